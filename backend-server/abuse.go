@@ -0,0 +1,266 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// abuseRedetectDelay keeps a dismissed flag from being raised again right away.
+const abuseRedetectDelay = 24 * time.Hour
+
+// AbuseFlag is a key that was used from more devices at once than plausible
+// for one person, waiting for an operator's review.
+type AbuseFlag struct {
+	ID       string `json:"id"`
+	UserID   string `json:"user_id"`
+	Email    string `json:"email"`
+	ServerID string `json:"server_id"`
+	// Devices is the highest number of simultaneous devices or IPs seen.
+	Devices int `json:"devices"`
+	// Status is "open", "dismissed" or "resolved".
+	Status     string     `json:"status"`
+	Resolution string     `json:"resolution,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// runAbuseChecks samples the connections of every key each interval until
+// the process exits. strikes counts consecutive samples over the limit per
+// user and server; a single burst, such as a phone switching networks, isn't
+// flagged.
+func (s *Server) runAbuseChecks(interval time.Duration) {
+	strikes := make(map[string]int)
+	for {
+		time.Sleep(interval)
+		s.checkAbuse(strikes)
+	}
+}
+
+func (s *Server) checkAbuse(strikes map[string]int) {
+	rows, err := s.DB.Query(`SELECT id, type, api_url, cert_sha256, server_host,
+		xray_inbound_id, xray_panel_url, xray_username, xray_password, xray_settings
+		FROM servers WHERE archived = 0`)
+	if err != nil {
+		log.Printf("[Abuse] Failed to list servers: %v", err)
+		return
+	}
+	var servers []serverRecord
+	for rows.Next() {
+		var rec serverRecord
+		if err := rows.Scan(&rec.ID, &rec.Type, &rec.APIURL, &rec.CertSHA256, &rec.ServerHost,
+			&rec.XrayInboundID, &rec.XrayPanelURL, &rec.XrayUsername, &rec.XrayPassword, &rec.XraySettings); err != nil {
+			log.Printf("[Abuse] Error scanning server row: %v", err)
+			continue
+		}
+		servers = append(servers, rec)
+	}
+	rows.Close()
+
+	for _, rec := range servers {
+		owners, err := s.keyOwners(rec.ID)
+		if err != nil {
+			log.Printf("[Abuse] Failed to list keys of server %s: %v", rec.ID, err)
+			continue
+		}
+		if len(owners) == 0 {
+			continue
+		}
+		counts, err := s.provider(rec).GetConnections()
+		if err != nil {
+			log.Printf("[Abuse] Failed to get connections of server %s: %v", rec.ID, err)
+			continue
+		}
+		for keyID, userID := range owners {
+			k := userID + "/" + rec.ID
+			devices := counts[keyID]
+			if devices <= s.Cfg.AbuseMaxDevices {
+				delete(strikes, k)
+				continue
+			}
+			strikes[k]++
+			if strikes[k] >= s.Cfg.AbuseStrikes {
+				s.flagAbuse(userID, rec.ID, devices)
+			}
+		}
+	}
+}
+
+// keyOwners maps the key IDs of a server to their users.
+func (s *Server) keyOwners(serverID string) (map[string]string, error) {
+	rows, err := s.DB.Query("SELECT key_id, user_id FROM access_keys WHERE server_id = ?", serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	owners := make(map[string]string)
+	for rows.Next() {
+		var keyID, userID string
+		if err := rows.Scan(&keyID, &userID); err != nil {
+			return nil, err
+		}
+		owners[keyID] = userID
+	}
+	return owners, rows.Err()
+}
+
+// flagAbuse opens a flag for the user's key on a server, or updates the open one.
+func (s *Server) flagAbuse(userID, serverID string, devices int) {
+	now := time.Now().UTC()
+	res, err := s.DB.Exec(`UPDATE abuse_flags SET devices = MAX(devices, ?), last_seen_at = ?
+		WHERE user_id = ? AND server_id = ? AND status = 'open'`, devices, now, userID, serverID)
+	if err != nil {
+		log.Printf("[Abuse] Failed to update flag of user %s: %v", userID, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return
+	}
+
+	var recent int
+	err = s.DB.QueryRow(`SELECT 1 FROM abuse_flags WHERE user_id = ? AND server_id = ? AND status = 'dismissed' AND resolved_at > ?`,
+		userID, serverID, now.Add(-abuseRedetectDelay)).Scan(&recent)
+	if err == nil {
+		return
+	}
+
+	id := uuid.New().String()
+	if _, err := s.DB.Exec(`INSERT INTO abuse_flags (id, user_id, server_id, devices, status, created_at, last_seen_at)
+		VALUES (?, ?, ?, ?, 'open', ?, ?)`, id, userID, serverID, devices, now, now); err != nil {
+		log.Printf("[Abuse] Failed to flag user %s: %v", userID, err)
+		return
+	}
+	log.Printf("[Abuse] Flagged user %s: key on server %s used from %d devices", userID, serverID, devices)
+	s.emitEvent(EventAbuseFlagged, map[string]interface{}{
+		"flag_id":   id,
+		"user_id":   userID,
+		"server_id": serverID,
+		"devices":   devices,
+	})
+}
+
+func (s *Server) handleAdminListAbuse(w http.ResponseWriter, r *http.Request) {
+	query := `SELECT f.id, f.user_id, COALESCE(u.email, ''), f.server_id, f.devices, f.status, f.resolution,
+		f.created_at, f.last_seen_at, f.resolved_at
+		FROM abuse_flags f LEFT JOIN users u ON u.id = f.user_id`
+	var args []interface{}
+	if status := r.URL.Query().Get("status"); status != "all" {
+		if status == "" {
+			status = "open"
+		}
+		query += " WHERE f.status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY f.last_seen_at DESC LIMIT 500"
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	flags := []AbuseFlag{}
+	for rows.Next() {
+		var f AbuseFlag
+		var resolvedAt sql.NullTime
+		if err := rows.Scan(&f.ID, &f.UserID, &f.Email, &f.ServerID, &f.Devices, &f.Status, &f.Resolution,
+			&f.CreatedAt, &f.LastSeenAt, &resolvedAt); err != nil {
+			log.Printf("Error scanning abuse flag row: %v", err)
+			continue
+		}
+		if resolvedAt.Valid {
+			f.ResolvedAt = &resolvedAt.Time
+		}
+		flags = append(flags, f)
+	}
+	json.NewEncoder(w).Encode(flags)
+}
+
+// handleAdminResolveAbuse closes a flag. Action "dismiss" marks it as a false
+// positive; "rotate" replaces the flagged key, so devices it was shared with
+// are cut off, and marks it resolved.
+func (s *Server) handleAdminResolveAbuse(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req struct {
+		Action string `json:"action"`
+		Note   string `json:"note"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Action != "dismiss" && req.Action != "rotate" {
+		http.Error(w, "Unknown action, use dismiss or rotate", 400)
+		return
+	}
+	if err := validateText("note", req.Note, maxTextLen); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	var userID, serverID, status string
+	if err := s.DB.QueryRow("SELECT user_id, server_id, status FROM abuse_flags WHERE id = ?", id).
+		Scan(&userID, &serverID, &status); err != nil {
+		http.Error(w, "Flag not found", 404)
+		return
+	}
+	if status != "open" {
+		http.Error(w, "Flag already "+status, 409)
+		return
+	}
+
+	newStatus := "dismissed"
+	if req.Action == "rotate" {
+		newStatus = "resolved"
+		rec, err := s.loadServerRecord(serverID)
+		if err != nil {
+			http.Error(w, "Server not found", 404)
+			return
+		}
+		if err := s.rotateServerKeys(userID, rec); err != nil {
+			http.Error(w, "Failed to rotate key: "+err.Error(), 502)
+			return
+		}
+	}
+
+	resolution := req.Action
+	if req.Note != "" {
+		resolution += ": " + req.Note
+	}
+	if _, err := s.DB.Exec("UPDATE abuse_flags SET status = ?, resolution = ?, resolved_at = ? WHERE id = ?",
+		newStatus, resolution, time.Now().UTC(), id); err != nil {
+		http.Error(w, "Database error: "+err.Error(), 500)
+		return
+	}
+	log.Printf("[Admin] Abuse flag %s of user %s %s (%s)", id, userID, newStatus, resolution)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": id})
+}
+
+// rotateServerKeys rotates the keys of all devices of the user on a server,
+// as the flag doesn't tell which one was shared.
+func (s *Server) rotateServerKeys(userID string, rec serverRecord) error {
+	rows, err := s.DB.Query("SELECT device_id FROM access_keys WHERE user_id = ? AND server_id = ?", userID, rec.ID)
+	if err != nil {
+		return err
+	}
+	var devices []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			devices = append(devices, id)
+		}
+	}
+	rows.Close()
+	for _, id := range devices {
+		if _, err := s.rotateKey(userID, id, rec, 0); err != nil && !errors.Is(err, errNoKey) {
+			return err
+		}
+	}
+	return nil
+}
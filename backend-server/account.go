@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// emailChangeTTL is how long an email confirmation link stays valid.
+const emailChangeTTL = 24 * time.Hour
+
+// Account is the profile of the authenticated user.
+type Account struct {
+	ID         string     `json:"id"`
+	Email      string     `json:"email"`
+	Plan       string     `json:"plan"`
+	ExpiryDate *time.Time `json:"expiry_date,omitempty"`
+	// PendingEmail is the address of an unconfirmed email change.
+	PendingEmail string `json:"pending_email,omitempty"`
+	// PausedUntil is set while the plan is paused, see pause.go.
+	PausedUntil *time.Time `json:"paused_until,omitempty"`
+}
+
+func (s *Server) handleGetAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+
+	var acc Account
+	var expiry, pausedUntil sql.NullTime
+	if err := s.DB.QueryRow("SELECT id, email, plan, expiry_date, paused_until FROM users WHERE id = ?", userID).
+		Scan(&acc.ID, &acc.Email, &acc.Plan, &expiry, &pausedUntil); err != nil {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	if expiry.Valid {
+		acc.ExpiryDate = &expiry.Time
+	}
+	if pausedUntil.Valid {
+		acc.PausedUntil = &pausedUntil.Time
+	}
+	s.DB.QueryRow(`SELECT new_email FROM email_changes
+		WHERE user_id = ? AND confirmed_at IS NULL AND expires_at > ? ORDER BY created_at DESC LIMIT 1`,
+		userID, time.Now().UTC()).Scan(&acc.PendingEmail)
+	json.NewEncoder(w).Encode(acc)
+}
+
+// handleChangeEmail starts an email change. The address is only changed once
+// the link sent to the new address is opened; until then the user keeps
+// logging in with the old one. Access keys are tied to the user ID and are
+// unaffected.
+func (s *Server) handleChangeEmail(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	newEmail := strings.TrimSpace(req.Email)
+	if err := validateEmail(newEmail); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	var email, pwd string
+	if err := s.DB.QueryRow("SELECT email, password FROM users WHERE id = ?", userID).Scan(&email, &pwd); err != nil {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	if pwd != req.Password {
+		http.Error(w, "Invalid password", 403)
+		return
+	}
+	if strings.EqualFold(newEmail, email) {
+		http.Error(w, "Email unchanged", 400)
+		return
+	}
+	var taken int
+	if err := s.DB.QueryRow("SELECT 1 FROM users WHERE email = ?", newEmail).Scan(&taken); err == nil {
+		http.Error(w, "Email already in use", 409)
+		return
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	token := hex.EncodeToString(buf)
+	now := time.Now().UTC()
+	// Only the latest request can be confirmed.
+	if _, err := s.DB.Exec("DELETE FROM email_changes WHERE user_id = ? AND confirmed_at IS NULL", userID); err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	if _, err := s.DB.Exec("INSERT INTO email_changes (token_hash, user_id, new_email, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		hashToken(token), userID, newEmail, now, now.Add(emailChangeTTL)); err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+
+	link := s.publicURL(r) + "/account/email/confirm?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Someone, hopefully you, asked to change the email of your Dr. Frake VPN account from %s to this address.\n\n"+
+		"Open this link within 24 hours to confirm:\n%s\n\nIf this wasn't you, ignore this email.\n", email, link)
+	if err := s.Mailer.Send(newEmail, "Confirm your new email address", body); err != nil {
+		log.Printf("[Account] Failed to send confirmation to %s: %v", newEmail, err)
+		http.Error(w, "Failed to send confirmation email", 502)
+		return
+	}
+	log.Printf("[Account] User %s requested email change to %s", userID, newEmail)
+	json.NewEncoder(w).Encode(map[string]string{"status": "pending", "pending_email": newEmail})
+}
+
+// handleConfirmEmail completes an email change. It is opened from the email,
+// so it answers with plain text rather than JSON.
+func (s *Server) handleConfirmEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "Missing token", 400)
+		return
+	}
+
+	var userID, newEmail string
+	var expiresAt time.Time
+	err := s.DB.QueryRow("SELECT user_id, new_email, expires_at FROM email_changes WHERE token_hash = ? AND confirmed_at IS NULL",
+		hashToken(token)).Scan(&userID, &newEmail, &expiresAt)
+	if err != nil || time.Now().After(expiresAt) {
+		http.Error(w, "This link is invalid or has expired.", 404)
+		return
+	}
+
+	var oldEmail string
+	if err := s.DB.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&oldEmail); err != nil {
+		http.Error(w, "This link is invalid or has expired.", 404)
+		return
+	}
+	// The UNIQUE constraint rejects the change if the address was taken meanwhile.
+	if _, err := s.DB.Exec("UPDATE users SET email = ? WHERE id = ?", newEmail, userID); err != nil {
+		http.Error(w, "This email address is already in use.", 409)
+		return
+	}
+	s.DB.Exec("UPDATE email_changes SET confirmed_at = ? WHERE token_hash = ?", time.Now().UTC(), hashToken(token))
+	log.Printf("[Account] User %s changed email from %s to %s", userID, oldEmail, newEmail)
+	s.emitEvent(EventUserEmailChanged, map[string]string{"user_id": userID, "old_email": oldEmail, "email": newEmail})
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "Your email address was changed to %s. Use it to log in from now on.\n", newEmail)
+}
+
+// publicURL is the base URL of the backend used in links sent to users.
+func (s *Server) publicURL(r *http.Request) string {
+	if s.Cfg.PublicURL != "" {
+		return strings.TrimSuffix(s.Cfg.PublicURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
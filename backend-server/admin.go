@@ -0,0 +1,553 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"drfrake-core"
+)
+
+// minAdminTokenLen is the length of the shortest admin token the backend
+// starts with.
+const minAdminTokenLen = 32
+
+// requireAdmin protects admin endpoints with the configured admin token,
+// passed as "Authorization: Bearer <token>" or, for the web dashboard, as a
+// cookie. Without a configured token the admin API is disabled, unless
+// AdminLocalhost opens it to localhost.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdmin(r) {
+			if s.Cfg.AdminToken == "" {
+				http.Error(w, "Admin API disabled: set ADMIN_TOKEN", 403)
+			} else {
+				http.Error(w, "Unauthorized", 401)
+			}
+			return
+		}
+		next(w, r)
+	}
+}
+
+// isAdmin reports whether the request carries valid admin credentials.
+func (s *Server) isAdmin(r *http.Request) bool {
+	if s.Cfg.AdminToken == "" {
+		if !s.Cfg.AdminLocalhost {
+			return false
+		}
+		// Requests a reverse proxy on the same host forwards come from
+		// localhost too.
+		if r.Header.Get("X-Forwarded-For") != "" {
+			return false
+		}
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		if c, err := r.Cookie(adminCookieName); err == nil {
+			token = c.Value
+		}
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.Cfg.AdminToken)) == 1
+}
+
+type AdminServer struct {
+	ID            string `json:"id"`
+	Country       string `json:"country"`
+	City          string `json:"city"`
+	Flag          string `json:"flag"`
+	IsPremium     bool   `json:"is_premium"`
+	Type          string `json:"type"`
+	APIURL        string `json:"api_url,omitempty"`
+	ServerHost    string `json:"server_host,omitempty"`
+	XrayPanelURL  string `json:"xray_panel_url,omitempty"`
+	XrayInboundID int    `json:"xray_inbound_id,omitempty"`
+	// Unpinned is set for Outline servers added without cert_sha256, whose
+	// management API is reached without checking its certificate.
+	Unpinned bool `json:"unpinned,omitempty"`
+	Keys     int  `json:"keys"`
+	// Users is the number of users with keys on the server, which counts
+	// against MaxUsers.
+	Users int `json:"users"`
+	// MaxUsers is the number of users the server takes, 0 = unlimited.
+	MaxUsers int      `json:"max_users"`
+	Tags     []string `json:"tags"`
+	// Result of the last health check.
+	Healthy         bool       `json:"healthy"`
+	LatencyMs       int64      `json:"latency_ms"`
+	HealthError     string     `json:"health_error,omitempty"`
+	HealthCheckedAt *time.Time `json:"health_checked_at,omitempty"`
+	// Archived servers are out of rotation but keep their access keys.
+	Archived      bool       `json:"archived"`
+	ArchivedAt    *time.Time `json:"archived_at,omitempty"`
+	ArchiveReason string     `json:"archive_reason,omitempty"`
+}
+
+func (s *Server) handleAdminListServers(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.DB.Query(`SELECT s.id, s.country, s.city, s.flag, s.is_premium, s.type, s.api_url,
+		s.server_host, s.xray_panel_url, s.xray_inbound_id, COALESCE(s.cert_sha256, '') = '',
+		(SELECT COUNT(*) FROM access_keys k WHERE k.server_id = s.id),
+		(SELECT COUNT(DISTINCT k.user_id) FROM access_keys k WHERE k.server_id = s.id), s.max_users,
+		s.health_ok, s.health_latency_ms, s.health_error, s.health_checked_at,
+		s.archived, s.archived_at, s.archive_reason, s.tags
+		FROM servers s ORDER BY s.archived, s.country, s.city`)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	servers := []AdminServer{}
+	for rows.Next() {
+		var srv AdminServer
+		var checkedAt, archivedAt sql.NullTime
+		var tags string
+		var noCert bool
+		if err := rows.Scan(&srv.ID, &srv.Country, &srv.City, &srv.Flag, &srv.IsPremium, &srv.Type, &srv.APIURL,
+			&srv.ServerHost, &srv.XrayPanelURL, &srv.XrayInboundID, &noCert, &srv.Keys, &srv.Users, &srv.MaxUsers,
+			&srv.Healthy, &srv.LatencyMs, &srv.HealthError, &checkedAt,
+			&srv.Archived, &archivedAt, &srv.ArchiveReason, &tags); err != nil {
+			log.Printf("Error scanning server row: %v", err)
+			continue
+		}
+		srv.Tags = parseTags(tags)
+		srv.Unpinned = noCert && ServerType(srv.Type) == ServerTypeOutline
+		if checkedAt.Valid {
+			srv.HealthCheckedAt = &checkedAt.Time
+		}
+		if archivedAt.Valid {
+			srv.ArchivedAt = &archivedAt.Time
+		}
+		servers = append(servers, srv)
+	}
+	json.NewEncoder(w).Encode(servers)
+}
+
+// warnUnpinnedServers logs the Outline servers added without cert_sha256,
+// see AdminServer.Unpinned.
+func (s *Server) warnUnpinnedServers() {
+	rows, err := s.DB.Query("SELECT id, api_url FROM servers WHERE type = 'outline' AND COALESCE(cert_sha256, '') = ''")
+	if err != nil {
+		log.Printf("Error listing unpinned servers: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, apiURL string
+		if err := rows.Scan(&id, &apiURL); err != nil {
+			continue
+		}
+		log.Printf("Warning: Outline server %s (%s) has no cert_sha256, so its certificate isn't checked; add it again with the fingerprint", id, apiURL)
+	}
+}
+
+// handleAdminValidateConfig checks an access config, such as one to import,
+// as the clients would before connecting, and describes it. Errors name the
+// field at fault, never its value.
+func (s *Server) handleAdminValidateConfig(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Config string `json:"config"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	info, err := core.ValidateConfig(req.Config)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	json.NewEncoder(w).Encode(info)
+}
+
+// handleAdminDeleteServer removes a server and its stored access keys. Keys on
+// the VPN server itself are left alone, as it may already be unreachable.
+func (s *Server) handleAdminDeleteServer(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	res, err := s.DB.Exec("DELETE FROM servers WHERE id = ?", id)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Server not found", 404)
+		return
+	}
+	s.DB.Exec("DELETE FROM access_keys WHERE server_id = ?", id)
+	log.Printf("[Admin] Deleted server %s", id)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": id})
+}
+
+// handleAdminArchiveServer takes a server out of rotation, e.g. for
+// maintenance or after abuse reports, without deleting its access keys. Users
+// stop receiving it from /servers until it is restored.
+func (s *Server) handleAdminArchiveServer(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 && !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := validateText("reason", req.Reason, maxTextLen); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	res, err := s.DB.Exec("UPDATE servers SET archived = 1, archived_at = ?, archive_reason = ? WHERE id = ?",
+		time.Now().UTC(), req.Reason, id)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Server not found", 404)
+		return
+	}
+	log.Printf("[Admin] Archived server %s: %s", id, req.Reason)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": id})
+}
+
+// handleAdminRestoreServer puts an archived server back into rotation.
+func (s *Server) handleAdminRestoreServer(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	res, err := s.DB.Exec("UPDATE servers SET archived = 0, archived_at = NULL, archive_reason = '' WHERE id = ?", id)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Server not found", 404)
+		return
+	}
+	log.Printf("[Admin] Restored server %s", id)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": id})
+}
+
+type AdminUser struct {
+	ID         string     `json:"id"`
+	Email      string     `json:"email"`
+	Plan       string     `json:"plan"`
+	ExpiryDate *time.Time `json:"expiry_date,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (s *Server) handleAdminListUsers(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.DB.Query("SELECT id, email, plan, expiry_date, created_at FROM users ORDER BY created_at DESC")
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	users := []AdminUser{}
+	for rows.Next() {
+		var u AdminUser
+		var expiry sql.NullTime
+		if err := rows.Scan(&u.ID, &u.Email, &u.Plan, &expiry, &u.CreatedAt); err != nil {
+			log.Printf("Error scanning user row: %v", err)
+			continue
+		}
+		if expiry.Valid {
+			u.ExpiryDate = &expiry.Time
+		}
+		users = append(users, u)
+	}
+	json.NewEncoder(w).Encode(users)
+}
+
+// handleAdminSetPlan grants a plan to a user, e.g. for support or promotions.
+// Days sets the expiry; 0 means the plan doesn't expire.
+func (s *Server) handleAdminSetPlan(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req struct {
+		Plan string `json:"plan"`
+		Days int    `json:"days"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := s.validatePlan(req.Plan); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if req.Days < 0 || req.Days > 3650 {
+		http.Error(w, "days must be between 0 and 3650", 400)
+		return
+	}
+
+	var expiry interface{}
+	if req.Days > 0 {
+		expiry = time.Now().AddDate(0, 0, req.Days).UTC()
+	}
+	res, err := s.DB.Exec("UPDATE users SET plan = ?, expiry_date = ? WHERE id = ?", req.Plan, expiry, id)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "User not found", 404)
+		return
+	}
+	log.Printf("[Admin] Set plan of user %s to %s (%d days)", id, req.Plan, req.Days)
+	go s.applyPlanLimits(id)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": id, "plan": req.Plan})
+}
+
+// handleAdminRevokeSessions logs a user out of every device, e.g. after an
+// account compromise.
+func (s *Server) handleAdminRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var exists int
+	if err := s.DB.QueryRow("SELECT 1 FROM users WHERE id = ?", id).Scan(&exists); err != nil {
+		http.Error(w, "User not found", 404)
+		return
+	}
+	n, err := s.revokeSessions(id)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), 500)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "id": id, "revoked": n})
+}
+
+var errUserHasFamily = errors.New("user has family members")
+
+// deleteUser deletes the user's keys on the VPN servers and the user with
+// everything tied to them, except their payments, which are kept for the
+// books. Keys of servers that can't be reached stay, so deleting again
+// retries them, and so does the user until they are all gone. Family owners
+// must remove their members first.
+func (s *Server) deleteUser(userID string) error {
+	unlock := s.lockUser(userID)
+	defer unlock()
+
+	var members int
+	if err := s.DB.QueryRow("SELECT COUNT(*) FROM users WHERE family_owner_id = ?", userID).Scan(&members); err != nil {
+		return err
+	}
+	if members > 0 {
+		return errUserHasFamily
+	}
+
+	rows, err := s.DB.Query("SELECT server_id, key_id FROM access_keys WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+	type userKey struct{ serverID, keyID string }
+	var keys []userKey
+	for rows.Next() {
+		var k userKey
+		if err := rows.Scan(&k.serverID, &k.keyID); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, k)
+	}
+	rows.Close()
+
+	var errs []error
+	for _, k := range keys {
+		rec, err := s.loadServerRecord(k.serverID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := s.provider(rec).DeleteKey(k.keyID); err != nil {
+			errs = append(errs, fmt.Errorf("%w: deleting key %s on server %s: %v", errVPNUnavailable, k.keyID, rec.ID, err))
+			continue
+		}
+		if _, err := s.DB.Exec("DELETE FROM access_keys WHERE user_id = ? AND server_id = ? AND key_id = ?", userID, rec.ID, k.keyID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, query := range []string{
+		"DELETE FROM sessions WHERE user_id = ?",
+		"DELETE FROM email_changes WHERE user_id = ?",
+		"DELETE FROM devices WHERE user_id = ?",
+		"DELETE FROM revoked_key_usage WHERE user_id = ?",
+		"DELETE FROM subscription_pauses WHERE user_id = ?",
+		"DELETE FROM family_invites WHERE owner_id = ?",
+		"DELETE FROM telegram_links WHERE user_id = ?",
+		"DELETE FROM telegram_link_codes WHERE user_id = ?",
+		"DELETE FROM users WHERE id = ?",
+	} {
+		if _, err := tx.Exec(query, userID); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("[Admin] Deleted user %s and their %d keys", userID, len(keys))
+	return nil
+}
+
+func (s *Server) handleAdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var exists int
+	if err := s.DB.QueryRow("SELECT 1 FROM users WHERE id = ?", id).Scan(&exists); err != nil {
+		http.Error(w, "User not found", 404)
+		return
+	}
+	err := s.deleteUser(id)
+	switch {
+	case errors.Is(err, errUserHasFamily):
+		http.Error(w, "Remove the user's family members first", 409)
+		return
+	case errors.Is(err, errVPNUnavailable):
+		log.Printf("[Admin] Failed to delete user %s: %v", id, err)
+		http.Error(w, "VPN server unavailable, try again later", 502)
+		return
+	case err != nil:
+		log.Printf("[Admin] Failed to delete user %s: %v", id, err)
+		http.Error(w, "Database error", 500)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": id})
+}
+
+type AdminPayment struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Amount    float64   `json:"amount"`
+	Status    string    `json:"status"`
+	Region    string    `json:"region"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s *Server) handleAdminListPayments(w http.ResponseWriter, r *http.Request) {
+	query := "SELECT yookassa_id, user_id, amount, status, region, created_at FROM payments WHERE 1 = 1"
+	var args []interface{}
+	if userID := r.URL.Query().Get("user_id"); userID != "" {
+		query += " AND user_id = ?"
+		args = append(args, userID)
+	}
+	if region, ok := r.URL.Query()["region"]; ok {
+		// An empty region lists the payments at the default price.
+		query += " AND region = ?"
+		args = append(args, region[0])
+	}
+	query += " ORDER BY created_at DESC LIMIT 500"
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	payments := []AdminPayment{}
+	for rows.Next() {
+		var p AdminPayment
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Amount, &p.Status, &p.Region, &p.CreatedAt); err != nil {
+			log.Printf("Error scanning payment row: %v", err)
+			continue
+		}
+		payments = append(payments, p)
+	}
+	json.NewEncoder(w).Encode(payments)
+}
+
+// handleAdminRefund refunds a succeeded payment, in full unless an amount is
+// given, and downgrades the user to the free plan.
+func (s *Server) handleAdminRefund(w http.ResponseWriter, r *http.Request) {
+	paymentID := r.PathValue("id")
+	var req struct {
+		Amount string `json:"amount"`
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 && !decodeJSON(w, r, &req) { // Body is optional
+		return
+	}
+	if req.Amount != "" {
+		if v, err := strconv.ParseFloat(req.Amount, 64); err != nil || v <= 0 {
+			http.Error(w, "amount must be a positive number, e.g. 299.00", 400)
+			return
+		}
+	}
+	if err := validateText("reason", req.Reason, maxTextLen); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	var userID, status string
+	var amount float64
+	err := s.DB.QueryRow("SELECT user_id, amount, status FROM payments WHERE yookassa_id = ?", paymentID).
+		Scan(&userID, &amount, &status)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Payment not found", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	if status != "succeeded" {
+		http.Error(w, "Only succeeded payments can be refunded (status: "+status+")", 409)
+		return
+	}
+	if req.Amount == "" {
+		req.Amount = formatAmount(amount)
+	}
+
+	refund, err := s.Payments.CreateRefund(paymentID, req.Amount, req.Reason)
+	if err != nil {
+		http.Error(w, "Refund error: "+err.Error(), 502)
+		return
+	}
+
+	if _, err := s.DB.Exec("UPDATE payments SET status = 'refunded' WHERE yookassa_id = ?", paymentID); err != nil {
+		log.Printf("[Admin] Refund %s of payment %s not recorded: %v", refund.ID, paymentID, err)
+		http.Error(w, "Database error", 500)
+		return
+	}
+	s.DB.Exec("UPDATE users SET plan = 'free', expiry_date = NULL WHERE id = ?", userID)
+	log.Printf("[Admin] Refunded payment %s (%s RUB) of user %s", paymentID, req.Amount, userID)
+	go s.applyPlanLimits(userID)
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":    "ok",
+		"refund_id": refund.ID,
+		"amount":    req.Amount,
+	})
+}
+
+func (s *Server) handleAdminReconcile(w http.ResponseWriter, r *http.Request) {
+	result, err := s.reconcilePayments()
+	if err != nil {
+		http.Error(w, "Reconcile error: "+err.Error(), 500)
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleAdminReconcileReport returns the result of the latest reconciliation,
+// scheduled or manual.
+func (s *Server) handleAdminReconcileReport(w http.ResponseWriter, r *http.Request) {
+	result := s.lastReconcileResult()
+	if result == nil {
+		http.Error(w, "No reconciliation has run yet", 404)
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
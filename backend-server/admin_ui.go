@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/subtle"
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed admin_ui
+var adminUIFiles embed.FS
+
+// adminCookieName holds the admin token for the web dashboard, which can't
+// send an Authorization header on page loads.
+const adminCookieName = "drfrake_admin"
+
+// adminUIHandler serves the embedded dashboard under /admin/ui/.
+func (s *Server) adminUIHandler() http.Handler {
+	static, _ := fs.Sub(adminUIFiles, "admin_ui")
+	files := http.StripPrefix("/admin/ui/", http.FileServer(http.FS(static)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/admin/ui/login" {
+			s.handleAdminUILogin(w, r, static)
+			return
+		}
+		if !s.isAdmin(r) {
+			http.Redirect(w, r, "/admin/ui/login", http.StatusSeeOther)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		files.ServeHTTP(w, r)
+	})
+}
+
+// handleAdminUILogin shows the token form and, on POST, stores the token in
+// an HttpOnly cookie if it is valid.
+func (s *Server) handleAdminUILogin(w http.ResponseWriter, r *http.Request, static fs.FS) {
+	if r.Method != "POST" {
+		http.ServeFileFS(w, r, static, "login.html")
+		return
+	}
+
+	token := r.FormValue("token")
+	if s.Cfg.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.Cfg.AdminToken)) != 1 {
+		http.Redirect(w, r, "/admin/ui/login?error=1", http.StatusSeeOther)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     adminCookieName,
+		Value:    token,
+		Path:     "/admin",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/admin/ui/", http.StatusSeeOther)
+}
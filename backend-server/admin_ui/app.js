@@ -0,0 +1,255 @@
+"use strict";
+
+// The admin token is sent as a cookie, set by the login page.
+async function api(method, path, body) {
+  const opts = { method, headers: {} };
+  if (body !== undefined) {
+    opts.headers["Content-Type"] = "application/json";
+    opts.body = JSON.stringify(body);
+  }
+  const resp = await fetch(path, opts);
+  if (resp.status === 401 || resp.status === 403) {
+    location.href = "/admin/ui/login";
+    throw new Error("unauthorized");
+  }
+  const text = await resp.text();
+  if (!resp.ok) throw new Error(text.trim() || resp.statusText);
+  return text ? JSON.parse(text) : null;
+}
+
+function row(cells, actions) {
+  const tr = document.createElement("tr");
+  for (const c of cells) {
+    const td = document.createElement("td");
+    td.textContent = c === null || c === undefined ? "-" : String(c);
+    tr.appendChild(td);
+  }
+  const td = document.createElement("td");
+  for (const [label, fn] of actions || []) {
+    const b = document.createElement("button");
+    b.textContent = label;
+    b.onclick = () => fn().then(refresh).catch((e) => alert(e.message));
+    td.appendChild(b);
+  }
+  tr.appendChild(td);
+  return tr;
+}
+
+function fill(section, rows) {
+  const tbody = document.querySelector(`#${section} tbody`);
+  tbody.replaceChildren(...rows);
+}
+
+const date = (s) => (s ? new Date(s).toLocaleString() : null);
+
+// splitTags parses comma-separated tags as typed; the backend normalizes them.
+const splitTags = (s) => s.split(",").map((t) => t.trim()).filter(Boolean);
+
+function health(s) {
+  const status = healthStatus(s);
+  return s.unpinned ? `${status}; certificate not pinned` : status;
+}
+
+function healthStatus(s) {
+  if (s.archived) return s.archive_reason ? `archived: ${s.archive_reason}` : "archived";
+  if (!s.health_checked_at) return "not checked";
+  if (!s.healthy) return `down: ${s.health_error}`;
+  return s.latency_ms ? `up, ${s.latency_ms} ms` : "up";
+}
+
+const loaders = {
+  async servers() {
+    const servers = await api("GET", "/admin/servers");
+    fill("servers", servers.map((s) => row(
+      [`${s.flag} ${s.city}, ${s.country}`, s.type, s.is_premium ? "yes" : "no", s.max_users ? `${s.users}/${s.max_users}` : s.users, s.tags.join(", "), health(s), s.id],
+      [
+        ["Capacity", () => {
+          const max = prompt(`Users server ${s.id} takes (0 = unlimited):`, s.max_users);
+          return max === null ? Promise.resolve() : api("PUT", `/admin/servers/${s.id}/capacity`, { max_users: Number(max) });
+        }],
+        ["Tags", () => {
+          const tags = prompt(`Tags of server ${s.id}, comma-separated:`, s.tags.join(", "));
+          return tags === null ? Promise.resolve() : api("PUT", `/admin/servers/${s.id}/tags`, { tags: splitTags(tags) });
+        }],
+        ...(s.type === "xray" ? [["Sync", () => api("POST", `/admin/servers/${s.id}/sync`)]] : []),
+        s.archived
+          ? ["Restore", () => api("POST", `/admin/servers/${s.id}/restore`)]
+          : ["Archive", () => {
+              const reason = prompt(`Archive server ${s.id}? Reason (optional):`);
+              return reason === null ? Promise.resolve() : api("POST", `/admin/servers/${s.id}/archive`, { reason });
+            }],
+        ["Delete", () => confirm(`Delete server ${s.id}?`) ? api("DELETE", `/admin/servers/${s.id}`) : Promise.resolve()],
+      ])));
+  },
+  async users() {
+    const users = await api("GET", "/admin/users");
+    fill("users", users.map((u) => row(
+      [u.email, u.plan, date(u.expiry_date), date(u.created_at), u.id],
+      [["Grant 30 days", () => api("POST", `/admin/users/${u.id}/plan`, { plan: "monthly", days: 30 })]])));
+  },
+  async payments() {
+    const payments = await api("GET", "/admin/payments");
+    fill("payments", payments.map((p) => row(
+      [date(p.created_at), p.user_id, p.amount.toFixed(2), p.region || "default", p.status, p.id],
+      p.status === "succeeded"
+        ? [["Refund", () => confirm(`Refund ${p.amount.toFixed(2)} RUB?`) ? api("POST", `/admin/payments/${p.id}/refund`, {}) : Promise.resolve()]]
+        : [])));
+  },
+  async prices() {
+    const [prices, revenue] = await Promise.all([api("GET", "/admin/prices"), api("GET", "/admin/payments/regions")]);
+    fill("prices", prices.map((p) => row(
+      [p.plan, p.country, p.amount, date(p.updated_at)],
+      [["Remove", () => confirm(`Remove the ${p.country} price of ${p.plan}?`)
+        ? api("DELETE", `/admin/prices/${p.plan}/${p.country}`) : Promise.resolve()]])));
+    document.querySelector("#region-revenue tbody").replaceChildren(...revenue.map((r) =>
+      row([r.region || "default", r.payments, r.amount.toFixed(2)])));
+  },
+  async health() {
+    const breakers = await api("GET", "/admin/breakers");
+    fill("health", breakers.map((b) => row(
+      [b.server_id, b.state, b.failures, b.last_error],
+      b.state !== "closed" ? [["Reset", () => api("POST", `/admin/servers/${b.server_id}/breaker/reset`)]] : [])));
+  },
+  async abuse() {
+    const flags = await api("GET", "/admin/abuse");
+    fill("abuse", flags.map((f) => row(
+      [date(f.last_seen_at), f.email || f.user_id, f.server_id, f.devices, date(f.created_at)],
+      [
+        ["Dismiss", () => api("POST", `/admin/abuse/${f.id}/resolve`, { action: "dismiss" })],
+        ["Rotate key", () => confirm(`Rotate the key of ${f.email || f.user_id}? Their devices must fetch the new key.`)
+          ? api("POST", `/admin/abuse/${f.id}/resolve`, { action: "rotate" }) : Promise.resolve()],
+      ])));
+  },
+  async codes() {
+    const codes = await api("GET", "/admin/codes");
+    fill("codes", codes.map((c) => row(
+      [c.code, c.plan, c.days, c.note, date(c.created_at), c.redeemed_by, date(c.redeemed_at)],
+      c.redeemed_by ? [] : [["Revoke", () => confirm(`Revoke code ${c.code}?`) ? api("DELETE", `/admin/codes/${c.code}`) : Promise.resolve()]])));
+  },
+  async flags() {
+    const flags = await api("GET", "/admin/flags");
+    fill("flags", flags.map((f) => row(
+      [f.name, f.enabled ? "yes" : "no", `${f.rollout}%`, f.message, date(f.updated_at)],
+      [
+        f.enabled
+          ? ["Disable", () => api("PUT", `/admin/flags/${f.name}`, { enabled: false, message: f.message })]
+          : ["Enable", () => {
+              const message = f.name === "maintenance" ? prompt("Message shown to users (optional):", f.message || "") : f.message;
+              return message === null ? Promise.resolve() : api("PUT", `/admin/flags/${f.name}`, { enabled: true, message });
+            }],
+        ["Rollout", () => {
+          const pct = prompt(`Rollout percentage for ${f.name}:`, f.rollout);
+          return pct === null ? Promise.resolve() : api("PUT", `/admin/flags/${f.name}`, { enabled: f.enabled, rollout: Number(pct), message: f.message });
+        }],
+        ...(f.updated_at ? [["Reset", () => api("DELETE", `/admin/flags/${f.name}`)]] : []),
+      ])));
+  },
+};
+
+let current = "servers";
+
+function refresh() {
+  return loaders[current]().catch((e) => console.error(e));
+}
+
+document.querySelectorAll("nav button").forEach((btn) => {
+  btn.onclick = () => {
+    current = btn.dataset.tab;
+    document.querySelectorAll("nav button").forEach((b) => b.classList.toggle("active", b === btn));
+    document.querySelectorAll("main section").forEach((s) => (s.hidden = s.id !== current));
+    refresh();
+  };
+});
+
+document.getElementById("add-server").onsubmit = (ev) => {
+  ev.preventDefault();
+  const form = new FormData(ev.target);
+  const body = Object.fromEntries(form.entries());
+  body.is_premium = form.has("is_premium");
+  body.xray_inbound_id = Number(body.xray_inbound_id || 0);
+  body.max_users = Number(body.max_users || 0);
+  body.tags = splitTags(body.tags);
+  api("POST", "/admin/add-server", body)
+    .then(() => { ev.target.reset(); refresh(); })
+    .catch((e) => alert(e.message));
+};
+
+// Imports are all or nothing; the results list the errors either way.
+document.getElementById("import-servers").onsubmit = async (ev) => {
+  ev.preventDefault();
+  const form = new FormData(ev.target);
+  const file = form.get("file");
+  const resp = await fetch(`/admin/servers/import?dry_run=${form.has("dry_run")}`, {
+    method: "POST",
+    headers: { "Content-Type": file.name.endsWith(".csv") ? "text/csv" : "application/json" },
+    body: await file.text(),
+  });
+  const text = await resp.text();
+  const out = document.getElementById("import-result");
+  let lines = [text.trim()];
+  try {
+    const r = JSON.parse(text);
+    lines = r.results.map((x) => `${x.row}: ${x.error ? `error: ${x.error}` : `${x.type} ${x.city || ""} ${x.id || ""}`}`);
+    lines.unshift(r.dry_run ? `Dry run, ${r.errors} errors` : `Imported ${r.imported}, ${r.errors} errors`);
+  } catch (e) {
+    // Plain text error
+  }
+  out.value = lines.join("\n");
+  out.hidden = false;
+  refresh();
+};
+
+document.getElementById("generate-codes").onsubmit = (ev) => {
+  ev.preventDefault();
+  const body = Object.fromEntries(new FormData(ev.target).entries());
+  for (const k of ["count", "days", "valid_days"]) body[k] = Number(body[k] || 0);
+  api("POST", "/admin/codes", body)
+    .then((r) => {
+      const out = document.getElementById("generated-codes");
+      out.value = r.codes.join("\n");
+      out.hidden = false;
+      refresh();
+    })
+    .catch((e) => alert(e.message));
+};
+
+document.getElementById("set-price").onsubmit = (ev) => {
+  ev.preventDefault();
+  const body = Object.fromEntries(new FormData(ev.target).entries());
+  api("PUT", `/admin/prices/${body.plan}/${body.country.toUpperCase()}`, { amount: body.amount })
+    .then(() => { ev.target.reset(); refresh(); })
+    .catch((e) => alert(e.message));
+};
+
+document.getElementById("reconcile").onclick = () => {
+  api("POST", "/admin/reconcile")
+    .then((r) => {
+      document.getElementById("reconcile-result").textContent =
+        `checked ${r.checked}, succeeded ${r.succeeded}, canceled ${r.canceled}, ` +
+        `healed ${r.healed}, mismatches ${(r.mismatches || []).length}`;
+      refresh();
+    })
+    .catch((e) => alert(e.message));
+};
+
+document.getElementById("audit-keys").onclick = () => {
+  const dryRun = document.getElementById("audit-dry-run").checked;
+  api("POST", "/admin/keys/audit" + (dryRun ? "?dry_run=true" : ""))
+    .then((r) => {
+      document.getElementById("audit-result").textContent =
+        `${r.keys} keys on ${r.servers} servers: recreated ${r.recreated}, imported ${r.imported}, ` +
+        `updated ${r.updated}, errors ${(r.errors || []).length}`;
+      const out = document.getElementById("audit-discrepancies");
+      const lines = (r.discrepancies || []).map(
+        (d) => `${d.repaired ? "repaired" : "open"}\t${d.server_id}\t${d.key_id}\t${d.user_id || ""}\t${d.problem}`,
+      );
+      out.value = lines.concat(r.errors || []).join("\n");
+      out.hidden = out.value === "";
+      refresh();
+    })
+    .catch((e) => alert(e.message));
+};
+
+refresh();
+// Keep the health view live.
+setInterval(() => { if (current === "health") refresh(); }, 10000);
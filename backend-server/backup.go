@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupPrefix and backupSuffix frame the names of backup files, so that
+// scheduled backups can be told apart from other files when pruning.
+const (
+	backupPrefix = "drfrake-"
+	backupSuffix = ".db"
+)
+
+func backupName(t time.Time) string {
+	return backupPrefix + t.UTC().Format("20060102-150405") + backupSuffix
+}
+
+// snapshot writes a consistent copy of the database to path. VACUUM INTO runs
+// in a read transaction, so the server keeps serving requests meanwhile.
+func (s *Server) snapshot(path string) error {
+	// VACUUM INTO refuses to overwrite an existing file.
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if _, err := s.DB.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("snapshot failed: %w", err)
+	}
+	return nil
+}
+
+// handleAdminBackup streams a fresh snapshot of the database. Restore it with
+// "drfrake-admin restore".
+func (s *Server) handleAdminBackup(w http.ResponseWriter, r *http.Request) {
+	name := backupName(time.Now())
+	path := filepath.Join(os.TempDir(), name)
+	if err := s.snapshot(path); err != nil {
+		http.Error(w, "Backup error: "+err.Error(), 500)
+		return
+	}
+	defer os.Remove(path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		http.Error(w, "Backup error: "+err.Error(), 500)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	if info, err := f.Stat(); err == nil {
+		w.Header().Set("Content-Length", fmt.Sprint(info.Size()))
+	}
+	io.Copy(w, f)
+	log.Printf("[Backup] Served backup %s to admin", name)
+}
+
+// runBackups takes a backup every interval until the process exits. Backups go
+// to the configured directory, the S3 bucket, or both.
+func (s *Server) runBackups(interval time.Duration) {
+	log.Printf("[Backup] Scheduled every %s", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := s.backupOnce(time.Now()); err != nil {
+			log.Printf("[Backup] Failed: %v", err)
+		}
+	}
+}
+
+func (s *Server) backupOnce(now time.Time) error {
+	name := backupName(now)
+
+	dir := s.Cfg.BackupDir
+	keepLocal := dir != ""
+	if !keepLocal {
+		dir = os.TempDir()
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, name)
+	if err := s.snapshot(path); err != nil {
+		return err
+	}
+	if !keepLocal {
+		defer os.Remove(path)
+	} else {
+		log.Printf("[Backup] Wrote %s", path)
+		if err := pruneBackups(dir, s.Cfg.BackupKeep); err != nil {
+			log.Printf("[Backup] Pruning %s failed: %v", dir, err)
+		}
+	}
+
+	if s.Cfg.BackupS3Bucket != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		uploader := NewS3Uploader(s.Cfg.BackupS3Endpoint, s.Cfg.BackupS3Region, s.Cfg.BackupS3Bucket,
+			s.Cfg.BackupS3AccessKey, s.Cfg.BackupS3SecretKey)
+		if err := uploader.Put(name, data); err != nil {
+			return err
+		}
+		log.Printf("[Backup] Uploaded %s to bucket %s", name, s.Cfg.BackupS3Bucket)
+	}
+	return nil
+}
+
+// pruneBackups deletes all but the newest keep backups in dir. Retention of
+// S3 backups is left to the bucket's lifecycle rules.
+func pruneBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), backupPrefix) && strings.HasSuffix(e.Name(), backupSuffix) {
+			names = append(names, e.Name())
+		}
+	}
+	// Names embed the timestamp, so they sort chronologically.
+	sort.Strings(names)
+	for len(names) > keep {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Billing states of a plan.
+const (
+	BillingFree    = "free"
+	BillingActive  = "active"
+	BillingPaused  = "paused"  // Active, keys disabled until PausedUntil
+	BillingGrace   = "grace"   // Expired, renewal still being retried
+	BillingExpired = "expired" // Expired, downgraded at the next expiry run
+)
+
+// Billing is the subscription state of the authenticated user. Clients show
+// it as is instead of tracking plans themselves.
+type Billing struct {
+	Plan       string     `json:"plan"`
+	Status     string     `json:"status"`
+	ExpiryDate *time.Time `json:"expiry_date,omitempty"`
+	// GraceUntil is when a user in grace is downgraded.
+	GraceUntil *time.Time `json:"grace_until,omitempty"`
+	AutoRenew  bool       `json:"auto_renew"`
+	// PaymentMethod describes the method renewals are charged to, such as
+	// "Bank card *4242".
+	PaymentMethod   string `json:"payment_method,omitempty"`
+	Price           string `json:"price,omitempty"` // Of a renewal, in RUB
+	RenewalFailures int    `json:"renewal_failures,omitempty"`
+	// NextPlan is a downgrade taking effect at ExpiryDate, with the renewal.
+	NextPlan string `json:"next_plan,omitempty"`
+	// PausedUntil is when a paused plan resumes by itself.
+	PausedUntil *time.Time `json:"paused_until,omitempty"`
+	// PauseDaysLeft is how many more days the plan can be paused for this
+	// year, see pause.go.
+	PauseDaysLeft int `json:"pause_days_left"`
+	// FamilyOwner is the email of the owner of the family plan the user
+	// shares, who pays for it; see family.go.
+	FamilyOwner string `json:"family_owner,omitempty"`
+}
+
+// UserPayment is a payment as shown to the user who made it.
+type UserPayment struct {
+	ID        string    `json:"id"`
+	Plan      string    `json:"plan"`
+	Amount    float64   `json:"amount"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// billing returns the subscription state of a user as of now.
+func (s *Server) billing(userID string, now time.Time) (*Billing, error) {
+	var b Billing
+	var expiry sql.NullTime
+	var autoRenew int
+	var methodID string
+	var pausedUntil sql.NullTime
+	if err := s.DB.QueryRow(`SELECT plan, expiry_date, auto_renew, payment_method_id, payment_method_title, renewal_failures, next_plan, paused_until
+		FROM users WHERE id = ?`, userID).Scan(&b.Plan, &expiry, &autoRenew, &methodID, &b.PaymentMethod, &b.RenewalFailures, &b.NextPlan, &pausedUntil); err != nil {
+		return nil, err
+	}
+	b.AutoRenew = autoRenew == 1
+	ownerID, err := s.familyOwner(userID)
+	if err != nil {
+		return nil, err
+	}
+	if ownerID != "" {
+		// Renewals and pauses are the owner's.
+		if err := s.DB.QueryRow("SELECT email, paused_until FROM users WHERE id = ?", ownerID).Scan(&b.FamilyOwner, &pausedUntil); err != nil {
+			return nil, err
+		}
+		if b.Plan == "free" {
+			pausedUntil = sql.NullTime{}
+		}
+	}
+	// The price renewals charge, see renewSubscription.
+	region := s.userRegion(userID)
+	if _, ok := paidPlans[b.Plan]; ok && ownerID == "" {
+		b.Price, _ = s.planPrice(b.Plan, region)
+	}
+	if _, ok := paidPlans[b.NextPlan]; ok {
+		b.Price, _ = s.planPrice(b.NextPlan, region)
+	}
+
+	switch {
+	case b.Plan == "free":
+		b.Status = BillingFree
+	case pausedUntil.Valid:
+		b.Status = BillingPaused
+		b.PausedUntil = &pausedUntil.Time
+	case !expiry.Valid || expiry.Time.After(now):
+		b.Status = BillingActive
+	case b.AutoRenew && methodID != "" && s.Cfg.AutoRenewDays > 0 && s.Cfg.GracePeriodDays > 0:
+		// Same rule as expireSubscriptions.
+		b.Status = BillingGrace
+		graceEnd := expiry.Time.AddDate(0, 0, s.Cfg.GracePeriodDays)
+		b.GraceUntil = &graceEnd
+	default:
+		b.Status = BillingExpired
+	}
+	if expiry.Valid {
+		b.ExpiryDate = &expiry.Time
+	}
+	if _, paid := paidPlans[b.Plan]; paid && expiry.Valid {
+		left, err := s.pauseDaysLeft(userID, now)
+		if err != nil {
+			return nil, err
+		}
+		b.PauseDaysLeft = left
+	}
+	return &b, nil
+}
+
+func (s *Server) handleGetBilling(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	b, err := s.billing(userID, time.Now().UTC())
+	if err != nil {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	json.NewEncoder(w).Encode(b)
+}
+
+// handleSetAutoRenew turns renewals on or off. They can only be turned on
+// once a payment saved a method to charge.
+func (s *Server) handleSetAutoRenew(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Enabled == nil {
+		http.Error(w, "enabled is required", 400)
+		return
+	}
+
+	unlock := s.lockUser(userID)
+	defer unlock()
+
+	var methodID string
+	if err := s.DB.QueryRow("SELECT payment_method_id FROM users WHERE id = ?", userID).Scan(&methodID); err != nil {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	if *req.Enabled && methodID == "" {
+		http.Error(w, "No saved payment method, pay for a plan first", 409)
+		return
+	}
+	autoRenew := 0
+	if *req.Enabled {
+		autoRenew = 1
+	}
+	// Turning renewals back on retries a failed one right away.
+	if _, err := s.DB.Exec("UPDATE users SET auto_renew = ?, renewal_attempted_at = NULL WHERE id = ?", autoRenew, userID); err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	log.Printf("[Billing] User %s set auto-renew to %v", userID, *req.Enabled)
+
+	b, err := s.billing(userID, time.Now().UTC())
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	json.NewEncoder(w).Encode(b)
+}
+
+// handleCancelNextPlan cancels a scheduled downgrade, so the current plan
+// renews as before.
+func (s *Server) handleCancelNextPlan(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	unlock := s.lockUser(userID)
+	defer unlock()
+	if _, err := s.DB.Exec("UPDATE users SET next_plan = '' WHERE id = ?", userID); err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+
+	b, err := s.billing(userID, time.Now().UTC())
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	json.NewEncoder(w).Encode(b)
+}
+
+// handleListUserPayments returns the user's latest payments, newest first.
+func (s *Server) handleListUserPayments(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	rows, err := s.DB.Query(`SELECT yookassa_id, plan, amount, status, created_at FROM payments
+		WHERE user_id = ? ORDER BY created_at DESC LIMIT 50`, userID)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	payments := []UserPayment{}
+	for rows.Next() {
+		var p UserPayment
+		if err := rows.Scan(&p.ID, &p.Plan, &p.Amount, &p.Status, &p.CreatedAt); err != nil {
+			log.Printf("[Billing] Error scanning payment row: %v", err)
+			continue
+		}
+		payments = append(payments, p)
+	}
+	json.NewEncoder(w).Encode(payments)
+}
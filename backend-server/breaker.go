@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by a ResilientProvider while its breaker is open.
+var ErrCircuitOpen = errors.New("provider circuit breaker is open")
+
+// BreakerState is the state of a CircuitBreaker.
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerHalfOpen
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreaker stops calling a provider after repeated failures. Once
+// Cooldown has passed, a single trial call is let through (half-open); its
+// outcome closes or re-opens the breaker.
+type CircuitBreaker struct {
+	Threshold int           // Consecutive failures before opening
+	Cooldown  time.Duration // Time spent open before a trial call
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	lastError string
+}
+
+// NewCircuitBreaker creates a closed breaker.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+// Allow reports whether a call may proceed.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.Cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		return true
+	case BreakerHalfOpen:
+		// Only one trial call at a time.
+		return false
+	default:
+		return true
+	}
+}
+
+// Record updates the breaker with the outcome of a call.
+func (b *CircuitBreaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	b.lastError = err.Error()
+	if b.state == BreakerHalfOpen || b.failures >= b.Threshold {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Reset closes the breaker and clears its failure count.
+func (b *CircuitBreaker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = BreakerClosed
+	b.failures = 0
+	b.lastError = ""
+}
+
+// BreakerStatus is a snapshot of a breaker, as exposed by the API.
+type BreakerStatus struct {
+	ServerID  string    `json:"server_id"`
+	State     string    `json:"state"`
+	Failures  int       `json:"failures"`
+	OpenedAt  time.Time `json:"opened_at,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+
+	state BreakerState
+}
+
+func (b *CircuitBreaker) status(serverID string) BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BreakerStatus{
+		ServerID:  serverID,
+		State:     b.state.String(),
+		Failures:  b.failures,
+		OpenedAt:  b.openedAt,
+		LastError: b.lastError,
+		state:     b.state,
+	}
+}
+
+// BreakerRegistry holds one breaker per server. Providers are created per
+// request, so breaker state must live here to survive between requests.
+type BreakerRegistry struct {
+	mu       sync.Mutex
+	breakers map[string]*CircuitBreaker
+}
+
+func NewBreakerRegistry() *BreakerRegistry {
+	return &BreakerRegistry{breakers: make(map[string]*CircuitBreaker)}
+}
+
+// Get returns the breaker for a server, creating it if needed.
+func (r *BreakerRegistry) Get(serverID string) *CircuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.breakers[serverID]
+	if !ok {
+		b = NewCircuitBreaker(5, 30*time.Second)
+		r.breakers[serverID] = b
+	}
+	return b
+}
+
+// Reset closes the breaker of a server. Returns false if it doesn't exist.
+func (r *BreakerRegistry) Reset(serverID string) bool {
+	r.mu.Lock()
+	b, ok := r.breakers[serverID]
+	r.mu.Unlock()
+	if ok {
+		b.Reset()
+		log.Printf("[Breaker] Reset breaker for server %s", serverID)
+	}
+	return ok
+}
+
+// Snapshot returns the status of all breakers sorted by server ID.
+func (r *BreakerRegistry) Snapshot() []BreakerStatus {
+	r.mu.Lock()
+	ids := make([]string, 0, len(r.breakers))
+	for id := range r.breakers {
+		ids = append(ids, id)
+	}
+	r.mu.Unlock()
+	sort.Strings(ids)
+
+	statuses := make([]BreakerStatus, 0, len(ids))
+	for _, id := range ids {
+		statuses = append(statuses, r.Get(id).status(id))
+	}
+	return statuses
+}
+
+// ResilientProvider decorates a VPNProvider with retries and a circuit breaker,
+// so a misbehaving panel fails fast instead of stalling every request.
+type ResilientProvider struct {
+	inner   VPNProvider
+	breaker *CircuitBreaker
+	retries int
+	backoff time.Duration
+}
+
+// NewResilientProvider wraps a provider. Failed calls are retried twice with
+// exponential backoff before counting as a single breaker failure.
+func NewResilientProvider(inner VPNProvider, breaker *CircuitBreaker) *ResilientProvider {
+	return &ResilientProvider{
+		inner:   inner,
+		breaker: breaker,
+		retries: 2,
+		backoff: 200 * time.Millisecond,
+	}
+}
+
+func (p *ResilientProvider) call(fn func() error) error {
+	if !p.breaker.Allow() {
+		return ErrCircuitOpen
+	}
+
+	backoff := p.backoff
+	var err error
+	for attempt := 0; attempt <= p.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = fn(); err == nil {
+			break
+		}
+	}
+	p.breaker.Record(err)
+	return err
+}
+
+func (p *ResilientProvider) CreateKey(userID string) (keyID string, accessConfig string, err error) {
+	err = p.call(func() error {
+		var callErr error
+		keyID, accessConfig, callErr = p.inner.CreateKey(userID)
+		return callErr
+	})
+	return keyID, accessConfig, err
+}
+
+func (p *ResilientProvider) DeleteKey(keyID string) error {
+	return p.call(func() error { return p.inner.DeleteKey(keyID) })
+}
+
+func (p *ResilientProvider) GetKeys() (keys []VPNKey, err error) {
+	err = p.call(func() error {
+		var callErr error
+		keys, callErr = p.inner.GetKeys()
+		return callErr
+	})
+	return keys, err
+}
+
+func (p *ResilientProvider) SetName(keyID string, name string) error {
+	return p.call(func() error { return p.inner.SetName(keyID, name) })
+}
+
+func (p *ResilientProvider) SetDataLimit(keyID string, bytes int64) error {
+	return p.call(func() error { return p.inner.SetDataLimit(keyID, bytes) })
+}
+
+func (p *ResilientProvider) GetUsage(keyID string) (used int64, err error) {
+	err = p.call(func() error {
+		var callErr error
+		used, callErr = p.inner.GetUsage(keyID)
+		return callErr
+	})
+	return used, err
+}
+
+func (p *ResilientProvider) ResetUsage(keyID string) error {
+	return p.call(func() error { return p.inner.ResetUsage(keyID) })
+}
+
+func (p *ResilientProvider) GetConnections() (counts map[string]int, err error) {
+	err = p.call(func() error {
+		var callErr error
+		counts, callErr = p.inner.GetConnections()
+		return callErr
+	})
+	return counts, err
+}
+
+func (p *ResilientProvider) GetTransfer() (transfer map[string]int64, err error) {
+	err = p.call(func() error {
+		var callErr error
+		transfer, callErr = p.inner.GetTransfer()
+		return callErr
+	})
+	return transfer, err
+}
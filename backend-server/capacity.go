@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// Server assignment strategies for GET /servers/recommended.
+const (
+	// AssignLatency ranks servers in the client's country first, then by
+	// measured latency.
+	AssignLatency = "latency"
+	// AssignLeastLoaded ranks countries like AssignLatency, but within a
+	// country prefers the server with the most free capacity.
+	AssignLeastLoaded = "least_loaded"
+)
+
+// errServerFull means a server reached its max_users and takes no new users.
+var errServerFull = errors.New("server is full")
+
+// serverLoad returns how many users have keys on a server, whatever number
+// of devices each, and its capacity (0 = unlimited).
+func (s *Server) serverLoad(serverID string) (users, maxUsers int, err error) {
+	err = s.DB.QueryRow(`SELECT (SELECT COUNT(DISTINCT k.user_id) FROM access_keys k WHERE k.server_id = s.id), s.max_users
+		FROM servers s WHERE s.id = ?`, serverID).Scan(&users, &maxUsers)
+	return users, maxUsers, err
+}
+
+// loadFactor is the share of a server's capacity in use. Servers without a
+// limit count their users against defaultCapacity, so they compare sensibly
+// with limited ones.
+func loadFactor(users, maxUsers int) float64 {
+	const defaultCapacity = 1000
+	if maxUsers <= 0 {
+		maxUsers = defaultCapacity
+	}
+	return float64(users) / float64(maxUsers)
+}
+
+// lockServer serializes creating keys on a server with a capacity limit, so
+// concurrent requests can't push it past max_users. Call the returned
+// function to unlock.
+func (s *Server) lockServer(serverID string) func() {
+	mu, _ := s.serverLocks.LoadOrStore(serverID, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// handleAdminSetCapacity changes how many users a server takes. Lowering it
+// below the current number of keys keeps the existing keys; the server just
+// takes no new users.
+func (s *Server) handleAdminSetCapacity(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req struct {
+		MaxUsers *int `json:"max_users"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.MaxUsers == nil {
+		http.Error(w, "max_users is required", 400)
+		return
+	}
+	if err := validateMaxUsers(*req.MaxUsers); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	res, err := s.DB.Exec("UPDATE servers SET max_users = ? WHERE id = ?", *req.MaxUsers, id)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Server not found", 404)
+		return
+	}
+	log.Printf("[Admin] Server %s capacity set to %d users", id, *req.MaxUsers)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": id})
+}
+
+func validateMaxUsers(n int) error {
+	if n < 0 || n > 1000000 {
+		return errors.New("max_users must be between 0 (unlimited) and 1000000")
+	}
+	return nil
+}
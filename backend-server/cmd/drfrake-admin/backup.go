@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	_ "modernc.org/sqlite"
+)
+
+// requiredTables must exist in a backup for it to be restorable.
+var requiredTables = []string{"users", "payments", "servers", "access_keys"}
+
+func newBackupCmd(c *adminClient) *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Download a consistent snapshot of the backend database",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			req, err := http.NewRequest("GET", strings.TrimRight(c.baseURL, "/")+"/admin/backup", nil)
+			if err != nil {
+				return err
+			}
+			if c.token != "" {
+				req.Header.Set("Authorization", "Bearer "+c.token)
+			}
+			resp, err := c.httpClient.Do(req)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode >= 400 {
+				data, _ := io.ReadAll(resp.Body)
+				return fmt.Errorf("GET /admin/backup: %s: %s", resp.Status, strings.TrimSpace(string(data)))
+			}
+
+			if output == "" {
+				output = "drfrake-" + time.Now().UTC().Format("20060102-150405") + ".db"
+			}
+			f, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			n, err := io.Copy(f, resp.Body)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(output)
+				return err
+			}
+			fmt.Printf("Saved backup to %s (%d bytes)\n", output, n)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "File to write (default drfrake-<timestamp>.db)")
+	return cmd
+}
+
+// newRestoreCmd restores a backup into the database file of a stopped backend.
+// It works on files directly rather than through the API, as the database
+// can't be swapped out under a running server.
+func newRestoreCmd() *cobra.Command {
+	var dbPath string
+	cmd := &cobra.Command{
+		Use:   "restore BACKUP_FILE",
+		Short: "Restore a backup into the database of a stopped backend",
+		Long: `Restore a backup into the database of a stopped backend.
+
+Stop the backend first. The backup is checked for integrity, the current
+database is kept as <db>.pre-restore-<timestamp>, and the backup is copied
+in its place. Then start the backend again.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			backup := args[0]
+			if err := verifyBackup(backup); err != nil {
+				return fmt.Errorf("%s is not a usable backup: %w", backup, err)
+			}
+
+			if _, err := os.Stat(dbPath); err == nil {
+				saved := dbPath + ".pre-restore-" + time.Now().UTC().Format("20060102-150405")
+				if err := os.Rename(dbPath, saved); err != nil {
+					return err
+				}
+				fmt.Printf("Kept current database as %s\n", saved)
+			}
+			// Stale journal files would be replayed against the restored database.
+			os.Remove(dbPath + "-wal")
+			os.Remove(dbPath + "-shm")
+
+			if err := copyFile(backup, dbPath); err != nil {
+				return err
+			}
+			fmt.Printf("Restored %s to %s\n", backup, dbPath)
+			return nil
+		},
+	}
+	defaultDB := os.Getenv("DB_PATH")
+	if defaultDB == "" {
+		defaultDB = "server.db"
+	}
+	cmd.Flags().StringVar(&dbPath, "db", defaultDB, "Database file of the backend (default $DB_PATH or server.db)")
+	return cmd
+}
+
+func verifyBackup(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check: %s", result)
+	}
+	for _, table := range requiredTables {
+		var name string
+		err := db.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&name)
+		if err != nil {
+			return fmt.Errorf("missing table %s", table)
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
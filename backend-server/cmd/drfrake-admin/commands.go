@@ -0,0 +1,692 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newServersCmd(c *adminClient) *cobra.Command {
+	cmd := &cobra.Command{Use: "servers", Short: "Manage VPN servers"}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List servers",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var servers []struct {
+				ID        string   `json:"id"`
+				Country   string   `json:"country"`
+				City      string   `json:"city"`
+				Flag      string   `json:"flag"`
+				IsPremium bool     `json:"is_premium"`
+				Type      string   `json:"type"`
+				Users     int      `json:"users"`
+				MaxUsers  int      `json:"max_users"`
+				Tags      []string `json:"tags"`
+				Archived  bool     `json:"archived"`
+				Unpinned  bool     `json:"unpinned"`
+			}
+			if err := c.do("GET", "/admin/servers", nil, &servers); err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID\tTYPE\tLOCATION\tPREMIUM\tUSERS\tTAGS\tARCHIVED")
+			for _, s := range servers {
+				users := strconv.Itoa(s.Users)
+				if s.MaxUsers > 0 {
+					users += "/" + strconv.Itoa(s.MaxUsers)
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s %s, %s\t%t\t%s\t%s\t%t\n", s.ID, s.Type, s.Flag, s.City, s.Country, s.IsPremium, users,
+					strings.Join(s.Tags, ","), s.Archived)
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+			for _, s := range servers {
+				if s.Unpinned {
+					fmt.Fprintf(os.Stderr, "Warning: server %s has no cert_sha256, its certificate isn't checked\n", s.ID)
+				}
+			}
+			return nil
+		},
+	})
+
+	var add struct {
+		Type          string   `json:"type"`
+		APIURL        string   `json:"api_url"`
+		CertSHA256    string   `json:"cert_sha256"`
+		Country       string   `json:"country"`
+		City          string   `json:"city"`
+		Flag          string   `json:"flag"`
+		IsPremium     bool     `json:"is_premium"`
+		ServerHost    string   `json:"server_host"`
+		XrayPanelURL  string   `json:"xray_panel_url"`
+		XrayUsername  string   `json:"xray_username"`
+		XrayPassword  string   `json:"xray_password"`
+		XrayInboundID int      `json:"xray_inbound_id"`
+		XraySettings  string   `json:"xray_settings"`
+		MaxUsers      int      `json:"max_users"`
+		Tags          []string `json:"tags"`
+	}
+	addCmd := &cobra.Command{
+		Use:   "add",
+		Short: "Add a server",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var resp map[string]string
+			if err := c.do("POST", "/admin/add-server", add, &resp); err != nil {
+				return err
+			}
+			fmt.Printf("Added %s server %s in %s %s, %s\n", resp["type"], resp["id"], resp["flag"], resp["city"], resp["country"])
+			return nil
+		},
+	}
+	f := addCmd.Flags()
+	f.StringVar(&add.Type, "type", "outline", "Server type: outline, xray or mock")
+	f.StringVar(&add.APIURL, "api-url", "", "Outline management API URL")
+	f.StringVar(&add.CertSHA256, "cert-sha256", "", "Outline management API certificate fingerprint")
+	f.StringVar(&add.Country, "country", "", "Country name (default: from GeoIP)")
+	f.StringVar(&add.City, "city", "", "City name (default: from GeoIP)")
+	f.StringVar(&add.Flag, "flag", "", "Flag emoji (default: from GeoIP)")
+	f.BoolVar(&add.IsPremium, "premium", false, "Only available to premium users")
+	f.StringVar(&add.ServerHost, "server-host", "", "Public hostname or IP of the VPN server (xray)")
+	f.StringVar(&add.XrayPanelURL, "panel-url", "", "3X-UI panel URL (xray)")
+	f.StringVar(&add.XrayUsername, "panel-user", "", "3X-UI panel username (xray)")
+	f.StringVar(&add.XrayPassword, "panel-password", "", "3X-UI panel password (xray)")
+	f.IntVar(&add.XrayInboundID, "inbound-id", 0, "3X-UI inbound ID, 0 to select by protocol (xray)")
+	f.StringVar(&add.XraySettings, "settings", "", "JSON xray settings (xray)")
+	f.IntVar(&add.MaxUsers, "max-users", 0, "Number of users the server takes, 0 for unlimited")
+	f.StringSliceVar(&add.Tags, "tag", nil, "Tag of the server, e.g. streaming; repeat or comma-separate for more")
+	cmd.AddCommand(addCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "capacity SERVER_ID MAX_USERS",
+		Short: "Set how many users a server takes, 0 for unlimited",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid MAX_USERS %q", args[1])
+			}
+			if err := c.do("PUT", "/admin/servers/"+args[0]+"/capacity", map[string]int{"max_users": n}, nil); err != nil {
+				return err
+			}
+			if n == 0 {
+				fmt.Printf("Server %s takes unlimited users\n", args[0])
+			} else {
+				fmt.Printf("Server %s takes up to %d users\n", args[0], n)
+			}
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "tags SERVER_ID [TAG...]",
+		Short: "Set the tags of a server, e.g. streaming p2p; none clears them",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var resp struct {
+				Tags []string `json:"tags"`
+			}
+			if err := c.do("PUT", "/admin/servers/"+args[0]+"/tags", map[string][]string{"tags": args[1:]}, &resp); err != nil {
+				return err
+			}
+			if len(resp.Tags) == 0 {
+				fmt.Printf("Server %s has no tags\n", args[0])
+			} else {
+				fmt.Printf("Server %s tagged %s\n", args[0], strings.Join(resp.Tags, ", "))
+			}
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "delete SERVER_ID",
+		Short: "Delete a server and its stored keys",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := c.do("DELETE", "/admin/servers/"+args[0], nil, nil); err != nil {
+				return err
+			}
+			fmt.Println("Deleted server", args[0])
+			return nil
+		},
+	})
+
+	var reason string
+	archiveCmd := &cobra.Command{
+		Use:   "archive SERVER_ID",
+		Short: "Take a server out of rotation, keeping its keys",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := c.do("POST", "/admin/servers/"+args[0]+"/archive", map[string]string{"reason": reason}, nil); err != nil {
+				return err
+			}
+			fmt.Println("Archived server", args[0])
+			return nil
+		},
+	}
+	archiveCmd.Flags().StringVar(&reason, "reason", "", "Why the server is archived, e.g. maintenance")
+	cmd.AddCommand(archiveCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "restore SERVER_ID",
+		Short: "Put an archived server back into rotation",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := c.do("POST", "/admin/servers/"+args[0]+"/restore", nil, nil); err != nil {
+				return err
+			}
+			fmt.Println("Restored server", args[0])
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "sync SERVER_ID",
+		Short: "Refresh Reality/TLS settings of an xray server from its panel",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var resp map[string]interface{}
+			if err := c.do("POST", "/admin/servers/"+args[0]+"/sync", nil, &resp); err != nil {
+				return err
+			}
+			printJSON(resp)
+			return nil
+		},
+	})
+
+	return cmd
+}
+
+func newUsersCmd(c *adminClient) *cobra.Command {
+	cmd := &cobra.Command{Use: "users", Short: "Manage users"}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List users",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var users []struct {
+				ID         string     `json:"id"`
+				Email      string     `json:"email"`
+				Plan       string     `json:"plan"`
+				ExpiryDate *time.Time `json:"expiry_date"`
+				CreatedAt  time.Time  `json:"created_at"`
+			}
+			if err := c.do("GET", "/admin/users", nil, &users); err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID\tEMAIL\tPLAN\tEXPIRES\tCREATED")
+			for _, u := range users {
+				expiry := "-"
+				if u.ExpiryDate != nil {
+					expiry = u.ExpiryDate.Format("2006-01-02")
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", u.ID, u.Email, u.Plan, expiry, u.CreatedAt.Format("2006-01-02"))
+			}
+			return tw.Flush()
+		},
+	})
+
+	var plan string
+	var days int
+	grantCmd := &cobra.Command{
+		Use:   "grant USER_ID",
+		Short: "Grant a plan to a user",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			body := map[string]interface{}{"plan": plan, "days": days}
+			if err := c.do("POST", "/admin/users/"+args[0]+"/plan", body, nil); err != nil {
+				return err
+			}
+			fmt.Printf("Granted %s to %s\n", plan, args[0])
+			return nil
+		},
+	}
+	grantCmd.Flags().StringVar(&plan, "plan", "monthly", "Plan to grant: free, monthly, yearly or a plan from config.json")
+	grantCmd.Flags().IntVar(&days, "days", 30, "Validity in days, 0 for no expiry")
+	cmd.AddCommand(grantCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "logout USER_ID",
+		Short: "Revoke all sessions of a user",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var resp struct {
+				Revoked int `json:"revoked"`
+			}
+			if err := c.do("DELETE", "/admin/users/"+args[0]+"/sessions", nil, &resp); err != nil {
+				return err
+			}
+			fmt.Printf("Revoked %d sessions of %s\n", resp.Revoked, args[0])
+			return nil
+		},
+	})
+
+	return cmd
+}
+
+func newPaymentsCmd(c *adminClient) *cobra.Command {
+	cmd := &cobra.Command{Use: "payments", Short: "Manage payments"}
+
+	var userID string
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List recent payments",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := "/admin/payments"
+			if userID != "" {
+				path += "?user_id=" + userID
+			}
+			var payments []struct {
+				ID        string    `json:"id"`
+				UserID    string    `json:"user_id"`
+				Amount    float64   `json:"amount"`
+				Status    string    `json:"status"`
+				CreatedAt time.Time `json:"created_at"`
+			}
+			if err := c.do("GET", path, nil, &payments); err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID\tUSER\tAMOUNT\tSTATUS\tCREATED")
+			for _, p := range payments {
+				fmt.Fprintf(tw, "%s\t%s\t%.2f\t%s\t%s\n", p.ID, p.UserID, p.Amount, p.Status, p.CreatedAt.Format(time.DateTime))
+			}
+			return tw.Flush()
+		},
+	}
+	listCmd.Flags().StringVar(&userID, "user", "", "Only show payments of this user")
+	cmd.AddCommand(listCmd)
+
+	var amount, reason string
+	refundCmd := &cobra.Command{
+		Use:   "refund PAYMENT_ID",
+		Short: "Refund a payment and downgrade the user",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			body := map[string]string{"amount": amount, "reason": reason}
+			var resp map[string]string
+			if err := c.do("POST", "/admin/payments/"+args[0]+"/refund", body, &resp); err != nil {
+				return err
+			}
+			fmt.Printf("Refunded %s RUB (refund %s)\n", resp["amount"], resp["refund_id"])
+			return nil
+		},
+	}
+	refundCmd.Flags().StringVar(&amount, "amount", "", "Amount to refund, e.g. 299.00 (default: full amount)")
+	refundCmd.Flags().StringVar(&reason, "reason", "", "Refund reason shown to the customer")
+	cmd.AddCommand(refundCmd)
+
+	return cmd
+}
+
+func newReconcileCmd(c *adminClient) *cobra.Command {
+	var last bool
+	cmd := &cobra.Command{
+		Use:   "reconcile",
+		Short: "Re-check pending payments and cross-check recent payments with the payment provider",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			method := "POST"
+			if last {
+				method = "GET"
+			}
+			var resp map[string]interface{}
+			if err := c.do(method, "/admin/reconcile", nil, &resp); err != nil {
+				return err
+			}
+			printJSON(resp)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&last, "last", false, "Show the report of the latest run instead of starting one")
+	return cmd
+}
+
+func newWebhooksCmd(c *adminClient) *cobra.Command {
+	cmd := &cobra.Command{Use: "webhooks", Short: "Manage outgoing webhooks"}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List webhooks",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var webhooks []struct {
+				ID     string   `json:"id"`
+				URL    string   `json:"url"`
+				Events []string `json:"events"`
+			}
+			if err := c.do("GET", "/admin/webhooks", nil, &webhooks); err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID\tURL\tEVENTS")
+			for _, wh := range webhooks {
+				events := strings.Join(wh.Events, ",")
+				if events == "" {
+					events = "all"
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", wh.ID, wh.URL, events)
+			}
+			return tw.Flush()
+		},
+	})
+
+	var add struct {
+		URL    string   `json:"url"`
+		Secret string   `json:"secret"`
+		Events []string `json:"events"`
+	}
+	addCmd := &cobra.Command{
+		Use:   "add URL",
+		Short: "Register a webhook",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			add.URL = args[0]
+			var resp struct {
+				ID     string `json:"id"`
+				Secret string `json:"secret"`
+			}
+			if err := c.do("POST", "/admin/webhooks", add, &resp); err != nil {
+				return err
+			}
+			fmt.Printf("Added webhook %s\nSigning secret: %s\n", resp.ID, resp.Secret)
+			return nil
+		},
+	}
+	addCmd.Flags().StringVar(&add.Secret, "secret", "", "Signing secret (default: generated)")
+	addCmd.Flags().StringSliceVar(&add.Events, "events", nil,
+		"Events to send: user.registered, user.email_changed, payment.succeeded, subscription.expired, server.unhealthy, abuse.flagged (default: all)")
+	cmd.AddCommand(addCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "delete WEBHOOK_ID",
+		Short: "Delete a webhook and its pending deliveries",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := c.do("DELETE", "/admin/webhooks/"+args[0], nil, nil); err != nil {
+				return err
+			}
+			fmt.Printf("Deleted webhook %s\n", args[0])
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "test WEBHOOK_ID",
+		Short: "Send a ping event to a webhook",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := c.do("POST", "/admin/webhooks/"+args[0]+"/test", nil, nil); err != nil {
+				return err
+			}
+			fmt.Println("Ping delivered")
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "deliveries WEBHOOK_ID",
+		Short: "Show recent deliveries of a webhook",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var deliveries []struct {
+				ID        string    `json:"id"`
+				Event     string    `json:"event"`
+				Status    string    `json:"status"`
+				Attempts  int       `json:"attempts"`
+				LastError string    `json:"last_error"`
+				CreatedAt time.Time `json:"created_at"`
+			}
+			if err := c.do("GET", "/admin/webhooks/"+args[0]+"/deliveries", nil, &deliveries); err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID\tEVENT\tSTATUS\tATTEMPTS\tCREATED\tLAST ERROR")
+			for _, d := range deliveries {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", d.ID, d.Event, d.Status, d.Attempts, d.CreatedAt.Format(time.DateTime), d.LastError)
+			}
+			return tw.Flush()
+		},
+	})
+
+	return cmd
+}
+
+func newAbuseCmd(c *adminClient) *cobra.Command {
+	cmd := &cobra.Command{Use: "abuse", Short: "Review keys flagged as used from too many devices"}
+
+	var all bool
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List open abuse flags",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := "/admin/abuse"
+			if all {
+				path += "?status=all"
+			}
+			var flags []struct {
+				ID         string    `json:"id"`
+				UserID     string    `json:"user_id"`
+				Email      string    `json:"email"`
+				ServerID   string    `json:"server_id"`
+				Devices    int       `json:"devices"`
+				Status     string    `json:"status"`
+				LastSeenAt time.Time `json:"last_seen_at"`
+			}
+			if err := c.do("GET", path, nil, &flags); err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID\tUSER\tEMAIL\tSERVER\tDEVICES\tSTATUS\tLAST SEEN")
+			for _, f := range flags {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", f.ID, f.UserID, f.Email, f.ServerID, f.Devices, f.Status, f.LastSeenAt.Format(time.DateTime))
+			}
+			return tw.Flush()
+		},
+	}
+	listCmd.Flags().BoolVar(&all, "all", false, "Also show dismissed and resolved flags")
+	cmd.AddCommand(listCmd)
+
+	var note string
+	resolve := func(action, use, short, done string) *cobra.Command {
+		resolveCmd := &cobra.Command{
+			Use:   use,
+			Short: short,
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				body := map[string]string{"action": action, "note": note}
+				if err := c.do("POST", "/admin/abuse/"+args[0]+"/resolve", body, nil); err != nil {
+					return err
+				}
+				fmt.Printf("Flag %s %s\n", args[0], done)
+				return nil
+			},
+		}
+		resolveCmd.Flags().StringVar(&note, "note", "", "Note stored with the resolution")
+		return resolveCmd
+	}
+	cmd.AddCommand(resolve("dismiss", "dismiss FLAG_ID", "Dismiss a flag as a false positive", "dismissed"))
+	cmd.AddCommand(resolve("rotate", "rotate FLAG_ID", "Replace the flagged key, cutting off devices it was shared with", "resolved, key rotated"))
+
+	return cmd
+}
+
+func newCodesCmd(c *adminClient) *cobra.Command {
+	cmd := &cobra.Command{Use: "codes", Short: "Generate and track gift activation codes"}
+
+	var count, days, validDays int
+	var plan, note string
+	generateCmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate one-time codes granting days of a paid plan, one per line",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			body := map[string]interface{}{"count": count, "days": days, "plan": plan, "note": note, "valid_days": validDays}
+			var resp struct {
+				Codes []string `json:"codes"`
+			}
+			if err := c.do("POST", "/admin/codes", body, &resp); err != nil {
+				return err
+			}
+			for _, code := range resp.Codes {
+				fmt.Println(code)
+			}
+			return nil
+		},
+	}
+	generateCmd.Flags().IntVar(&count, "count", 1, "Number of codes")
+	generateCmd.Flags().IntVar(&days, "days", 30, "Days of the plan each code grants")
+	generateCmd.Flags().StringVar(&plan, "plan", "monthly", "Plan the codes grant")
+	generateCmd.Flags().StringVar(&note, "note", "", "Note to find the batch by, e.g. the giveaway name")
+	generateCmd.Flags().IntVar(&validDays, "valid-days", 0, "Days the codes can be redeemed for (0 = no limit)")
+	cmd.AddCommand(generateCmd)
+
+	var status, batch string
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List codes and who redeemed them",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			q := url.Values{}
+			if status != "" {
+				q.Set("status", status)
+			}
+			if batch != "" {
+				q.Set("note", batch)
+			}
+			path := "/admin/codes"
+			if len(q) > 0 {
+				path += "?" + q.Encode()
+			}
+			var codes []struct {
+				Code       string     `json:"code"`
+				Plan       string     `json:"plan"`
+				Days       int        `json:"days"`
+				Note       string     `json:"note"`
+				RedeemedBy string     `json:"redeemed_by"`
+				RedeemedAt *time.Time `json:"redeemed_at"`
+			}
+			if err := c.do("GET", path, nil, &codes); err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "CODE\tPLAN\tDAYS\tNOTE\tREDEEMED BY\tREDEEMED AT")
+			for _, code := range codes {
+				redeemedAt := "-"
+				if code.RedeemedAt != nil {
+					redeemedAt = code.RedeemedAt.Format(time.DateTime)
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", code.Code, code.Plan, code.Days, code.Note, code.RedeemedBy, redeemedAt)
+			}
+			return tw.Flush()
+		},
+	}
+	listCmd.Flags().StringVar(&status, "status", "", "Only show unused or redeemed codes")
+	listCmd.Flags().StringVar(&batch, "note", "", "Only show codes generated with this note")
+	cmd.AddCommand(listCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "revoke CODE",
+		Short: "Delete an unused code",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := c.do("DELETE", "/admin/codes/"+url.PathEscape(args[0]), nil, nil); err != nil {
+				return err
+			}
+			fmt.Printf("Code %s revoked\n", args[0])
+			return nil
+		},
+	})
+
+	return cmd
+}
+
+func newFlagsCmd(c *adminClient) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "flags",
+		Short: "Manage feature flags, maintenance mode and kill switches",
+		Long: `Manage runtime feature flags. Built-in flags:
+  maintenance   answer all non-admin requests with 503 while enabled
+  registration  disable to close /register
+  payments      disable to stop new payments`,
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List flags",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var flags []struct {
+				Name    string `json:"name"`
+				Enabled bool   `json:"enabled"`
+				Rollout int    `json:"rollout"`
+				Message string `json:"message"`
+			}
+			if err := c.do("GET", "/admin/flags", nil, &flags); err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tENABLED\tROLLOUT\tMESSAGE")
+			for _, f := range flags {
+				fmt.Fprintf(tw, "%s\t%t\t%d%%\t%s\n", f.Name, f.Enabled, f.Rollout, f.Message)
+			}
+			return tw.Flush()
+		},
+	})
+
+	var rollout int
+	var message string
+	set := func(enabled bool, use, short string) *cobra.Command {
+		setCmd := &cobra.Command{
+			Use:   use,
+			Short: short,
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				body := map[string]interface{}{"enabled": enabled, "rollout": rollout, "message": message}
+				if err := c.do("PUT", "/admin/flags/"+args[0], body, nil); err != nil {
+					return err
+				}
+				state := "disabled"
+				if enabled {
+					state = fmt.Sprintf("enabled for %d%% of clients", rollout)
+				}
+				fmt.Printf("Flag %s %s\n", args[0], state)
+				return nil
+			},
+		}
+		setCmd.Flags().StringVar(&message, "message", "", "Message shown to clients the flag turns away")
+		return setCmd
+	}
+	enableCmd := set(true, "enable FLAG", "Enable a flag, e.g. maintenance")
+	enableCmd.Flags().IntVar(&rollout, "rollout", 100, "Percentage of clients the flag applies to")
+	cmd.AddCommand(enableCmd)
+	cmd.AddCommand(set(false, "disable FLAG", "Disable a flag, e.g. registration or payments"))
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "reset FLAG",
+		Short: "Delete a flag, returning a built-in flag to its default",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := c.do("DELETE", "/admin/flags/"+args[0], nil, nil); err != nil {
+				return err
+			}
+			fmt.Printf("Flag %s reset\n", args[0])
+			return nil
+		},
+	})
+
+	return cmd
+}
@@ -0,0 +1,110 @@
+// Command drfrake-admin manages a Dr. Frake backend through its admin API.
+//
+//	drfrake-admin --url https://api.example.com --token $ADMIN_TOKEN servers list
+//
+// The URL and token default to the DRFRAKE_ADMIN_URL and ADMIN_TOKEN environment variables.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// adminClient calls the backend admin API.
+type adminClient struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// do sends a request with an optional JSON body and decodes the JSON response into out.
+func (c *adminClient) do(method, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimRight(c.baseURL, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	return nil
+}
+
+func main() {
+	client := &adminClient{httpClient: &http.Client{Timeout: 60 * time.Second}}
+
+	defaultURL := os.Getenv("DRFRAKE_ADMIN_URL")
+	if defaultURL == "" {
+		defaultURL = "http://localhost:8080"
+	}
+
+	root := &cobra.Command{
+		Use:           "drfrake-admin",
+		Short:         "Administer a Dr. Frake VPN backend",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	root.PersistentFlags().StringVar(&client.baseURL, "url", defaultURL, "Backend base URL")
+	root.PersistentFlags().StringVar(&client.token, "token", os.Getenv("ADMIN_TOKEN"), "Admin API token")
+
+	root.AddCommand(
+		newServersCmd(client),
+		newUsersCmd(client),
+		newPaymentsCmd(client),
+		newReconcileCmd(client),
+		newWebhooksCmd(client),
+		newAbuseCmd(client),
+		newCodesCmd(client),
+		newFlagsCmd(client),
+		newProvisionCmd(client),
+		newBackupCmd(client),
+		newRestoreCmd(),
+	)
+
+	if err := root.Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// printJSON prints a value as indented JSON.
+func printJSON(v interface{}) {
+	data, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Println(string(data))
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type provisionJob struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Host       string     `json:"host"`
+	Status     string     `json:"status"`
+	HostKey    string     `json:"host_key"`
+	ServerID   string     `json:"server_id"`
+	Error      string     `json:"error"`
+	Log        string     `json:"log"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+func newProvisionCmd(c *adminClient) *cobra.Command {
+	cmd := &cobra.Command{Use: "provision", Short: "Set up fresh VPSes as VPN servers over SSH"}
+
+	var req struct {
+		Type          string   `json:"type"`
+		Host          string   `json:"host"`
+		SSHPort       int      `json:"ssh_port"`
+		SSHUser       string   `json:"ssh_user"`
+		SSHPassword   string   `json:"ssh_password,omitempty"`
+		SSHPrivateKey string   `json:"ssh_private_key,omitempty"`
+		SSHHostKey    string   `json:"ssh_host_key,omitempty"`
+		Country       string   `json:"country"`
+		City          string   `json:"city"`
+		Flag          string   `json:"flag"`
+		IsPremium     bool     `json:"is_premium"`
+		MaxUsers      int      `json:"max_users"`
+		Tags          []string `json:"tags"`
+		XrayPort      int      `json:"xray_port"`
+		XrayTarget    string   `json:"xray_target"`
+	}
+	var keyFile string
+	var wait bool
+	startCmd := &cobra.Command{
+		Use:   "start HOST",
+		Short: "Install Outline or 3X-UI on a VPS and add it as a server",
+		Long: `Install Outline or 3X-UI on a VPS and add it as a server.
+
+The backend connects to the VPS over SSH as root, or as a user with
+passwordless sudo, installs Docker if needed and then Outline with its
+install script, or 3X-UI with a VLESS+Reality inbound and its panel on
+HTTPS. The SSH credentials are sent to the backend for the job only and not
+stored. Without --host-key the key an earlier job saw for the VPS is
+expected, or on the first job the key the VPS presents is trusted;
+compare it with the one the job shows.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			req.Host = args[0]
+			if keyFile != "" {
+				key, err := os.ReadFile(keyFile)
+				if err != nil {
+					return err
+				}
+				req.SSHPrivateKey = string(key)
+			}
+			if req.SSHPrivateKey == "" && req.SSHPassword == "" {
+				return fmt.Errorf("--ssh-key or --ssh-password is required")
+			}
+			var job provisionJob
+			if err := c.do("POST", "/admin/provision", req, &job); err != nil {
+				return err
+			}
+			fmt.Printf("Started job %s\n", job.ID)
+			if !wait {
+				return nil
+			}
+			return followProvision(c, job.ID)
+		},
+	}
+	startCmd.Flags().StringVar(&req.Type, "type", "outline", "Server type: outline or xray")
+	startCmd.Flags().IntVar(&req.SSHPort, "ssh-port", 22, "SSH port")
+	startCmd.Flags().StringVar(&req.SSHUser, "ssh-user", "root", "SSH user, root or one with passwordless sudo")
+	startCmd.Flags().StringVar(&keyFile, "ssh-key", "", "Private key file to log in with")
+	startCmd.Flags().StringVar(&req.SSHPassword, "ssh-password", "", "Password to log in with")
+	startCmd.Flags().StringVar(&req.SSHHostKey, "host-key", "", "Expected SHA256 fingerprint of the host key")
+	startCmd.Flags().StringVar(&req.Country, "country", "", "Country (default: from GeoIP)")
+	startCmd.Flags().StringVar(&req.City, "city", "", "City (default: from GeoIP)")
+	startCmd.Flags().StringVar(&req.Flag, "flag", "", "Flag emoji (default: from GeoIP)")
+	startCmd.Flags().BoolVar(&req.IsPremium, "premium", false, "Only offer the server to paid plans")
+	startCmd.Flags().IntVar(&req.MaxUsers, "max-users", 0, "Capacity of the server (0 = unlimited)")
+	startCmd.Flags().StringSliceVar(&req.Tags, "tag", nil, "Tag of the server, e.g. streaming; repeat or comma-separate for more")
+	startCmd.Flags().IntVar(&req.XrayPort, "xray-port", 443, "Port of the VLESS inbound")
+	startCmd.Flags().StringVar(&req.XrayTarget, "xray-target", "www.google.com", "Site whose TLS handshake Reality borrows")
+	startCmd.Flags().BoolVar(&wait, "wait", false, "Follow the job's log until it finishes")
+	cmd.AddCommand(startCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the latest provisioning jobs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var jobs []provisionJob
+			if err := c.do("GET", "/admin/provision", nil, &jobs); err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID\tTYPE\tHOST\tSTATUS\tSERVER\tSTARTED\tERROR")
+			for _, j := range jobs {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", j.ID, j.Type, j.Host, j.Status, j.ServerID,
+					j.CreatedAt.Format(time.DateTime), j.Error)
+			}
+			return tw.Flush()
+		},
+	})
+
+	var follow bool
+	showCmd := &cobra.Command{
+		Use:   "show JOB_ID",
+		Short: "Show a provisioning job with its log",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if follow {
+				return followProvision(c, args[0])
+			}
+			var job provisionJob
+			if err := c.do("GET", "/admin/provision/"+args[0], nil, &job); err != nil {
+				return err
+			}
+			fmt.Print(job.Log)
+			printProvisionResult(&job)
+			return nil
+		},
+	}
+	showCmd.Flags().BoolVarP(&follow, "follow", "f", false, "Follow the log until the job finishes")
+	cmd.AddCommand(showCmd)
+	return cmd
+}
+
+// followProvision prints the log of a job as it grows until the job finishes,
+// and fails if the job does.
+func followProvision(c *adminClient, id string) error {
+	var printed string
+	for {
+		var job provisionJob
+		if err := c.do("GET", "/admin/provision/"+id, nil, &job); err != nil {
+			return err
+		}
+		fmt.Print(newLogOutput(printed, job.Log))
+		printed = job.Log
+		if job.Status != "running" {
+			printProvisionResult(&job)
+			if job.Status == "failed" {
+				return fmt.Errorf("provisioning failed")
+			}
+			return nil
+		}
+		time.Sleep(3 * time.Second)
+	}
+}
+
+// newLogOutput returns what log has after the part printed already. The
+// backend only keeps the tail of long logs, so the part printed may have been
+// cut from the front.
+func newLogOutput(printed, log string) string {
+	if strings.HasPrefix(log, printed) {
+		return log[len(printed):]
+	}
+	tail := printed[max(0, len(printed)-256):]
+	if i := strings.LastIndex(log, tail); i >= 0 {
+		return log[i+len(tail):]
+	}
+	return log
+}
+
+func printProvisionResult(job *provisionJob) {
+	fmt.Printf("\nJob %s: %s", job.ID, job.Status)
+	if job.HostKey != "" {
+		fmt.Printf(", host key %s", job.HostKey)
+	}
+	switch {
+	case job.ServerID != "":
+		fmt.Printf(", added server %s", job.ServerID)
+	case job.Error != "":
+		fmt.Printf(": %s", job.Error)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Activation codes grant N days of a paid plan, for giveaways and offline
+// sales. Each code can be redeemed once; who redeemed it and when is kept.
+
+// codeAlphabet leaves out characters that are easily confused, such as 0/O and 1/I.
+const codeAlphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+// maxCodesPerBatch caps a single POST /admin/codes.
+const maxCodesPerBatch = 1000
+
+// ActivationCode is a code as listed in the admin API.
+type ActivationCode struct {
+	Code       string     `json:"code"`
+	Plan       string     `json:"plan"`
+	Days       int        `json:"days"`
+	Note       string     `json:"note,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RedeemedBy string     `json:"redeemed_by,omitempty"`
+	RedeemedAt *time.Time `json:"redeemed_at,omitempty"`
+}
+
+var (
+	errCodeInvalid   = errors.New("invalid or expired code")
+	errCodeRedeemed  = errors.New("code already redeemed")
+	errPlanPermanent = errors.New("your plan doesn't expire")
+	errPlanMismatch  = errors.New("code is for another plan than the active one")
+)
+
+// newActivationCode returns a random code such as "DRF-7KQ2-M9XH-C4TP".
+func newActivationCode() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	sb.WriteString("DRF")
+	for i, b := range buf {
+		if i%4 == 0 {
+			sb.WriteByte('-')
+		}
+		sb.WriteByte(codeAlphabet[int(b)%len(codeAlphabet)])
+	}
+	return sb.String(), nil
+}
+
+// normalizeCode makes codes typed by hand match: case and stray spaces are
+// ignored, and the dashes are optional.
+func normalizeCode(code string) string {
+	code = strings.ToUpper(strings.Join(strings.Fields(code), ""))
+	code = strings.ReplaceAll(code, "-", "")
+	if len(code) != 15 || !strings.HasPrefix(code, "DRF") {
+		return code
+	}
+	return code[:3] + "-" + code[3:7] + "-" + code[7:11] + "-" + code[11:]
+}
+
+// redeemCode consumes a code for the user and extends their plan by its days.
+// Time left on the same plan is kept. A code for another plan is refused
+// while a paid plan is active, paused ones included, so it can't cut that
+// plan short; once it has expired the code switches to its plan starting now.
+func (s *Server) redeemCode(userID, code string) (plan string, expiry time.Time, err error) {
+	code = normalizeCode(code)
+	unlock := s.lockUser(userID)
+	defer unlock()
+
+	var days int
+	var codeExpiry sql.NullTime
+	var redeemedBy sql.NullString
+	err = s.DB.QueryRow("SELECT plan, days, expires_at, redeemed_by FROM activation_codes WHERE code = ?", code).
+		Scan(&plan, &days, &codeExpiry, &redeemedBy)
+	if err == sql.ErrNoRows {
+		return "", time.Time{}, errCodeInvalid
+	} else if err != nil {
+		return "", time.Time{}, err
+	}
+	if redeemedBy.Valid {
+		return "", time.Time{}, errCodeRedeemed
+	}
+	now := time.Now().UTC()
+	if codeExpiry.Valid && !codeExpiry.Time.After(now) {
+		return "", time.Time{}, errCodeInvalid
+	}
+
+	var userPlan string
+	var userExpiry sql.NullTime
+	if err := s.DB.QueryRow("SELECT plan, expiry_date FROM users WHERE id = ?", userID).Scan(&userPlan, &userExpiry); err != nil {
+		return "", time.Time{}, err
+	}
+	if userPlan != "free" && !userExpiry.Valid {
+		return "", time.Time{}, errPlanPermanent
+	}
+	if userPlan != "free" && userPlan != plan && userExpiry.Time.After(now) {
+		return "", time.Time{}, errPlanMismatch
+	}
+	start := now
+	if userPlan == plan && userExpiry.Valid && userExpiry.Time.After(now) {
+		start = userExpiry.Time.UTC()
+	}
+	expiry = start.AddDate(0, 0, days)
+
+	// The code is claimed first, so a code redeemed concurrently by another
+	// user can't be applied twice.
+	res, err := s.DB.Exec("UPDATE activation_codes SET redeemed_by = ?, redeemed_at = ? WHERE code = ? AND redeemed_by IS NULL",
+		userID, now, code)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", time.Time{}, errCodeRedeemed
+	}
+	if _, err := s.DB.Exec("UPDATE users SET plan = ?, expiry_date = ? WHERE id = ?", plan, expiry, userID); err != nil {
+		return "", time.Time{}, err
+	}
+	log.Printf("[Codes] User %s redeemed %s: %s until %s", userID, code, plan, expiry.Format(time.DateOnly))
+	go s.applyPlanLimits(userID)
+	return plan, expiry, nil
+}
+
+func (s *Server) handleRedeem(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Code == "" || len(req.Code) > 64 {
+		http.Error(w, "code is required", 400)
+		return
+	}
+
+	plan, expiry, err := s.redeemCode(userID, req.Code)
+	switch {
+	case errors.Is(err, errCodeInvalid):
+		http.Error(w, "Invalid or expired code", 404)
+		return
+	case errors.Is(err, errCodeRedeemed):
+		http.Error(w, "Code already redeemed", 409)
+		return
+	case errors.Is(err, errPlanPermanent):
+		http.Error(w, "Your plan doesn't expire, keep the code for someone else", 409)
+		return
+	case errors.Is(err, errPlanMismatch):
+		http.Error(w, "This code is for another plan, redeem it once your current plan ends", 409)
+		return
+	case err != nil:
+		log.Printf("[Codes] Failed to redeem code for user %s: %v", userID, err)
+		http.Error(w, "Database error", 500)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":      "ok",
+		"plan":        plan,
+		"expiry_date": expiry,
+	})
+}
+
+// handleAdminGenerateCodes creates a batch of codes and returns them; they
+// can be listed again later with GET /admin/codes.
+func (s *Server) handleAdminGenerateCodes(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Count int    `json:"count"`
+		Days  int    `json:"days"`
+		Plan  string `json:"plan"`
+		Note  string `json:"note"`
+		// ValidDays limits how long the codes can be redeemed; 0 means forever.
+		ValidDays int `json:"valid_days"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Count == 0 {
+		req.Count = 1
+	}
+	if req.Plan == "" {
+		req.Plan = "monthly"
+	}
+	var err error
+	switch {
+	case req.Count < 1 || req.Count > maxCodesPerBatch:
+		err = fmt.Errorf("count must be between 1 and %d", maxCodesPerBatch)
+	case req.Days < 1 || req.Days > 3650:
+		err = errors.New("days must be between 1 and 3650")
+	case req.ValidDays < 0 || req.ValidDays > 3650:
+		err = errors.New("valid_days must be between 0 and 3650")
+	case req.Plan == "free":
+		err = errors.New("codes must grant a paid plan")
+	default:
+		err = firstError(s.validatePlan(req.Plan), validateText("note", req.Note, maxTextLen))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	now := time.Now().UTC()
+	var expiresAt interface{}
+	if req.ValidDays > 0 {
+		expiresAt = now.AddDate(0, 0, req.ValidDays)
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	defer tx.Rollback()
+	codes := make([]string, 0, req.Count)
+	for len(codes) < req.Count {
+		code, err := newActivationCode()
+		if err != nil {
+			http.Error(w, "Internal error", 500)
+			return
+		}
+		if _, err := tx.Exec("INSERT INTO activation_codes (code, plan, days, note, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+			code, req.Plan, req.Days, req.Note, now, expiresAt); err != nil {
+			http.Error(w, "Database error: "+err.Error(), 500)
+			return
+		}
+		codes = append(codes, code)
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Database error: "+err.Error(), 500)
+		return
+	}
+	log.Printf("[Admin] Generated %d codes for %d days of %s (%s)", len(codes), req.Days, req.Plan, req.Note)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "codes": codes})
+}
+
+func (s *Server) handleAdminListCodes(w http.ResponseWriter, r *http.Request) {
+	query := "SELECT code, plan, days, note, created_at, expires_at, redeemed_by, redeemed_at FROM activation_codes WHERE 1 = 1"
+	var args []interface{}
+	switch r.URL.Query().Get("status") {
+	case "unused":
+		query += " AND redeemed_by IS NULL"
+	case "redeemed":
+		query += " AND redeemed_by IS NOT NULL"
+	}
+	if note := r.URL.Query().Get("note"); note != "" {
+		query += " AND note = ?"
+		args = append(args, note)
+	}
+	query += " ORDER BY created_at DESC, code LIMIT 5000"
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	codes := []ActivationCode{}
+	for rows.Next() {
+		var c ActivationCode
+		var expiresAt, redeemedAt sql.NullTime
+		var redeemedBy sql.NullString
+		if err := rows.Scan(&c.Code, &c.Plan, &c.Days, &c.Note, &c.CreatedAt, &expiresAt, &redeemedBy, &redeemedAt); err != nil {
+			log.Printf("Error scanning code row: %v", err)
+			continue
+		}
+		if expiresAt.Valid {
+			c.ExpiresAt = &expiresAt.Time
+		}
+		if redeemedAt.Valid {
+			c.RedeemedAt = &redeemedAt.Time
+		}
+		c.RedeemedBy = redeemedBy.String
+		codes = append(codes, c)
+	}
+	json.NewEncoder(w).Encode(codes)
+}
+
+// handleAdminRevokeCode deletes an unused code, e.g. one that leaked.
+func (s *Server) handleAdminRevokeCode(w http.ResponseWriter, r *http.Request) {
+	code := normalizeCode(r.PathValue("code"))
+	res, err := s.DB.Exec("DELETE FROM activation_codes WHERE code = ? AND redeemed_by IS NULL", code)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Code not found or already redeemed", 404)
+		return
+	}
+	log.Printf("[Admin] Revoked code %s", code)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": code})
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Devices are the machines a user connects from. Clients identify theirs with
+// a stable random ID in the X-Device-ID header, and name it with
+// X-Device-Name when it is first seen. Each device gets keys of its own, so
+// users can see which machines hold keys and revoke one without logging out
+// everywhere. Requests without an ID, from clients from before devices and
+// subscription links, use the account's keys, of device "".
+
+// Device is a machine of a user with keys of its own.
+type Device struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// Keys is the number of servers the device has a key on.
+	Keys    int  `json:"keys"`
+	Current bool `json:"current"`
+}
+
+var errTooManyDevices = errors.New("too many devices")
+
+// validateDeviceID requires IDs clients generate: up to 64 letters, digits,
+// dashes and underscores.
+func validateDeviceID(id string) error {
+	if id == "" || len(id) > 64 || strings.Trim(id, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_") != "" {
+		return errors.New("device ID must be 1 to 64 letters, digits, dashes or underscores")
+	}
+	return nil
+}
+
+// requestDevice returns the ID and name of the device a request was made
+// from. Both are empty for requests without an X-Device-ID header.
+func requestDevice(r *http.Request) (id, name string, err error) {
+	id = r.Header.Get("X-Device-ID")
+	if id == "" {
+		return "", "", nil
+	}
+	name = strings.TrimSpace(r.Header.Get("X-Device-Name"))
+	return id, name, firstError(validateDeviceID(id), validateText("X-Device-Name", name, 64))
+}
+
+// keyOwner returns the name the keys of a device are created under with the
+// VPN providers, which must differ between the devices of a user: Xray
+// identifies clients by it.
+func keyOwner(userID, deviceID string) string {
+	if deviceID == "" {
+		return userID
+	}
+	return userID + "." + deviceID
+}
+
+// registerDevice returns the user's device, adding it with name if it is new.
+// New devices beyond Config.MaxDevices are refused with errTooManyDevices.
+// The name the client reports is only used for new devices, so renames made
+// with PUT /devices/{id} stick.
+func (s *Server) registerDevice(userID, id, name string) (Device, error) {
+	unlock := s.lockUser(userID)
+	defer unlock()
+
+	now := time.Now().UTC()
+	dev := Device{ID: id, LastSeenAt: now}
+	err := s.DB.QueryRow("SELECT name, created_at FROM devices WHERE user_id = ? AND id = ?", userID, id).
+		Scan(&dev.Name, &dev.CreatedAt)
+	if err == nil {
+		_, err = s.DB.Exec("UPDATE devices SET last_seen_at = ? WHERE user_id = ? AND id = ?", now, userID, id)
+		return dev, err
+	} else if err != sql.ErrNoRows {
+		return dev, err
+	}
+
+	var count int
+	if err := s.DB.QueryRow("SELECT COUNT(*) FROM devices WHERE user_id = ?", userID).Scan(&count); err != nil {
+		return dev, err
+	}
+	if count >= s.Cfg.MaxDevices {
+		return dev, errTooManyDevices
+	}
+	dev.Name, dev.CreatedAt = name, now
+	if _, err := s.DB.Exec("INSERT INTO devices (user_id, id, name, created_at, last_seen_at) VALUES (?, ?, ?, ?, ?)",
+		userID, id, name, now, now); err != nil {
+		return dev, err
+	}
+	log.Printf("[Devices] User %s added device %s (%q)", userID, id, name)
+	return dev, nil
+}
+
+// revokeDevice deletes the keys of the user's device, revokes the sessions
+// logged in on it and forgets it. Their traffic keeps counting against the
+// data cap, see issueKey. Keys of servers that can't be reached stay, so
+// revoking again retries them.
+func (s *Server) revokeDevice(userID, deviceID string) error {
+	unlock := s.lockUser(userID)
+	defer unlock()
+
+	rows, err := s.DB.Query("SELECT server_id, key_id, usage_offset FROM access_keys WHERE user_id = ? AND device_id = ?",
+		userID, deviceID)
+	if err != nil {
+		return err
+	}
+	type deviceKey struct {
+		serverID, keyID string
+		offset          int64
+	}
+	var keys []deviceKey
+	for rows.Next() {
+		var k deviceKey
+		if err := rows.Scan(&k.serverID, &k.keyID, &k.offset); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, k)
+	}
+	rows.Close()
+
+	var errs []error
+	for _, k := range keys {
+		rec, err := s.loadServerRecord(k.serverID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		provider := s.provider(rec)
+		used := k.offset
+		if n, err := provider.GetUsage(k.keyID); err == nil {
+			used += n
+		} else {
+			log.Printf("[Devices] Failed to get usage of key %s on server %s before revoking: %v", k.keyID, rec.ID, err)
+		}
+		if err := provider.DeleteKey(k.keyID); err != nil {
+			errs = append(errs, fmt.Errorf("%w: deleting key %s on server %s: %v", errVPNUnavailable, k.keyID, rec.ID, err))
+			continue
+		}
+		if used > 0 {
+			if _, err := s.DB.Exec(`INSERT INTO revoked_key_usage (user_id, server_id, bytes) VALUES (?, ?, ?)
+				ON CONFLICT (user_id, server_id) DO UPDATE SET bytes = bytes + excluded.bytes`, userID, rec.ID, used); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if _, err := s.DB.Exec("DELETE FROM access_keys WHERE user_id = ? AND server_id = ? AND device_id = ?",
+			userID, rec.ID, deviceID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	if _, err := s.DB.Exec("UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND device_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), userID, deviceID); err != nil {
+		return err
+	}
+	if _, err := s.DB.Exec("DELETE FROM devices WHERE user_id = ? AND id = ?", userID, deviceID); err != nil {
+		return err
+	}
+	log.Printf("[Devices] Revoked device %s of user %s and its %d keys", deviceID, userID, len(keys))
+	return nil
+}
+
+func (s *Server) handleListDevices(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	currentID := r.Header.Get("X-Device-ID")
+
+	rows, err := s.DB.Query(`SELECT d.id, d.name, d.created_at, d.last_seen_at,
+		(SELECT COUNT(*) FROM access_keys k WHERE k.user_id = d.user_id AND k.device_id = d.id)
+		FROM devices d WHERE d.user_id = ? ORDER BY d.last_seen_at DESC`, userID)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	devices := []Device{}
+	for rows.Next() {
+		var dev Device
+		if err := rows.Scan(&dev.ID, &dev.Name, &dev.CreatedAt, &dev.LastSeenAt, &dev.Keys); err != nil {
+			http.Error(w, "Database error", 500)
+			return
+		}
+		dev.Current = dev.ID == currentID
+		devices = append(devices, dev)
+	}
+	json.NewEncoder(w).Encode(devices)
+}
+
+func (s *Server) handleRenameDevice(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if err := validateText("name", req.Name, 64); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	res, err := s.DB.Exec("UPDATE devices SET name = ? WHERE user_id = ? AND id = ?", req.Name, userID, r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Device not found", 404)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleRevokeDevice revokes a device of the user, which may be the one the
+// request comes from.
+func (s *Server) handleRevokeDevice(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	id := r.PathValue("id")
+	var exists bool
+	if err := s.DB.QueryRow("SELECT COUNT(*) > 0 FROM devices WHERE user_id = ? AND id = ?", userID, id).Scan(&exists); err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	if !exists {
+		http.Error(w, "Device not found", 404)
+		return
+	}
+
+	err := s.revokeDevice(userID, id)
+	if errors.Is(err, errVPNUnavailable) {
+		log.Printf("[Devices] Failed to revoke device %s of user %s: %v", id, userID, err)
+		http.Error(w, "VPN server unavailable, try again later", 502)
+		return
+	} else if err != nil {
+		log.Printf("[Devices] Failed to revoke device %s of user %s: %v", id, userID, err)
+		http.Error(w, "Database error", 500)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Family plans: a paid plan with more than one seat lets its owner invite
+// other accounts, which get the owner's plan as long as the owner has it.
+// Members log in with their own account and get their own keys and data
+// allowance; only the payment is shared. A member's plan is kept in
+// users.plan like any other, without an expiry, so renewals and expiry only
+// ever act on the owner's; applyPlanLimits passes the owner's plan on.
+
+// familyInviteTTL is how long an invitation can be accepted for.
+const familyInviteTTL = 7 * 24 * time.Hour
+
+var (
+	errNoFamilySeats   = errors.New("plan has no family seats")
+	errFamilyFull      = errors.New("no free family seats")
+	errInviteInvalid   = errors.New("invalid or expired invitation")
+	errCannotJoin      = errors.New("account can't join a family")
+	errAlreadyInFamily = errors.New("account already in a family")
+)
+
+// FamilyMember is an account sharing a family plan. ID identifies the
+// membership, not the account: user IDs are never shown to other users.
+type FamilyMember struct {
+	ID       string    `json:"id"`
+	Email    string    `json:"email"`
+	JoinedAt time.Time `json:"joined_at"`
+	Current  bool      `json:"current"` // The authenticated user
+}
+
+// FamilyInvite is an invitation not accepted yet.
+type FamilyInvite struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Family is the family of the authenticated user: the members and pending
+// invitations of their plan if they own one, or the owner whose plan they
+// share.
+type Family struct {
+	// Seats is how many accounts, the owner's included, the plan covers.
+	Seats int `json:"seats"`
+	// Owner is the email of the owner, for members.
+	Owner   string         `json:"owner,omitempty"`
+	Members []FamilyMember `json:"members"`
+	Invites []FamilyInvite `json:"invites"`
+}
+
+// planSeats returns how many accounts a plan covers.
+func planSeats(plan string) int {
+	return max(paidPlans[plan].Seats, 1)
+}
+
+// familyOwner returns the owner of the family the user shares a plan with,
+// or "" if they don't. Members who bought a plan of their own keep it and
+// don't share the owner's.
+func (s *Server) familyOwner(userID string) (string, error) {
+	var ownerID string
+	err := s.DB.QueryRow("SELECT family_owner_id FROM users WHERE id = ? AND expiry_date IS NULL", userID).Scan(&ownerID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return ownerID, err
+}
+
+// syncFamily passes plan, the plan of ownerID, on to their members and
+// applies it to the members' keys. Members get the free plan while the owner
+// has no family plan, and theirs back once the owner buys one again.
+func (s *Server) syncFamily(ownerID, plan string) {
+	if planSeats(plan) <= 1 {
+		plan = "free"
+	}
+	rows, err := s.DB.Query(`UPDATE users SET plan = ? WHERE family_owner_id = ? AND expiry_date IS NULL RETURNING id`, plan, ownerID)
+	if err != nil {
+		log.Printf("[Family] Failed to pass plan of user %s on to their family: %v", ownerID, err)
+		return
+	}
+	var members []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			members = append(members, id)
+		}
+	}
+	rows.Close()
+	for _, id := range members {
+		s.applyPlanLimits(id)
+	}
+}
+
+// family returns the family of the user.
+func (s *Server) family(userID string, now time.Time) (*Family, error) {
+	var plan string
+	if err := s.DB.QueryRow("SELECT plan FROM users WHERE id = ?", userID).Scan(&plan); err != nil {
+		return nil, err
+	}
+	ownerID, err := s.familyOwner(userID)
+	if err != nil {
+		return nil, err
+	}
+	f := &Family{Seats: planSeats(plan), Members: []FamilyMember{}, Invites: []FamilyInvite{}}
+	selfID := userID
+	if ownerID != "" {
+		if err := s.DB.QueryRow("SELECT email FROM users WHERE id = ?", ownerID).Scan(&f.Owner); err != nil {
+			return nil, err
+		}
+		userID = ownerID
+	}
+
+	rows, err := s.DB.Query("SELECT id, family_member_id, email, family_joined_at FROM users WHERE family_owner_id = ? ORDER BY family_joined_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m FamilyMember
+		var memberUserID string
+		if err := rows.Scan(&memberUserID, &m.ID, &m.Email, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		m.Current = memberUserID == selfID
+		f.Members = append(f.Members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Members see who they share the plan with, but not the invitations.
+	if ownerID != "" {
+		return f, nil
+	}
+
+	rows, err = s.DB.Query("SELECT id, email, created_at, expires_at FROM family_invites WHERE owner_id = ? AND accepted_at IS NULL AND expires_at > ? ORDER BY created_at",
+		userID, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var inv FamilyInvite
+		if err := rows.Scan(&inv.ID, &inv.Email, &inv.CreatedAt, &inv.ExpiresAt); err != nil {
+			return nil, err
+		}
+		f.Invites = append(f.Invites, inv)
+	}
+	return f, rows.Err()
+}
+
+// seatsTaken returns how many seats of the owner's plan are taken by members
+// and pending invitations, not counting the owner's own.
+func (s *Server) seatsTaken(ownerID string, now time.Time) (int, error) {
+	var n int
+	err := s.DB.QueryRow(`SELECT (SELECT COUNT(*) FROM users WHERE family_owner_id = ?) +
+		(SELECT COUNT(*) FROM family_invites WHERE owner_id = ? AND accepted_at IS NULL AND expires_at > ?)`,
+		ownerID, ownerID, now).Scan(&n)
+	return n, err
+}
+
+// inviteToFamily creates an invitation of email to the owner's family and
+// returns it with its token. The caller holds the owner's lock.
+func (s *Server) inviteToFamily(ownerID, email string, now time.Time) (*FamilyInvite, string, error) {
+	var plan, ownerEmail string
+	if err := s.DB.QueryRow("SELECT plan, email FROM users WHERE id = ?", ownerID).Scan(&plan, &ownerEmail); err != nil {
+		return nil, "", err
+	}
+	if inherited, err := s.familyOwner(ownerID); err != nil {
+		return nil, "", err
+	} else if inherited != "" || planSeats(plan) <= 1 {
+		return nil, "", errNoFamilySeats
+	}
+	if strings.EqualFold(email, ownerEmail) {
+		return nil, "", errAlreadyInFamily
+	}
+	var member int
+	if err := s.DB.QueryRow("SELECT 1 FROM users WHERE family_owner_id = ? AND email = ?", ownerID, email).Scan(&member); err == nil {
+		return nil, "", errAlreadyInFamily
+	}
+	// A new invitation replaces an earlier one to the same address.
+	if _, err := s.DB.Exec("DELETE FROM family_invites WHERE owner_id = ? AND email = ? AND accepted_at IS NULL", ownerID, email); err != nil {
+		return nil, "", err
+	}
+	taken, err := s.seatsTaken(ownerID, now)
+	if err != nil {
+		return nil, "", err
+	}
+	if taken >= planSeats(plan)-1 {
+		return nil, "", errFamilyFull
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", err
+	}
+	token := hex.EncodeToString(buf)
+	inv := &FamilyInvite{ID: uuid.New().String(), Email: email, CreatedAt: now, ExpiresAt: now.Add(familyInviteTTL)}
+	if _, err := s.DB.Exec("INSERT INTO family_invites (id, token_hash, owner_id, email, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		inv.ID, hashToken(token), ownerID, email, inv.CreatedAt, inv.ExpiresAt); err != nil {
+		return nil, "", err
+	}
+	return inv, token, nil
+}
+
+// joinFamily makes the user a member of the family the invitation with token
+// is from, and returns its owner.
+func (s *Server) joinFamily(userID, token string, now time.Time) (string, error) {
+	var inviteID, ownerID, email string
+	var expiresAt time.Time
+	err := s.DB.QueryRow("SELECT id, owner_id, email, expires_at FROM family_invites WHERE token_hash = ? AND accepted_at IS NULL",
+		hashToken(token)).Scan(&inviteID, &ownerID, &email, &expiresAt)
+	if err == sql.ErrNoRows || (err == nil && !now.Before(expiresAt)) {
+		return "", errInviteInvalid
+	} else if err != nil {
+		return "", err
+	}
+
+	unlock := s.lockUser(ownerID)
+	defer unlock()
+
+	var userEmail, userPlan, userOwner string
+	if err := s.DB.QueryRow("SELECT email, plan, family_owner_id FROM users WHERE id = ?", userID).Scan(&userEmail, &userPlan, &userOwner); err != nil {
+		return "", err
+	}
+	// Invitations are for the address they were sent to.
+	if !strings.EqualFold(userEmail, email) {
+		return "", errInviteInvalid
+	}
+	if userOwner != "" {
+		return "", errAlreadyInFamily
+	}
+	// Users with a plan of their own would lose it, and families don't nest.
+	var members int
+	if err := s.DB.QueryRow("SELECT COUNT(*) FROM users WHERE family_owner_id = ?", userID).Scan(&members); err != nil {
+		return "", err
+	}
+	if userID == ownerID || userPlan != "free" || members > 0 {
+		return "", errCannotJoin
+	}
+
+	var ownerPlan string
+	if err := s.DB.QueryRow("SELECT plan FROM users WHERE id = ?", ownerID).Scan(&ownerPlan); err != nil {
+		return "", err
+	}
+	var joined int
+	if err := s.DB.QueryRow("SELECT COUNT(*) FROM users WHERE family_owner_id = ?", ownerID).Scan(&joined); err != nil {
+		return "", err
+	}
+	if planSeats(ownerPlan) <= 1 {
+		return "", errNoFamilySeats
+	}
+	if joined >= planSeats(ownerPlan)-1 {
+		return "", errFamilyFull
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE family_invites SET accepted_at = ? WHERE id = ? AND accepted_at IS NULL", now, inviteID)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", errInviteInvalid
+	}
+	if _, err := tx.Exec("UPDATE users SET family_owner_id = ?, family_member_id = ?, family_joined_at = ?, plan = ?, expiry_date = NULL, next_plan = '' WHERE id = ?",
+		ownerID, uuid.New().String(), now, ownerPlan, userID); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	log.Printf("[Family] User %s joined the family of user %s", userID, ownerID)
+	go s.applyPlanLimits(userID)
+	return ownerID, nil
+}
+
+// leaveFamily removes a member from the owner's family. They are back on
+// the free plan unless they bought one of their own meanwhile.
+func (s *Server) leaveFamily(ownerID, memberID string) (bool, error) {
+	res, err := s.DB.Exec(`UPDATE users SET family_owner_id = '', family_member_id = '', family_joined_at = NULL,
+		plan = CASE WHEN expiry_date IS NULL THEN 'free' ELSE plan END
+		WHERE id = ? AND family_owner_id = ?`, memberID, ownerID)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	log.Printf("[Family] User %s left the family of user %s", memberID, ownerID)
+	go s.applyPlanLimits(memberID)
+	return true, nil
+}
+
+func (s *Server) handleGetFamily(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	f, err := s.family(userID, time.Now().UTC())
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	json.NewEncoder(w).Encode(f)
+}
+
+// handleFamilyInvite emails an invitation to join the user's family plan.
+// It holds a seat until it is accepted or expires.
+func (s *Server) handleFamilyInvite(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	var req struct {
+		Email string `json:"email"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	email := strings.TrimSpace(req.Email)
+	if err := validateEmail(email); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	unlock := s.lockUser(userID)
+	inv, token, err := s.inviteToFamily(userID, email, time.Now().UTC())
+	unlock()
+	switch {
+	case errors.Is(err, errNoFamilySeats):
+		http.Error(w, "Your plan has no family seats", 409)
+		return
+	case errors.Is(err, errFamilyFull):
+		http.Error(w, "All family seats are taken", 409)
+		return
+	case errors.Is(err, errAlreadyInFamily):
+		http.Error(w, "Already in your family", 409)
+		return
+	case err != nil:
+		log.Printf("[Family] Failed to invite %s to the family of user %s: %v", email, userID, err)
+		http.Error(w, "Database error", 500)
+		return
+	}
+
+	var ownerEmail string
+	s.DB.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&ownerEmail)
+	body := fmt.Sprintf("%s invited you to share their Dr. Frake VPN Premium plan.\n\n"+
+		"Log in to the app with this address, or create an account with it, and enter this invitation code on the Account page within 7 days:\n\n%s\n\n"+
+		"If you don't know %s, ignore this email.\n", ownerEmail, token, ownerEmail)
+	if err := s.Mailer.Send(email, "You're invited to Dr. Frake VPN Premium", body); err != nil {
+		log.Printf("[Family] Failed to send invitation to %s: %v", email, err)
+		s.DB.Exec("DELETE FROM family_invites WHERE id = ?", inv.ID)
+		http.Error(w, "Failed to send invitation email", 502)
+		return
+	}
+	log.Printf("[Family] User %s invited %s", userID, email)
+	json.NewEncoder(w).Encode(inv)
+}
+
+// handleFamilyAccept joins the family of an invitation sent to the user's
+// address.
+func (s *Server) handleFamilyAccept(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	var req struct {
+		Token string `json:"token"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	token := strings.TrimSpace(req.Token)
+	if token == "" || len(token) > 64 {
+		http.Error(w, "token is required", 400)
+		return
+	}
+
+	now := time.Now().UTC()
+	_, err := s.joinFamily(userID, token, now)
+	switch {
+	case errors.Is(err, errInviteInvalid):
+		http.Error(w, "Invalid or expired invitation", 404)
+		return
+	case errors.Is(err, errAlreadyInFamily):
+		http.Error(w, "You are already in a family, leave it first", 409)
+		return
+	case errors.Is(err, errCannotJoin):
+		http.Error(w, "Accounts with a plan of their own or a family can't join one", 409)
+		return
+	case errors.Is(err, errNoFamilySeats), errors.Is(err, errFamilyFull):
+		http.Error(w, "The family has no free seat", 409)
+		return
+	case err != nil:
+		log.Printf("[Family] Failed to add user %s to a family: %v", userID, err)
+		http.Error(w, "Database error", 500)
+		return
+	}
+	f, err := s.family(userID, now)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	json.NewEncoder(w).Encode(f)
+}
+
+// handleRemoveFamilyMember removes a member, by the ID of the membership,
+// from the user's family. With the user's own user or member ID it leaves the
+// family they are in instead.
+func (s *Server) handleRemoveFamilyMember(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	id := r.PathValue("id")
+	var ownerID, selfMemberID string
+	if err := s.DB.QueryRow("SELECT family_owner_id, family_member_id FROM users WHERE id = ?", userID).Scan(&ownerID, &selfMemberID); err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	memberID := userID
+	if id != userID && id != selfMemberID {
+		ownerID = userID
+		err := s.DB.QueryRow("SELECT id FROM users WHERE family_member_id = ? AND family_owner_id = ?", id, userID).Scan(&memberID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Member not found", 404)
+			return
+		} else if err != nil {
+			http.Error(w, "Database error", 500)
+			return
+		}
+	}
+	if ownerID == "" {
+		http.Error(w, "Member not found", 404)
+		return
+	}
+
+	unlock := s.lockUser(ownerID)
+	removed, err := s.leaveFamily(ownerID, memberID)
+	unlock()
+	if err != nil {
+		log.Printf("[Family] Failed to remove user %s from the family of user %s: %v", memberID, ownerID, err)
+		http.Error(w, "Database error", 500)
+		return
+	}
+	if !removed {
+		http.Error(w, "Member not found", 404)
+		return
+	}
+	f, err := s.family(userID, time.Now().UTC())
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	json.NewEncoder(w).Encode(f)
+}
+
+// handleCancelFamilyInvite withdraws an invitation, freeing its seat.
+func (s *Server) handleCancelFamilyInvite(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	res, err := s.DB.Exec("DELETE FROM family_invites WHERE id = ? AND owner_id = ? AND accepted_at IS NULL", r.PathValue("id"), userID)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Invitation not found", 404)
+		return
+	}
+	f, err := s.family(userID, time.Now().UTC())
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	json.NewEncoder(w).Encode(f)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Built-in flags. Other names can be created through the admin API, for
+// rolling out features gradually.
+const (
+	// FlagMaintenance answers every non-admin request with 503 while enabled.
+	FlagMaintenance = "maintenance"
+	// FlagRegistration is a kill switch for /register.
+	FlagRegistration = "registration"
+	// FlagPayments is a kill switch for starting new payments. Checks of
+	// existing payments and provider webhooks keep working.
+	FlagPayments = "payments"
+)
+
+// defaultFlags are the values of built-in flags that were never set.
+var defaultFlags = map[string]FeatureFlag{
+	FlagMaintenance:  {Name: FlagMaintenance, Enabled: false, Rollout: 100},
+	FlagRegistration: {Name: FlagRegistration, Enabled: true, Rollout: 100},
+	FlagPayments:     {Name: FlagPayments, Enabled: true, Rollout: 100},
+}
+
+// flagCacheTTL bounds how long a change made through another backend
+// instance takes to apply.
+const flagCacheTTL = 15 * time.Second
+
+var flagNameRe = regexp.MustCompile(`^[a-z0-9_.-]{1,64}$`)
+
+// FeatureFlag is a runtime switch stored in the database.
+type FeatureFlag struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	// Rollout is the percentage of clients an enabled flag applies to.
+	Rollout int `json:"rollout"`
+	// Message is shown to clients a flag turns away, such as during maintenance.
+	Message   string     `json:"message,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// FlagStore caches the feature_flags table for the request middleware.
+type FlagStore struct {
+	db *sql.DB
+
+	mu       sync.Mutex
+	flags    map[string]FeatureFlag
+	loadedAt time.Time
+}
+
+func NewFlagStore(db *sql.DB) *FlagStore {
+	return &FlagStore{db: db}
+}
+
+// Get returns a flag, falling back to its default. ok is false for unknown
+// flags that were never set.
+func (f *FlagStore) Get(name string) (flag FeatureFlag, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.flags == nil || time.Since(f.loadedAt) > flagCacheTTL {
+		if err := f.loadLocked(); err != nil {
+			log.Printf("[Flags] Failed to load flags: %v", err)
+		}
+	}
+	if flag, ok := f.flags[name]; ok {
+		return flag, true
+	}
+	flag, ok = defaultFlags[name]
+	return flag, ok
+}
+
+// Enabled reports whether a flag applies to subject, such as a user ID or
+// client IP. With a rollout below 100%, a subject consistently falls on the
+// same side of the split.
+func (f *FlagStore) Enabled(name, subject string) bool {
+	flag, ok := f.Get(name)
+	if !ok || !flag.Enabled {
+		return false
+	}
+	if flag.Rollout >= 100 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + subject))
+	return int(h.Sum32()%100) < flag.Rollout
+}
+
+// List returns all flags, built-in ones included, sorted by name.
+func (f *FlagStore) List() ([]FeatureFlag, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.loadLocked(); err != nil {
+		return nil, err
+	}
+	all := make(map[string]FeatureFlag)
+	for name, flag := range defaultFlags {
+		all[name] = flag
+	}
+	for name, flag := range f.flags {
+		all[name] = flag
+	}
+	flags := make([]FeatureFlag, 0, len(all))
+	for _, flag := range all {
+		flags = append(flags, flag)
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags, nil
+}
+
+// Set stores a flag; it applies to this instance immediately.
+func (f *FlagStore) Set(flag FeatureFlag) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.db.Exec(`INSERT INTO feature_flags (name, enabled, rollout, message, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET enabled = excluded.enabled, rollout = excluded.rollout,
+		message = excluded.message, updated_at = excluded.updated_at`,
+		flag.Name, flag.Enabled, flag.Rollout, flag.Message, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return f.loadLocked()
+}
+
+// Delete removes a flag, so a built-in flag returns to its default.
+func (f *FlagStore) Delete(name string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res, err := f.db.Exec("DELETE FROM feature_flags WHERE name = ?", name)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, f.loadLocked()
+}
+
+func (f *FlagStore) loadLocked() error {
+	rows, err := f.db.Query("SELECT name, enabled, rollout, message, updated_at FROM feature_flags")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	flags := make(map[string]FeatureFlag)
+	for rows.Next() {
+		var flag FeatureFlag
+		var updatedAt time.Time
+		if err := rows.Scan(&flag.Name, &flag.Enabled, &flag.Rollout, &flag.Message, &updatedAt); err != nil {
+			return err
+		}
+		flag.UpdatedAt = &updatedAt
+		flags[flag.Name] = flag
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	f.flags = flags
+	f.loadedAt = time.Now()
+	return nil
+}
+
+// withFlags applies maintenance mode and the kill switches to all routes.
+// Admin routes, metrics, probes and payment provider webhooks are never
+// blocked, so operators can turn maintenance off again, orchestrators don't
+// restart the backend and no payment is lost.
+func (s *Server) withFlags(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if strings.HasPrefix(path, "/admin/") || path == "/metrics" || path == "/healthz" || path == "/readyz" ||
+			path == "/payment/webhook" || s.isAdmin(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Rollouts split signed-in users by ID, so they stay on one side
+		// across networks, and everyone else by the client's address.
+		subject := clientIP(r)
+		if userID, ok := s.authUser(r); ok {
+			subject = userID
+		}
+		switch {
+		case s.Flags.Enabled(FlagMaintenance, subject):
+			s.flagUnavailable(w, FlagMaintenance, "maintenance", "The service is under maintenance, please try again later.")
+		case path == "/register" && !s.Flags.Enabled(FlagRegistration, subject):
+			s.flagUnavailable(w, FlagRegistration, "registration_disabled", "Registration is temporarily closed.")
+		case path == "/payment/init" && !s.Flags.Enabled(FlagPayments, subject):
+			s.flagUnavailable(w, FlagPayments, "payments_disabled", "Payments are temporarily unavailable, please try again later.")
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// flagUnavailable writes the 503 JSON body clients show to users, using the
+// flag's message if the operator set one.
+func (s *Server) flagUnavailable(w http.ResponseWriter, name, code, message string) {
+	if flag, _ := s.Flags.Get(name); flag.Message != "" {
+		message = flag.Message
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", "300")
+	w.WriteHeader(503)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "message": message})
+}
+
+func (s *Server) handleAdminListFlags(w http.ResponseWriter, r *http.Request) {
+	flags, err := s.Flags.List()
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	json.NewEncoder(w).Encode(flags)
+}
+
+func (s *Server) handleAdminSetFlag(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !flagNameRe.MatchString(name) {
+		http.Error(w, "Invalid flag name, use a-z, 0-9, '_', '.' and '-'", 400)
+		return
+	}
+	var req struct {
+		Enabled *bool  `json:"enabled"`
+		Rollout *int   `json:"rollout"`
+		Message string `json:"message"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Enabled == nil {
+		http.Error(w, "enabled is required", 400)
+		return
+	}
+	if err := validateText("message", req.Message, maxTextLen); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	flag := FeatureFlag{Name: name, Enabled: *req.Enabled, Rollout: 100, Message: req.Message}
+	if req.Rollout != nil {
+		if *req.Rollout < 0 || *req.Rollout > 100 {
+			http.Error(w, "Rollout must be between 0 and 100", 400)
+			return
+		}
+		flag.Rollout = *req.Rollout
+	}
+	if err := s.Flags.Set(flag); err != nil {
+		http.Error(w, "Database error: "+err.Error(), 500)
+		return
+	}
+	log.Printf("[Admin] Flag %s set: enabled=%t rollout=%d%%", name, flag.Enabled, flag.Rollout)
+	flag, _ = s.Flags.Get(name)
+	json.NewEncoder(w).Encode(flag)
+}
+
+func (s *Server) handleAdminDeleteFlag(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	found, err := s.Flags.Delete(name)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), 500)
+		return
+	}
+	if !found {
+		http.Error(w, "Flag not set", 404)
+		return
+	}
+	log.Printf("[Admin] Flag %s reset", name)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": name})
+}
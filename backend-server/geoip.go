@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrPrivateIP is returned for addresses that can't be geolocated.
+var ErrPrivateIP = errors.New("private or loopback address")
+
+// GeoInfo is the location of an IP address.
+type GeoInfo struct {
+	Country     string  `json:"country"`
+	CountryCode string  `json:"country_code"`
+	City        string  `json:"city"`
+	Lat         float64 `json:"lat"`
+	Lon         float64 `json:"lon"`
+}
+
+// GeoLocator resolves IP addresses to locations.
+type GeoLocator interface {
+	Lookup(ip string) (*GeoInfo, error)
+}
+
+// IPAPILocator looks up addresses with the ip-api.com JSON API and caches the
+// results, since the free tier is rate limited.
+type IPAPILocator struct {
+	baseURL    string
+	ttl        time.Duration
+	httpClient *http.Client
+
+	mu    sync.Mutex
+	cache map[string]geoCacheEntry
+}
+
+type geoCacheEntry struct {
+	info    *GeoInfo
+	expires time.Time
+}
+
+// NewIPAPILocator creates a locator using the given ip-api compatible
+// endpoint, or http://ip-api.com/json/ if empty.
+func NewIPAPILocator(baseURL string) *IPAPILocator {
+	if baseURL == "" {
+		baseURL = "http://ip-api.com/json/"
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return &IPAPILocator{
+		baseURL:    baseURL,
+		ttl:        24 * time.Hour,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		cache:      make(map[string]geoCacheEntry),
+	}
+}
+
+func (l *IPAPILocator) Lookup(ip string) (*GeoInfo, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil, fmt.Errorf("invalid IP address %q", ip)
+	}
+	if parsed.IsLoopback() || parsed.IsPrivate() || parsed.IsLinkLocalUnicast() || parsed.IsUnspecified() {
+		return nil, ErrPrivateIP
+	}
+
+	l.mu.Lock()
+	entry, ok := l.cache[ip]
+	l.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.info, nil
+	}
+
+	resp, err := l.httpClient.Get(l.baseURL + ip + "?fields=status,message,country,countryCode,city,lat,lon")
+	if err != nil {
+		return nil, fmt.Errorf("geoip lookup failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("geoip lookup failed: %s", resp.Status)
+	}
+
+	var result struct {
+		Status      string  `json:"status"`
+		Message     string  `json:"message"`
+		Country     string  `json:"country"`
+		CountryCode string  `json:"countryCode"`
+		City        string  `json:"city"`
+		Lat         float64 `json:"lat"`
+		Lon         float64 `json:"lon"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("geoip lookup failed: %w", err)
+	}
+	if result.Status != "success" {
+		return nil, fmt.Errorf("geoip lookup of %s failed: %s", ip, result.Message)
+	}
+
+	info := &GeoInfo{
+		Country:     result.Country,
+		CountryCode: result.CountryCode,
+		City:        result.City,
+		Lat:         result.Lat,
+		Lon:         result.Lon,
+	}
+	l.mu.Lock()
+	l.cache[ip] = geoCacheEntry{info: info, expires: time.Now().Add(l.ttl)}
+	l.mu.Unlock()
+	return info, nil
+}
+
+// clientIP returns the address of the client. X-Forwarded-For is only
+// trusted when the request comes from a reverse proxy on a private network,
+// and then read from the right: each proxy appends the address it got the
+// request from, while everything left of them is whatever the client sent.
+// The first address that isn't a proxy on a private network is the client.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isProxyAddr(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(hops[i])
+		if net.ParseIP(ip) == nil {
+			break
+		}
+		host = ip
+		if !isProxyAddr(ip) {
+			break
+		}
+	}
+	return host
+}
+
+// isProxyAddr reports whether ip may be a reverse proxy in front of the
+// backend, which only ever run on the same host or a private network.
+func isProxyAddr(ip string) bool {
+	addr := net.ParseIP(ip)
+	return addr != nil && (addr.IsLoopback() || addr.IsPrivate())
+}
+
+// countryFlag returns the flag emoji of an ISO country code, e.g. 🇳🇱 for "NL".
+func countryFlag(code string) string {
+	if len(code) != 2 {
+		return ""
+	}
+	var flag []rune
+	for _, c := range strings.ToUpper(code) {
+		if c < 'A' || c > 'Z' {
+			return ""
+		}
+		flag = append(flag, 0x1F1E6+(c-'A'))
+	}
+	return string(flag)
+}
+
+// lookupHost geolocates a hostname or IP address, using its first resolved address.
+func lookupHost(geo GeoLocator, host string) (*GeoInfo, error) {
+	if net.ParseIP(host) == nil {
+		addrs, err := net.LookupHost(host)
+		if err != nil {
+			return nil, err
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("%s has no addresses", host)
+		}
+		host = addrs[0]
+	}
+	return geo.Lookup(host)
+}
+
+// flagCountryCode returns the ISO country code encoded in a flag emoji, e.g.
+// "NL" for 🇳🇱, or "" if flag isn't one.
+func flagCountryCode(flag string) string {
+	runes := []rune(strings.TrimSpace(flag))
+	if len(runes) != 2 {
+		return ""
+	}
+	const regionalA = 0x1F1E6
+	code := make([]rune, 2)
+	for i, r := range runes {
+		if r < regionalA || r > regionalA+25 {
+			return ""
+		}
+		code[i] = 'A' + (r - regionalA)
+	}
+	return string(code)
+}
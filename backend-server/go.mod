@@ -1,21 +1,33 @@
 module drfrake-backend
 
-go 1.21
+go 1.25.0
 
 require (
+	drfrake-core v0.0.0-00010101000000-000000000000
 	github.com/google/uuid v1.6.0
+	github.com/spf13/cobra v1.8.1
+	golang.getoutline.org/sdk v0.0.21
+	golang.org/x/crypto v0.48.0
 	modernc.org/sqlite v1.28.0
 )
 
 require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/goccy/go-yaml v1.17.1 // indirect
+	github.com/gorilla/websocket v1.5.3 // indirect
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51 // indirect
 	github.com/mattn/go-isatty v0.0.16 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
-	golang.org/x/mod v0.3.0 // indirect
-	golang.org/x/sys v0.9.0 // indirect
-	golang.org/x/tools v0.0.0-20201124115921-2c860bdd6e78 // indirect
-	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+	github.com/shadowsocks/go-shadowsocks2 v0.1.5 // indirect
+	github.com/spf13/pflag v1.0.5 // indirect
+	github.com/things-go/go-socks5 v0.0.5 // indirect
+	golang.getoutline.org/sdk/x v0.1.0 // indirect
+	golang.org/x/mod v0.33.0 // indirect
+	golang.org/x/net v0.50.0 // indirect
+	golang.org/x/sync v0.19.0 // indirect
+	golang.org/x/sys v0.41.0 // indirect
+	golang.org/x/tools v0.42.0 // indirect
 	lukechampine.com/uint128 v1.2.0 // indirect
 	modernc.org/cc/v3 v3.40.0 // indirect
 	modernc.org/ccgo/v3 v3.16.13 // indirect
@@ -26,3 +38,9 @@ require (
 	modernc.org/strutil v1.1.3 // indirect
 	modernc.org/token v1.0.1 // indirect
 )
+
+replace golang.getoutline.org/sdk => ../
+
+replace golang.getoutline.org/sdk/x => ../x
+
+replace drfrake-core => ../x/core
@@ -3,8 +3,12 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -17,6 +21,8 @@ type RegisterRequest struct {
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// Device is a human readable name shown in the session list, e.g. "Windows PC".
+	Device string `json:"device,omitempty"`
 }
 
 type AuthResponse struct {
@@ -36,26 +42,45 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var req RegisterRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Bad request", 400)
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if err := firstError(validateEmail(req.Email), validatePassword(req.Password)); err != nil {
+		http.Error(w, err.Error(), 400)
 		return
 	}
 
 	// TODO: Hash password! For demo, plaintext (bad practice but simple for now)
 	id := uuid.New().String()
-	_, err := s.DB.Exec("INSERT INTO users (id, email, password, plan) VALUES (?, ?, ?, ?)", id, req.Email, req.Password, "free")
+	_, err := s.DB.Exec("INSERT INTO users (id, email, password, plan, quota_reset_at, legacy_token_revoked) VALUES (?, ?, ?, ?, ?, 1)",
+		id, req.Email, req.Password, "free", addMonths(time.Now(), 1).UTC())
 	if err != nil {
 		http.Error(w, "User exists or error", 500)
 		return
 	}
 
+	s.emitEvent(EventUserRegistered, map[string]string{"user_id": id, "email": req.Email})
+
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": id})
 }
 
 func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	var req LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Bad request", 400)
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email == "" || req.Password == "" {
+		http.Error(w, "Email and password are required", 400)
+		return
+	}
+	if err := validateText("Device", req.Device, 64); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if _, _, err := requestDevice(r); err != nil {
+		http.Error(w, err.Error(), 400)
 		return
 	}
 
@@ -67,17 +92,22 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Simple token = UserID for demo. Real world: JWT.
+	token, err := s.createSession(user.ID, req.Device, r)
+	if err != nil {
+		log.Printf("Failed to create session for user %s: %v", user.ID, err)
+		http.Error(w, "Database error", 500)
+		return
+	}
 	resp := AuthResponse{
-		Token: user.ID,
+		Token: token,
 		User:  user,
 	}
 	json.NewEncoder(w).Encode(resp)
 }
 
 func (s *Server) handleGetServers(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("Authorization")
-	if token == "" {
+	token, ok := s.authUser(r)
+	if !ok {
 		http.Error(w, "Unauthorized", 401)
 		return
 	}
@@ -90,144 +120,291 @@ func (s *Server) handleGetServers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get all active servers
-	rows, err := s.DB.Query(`SELECT id, api_url, cert_sha256, country, city, flag, is_premium,
-		type, server_host, xray_inbound_id, xray_panel_url, xray_username, xray_password, xray_settings
-		FROM servers`)
+	deviceID, deviceName, err := requestDevice(r)
 	if err != nil {
-		http.Error(w, "Database error", 500)
+		http.Error(w, err.Error(), 400)
 		return
 	}
-	defer rows.Close()
+	var dev *Device
+	if deviceID != "" {
+		d, err := s.registerDevice(token, deviceID, deviceName)
+		if errors.Is(err, errTooManyDevices) {
+			http.Error(w, fmt.Sprintf("Device limit of %d reached, revoke a device first", s.Cfg.MaxDevices), 403)
+			return
+		} else if err != nil {
+			log.Printf("[Devices] Failed to register device %s of user %s: %v", deviceID, token, err)
+			http.Error(w, "Database error", 500)
+			return
+		}
+		dev = &d
+	}
 
-	var servers []map[string]interface{}
+	servers, err := s.userServers(token, plan, dev)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	json.NewEncoder(w).Encode(servers)
+}
 
+// userServers returns the servers in rotation with the user's access config
+// for each, creating keys on servers the user has none on yet. Servers a key
+// can't be created on, e.g. because they are full, are left out. Premium
+// servers the plan doesn't cover are listed as locked, without a config: this
+// is what gates them, as clients can be changed to skip their own checks.
+// The keys are the ones of dev, or the account's if it is nil.
+func (s *Server) userServers(userID, plan string, dev *Device) ([]map[string]interface{}, error) {
+	// Get all active servers. Rows are read up front: SQLite can't insert the
+	// new access keys below while the query is still open.
+	rows, err := s.DB.Query(`SELECT id, api_url, cert_sha256, country, city, flag, is_premium,
+		type, server_host, xray_inbound_id, xray_panel_url, xray_username, xray_password, xray_settings, tags
+		FROM servers WHERE archived = 0`)
+	if err != nil {
+		return nil, err
+	}
+	type serverRow struct {
+		rec                 serverRecord
+		country, city, flag string
+		isPremium           bool
+		tags                []string
+	}
+	var serverRows []serverRow
 	for rows.Next() {
-		var srvID, apiURL, cert, country, city, flag string
-		var isPremium bool
-		var srvType, serverHost, xrayPanelURL, xrayUsername, xrayPassword, xraySettings string
-		var xrayInboundID int
-		if err := rows.Scan(&srvID, &apiURL, &cert, &country, &city, &flag, &isPremium,
-			&srvType, &serverHost, &xrayInboundID, &xrayPanelURL, &xrayUsername, &xrayPassword, &xraySettings); err != nil {
+		var row serverRow
+		rec := &row.rec
+		var tags string
+		if err := rows.Scan(&rec.ID, &rec.APIURL, &rec.CertSHA256, &row.country, &row.city, &row.flag, &row.isPremium,
+			&rec.Type, &rec.ServerHost, &rec.XrayInboundID, &rec.XrayPanelURL, &rec.XrayUsername, &rec.XrayPassword, &rec.XraySettings, &tags); err != nil {
 			log.Printf("Error scanning server row: %v", err)
 			continue
 		}
+		row.tags = parseTags(tags)
+		serverRows = append(serverRows, row)
+	}
+	rows.Close()
 
-		// Check/Create Access Key
-		var keyID, accessURL string
-		err := s.DB.QueryRow("SELECT key_id, access_url FROM access_keys WHERE user_id = ? AND server_id = ?", token, srvID).Scan(&keyID, &accessURL)
+	deviceID := ""
+	var device map[string]interface{}
+	if dev != nil {
+		deviceID = dev.ID
+		device = map[string]interface{}{"id": dev.ID, "name": dev.Name, "created_at": dev.CreatedAt}
+	}
 
-		if err == sql.ErrNoRows {
-			// Create provider based on server type
-			var provider VPNProvider
-			switch ServerType(srvType) {
-			case ServerTypeXray:
-				provider = NewXrayProvider(xrayPanelURL, xrayUsername, xrayPassword, xrayInboundID, serverHost, xraySettings)
-			default:
-				provider = NewOutlineProvider(apiURL, cert)
-			}
+	var servers []map[string]interface{}
 
-			// Check if key already exists (idempotency)
-			var foundKeyID, foundKeyURL string
-			keys, listErr := provider.GetKeys()
-			if listErr == nil {
-				for _, k := range keys {
-					if k.Name == "user-"+token {
-						foundKeyID = k.ID
-						foundKeyURL = k.AccessURL
-						break
-					}
-				}
-			}
+	for _, row := range serverRows {
+		srvID, srvType := row.rec.ID, row.rec.Type
+
+		if row.isPremium && !planHasPremium(plan) {
+			servers = append(servers, map[string]interface{}{
+				"id":        srvID,
+				"country":   row.country,
+				"city":      row.city,
+				"flag":      row.flag,
+				"config":    "",
+				"isPremium": row.isPremium,
+				"locked":    true,
+				"type":      srvType,
+				"tags":      row.tags,
+			})
+			continue
+		}
 
-			// If not found, create new key
-			if foundKeyID == "" {
-				newID, newURL, createErr := provider.CreateKey(token)
-				if createErr != nil {
-					log.Printf("Failed to create key for user %s on server %s (%s): %v", token, srvID, srvType, createErr)
-					continue
-				}
-				foundKeyID = newID
-				foundKeyURL = newURL
-			}
+		// Check/Create Access Key
+		var keyID, accessURL string
+		err := s.DB.QueryRow("SELECT key_id, access_url FROM access_keys WHERE user_id = ? AND server_id = ? AND device_id = ?",
+			userID, srvID, deviceID).Scan(&keyID, &accessURL)
 
-			// Save to DB
-			_, dbErr := s.DB.Exec("INSERT INTO access_keys (user_id, server_id, key_id, access_url) VALUES (?, ?, ?, ?)",
-				token, srvID, foundKeyID, foundKeyURL)
-			if dbErr != nil {
-				log.Printf("DB Insert Warning (Key might exist): %v", dbErr)
+		if err == sql.ErrNoRows {
+			accessURL, err = s.issueKey(userID, deviceID, plan, row.rec)
+			if errors.Is(err, errServerFull) {
+				continue
+			} else if err != nil {
+				log.Printf("Failed to create key for user %s on server %s (%s): %v", userID, srvID, srvType, err)
+				continue
 			}
-
-			accessURL = foundKeyURL
 		} else if err != nil {
 			log.Printf("DB Error fetching key: %v", err)
 			continue
 		}
 
 		// Add to response
-		servers = append(servers, map[string]interface{}{
+		entry := map[string]interface{}{
 			"id":        srvID,
-			"country":   country,
-			"city":      city,
-			"flag":      flag,
+			"country":   row.country,
+			"city":      row.city,
+			"flag":      row.flag,
 			"config":    accessURL,
-			"isPremium": isPremium,
+			"isPremium": row.isPremium,
+			"locked":    false,
 			"type":      srvType,
-		})
+			"tags":      row.tags,
+		}
+		if device != nil {
+			entry["device"] = device
+		}
+		servers = append(servers, entry)
 	}
 
 	if servers == nil {
 		servers = []map[string]interface{}{}
 	}
-	json.NewEncoder(w).Encode(servers)
+	return servers, nil
+}
+
+// issueKey creates the access key of the user's device on a server and stores
+// it. It fails with errServerFull if the server reached its max_users, unless
+// the user is on it already with another device. Traffic
+// of the user's keys revoked on the server this quota period counts against
+// the new key's data cap.
+func (s *Server) issueKey(userID, deviceID, plan string, rec serverRecord) (string, error) {
+	unlock := s.lockServer(rec.ID)
+	defer unlock()
+	users, maxUsers, err := s.serverLoad(rec.ID)
+	if err != nil {
+		return "", err
+	}
+	if maxUsers > 0 && users >= maxUsers {
+		var onServer bool
+		if err := s.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM access_keys WHERE server_id = ? AND user_id = ?)",
+			rec.ID, userID).Scan(&onServer); err != nil {
+			return "", err
+		}
+		if !onServer {
+			return "", errServerFull
+		}
+	}
+
+	provider := s.provider(rec)
+
+	// Check if key already exists (idempotency)
+	var foundKeyID, foundKeyURL string
+	existing, listErr := provider.GetKeys()
+	if listErr == nil {
+		for _, k := range existing {
+			if k.Name == "user-"+keyOwner(userID, deviceID) {
+				foundKeyID = k.ID
+				foundKeyURL = k.AccessURL
+				break
+			}
+		}
+	}
+
+	// If not found, create new key
+	if foundKeyID == "" {
+		newID, newURL, err := provider.CreateKey(keyOwner(userID, deviceID))
+		if err != nil {
+			return "", err
+		}
+		foundKeyID = newID
+		foundKeyURL = newURL
+	}
+	if err := validateAccessConfig(foundKeyURL); err != nil {
+		return "", fmt.Errorf("%w: provider returned an invalid key %s: %v", errVPNUnavailable, foundKeyID, err)
+	}
+
+	var offset int64
+	s.DB.QueryRow("SELECT bytes FROM revoked_key_usage WHERE user_id = ? AND server_id = ?", userID, rec.ID).Scan(&offset)
+	if err := provider.SetDataLimit(foundKeyID, keyDataLimit(s.keyPlan(userID, plan), offset)); err != nil {
+		log.Printf("Failed to set data limit for user %s on server %s: %v", userID, rec.ID, err)
+	}
+
+	// Save to DB
+	_, dbErr := s.DB.Exec(`INSERT INTO access_keys (user_id, server_id, device_id, key_id, access_url, usage_offset, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, userID, rec.ID, deviceID, foundKeyID, foundKeyURL, offset, time.Now().UTC())
+	if dbErr != nil {
+		log.Printf("DB Insert Warning (Key might exist): %v", dbErr)
+	} else if offset > 0 {
+		s.DB.Exec("DELETE FROM revoked_key_usage WHERE user_id = ? AND server_id = ?", userID, rec.ID)
+	}
+	return foundKeyURL, nil
 }
 
 func (s *Server) handleAdminAddServer(w http.ResponseWriter, r *http.Request) {
-	// Simple validation - strictly for local/trusted usage now
-	var req struct {
-		APIURL     string `json:"api_url"`
-		CertSHA256 string `json:"cert_sha256"`
-		Country    string `json:"country"`
-		City       string `json:"city"`
-		Flag       string `json:"flag"`
-		IsPremium  bool   `json:"is_premium"`
-		// New fields for dual provider support
-		Type          string `json:"type"` // "outline" (default) or "xray"
-		ServerHost    string `json:"server_host"`
-		XrayPanelURL  string `json:"xray_panel_url"`
-		XrayUsername  string `json:"xray_username"`
-		XrayPassword  string `json:"xray_password"`
-		XrayInboundID int    `json:"xray_inbound_id"`
-		XraySettings  string `json:"xray_settings"` // JSON string with Reality params
+	var req ServerDefinition
+	if !decodeJSON(w, r, &req) {
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Bad request", 400)
+	req.ID = ""
+	req.setDefaults()
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), 400)
 		return
 	}
+	s.locateServer(&req)
 
-	// Defaults
-	if req.Type == "" {
-		req.Type = "outline"
+	req.ID = uuid.New().String()
+	if err := insertServer(s.DB, req); err != nil {
+		http.Error(w, "Database error: "+err.Error(), 500)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "ok",
+		"id":      req.ID,
+		"type":    req.Type,
+		"country": req.Country,
+		"city":    req.City,
+		"flag":    req.Flag,
+	})
+}
+
+// handleAdminSyncServer refreshes the Reality/TLS parameters of an Xray server
+// from its 3X-UI inbound and stores them in xray_settings.
+func (s *Server) handleAdminSyncServer(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var srvType, serverHost, panelURL, username, password, certSHA256, settingsJSON string
+	var inboundID int
+	err := s.DB.QueryRow(`SELECT type, server_host, xray_inbound_id, xray_panel_url, xray_username, xray_password, cert_sha256, xray_settings
+		FROM servers WHERE id = ?`, id).Scan(&srvType, &serverHost, &inboundID, &panelURL, &username, &password, &certSHA256, &settingsJSON)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Server not found", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
 	}
-	if req.XraySettings == "" {
-		req.XraySettings = "{}"
+	if ServerType(srvType) != ServerTypeXray {
+		http.Error(w, "Only xray servers can be synced", 400)
+		return
 	}
 
-	id := uuid.New().String()
-	_, err := s.DB.Exec(`INSERT INTO servers
-		(id, api_url, cert_sha256, country, city, flag, is_premium, type, server_host,
-		 xray_inbound_id, xray_panel_url, xray_username, xray_password, xray_settings)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		id, req.APIURL, req.CertSHA256, req.Country, req.City, req.Flag, req.IsPremium,
-		req.Type, req.ServerHost, req.XrayInboundID, req.XrayPanelURL,
-		req.XrayUsername, req.XrayPassword, req.XraySettings)
+	provider := NewXrayProvider(panelURL, username, password, certSHA256, inboundID, serverHost, settingsJSON)
+	settings, err := provider.DiscoverSettings()
+	if err != nil {
+		http.Error(w, "Sync failed: "+err.Error(), 502)
+		return
+	}
 
+	data, _ := json.Marshal(settings)
+	_, err = s.DB.Exec("UPDATE servers SET xray_settings = ?, xray_inbound_id = ? WHERE id = ?",
+		string(data), provider.InboundID(), id)
 	if err != nil {
 		http.Error(w, "Database error: "+err.Error(), 500)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": id, "type": req.Type})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     "ok",
+		"id":         id,
+		"inbound_id": provider.InboundID(),
+		"settings":   settings,
+	})
+}
+
+func (s *Server) handleAdminListBreakers(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(s.Breakers.Snapshot())
+}
+
+func (s *Server) handleAdminResetBreaker(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !s.Breakers.Reset(id) {
+		http.Error(w, "No breaker for server", 404)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": id})
 }
 
 func (s *Server) handleInitPayment(w http.ResponseWriter, r *http.Request) {
@@ -236,12 +413,33 @@ func (s *Server) handleInitPayment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token := r.Header.Get("Authorization")
-	if token == "" {
+	token, ok := s.authUser(r)
+	if !ok {
 		http.Error(w, "Unauthorized", 401)
 		return
 	}
 
+	var req struct {
+		Plan string `json:"plan"`
+		// Country is where the client says it is, used for regional
+		// pricing only if GeoIP can't place it.
+		Country string `json:"country"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if _, ok := paidPlans[req.Plan]; !ok {
+		http.Error(w, "Invalid plan", 400)
+		return
+	}
+	if req.Country != "" {
+		if err := validateCountryCode("country", req.Country); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
 	// Verify user
 	var plan string
 	err := s.DB.QueryRow("SELECT plan FROM users WHERE id = ?", token).Scan(&plan)
@@ -250,55 +448,78 @@ func (s *Server) handleInitPayment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		Plan string `json:"plan"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Bad request", 400)
+	if owner, err := s.familyOwner(token); err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	} else if owner != "" {
+		http.Error(w, "Your plan is shared by your family, leave it to buy your own", 409)
 		return
 	}
 
-	// Calculate amount based on plan
-	var amount, desc string
-	switch req.Plan {
-	case "monthly":
-		amount = "299.00"
-		desc = "Dr. Frake VPN — Premium Monthly"
-	case "yearly":
-		amount = "2990.00"
-		desc = "Dr. Frake VPN — Premium Yearly"
-	default:
-		http.Error(w, "Invalid plan", 400)
+	// Downgrades take effect when the current plan ends, without a payment now.
+	start, downgrade, err := s.downgradeStart(token, req.Plan, time.Now().UTC())
+	if err != nil {
+		http.Error(w, "Database error", 500)
 		return
 	}
-
-	returnURL := s.Cfg.YookassaReturnURL
-	if returnURL == "" {
-		returnURL = "https://google.com"
+	if downgrade {
+		if err := s.scheduleDowngrade(token, req.Plan); err != nil {
+			http.Error(w, "Database error", 500)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":       "scheduled",
+			"plan":         req.Plan,
+			"effective_at": start.Format(time.RFC3339),
+		})
+		return
 	}
 
-	// Call YooKassa API (server-side only!)
-	payResp, err := s.YooKassa.CreatePayment(amount, desc, token, req.Plan, returnURL)
+	payment, err := s.startPayment(token, req.Plan, s.paymentRegion(r, token, req.Country))
 	if err != nil {
 		http.Error(w, "Payment error: "+err.Error(), 500)
 		return
 	}
 
-	// Store payment in DB
-	s.DB.Exec("INSERT INTO payments (id, user_id, yookassa_id, amount, status) VALUES (?, ?, ?, ?, ?)",
-		payResp.ID, token, payResp.ID, amount, payResp.Status)
-
 	// Return confirmation URL to client
 	json.NewEncoder(w).Encode(map[string]string{
-		"id":               payResp.ID,
-		"status":           payResp.Status,
-		"confirmation_url": payResp.Confirmation.ConfirmationURL,
+		"id":               payment.ID,
+		"status":           payment.Status,
+		"confirmation_url": payment.ConfirmationURL,
+		"amount":           payment.Amount,
+		"region":           payment.Region,
 	})
 }
 
+// handlePendingPayment returns the user's unpaid payment, if any, so the app
+// can resume it instead of starting a new one.
+func (s *Server) handlePendingPayment(w http.ResponseWriter, r *http.Request) {
+	token, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	var plan string
+	if err := s.DB.QueryRow("SELECT plan FROM users WHERE id = ?", token).Scan(&plan); err != nil {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+
+	pending, err := s.pendingPayment(token, r.URL.Query().Get("plan"))
+	if err != nil {
+		http.Error(w, "Error checking payment: "+err.Error(), 500)
+		return
+	}
+	if pending == nil {
+		http.Error(w, "No pending payment", 404)
+		return
+	}
+	json.NewEncoder(w).Encode(pending)
+}
+
 func (s *Server) handleCheckPayment(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("Authorization")
-	if token == "" {
+	token, ok := s.authUser(r)
+	if !ok {
 		http.Error(w, "Unauthorized", 401)
 		return
 	}
@@ -310,7 +531,7 @@ func (s *Server) handleCheckPayment(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check payment status from YooKassa
-	payResp, err := s.YooKassa.GetPayment(paymentID)
+	payResp, err := s.Payments.GetPayment(paymentID)
 	if err != nil {
 		http.Error(w, "Error checking payment: "+err.Error(), 500)
 		return
@@ -318,12 +539,14 @@ func (s *Server) handleCheckPayment(w http.ResponseWriter, r *http.Request) {
 
 	// If payment succeeded, upgrade user
 	if payResp.Status == "succeeded" {
-		tier := payResp.Metadata.Tier
-		if tier == "" {
-			tier = "monthly"
+		if payResp.Metadata.UserID == "" {
+			payResp.Metadata.UserID = token
+		}
+		if err := s.applyPaymentStatus(payResp); err != nil {
+			log.Printf("[Payments] Failed to apply payment %s: %v", paymentID, err)
+			http.Error(w, "Database error", 500)
+			return
 		}
-		s.DB.Exec("UPDATE users SET plan = ? WHERE id = ?", tier, token)
-		s.DB.Exec("UPDATE payments SET status = ? WHERE yookassa_id = ?", "succeeded", paymentID)
 	}
 
 	json.NewEncoder(w).Encode(map[string]string{
@@ -331,8 +554,3 @@ func (s *Server) handleCheckPayment(w http.ResponseWriter, r *http.Request) {
 		"plan":   payResp.Metadata.Tier,
 	})
 }
-
-func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
-	// Handle YooKassa webhook — updates payment/user status
-	w.WriteHeader(200)
-}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// healthCheckTimeout bounds the TCP connect to a server's endpoint.
+const healthCheckTimeout = 5 * time.Second
+
+// runHealthChecks measures the connect latency of every server every interval
+// and stores it with the server, for recommendations and the admin dashboard.
+func (s *Server) runHealthChecks(interval time.Duration) {
+	for {
+		s.checkAllServers()
+		time.Sleep(interval)
+	}
+}
+
+// checkAllServers probes every server in rotation. Archived servers are
+// skipped, so nodes under maintenance don't raise server.unhealthy events.
+func (s *Server) checkAllServers() {
+	rows, err := s.DB.Query(`SELECT id, type, api_url, server_host, xray_panel_url, xray_settings FROM servers
+		WHERE archived = 0`)
+	if err != nil {
+		log.Printf("[Health] Failed to list servers: %v", err)
+		return
+	}
+	type target struct {
+		id, endpoint string
+		err          error // Set if the server has no usable endpoint
+	}
+	var targets []target
+	for rows.Next() {
+		var id, srvType, apiURL, serverHost, panelURL, settingsJSON string
+		if err := rows.Scan(&id, &srvType, &apiURL, &serverHost, &panelURL, &settingsJSON); err != nil {
+			log.Printf("[Health] Error scanning server row: %v", err)
+			continue
+		}
+		t := target{id: id}
+		if ServerType(srvType) != ServerTypeMock {
+			// Mock servers have nothing to dial and are always healthy.
+			t.endpoint, t.err = healthEndpoint(ServerType(srvType), apiURL, serverHost, panelURL, settingsJSON)
+		}
+		targets = append(targets, t)
+	}
+	// Results can only be written once the query is closed.
+	rows.Close()
+
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if t.err != nil || t.endpoint == "" {
+				s.recordHealth(t.id, 0, t.err)
+				return
+			}
+			start := time.Now()
+			conn, err := net.DialTimeout("tcp", t.endpoint, healthCheckTimeout)
+			if err == nil {
+				conn.Close()
+			}
+			s.recordHealth(t.id, time.Since(start), err)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Server) recordHealth(serverID string, latency time.Duration, checkErr error) {
+	// 0 means unknown, so sub-millisecond latencies are rounded up.
+	healthy, latencyMs, errMsg := true, max(latency.Milliseconds(), 1), ""
+	if latency == 0 {
+		latencyMs = 0
+	}
+	if checkErr != nil {
+		healthy, latencyMs, errMsg = false, 0, checkErr.Error()
+		log.Printf("[Health] Server %s is unhealthy: %v", serverID, checkErr)
+	}
+	// Only healthy -> unhealthy transitions are announced, not every failed check.
+	var wasHealthy bool
+	var country, city string
+	s.DB.QueryRow("SELECT health_ok, country, city FROM servers WHERE id = ?", serverID).Scan(&wasHealthy, &country, &city)
+
+	_, err := s.DB.Exec(`UPDATE servers SET health_ok = ?, health_latency_ms = ?, health_error = ?, health_checked_at = ?
+		WHERE id = ?`, healthy, latencyMs, errMsg, time.Now().UTC(), serverID)
+	if err != nil {
+		log.Printf("[Health] Failed to store health of server %s: %v", serverID, err)
+		return
+	}
+	if wasHealthy && !healthy {
+		s.emitEvent(EventServerUnhealthy, map[string]string{
+			"server_id": serverID,
+			"country":   country,
+			"city":      city,
+			"error":     errMsg,
+		})
+	}
+}
+
+// healthEndpoint returns the host:port probed for a server: the management
+// API for Outline, the inbound port for Xray.
+func healthEndpoint(srvType ServerType, apiURL, serverHost, panelURL, settingsJSON string) (string, error) {
+	if srvType == ServerTypeXray {
+		var settings XrayServerSettings
+		json.Unmarshal([]byte(settingsJSON), &settings)
+		port := settings.Port
+		if port == 0 {
+			port = 443
+		}
+		host := serverHost
+		if host == "" {
+			if u, err := url.Parse(panelURL); err == nil {
+				host = u.Hostname()
+			}
+		}
+		if host == "" {
+			return "", fmt.Errorf("xray server has no host")
+		}
+		return net.JoinHostPort(host, strconv.Itoa(port)), nil
+	}
+
+	u, err := url.Parse(apiURL)
+	if err != nil || u.Hostname() == "" {
+		return "", fmt.Errorf("invalid api_url %q", apiURL)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
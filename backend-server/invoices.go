@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Invoices: users can download an invoice for each of their completed
+// payments, as HTML or, with ?format=pdf, as a one-page PDF. The seller
+// details come from the INVOICE_* settings. Invoice numbers are derived from
+// the payment's row, so they are stable and increase with time.
+
+//go:embed invoice.html
+var invoiceHTML string
+
+var invoiceTemplate = template.Must(template.New("invoice").Parse(invoiceHTML))
+
+// InvoiceCompany are the seller details printed on invoices.
+type InvoiceCompany struct {
+	Name    string
+	Address string
+	TaxID   string
+	Email   string
+}
+
+// Invoice is what an invoice document shows.
+type Invoice struct {
+	Number      string
+	Date        time.Time
+	Status      string // "succeeded" or "refunded"
+	Company     InvoiceCompany
+	Email       string
+	Description string
+	Period      string
+	Amount      string // RUB
+	PaymentID   string
+}
+
+// PaymentHistoryEntry is a payment of the user in their history.
+type PaymentHistoryEntry struct {
+	UserPayment
+	Description string `json:"description"`
+	Region      string `json:"region,omitempty"`
+	// InvoiceURL is set for completed payments, see handleInvoice.
+	InvoiceURL string `json:"invoice_url,omitempty"`
+}
+
+// StatusLabel is the status printed on the invoice.
+func (inv *Invoice) StatusLabel() string {
+	if inv.Status == "refunded" {
+		return "refunded"
+	}
+	return "paid"
+}
+
+// invoiceAvailable reports whether a payment with status has an invoice.
+func invoiceAvailable(status string) bool {
+	return status == "succeeded" || status == "refunded"
+}
+
+// planDescription is the line item of a payment for plan.
+func planDescription(plan string) string {
+	if paid, ok := paidPlans[plan]; ok {
+		return paid.Description
+	}
+	return "Dr. Frake VPN — " + plan
+}
+
+// invoice returns the invoice of the user's payment, sql.ErrNoRows if the
+// user has no such payment.
+func (s *Server) invoice(userID, paymentID string) (*Invoice, error) {
+	var inv Invoice
+	var rowID int64
+	var plan string
+	var amount float64
+	err := s.DB.QueryRow(`SELECT p.rowid, p.yookassa_id, p.plan, p.amount, p.status, p.created_at, u.email
+		FROM payments p JOIN users u ON u.id = p.user_id WHERE p.yookassa_id = ? AND p.user_id = ?`,
+		paymentID, userID).Scan(&rowID, &inv.PaymentID, &plan, &amount, &inv.Status, &inv.Date, &inv.Email)
+	if err != nil {
+		return nil, err
+	}
+	inv.Date = inv.Date.UTC()
+	inv.Number = fmt.Sprintf("DF-%d-%06d", inv.Date.Year(), rowID)
+	inv.Company = InvoiceCompany{
+		Name:    s.Cfg.InvoiceCompany,
+		Address: s.Cfg.InvoiceAddress,
+		TaxID:   s.Cfg.InvoiceTaxID,
+		Email:   s.Cfg.InvoiceEmail,
+	}
+	inv.Description = planDescription(plan)
+	inv.Amount = formatAmount(amount)
+	if paid, ok := paidPlans[plan]; ok {
+		inv.Period = inv.Date.Format(time.DateOnly) + " – " + inv.Date.AddDate(0, paid.Months, 0).Format(time.DateOnly)
+	}
+	return &inv, nil
+}
+
+// handleInvoice renders the invoice of a completed payment of the user.
+func (s *Server) handleInvoice(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "html" && format != "pdf" {
+		http.Error(w, "format must be html or pdf", 400)
+		return
+	}
+
+	inv, err := s.invoice(userID, r.PathValue("id"))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Payment not found", 404)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	if !invoiceAvailable(inv.Status) {
+		http.Error(w, "Payment is not completed", 409)
+		return
+	}
+
+	var buf bytes.Buffer
+	if format == "pdf" {
+		renderInvoicePDF(&buf, inv)
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="invoice-%s.pdf"`, inv.Number))
+	} else {
+		if err := invoiceTemplate.Execute(&buf, inv); err != nil {
+			log.Printf("[Billing] Failed to render invoice %s: %v", inv.Number, err)
+			http.Error(w, "Internal error", 500)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Write(buf.Bytes())
+}
+
+// handlePaymentHistory returns all payments of the user, newest first, with
+// links to the invoices of the completed ones.
+func (s *Server) handlePaymentHistory(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	rows, err := s.DB.Query(`SELECT yookassa_id, plan, amount, status, region, created_at FROM payments
+		WHERE user_id = ? ORDER BY created_at DESC, rowid DESC`, userID)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	payments := []PaymentHistoryEntry{}
+	for rows.Next() {
+		var p PaymentHistoryEntry
+		if err := rows.Scan(&p.ID, &p.Plan, &p.Amount, &p.Status, &p.Region, &p.CreatedAt); err != nil {
+			log.Printf("[Billing] Error scanning payment row: %v", err)
+			continue
+		}
+		p.Description = planDescription(p.Plan)
+		if invoiceAvailable(p.Status) {
+			p.InvoiceURL = "/payments/" + p.ID + "/invoice"
+		}
+		payments = append(payments, p)
+	}
+	json.NewEncoder(w).Encode(payments)
+}
+
+// renderInvoicePDF writes inv as a one-page A4 PDF. It only uses the
+// standard Helvetica fonts, so characters outside Windows-1252 show as "?".
+func renderInvoicePDF(buf *bytes.Buffer, inv *Invoice) {
+	var c pdfContent
+	c.text(50, 780, 24, true, "INVOICE")
+	c.text(50, 760, 10, false, inv.Number)
+	c.text(400, 780, 10, false, "Issued "+inv.Date.Format(time.DateOnly))
+	c.text(400, 765, 10, true, strings.ToUpper(inv.StatusLabel()))
+	c.line(50, 745, 545, 745)
+
+	c.text(50, 720, 8, true, "FROM")
+	y := 705.0
+	for _, l := range []string{inv.Company.Name, inv.Company.Address, taxIDLine(inv.Company.TaxID), inv.Company.Email} {
+		if l != "" {
+			c.text(50, y, 10, l == inv.Company.Name, l)
+			y -= 14
+		}
+	}
+	c.text(320, 720, 8, true, "BILLED TO")
+	c.text(320, 705, 10, false, inv.Email)
+
+	c.text(50, 620, 10, true, "Description")
+	c.text(330, 620, 10, true, "Period")
+	c.text(470, 620, 10, true, "Amount")
+	c.line(50, 612, 545, 612)
+	c.text(50, 595, 10, false, inv.Description)
+	c.text(330, 595, 10, false, inv.Period)
+	c.text(470, 595, 10, false, inv.Amount+" RUB")
+	c.line(50, 585, 545, 585)
+	c.text(50, 565, 11, true, "Total")
+	c.text(470, 565, 11, true, inv.Amount+" RUB")
+
+	footer := "Payment ID " + inv.PaymentID + "."
+	if inv.Status == "refunded" {
+		footer += " This payment was refunded."
+	}
+	c.text(50, 80, 8, false, footer)
+
+	writePDF(buf, c.String())
+}
+
+func taxIDLine(taxID string) string {
+	if taxID == "" {
+		return ""
+	}
+	return "Tax ID: " + taxID
+}
+
+// pdfContent builds the content stream of a PDF page: F1 is Helvetica, F2
+// Helvetica-Bold, and coordinates are in points from the bottom left.
+type pdfContent struct {
+	strings.Builder
+}
+
+func (c *pdfContent) text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(c, "BT /%s %g Tf %g %g Td (%s) Tj ET\n", font, size, x, y, pdfString(s))
+}
+
+func (c *pdfContent) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(c, "0.5 w %g %g m %g %g l S\n", x1, y1, x2, y2)
+}
+
+// pdfString encodes s in WinAnsiEncoding and escapes it for a PDF literal
+// string.
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		case r == '–':
+			b.WriteString(`\226`)
+		case r == '—':
+			b.WriteString(`\227`)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// writePDF writes a one-page A4 PDF document with the content stream.
+func writePDF(buf *bytes.Buffer, content string) {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents 6 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content),
+	}
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+}
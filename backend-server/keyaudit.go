@@ -0,0 +1,380 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Key audits compare the access_keys table with the keys the VPN servers
+// report, as keys drift when a server is reinstalled, an operator deletes keys
+// in the Outline Manager or 3X-UI panel, or the backend fails between creating
+// a key and storing it. Keys are named user-<user ID>, or
+// user-<user ID>.<device ID> for devices, see keyOwner, which is what ties an
+// unknown key back to its owner.
+
+// KeyAuditResult summarizes a key audit.
+type KeyAuditResult struct {
+	StartedAt time.Time `json:"started_at"`
+	// DryRun audits report discrepancies without repairing them.
+	DryRun bool `json:"dry_run"`
+	// Servers were audited; Keys is the number of access_keys rows on them.
+	Servers int `json:"servers"`
+	Keys    int `json:"keys"`
+	// Recreated keys were missing on their server, Imported ones were only
+	// on the server, and Updated rows had a stale key ID or access URL.
+	Recreated     int              `json:"recreated"`
+	Imported      int              `json:"imported"`
+	Updated       int              `json:"updated"`
+	Discrepancies []KeyDiscrepancy `json:"discrepancies,omitempty"`
+	Errors        []string         `json:"errors,omitempty"`
+}
+
+// KeyDiscrepancy is a difference between a server's keys and the
+// access_keys table. Repaired is false for the ones that need an operator's
+// attention, and for all of them in dry runs.
+type KeyDiscrepancy struct {
+	ServerID string `json:"server_id"`
+	KeyID    string `json:"key_id"`
+	KeyName  string `json:"key_name,omitempty"`
+	UserID   string `json:"user_id,omitempty"`
+	DeviceID string `json:"device_id,omitempty"`
+	Problem  string `json:"problem"`
+	Repaired bool   `json:"repaired"`
+}
+
+// storedKey is an access_keys row of the server being audited.
+type storedKey struct {
+	userID, deviceID, keyID, accessURL, plan string
+	offset                                   int64
+}
+
+// runKeyAudits audits the keys of all servers every interval until the
+// process exits.
+func (s *Server) runKeyAudits(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if _, err := s.auditKeys(false); err != nil {
+			log.Printf("[KeyAudit] Failed: %v", err)
+		}
+	}
+}
+
+// auditKeys compares the keys of every server in rotation with the
+// access_keys table and, unless dryRun, repairs the drift: keys missing on
+// their server are recreated, keys named after a user and device without a
+// row are imported, and rows whose key ID or access URL changed are updated.
+// Keys that can't be tied to an owner are only reported. The result of real
+// runs is kept for GET /admin/keys/audit.
+func (s *Server) auditKeys(dryRun bool) (*KeyAuditResult, error) {
+	result := &KeyAuditResult{StartedAt: time.Now().UTC(), DryRun: dryRun}
+	rows, err := s.DB.Query(`SELECT id, type, api_url, cert_sha256, server_host,
+		xray_inbound_id, xray_panel_url, xray_username, xray_password, xray_settings
+		FROM servers WHERE archived = 0`)
+	if err != nil {
+		return nil, err
+	}
+	var servers []serverRecord
+	for rows.Next() {
+		var rec serverRecord
+		if err := rows.Scan(&rec.ID, &rec.Type, &rec.APIURL, &rec.CertSHA256, &rec.ServerHost,
+			&rec.XrayInboundID, &rec.XrayPanelURL, &rec.XrayUsername, &rec.XrayPassword, &rec.XraySettings); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		servers = append(servers, rec)
+	}
+	rows.Close()
+
+	for _, rec := range servers {
+		if err := s.auditServerKeys(rec, result); err != nil {
+			result.Errors = append(result.Errors, rec.ID+": "+err.Error())
+			continue
+		}
+		result.Servers++
+	}
+
+	log.Printf("[KeyAudit] Audited %d servers with %d keys: %d recreated, %d imported, %d updated; %d discrepancies, %d errors",
+		result.Servers, result.Keys, result.Recreated, result.Imported, result.Updated, len(result.Discrepancies), len(result.Errors))
+	for _, d := range result.Discrepancies {
+		if !d.Repaired {
+			log.Printf("[KeyAudit] Key %s on server %s: %s", d.KeyID, d.ServerID, d.Problem)
+		}
+	}
+
+	if !dryRun {
+		s.keyAuditMu.Lock()
+		s.lastKeyAudit = result
+		s.keyAuditMu.Unlock()
+	}
+	return result, nil
+}
+
+func (s *Server) auditServerKeys(rec serverRecord, result *KeyAuditResult) error {
+	provider := s.provider(rec)
+	remote, err := provider.GetKeys()
+	if err != nil {
+		return fmt.Errorf("listing keys: %v", err)
+	}
+	stored, err := s.storedKeys(rec.ID)
+	if err != nil {
+		return err
+	}
+	result.Keys += len(stored)
+
+	remoteByID := make(map[string]VPNKey, len(remote))
+	for _, k := range remote {
+		remoteByID[k.ID] = k
+	}
+	storedByID := make(map[string]storedKey, len(stored))
+	storedByOwner := make(map[string]bool, len(stored))
+	for _, k := range stored {
+		storedByID[k.keyID] = k
+		storedByOwner[keyOwner(k.userID, k.deviceID)] = true
+	}
+	// unstored are the keys of the server without a row, by name.
+	unstored := make(map[string]VPNKey)
+	for _, k := range remote {
+		if _, ok := storedByID[k.ID]; !ok {
+			unstored[k.Name] = k
+		}
+	}
+	relinked := make(map[string]bool)
+
+	for _, k := range stored {
+		d := KeyDiscrepancy{ServerID: rec.ID, KeyID: k.keyID, UserID: k.userID, DeviceID: k.deviceID}
+		r, ok := remoteByID[k.keyID]
+		named, hasNamed := unstored["user-"+keyOwner(k.userID, k.deviceID)]
+		switch {
+		case !ok && hasNamed:
+			// The owner's key was recreated on the server, e.g. by re-adding
+			// an Xray client, which gives it a new ID.
+			d.Problem = "key ID changed on the server"
+			relinked[named.ID] = true
+			if !result.DryRun {
+				if err := s.relinkKey(rec.ID, k, named); err != nil {
+					result.Errors = append(result.Errors, fmt.Sprintf("%s: relinking key %s: %v", rec.ID, k.keyID, err))
+				} else {
+					d.Repaired = true
+					result.Updated++
+				}
+			}
+		case !ok:
+			d.Problem = "missing on the server"
+			if !result.DryRun {
+				if err := s.recreateKey(rec, provider, k); err != nil {
+					result.Errors = append(result.Errors, fmt.Sprintf("%s: recreating key %s: %v", rec.ID, k.keyID, err))
+				} else {
+					d.Repaired = true
+					result.Recreated++
+				}
+			}
+		case r.AccessURL != k.accessURL:
+			d.Problem = "access URL differs"
+			if !result.DryRun {
+				if err := s.relinkKey(rec.ID, k, r); err != nil {
+					result.Errors = append(result.Errors, fmt.Sprintf("%s: updating key %s: %v", rec.ID, k.keyID, err))
+				} else {
+					d.Repaired = true
+					result.Updated++
+				}
+			}
+		default:
+			continue
+		}
+		result.Discrepancies = append(result.Discrepancies, d)
+	}
+
+	for _, r := range remote {
+		if _, ok := storedByID[r.ID]; ok || relinked[r.ID] {
+			continue
+		}
+		d := KeyDiscrepancy{ServerID: rec.ID, KeyID: r.ID, KeyName: r.Name}
+		owner, ok := strings.CutPrefix(r.Name, "user-")
+		if !ok || owner == "" {
+			d.Problem = "not issued by the backend"
+			result.Discrepancies = append(result.Discrepancies, d)
+			continue
+		}
+		d.UserID, d.DeviceID, _ = strings.Cut(owner, ".")
+		if storedByOwner[owner] {
+			d.Problem = "duplicate of the owner's stored key"
+			result.Discrepancies = append(result.Discrepancies, d)
+			continue
+		}
+		d.Problem = "not in the database"
+		if !result.DryRun {
+			imported, err := s.importKey(rec, provider, d.UserID, d.DeviceID, r)
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: importing key %s: %v", rec.ID, r.ID, err))
+			} else if !imported {
+				d.Problem = "owner not found"
+			} else {
+				d.Repaired = true
+				result.Imported++
+				storedByOwner[owner] = true
+			}
+		}
+		result.Discrepancies = append(result.Discrepancies, d)
+	}
+	return nil
+}
+
+func (s *Server) storedKeys(serverID string) ([]storedKey, error) {
+	rows, err := s.DB.Query(`SELECT k.user_id, k.device_id, k.key_id, k.access_url, k.usage_offset, u.plan
+		FROM access_keys k JOIN users u ON u.id = k.user_id WHERE k.server_id = ?`, serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []storedKey
+	for rows.Next() {
+		var k storedKey
+		if err := rows.Scan(&k.userID, &k.deviceID, &k.keyID, &k.accessURL, &k.offset, &k.plan); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// stillStored reports whether the user's row still holds the key, as it may
+// have been rotated or revoked since the audit read it.
+func (s *Server) stillStored(serverID string, k storedKey) bool {
+	var keyID string
+	err := s.DB.QueryRow("SELECT key_id FROM access_keys WHERE user_id = ? AND server_id = ? AND device_id = ?",
+		k.userID, serverID, k.deviceID).Scan(&keyID)
+	return err == nil && keyID == k.keyID
+}
+
+// recreateKey replaces a key missing on its server with a new one. Its
+// traffic is lost with it, so the data limit only accounts for the offset.
+func (s *Server) recreateKey(rec serverRecord, provider VPNProvider, k storedKey) error {
+	unlock := s.lockUser(k.userID)
+	defer unlock()
+	if !s.stillStored(rec.ID, k) {
+		return nil
+	}
+
+	keyID, accessURL, err := provider.CreateKey(keyOwner(k.userID, k.deviceID))
+	if err != nil {
+		return err
+	}
+	if err := validateAccessConfig(accessURL); err != nil {
+		provider.DeleteKey(keyID)
+		return fmt.Errorf("provider returned an invalid key %s: %v", keyID, err)
+	}
+	if err := provider.SetDataLimit(keyID, keyDataLimit(s.keyPlan(k.userID, k.plan), k.offset)); err != nil {
+		log.Printf("[KeyAudit] Failed to set data limit for user %s on server %s: %v", k.userID, rec.ID, err)
+	}
+	if _, err := s.DB.Exec("UPDATE access_keys SET key_id = ?, access_url = ? WHERE user_id = ? AND server_id = ? AND device_id = ?",
+		keyID, accessURL, k.userID, rec.ID, k.deviceID); err != nil {
+		return err
+	}
+	log.Printf("[KeyAudit] Recreated missing key %s of user %s on server %s as %s", k.keyID, k.userID, rec.ID, keyID)
+	return nil
+}
+
+// relinkKey points the user's row at the key the server has for them now.
+// Updating the access URL of a key is relinking it to itself.
+func (s *Server) relinkKey(serverID string, k storedKey, key VPNKey) error {
+	if err := validateAccessConfig(key.AccessURL); err != nil {
+		return fmt.Errorf("server reports an invalid key: %v", err)
+	}
+	unlock := s.lockUser(k.userID)
+	defer unlock()
+	if !s.stillStored(serverID, k) {
+		return nil
+	}
+	_, err := s.DB.Exec("UPDATE access_keys SET key_id = ?, access_url = ? WHERE user_id = ? AND server_id = ? AND device_id = ?",
+		key.ID, key.AccessURL, k.userID, serverID, k.deviceID)
+	return err
+}
+
+// importKey stores a key found on the server for the user's device, which
+// must exist, and applies the plan's data limit to it. It returns false if
+// the owner doesn't.
+func (s *Server) importKey(rec serverRecord, provider VPNProvider, userID, deviceID string, key VPNKey) (bool, error) {
+	// The server lock keeps issueKey from storing a key for the same owner
+	// meanwhile, like the one being imported.
+	unlockServer := s.lockServer(rec.ID)
+	defer unlockServer()
+	unlock := s.lockUser(userID)
+	defer unlock()
+
+	var planName string
+	err := s.DB.QueryRow("SELECT plan FROM users WHERE id = ?", userID).Scan(&planName)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if deviceID != "" {
+		var exists bool
+		if err := s.DB.QueryRow("SELECT COUNT(*) > 0 FROM devices WHERE user_id = ? AND id = ?", userID, deviceID).Scan(&exists); err != nil {
+			return false, err
+		}
+		if !exists {
+			return false, nil
+		}
+	}
+	if err := validateAccessConfig(key.AccessURL); err != nil {
+		return false, fmt.Errorf("server reports an invalid key: %v", err)
+	}
+
+	var offset int64
+	s.DB.QueryRow("SELECT bytes FROM revoked_key_usage WHERE user_id = ? AND server_id = ?", userID, rec.ID).Scan(&offset)
+	res, err := s.DB.Exec(`INSERT INTO access_keys (user_id, server_id, device_id, key_id, access_url, usage_offset, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`, userID, rec.ID, deviceID, key.ID, key.AccessURL, offset, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// The owner got a key stored since the audit read the table.
+		return true, nil
+	}
+	if offset > 0 {
+		s.DB.Exec("DELETE FROM revoked_key_usage WHERE user_id = ? AND server_id = ?", userID, rec.ID)
+	}
+	if err := provider.SetDataLimit(key.ID, keyDataLimit(s.keyPlan(userID, planName), offset)); err != nil {
+		log.Printf("[KeyAudit] Failed to set data limit for user %s on server %s: %v", userID, rec.ID, err)
+	}
+	log.Printf("[KeyAudit] Imported key %s of user %s on server %s", key.ID, userID, rec.ID)
+	return true, nil
+}
+
+// lastKeyAuditResult returns the report of the latest audit that wasn't a
+// dry run, or nil.
+func (s *Server) lastKeyAuditResult() *KeyAuditResult {
+	s.keyAuditMu.Lock()
+	defer s.keyAuditMu.Unlock()
+	return s.lastKeyAudit
+}
+
+// handleAdminAuditKeys audits the keys now. With ?dry_run=true the drift is
+// only reported.
+func (s *Server) handleAdminAuditKeys(w http.ResponseWriter, r *http.Request) {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	result, err := s.auditKeys(dryRun)
+	if err != nil {
+		http.Error(w, "Audit error: "+err.Error(), 500)
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleAdminKeyAuditReport returns the result of the latest audit, scheduled
+// or manual.
+func (s *Server) handleAdminKeyAuditReport(w http.ResponseWriter, r *http.Request) {
+	result := s.lastKeyAuditResult()
+	if result == nil {
+		http.Error(w, "No key audit has run yet", 404)
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
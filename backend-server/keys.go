@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// keyRotationCooldown is the minimum time between rotations of a user's key
+// on one server, to keep clients from hammering the VPN servers' APIs.
+const keyRotationCooldown = time.Hour
+
+// handleRotateKey replaces the user's access key on a server with a fresh one,
+// for when a key was shared or got blocked. The old key stops working
+// immediately. Traffic of the old key still counts against the plan's data cap.
+func (s *Server) handleRotateKey(w http.ResponseWriter, r *http.Request) {
+	token, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	var req struct {
+		ServerID string `json:"server_id"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.ServerID == "" {
+		http.Error(w, "server_id is required", 400)
+		return
+	}
+	deviceID, _, err := requestDevice(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	var rec serverRecord
+	var country, city, flag string
+	var isPremium bool
+	var tags string
+	err = s.DB.QueryRow(`SELECT id, api_url, cert_sha256, country, city, flag, is_premium,
+		type, server_host, xray_inbound_id, xray_panel_url, xray_username, xray_password, xray_settings, tags
+		FROM servers WHERE id = ? AND archived = 0`, req.ServerID).
+		Scan(&rec.ID, &rec.APIURL, &rec.CertSHA256, &country, &city, &flag, &isPremium,
+			&rec.Type, &rec.ServerHost, &rec.XrayInboundID, &rec.XrayPanelURL, &rec.XrayUsername, &rec.XrayPassword, &rec.XraySettings, &tags)
+	if err != nil {
+		http.Error(w, "Server not found", 404)
+		return
+	}
+	if isPremium {
+		var plan string
+		if err := s.DB.QueryRow("SELECT plan FROM users WHERE id = ?", token).Scan(&plan); err != nil {
+			http.Error(w, "Unauthorized", 401)
+			return
+		}
+		if !planHasPremium(plan) {
+			http.Error(w, "Premium plan required", 403)
+			return
+		}
+	}
+
+	accessURL, err := s.rotateKey(token, deviceID, rec, keyRotationCooldown)
+	var cooldown *rotationCooldownError
+	switch {
+	case errors.Is(err, errNoKey):
+		http.Error(w, "No key on this server yet, fetch /servers first", 404)
+		return
+	case errors.As(err, &cooldown):
+		w.Header().Set("Retry-After", fmt.Sprint(int(cooldown.wait.Seconds())+1))
+		http.Error(w, "Key was rotated recently, try again later", 429)
+		return
+	case errors.Is(err, errVPNUnavailable):
+		log.Printf("[Keys] Failed to rotate key of user %s on server %s: %v", token, rec.ID, err)
+		http.Error(w, "VPN server unavailable", 502)
+		return
+	case err != nil:
+		log.Printf("[Keys] Failed to rotate key of user %s on server %s: %v", token, rec.ID, err)
+		http.Error(w, "Database error", 500)
+		return
+	}
+
+	entry := map[string]interface{}{
+		"id":        rec.ID,
+		"country":   country,
+		"city":      city,
+		"flag":      flag,
+		"config":    accessURL,
+		"isPremium": isPremium,
+		"locked":    false,
+		"type":      rec.Type,
+		"tags":      parseTags(tags),
+	}
+	var dev Device
+	if s.DB.QueryRow("SELECT id, name, created_at FROM devices WHERE user_id = ? AND id = ?", token, deviceID).
+		Scan(&dev.ID, &dev.Name, &dev.CreatedAt) == nil {
+		entry["device"] = map[string]interface{}{"id": dev.ID, "name": dev.Name, "created_at": dev.CreatedAt}
+	}
+	json.NewEncoder(w).Encode(entry)
+}
+
+var (
+	errNoKey          = errors.New("user has no key on this server")
+	errVPNUnavailable = errors.New("vpn server unavailable")
+)
+
+// rotationCooldownError is returned by rotateKey when the key was rotated
+// less than the cooldown ago.
+type rotationCooldownError struct {
+	wait time.Duration
+}
+
+func (e *rotationCooldownError) Error() string {
+	return fmt.Sprintf("key was rotated recently, retry in %s", e.wait.Round(time.Second))
+}
+
+// rotateKey deletes the key of the user's device on a server and creates a
+// new one, returning its access config. The old key's traffic is carried over as a
+// usage offset, so rotating doesn't reset the data cap. A cooldown of 0
+// allows rotating at any time.
+func (s *Server) rotateKey(userID, deviceID string, rec serverRecord, cooldown time.Duration) (string, error) {
+	unlock := s.lockUser(userID)
+	defer unlock()
+
+	var planName string
+	if err := s.DB.QueryRow("SELECT plan FROM users WHERE id = ?", userID).Scan(&planName); err != nil {
+		return "", err
+	}
+	var oldKeyID string
+	var rotatedAt sql.NullTime
+	var offset int64
+	err := s.DB.QueryRow("SELECT key_id, rotated_at, usage_offset FROM access_keys WHERE user_id = ? AND server_id = ? AND device_id = ?",
+		userID, rec.ID, deviceID).Scan(&oldKeyID, &rotatedAt, &offset)
+	if err == sql.ErrNoRows {
+		return "", errNoKey
+	} else if err != nil {
+		return "", err
+	}
+	if rotatedAt.Valid {
+		if wait := cooldown - time.Since(rotatedAt.Time); wait > 0 {
+			return "", &rotationCooldownError{wait: wait}
+		}
+	}
+
+	provider := s.provider(rec)
+	if used, err := provider.GetUsage(oldKeyID); err == nil {
+		offset += used
+	} else {
+		log.Printf("[Keys] Failed to get usage of key %s on server %s before rotation: %v", oldKeyID, rec.ID, err)
+	}
+
+	// The old key is deleted first: Xray identifies clients by user, so a new
+	// key can't be added while the old one exists.
+	if err := provider.DeleteKey(oldKeyID); err != nil {
+		return "", fmt.Errorf("%w: deleting key %s: %v", errVPNUnavailable, oldKeyID, err)
+	}
+	newKeyID, accessURL, err := provider.CreateKey(keyOwner(userID, deviceID))
+	if err != nil {
+		// Without a stored key, /servers provisions a new one on the next request.
+		s.DB.Exec("DELETE FROM access_keys WHERE user_id = ? AND server_id = ? AND device_id = ?", userID, rec.ID, deviceID)
+		return "", fmt.Errorf("%w: creating key: %v", errVPNUnavailable, err)
+	}
+	if err := validateAccessConfig(accessURL); err != nil {
+		provider.DeleteKey(newKeyID)
+		s.DB.Exec("DELETE FROM access_keys WHERE user_id = ? AND server_id = ? AND device_id = ?", userID, rec.ID, deviceID)
+		return "", fmt.Errorf("%w: provider returned an invalid key %s: %v", errVPNUnavailable, newKeyID, err)
+	}
+	if err := provider.SetDataLimit(newKeyID, keyDataLimit(s.keyPlan(userID, planName), offset)); err != nil {
+		log.Printf("[Keys] Failed to set data limit for user %s on server %s: %v", userID, rec.ID, err)
+	}
+
+	if _, err := s.DB.Exec(`UPDATE access_keys SET key_id = ?, access_url = ?, rotated_at = ?, usage_offset = ?
+		WHERE user_id = ? AND server_id = ? AND device_id = ?`, newKeyID, accessURL, time.Now().UTC(), offset, userID, rec.ID, deviceID); err != nil {
+		return "", err
+	}
+	log.Printf("[Keys] Rotated key of user %s on server %s", userID, rec.ID)
+	return accessURL, nil
+}
@@ -6,6 +6,10 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
@@ -16,24 +20,174 @@ type Config struct {
 	YookassaShopID    string
 	YookassaSecretKey string
 	YookassaReturnURL string
+	// PaymentProvider is "yookassa" (default) or "sandbox" for local development.
+	PaymentProvider string
+	// WebhookAuth is how the notifications of each payment provider, by
+	// name, authenticate, from <PROVIDER>_WEBHOOK_USERNAME, _PASSWORD and
+	// _SECRET.
+	WebhookAuth map[string]NotificationAuth
+	// AdminToken protects the /admin API; it must be at least
+	// minAdminTokenLen characters. If empty, the admin API is disabled, or
+	// with AdminLocalhost only reachable from localhost.
+	AdminToken     string
+	AdminLocalhost bool
+	// MetricsToken lets Prometheus scrape /metrics without the admin token.
+	MetricsToken string
+
+	// LegacyTokensUntil is the date, as YYYY-MM-DD, after which the bare user
+	// IDs of accounts created before sessions existed stop working as tokens.
+	LegacyTokensUntil string
+
+	// Scheduled backups. BackupInterval is a duration such as "24h"; backups are
+	// enabled when BackupDir or BackupS3Bucket is set.
+	BackupDir         string
+	BackupInterval    string
+	BackupKeep        int
+	BackupS3Endpoint  string
+	BackupS3Region    string
+	BackupS3Bucket    string
+	BackupS3AccessKey string
+	BackupS3SecretKey string
+
+	// PublicURL is the externally reachable base URL of the backend, used in
+	// links sent by email. Defaults to the Host of the request.
+	PublicURL string
+
+	// SMTP server for emails to users. Without SMTPHost, emails are only logged.
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+
+	// GeoIPURL is an ip-api.com compatible lookup endpoint, http://ip-api.com/json/ by default.
+	GeoIPURL string
+
+	// TelegramBotToken enables the Telegram sales bot. TelegramAPIURL
+	// overrides the Bot API server, e.g. for a local Bot API server.
+	TelegramBotToken string
+	TelegramAPIURL   string
+
+	// Abuse detection: a key used from more than AbuseMaxDevices devices at
+	// once in AbuseStrikes consecutive checks is flagged for review.
+	// AbuseCheckInterval is a duration such as "10m"; "0" disables the checks.
+	AbuseMaxDevices    int
+	AbuseStrikes       int
+	AbuseCheckInterval string
+
+	// KeyAuditInterval is how often the keys of the servers are compared
+	// with the database, a duration such as "6h"; "0" disables the audits.
+	KeyAuditInterval string
+
+	// MaxDevices is how many devices a user can hold keys of at once, see
+	// devices.go.
+	MaxDevices int
+
+	// Auto-renewal: the saved payment method of users is charged AutoRenewDays
+	// before their plan expires (0 disables auto-renewal and saving cards).
+	// Users whose renewal fails keep their plan for GracePeriodDays.
+	AutoRenewDays   int
+	GracePeriodDays int
+
+	// MaxPauseDays is how many days a year users can pause their paid plan
+	// for, see pause.go. 0 disables pausing.
+	MaxPauseDays int
+
+	// RegionalPricing charges the per-country prices of plan_prices, see
+	// pricing.go. Off by default: every country pays the plan's price.
+	RegionalPricing bool
+
+	// Seller details printed on invoices, see invoices.go.
+	InvoiceCompany string
+	InvoiceAddress string
+	InvoiceTaxID   string
+	InvoiceEmail   string
+
+	// ServerAssignment is the default strategy of GET /servers/recommended:
+	// "latency" (default) or "least_loaded".
+	ServerAssignment string
+
+	// PlanDataLimitsGB overrides the monthly data cap of plans, in GB (0 = unlimited).
+	PlanDataLimitsGB map[string]int64
 }
 
 type Server struct {
 	DB       *sql.DB
 	Cfg      *Config
-	YooKassa *YooKassaClient
+	Payments PaymentProvider
+	Breakers *BreakerRegistry
+	Plans    map[string]Plan
+	Geo      GeoLocator
+	Mailer   Mailer
+	Flags    *FlagStore
+	Traffic  *TrafficStats
+	Telegram *TelegramBot // nil unless TELEGRAM_BOT_TOKEN is set
+
+	reconcileMu   sync.Mutex
+	lastReconcile *ReconcileResult
+	keyAuditMu    sync.Mutex
+	lastKeyAudit  *KeyAuditResult
+	userLocks     sync.Map  // User ID -> *sync.Mutex, see lockUser
+	serverLocks   sync.Map  // Server ID -> *sync.Mutex, see lockServer
+	legacyCutoff  time.Time // End of LegacyTokensUntil, see authSession
+	dataLimits    sync.Map  // "serverID/keyID" -> data cap last set, see enforceDataCaps
 }
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "smoke" {
+		os.Exit(runSmoke(os.Args[2:]))
+	}
+
 	// Initialize Config
 	cfg := LoadConfig()
 
+	// Validate the config before opening the database or starting any workers.
+	switch {
+	case cfg.AdminToken != "" && len(cfg.AdminToken) < minAdminTokenLen:
+		log.Fatalf("ADMIN_TOKEN is too short, use at least %d random characters, e.g. from: openssl rand -hex 32", minAdminTokenLen)
+	case cfg.AdminToken == "" && cfg.AdminLocalhost:
+		log.Printf("ADMIN_TOKEN is not set: the admin API is open to localhost")
+	case cfg.AdminToken == "":
+		log.Printf("ADMIN_TOKEN is not set: the admin API is disabled")
+	}
+	if cfg.MetricsToken != "" && len(cfg.MetricsToken) < minAdminTokenLen {
+		log.Fatalf("METRICS_TOKEN is too short, use at least %d random characters", minAdminTokenLen)
+	}
+
+	legacyCutoff, err := time.Parse(time.DateOnly, cfg.LegacyTokensUntil)
+	if err != nil {
+		log.Fatalf("Invalid legacy token cutoff %q, use YYYY-MM-DD", cfg.LegacyTokensUntil)
+	}
+	// Legacy tokens keep working through the whole cutoff day.
+	legacyCutoff = legacyCutoff.AddDate(0, 0, 1)
+
+	if cfg.ServerAssignment != AssignLatency && cfg.ServerAssignment != AssignLeastLoaded {
+		log.Fatalf("Invalid server assignment strategy %q, use %s or %s", cfg.ServerAssignment, AssignLatency, AssignLeastLoaded)
+	}
+	abuseInterval, err := time.ParseDuration(cfg.AbuseCheckInterval)
+	if err != nil || abuseInterval < 0 {
+		log.Fatalf("Invalid abuse check interval %q", cfg.AbuseCheckInterval)
+	}
+	keyAuditInterval, err := time.ParseDuration(cfg.KeyAuditInterval)
+	if err != nil || keyAuditInterval < 0 {
+		log.Fatalf("Invalid key audit interval %q", cfg.KeyAuditInterval)
+	}
+	var backupInterval time.Duration
+	if cfg.BackupDir != "" || cfg.BackupS3Bucket != "" {
+		backupInterval, err = time.ParseDuration(cfg.BackupInterval)
+		if err != nil || backupInterval <= 0 {
+			log.Fatalf("Invalid backup interval %q", cfg.BackupInterval)
+		}
+	}
+
 	// Initialize DB (supports DB_PATH env var for Docker)
 	dbPath := os.Getenv("DB_PATH")
 	if dbPath == "" {
 		dbPath = "server.db"
 	}
-	db, err := sql.Open("sqlite", dbPath)
+	// Writers wait for long readers, such as backups, instead of failing with SQLITE_BUSY.
+	db, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -46,25 +200,144 @@ func main() {
 	srv := &Server{
 		DB:       db,
 		Cfg:      cfg,
-		YooKassa: NewYooKassaClient(cfg.YookassaShopID, cfg.YookassaSecretKey),
+		Payments: newPaymentProvider(cfg),
+		Breakers: NewBreakerRegistry(),
+		Plans:    loadPlans(cfg),
+		Geo:      NewIPAPILocator(cfg.GeoIPURL),
+		Mailer:   newMailer(cfg),
+		Flags:    NewFlagStore(db),
+		Traffic:  NewTrafficStats(),
+
+		legacyCutoff: legacyCutoff,
+	}
+	if cfg.TelegramBotToken != "" {
+		srv.Telegram = NewTelegramBot(cfg.TelegramAPIURL, cfg.TelegramBotToken)
 	}
 
 	// Router
 	mux := http.NewServeMux()
 	mux.HandleFunc("/register", srv.handleRegister)
 	mux.HandleFunc("/login", srv.handleLogin)
+	mux.HandleFunc("POST /logout", srv.handleLogout)
+	mux.HandleFunc("GET /sessions", srv.handleListSessions)
+	mux.HandleFunc("DELETE /sessions", srv.handleRevokeAllSessions)
+	mux.HandleFunc("DELETE /sessions/{id}", srv.handleRevokeSession)
+	mux.HandleFunc("GET /account", srv.handleGetAccount)
+	mux.HandleFunc("PUT /account/email", srv.handleChangeEmail)
+	mux.HandleFunc("GET /account/email/confirm", srv.handleConfirmEmail)
+	mux.HandleFunc("GET /account/subscription", srv.handleGetBilling)
+	mux.HandleFunc("PUT /account/subscription/auto-renew", srv.handleSetAutoRenew)
+	mux.HandleFunc("DELETE /account/subscription/next-plan", srv.handleCancelNextPlan)
+	mux.HandleFunc("POST /account/subscription/pause", srv.handlePauseSubscription)
+	mux.HandleFunc("POST /account/subscription/resume", srv.handleResumeSubscription)
+	mux.HandleFunc("GET /account/payments", srv.handleListUserPayments)
+	mux.HandleFunc("GET /payments", srv.handlePaymentHistory)
+	mux.HandleFunc("GET /payments/{id}/invoice", srv.handleInvoice)
+	mux.HandleFunc("GET /family", srv.handleGetFamily)
+	mux.HandleFunc("POST /family/invite", srv.handleFamilyInvite)
+	mux.HandleFunc("POST /family/accept", srv.handleFamilyAccept)
+	mux.HandleFunc("DELETE /family/invites/{id}", srv.handleCancelFamilyInvite)
+	mux.HandleFunc("DELETE /family/members/{id}", srv.handleRemoveFamilyMember)
 	mux.HandleFunc("/servers", srv.handleGetServers)
+	mux.HandleFunc("GET /servers/recommended", srv.handleRecommendedServers)
+	mux.HandleFunc("POST /keys/rotate", srv.handleRotateKey)
+	mux.HandleFunc("GET /devices", srv.handleListDevices)
+	mux.HandleFunc("PUT /devices/{id}", srv.handleRenameDevice)
+	mux.HandleFunc("DELETE /devices/{id}", srv.handleRevokeDevice)
+	mux.HandleFunc("POST /redeem", srv.handleRedeem)
+	mux.HandleFunc("POST /telegram/link", srv.handleTelegramLink)
+	mux.HandleFunc("GET /sub/{token}", srv.handleSubscription)
 	mux.HandleFunc("/payment/init", srv.handleInitPayment)
 	mux.HandleFunc("/payment/check", srv.handleCheckPayment)
+	mux.HandleFunc("GET /payment/pending", srv.handlePendingPayment)
 	mux.HandleFunc("/payment/webhook", srv.handleWebhook)
-	mux.HandleFunc("/admin/add-server", srv.handleAdminAddServer)
+	mux.HandleFunc("GET /usage", srv.handleUsage)
+	mux.HandleFunc("/metrics", srv.requireMetrics(srv.handleMetrics))
+	mux.HandleFunc("GET /healthz", srv.handleHealthz)
+	mux.HandleFunc("GET /readyz", srv.handleReadyz)
+	mux.HandleFunc("GET /openapi.json", srv.handleOpenAPI)
+
+	// Admin API
+	mux.HandleFunc("/admin/add-server", srv.requireAdmin(srv.handleAdminAddServer))
+	mux.HandleFunc("GET /admin/servers", srv.requireAdmin(srv.handleAdminListServers))
+	mux.HandleFunc("POST /admin/servers/import", srv.requireAdmin(srv.handleAdminImportServers))
+	mux.HandleFunc("GET /admin/servers/export", srv.requireAdmin(srv.handleAdminExportServers))
+	mux.HandleFunc("DELETE /admin/servers/{id}", srv.requireAdmin(srv.handleAdminDeleteServer))
+	mux.HandleFunc("POST /admin/servers/{id}/sync", srv.requireAdmin(srv.handleAdminSyncServer))
+	mux.HandleFunc("POST /admin/servers/{id}/archive", srv.requireAdmin(srv.handleAdminArchiveServer))
+	mux.HandleFunc("POST /admin/servers/{id}/restore", srv.requireAdmin(srv.handleAdminRestoreServer))
+	mux.HandleFunc("PUT /admin/servers/{id}/capacity", srv.requireAdmin(srv.handleAdminSetCapacity))
+	mux.HandleFunc("PUT /admin/servers/{id}/tags", srv.requireAdmin(srv.handleAdminSetTags))
+	mux.HandleFunc("POST /admin/servers/{id}/breaker/reset", srv.requireAdmin(srv.handleAdminResetBreaker))
+	mux.HandleFunc("POST /admin/provision", srv.requireAdmin(srv.handleAdminProvision))
+	mux.HandleFunc("GET /admin/provision", srv.requireAdmin(srv.handleAdminListProvisions))
+	mux.HandleFunc("GET /admin/provision/{id}", srv.requireAdmin(srv.handleAdminGetProvision))
+	mux.HandleFunc("GET /admin/breakers", srv.requireAdmin(srv.handleAdminListBreakers))
+	mux.HandleFunc("POST /admin/configs/validate", srv.requireAdmin(srv.handleAdminValidateConfig))
+	mux.HandleFunc("GET /admin/users", srv.requireAdmin(srv.handleAdminListUsers))
+	mux.HandleFunc("POST /admin/users/{id}/plan", srv.requireAdmin(srv.handleAdminSetPlan))
+	mux.HandleFunc("DELETE /admin/users/{id}", srv.requireAdmin(srv.handleAdminDeleteUser))
+	mux.HandleFunc("DELETE /admin/users/{id}/sessions", srv.requireAdmin(srv.handleAdminRevokeSessions))
+	mux.HandleFunc("GET /admin/payments", srv.requireAdmin(srv.handleAdminListPayments))
+	mux.HandleFunc("POST /admin/payments/{id}/refund", srv.requireAdmin(srv.handleAdminRefund))
+	mux.HandleFunc("GET /admin/payments/dead-letters", srv.requireAdmin(srv.handleAdminListDeadLetters))
+	mux.HandleFunc("POST /admin/payments/dead-letters/{id}/retry", srv.requireAdmin(srv.handleAdminRetryDeadLetter))
+	mux.HandleFunc("GET /admin/payments/regions", srv.requireAdmin(srv.handleAdminRegionRevenue))
+	mux.HandleFunc("GET /admin/prices", srv.requireAdmin(srv.handleAdminListPrices))
+	mux.HandleFunc("PUT /admin/prices/{plan}/{country}", srv.requireAdmin(srv.handleAdminSetPrice))
+	mux.HandleFunc("DELETE /admin/prices/{plan}/{country}", srv.requireAdmin(srv.handleAdminDeletePrice))
+	mux.HandleFunc("POST /admin/reconcile", srv.requireAdmin(srv.handleAdminReconcile))
+	mux.HandleFunc("GET /admin/reconcile", srv.requireAdmin(srv.handleAdminReconcileReport))
+	mux.HandleFunc("POST /admin/keys/audit", srv.requireAdmin(srv.handleAdminAuditKeys))
+	mux.HandleFunc("GET /admin/keys/audit", srv.requireAdmin(srv.handleAdminKeyAuditReport))
+	mux.HandleFunc("GET /admin/backup", srv.requireAdmin(srv.handleAdminBackup))
+	mux.HandleFunc("GET /admin/abuse", srv.requireAdmin(srv.handleAdminListAbuse))
+	mux.HandleFunc("POST /admin/abuse/{id}/resolve", srv.requireAdmin(srv.handleAdminResolveAbuse))
+	mux.HandleFunc("GET /admin/codes", srv.requireAdmin(srv.handleAdminListCodes))
+	mux.HandleFunc("POST /admin/codes", srv.requireAdmin(srv.handleAdminGenerateCodes))
+	mux.HandleFunc("DELETE /admin/codes/{code}", srv.requireAdmin(srv.handleAdminRevokeCode))
+	mux.HandleFunc("GET /admin/flags", srv.requireAdmin(srv.handleAdminListFlags))
+	mux.HandleFunc("PUT /admin/flags/{name}", srv.requireAdmin(srv.handleAdminSetFlag))
+	mux.HandleFunc("DELETE /admin/flags/{name}", srv.requireAdmin(srv.handleAdminDeleteFlag))
+	mux.HandleFunc("GET /admin/webhooks", srv.requireAdmin(srv.handleAdminListWebhooks))
+	mux.HandleFunc("POST /admin/webhooks", srv.requireAdmin(srv.handleAdminAddWebhook))
+	mux.HandleFunc("DELETE /admin/webhooks/{id}", srv.requireAdmin(srv.handleAdminDeleteWebhook))
+	mux.HandleFunc("POST /admin/webhooks/{id}/test", srv.requireAdmin(srv.handleAdminTestWebhook))
+	mux.HandleFunc("GET /admin/webhooks/{id}/deliveries", srv.requireAdmin(srv.handleAdminListWebhookDeliveries))
+	mux.Handle("/admin/ui/", srv.adminUIHandler())
+
+	srv.failInterruptedProvisions()
+	srv.warnUnpinnedServers()
+	go srv.runQuotaResets(time.Hour)
+	go srv.runHealthChecks(time.Minute)
+	go srv.runSubscriptionExpiry(time.Hour)
+	go srv.runWebhookDeliveries(5 * time.Second)
+	go srv.runReconciler(15 * time.Minute)
+	go srv.runTrafficCollector(time.Minute)
+	if cfg.AutoRenewDays > 0 {
+		go srv.runAutoRenew(time.Hour)
+	}
+	if srv.Telegram != nil {
+		go srv.runTelegramBot()
+	}
+
+	if abuseInterval > 0 {
+		go srv.runAbuseChecks(abuseInterval)
+	}
+	if keyAuditInterval > 0 {
+		go srv.runKeyAudits(keyAuditInterval)
+	}
+	if backupInterval > 0 {
+		go srv.runBackups(backupInterval)
+	}
 
 	log.Printf("Server starting on %s...", cfg.Port)
-	log.Fatal(http.ListenAndServe(cfg.Port, mux))
+	log.Fatal(http.ListenAndServe(cfg.Port, srv.withFlags(mux)))
 }
 
 func LoadConfig() *Config {
-	cfg := &Config{}
+	// Defaults that can be set to 0 go here; the others are filled in below.
+	cfg := &Config{AutoRenewDays: 3, GracePeriodDays: 3, MaxPauseDays: 30}
 
 	// Try loading from config.json first
 	configPath := os.Getenv("CONFIG_PATH")
@@ -92,6 +365,144 @@ func LoadConfig() *Config {
 	if v := os.Getenv("YOOKASSA_RETURN_URL"); v != "" {
 		cfg.YookassaReturnURL = v
 	}
+	if v := os.Getenv("PAYMENT_PROVIDER"); v != "" {
+		cfg.PaymentProvider = v
+	}
+	for _, name := range []string{"yookassa", "sandbox"} {
+		prefix := strings.ToUpper(name) + "_WEBHOOK_"
+		auth := NotificationAuth{
+			Username: os.Getenv(prefix + "USERNAME"),
+			Password: os.Getenv(prefix + "PASSWORD"),
+			Secret:   os.Getenv(prefix + "SECRET"),
+		}
+		if auth.Configured() {
+			if cfg.WebhookAuth == nil {
+				cfg.WebhookAuth = make(map[string]NotificationAuth)
+			}
+			cfg.WebhookAuth[name] = auth
+		}
+	}
+	if v := os.Getenv("ADMIN_TOKEN"); v != "" {
+		cfg.AdminToken = v
+	}
+	if v := os.Getenv("METRICS_TOKEN"); v != "" {
+		cfg.MetricsToken = v
+	}
+	if v, err := strconv.ParseBool(os.Getenv("ADMIN_LOCALHOST")); err == nil {
+		cfg.AdminLocalhost = v
+	}
+	if v := os.Getenv("LEGACY_TOKENS_UNTIL"); v != "" {
+		cfg.LegacyTokensUntil = v
+	}
+	if v := os.Getenv("PUBLIC_URL"); v != "" {
+		cfg.PublicURL = v
+	}
+	if v := os.Getenv("SMTP_HOST"); v != "" {
+		cfg.SMTPHost = v
+	}
+	if v := os.Getenv("SMTP_PORT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.SMTPPort = n
+		}
+	}
+	if v := os.Getenv("SMTP_USERNAME"); v != "" {
+		cfg.SMTPUsername = v
+	}
+	if v := os.Getenv("SMTP_PASSWORD"); v != "" {
+		cfg.SMTPPassword = v
+	}
+	if v := os.Getenv("SMTP_FROM"); v != "" {
+		cfg.SMTPFrom = v
+	}
+	if v := os.Getenv("TELEGRAM_BOT_TOKEN"); v != "" {
+		cfg.TelegramBotToken = v
+	}
+	if v := os.Getenv("TELEGRAM_API_URL"); v != "" {
+		cfg.TelegramAPIURL = v
+	}
+	if v := os.Getenv("ABUSE_MAX_DEVICES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.AbuseMaxDevices = n
+		}
+	}
+	if v := os.Getenv("MAX_DEVICES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.MaxDevices = n
+		}
+	}
+	if v := os.Getenv("ABUSE_STRIKES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.AbuseStrikes = n
+		}
+	}
+	if v := os.Getenv("ABUSE_CHECK_INTERVAL"); v != "" {
+		cfg.AbuseCheckInterval = v
+	}
+	if v := os.Getenv("KEY_AUDIT_INTERVAL"); v != "" {
+		cfg.KeyAuditInterval = v
+	}
+	if v := os.Getenv("AUTO_RENEW_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.AutoRenewDays = n
+		}
+	}
+	if v := os.Getenv("GRACE_PERIOD_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.GracePeriodDays = n
+		}
+	}
+	if v := os.Getenv("MAX_PAUSE_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.MaxPauseDays = n
+		}
+	}
+	if v, err := strconv.ParseBool(os.Getenv("REGIONAL_PRICING")); err == nil {
+		cfg.RegionalPricing = v
+	}
+	if v := os.Getenv("INVOICE_COMPANY"); v != "" {
+		cfg.InvoiceCompany = v
+	}
+	if v := os.Getenv("INVOICE_ADDRESS"); v != "" {
+		cfg.InvoiceAddress = v
+	}
+	if v := os.Getenv("INVOICE_TAX_ID"); v != "" {
+		cfg.InvoiceTaxID = v
+	}
+	if v := os.Getenv("INVOICE_EMAIL"); v != "" {
+		cfg.InvoiceEmail = v
+	}
+	if v := os.Getenv("SERVER_ASSIGNMENT"); v != "" {
+		cfg.ServerAssignment = v
+	}
+	if v := os.Getenv("GEOIP_URL"); v != "" {
+		cfg.GeoIPURL = v
+	}
+	if v := os.Getenv("BACKUP_DIR"); v != "" {
+		cfg.BackupDir = v
+	}
+	if v := os.Getenv("BACKUP_INTERVAL"); v != "" {
+		cfg.BackupInterval = v
+	}
+	if v := os.Getenv("BACKUP_KEEP"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.BackupKeep = n
+		}
+	}
+	if v := os.Getenv("BACKUP_S3_ENDPOINT"); v != "" {
+		cfg.BackupS3Endpoint = v
+	}
+	if v := os.Getenv("BACKUP_S3_REGION"); v != "" {
+		cfg.BackupS3Region = v
+	}
+	if v := os.Getenv("BACKUP_S3_BUCKET"); v != "" {
+		cfg.BackupS3Bucket = v
+	}
+	if v := os.Getenv("BACKUP_S3_ACCESS_KEY"); v != "" {
+		cfg.BackupS3AccessKey = v
+	}
+	if v := os.Getenv("BACKUP_S3_SECRET_KEY"); v != "" {
+		cfg.BackupS3SecretKey = v
+	}
 
 	// Defaults
 	if cfg.Port == "" {
@@ -100,10 +511,68 @@ func LoadConfig() *Config {
 	if cfg.YookassaReturnURL == "" {
 		cfg.YookassaReturnURL = "https://google.com"
 	}
+	if cfg.LegacyTokensUntil == "" {
+		cfg.LegacyTokensUntil = "2026-12-31"
+	}
+	if cfg.InvoiceCompany == "" {
+		cfg.InvoiceCompany = "Dr. Frake VPN"
+	}
+	if cfg.BackupInterval == "" {
+		cfg.BackupInterval = "24h"
+	}
+	if cfg.BackupKeep == 0 {
+		cfg.BackupKeep = 7
+	}
+	if cfg.TelegramAPIURL == "" {
+		cfg.TelegramAPIURL = "https://api.telegram.org"
+	}
+	if cfg.AbuseMaxDevices == 0 {
+		cfg.AbuseMaxDevices = 5
+	}
+	if cfg.MaxDevices == 0 {
+		cfg.MaxDevices = 5
+	}
+	if cfg.AbuseStrikes == 0 {
+		cfg.AbuseStrikes = 3
+	}
+	if cfg.AbuseCheckInterval == "" {
+		cfg.AbuseCheckInterval = "10m"
+	}
+	if cfg.KeyAuditInterval == "" {
+		cfg.KeyAuditInterval = "6h"
+	}
+	if cfg.AutoRenewDays < 0 {
+		cfg.AutoRenewDays = 0
+	}
+	if cfg.GracePeriodDays < 0 {
+		cfg.GracePeriodDays = 0
+	}
+	if cfg.MaxPauseDays < 0 {
+		cfg.MaxPauseDays = 0
+	}
+	if cfg.ServerAssignment == "" {
+		cfg.ServerAssignment = AssignLatency
+	}
 
 	return cfg
 }
 
+func newPaymentProvider(cfg *Config) PaymentProvider {
+	if cfg.PaymentProvider == "sandbox" {
+		log.Printf("Using sandbox payment provider: payments always succeed")
+		client := NewSandboxPaymentClient()
+		client.Notifications = cfg.WebhookAuth["sandbox"]
+		return client
+	}
+	client := NewYooKassaClient(cfg.YookassaShopID, cfg.YookassaSecretKey)
+	client.SavePaymentMethods = cfg.AutoRenewDays > 0
+	client.Notifications = cfg.WebhookAuth["yookassa"]
+	if !client.Notifications.Configured() {
+		log.Printf("Payment notifications aren't authenticated, set YOOKASSA_WEBHOOK_USERNAME and _PASSWORD or _SECRET")
+	}
+	return client
+}
+
 func initDB(db *sql.DB) {
 	queries := []string{
 		`CREATE TABLE IF NOT EXISTS users (
@@ -112,7 +581,44 @@ func initDB(db *sql.DB) {
 			password TEXT,
 			plan TEXT,
 			expiry_date DATETIME,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			quota_reset_at DATETIME,
+			legacy_token_revoked BOOLEAN DEFAULT 1,
+			sub_token TEXT DEFAULT '',
+			auto_renew BOOLEAN DEFAULT 0,
+			payment_method_id TEXT DEFAULT '',
+			payment_method_title TEXT DEFAULT '',
+			renewal_attempted_at DATETIME,
+			renewal_failures INTEGER DEFAULT 0,
+			next_plan TEXT DEFAULT '',
+			paused_at DATETIME,
+			paused_until DATETIME,
+			family_owner_id TEXT DEFAULT '',
+			family_joined_at DATETIME,
+			family_member_id TEXT DEFAULT ''
+		);`,
+		`CREATE TABLE IF NOT EXISTS sessions (
+			id TEXT PRIMARY KEY,
+			user_id TEXT,
+			token_hash TEXT UNIQUE,
+			device TEXT DEFAULT '',
+			device_id TEXT DEFAULT '',
+			user_agent TEXT DEFAULT '',
+			ip TEXT DEFAULT '',
+			created_at DATETIME,
+			last_seen_at DATETIME,
+			revoked_at DATETIME,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions (user_id);`,
+		`CREATE TABLE IF NOT EXISTS email_changes (
+			token_hash TEXT PRIMARY KEY,
+			user_id TEXT,
+			new_email TEXT,
+			created_at DATETIME,
+			expires_at DATETIME,
+			confirmed_at DATETIME,
+			FOREIGN KEY(user_id) REFERENCES users(id)
 		);`,
 		`CREATE TABLE IF NOT EXISTS payments (
 			id TEXT PRIMARY KEY,
@@ -120,7 +626,9 @@ func initDB(db *sql.DB) {
 			yookassa_id TEXT,
 			amount REAL,
 			status TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			plan TEXT DEFAULT '',
+			confirmation_url TEXT DEFAULT ''
 		);`,
 		`CREATE TABLE IF NOT EXISTS servers (
 			id TEXT PRIMARY KEY,
@@ -136,17 +644,158 @@ func initDB(db *sql.DB) {
 			xray_panel_url TEXT DEFAULT '',
 			xray_username TEXT DEFAULT '',
 			xray_password TEXT DEFAULT '',
-			xray_settings TEXT DEFAULT '{}'
+			xray_settings TEXT DEFAULT '{}',
+			health_ok BOOLEAN DEFAULT 1,
+			health_latency_ms INTEGER DEFAULT 0,
+			health_error TEXT DEFAULT '',
+			health_checked_at DATETIME,
+			archived BOOLEAN DEFAULT 0,
+			archived_at DATETIME,
+			archive_reason TEXT DEFAULT '',
+			max_users INTEGER DEFAULT 0,
+			tags TEXT DEFAULT ''
+		);`,
+		`CREATE TABLE IF NOT EXISTS webhooks (
+			id TEXT PRIMARY KEY,
+			url TEXT,
+			secret TEXT,
+			events TEXT DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id TEXT PRIMARY KEY,
+			webhook_id TEXT,
+			event TEXT,
+			payload TEXT,
+			status TEXT DEFAULT 'pending',
+			attempts INTEGER DEFAULT 0,
+			last_error TEXT DEFAULT '',
+			next_attempt_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);`,
+		`CREATE TABLE IF NOT EXISTS payment_notifications (
+			id TEXT PRIMARY KEY,
+			processed_at DATETIME
+		);`,
+		`CREATE TABLE IF NOT EXISTS payment_dead_letters (
+			id TEXT PRIMARY KEY,
+			event TEXT,
+			payment_id TEXT,
+			body TEXT,
+			error TEXT,
+			attempts INTEGER DEFAULT 0,
+			failed_at DATETIME,
+			resolved_at DATETIME
+		);`,
+		`CREATE TABLE IF NOT EXISTS subscription_pauses (
+			id TEXT PRIMARY KEY,
+			user_id TEXT,
+			started_at DATETIME,
+			ended_at DATETIME,
+			days INTEGER
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_subscription_pauses_user ON subscription_pauses (user_id, started_at);`,
+		`CREATE TABLE IF NOT EXISTS family_invites (
+			id TEXT PRIMARY KEY,
+			token_hash TEXT UNIQUE,
+			owner_id TEXT,
+			email TEXT,
+			created_at DATETIME,
+			expires_at DATETIME,
+			accepted_at DATETIME
+		);`,
+		`CREATE TABLE IF NOT EXISTS plan_prices (
+			plan TEXT,
+			country TEXT,
+			amount TEXT,
+			updated_at DATETIME,
+			PRIMARY KEY (plan, country)
+		);`,
+		`CREATE TABLE IF NOT EXISTS provision_jobs (
+			id TEXT PRIMARY KEY,
+			type TEXT,
+			host TEXT,
+			status TEXT,
+			host_key TEXT DEFAULT '',
+			server_id TEXT DEFAULT '',
+			error TEXT DEFAULT '',
+			log TEXT DEFAULT '',
+			created_at DATETIME,
+			finished_at DATETIME
+		);`,
+		`CREATE TABLE IF NOT EXISTS ssh_host_keys (
+			host TEXT PRIMARY KEY,
+			fingerprint TEXT,
+			updated_at DATETIME
+		);`,
+		`CREATE TABLE IF NOT EXISTS telegram_links (
+			chat_id INTEGER PRIMARY KEY,
+			user_id TEXT UNIQUE,
+			created_at DATETIME
+		);`,
+		`CREATE TABLE IF NOT EXISTS telegram_link_codes (
+			code_hash TEXT PRIMARY KEY,
+			user_id TEXT,
+			expires_at DATETIME
+		);`,
+		`CREATE TABLE IF NOT EXISTS feature_flags (
+			name TEXT PRIMARY KEY,
+			enabled INTEGER,
+			rollout INTEGER DEFAULT 100,
+			message TEXT DEFAULT '',
+			updated_at DATETIME
+		);`,
+		`CREATE TABLE IF NOT EXISTS abuse_flags (
+			id TEXT PRIMARY KEY,
+			user_id TEXT,
+			server_id TEXT,
+			devices INTEGER,
+			status TEXT DEFAULT 'open',
+			resolution TEXT DEFAULT '',
+			created_at DATETIME,
+			last_seen_at DATETIME,
+			resolved_at DATETIME
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_abuse_flags_user ON abuse_flags (user_id, server_id, status);`,
+		`CREATE TABLE IF NOT EXISTS activation_codes (
+			code TEXT PRIMARY KEY,
+			plan TEXT,
+			days INTEGER,
+			note TEXT DEFAULT '',
+			created_at DATETIME,
+			expires_at DATETIME,
+			redeemed_by TEXT,
+			redeemed_at DATETIME
 		);`,
 		`CREATE TABLE IF NOT EXISTS access_keys (
 			user_id TEXT,
 			server_id TEXT,
+			device_id TEXT DEFAULT '',
 			key_id TEXT,
 			access_url TEXT,
-			PRIMARY KEY (user_id, server_id),
+			rotated_at DATETIME,
+			usage_offset INTEGER DEFAULT 0,
+			created_at DATETIME,
+			PRIMARY KEY (user_id, server_id, device_id),
 			FOREIGN KEY(user_id) REFERENCES users(id),
 			FOREIGN KEY(server_id) REFERENCES servers(id)
 		);`,
+		`CREATE TABLE IF NOT EXISTS devices (
+			user_id TEXT,
+			id TEXT,
+			name TEXT DEFAULT '',
+			created_at DATETIME,
+			last_seen_at DATETIME,
+			PRIMARY KEY (user_id, id),
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS revoked_key_usage (
+			user_id TEXT,
+			server_id TEXT,
+			bytes INTEGER DEFAULT 0,
+			PRIMARY KEY (user_id, server_id)
+		);`,
 	}
 
 	for _, q := range queries {
@@ -164,8 +813,90 @@ func initDB(db *sql.DB) {
 		`ALTER TABLE servers ADD COLUMN xray_username TEXT DEFAULT '';`,
 		`ALTER TABLE servers ADD COLUMN xray_password TEXT DEFAULT '';`,
 		`ALTER TABLE servers ADD COLUMN xray_settings TEXT DEFAULT '{}';`,
+		`ALTER TABLE users ADD COLUMN quota_reset_at DATETIME;`,
+		`ALTER TABLE payments ADD COLUMN plan TEXT DEFAULT '';`,
+		`ALTER TABLE users ADD COLUMN legacy_token_revoked BOOLEAN DEFAULT 0;`,
+		`ALTER TABLE payments ADD COLUMN confirmation_url TEXT DEFAULT '';`,
+		`ALTER TABLE servers ADD COLUMN health_ok BOOLEAN DEFAULT 1;`,
+		`ALTER TABLE servers ADD COLUMN health_latency_ms INTEGER DEFAULT 0;`,
+		`ALTER TABLE servers ADD COLUMN health_error TEXT DEFAULT '';`,
+		`ALTER TABLE servers ADD COLUMN health_checked_at DATETIME;`,
+		`ALTER TABLE access_keys ADD COLUMN rotated_at DATETIME;`,
+		`ALTER TABLE access_keys ADD COLUMN usage_offset INTEGER DEFAULT 0;`,
+		`ALTER TABLE servers ADD COLUMN archived BOOLEAN DEFAULT 0;`,
+		`ALTER TABLE servers ADD COLUMN archived_at DATETIME;`,
+		`ALTER TABLE servers ADD COLUMN archive_reason TEXT DEFAULT '';`,
+		`ALTER TABLE users ADD COLUMN sub_token TEXT DEFAULT '';`,
+		`CREATE INDEX IF NOT EXISTS idx_users_sub_token ON users (sub_token);`,
+		`ALTER TABLE servers ADD COLUMN max_users INTEGER DEFAULT 0;`,
+		`CREATE INDEX IF NOT EXISTS idx_access_keys_server ON access_keys (server_id);`,
+		`ALTER TABLE users ADD COLUMN auto_renew BOOLEAN DEFAULT 0;`,
+		`ALTER TABLE users ADD COLUMN payment_method_id TEXT DEFAULT '';`,
+		`ALTER TABLE users ADD COLUMN payment_method_title TEXT DEFAULT '';`,
+		`ALTER TABLE users ADD COLUMN renewal_attempted_at DATETIME;`,
+		`ALTER TABLE users ADD COLUMN renewal_failures INTEGER DEFAULT 0;`,
+		`ALTER TABLE users ADD COLUMN next_plan TEXT DEFAULT '';`,
+		`ALTER TABLE users ADD COLUMN paused_at DATETIME;`,
+		`ALTER TABLE users ADD COLUMN paused_until DATETIME;`,
+		`ALTER TABLE users ADD COLUMN family_owner_id TEXT DEFAULT '';`,
+		`ALTER TABLE users ADD COLUMN family_joined_at DATETIME;`,
+		`CREATE INDEX IF NOT EXISTS idx_users_family_owner ON users (family_owner_id);`,
+		`ALTER TABLE sessions ADD COLUMN device_id TEXT DEFAULT '';`,
+		`ALTER TABLE payments ADD COLUMN region TEXT DEFAULT '';`,
+		`ALTER TABLE servers ADD COLUMN tags TEXT DEFAULT '';`,
+		`ALTER TABLE users ADD COLUMN family_member_id TEXT DEFAULT '';`,
+		// Members who joined before memberships had their own IDs.
+		`UPDATE users SET family_member_id = lower(hex(randomblob(16))) WHERE family_owner_id != '' AND family_member_id = '';`,
 	}
 	for _, m := range migrations {
 		db.Exec(m) // Ignore errors (column already exists)
 	}
+	if err := migrateDeviceKeys(db); err != nil {
+		log.Printf("Error migrating access keys: %v", err)
+	}
+}
+
+// migrateDeviceKeys rebuilds an access_keys table from before devices, whose
+// primary key left room for one key per user and server. The keys it had
+// become the users' account keys, of device "".
+func migrateDeviceKeys(db *sql.DB) error {
+	var hasDevices bool
+	if err := db.QueryRow("SELECT COUNT(*) > 0 FROM pragma_table_info('access_keys') WHERE name = 'device_id'").Scan(&hasDevices); err != nil {
+		return err
+	}
+	if hasDevices {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, q := range []string{
+		`CREATE TABLE access_keys_devices (
+			user_id TEXT,
+			server_id TEXT,
+			device_id TEXT DEFAULT '',
+			key_id TEXT,
+			access_url TEXT,
+			rotated_at DATETIME,
+			usage_offset INTEGER DEFAULT 0,
+			created_at DATETIME,
+			PRIMARY KEY (user_id, server_id, device_id),
+			FOREIGN KEY(user_id) REFERENCES users(id),
+			FOREIGN KEY(server_id) REFERENCES servers(id)
+		);`,
+		`INSERT INTO access_keys_devices (user_id, server_id, key_id, access_url, rotated_at, usage_offset)
+			SELECT user_id, server_id, key_id, access_url, rotated_at, usage_offset FROM access_keys;`,
+		`DROP TABLE access_keys;`,
+		`ALTER TABLE access_keys_devices RENAME TO access_keys;`,
+		// Dropping the old table dropped its index.
+		`CREATE INDEX IF NOT EXISTS idx_access_keys_server ON access_keys (server_id);`,
+	} {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	log.Printf("Migrated access keys to per-device keys")
+	return tx.Commit()
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// labelEscaper escapes Prometheus label values. Unlike %q, it leaves
+// non-ASCII characters, such as in country names, as they are.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// requireMetrics protects /metrics, as the state, traffic and capacity of the
+// servers are for operators only. It takes MetricsToken, as Prometheus sends
+// it with authorization credentials, or the admin credentials.
+func (s *Server) requireMetrics(next http.HandlerFunc) http.HandlerFunc {
+	admin := s.requireAdmin(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if s.Cfg.MetricsToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.Cfg.MetricsToken)) == 1 {
+			next(w, r)
+			return
+		}
+		admin(w, r)
+	}
+}
+
+// handleMetrics exposes runtime metrics in the Prometheus text format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	statuses := s.Breakers.Snapshot()
+
+	fmt.Fprintln(w, "# HELP drfrake_provider_breaker_state Circuit breaker state per server (0=closed, 1=half-open, 2=open).")
+	fmt.Fprintln(w, "# TYPE drfrake_provider_breaker_state gauge")
+	for _, st := range statuses {
+		fmt.Fprintf(w, "drfrake_provider_breaker_state{server_id=%q} %d\n", st.ServerID, st.state)
+	}
+
+	fmt.Fprintln(w, "# HELP drfrake_provider_breaker_failures Consecutive provider failures per server.")
+	fmt.Fprintln(w, "# TYPE drfrake_provider_breaker_failures gauge")
+	for _, st := range statuses {
+		fmt.Fprintf(w, "drfrake_provider_breaker_failures{server_id=%q} %d\n", st.ServerID, st.Failures)
+	}
+
+	traffic := s.Traffic.Snapshot()
+	labels := func(st ServerTraffic) string {
+		return fmt.Sprintf(`server_id="%s",country="%s",type="%s"`,
+			labelEscaper.Replace(st.ServerID), labelEscaper.Replace(st.Country), labelEscaper.Replace(st.Type))
+	}
+
+	fmt.Fprintln(w, "# HELP drfrake_server_transfer_bytes Bytes transferred by all keys as reported by the server (Outline: last 30 days, 3X-UI: since the last counter reset).")
+	fmt.Fprintln(w, "# TYPE drfrake_server_transfer_bytes gauge")
+	for _, st := range traffic {
+		fmt.Fprintf(w, "drfrake_server_transfer_bytes{%s} %d\n", labels(st), st.Bytes)
+	}
+
+	fmt.Fprintln(w, "# HELP drfrake_server_transfer_bytes_total Bytes transferred by all keys since the backend started, sampled every minute.")
+	fmt.Fprintln(w, "# TYPE drfrake_server_transfer_bytes_total counter")
+	for _, st := range traffic {
+		fmt.Fprintf(w, "drfrake_server_transfer_bytes_total{%s} %d\n", labels(st), st.Observed)
+	}
+
+	fmt.Fprintln(w, "# HELP drfrake_server_transfer_up Whether the last collection of the server's transfer succeeded.")
+	fmt.Fprintln(w, "# TYPE drfrake_server_transfer_up gauge")
+	for _, st := range traffic {
+		up := 0
+		if st.Up {
+			up = 1
+		}
+		fmt.Fprintf(w, "drfrake_server_transfer_up{%s} %d\n", labels(st), up)
+	}
+
+	fmt.Fprintln(w, "# HELP drfrake_server_keys Access keys issued on the server.")
+	fmt.Fprintln(w, "# TYPE drfrake_server_keys gauge")
+	for _, st := range traffic {
+		fmt.Fprintf(w, "drfrake_server_keys{%s} %d\n", labels(st), st.Keys)
+	}
+
+	fmt.Fprintln(w, "# HELP drfrake_server_users Users with access keys on the server, which count against max_users.")
+	fmt.Fprintln(w, "# TYPE drfrake_server_users gauge")
+	for _, st := range traffic {
+		fmt.Fprintf(w, "drfrake_server_users{%s} %d\n", labels(st), st.Users)
+	}
+
+	fmt.Fprintln(w, "# HELP drfrake_server_max_users Number of users the server takes, 0 for unlimited.")
+	fmt.Fprintln(w, "# TYPE drfrake_server_max_users gauge")
+	for _, st := range traffic {
+		fmt.Fprintf(w, "drfrake_server_max_users{%s} %d\n", labels(st), st.MaxUsers)
+	}
+}
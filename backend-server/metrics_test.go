@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestLabelEscaper(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "plain", input: "Frankfurt", want: "Frankfurt"},
+		{name: "non-ASCII", input: "Zürich", want: "Zürich"},
+		{name: "quote", input: `Côte d"Ivoire`, want: `Côte d\"Ivoire`},
+		{name: "backslash", input: `a\b`, want: `a\\b`},
+		{name: "newline", input: "a\nb", want: `a\nb`},
+		{name: "escaped quote", input: `\"`, want: `\\\"`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := labelEscaper.Replace(tc.input); got != tc.want {
+				t.Errorf("labelEscaper.Replace(%q) = %q, want %q", tc.input, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MockProvider implements VPNProvider without any real VPN server. It issues
+// deterministic fake ss:// keys so the registration → payment → provisioning
+// flow can be exercised in local development and end-to-end tests.
+type MockProvider struct {
+	serverHost string
+	store      *mockKeyStore
+}
+
+// mockKeyStore holds the keys of one mock server. Stores are shared per host
+// because providers are created per request.
+type mockKeyStore struct {
+	mu     sync.Mutex
+	keys   map[string]VPNKey
+	limits map[string]int64
+	// rotations counts deleted keys per user, so a rotated key gets a new secret.
+	rotations map[string]int
+}
+
+var (
+	mockStoresMu sync.Mutex
+	mockStores   = map[string]*mockKeyStore{}
+)
+
+// NewMockProvider creates a mock provider. Keys point to serverHost, or to
+// 127.0.0.1 if it is empty.
+func NewMockProvider(serverHost string) *MockProvider {
+	if serverHost == "" {
+		serverHost = "127.0.0.1"
+	}
+	mockStoresMu.Lock()
+	defer mockStoresMu.Unlock()
+	store, ok := mockStores[serverHost]
+	if !ok {
+		store = &mockKeyStore{
+			keys:      make(map[string]VPNKey),
+			limits:    make(map[string]int64),
+			rotations: make(map[string]int),
+		}
+		mockStores[serverHost] = store
+	}
+	return &MockProvider{serverHost: serverHost, store: store}
+}
+
+func (p *MockProvider) CreateKey(userID string) (string, string, error) {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+
+	seed := p.serverHost + "/" + userID
+	if n := p.store.rotations[userID]; n > 0 {
+		seed += fmt.Sprintf("/%d", n)
+	}
+	sum := sha256.Sum256([]byte(seed))
+	keyID := hex.EncodeToString(sum[:4])
+	secret := hex.EncodeToString(sum[4:16])
+	userInfo := base64.RawURLEncoding.EncodeToString([]byte("chacha20-ietf-poly1305:" + secret))
+	accessURL := fmt.Sprintf("ss://%s@%s:8388/?outline=1#mock-%s", userInfo, p.serverHost, keyID)
+
+	p.store.keys[keyID] = VPNKey{ID: keyID, Name: "user-" + userID, AccessURL: accessURL}
+	return keyID, accessURL, nil
+}
+
+func (p *MockProvider) DeleteKey(keyID string) error {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+	key, ok := p.store.keys[keyID]
+	if !ok {
+		return fmt.Errorf("mock key %s not found", keyID)
+	}
+	delete(p.store.keys, keyID)
+	p.store.rotations[strings.TrimPrefix(key.Name, "user-")]++
+	return nil
+}
+
+func (p *MockProvider) GetKeys() ([]VPNKey, error) {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+	keys := make([]VPNKey, 0, len(p.store.keys))
+	for _, k := range p.store.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+func (p *MockProvider) SetName(keyID string, name string) error {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+	key, ok := p.store.keys[keyID]
+	if !ok {
+		return fmt.Errorf("mock key %s not found", keyID)
+	}
+	key.Name = name
+	p.store.keys[keyID] = key
+	return nil
+}
+
+func (p *MockProvider) SetDataLimit(keyID string, bytes int64) error {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+	if _, ok := p.store.keys[keyID]; !ok {
+		return fmt.Errorf("mock key %s not found", keyID)
+	}
+	p.store.limits[keyID] = bytes
+	return nil
+}
+
+// GetUsage always returns 0: mock keys carry no traffic.
+func (p *MockProvider) GetUsage(keyID string) (int64, error) {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+	if _, ok := p.store.keys[keyID]; !ok {
+		return 0, fmt.Errorf("mock key %s not found", keyID)
+	}
+	return 0, nil
+}
+
+func (p *MockProvider) ResetUsage(keyID string) error {
+	return nil
+}
+
+// GetConnections reports no connections: mock keys carry no traffic.
+func (p *MockProvider) GetConnections() (map[string]int, error) {
+	return map[string]int{}, nil
+}
+
+// GetTransfer reports no transfer: mock keys carry no traffic.
+func (p *MockProvider) GetTransfer() (map[string]int64, error) {
+	return map[string]int64{}, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseNotification(t *testing.T) {
+	cases := []struct {
+		name    string
+		body    string
+		wantErr string // Substring of the error, "" for none
+	}{
+		{
+			name: "succeeded",
+			body: `{"type":"notification","event":"payment.succeeded","object":{"id":"2d2f-000f","status":"succeeded","amount":{"value":"299.00"}}}`,
+		},
+		{
+			name: "refund",
+			body: `{"type":"notification","event":"refund.succeeded","object":{"id":"r1","status":"succeeded"}}`,
+		},
+		{name: "not JSON", body: `payment`, wantErr: "invalid notification"},
+		{name: "empty", body: ``, wantErr: "invalid notification"},
+		{
+			name:    "unknown field",
+			body:    `{"type":"notification","event":"payment.succeeded","object":{"id":"p1","status":"succeeded"},"extra":1}`,
+			wantErr: "invalid notification",
+		},
+		{
+			name:    "trailing data",
+			body:    `{"type":"notification","event":"payment.succeeded","object":{"id":"p1","status":"succeeded"}} {}`,
+			wantErr: "trailing data",
+		},
+		{name: "no object", body: `{"type":"notification","event":"payment.succeeded"}`, wantErr: "object must be an object"},
+		{name: "object not an object", body: `{"type":"notification","event":"payment.succeeded","object":"p1"}`, wantErr: "object must be an object"},
+		{
+			name:    "wrong type",
+			body:    `{"type":"event","event":"payment.succeeded","object":{"id":"p1","status":"succeeded"}}`,
+			wantErr: "type must be",
+		},
+		{
+			name:    "bad event",
+			body:    `{"type":"notification","event":"succeeded","object":{"id":"p1","status":"succeeded"}}`,
+			wantErr: "event must be",
+		},
+		{
+			name:    "missing ID",
+			body:    `{"type":"notification","event":"payment.succeeded","object":{"status":"succeeded"}}`,
+			wantErr: "object.id must be",
+		},
+		{
+			name:    "bad ID",
+			body:    `{"type":"notification","event":"payment.succeeded","object":{"id":"../p1","status":"succeeded"}}`,
+			wantErr: "object.id must be",
+		},
+		{
+			name:    "long ID",
+			body:    `{"type":"notification","event":"payment.succeeded","object":{"id":"` + strings.Repeat("a", 65) + `","status":"succeeded"}}`,
+			wantErr: "object.id must be",
+		},
+		{
+			name:    "bad status",
+			body:    `{"type":"notification","event":"payment.succeeded","object":{"id":"p1","status":"Succeeded!"}}`,
+			wantErr: "object.status must be",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			n, err := parseNotification([]byte(tc.body))
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("parseNotification: %v", err)
+				}
+				if n.Type != "notification" || n.Object.ID == "" || n.Object.Status == "" {
+					t.Errorf("parseNotification = %+v, missing fields", *n)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("parseNotification error = %v, want one containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestNotificationAuthVerify(t *testing.T) {
+	body := []byte(`{"type":"notification"}`)
+	sign := func(secret string, body []byte) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	cases := []struct {
+		name      string
+		auth      NotificationAuth
+		user      string
+		pass      string
+		signature string
+		wantOK    bool
+	}{
+		{name: "unauthenticated", wantOK: true},
+		{name: "basic auth", auth: NotificationAuth{Username: "shop", Password: "secret"}, user: "shop", pass: "secret", wantOK: true},
+		{name: "wrong password", auth: NotificationAuth{Username: "shop", Password: "secret"}, user: "shop", pass: "guess"},
+		{name: "wrong user", auth: NotificationAuth{Username: "shop", Password: "secret"}, user: "other", pass: "secret"},
+		{name: "no credentials", auth: NotificationAuth{Username: "shop", Password: "secret"}},
+		{name: "signature", auth: NotificationAuth{Secret: "key"}, signature: sign("key", body), wantOK: true},
+		{name: "signature with other key", auth: NotificationAuth{Secret: "key"}, signature: sign("other", body)},
+		{name: "signature of other body", auth: NotificationAuth{Secret: "key"}, signature: sign("key", []byte("{}"))},
+		{name: "signature without prefix", auth: NotificationAuth{Secret: "key"}, signature: strings.TrimPrefix(sign("key", body), "sha256=")},
+		{name: "signature not hex", auth: NotificationAuth{Secret: "key"}, signature: "sha256=zz"},
+		{name: "no signature", auth: NotificationAuth{Secret: "key"}},
+		{
+			name: "both", auth: NotificationAuth{Username: "shop", Password: "secret", Secret: "key"},
+			user: "shop", pass: "secret", signature: sign("key", body), wantOK: true,
+		},
+		{
+			name: "both without signature", auth: NotificationAuth{Username: "shop", Password: "secret", Secret: "key"},
+			user: "shop", pass: "secret",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/payment/webhook", nil)
+			if tc.user != "" || tc.pass != "" {
+				r.SetBasicAuth(tc.user, tc.pass)
+			}
+			if tc.signature != "" {
+				r.Header.Set("X-Webhook-Signature", tc.signature)
+			}
+			err := tc.auth.Verify(r, body)
+			if tc.wantOK && err != nil {
+				t.Errorf("Verify: %v", err)
+			} else if !tc.wantOK && !errors.Is(err, errNotificationAuth) {
+				t.Errorf("Verify = %v, want %v", err, errNotificationAuth)
+			}
+		})
+	}
+}
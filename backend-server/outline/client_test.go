@@ -0,0 +1,81 @@
+package outline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPinnedCertVerifier(t *testing.T) {
+	leaf := []byte("leaf certificate")
+	sum := sha256.Sum256(leaf)
+	fingerprint := hex.EncodeToString(sum[:])
+	colons := strings.ToUpper(fingerprint[0:2])
+	for i := 2; i < len(fingerprint); i += 2 {
+		colons += ":" + strings.ToUpper(fingerprint[i:i+2])
+	}
+
+	cases := []struct {
+		name        string
+		fingerprint string
+		rawCerts    [][]byte
+		wantErr     string // Substring of the error, "" for none
+	}{
+		{name: "match", fingerprint: fingerprint, rawCerts: [][]byte{leaf}},
+		{name: "colons and upper case", fingerprint: colons, rawCerts: [][]byte{leaf}},
+		{name: "surrounding space", fingerprint: " " + fingerprint + "\n", rawCerts: [][]byte{leaf}},
+		{name: "leaf is checked, not the chain", fingerprint: fingerprint, rawCerts: [][]byte{[]byte("other"), leaf}, wantErr: "does not match"},
+		{name: "other certificate", fingerprint: fingerprint, rawCerts: [][]byte{[]byte("other")}, wantErr: "does not match"},
+		{name: "no certificates", fingerprint: fingerprint, wantErr: "does not match"},
+		{name: "not hex", fingerprint: "zz" + fingerprint[2:], rawCerts: [][]byte{leaf}, wantErr: "invalid certificate fingerprint"},
+		{name: "too short", fingerprint: fingerprint[:62], rawCerts: [][]byte{leaf}, wantErr: "invalid certificate fingerprint length"},
+		{name: "empty", fingerprint: "", rawCerts: [][]byte{leaf}, wantErr: "empty certificate fingerprint"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := pinnedCertVerifier(tc.fingerprint)(tc.rawCerts, nil)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("verify: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("verify error = %v, want one containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestClientPinning(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"accessKeys":[]}`))
+	}))
+	defer srv.Close()
+	sum := sha256.Sum256(srv.Certificate().Raw)
+	other := sha256.Sum256([]byte("other"))
+
+	cases := []struct {
+		name        string
+		fingerprint string
+		wantErr     bool
+	}{
+		{name: "pinned", fingerprint: hex.EncodeToString(sum[:])},
+		{name: "not pinned", fingerprint: ""},
+		{name: "mismatch", fingerprint: hex.EncodeToString(other[:]), wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewClient(srv.URL, tc.fingerprint).GetKeys()
+			if tc.wantErr && !errors.Is(err, ErrCertificateMismatch) {
+				t.Errorf("GetKeys = %v, want %v", err, ErrCertificateMismatch)
+			} else if !tc.wantErr && err != nil {
+				t.Errorf("GetKeys: %v", err)
+			}
+		})
+	}
+}
@@ -17,7 +17,7 @@ func NewOutlineProvider(apiURL, certSHA256 string) *OutlineProvider {
 }
 
 func (p *OutlineProvider) CreateKey(userID string) (string, string, error) {
-	key, err := p.client.CreateKey()
+	key, err := p.client.CreateKey(nil)
 	if err != nil {
 		return "", "", err
 	}
@@ -49,3 +49,34 @@ func (p *OutlineProvider) GetKeys() ([]VPNKey, error) {
 func (p *OutlineProvider) SetName(keyID string, name string) error {
 	return p.client.SetName(keyID, name)
 }
+
+func (p *OutlineProvider) SetDataLimit(keyID string, bytes int64) error {
+	return p.client.SetDataLimit(keyID, bytes)
+}
+
+// GetUsage returns the transfer of the key over the last 30 days: Outline
+// enforces data limits over a rolling window rather than calendar periods.
+func (p *OutlineProvider) GetUsage(keyID string) (int64, error) {
+	transfer, err := p.client.GetMetricsTransfer()
+	if err != nil {
+		return 0, err
+	}
+	return transfer[keyID], nil
+}
+
+// GetConnections reports the peak number of simultaneous devices per key over
+// the last hour.
+func (p *OutlineProvider) GetConnections() (map[string]int, error) {
+	return p.client.GetPeakDeviceCounts("1h")
+}
+
+// GetTransfer returns the transfer of each key over the last 30 days.
+func (p *OutlineProvider) GetTransfer() (map[string]int64, error) {
+	return p.client.GetMetricsTransfer()
+}
+
+// ResetUsage is a no-op: Outline's counters can't be reset, and old traffic
+// leaves the 30-day window on its own.
+func (p *OutlineProvider) ResetUsage(keyID string) error {
+	return nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Pausing a subscription: users on a paid plan can pause it for up to
+// MaxPauseDays days per rolling year, e.g. while they travel. While paused
+// their keys are capped at 1 byte, as if their allowance were used up, and
+// renewals and expiry leave the plan alone. The expiry is pushed out by the
+// days asked for when the pause starts; resuming early gives back the days
+// not used, counted in whole days.
+
+var (
+	errPauseUnavailable = errors.New("only active paid plans can be paused")
+	errAlreadyPaused    = errors.New("subscription already paused")
+	errNotPaused        = errors.New("subscription not paused")
+)
+
+// pauseDaysUsed returns how many days the user paused their plan for in the
+// year before now, counting the days asked for of a running pause.
+func (s *Server) pauseDaysUsed(userID string, now time.Time) (int, error) {
+	var days int
+	err := s.DB.QueryRow("SELECT COALESCE(SUM(days), 0) FROM subscription_pauses WHERE user_id = ? AND started_at > ?",
+		userID, now.AddDate(-1, 0, 0)).Scan(&days)
+	return days, err
+}
+
+// pauseDaysLeft returns how many more days the user can pause their plan for.
+func (s *Server) pauseDaysLeft(userID string, now time.Time) (int, error) {
+	used, err := s.pauseDaysUsed(userID, now)
+	if err != nil {
+		return 0, err
+	}
+	return max(s.Cfg.MaxPauseDays-used, 0), nil
+}
+
+// isPaused reports whether the user's plan is paused, or the family plan
+// they share.
+func (s *Server) isPaused(userID string) bool {
+	var paused bool
+	s.DB.QueryRow(`SELECT u.paused_at IS NOT NULL OR (u.expiry_date IS NULL AND o.paused_at IS NOT NULL)
+		FROM users u LEFT JOIN users o ON o.id = u.family_owner_id WHERE u.id = ?`, userID).Scan(&paused)
+	return paused
+}
+
+// keyPlan returns the plan whose data cap applies to the keys of a user on
+// plan planName: the plan itself, or an exhausted one while it is paused.
+func (s *Server) keyPlan(userID, planName string) Plan {
+	plan := s.plan(planName)
+	if s.isPaused(userID) {
+		plan.MonthlyDataBytes = 1
+	}
+	return plan
+}
+
+// pauseSubscription pauses the user's plan for days days. The caller holds
+// the user's lock.
+func (s *Server) pauseSubscription(userID string, days int, now time.Time) error {
+	plan, expiry, ok, err := s.activePaidPlan(userID, now)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errPauseUnavailable
+	}
+	if s.isPaused(userID) {
+		return errAlreadyPaused
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE users SET paused_at = ?, paused_until = ?, expiry_date = ? WHERE id = ? AND paused_at IS NULL",
+		now, now.AddDate(0, 0, days), expiry.AddDate(0, 0, days), userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errAlreadyPaused
+	}
+	if _, err := tx.Exec("INSERT INTO subscription_pauses (id, user_id, started_at, days) VALUES (?, ?, ?, ?)",
+		uuid.New().String(), userID, now, days); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("[Pause] User %s paused the %s plan for %d days", userID, plan, days)
+	go s.applyPlanLimits(userID)
+	return nil
+}
+
+// resumeSubscription ends the pause of the user's plan. The days not used
+// are taken off the expiry again. The caller holds the user's lock.
+func (s *Server) resumeSubscription(userID string, now time.Time) error {
+	var pausedAt, pausedUntil, expiry sql.NullTime
+	if err := s.DB.QueryRow("SELECT paused_at, paused_until, expiry_date FROM users WHERE id = ?", userID).
+		Scan(&pausedAt, &pausedUntil, &expiry); err != nil {
+		return err
+	}
+	if !pausedAt.Valid {
+		return errNotPaused
+	}
+	var pauseID string
+	var planned int
+	if err := s.DB.QueryRow("SELECT id, days FROM subscription_pauses WHERE user_id = ? AND ended_at IS NULL ORDER BY started_at DESC LIMIT 1",
+		userID).Scan(&pauseID, &planned); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	// A started day counts as used.
+	used := planned
+	if pausedUntil.Valid && now.Before(pausedUntil.Time) {
+		used = int(math.Ceil(now.Sub(pausedAt.Time).Hours() / 24))
+		used = min(max(used, 1), planned)
+	}
+	var newExpiry interface{}
+	if expiry.Valid {
+		newExpiry = expiry.Time.UTC().AddDate(0, 0, used-planned)
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE users SET paused_at = NULL, paused_until = NULL, expiry_date = ? WHERE id = ? AND paused_at IS NOT NULL",
+		newExpiry, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errNotPaused
+	}
+	if pauseID != "" {
+		if _, err := tx.Exec("UPDATE subscription_pauses SET ended_at = ?, days = ? WHERE id = ?", now, used, pauseID); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("[Pause] User %s resumed their plan after %d of %d days", userID, used, planned)
+	go s.applyPlanLimits(userID)
+	return nil
+}
+
+// resumeDuePauses resumes the plans whose pause has run out by now. Run by
+// runSubscriptionExpiry, before plans are expired.
+func (s *Server) resumeDuePauses(now time.Time) {
+	rows, err := s.DB.Query("SELECT id FROM users WHERE paused_at IS NOT NULL AND paused_until <= ?", now.UTC())
+	if err != nil {
+		log.Printf("[Pause] Failed to query due pauses: %v", err)
+		return
+	}
+	var due []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			due = append(due, id)
+		}
+	}
+	rows.Close()
+
+	for _, userID := range due {
+		unlock := s.lockUser(userID)
+		if err := s.resumeSubscription(userID, now.UTC()); err != nil && !errors.Is(err, errNotPaused) {
+			log.Printf("[Pause] Failed to resume plan of user %s: %v", userID, err)
+		}
+		unlock()
+	}
+}
+
+// handlePauseSubscription pauses the user's plan for the days asked for,
+// up to what is left of their yearly allowance.
+func (s *Server) handlePauseSubscription(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	var req struct {
+		Days int `json:"days"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if s.Cfg.MaxPauseDays == 0 {
+		http.Error(w, "Pausing is disabled", 403)
+		return
+	}
+
+	unlock := s.lockUser(userID)
+	defer unlock()
+
+	now := time.Now().UTC()
+	left, err := s.pauseDaysLeft(userID, now)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	if req.Days < 1 || req.Days > left {
+		http.Error(w, "days must be between 1 and the pause days left", 400)
+		return
+	}
+	err = s.pauseSubscription(userID, req.Days, now)
+	switch {
+	case errors.Is(err, errPauseUnavailable):
+		http.Error(w, "Only active paid plans can be paused", 409)
+		return
+	case errors.Is(err, errAlreadyPaused):
+		http.Error(w, "Subscription is already paused", 409)
+		return
+	case err != nil:
+		log.Printf("[Pause] Failed to pause plan of user %s: %v", userID, err)
+		http.Error(w, "Database error", 500)
+		return
+	}
+
+	b, err := s.billing(userID, now)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	json.NewEncoder(w).Encode(b)
+}
+
+// handleResumeSubscription ends the pause of the user's plan early.
+func (s *Server) handleResumeSubscription(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	unlock := s.lockUser(userID)
+	defer unlock()
+
+	now := time.Now().UTC()
+	err := s.resumeSubscription(userID, now)
+	switch {
+	case errors.Is(err, errNotPaused):
+		http.Error(w, "Subscription is not paused", 409)
+		return
+	case err != nil:
+		log.Printf("[Pause] Failed to resume plan of user %s: %v", userID, err)
+		http.Error(w, "Database error", 500)
+		return
+	}
+
+	b, err := s.billing(userID, now)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	json.NewEncoder(w).Encode(b)
+}
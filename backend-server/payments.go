@@ -0,0 +1,420 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// applyPaymentStatus stores the gateway status of a payment and upgrades the
+// paying user when it has succeeded. Succeeded and refunded are final
+// locally: the gateway keeps reporting a refunded payment as succeeded, and
+// taking that over would credit the plan again.
+func (s *Server) applyPaymentStatus(payResp *PaymentResponse) error {
+	res, err := s.DB.Exec("UPDATE payments SET status = ? WHERE yookassa_id = ? AND status NOT IN ('succeeded', 'refunded')", payResp.Status, payResp.ID)
+	if err != nil {
+		return err
+	}
+	changed, _ := res.RowsAffected()
+	if payResp.Status != "succeeded" {
+		return nil
+	}
+
+	tier := payResp.Metadata.Tier
+	if tier == "" {
+		tier = "monthly"
+	}
+
+	// Payments are checked repeatedly by clients; extend the plan and notify
+	// only once.
+	if changed > 0 {
+		expiry, err := s.extendPaidPlan(payResp.Metadata.UserID, tier, payResp.Metadata.Credit != "")
+		if err != nil {
+			return err
+		}
+		go s.applyPlanLimits(payResp.Metadata.UserID)
+		if m := payResp.PaymentMethod; m != nil && m.Saved {
+			if _, err := s.DB.Exec("UPDATE users SET payment_method_id = ?, payment_method_title = ?, auto_renew = 1 WHERE id = ?",
+				m.ID, m.Title, payResp.Metadata.UserID); err != nil {
+				return err
+			}
+		}
+		go s.sendReceipt(payResp, tier, expiry)
+		if s.Telegram != nil {
+			go s.notifyTelegramPayment(payResp.Metadata.UserID, tier)
+		}
+		s.emitEvent(EventPaymentSucceeded, map[string]string{
+			"payment_id": payResp.ID,
+			"user_id":    payResp.Metadata.UserID,
+			"plan":       tier,
+			"amount":     payResp.Amount.Value,
+			"currency":   payResp.Amount.Currency,
+		})
+	}
+	return nil
+}
+
+// PaidPlan is a plan sold through the payment gateway.
+type PaidPlan struct {
+	Amount      string // In RUB
+	Description string
+	Months      int // Period a payment buys
+	// Seats is how many accounts the plan covers, the buyer's included. Plans
+	// with more than one are family plans, see family.go.
+	Seats int
+}
+
+// paidPlans are the plans users can buy.
+var paidPlans = map[string]PaidPlan{
+	"monthly": {Amount: "299.00", Description: "Dr. Frake VPN — Premium Monthly", Months: 1},
+	"yearly":  {Amount: "2990.00", Description: "Dr. Frake VPN — Premium Yearly", Months: 12},
+	"family":  {Amount: "599.00", Description: "Dr. Frake VPN — Premium Family, 5 accounts", Months: 1, Seats: 5},
+}
+
+// startPayment creates a payment for a paid plan and returns it with the URL
+// the user confirms it at. If the user already started one for this plan, the
+// same payment is handed out again instead of piling up pending payments on
+// every click. Upgrades from a shorter plan are prorated, see prorate. The
+// plan is charged at its price in region, see planPrice.
+func (s *Server) startPayment(userID, plan, region string) (*PendingPayment, error) {
+	paid, ok := paidPlans[plan]
+	if !ok {
+		return nil, fmt.Errorf("invalid plan %q", plan)
+	}
+
+	unlock := s.lockUser(userID)
+	defer unlock()
+	pending, err := s.pendingPayment(userID, plan)
+	if err != nil {
+		log.Printf("Failed to look up pending payment of user %s: %v", userID, err)
+	} else if pending != nil {
+		return pending, nil
+	}
+
+	returnURL := s.Cfg.YookassaReturnURL
+	if returnURL == "" {
+		returnURL = "https://google.com"
+	}
+
+	amount, region := s.planPrice(plan, region)
+	description := paid.Description
+	metadata := PaymentMetadata{UserID: userID, Tier: plan, Region: region}
+	proration, err := s.prorate(userID, plan, region, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if proration != nil {
+		amount, description = formatAmount(proration.Amount), prorationDescription(paid, proration)
+		metadata.Credit, metadata.FromPlan = formatAmount(proration.Credit), proration.FromPlan
+	}
+
+	// Call YooKassa API (server-side only!)
+	payResp, err := s.Payments.CreatePayment(amount, description, metadata, returnURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Store payment in DB. Without the row the payment couldn't be checked
+	// or handed out again, so the user doesn't get to pay it.
+	if _, err := s.DB.Exec("INSERT INTO payments (id, user_id, yookassa_id, amount, status, plan, confirmation_url, region) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		payResp.ID, userID, payResp.ID, amount, payResp.Status, plan, payResp.Confirmation.ConfirmationURL, region); err != nil {
+		log.Printf("[Payments] Failed to record payment %s of user %s: %v", payResp.ID, userID, err)
+		return nil, errors.New("failed to record the payment")
+	}
+
+	return &PendingPayment{
+		ID:              payResp.ID,
+		Status:          payResp.Status,
+		Plan:            plan,
+		Amount:          amount,
+		Region:          region,
+		ConfirmationURL: payResp.Confirmation.ConfirmationURL,
+		CreatedAt:       time.Now().UTC(),
+	}, nil
+}
+
+// pendingPaymentReuseWindow is how long an unpaid payment is handed out again
+// instead of creating a new one when the user retries.
+const pendingPaymentReuseWindow = 30 * time.Minute
+
+// PendingPayment is a payment awaiting the user's confirmation.
+type PendingPayment struct {
+	ID              string    `json:"id"`
+	Status          string    `json:"status"`
+	Plan            string    `json:"plan"`
+	Amount          string    `json:"amount"`
+	Region          string    `json:"region,omitempty"`
+	ConfirmationURL string    `json:"confirmation_url"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// pendingPayment returns the newest payment of the user created within the
+// reuse window that is still awaiting payment, for any plan if plan is empty.
+// Statuses are refreshed from the gateway first, so a payment completed in
+// the meantime is applied rather than returned. Returns nil if there is none.
+func (s *Server) pendingPayment(userID, plan string) (*PendingPayment, error) {
+	query := `SELECT yookassa_id, plan, amount, region, confirmation_url, created_at FROM payments
+		WHERE user_id = ? AND status IN ('pending', 'waiting_for_capture') AND confirmation_url != ''
+		AND created_at >= ?`
+	args := []interface{}{userID, time.Now().Add(-pendingPaymentReuseWindow).UTC().Format(time.DateTime)}
+	if plan != "" {
+		query += " AND plan = ?"
+		args = append(args, plan)
+	}
+	query += " ORDER BY created_at DESC, rowid DESC LIMIT 5"
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []PendingPayment
+	for rows.Next() {
+		var p PendingPayment
+		var amount float64
+		if err := rows.Scan(&p.ID, &p.Plan, &amount, &p.Region, &p.ConfirmationURL, &p.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		p.Amount = formatAmount(amount)
+		candidates = append(candidates, p)
+	}
+	rows.Close()
+
+	for _, p := range candidates {
+		payResp, err := s.Payments.GetPayment(p.ID)
+		if err != nil {
+			return nil, err
+		}
+		if payResp.Status == "pending" || payResp.Status == "waiting_for_capture" {
+			p.Status = payResp.Status
+			return &p, nil
+		}
+		if payResp.Metadata.UserID == "" {
+			payResp.Metadata.UserID = userID
+		}
+		if err := s.applyPaymentStatus(payResp); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// lockUser serializes state-changing requests of a user, such as payment
+// initiation and key rotation, so double clicks can't race each other. Call
+// the returned function to unlock.
+func (s *Server) lockUser(userID string) func() {
+	mu, _ := s.userLocks.LoadOrStore(userID, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// reconcileLookback is how far back the gateway's payment list is cross-checked.
+const reconcileLookback = 72 * time.Hour
+
+// ReconcileResult summarizes a reconciliation run.
+type ReconcileResult struct {
+	StartedAt time.Time `json:"started_at"`
+	// Checked, Succeeded and Canceled count locally pending payments re-checked with the gateway.
+	Checked   int `json:"checked"`
+	Succeeded int `json:"succeeded"`
+	Canceled  int `json:"canceled"`
+	// Listed payments were returned by the gateway for the lookback window;
+	// Healed of them had succeeded without being credited.
+	Listed     int               `json:"listed"`
+	Healed     int               `json:"healed"`
+	Mismatches []PaymentMismatch `json:"mismatches,omitempty"`
+	Errors     []string          `json:"errors,omitempty"`
+}
+
+// PaymentMismatch is a discrepancy between the gateway and the payments
+// table that needs an operator's attention.
+type PaymentMismatch struct {
+	PaymentID     string `json:"payment_id"`
+	UserID        string `json:"user_id,omitempty"`
+	Problem       string `json:"problem"`
+	LocalAmount   string `json:"local_amount,omitempty"`
+	GatewayAmount string `json:"gateway_amount,omitempty"`
+	LocalStatus   string `json:"local_status,omitempty"`
+	GatewayStatus string `json:"gateway_status,omitempty"`
+}
+
+// runReconciler reconciles payments every interval until the process exits.
+func (s *Server) runReconciler(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if _, err := s.reconcilePayments(); err != nil {
+			log.Printf("[Reconcile] Failed: %v", err)
+		}
+	}
+}
+
+// reconcilePayments re-checks every payment that is still pending locally
+// against the payment provider, so upgrades aren't lost when the client never
+// called /payment/check. It then cross-checks the gateway's recent payments
+// with the payments table, crediting succeeded payments that were missed and
+// flagging mismatches. The result is kept for GET /admin/reconcile.
+func (s *Server) reconcilePayments() (*ReconcileResult, error) {
+	result := &ReconcileResult{StartedAt: time.Now().UTC()}
+	if err := s.recheckPendingPayments(result); err != nil {
+		return nil, err
+	}
+	if err := s.crossCheckPayments(result, time.Now().Add(-reconcileLookback)); err != nil {
+		result.Errors = append(result.Errors, "listing payments: "+err.Error())
+	}
+
+	log.Printf("[Reconcile] Checked %d pending payments: %d succeeded, %d canceled; cross-checked %d: %d healed, %d mismatches; %d errors",
+		result.Checked, result.Succeeded, result.Canceled, result.Listed, result.Healed, len(result.Mismatches), len(result.Errors))
+	for _, m := range result.Mismatches {
+		log.Printf("[Reconcile] Mismatch on payment %s: %s", m.PaymentID, m.Problem)
+	}
+
+	s.reconcileMu.Lock()
+	s.lastReconcile = result
+	s.reconcileMu.Unlock()
+	return result, nil
+}
+
+func (s *Server) recheckPendingPayments(result *ReconcileResult) error {
+	rows, err := s.DB.Query(`SELECT yookassa_id, user_id FROM payments
+		WHERE status NOT IN ('succeeded', 'canceled', 'refunded')`)
+	if err != nil {
+		return err
+	}
+	type pending struct{ paymentID, userID string }
+	var payments []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.paymentID, &p.userID); err != nil {
+			rows.Close()
+			return err
+		}
+		payments = append(payments, p)
+	}
+	rows.Close()
+
+	for _, p := range payments {
+		result.Checked++
+		payResp, err := s.Payments.GetPayment(p.paymentID)
+		if err != nil {
+			result.Errors = append(result.Errors, p.paymentID+": "+err.Error())
+			continue
+		}
+		if payResp.Metadata.UserID == "" {
+			payResp.Metadata.UserID = p.userID
+		}
+		if err := s.applyPaymentStatus(payResp); err != nil {
+			result.Errors = append(result.Errors, p.paymentID+": "+err.Error())
+			continue
+		}
+		switch payResp.Status {
+		case "succeeded":
+			result.Succeeded++
+		case "canceled":
+			result.Canceled++
+		}
+	}
+	return nil
+}
+
+func (s *Server) crossCheckPayments(result *ReconcileResult, since time.Time) error {
+	payments, err := s.Payments.ListPayments(since)
+	if err != nil {
+		return err
+	}
+	result.Listed = len(payments)
+
+	for i := range payments {
+		remote := &payments[i]
+		var localUserID, localStatus string
+		var localAmount float64
+		err := s.DB.QueryRow("SELECT user_id, amount, status FROM payments WHERE yookassa_id = ?", remote.ID).
+			Scan(&localUserID, &localAmount, &localStatus)
+
+		if err == sql.ErrNoRows {
+			// Created but never recorded, e.g. the backend crashed before the INSERT.
+			if remote.Status != "succeeded" {
+				continue
+			}
+			if remote.Metadata.UserID == "" {
+				result.Mismatches = append(result.Mismatches, PaymentMismatch{
+					PaymentID:     remote.ID,
+					Problem:       "succeeded payment without user is missing locally",
+					GatewayAmount: remote.Amount.Value,
+					GatewayStatus: remote.Status,
+				})
+				continue
+			}
+			if _, err := s.DB.Exec("INSERT INTO payments (id, user_id, yookassa_id, amount, status, plan, region) VALUES (?, ?, ?, ?, ?, ?, ?)",
+				remote.ID, remote.Metadata.UserID, remote.ID, remote.Amount.Value, "pending", remote.Metadata.Tier, remote.Metadata.Region); err != nil {
+				result.Errors = append(result.Errors, remote.ID+": "+err.Error())
+				continue
+			}
+			if err := s.applyPaymentStatus(remote); err != nil {
+				result.Errors = append(result.Errors, remote.ID+": "+err.Error())
+				continue
+			}
+			result.Healed++
+			continue
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, remote.ID+": "+err.Error())
+			continue
+		}
+
+		if formatAmount(localAmount) != normalizeAmount(remote.Amount.Value) {
+			result.Mismatches = append(result.Mismatches, PaymentMismatch{
+				PaymentID:     remote.ID,
+				UserID:        localUserID,
+				Problem:       "amount differs",
+				LocalAmount:   formatAmount(localAmount),
+				GatewayAmount: remote.Amount.Value,
+			})
+		}
+
+		switch {
+		case remote.Status == "succeeded" && localStatus != "succeeded" && localStatus != "refunded":
+			if remote.Metadata.UserID == "" {
+				remote.Metadata.UserID = localUserID
+			}
+			if err := s.applyPaymentStatus(remote); err != nil {
+				result.Errors = append(result.Errors, remote.ID+": "+err.Error())
+				continue
+			}
+			result.Healed++
+		case localStatus == "succeeded" && remote.Status != "succeeded":
+			result.Mismatches = append(result.Mismatches, PaymentMismatch{
+				PaymentID:     remote.ID,
+				UserID:        localUserID,
+				Problem:       "credited locally but not succeeded at the gateway",
+				LocalStatus:   localStatus,
+				GatewayStatus: remote.Status,
+			})
+		}
+	}
+	return nil
+}
+
+// lastReconcileResult returns the report of the latest run, or nil.
+func (s *Server) lastReconcileResult() *ReconcileResult {
+	s.reconcileMu.Lock()
+	defer s.reconcileMu.Unlock()
+	return s.lastReconcile
+}
+
+// normalizeAmount formats a gateway amount like formatAmount, for comparison.
+func normalizeAmount(value string) string {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return value
+	}
+	return formatAmount(f)
+}
+
+// formatAmount formats a stored amount the way the payment gateway expects it.
+func formatAmount(amount float64) string {
+	return fmt.Sprintf("%.2f", amount)
+}
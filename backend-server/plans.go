@@ -0,0 +1,49 @@
+package main
+
+// Plan describes the limits of a subscription tier.
+type Plan struct {
+	Name string `json:"name"`
+	// MonthlyDataBytes caps the traffic of each of the user's access keys per
+	// quota period. 0 means unlimited.
+	MonthlyDataBytes int64 `json:"monthly_data_bytes"`
+}
+
+const gigabyte = 1 << 30
+
+// defaultPlans are the built-in tiers. Caps can be overridden per plan with
+// PlanDataLimitsGB in config.json.
+var defaultPlans = map[string]Plan{
+	"free":    {Name: "free", MonthlyDataBytes: 10 * gigabyte},
+	"monthly": {Name: "monthly", MonthlyDataBytes: 500 * gigabyte},
+	"yearly":  {Name: "yearly", MonthlyDataBytes: 500 * gigabyte},
+	"family":  {Name: "family", MonthlyDataBytes: 500 * gigabyte},
+}
+
+// loadPlans returns the built-in plans with the configured caps applied.
+func loadPlans(cfg *Config) map[string]Plan {
+	plans := make(map[string]Plan, len(defaultPlans))
+	for name, p := range defaultPlans {
+		plans[name] = p
+	}
+	for name, gb := range cfg.PlanDataLimitsGB {
+		plans[name] = Plan{Name: name, MonthlyDataBytes: gb * gigabyte}
+	}
+	return plans
+}
+
+// planHasPremium reports whether a plan gives access to premium servers: all
+// but the free plan do.
+func planHasPremium(plan string) bool {
+	return plan != "free"
+}
+
+// plan returns the plan with the given name. Unknown plans, e.g. ones granted
+// before they were removed from the config, get the limits of the free plan.
+func (s *Server) plan(name string) Plan {
+	if p, ok := s.Plans[name]; ok {
+		return p
+	}
+	p := s.Plans["free"]
+	p.Name = name
+	return p
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Regional pricing: with RegionalPricing on, a paid plan can have its own
+// price per country in the plan_prices table, e.g. a lower one where the
+// default is a lot of money. The country of a payment is the one GeoIP
+// places the client in; the one the client declares is only used when GeoIP
+// can't tell, so a VPN user can't pick the cheapest country. Failing both,
+// and for renewals and Telegram purchases, the region of the user's last paid
+// payment is used. Payments record their region for reporting.
+
+// PlanPrice is the price of a paid plan in one country.
+type PlanPrice struct {
+	Plan      string    `json:"plan"`
+	Country   string    `json:"country"`
+	Amount    string    `json:"amount"` // RUB
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RegionRevenue is what payments from one region brought in.
+type RegionRevenue struct {
+	Region   string  `json:"region"` // "" for the default price
+	Payments int     `json:"payments"`
+	Amount   float64 `json:"amount"` // RUB
+}
+
+// planPrice returns the price of plan for payments from region, and the
+// region the price is for: region itself if it has a price of its own, or ""
+// for the plan's default price.
+func (s *Server) planPrice(plan, region string) (amount, priced string) {
+	amount = paidPlans[plan].Amount
+	if !s.Cfg.RegionalPricing || region == "" {
+		return amount, ""
+	}
+	var regional string
+	err := s.DB.QueryRow("SELECT amount FROM plan_prices WHERE plan = ? AND country = ?", plan, region).Scan(&regional)
+	if err == sql.ErrNoRows {
+		return amount, ""
+	} else if err != nil {
+		log.Printf("[Pricing] Failed to look up the %s price of plan %s: %v", region, plan, err)
+		return amount, ""
+	}
+	return regional, region
+}
+
+// paymentRegion returns the country a payment request of the user comes
+// from, falling back to the region of their last payment, "" if regional
+// pricing is off or it is unknown.
+func (s *Server) paymentRegion(r *http.Request, userID, declared string) string {
+	if !s.Cfg.RegionalPricing {
+		return ""
+	}
+	ip := clientIP(r)
+	geo, err := s.Geo.Lookup(ip)
+	if err == nil && geo.CountryCode != "" {
+		return strings.ToUpper(geo.CountryCode)
+	}
+	if err != nil && !errors.Is(err, ErrPrivateIP) {
+		log.Printf("[GeoIP] Lookup of %s failed: %v", ip, err)
+	}
+	if validateCountryCode("country", declared) == nil {
+		return declared
+	}
+	return s.userRegion(userID)
+}
+
+// userRegion returns the region of the user's last paid payment, which
+// renewals are priced for.
+func (s *Server) userRegion(userID string) string {
+	var region string
+	s.DB.QueryRow("SELECT region FROM payments WHERE user_id = ? AND status = 'succeeded' ORDER BY created_at DESC, rowid DESC LIMIT 1",
+		userID).Scan(&region)
+	return region
+}
+
+func (s *Server) handleAdminListPrices(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.DB.Query("SELECT plan, country, amount, updated_at FROM plan_prices ORDER BY plan, country")
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+	prices := []PlanPrice{}
+	for rows.Next() {
+		var p PlanPrice
+		if err := rows.Scan(&p.Plan, &p.Country, &p.Amount, &p.UpdatedAt); err != nil {
+			log.Printf("[Pricing] Error scanning price row: %v", err)
+			continue
+		}
+		prices = append(prices, p)
+	}
+	json.NewEncoder(w).Encode(prices)
+}
+
+// handleAdminSetPrice sets the price of a plan in a country.
+func (s *Server) handleAdminSetPrice(w http.ResponseWriter, r *http.Request) {
+	plan, country := r.PathValue("plan"), r.PathValue("country")
+	if _, ok := paidPlans[plan]; !ok {
+		http.Error(w, "Invalid plan", 400)
+		return
+	}
+	if err := validateCountryCode("country", country); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	var req struct {
+		Amount string `json:"amount"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	amount, err := strconv.ParseFloat(req.Amount, 64)
+	if err != nil || amount < 1 || amount > 1000000 {
+		http.Error(w, "amount must be between 1 and 1000000 RUB", 400)
+		return
+	}
+
+	p := PlanPrice{Plan: plan, Country: country, Amount: formatAmount(amount), UpdatedAt: time.Now().UTC()}
+	if _, err := s.DB.Exec(`INSERT INTO plan_prices (plan, country, amount, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (plan, country) DO UPDATE SET amount = excluded.amount, updated_at = excluded.updated_at`,
+		p.Plan, p.Country, p.Amount, p.UpdatedAt); err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	log.Printf("[Admin] Set the %s price of plan %s to %s RUB", country, plan, p.Amount)
+	json.NewEncoder(w).Encode(p)
+}
+
+// handleAdminDeletePrice drops a regional price; the country pays the
+// default price again.
+func (s *Server) handleAdminDeletePrice(w http.ResponseWriter, r *http.Request) {
+	plan, country := r.PathValue("plan"), r.PathValue("country")
+	res, err := s.DB.Exec("DELETE FROM plan_prices WHERE plan = ? AND country = ?", plan, country)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Price not found", 404)
+		return
+	}
+	log.Printf("[Admin] Removed the %s price of plan %s", country, plan)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleAdminRegionRevenue sums succeeded payments by region, optionally
+// since a date.
+func (s *Server) handleAdminRegionRevenue(w http.ResponseWriter, r *http.Request) {
+	query := "SELECT region, COUNT(*), COALESCE(SUM(amount), 0) FROM payments WHERE status = 'succeeded'"
+	var args []interface{}
+	if v := r.URL.Query().Get("since"); v != "" {
+		since, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			http.Error(w, "since must be a date such as 2026-01-31", 400)
+			return
+		}
+		query += " AND created_at >= ?"
+		args = append(args, since.Format(time.DateTime))
+	}
+	query += " GROUP BY region ORDER BY SUM(amount) DESC"
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+	regions := []RegionRevenue{}
+	for rows.Next() {
+		var rr RegionRevenue
+		if err := rows.Scan(&rr.Region, &rr.Payments, &rr.Amount); err != nil {
+			log.Printf("[Pricing] Error scanning revenue row: %v", err)
+			continue
+		}
+		regions = append(regions, rr)
+	}
+	json.NewEncoder(w).Encode(regions)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// readinessDBTimeout bounds the database check of /readyz, so a locked
+// database fails the probe instead of hanging it.
+const readinessDBTimeout = 2 * time.Second
+
+// handleHealthz is the liveness probe: it answers as long as the process
+// serves HTTP, so orchestrators only restart a hung backend, not one whose
+// dependencies are down.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleReadyz is the readiness probe. It answers 200 when the backend can
+// serve users and 503 otherwise, with the result of each check:
+//   - database: SQLite answers a query
+//   - payments: the payment gateway credentials are configured
+//   - providers: at least one server in rotation passed its last health check
+//     and its circuit breaker is not open
+//
+// It also tells which payment provider is in use.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{
+		"database":  "ok",
+		"payments":  "ok",
+		"providers": "ok",
+	}
+	ready := true
+	fail := func(check, msg string) {
+		checks[check] = msg
+		ready = false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessDBTimeout)
+	defer cancel()
+	var one int
+	if err := s.DB.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		fail("database", "unreachable: "+err.Error())
+	}
+
+	if s.Cfg.PaymentProvider != "sandbox" && (s.Cfg.YookassaShopID == "" || s.Cfg.YookassaSecretKey == "") {
+		fail("payments", "YOOKASSA_SHOP_ID and YOOKASSA_SECRET_KEY are not set")
+	}
+
+	if checks["database"] == "ok" {
+		if total, reachable, err := s.reachableServers(ctx); err != nil {
+			fail("providers", "unknown: "+err.Error())
+		} else if reachable == 0 {
+			fail("providers", fmt.Sprintf("none of %d servers reachable", total))
+		} else {
+			checks["providers"] = fmt.Sprintf("%d of %d servers reachable", reachable, total)
+		}
+	} else {
+		fail("providers", "unknown: database unreachable")
+	}
+
+	// The smoke test only starts payments through the sandbox.
+	provider := "yookassa"
+	if s.Cfg.PaymentProvider == "sandbox" {
+		provider = "sandbox"
+	}
+
+	status := "ok"
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		status = "unavailable"
+		w.WriteHeader(503)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "checks": checks, "payment_provider": provider})
+}
+
+// reachableServers counts the servers in rotation and those of them that
+// passed their last health check and aren't cut off by their breaker. Servers
+// added since the last round of health checks count as reachable.
+func (s *Server) reachableServers(ctx context.Context) (total, reachable int, err error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT id, health_ok FROM servers WHERE archived = 0")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+	open := make(map[string]bool)
+	for _, st := range s.Breakers.Snapshot() {
+		open[st.ServerID] = st.state == BreakerOpen
+	}
+	for rows.Next() {
+		var id string
+		var healthy bool
+		if err := rows.Scan(&id, &healthy); err != nil {
+			return 0, 0, err
+		}
+		total++
+		if healthy && !open[id] {
+			reachable++
+		}
+	}
+	return total, reachable, rows.Err()
+}
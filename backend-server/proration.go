@@ -0,0 +1,105 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Switching plans mid-cycle: upgrading to a longer plan, e.g. monthly to
+// yearly, credits the unused value of the current period against the price,
+// and the new plan starts right away. Downgrading to a shorter plan takes
+// effect at the end of the period: it is kept as the user's next plan, which
+// the renewal charges.
+
+// minProratedCharge is the least a prorated payment charges, in RUB. Users
+// with more credit than that leaves, e.g. several months bought in advance,
+// pay in full and keep their time instead, as on other purchases.
+const minProratedCharge = 1.00
+
+// Proration is the credit for the unused part of a user's plan when they
+// upgrade.
+type Proration struct {
+	FromPlan string
+	Credit   float64 // RUB
+	Amount   float64 // RUB charged after the credit
+}
+
+// activePaidPlan returns the user's paid plan and its expiry, if it hasn't
+// expired by now. ok is false for free plans, plans without an expiry and
+// expired ones.
+func (s *Server) activePaidPlan(userID string, now time.Time) (plan string, expiry time.Time, ok bool, err error) {
+	var exp sql.NullTime
+	if err := s.DB.QueryRow("SELECT plan, expiry_date FROM users WHERE id = ?", userID).Scan(&plan, &exp); err != nil {
+		return "", time.Time{}, false, err
+	}
+	if _, paid := paidPlans[plan]; !paid || !exp.Valid || !exp.Time.After(now) {
+		return plan, time.Time{}, false, nil
+	}
+	return plan, exp.Time.UTC(), true, nil
+}
+
+// prorate returns the proration of an upgrade of the user to plan, or nil if
+// buying plan isn't an upgrade or leaves nothing to credit. Both plans are
+// valued at their price in region.
+func (s *Server) prorate(userID, plan, region string, now time.Time) (*Proration, error) {
+	current, expiry, ok, err := s.activePaidPlan(userID, now)
+	if err != nil || !ok {
+		return nil, err
+	}
+	from, to := paidPlans[current], paidPlans[plan]
+	if to.Months <= from.Months {
+		return nil, nil
+	}
+	fromAmount, _ := s.planPrice(current, region)
+	fromPrice, err := strconv.ParseFloat(fromAmount, 64)
+	if err != nil {
+		return nil, err
+	}
+	toAmount, _ := s.planPrice(plan, region)
+	toPrice, err := strconv.ParseFloat(toAmount, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	// The unused time is valued at the price of the current plan's period,
+	// rounded down to the kopeck.
+	period := expiry.Sub(expiry.AddDate(0, -from.Months, 0))
+	credit := math.Floor(fromPrice*float64(expiry.Sub(now))/float64(period)*100) / 100
+	if credit <= 0 || toPrice-credit < minProratedCharge {
+		return nil, nil
+	}
+	return &Proration{FromPlan: current, Credit: credit, Amount: toPrice - credit}, nil
+}
+
+// downgradeStart returns when a switch of the user to plan would take effect
+// if it is a downgrade, i.e. at the end of the current, longer plan. ok is
+// false if it isn't one.
+func (s *Server) downgradeStart(userID, plan string, now time.Time) (start time.Time, ok bool, err error) {
+	current, expiry, active, err := s.activePaidPlan(userID, now)
+	if err != nil || !active {
+		return time.Time{}, false, err
+	}
+	if paidPlans[plan].Months >= paidPlans[current].Months {
+		return time.Time{}, false, nil
+	}
+	return expiry, true, nil
+}
+
+// scheduleDowngrade makes plan the user's next plan, from the end of the
+// current one.
+func (s *Server) scheduleDowngrade(userID, plan string) error {
+	if _, err := s.DB.Exec("UPDATE users SET next_plan = ? WHERE id = ?", plan, userID); err != nil {
+		return err
+	}
+	log.Printf("[Billing] User %s scheduled a switch to the %s plan", userID, plan)
+	return nil
+}
+
+// prorationDescription is the payment description of a prorated upgrade.
+func prorationDescription(paid PaidPlan, p *Proration) string {
+	return fmt.Sprintf("%s, %s RUB credited for the %s plan", paid.Description, formatAmount(p.Credit), p.FromPlan)
+}
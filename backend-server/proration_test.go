@@ -0,0 +1,100 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestServer returns a server on a fresh database.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	initDB(db)
+	cfg := &Config{}
+	return &Server{DB: db, Cfg: cfg, Breakers: NewBreakerRegistry(), Plans: loadPlans(cfg)}
+}
+
+func TestProrate(t *testing.T) {
+	expiry := time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC)
+	halfway := time.Date(2026, 11, 16, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name   string
+		plan   string // Current plan of the user
+		expiry *time.Time
+		prices map[string]string // Regional prices in "XX"
+		now    time.Time
+		to     string
+		want   *Proration
+	}{
+		{name: "free", plan: "free", now: halfway, to: "yearly"},
+		{name: "expired", plan: "monthly", expiry: &expiry, now: expiry.Add(time.Hour), to: "yearly"},
+		{name: "permanent", plan: "monthly", now: halfway, to: "yearly"},
+		{name: "same plan", plan: "monthly", expiry: &expiry, now: halfway, to: "monthly"},
+		{name: "same length", plan: "monthly", expiry: &expiry, now: halfway, to: "family"},
+		{name: "downgrade", plan: "yearly", expiry: &expiry, now: halfway, to: "monthly"},
+		{
+			name: "half used", plan: "monthly", expiry: &expiry, now: halfway, to: "yearly",
+			want: &Proration{FromPlan: "monthly", Credit: 149.50, Amount: 2840.50},
+		},
+		{
+			name: "unused", plan: "monthly", expiry: &expiry, now: expiry.AddDate(0, -1, 0), to: "yearly",
+			want: &Proration{FromPlan: "monthly", Credit: 299.00, Amount: 2691.00},
+		},
+		{
+			name: "rounded down", plan: "monthly", expiry: &expiry, now: expiry.Add(-time.Hour), to: "yearly",
+			// 299 RUB / 720 hours = 0.4152...
+			want: &Proration{FromPlan: "monthly", Credit: 0.41, Amount: 2989.59},
+		},
+		{
+			name: "regional", plan: "monthly", expiry: &expiry, now: halfway, to: "yearly",
+			prices: map[string]string{"monthly": "100.00", "yearly": "1000.00"},
+			want:   &Proration{FromPlan: "monthly", Credit: 50.00, Amount: 950.00},
+		},
+		{
+			name: "credit covers the price", plan: "monthly", expiry: &expiry, now: halfway, to: "yearly",
+			prices: map[string]string{"yearly": "150.00"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t)
+			var region string
+			if tc.prices != nil {
+				s.Cfg.RegionalPricing = true
+				region = "XX"
+				for plan, amount := range tc.prices {
+					if _, err := s.DB.Exec("INSERT INTO plan_prices (plan, country, amount) VALUES (?, ?, ?)", plan, region, amount); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if _, err := s.DB.Exec("INSERT INTO users (id, email, plan, expiry_date) VALUES (?, ?, ?, ?)",
+				"user", "user@example.com", tc.plan, tc.expiry); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := s.prorate("user", tc.to, region, tc.now)
+			if err != nil {
+				t.Fatalf("prorate: %v", err)
+			}
+			switch {
+			case tc.want == nil && got != nil:
+				t.Errorf("prorate = %+v, want none", *got)
+			case tc.want != nil && got == nil:
+				t.Errorf("prorate = none, want %+v", *tc.want)
+			case tc.want != nil && (got.FromPlan != tc.want.FromPlan || !centsEqual(got.Credit, tc.want.Credit) || !centsEqual(got.Amount, tc.want.Amount)):
+				t.Errorf("prorate = %+v, want %+v", *got, *tc.want)
+			}
+		})
+	}
+}
+
+func centsEqual(a, b float64) bool {
+	return int64(a*100+0.5) == int64(b*100+0.5)
+}
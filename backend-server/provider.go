@@ -15,6 +15,24 @@ type VPNProvider interface {
 
 	// SetName sets a human-readable name for a key (for tracking).
 	SetName(keyID string, name string) error
+
+	// SetDataLimit caps the traffic of a key, in bytes. 0 removes the cap.
+	SetDataLimit(keyID string, bytes int64) error
+
+	// GetUsage returns the bytes a key transferred in the current quota period.
+	GetUsage(keyID string) (int64, error)
+
+	// ResetUsage starts a new quota period for a key.
+	ResetUsage(keyID string) error
+
+	// GetConnections returns how many devices or source IPs are currently
+	// connected with each key. Keys without connections may be omitted.
+	GetConnections() (map[string]int, error)
+
+	// GetTransfer returns the bytes transferred by each key on the server, as
+	// counted by the server: Outline over the last 30 days, 3X-UI since the
+	// counters were last reset.
+	GetTransfer() (map[string]int64, error)
 }
 
 // VPNKey represents an access key from any VPN provider.
@@ -30,4 +48,5 @@ type ServerType string
 const (
 	ServerTypeOutline ServerType = "outline"
 	ServerTypeXray    ServerType = "xray"
+	ServerTypeMock    ServerType = "mock" // In-process fake keys, for development and tests
 )
@@ -0,0 +1,551 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"drfrake-backend/xray"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+)
+
+// Provisioning sets up a fresh VPS as a VPN server over SSH and adds it: an
+// Outline server with the official install script, or 3X-UI in Docker with a
+// VLESS+Reality inbound. It runs in the background; the job records the
+// output of the commands, the SSH host key seen and the server added. SSH
+// credentials are only used for the job and never stored. Host keys are
+// trusted on first use: the key a host presents is remembered, and later
+// jobs for the host fail if it presents another one, unless the new one is
+// given as ssh_host_key.
+
+const (
+	// provisionTimeout caps a provisioning job, installs included.
+	provisionTimeout = 20 * time.Minute
+	// maxProvisionLog is how much command output a job keeps, the tail.
+	maxProvisionLog = 64 << 10
+
+	outlineInstallScript = "https://raw.githubusercontent.com/Jigsaw-Code/outline-server/master/src/server_manager/install_scripts/install_server.sh"
+	xuiImage             = "ghcr.io/mhsanaei/3x-ui:latest"
+	// xuiPanelPort is the port the 3X-UI panel listens on, a path that is
+	// random per server hides it. The panel is served over HTTPS with a
+	// self-signed certificate the backend pins.
+	xuiPanelPort = 2053
+)
+
+// Statuses of a ProvisionJob.
+const (
+	ProvisionRunning   = "running"
+	ProvisionSucceeded = "succeeded"
+	ProvisionFailed    = "failed"
+)
+
+// outlineAccessRe matches the line the Outline install script ends with.
+var outlineAccessRe = regexp.MustCompile(`\{"apiUrl":"(https://[^"]+)","certSha256":"([0-9A-Fa-f]{64})"\}`)
+
+// ProvisionRequest asks for a VPS to be set up as a VPN server.
+type ProvisionRequest struct {
+	Type string `json:"type"` // "outline" or "xray"
+	Host string `json:"host"`
+	// SSH access; root, or a user with passwordless sudo.
+	SSHPort       int    `json:"ssh_port"` // 22 if 0
+	SSHUser       string `json:"ssh_user"` // "root" if empty
+	SSHPassword   string `json:"ssh_password"`
+	SSHPrivateKey string `json:"ssh_private_key"` // PEM or OpenSSH format
+	// SSHHostKey is the SHA256 fingerprint of the host key, e.g.
+	// "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8". If empty, the
+	// key the host presented to an earlier job is expected, or on the first
+	// job for the host the key presented is trusted and remembered.
+	SSHHostKey string `json:"ssh_host_key"`
+
+	// Of the server added, see ServerDefinition.
+	Country   string   `json:"country"`
+	City      string   `json:"city"`
+	Flag      string   `json:"flag"`
+	IsPremium bool     `json:"is_premium"`
+	MaxUsers  int      `json:"max_users"`
+	Tags      []string `json:"tags"`
+
+	// XrayPort is the port of the VLESS inbound, 443 if 0.
+	XrayPort int `json:"xray_port"`
+	// XrayTarget is the site Reality borrows the TLS handshake of,
+	// "www.google.com" if empty.
+	XrayTarget string `json:"xray_target"`
+}
+
+func (req *ProvisionRequest) setDefaults() {
+	if req.SSHPort == 0 {
+		req.SSHPort = 22
+	}
+	if req.SSHUser == "" {
+		req.SSHUser = "root"
+	}
+	if req.XrayPort == 0 {
+		req.XrayPort = 443
+	}
+	if req.XrayTarget == "" {
+		req.XrayTarget = "www.google.com"
+	}
+}
+
+func (req *ProvisionRequest) validate() error {
+	err := firstError(
+		validateHost("host", req.Host),
+		validateText("country", req.Country, 64),
+		validateText("city", req.City, 64),
+		validateText("flag", req.Flag, 16),
+		validateMaxUsers(req.MaxUsers),
+		validateHost("xray_target", req.XrayTarget),
+		validateTags(normalizeTags(req.Tags)),
+	)
+	if req.Type != string(ServerTypeOutline) && req.Type != string(ServerTypeXray) {
+		err = firstError(err, errors.New("type must be outline or xray"))
+	}
+	if req.SSHPort < 1 || req.SSHPort > 65535 || req.XrayPort < 1 || req.XrayPort > 65535 {
+		err = firstError(err, errors.New("ssh_port and xray_port must be between 1 and 65535"))
+	}
+	if req.XrayPort == xuiPanelPort {
+		err = firstError(err, fmt.Errorf("xray_port must not be %d, the panel's port", xuiPanelPort))
+	}
+	if req.SSHPassword == "" && req.SSHPrivateKey == "" {
+		err = firstError(err, errors.New("ssh_password or ssh_private_key is required"))
+	}
+	if req.SSHPrivateKey != "" {
+		if _, keyErr := ssh.ParsePrivateKey([]byte(req.SSHPrivateKey)); keyErr != nil {
+			err = firstError(err, fmt.Errorf("ssh_private_key: %v", keyErr))
+		}
+	}
+	if req.SSHHostKey != "" && !strings.HasPrefix(req.SSHHostKey, "SHA256:") {
+		err = firstError(err, errors.New("ssh_host_key must be a SHA256 fingerprint such as SHA256:nThbg6kX..."))
+	}
+	return err
+}
+
+// ProvisionJob is a provisioning run.
+type ProvisionJob struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Host       string     `json:"host"`
+	Status     string     `json:"status"`
+	HostKey    string     `json:"host_key,omitempty"`  // SHA256 fingerprint seen
+	ServerID   string     `json:"server_id,omitempty"` // Set once the server is added
+	Error      string     `json:"error,omitempty"`
+	Log        string     `json:"log,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// provisionRun is the state of a running job.
+type provisionRun struct {
+	s   *Server
+	job *ProvisionJob
+	mu  sync.Mutex // guards job.Log
+}
+
+// logf appends a line to the job's log, and to the backend's.
+func (p *provisionRun) logf(format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	log.Printf("[Provision] %s: %s", p.job.Host, line)
+	p.appendLog("# " + line + "\n")
+}
+
+func (p *provisionRun) appendLog(out string) {
+	p.mu.Lock()
+	p.job.Log += out
+	if n := len(p.job.Log); n > maxProvisionLog {
+		p.job.Log = p.job.Log[n-maxProvisionLog:]
+	}
+	logText := p.job.Log
+	p.mu.Unlock()
+	p.s.DB.Exec("UPDATE provision_jobs SET log = ? WHERE id = ?", logText, p.job.ID)
+}
+
+// run runs a shell script on the host as root and returns its output.
+func (p *provisionRun) run(client *ssh.Client, user, script string) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	cmd := "sh -c " + shellQuote(script)
+	if user != "root" {
+		cmd = "sudo -n " + cmd
+	}
+	out, err := session.CombinedOutput(cmd)
+	p.appendLog(string(out))
+	return string(out), err
+}
+
+// shellQuote quotes s as one argument for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// randomHex returns n random bytes, hex-encoded.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// dialSSH connects to the host, checking its key against the fingerprint
+// asked for or else the one remembered for the host, and recording the one
+// seen on the job.
+func (p *provisionRun) dialSSH(req *ProvisionRequest) (*ssh.Client, error) {
+	expected := req.SSHHostKey
+	if expected == "" {
+		err := p.s.DB.QueryRow("SELECT fingerprint FROM ssh_host_keys WHERE host = ?", req.Host).Scan(&expected)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	var auth []ssh.AuthMethod
+	if req.SSHPrivateKey != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(req.SSHPrivateKey))
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if req.SSHPassword != "" {
+		auth = append(auth, ssh.Password(req.SSHPassword))
+	}
+	config := &ssh.ClientConfig{
+		User: req.SSHUser,
+		Auth: auth,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			seen := ssh.FingerprintSHA256(key)
+			p.job.HostKey = seen
+			p.s.DB.Exec("UPDATE provision_jobs SET host_key = ? WHERE id = ?", seen, p.job.ID)
+			if expected != "" && expected != seen {
+				if req.SSHHostKey == "" {
+					return fmt.Errorf("host key %s does not match %s seen before; if the host was reinstalled, pass the new key as ssh_host_key", seen, expected)
+				}
+				return fmt.Errorf("host key %s does not match %s", seen, expected)
+			}
+			if expected == "" {
+				p.logf("Trusting host key %s seen for the first time", seen)
+			}
+			_, err := p.s.DB.Exec(`INSERT INTO ssh_host_keys (host, fingerprint, updated_at) VALUES (?, ?, ?)
+				ON CONFLICT (host) DO UPDATE SET fingerprint = excluded.fingerprint, updated_at = excluded.updated_at`,
+				req.Host, seen, time.Now().UTC())
+			return err
+		},
+		Timeout: 30 * time.Second,
+	}
+	return ssh.Dial("tcp", net.JoinHostPort(req.Host, strconv.Itoa(req.SSHPort)), config)
+}
+
+// provision sets up the host and adds it as a server, returning its ID.
+func (p *provisionRun) provision(ctx context.Context, req *ProvisionRequest) (string, error) {
+	p.logf("Connecting to %s@%s:%d", req.SSHUser, req.Host, req.SSHPort)
+	client, err := p.dialSSH(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect: %w", err)
+	}
+	defer client.Close()
+	// Commands can't be canceled, closing the connection ends them.
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+	p.logf("Connected, host key %s", p.job.HostKey)
+
+	def := ServerDefinition{
+		Type:      req.Type,
+		Country:   req.Country,
+		City:      req.City,
+		Flag:      req.Flag,
+		IsPremium: req.IsPremium,
+		MaxUsers:  req.MaxUsers,
+		Tags:      req.Tags,
+	}
+	switch ServerType(req.Type) {
+	case ServerTypeOutline:
+		err = p.installOutline(client, req, &def)
+	case ServerTypeXray:
+		err = p.installXray(ctx, client, req, &def)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("timed out after %s", provisionTimeout)
+		}
+		return "", err
+	}
+
+	def.setDefaults()
+	if err := def.validate(); err != nil {
+		return "", fmt.Errorf("invalid server: %w", err)
+	}
+	p.s.locateServer(&def)
+	def.ID = uuid.New().String()
+	if err := insertServer(p.s.DB, def); err != nil {
+		return "", fmt.Errorf("failed to add server: %w", err)
+	}
+	p.logf("Added %s server %s in %s %s, %s", def.Type, def.ID, def.Flag, def.City, def.Country)
+	return def.ID, nil
+}
+
+// dockerScript installs Docker unless it is there already.
+const dockerScript = `command -v docker >/dev/null 2>&1 || curl -fsSL https://get.docker.com | sh
+`
+
+func (p *provisionRun) installOutline(client *ssh.Client, req *ProvisionRequest, def *ServerDefinition) error {
+	p.logf("Installing Outline")
+	script := "set -e\n" + dockerScript +
+		fmt.Sprintf(`bash -c "$(curl -fsSL %s)" -- --hostname %s`, outlineInstallScript, shellQuote(req.Host))
+	out, err := p.run(client, req.SSHUser, script)
+	if err != nil {
+		return fmt.Errorf("outline install failed: %w", err)
+	}
+	m := outlineAccessRe.FindStringSubmatch(out)
+	if m == nil {
+		return errors.New("outline install printed no apiUrl and certSha256")
+	}
+	def.APIURL, def.CertSHA256 = m[1], m[2]
+	p.logf("Outline management API at %s", def.APIURL)
+	return nil
+}
+
+// panelCertificate creates the self-signed certificate and key, PEM-encoded,
+// of a 3X-UI panel on host, and returns them with the certificate's hex
+// SHA-256 fingerprint.
+func panelCertificate(host string) (certPEM, keyPEM, fingerprint string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", "", err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return "", "", "", err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", "", err
+	}
+	sum := sha256.Sum256(der)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+		strings.ToUpper(hex.EncodeToString(sum[:])), nil
+}
+
+func (p *provisionRun) installXray(ctx context.Context, client *ssh.Client, req *ProvisionRequest, def *ServerDefinition) error {
+	p.logf("Installing 3X-UI")
+	username, password, basePath := randomHex(8), randomHex(16), "/"+randomHex(8)+"/"
+	certPEM, keyPEM, certSHA256, err := panelCertificate(req.Host)
+	if err != nil {
+		return fmt.Errorf("failed to create the panel certificate: %w", err)
+	}
+	// The panel only gets its credentials once it serves HTTPS, so they never
+	// cross the network in the clear.
+	script := "set -e\n" + dockerScript + fmt.Sprintf(`if docker ps -a --format '{{.Names}}' | grep -qx 3x-ui; then
+  echo "a 3x-ui container exists already" >&2
+  exit 1
+fi
+mkdir -p /opt/3x-ui/db /opt/3x-ui/cert
+umask 077
+cat > /opt/3x-ui/cert/panel.crt <<'EOF'
+%sEOF
+cat > /opt/3x-ui/cert/panel.key <<'EOF'
+%sEOF
+docker run -d --name 3x-ui --restart unless-stopped --network host \
+  -v /opt/3x-ui/db:/etc/x-ui -v /opt/3x-ui/cert:/root/cert %s
+sleep 5
+docker exec 3x-ui /app/x-ui cert -webCert /root/cert/panel.crt -webCertKey /root/cert/panel.key >/dev/null
+docker exec 3x-ui /app/x-ui setting -username %s -password %s -port %d -webBasePath %s >/dev/null
+docker restart 3x-ui >/dev/null
+`, certPEM, keyPEM, xuiImage, username, password, xuiPanelPort, basePath)
+	if _, err := p.run(client, req.SSHUser, script); err != nil {
+		return fmt.Errorf("3x-ui install failed: %w", err)
+	}
+
+	panelURL := fmt.Sprintf("https://%s%s", net.JoinHostPort(req.Host, strconv.Itoa(xuiPanelPort)), basePath)
+	panel := xray.NewClient(panelURL, username, password)
+	if err := panel.PinCertificate(certSHA256); err != nil {
+		return err
+	}
+	p.logf("Waiting for the panel at %s, certificate %s", panelURL, certSHA256)
+	for err := panel.Login(); err != nil; err = panel.Login() {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("panel not reachable: %w", err)
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	inbound, err := panel.AddRealityInbound(xray.RealityInbound{Remark: "drfrake", Port: req.XrayPort, Target: req.XrayTarget})
+	if err != nil {
+		return fmt.Errorf("failed to create inbound: %w", err)
+	}
+	p.logf("Created VLESS+Reality inbound %d on port %d", inbound.Id, inbound.Port)
+
+	provider := NewXrayProvider(panelURL, username, password, certSHA256, inbound.Id, req.Host, "{}")
+	settings, err := provider.DiscoverSettings()
+	if err != nil {
+		return fmt.Errorf("failed to read inbound settings: %w", err)
+	}
+	data, _ := json.Marshal(settings)
+	def.ServerHost = req.Host
+	def.XrayPanelURL, def.CertSHA256 = panelURL, certSHA256
+	def.XrayUsername, def.XrayPassword = username, password
+	def.XrayInboundID = inbound.Id
+	def.XraySettings = string(data)
+	return nil
+}
+
+// startProvision records a job for req and runs it in the background.
+func (s *Server) startProvision(req ProvisionRequest) (*ProvisionJob, error) {
+	job := &ProvisionJob{
+		ID:        uuid.New().String(),
+		Type:      req.Type,
+		Host:      req.Host,
+		Status:    ProvisionRunning,
+		CreatedAt: time.Now().UTC(),
+	}
+	if _, err := s.DB.Exec("INSERT INTO provision_jobs (id, type, host, status, log, created_at) VALUES (?, ?, ?, ?, '', ?)",
+		job.ID, job.Type, job.Host, job.Status, job.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	started := *job
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), provisionTimeout)
+		defer cancel()
+		p := &provisionRun{s: s, job: job}
+		serverID, err := p.provision(ctx, &req)
+		job.Status, job.ServerID = ProvisionSucceeded, serverID
+		if err != nil {
+			job.Status, job.Error = ProvisionFailed, err.Error()
+			p.logf("Failed: %v", err)
+		}
+		if _, err := s.DB.Exec("UPDATE provision_jobs SET status = ?, server_id = ?, error = ?, finished_at = ? WHERE id = ?",
+			job.Status, job.ServerID, job.Error, time.Now().UTC(), job.ID); err != nil {
+			log.Printf("[Provision] Failed to record the end of job %s: %v", job.ID, err)
+		}
+	}()
+	return &started, nil
+}
+
+// failInterruptedProvisions marks the jobs that were running when the backend
+// stopped as failed; they don't resume.
+func (s *Server) failInterruptedProvisions() {
+	if _, err := s.DB.Exec("UPDATE provision_jobs SET status = ?, error = 'interrupted by a backend restart', finished_at = ? WHERE status = ?",
+		ProvisionFailed, time.Now().UTC(), ProvisionRunning); err != nil {
+		log.Printf("[Provision] Failed to fail interrupted jobs: %v", err)
+	}
+}
+
+// scanProvisionJob scans a row of provisionJobColumns.
+func (s *Server) scanProvisionJob(row interface{ Scan(...any) error }) (*ProvisionJob, error) {
+	var j ProvisionJob
+	var finished sql.NullTime
+	if err := row.Scan(&j.ID, &j.Type, &j.Host, &j.Status, &j.HostKey, &j.ServerID, &j.Error, &j.Log, &j.CreatedAt, &finished); err != nil {
+		return nil, err
+	}
+	if finished.Valid {
+		j.FinishedAt = &finished.Time
+	}
+	return &j, nil
+}
+
+const provisionJobColumns = "id, type, host, status, host_key, server_id, error, log, created_at, finished_at"
+
+// handleAdminProvision starts setting up a VPS as a VPN server. It answers
+// 202 with the job, whose progress GET /admin/provision/{id} shows.
+func (s *Server) handleAdminProvision(w http.ResponseWriter, r *http.Request) {
+	var req ProvisionRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	req.setDefaults()
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	var running int
+	s.DB.QueryRow("SELECT COUNT(*) FROM provision_jobs WHERE host = ? AND status = ?", req.Host, ProvisionRunning).Scan(&running)
+	if running > 0 {
+		http.Error(w, "The host is being provisioned already", 409)
+		return
+	}
+
+	job, err := s.startProvision(req)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	log.Printf("[Admin] Started provisioning %s server %s, job %s", req.Type, req.Host, job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleAdminListProvisions lists the latest provisioning jobs, without their
+// logs.
+func (s *Server) handleAdminListProvisions(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.DB.Query("SELECT " + provisionJobColumns + " FROM provision_jobs ORDER BY created_at DESC LIMIT 100")
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+	jobs := []ProvisionJob{}
+	for rows.Next() {
+		j, err := s.scanProvisionJob(rows)
+		if err != nil {
+			log.Printf("[Provision] Error scanning job row: %v", err)
+			continue
+		}
+		j.Log = ""
+		jobs = append(jobs, *j)
+	}
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// handleAdminGetProvision returns a provisioning job with its log.
+func (s *Server) handleAdminGetProvision(w http.ResponseWriter, r *http.Request) {
+	j, err := s.scanProvisionJob(s.DB.QueryRow("SELECT "+provisionJobColumns+" FROM provision_jobs WHERE id = ?", r.PathValue("id")))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Job not found", 404)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	json.NewEncoder(w).Encode(j)
+}
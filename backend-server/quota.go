@@ -0,0 +1,425 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// serverRecord holds the columns of a servers row needed to reach its VPN backend.
+type serverRecord struct {
+	ID            string
+	Type          string
+	APIURL        string
+	CertSHA256    string
+	ServerHost    string
+	XrayInboundID int
+	XrayPanelURL  string
+	XrayUsername  string
+	XrayPassword  string
+	XraySettings  string
+}
+
+// provider returns the VPN provider of a server, guarded by its circuit breaker.
+func (s *Server) provider(rec serverRecord) VPNProvider {
+	var provider VPNProvider
+	switch ServerType(rec.Type) {
+	case ServerTypeXray:
+		provider = NewXrayProvider(rec.XrayPanelURL, rec.XrayUsername, rec.XrayPassword, rec.CertSHA256, rec.XrayInboundID, rec.ServerHost, rec.XraySettings)
+	case ServerTypeMock:
+		provider = NewMockProvider(rec.ServerHost)
+	default:
+		provider = NewOutlineProvider(rec.APIURL, rec.CertSHA256)
+	}
+	return NewResilientProvider(provider, s.Breakers.Get(rec.ID))
+}
+
+// loadServerRecord reads the connection details of a server.
+func (s *Server) loadServerRecord(id string) (serverRecord, error) {
+	var rec serverRecord
+	err := s.DB.QueryRow(`SELECT id, type, api_url, cert_sha256, server_host,
+		xray_inbound_id, xray_panel_url, xray_username, xray_password, xray_settings
+		FROM servers WHERE id = ?`, id).
+		Scan(&rec.ID, &rec.Type, &rec.APIURL, &rec.CertSHA256, &rec.ServerHost,
+			&rec.XrayInboundID, &rec.XrayPanelURL, &rec.XrayUsername, &rec.XrayPassword, &rec.XraySettings)
+	return rec, err
+}
+
+// userKey is an access key of a user together with the server it lives on.
+type userKey struct {
+	Server  serverRecord
+	Country string
+	City    string
+	KeyID   string
+	// UsageOffset is traffic of keys this one replaced in the current quota
+	// period, see handleRotateKey.
+	UsageOffset int64
+}
+
+func (s *Server) userKeys(userID string) ([]userKey, error) {
+	rows, err := s.DB.Query(`SELECT k.key_id, k.usage_offset, s.id, s.type, s.api_url, s.cert_sha256, s.server_host,
+		s.xray_inbound_id, s.xray_panel_url, s.xray_username, s.xray_password, s.xray_settings, s.country, s.city
+		FROM access_keys k JOIN servers s ON s.id = k.server_id
+		WHERE k.user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []userKey
+	for rows.Next() {
+		var k userKey
+		rec := &k.Server
+		if err := rows.Scan(&k.KeyID, &k.UsageOffset, &rec.ID, &rec.Type, &rec.APIURL, &rec.CertSHA256, &rec.ServerHost,
+			&rec.XrayInboundID, &rec.XrayPanelURL, &rec.XrayUsername, &rec.XrayPassword, &rec.XraySettings,
+			&k.Country, &k.City); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// The data cap of a plan is the allowance of the user, whatever number of
+// servers and devices they use it on. The VPN servers can only cap single
+// keys, so each key is capped at what it used plus what the user has left:
+// the user's keys together stay within the allowance, give or take the
+// traffic between two rounds of enforceDataCaps, which follows the traffic
+// collector.
+//
+// Outline can't reset a key's counter; it caps the traffic of the last 30
+// days instead. On Outline servers a quota reset thus doesn't clear what the
+// user used, which leaves the count as the days pass.
+
+// applyPlanLimits sets the data cap of the user's plan on all of their keys,
+// or disables them while the plan is paused, and passes the plan on to the
+// user's family members, see family.go. Failures are logged; the cap is
+// applied again whenever the plan changes.
+func (s *Server) applyPlanLimits(userID string) {
+	var planName string
+	var revoked int64
+	err := s.DB.QueryRow(`SELECT plan, (SELECT COALESCE(SUM(bytes), 0) FROM revoked_key_usage WHERE user_id = users.id)
+		FROM users WHERE id = ?`, userID).Scan(&planName, &revoked)
+	if err != nil {
+		log.Printf("[Quota] Unknown user %s: %v", userID, err)
+		return
+	}
+	plan := s.keyPlan(userID, planName)
+
+	keys, err := s.userKeys(userID)
+	if err != nil {
+		log.Printf("[Quota] Failed to list keys of user %s: %v", userID, err)
+		return
+	}
+	used := make([]int64, len(keys))
+	offset := revoked
+	for i, k := range keys {
+		offset += k.UsageOffset
+		if plan.MonthlyDataBytes <= 0 {
+			continue
+		}
+		n, err := s.provider(k.Server).GetUsage(k.KeyID)
+		if err != nil {
+			log.Printf("[Quota] Failed to get usage of user %s on server %s: %v", userID, k.Server.ID, err)
+		}
+		used[i] = n
+	}
+	for i, limit := range sharedKeyLimits(plan.MonthlyDataBytes, used, offset) {
+		k := keys[i]
+		if err := s.provider(k.Server).SetDataLimit(k.KeyID, limit); err != nil {
+			log.Printf("[Quota] Failed to set data limit of user %s on server %s: %v", userID, k.Server.ID, err)
+			continue
+		}
+		s.dataLimits.Store(k.Server.ID+"/"+k.KeyID, limit)
+	}
+	s.syncFamily(userID, planName)
+}
+
+// sharedKeyLimits returns the data caps of a user's keys that share
+// allowance, 0 for unlimited. used is the traffic of each key by its own
+// counter and offset the traffic counted against the user besides, of keys
+// they replaced or deleted.
+func sharedKeyLimits(allowance int64, used []int64, offset int64) []int64 {
+	limits := make([]int64, len(used))
+	if allowance <= 0 {
+		return limits
+	}
+	total := offset
+	for _, n := range used {
+		total += n
+	}
+	left := max(allowance-total, 0)
+	for i, n := range used {
+		// 0 would mean unlimited, so an exhausted allowance is capped at 1 byte.
+		limits[i] = max(n+left, 1)
+	}
+	return limits
+}
+
+// enforceDataCaps brings the data caps of all keys on capped plans in line
+// with the traffic of their users, given the servers in rotation and the
+// transfer per key of those that answered. Users with a key on a server that
+// didn't answer are left for the next round. Caps are only updated when they
+// are off by more than 1% of the allowance, so steady traffic doesn't call
+// the servers for every key each round.
+func (s *Server) enforceDataCaps(servers map[string]serverRecord, transfers map[string]map[string]int64) {
+	rows, err := s.DB.Query(`SELECT k.user_id, k.server_id, k.key_id, k.usage_offset, u.plan,
+		(SELECT COALESCE(SUM(bytes), 0) FROM revoked_key_usage r WHERE r.user_id = k.user_id)
+		FROM access_keys k JOIN users u ON u.id = k.user_id ORDER BY k.user_id`)
+	if err != nil {
+		log.Printf("[Quota] Failed to list keys: %v", err)
+		return
+	}
+	type capKey struct {
+		userID, serverID, keyID, plan string
+		offset, revoked               int64
+	}
+	var keys []capKey
+	for rows.Next() {
+		var k capKey
+		if err := rows.Scan(&k.userID, &k.serverID, &k.keyID, &k.offset, &k.plan, &k.revoked); err != nil {
+			log.Printf("[Quota] Error scanning key row: %v", err)
+			continue
+		}
+		// Unlimited plans have nothing to enforce.
+		if s.plan(k.plan).MonthlyDataBytes > 0 {
+			keys = append(keys, k)
+		}
+	}
+	rows.Close()
+
+	for start := 0; start < len(keys); {
+		end := start + 1
+		for end < len(keys) && keys[end].userID == keys[start].userID {
+			end++
+		}
+		user := keys[start:end]
+		start = end
+
+		used := make([]int64, len(user))
+		offset := user[0].revoked
+		complete := true
+		for i, k := range user {
+			transfer, ok := transfers[k.serverID]
+			if !ok {
+				complete = false
+				break
+			}
+			used[i] = transfer[k.keyID]
+			offset += k.offset
+		}
+		if !complete {
+			continue
+		}
+		plan := s.keyPlan(user[0].userID, user[0].plan)
+		for i, limit := range sharedKeyLimits(plan.MonthlyDataBytes, used, offset) {
+			k := user[i]
+			id := k.serverID + "/" + k.keyID
+			if last, ok := s.dataLimits.Load(id); ok && abs(last.(int64)-limit) <= plan.MonthlyDataBytes/100 {
+				continue
+			}
+			if err := s.provider(servers[k.serverID]).SetDataLimit(k.keyID, limit); err != nil {
+				log.Printf("[Quota] Failed to set data limit of user %s on server %s: %v", k.userID, k.serverID, err)
+				continue
+			}
+			s.dataLimits.Store(id, limit)
+		}
+	}
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// keyDataLimit is the data cap of a new key that replaced keys which already
+// used offset bytes of the plan's allowance in this period, until the next
+// round of enforceDataCaps shares the allowance with the user's other keys.
+func keyDataLimit(plan Plan, offset int64) int64 {
+	if plan.MonthlyDataBytes <= 0 {
+		return 0
+	}
+	// 0 would mean unlimited, so an exhausted allowance is capped at 1 byte.
+	return max(plan.MonthlyDataBytes-offset, 1)
+}
+
+// KeyUsage is the quota state of one access key.
+type KeyUsage struct {
+	ServerID  string `json:"server_id"`
+	Country   string `json:"country"`
+	City      string `json:"city"`
+	UsedBytes int64  `json:"used_bytes"`
+	// RemainingBytes is what is left of the user's allowance, shared by all
+	// of their keys. It is omitted for unlimited plans.
+	RemainingBytes *int64 `json:"remaining_bytes,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// UsageResponse is returned by GET /usage.
+type UsageResponse struct {
+	Plan string `json:"plan"`
+	// LimitBytes is the cap of the user per quota period, 0 = unlimited.
+	LimitBytes int64 `json:"limit_bytes"`
+	// UsedBytes is the traffic of all of the user's keys, including keys
+	// they replaced or deleted. Keys whose usage is unavailable are left out.
+	UsedBytes int64 `json:"used_bytes"`
+	// RemainingBytes is omitted for unlimited plans.
+	RemainingBytes *int64     `json:"remaining_bytes,omitempty"`
+	ResetAt        *time.Time `json:"reset_at,omitempty"`
+	Servers        []KeyUsage `json:"servers"`
+}
+
+// handleUsage shows the user how much of their data cap is left on each server.
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	token, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+
+	var planName string
+	var resetAt sql.NullTime
+	var revoked int64
+	err := s.DB.QueryRow(`SELECT plan, quota_reset_at, (SELECT COALESCE(SUM(bytes), 0) FROM revoked_key_usage WHERE user_id = users.id)
+		FROM users WHERE id = ?`, token).Scan(&planName, &resetAt, &revoked)
+	if err != nil {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	plan := s.plan(planName)
+
+	keys, err := s.userKeys(token)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+
+	resp := UsageResponse{
+		Plan:       plan.Name,
+		LimitBytes: plan.MonthlyDataBytes,
+		UsedBytes:  revoked,
+		Servers:    []KeyUsage{},
+	}
+	if resetAt.Valid {
+		resp.ResetAt = &resetAt.Time
+	}
+	for _, k := range keys {
+		usage := KeyUsage{ServerID: k.Server.ID, Country: k.Country, City: k.City}
+		used, err := s.provider(k.Server).GetUsage(k.KeyID)
+		if err != nil {
+			usage.Error = "usage unavailable"
+			log.Printf("[Quota] Failed to get usage of user %s on server %s: %v", token, k.Server.ID, err)
+		} else {
+			usage.UsedBytes = used + k.UsageOffset
+			resp.UsedBytes += usage.UsedBytes
+		}
+		resp.Servers = append(resp.Servers, usage)
+	}
+	if plan.MonthlyDataBytes > 0 {
+		// Any key can use up what is left of the user's allowance.
+		remaining := max(plan.MonthlyDataBytes-resp.UsedBytes, 0)
+		resp.RemainingBytes = &remaining
+		for i := range resp.Servers {
+			if resp.Servers[i].Error == "" {
+				resp.Servers[i].RemainingBytes = &remaining
+			}
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// runQuotaResets starts a new quota period for every user whose period has
+// ended, checking every interval until the process exits.
+func (s *Server) runQuotaResets(interval time.Duration) {
+	for {
+		s.resetDueQuotas(time.Now())
+		time.Sleep(interval)
+	}
+}
+
+func (s *Server) resetDueQuotas(now time.Time) {
+	rows, err := s.DB.Query("SELECT id, quota_reset_at, created_at FROM users WHERE quota_reset_at IS NULL OR quota_reset_at <= ?", now.UTC())
+	if err != nil {
+		log.Printf("[Quota] Failed to query due users: %v", err)
+		return
+	}
+	type due struct {
+		userID    string
+		resetAt   sql.NullTime
+		createdAt sql.NullTime
+	}
+	var users []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.userID, &d.resetAt, &d.createdAt); err == nil {
+			users = append(users, d)
+		}
+	}
+	rows.Close()
+
+	for _, d := range users {
+		// Users without a period yet (created before quotas existed) start one now.
+		if !d.resetAt.Valid {
+			s.applyPlanLimits(d.userID)
+		} else {
+			keys, err := s.userKeys(d.userID)
+			if err != nil {
+				log.Printf("[Quota] Failed to list keys of user %s: %v", d.userID, err)
+				continue
+			}
+			for _, k := range keys {
+				if err := s.provider(k.Server).ResetUsage(k.KeyID); err != nil {
+					log.Printf("[Quota] Failed to reset usage of user %s on server %s: %v", d.userID, k.Server.ID, err)
+				}
+			}
+			// Lift the reduced caps of rotated keys.
+			if _, err := s.DB.Exec("DELETE FROM revoked_key_usage WHERE user_id = ?", d.userID); err != nil {
+				log.Printf("[Quota] Failed to lift rotated key caps of user %s: %v", d.userID, err)
+			}
+			res, err := s.DB.Exec("UPDATE access_keys SET usage_offset = 0 WHERE user_id = ? AND usage_offset != 0", d.userID)
+			if err != nil {
+				log.Printf("[Quota] Failed to clear usage offsets of user %s: %v", d.userID, err)
+			} else if n, _ := res.RowsAffected(); n > 0 {
+				s.applyPlanLimits(d.userID)
+			}
+		}
+		// Periods follow the signup day, so a reset clamped to a short month
+		// doesn't pull the following ones earlier.
+		anchor := now
+		if d.createdAt.Valid {
+			anchor = d.createdAt.Time
+		} else if d.resetAt.Valid {
+			anchor = d.resetAt.Time
+		}
+		if _, err := s.DB.Exec("UPDATE users SET quota_reset_at = ? WHERE id = ?", nextQuotaReset(anchor, now).UTC(), d.userID); err != nil {
+			log.Printf("[Quota] Failed to schedule next reset of user %s: %v", d.userID, err)
+		}
+	}
+	if len(users) > 0 {
+		log.Printf("[Quota] Started new quota period for %d users", len(users))
+	}
+}
+
+// nextQuotaReset returns the first monthly anniversary of anchor after now.
+// Periods missed while the backend was down are skipped.
+func nextQuotaReset(anchor, now time.Time) time.Time {
+	n := 1
+	for !addMonths(anchor, n).After(now) {
+		n++
+	}
+	return addMonths(anchor, n)
+}
+
+// addMonths moves t n months forward on the same day of month, or on the last
+// day of the month when that one is shorter. Unlike time.AddDate, Jan 31 plus
+// one month is Feb 28, not Mar 3.
+func addMonths(t time.Time, n int) time.Time {
+	y, m, d := t.Date()
+	first := time.Date(y, m+time.Month(n), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(d, last)-1)
+}
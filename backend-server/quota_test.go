@@ -0,0 +1,83 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSharedKeyLimits(t *testing.T) {
+	cases := []struct {
+		name      string
+		allowance int64
+		used      []int64
+		offset    int64
+		want      []int64
+	}{
+		{name: "unlimited", allowance: 0, used: []int64{100, 200}, want: []int64{0, 0}},
+		{name: "single key", allowance: 1000, used: []int64{300}, want: []int64{1000}},
+		{name: "single key with offset", allowance: 1000, used: []int64{300}, offset: 200, want: []int64{800}},
+		{name: "shared", allowance: 1000, used: []int64{300, 200, 0}, want: []int64{800, 700, 500}},
+		{name: "shared with offset", allowance: 1000, used: []int64{300, 200}, offset: 400, want: []int64{400, 300}},
+		{name: "exhausted", allowance: 1000, used: []int64{600, 400}, want: []int64{600, 400}},
+		{name: "overshot", allowance: 1000, used: []int64{900, 300}, want: []int64{900, 300}},
+		{name: "exhausted unused key", allowance: 1000, used: []int64{1000, 0}, want: []int64{1000, 1}},
+		{name: "exhausted by offset", allowance: 1000, used: []int64{0}, offset: 1500, want: []int64{1}},
+		{name: "no keys", allowance: 1000, used: nil, want: []int64{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := sharedKeyLimits(tc.allowance, tc.used, tc.offset)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("sharedKeyLimits(%d, %v, %d) = %v, want %v", tc.allowance, tc.used, tc.offset, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAddMonths(t *testing.T) {
+	date := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 10, 30, 0, 0, time.UTC) }
+	cases := []struct {
+		name string
+		t    time.Time
+		n    int
+		want time.Time
+	}{
+		{name: "mid month", t: date(2026, 1, 15), n: 1, want: date(2026, 2, 15)},
+		{name: "end of January", t: date(2026, 1, 31), n: 1, want: date(2026, 2, 28)},
+		{name: "leap year", t: date(2028, 1, 31), n: 1, want: date(2028, 2, 29)},
+		{name: "back to 31", t: date(2026, 1, 31), n: 2, want: date(2026, 3, 31)},
+		{name: "30-day month", t: date(2026, 3, 31), n: 1, want: date(2026, 4, 30)},
+		{name: "year end", t: date(2026, 12, 31), n: 1, want: date(2027, 1, 31)},
+		{name: "many months", t: date(2026, 1, 31), n: 13, want: date(2027, 2, 28)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := addMonths(tc.t, tc.n); !got.Equal(tc.want) {
+				t.Errorf("addMonths(%v, %d) = %v, want %v", tc.t, tc.n, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNextQuotaReset(t *testing.T) {
+	anchor := time.Date(2026, 1, 31, 10, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name string
+		now  time.Time
+		want time.Time
+	}{
+		{name: "signup", now: anchor, want: time.Date(2026, 2, 28, 10, 0, 0, 0, time.UTC)},
+		{name: "at a reset", now: time.Date(2026, 2, 28, 10, 0, 0, 0, time.UTC), want: time.Date(2026, 3, 31, 10, 0, 0, 0, time.UTC)},
+		{name: "just before a reset", now: time.Date(2026, 3, 31, 9, 59, 0, 0, time.UTC), want: time.Date(2026, 3, 31, 10, 0, 0, 0, time.UTC)},
+		{name: "missed periods", now: time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC), want: time.Date(2026, 7, 31, 10, 0, 0, 0, time.UTC)},
+		{name: "short month", now: time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), want: time.Date(2026, 4, 30, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := nextQuotaReset(anchor, tc.now); !got.Equal(tc.want) {
+				t.Errorf("nextQuotaReset(%v, %v) = %v, want %v", anchor, tc.now, got, tc.want)
+			}
+		})
+	}
+}
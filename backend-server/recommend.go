@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RecommendedServer is a server suggested to the client, best first.
+type RecommendedServer struct {
+	ID        string   `json:"id"`
+	Country   string   `json:"country"`
+	City      string   `json:"city"`
+	Flag      string   `json:"flag"`
+	IsPremium bool     `json:"isPremium"`
+	Type      string   `json:"type"`
+	Tags      []string `json:"tags"`
+	// LatencyMs is the last health-check latency from the backend, 0 if unknown.
+	LatencyMs int64  `json:"latency_ms"`
+	Reason    string `json:"reason"`
+}
+
+// handleRecommendedServers returns the best 1-3 servers for the client:
+// healthy servers in the client's country first, then by measured latency.
+// With the least_loaded strategy, servers of the same country are ordered by
+// free capacity instead. Free users are only offered free servers, and full
+// servers only to users who already have a key on them.
+func (s *Server) handleRecommendedServers(w http.ResponseWriter, r *http.Request) {
+	token, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	var plan string
+	if err := s.DB.QueryRow("SELECT plan FROM users WHERE id = ?", token).Scan(&plan); err != nil {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+
+	limit := 3
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 3 {
+			http.Error(w, "limit must be between 1 and 3", 400)
+			return
+		}
+		limit = n
+	}
+	strategy := s.Cfg.ServerAssignment
+	if v := r.URL.Query().Get("strategy"); v != "" {
+		if v != AssignLatency && v != AssignLeastLoaded {
+			http.Error(w, "strategy must be latency or least_loaded", 400)
+			return
+		}
+		strategy = v
+	}
+
+	var clientCountry string
+	ip := clientIP(r)
+	if geo, err := s.Geo.Lookup(ip); err == nil {
+		clientCountry = geo.CountryCode
+	} else if !errors.Is(err, ErrPrivateIP) {
+		log.Printf("[GeoIP] Lookup of %s failed: %v", ip, err)
+	}
+
+	rows, err := s.DB.Query(`SELECT s.id, s.country, s.city, s.flag, s.is_premium, s.type, s.health_ok, s.health_latency_ms,
+		(SELECT COUNT(DISTINCT k.user_id) FROM access_keys k WHERE k.server_id = s.id), s.max_users,
+		EXISTS (SELECT 1 FROM access_keys k WHERE k.server_id = s.id AND k.user_id = ?), s.tags
+		FROM servers s WHERE s.archived = 0`, token)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	type candidate struct {
+		RecommendedServer
+		local bool
+		load  float64
+	}
+	var candidates []candidate
+	for rows.Next() {
+		var c candidate
+		var healthy, hasKey bool
+		var users, maxUsers int
+		var tags string
+		if err := rows.Scan(&c.ID, &c.Country, &c.City, &c.Flag, &c.IsPremium, &c.Type, &healthy, &c.LatencyMs,
+			&users, &maxUsers, &hasKey, &tags); err != nil {
+			log.Printf("Error scanning server row: %v", err)
+			continue
+		}
+		c.Tags = parseTags(tags)
+		if !healthy || (c.IsPremium && !planHasPremium(plan)) {
+			continue
+		}
+		if !hasKey && maxUsers > 0 && users >= maxUsers {
+			continue
+		}
+		c.load = loadFactor(users, maxUsers)
+		if s.Breakers.Get(c.ID).status(c.ID).state == BreakerOpen {
+			continue
+		}
+		c.local = clientCountry != "" && (strings.EqualFold(flagCountryCode(c.Flag), clientCountry) ||
+			strings.EqualFold(c.Country, clientCountry))
+		candidates = append(candidates, c)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.local != b.local {
+			return a.local
+		}
+		// Unknown latency sorts last.
+		if (a.LatencyMs == 0) != (b.LatencyMs == 0) {
+			return a.LatencyMs != 0
+		}
+		return a.LatencyMs < b.LatencyMs
+	})
+
+	// Countries keep the rank of their best server; the slots of a country
+	// are then filled with its servers from least to most loaded.
+	if strategy == AssignLeastLoaded {
+		byCountry := make(map[string][]candidate)
+		for _, c := range candidates {
+			byCountry[c.Country] = append(byCountry[c.Country], c)
+		}
+		for _, group := range byCountry {
+			sort.SliceStable(group, func(i, j int) bool { return group[i].load < group[j].load })
+		}
+		for i, c := range candidates {
+			candidates[i] = byCountry[c.Country][0]
+			byCountry[c.Country] = byCountry[c.Country][1:]
+		}
+	}
+
+	result := []RecommendedServer{}
+	for i, c := range candidates {
+		if i == limit {
+			break
+		}
+		switch {
+		case c.local:
+			c.Reason = "in your country"
+		case strategy == AssignLeastLoaded:
+			c.Reason = "least loaded"
+		case c.LatencyMs > 0:
+			c.Reason = "lowest latency"
+		default:
+			c.Reason = "available"
+		}
+		result = append(result, c.RecommendedServer)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"client_country": clientCountry,
+		"servers":        result,
+	})
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// renewalRetryInterval is how long a failed renewal waits before the saved
+// method is charged again.
+const renewalRetryInterval = 24 * time.Hour
+
+// extendPaidPlan moves the user to tier and extends it by the period of a
+// payment for it. Time left on the current plan is kept, unless the payment
+// was prorated, which credited it. Plans without an expiry, such as ones
+// granted by an admin, stay without one. A scheduled switch to another plan
+// is dropped. Returns the new expiry, zero if there is none.
+func (s *Server) extendPaidPlan(userID, tier string, prorated bool) (time.Time, error) {
+	paid, ok := paidPlans[tier]
+	if !ok {
+		_, err := s.DB.Exec("UPDATE users SET plan = ? WHERE id = ?", tier, userID)
+		return time.Time{}, err
+	}
+	now := time.Now().UTC()
+	// Retried if another update of the user got in between.
+	for attempt := 0; attempt < 3; attempt++ {
+		var plan string
+		var expiry sql.NullTime
+		var rawExpiry sql.NullString // As stored, for the compare below
+		if err := s.DB.QueryRow("SELECT plan, expiry_date, CAST(expiry_date AS TEXT) FROM users WHERE id = ?",
+			userID).Scan(&plan, &expiry, &rawExpiry); err != nil {
+			return time.Time{}, err
+		}
+		if plan == tier && !expiry.Valid {
+			return time.Time{}, nil
+		}
+		start := now
+		if expiry.Valid && expiry.Time.After(now) && !prorated {
+			start = expiry.Time.UTC()
+		}
+		newExpiry := start.AddDate(0, paid.Months, 0)
+
+		res, err := s.DB.Exec(`UPDATE users SET plan = ?, expiry_date = ?, renewal_attempted_at = NULL, renewal_failures = 0, next_plan = ''
+			WHERE id = ? AND plan = ? AND expiry_date IS ?`, tier, newExpiry, userID, plan, rawExpiry)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			return newExpiry, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("user %s changed concurrently", userID)
+}
+
+// sendReceipt emails the user a receipt for a succeeded payment.
+func (s *Server) sendReceipt(payResp *PaymentResponse, tier string, expiry time.Time) {
+	var email string
+	if err := s.DB.QueryRow("SELECT email FROM users WHERE id = ?", payResp.Metadata.UserID).Scan(&email); err != nil {
+		log.Printf("[Mail] Failed to look up user %s for a receipt: %v", payResp.Metadata.UserID, err)
+		return
+	}
+	body := fmt.Sprintf("Thank you for your payment.\n\n"+
+		"Plan: %s\nAmount: %s %s\nPayment ID: %s\n", tier, payResp.Amount.Value, payResp.Amount.Currency, payResp.ID)
+	if !expiry.IsZero() {
+		body += "Paid until: " + expiry.Format("2006-01-02") + "\n"
+	}
+	if m := payResp.PaymentMethod; m != nil && m.Title != "" {
+		body += "Paid with: " + m.Title + "\n"
+	}
+	if err := s.Mailer.Send(email, "Dr. Frake VPN payment receipt", body); err != nil {
+		log.Printf("[Mail] Failed to send receipt to %s: %v", email, err)
+	}
+}
+
+// runAutoRenew charges the saved payment method of users whose plan expires
+// within AutoRenewDays. A failed charge is retried daily; users whose
+// renewal keeps failing keep their plan for GracePeriodDays after expiry
+// before runSubscriptionExpiry downgrades them.
+func (s *Server) runAutoRenew(interval time.Duration) {
+	for {
+		s.renewDueSubscriptions(time.Now())
+		time.Sleep(interval)
+	}
+}
+
+func (s *Server) renewDueSubscriptions(now time.Time) {
+	now = now.UTC()
+	rows, err := s.DB.Query(`SELECT id FROM users
+		WHERE plan != 'free' AND auto_renew = 1 AND payment_method_id != '' AND paused_at IS NULL AND expiry_date IS NOT NULL
+		AND expiry_date <= ? AND (renewal_attempted_at IS NULL OR renewal_attempted_at <= ?)`,
+		now.AddDate(0, 0, s.Cfg.AutoRenewDays), now.Add(-renewalRetryInterval))
+	if err != nil {
+		log.Printf("[Renewal] Failed to query due subscriptions: %v", err)
+		return
+	}
+	var due []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			due = append(due, id)
+		}
+	}
+	rows.Close()
+
+	for _, userID := range due {
+		if !s.Flags.Enabled(FlagPayments, userID) {
+			continue
+		}
+		s.renewSubscription(userID, now)
+	}
+}
+
+// renewSubscription charges the user's saved method once for their plan.
+func (s *Server) renewSubscription(userID string, now time.Time) {
+	unlock := s.lockUser(userID)
+	defer unlock()
+
+	var email, plan, nextPlan, methodID string
+	var expiry sql.NullTime
+	var attempted sql.NullTime
+	err := s.DB.QueryRow(`SELECT email, plan, next_plan, payment_method_id, expiry_date, renewal_attempted_at FROM users
+		WHERE id = ? AND auto_renew = 1 AND paused_at IS NULL`, userID).Scan(&email, &plan, &nextPlan, &methodID, &expiry, &attempted)
+	if err != nil {
+		return // Renewed or turned off in the meantime
+	}
+	if !expiry.Valid || expiry.Time.After(now.AddDate(0, 0, s.Cfg.AutoRenewDays)) ||
+		(attempted.Valid && attempted.Time.After(now.Add(-renewalRetryInterval))) {
+		return
+	}
+	// A scheduled downgrade takes effect with the renewal: the next period is
+	// charged, and the plan switched, to it.
+	if _, ok := paidPlans[nextPlan]; ok {
+		plan = nextPlan
+	}
+	paid, ok := paidPlans[plan]
+	if !ok || methodID == "" {
+		return
+	}
+
+	// Recorded before charging, so a crash can't lead to a second charge
+	// before the retry interval.
+	if _, err := s.DB.Exec("UPDATE users SET renewal_attempted_at = ? WHERE id = ?", now, userID); err != nil {
+		log.Printf("[Renewal] Failed to record attempt for user %s: %v", userID, err)
+		return
+	}
+
+	// Renewals are charged at the price of the region the user last paid in.
+	amount, region := s.planPrice(plan, s.userRegion(userID))
+	payResp, err := s.Payments.ChargeSavedMethod(amount, paid.Description+" (renewal)", userID, plan, methodID)
+	if err != nil {
+		log.Printf("[Renewal] Failed to charge user %s: %v", userID, err)
+		s.renewalFailed(userID, email, plan, expiry.Time, "payment_error")
+		return
+	}
+	_, err = s.DB.Exec("INSERT INTO payments (id, user_id, yookassa_id, amount, status, plan, region) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		uuid.New().String(), userID, payResp.ID, amount, "pending", plan, region)
+	if err == nil {
+		err = s.applyPaymentStatus(payResp)
+	}
+	if err != nil {
+		// The reconciler credits the payment from the gateway's list.
+		log.Printf("[Renewal] Failed to record renewal payment %s of user %s: %v", payResp.ID, userID, err)
+		return
+	}
+
+	switch {
+	case payResp.Status == "succeeded":
+		log.Printf("[Renewal] Renewed %s plan of user %s", plan, userID)
+		s.emitEvent(EventSubscriptionRenewed, map[string]string{
+			"user_id":    userID,
+			"plan":       plan,
+			"payment_id": payResp.ID,
+			"amount":     payResp.Amount.Value,
+		})
+	case payResp.Status == "canceled":
+		reason := "canceled"
+		if d := payResp.CancellationDetails; d != nil && d.Reason != "" {
+			reason = d.Reason
+		}
+		s.renewalFailed(userID, email, plan, expiry.Time, reason)
+	default:
+		// Applied by the webhook or the reconciler once the gateway decides.
+		log.Printf("[Renewal] Renewal payment %s of user %s is %s", payResp.ID, userID, payResp.Status)
+	}
+}
+
+// renewalFailed records a failed renewal. The user is emailed about the
+// first failure of a period only.
+func (s *Server) renewalFailed(userID, email, plan string, expiry time.Time, reason string) {
+	log.Printf("[Renewal] Renewal of %s plan of user %s failed: %s", plan, userID, reason)
+	var failures int
+	if err := s.DB.QueryRow("UPDATE users SET renewal_failures = renewal_failures + 1 WHERE id = ? RETURNING renewal_failures",
+		userID).Scan(&failures); err != nil {
+		log.Printf("[Renewal] Failed to record failure for user %s: %v", userID, err)
+	}
+	s.emitEvent(EventRenewalFailed, map[string]interface{}{
+		"user_id":  userID,
+		"plan":     plan,
+		"reason":   reason,
+		"failures": failures,
+	})
+	if failures != 1 {
+		return
+	}
+	graceEnd := expiry.AddDate(0, 0, s.Cfg.GracePeriodDays)
+	body := fmt.Sprintf("We couldn't renew your %s Dr. Frake VPN subscription: the payment failed (%s).\n\n"+
+		"We'll try again daily. To keep Premium after %s, please pay in the app or the Telegram bot with another card.\n",
+		plan, reason, graceEnd.Format("2006-01-02"))
+	go func() {
+		if err := s.Mailer.Send(email, "Your Dr. Frake VPN renewal failed", body); err != nil {
+			log.Printf("[Mail] Failed to send renewal failure to %s: %v", email, err)
+		}
+	}()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3Uploader puts objects into an S3-compatible bucket (AWS, MinIO, Yandex
+// Object Storage, ...) using path-style URLs and AWS Signature Version 4.
+type S3Uploader struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+
+	httpClient *http.Client
+}
+
+func NewS3Uploader(endpoint, region, bucket, accessKey, secretKey string) *S3Uploader {
+	if endpoint == "" {
+		endpoint = "https://s3.amazonaws.com"
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3Uploader{
+		Endpoint:   strings.TrimRight(endpoint, "/"),
+		Region:     region,
+		Bucket:     bucket,
+		AccessKey:  accessKey,
+		SecretKey:  secretKey,
+		httpClient: &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// Put uploads data as the object key.
+func (u *S3Uploader) Put(key string, data []byte) error {
+	target, err := url.Parse(u.Endpoint + "/" + url.PathEscape(u.Bucket) + "/" + url.PathEscape(key))
+	if err != nil {
+		return fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	req, err := http.NewRequest("PUT", target.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	u.sign(req, data, time.Now())
+
+	resp, err := u.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("S3 upload failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("S3 upload failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (u *S3Uploader) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + u.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+u.SecretKey), date)
+	key = hmacSHA256(key, u.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		u.AccessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PaymentProvider creates and looks up payments. Implemented by YooKassaClient
+// and, for development and tests, SandboxPaymentClient.
+type PaymentProvider interface {
+	CreatePayment(amount string, description string, metadata PaymentMetadata, returnURL string) (*PaymentResponse, error)
+	GetPayment(paymentID string) (*PaymentResponse, error)
+	// ChargeSavedMethod charges a payment method saved by an earlier payment,
+	// for auto-renewal.
+	ChargeSavedMethod(amount string, description string, userID string, tier string, methodID string) (*PaymentResponse, error)
+	CreateRefund(paymentID string, amount string, description string) (*RefundResponse, error)
+	// ListPayments returns the payments created since the given time.
+	ListPayments(since time.Time) ([]PaymentResponse, error)
+	// VerifyNotification authenticates a notification POSTed to
+	// /payment/webhook, given its body.
+	VerifyNotification(r *http.Request, body []byte) error
+}
+
+// SandboxPaymentClient is an in-memory PaymentProvider. Payments are created
+// as "pending" and reported as "succeeded" on the first status check, so the
+// full upgrade flow runs without a payment gateway. Every payment saves a fake
+// card; charging it succeeds unless the method ID contains "decline".
+type SandboxPaymentClient struct {
+	// Notifications is how notifications, e.g. sent with curl to test
+	// /payment/webhook, authenticate.
+	Notifications NotificationAuth
+
+	mu       sync.Mutex
+	payments map[string]*PaymentResponse
+}
+
+func NewSandboxPaymentClient() *SandboxPaymentClient {
+	return &SandboxPaymentClient{payments: make(map[string]*PaymentResponse)}
+}
+
+func (c *SandboxPaymentClient) CreatePayment(amount string, description string, metadata PaymentMetadata, returnURL string) (*PaymentResponse, error) {
+	id := "sandbox-" + uuid.New().String()
+	confirmationURL := returnURL
+	if u, err := url.Parse(returnURL); err == nil {
+		q := u.Query()
+		q.Set("payment_id", id)
+		u.RawQuery = q.Encode()
+		confirmationURL = u.String()
+	}
+
+	payment := &PaymentResponse{
+		ID:     id,
+		Status: "pending",
+		Amount: Amount{Value: amount, Currency: "RUB"},
+		Confirmation: Confirmation{
+			Type:            "redirect",
+			ConfirmationURL: confirmationURL,
+		},
+		Description: description,
+		Metadata:    metadata,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.payments[id] = payment
+	copied := *payment
+	return &copied, nil
+}
+
+func (c *SandboxPaymentClient) GetPayment(paymentID string) (*PaymentResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	payment, ok := c.payments[paymentID]
+	if !ok {
+		return nil, fmt.Errorf("sandbox payment %s not found", paymentID)
+	}
+	if payment.Status == "pending" {
+		payment.Status = "succeeded"
+		payment.Paid = true
+		if payment.PaymentMethod == nil {
+			payment.PaymentMethod = &PaymentMethod{
+				ID:    "sandbox-pm-" + uuid.New().String(),
+				Type:  "bank_card",
+				Saved: true,
+				Title: "Bank card *4242",
+			}
+		}
+	}
+	copied := *payment
+	return &copied, nil
+}
+
+func (c *SandboxPaymentClient) ChargeSavedMethod(amount string, description string, userID string, tier string, methodID string) (*PaymentResponse, error) {
+	if !strings.HasPrefix(methodID, "sandbox-pm-") {
+		return nil, fmt.Errorf("sandbox payment method %s not found", methodID)
+	}
+	payment := &PaymentResponse{
+		ID:            "sandbox-" + uuid.New().String(),
+		Status:        "succeeded",
+		Paid:          true,
+		Amount:        Amount{Value: amount, Currency: "RUB"},
+		Description:   description,
+		Metadata:      PaymentMetadata{UserID: userID, Tier: tier},
+		CreatedAt:     time.Now().UTC(),
+		PaymentMethod: &PaymentMethod{ID: methodID, Type: "bank_card", Saved: true, Title: "Bank card *4242"},
+	}
+	if strings.Contains(methodID, "decline") {
+		payment.Status = "canceled"
+		payment.Paid = false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.payments[payment.ID] = payment
+	copied := *payment
+	return &copied, nil
+}
+
+func (c *SandboxPaymentClient) CreateRefund(paymentID string, amount string, description string) (*RefundResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	payment, ok := c.payments[paymentID]
+	if !ok {
+		return nil, fmt.Errorf("sandbox payment %s not found", paymentID)
+	}
+	if payment.Status != "succeeded" {
+		return nil, fmt.Errorf("sandbox payment %s is %s, only succeeded payments can be refunded", paymentID, payment.Status)
+	}
+	return &RefundResponse{
+		ID:        "sandbox-refund-" + uuid.New().String(),
+		PaymentID: paymentID,
+		Status:    "succeeded",
+		Amount:    Amount{Value: amount, Currency: "RUB"},
+	}, nil
+}
+
+func (c *SandboxPaymentClient) ListPayments(since time.Time) ([]PaymentResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var payments []PaymentResponse
+	for _, p := range c.payments {
+		if !p.CreatedAt.Before(since) {
+			payments = append(payments, *p)
+		}
+	}
+	sort.Slice(payments, func(i, j int) bool { return payments[i].CreatedAt.Before(payments[j].CreatedAt) })
+	return payments, nil
+}
+
+func (c *SandboxPaymentClient) VerifyNotification(r *http.Request, body []byte) error {
+	return c.Notifications.Verify(r, body)
+}
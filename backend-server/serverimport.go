@@ -0,0 +1,444 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxImportBytes caps the bodies of server imports, which can list many
+// servers, unlike the rest of the API's requests.
+const maxImportBytes = 4 << 20
+
+// ServerDefinition is a server as the admin API adds, imports and exports it,
+// credentials included. In CSV, the columns are named after the JSON fields.
+type ServerDefinition struct {
+	// ID is kept on import if no server has it, so clients' favorites and
+	// last servers survive a migration. Left out, one is generated.
+	ID         string `json:"id,omitempty"`
+	APIURL     string `json:"api_url"`
+	CertSHA256 string `json:"cert_sha256"` // Pin of the Outline API's or 3X-UI panel's certificate
+	Country    string `json:"country"`
+	City       string `json:"city"`
+	Flag       string `json:"flag"`
+	IsPremium  bool   `json:"is_premium"`
+	// New fields for dual provider support
+	Type          string `json:"type"` // "outline" (default) or "xray"
+	ServerHost    string `json:"server_host"`
+	XrayPanelURL  string `json:"xray_panel_url"`
+	XrayUsername  string `json:"xray_username"`
+	XrayPassword  string `json:"xray_password"`
+	XrayInboundID int    `json:"xray_inbound_id"`
+	XraySettings  string `json:"xray_settings"` // JSON string with Reality params
+	MaxUsers      int    `json:"max_users"`     // 0 = unlimited
+	// Tags such as "streaming", comma-separated in CSV.
+	Tags []string `json:"tags"`
+	// Archived servers are imported out of rotation.
+	Archived      bool   `json:"archived"`
+	ArchiveReason string `json:"archive_reason"`
+}
+
+// setDefaults fills in the fields left out.
+func (d *ServerDefinition) setDefaults() {
+	if d.Type == "" {
+		d.Type = "outline"
+	}
+	if d.XraySettings == "" {
+		d.XraySettings = "{}"
+	}
+	d.Tags = normalizeTags(d.Tags)
+}
+
+// validate checks the definition like any other request, see validate.go.
+func (d *ServerDefinition) validate() error {
+	err := firstError(
+		validateText("country", d.Country, 64),
+		validateText("city", d.City, 64),
+		validateText("flag", d.Flag, 16),
+		validateText("archive_reason", d.ArchiveReason, maxTextLen),
+		validateTags(d.Tags),
+		validateMaxUsers(d.MaxUsers),
+	)
+	if d.ID != "" {
+		err = firstError(err, validateText("id", d.ID, 64))
+	}
+	switch ServerType(d.Type) {
+	case ServerTypeOutline:
+		err = firstError(err,
+			validateHTTPURL("api_url", d.APIURL),
+			validateFingerprint("cert_sha256", d.CertSHA256))
+		// Outline's certificates are self-signed; without the pin anyone
+		// on the way could pose as the management API.
+		if d.CertSHA256 == "" {
+			err = firstError(err, errors.New("cert_sha256 is required for outline servers"))
+		}
+	case ServerTypeXray:
+		err = firstError(err,
+			validateHTTPURL("xray_panel_url", d.XrayPanelURL),
+			validateHost("server_host", d.ServerHost),
+			validateFingerprint("cert_sha256", d.CertSHA256))
+		if d.XrayUsername == "" || d.XrayPassword == "" {
+			err = firstError(err, errors.New("xray_username and xray_password are required"))
+		}
+		if d.XrayInboundID < 0 {
+			err = firstError(err, errors.New("xray_inbound_id must not be negative"))
+		}
+		var settings map[string]interface{}
+		if json.Unmarshal([]byte(d.XraySettings), &settings) != nil {
+			err = firstError(err, errors.New("xray_settings must be a JSON object"))
+		}
+	case ServerTypeMock:
+		if d.ServerHost != "" {
+			err = firstError(err, validateHost("server_host", d.ServerHost))
+		}
+	default:
+		err = firstError(err, errors.New("type must be outline, xray or mock"))
+	}
+	return err
+}
+
+// endpoint identifies the VPN server behind the definition, to catch the same
+// one added twice. Mock servers have none.
+func (d *ServerDefinition) endpoint() string {
+	switch ServerType(d.Type) {
+	case ServerTypeOutline:
+		return d.APIURL
+	case ServerTypeXray:
+		return fmt.Sprintf("%s#%d", d.XrayPanelURL, d.XrayInboundID)
+	}
+	return ""
+}
+
+// locateServer fills in the location from GeoIP when the operator left it
+// out.
+func (s *Server) locateServer(d *ServerDefinition) {
+	if d.Country != "" && d.City != "" && d.Flag != "" {
+		return
+	}
+	host := d.ServerHost
+	if host == "" {
+		for _, raw := range []string{d.APIURL, d.XrayPanelURL} {
+			if u, err := url.Parse(raw); err == nil && u.Hostname() != "" {
+				host = u.Hostname()
+				break
+			}
+		}
+	}
+	if host == "" {
+		return
+	}
+	geo, err := lookupHost(s.Geo, host)
+	if err != nil {
+		log.Printf("[GeoIP] Could not locate server host %s: %v", host, err)
+		return
+	}
+	if d.Country == "" {
+		d.Country = geo.Country
+	}
+	if d.City == "" {
+		d.City = geo.City
+	}
+	if d.Flag == "" {
+		d.Flag = countryFlag(geo.CountryCode)
+	}
+}
+
+// insertServer stores the definition, which must have an ID, with db, a
+// *sql.DB or *sql.Tx.
+func insertServer(db interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}, d ServerDefinition) error {
+	var archivedAt sql.NullTime
+	if d.Archived {
+		archivedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	}
+	_, err := db.Exec(`INSERT INTO servers
+		(id, api_url, cert_sha256, country, city, flag, is_premium, type, server_host,
+		 xray_inbound_id, xray_panel_url, xray_username, xray_password, xray_settings, max_users,
+		 archived, archived_at, archive_reason, tags)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.ID, d.APIURL, d.CertSHA256, d.Country, d.City, d.Flag, d.IsPremium,
+		d.Type, d.ServerHost, d.XrayInboundID, d.XrayPanelURL,
+		d.XrayUsername, d.XrayPassword, d.XraySettings, d.MaxUsers,
+		d.Archived, archivedAt, d.ArchiveReason, joinTags(d.Tags))
+	return err
+}
+
+// ServerImportResult is the outcome of one server of an import.
+type ServerImportResult struct {
+	Row int `json:"row"` // 1-based, not counting a CSV header
+	// ID is generated when the server is added, if the import left it out.
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Country string `json:"country,omitempty"`
+	City    string `json:"city,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ServerImportResponse is returned by POST /admin/servers/import.
+type ServerImportResponse struct {
+	DryRun   bool                 `json:"dry_run"`
+	Imported int                  `json:"imported"`
+	Errors   int                  `json:"errors"`
+	Results  []ServerImportResult `json:"results"`
+}
+
+// handleAdminImportServers adds the servers in the body, a JSON array of
+// ServerDefinitions or CSV with a header row, as picked by the Content-Type.
+// Either all of them are added or, if any is invalid or already exists, none;
+// the results tell which. With ?dry_run=true nothing is added either way.
+func (s *Server) handleAdminImportServers(w http.ResponseWriter, r *http.Request) {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	var defs []ServerDefinition
+	var err error
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "text/csv" {
+		defs, err = readServersCSV(r.Body)
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&defs)
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "Request body too large", 413)
+		return
+	} else if err != nil {
+		http.Error(w, "Bad request: "+err.Error(), 400)
+		return
+	}
+	if len(defs) == 0 {
+		http.Error(w, "No servers to import", 400)
+		return
+	}
+
+	// Servers already added, and the ones before in the import, can't be
+	// added again.
+	ids := make(map[string]bool)
+	endpoints := make(map[string]bool)
+	rows, err := s.DB.Query("SELECT id, type, api_url, xray_panel_url, xray_inbound_id FROM servers")
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	for rows.Next() {
+		var d ServerDefinition
+		if err := rows.Scan(&d.ID, &d.Type, &d.APIURL, &d.XrayPanelURL, &d.XrayInboundID); err == nil {
+			ids[d.ID] = true
+			endpoints[d.endpoint()] = true
+		}
+	}
+	rows.Close()
+
+	resp := ServerImportResponse{DryRun: dryRun, Results: []ServerImportResult{}}
+	for i := range defs {
+		d := &defs[i]
+		d.setDefaults()
+		err := d.validate()
+		if err == nil && d.ID != "" && ids[d.ID] {
+			err = fmt.Errorf("a server with id %s exists", d.ID)
+		}
+		if ep := d.endpoint(); err == nil && ep != "" && endpoints[ep] {
+			err = errors.New("the VPN server was already added")
+		}
+		result := ServerImportResult{Row: i + 1, Type: d.Type}
+		if err != nil {
+			result.ID, result.Error = d.ID, err.Error()
+			resp.Errors++
+		} else {
+			s.locateServer(d)
+			if d.ID != "" {
+				ids[d.ID] = true
+			}
+			endpoints[d.endpoint()] = true
+			result.ID, result.Country, result.City = d.ID, d.Country, d.City
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	if resp.Errors > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if dryRun {
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	defer tx.Rollback()
+	for i, d := range defs {
+		if d.ID == "" {
+			d.ID = uuid.New().String()
+			resp.Results[i].ID = d.ID
+		}
+		if err := insertServer(tx, d); err != nil {
+			http.Error(w, "Database error: "+err.Error(), 500)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Database error: "+err.Error(), 500)
+		return
+	}
+	resp.Imported = len(defs)
+	log.Printf("[Admin] Imported %d servers", len(defs))
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleAdminExportServers returns all servers, archived ones included, as
+// ServerDefinitions for POST /admin/servers/import: JSON, or CSV with
+// ?format=csv.
+func (s *Server) handleAdminExportServers(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		http.Error(w, "format must be json or csv", 400)
+		return
+	}
+	rows, err := s.DB.Query(`SELECT id, api_url, cert_sha256, country, city, flag, is_premium, type, server_host,
+		xray_panel_url, xray_username, xray_password, xray_inbound_id, xray_settings, max_users, archived, archive_reason, tags
+		FROM servers ORDER BY archived, country, city`)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	defs := []ServerDefinition{}
+	for rows.Next() {
+		var d ServerDefinition
+		var tags string
+		if err := rows.Scan(&d.ID, &d.APIURL, &d.CertSHA256, &d.Country, &d.City, &d.Flag, &d.IsPremium, &d.Type, &d.ServerHost,
+			&d.XrayPanelURL, &d.XrayUsername, &d.XrayPassword, &d.XrayInboundID, &d.XraySettings, &d.MaxUsers,
+			&d.Archived, &d.ArchiveReason, &tags); err != nil {
+			http.Error(w, "Database error", 500)
+			return
+		}
+		d.Tags = parseTags(tags)
+		defs = append(defs, d)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+
+	name := "servers-" + time.Now().UTC().Format("20060102-150405")
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+		writeServersCSV(w, defs)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.json"`)
+	json.NewEncoder(w).Encode(defs)
+}
+
+// serverCSVColumns are the CSV columns of ServerDefinition's fields, by
+// index, named after their JSON fields.
+func serverCSVColumns() []string {
+	t := reflect.TypeOf(ServerDefinition{})
+	columns := make([]string, t.NumField())
+	for i := range columns {
+		columns[i], _, _ = strings.Cut(t.Field(i).Tag.Get("json"), ",")
+	}
+	return columns
+}
+
+// readServersCSV parses CSV with a header row naming the columns. Columns can
+// be in any order and left out; empty cells are zero values.
+func readServersCSV(r io.Reader) ([]ServerDefinition, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	fields := make([]int, len(header))
+	columns := serverCSVColumns()
+	for i, name := range header {
+		fields[i] = -1
+		for j, column := range columns {
+			if strings.TrimSpace(name) == column {
+				fields[i] = j
+			}
+		}
+		if fields[i] < 0 {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+	}
+
+	var defs []ServerDefinition
+	for row := 1; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return defs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		var d ServerDefinition
+		v := reflect.ValueOf(&d).Elem()
+		for i, cell := range record {
+			if cell == "" {
+				continue
+			}
+			field := v.Field(fields[i])
+			switch field.Kind() {
+			case reflect.String:
+				field.SetString(cell)
+			case reflect.Bool:
+				b, err := strconv.ParseBool(cell)
+				if err != nil {
+					return nil, fmt.Errorf("row %d: %s must be true or false", row, header[i])
+				}
+				field.SetBool(b)
+			case reflect.Int:
+				n, err := strconv.Atoi(cell)
+				if err != nil {
+					return nil, fmt.Errorf("row %d: %s must be a number", row, header[i])
+				}
+				field.SetInt(int64(n))
+			case reflect.Slice:
+				field.Set(reflect.ValueOf(strings.Split(cell, ",")))
+			}
+		}
+		defs = append(defs, d)
+	}
+}
+
+// writeServersCSV writes defs with a header row of all columns.
+func writeServersCSV(w io.Writer, defs []ServerDefinition) error {
+	cw := csv.NewWriter(w)
+	cw.Write(serverCSVColumns())
+	for _, d := range defs {
+		v := reflect.ValueOf(d)
+		record := make([]string, v.NumField())
+		for i := range record {
+			if list, ok := v.Field(i).Interface().([]string); ok {
+				record[i] = strings.Join(list, ",")
+			} else {
+				record[i] = fmt.Sprint(v.Field(i).Interface())
+			}
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
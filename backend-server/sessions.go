@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// sessionTouchInterval limits how often last_seen_at is written per session.
+const sessionTouchInterval = time.Minute
+
+// Session is a login of a user on one device.
+type Session struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
+// createSession issues a new session token for the user. Only a hash of the
+// token is stored, so a leaked database doesn't leak usable tokens. The
+// session is tied to the device in the request's X-Device-ID, if any, so
+// revoking the device logs it out.
+func (s *Server) createSession(userID, device string, r *http.Request) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	now := time.Now().UTC()
+	_, err := s.DB.Exec(`INSERT INTO sessions (id, user_id, token_hash, device, device_id, user_agent, ip, created_at, last_seen_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		uuid.New().String(), userID, hashToken(token), device, r.Header.Get("X-Device-ID"), r.UserAgent(), clientIP(r), now, now)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// authUser returns the ID of the user the request is authenticated as.
+func (s *Server) authUser(r *http.Request) (string, bool) {
+	userID, _, ok := s.authSession(r)
+	return userID, ok
+}
+
+// authSession resolves the request's Authorization header to a user and the
+// session it belongs to. Bare user IDs, the tokens issued before sessions
+// existed, are still accepted with an empty session ID until the user logs
+// out everywhere or LegacyTokensUntil passes. Accounts created since are
+// stored with the legacy token revoked, so their IDs never authenticate.
+func (s *Server) authSession(r *http.Request) (userID, sessionID string, ok bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return "", "", false
+	}
+
+	var lastSeen time.Time
+	err := s.DB.QueryRow("SELECT id, user_id, last_seen_at FROM sessions WHERE token_hash = ? AND revoked_at IS NULL",
+		hashToken(token)).Scan(&sessionID, &userID, &lastSeen)
+	if err == nil {
+		if time.Since(lastSeen) > sessionTouchInterval {
+			s.DB.Exec("UPDATE sessions SET last_seen_at = ?, ip = ? WHERE id = ?", time.Now().UTC(), clientIP(r), sessionID)
+		}
+		return userID, sessionID, true
+	}
+	if err != sql.ErrNoRows {
+		log.Printf("[Sessions] Lookup failed: %v", err)
+		return "", "", false
+	}
+
+	if !time.Now().Before(s.legacyCutoff) {
+		return "", "", false
+	}
+	var legacyRevoked bool
+	if err := s.DB.QueryRow("SELECT legacy_token_revoked FROM users WHERE id = ?", token).Scan(&legacyRevoked); err != nil || legacyRevoked {
+		return "", "", false
+	}
+	return token, "", true
+}
+
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	userID, currentID, ok := s.authSession(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+
+	rows, err := s.DB.Query(`SELECT id, device, user_agent, ip, created_at, last_seen_at FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL ORDER BY last_seen_at DESC`, userID)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var sess Session
+		if err := rows.Scan(&sess.ID, &sess.Device, &sess.UserAgent, &sess.IP, &sess.CreatedAt, &sess.LastSeenAt); err != nil {
+			http.Error(w, "Database error", 500)
+			return
+		}
+		sess.Current = sess.ID == currentID
+		sessions = append(sessions, sess)
+	}
+	json.NewEncoder(w).Encode(sessions)
+}
+
+func (s *Server) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+
+	res, err := s.DB.Exec("UPDATE sessions SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), r.PathValue("id"), userID)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Session not found", 404)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleLogout revokes the session the request was made with.
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	_, sessionID, ok := s.authSession(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	if sessionID == "" {
+		http.Error(w, "Legacy token can only be revoked by logging out everywhere", 400)
+		return
+	}
+	if _, err := s.DB.Exec("UPDATE sessions SET revoked_at = ? WHERE id = ?", time.Now().UTC(), sessionID); err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleRevokeAllSessions logs the user out everywhere, including the calling
+// session and the legacy user ID token.
+func (s *Server) handleRevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	n, err := s.revokeSessions(userID)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "revoked": n})
+}
+
+// revokeSessions revokes every session of the user and disables the legacy
+// user ID token. It returns the number of sessions revoked.
+func (s *Server) revokeSessions(userID string) (int64, error) {
+	res, err := s.DB.Exec("UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", time.Now().UTC(), userID)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.DB.Exec("UPDATE users SET legacy_token_revoked = 1 WHERE id = ?", userID); err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	log.Printf("[Sessions] Revoked %d sessions of user %s", n, userID)
+	return n, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.getoutline.org/sdk/transport"
+	"golang.getoutline.org/sdk/transport/shadowsocks"
+)
+
+// smokeResult is a single line of the smoke test report.
+type smokeResult struct {
+	Step   string
+	OK     bool
+	Detail string
+}
+
+// smokeRunner drives a synthetic user journey against a running backend.
+type smokeRunner struct {
+	baseURL    string
+	adminToken string
+	target     string
+	timeout    time.Duration
+	httpClient *http.Client
+	results    []smokeResult
+}
+
+// runSmoke implements the "smoke" subcommand. It registers a throwaway user,
+// fetches the server list, checks that every returned access key actually
+// carries traffic, initiates a payment if the backend uses the sandbox
+// provider and deletes the user and its keys again through the admin API.
+// Returns the process exit code.
+func runSmoke(args []string) int {
+	fs := flag.NewFlagSet("smoke", flag.ExitOnError)
+	baseURL := fs.String("url", "http://localhost:8080", "Base URL of the backend to test")
+	target := fs.String("target", "example.com:80", "HTTP host:port to fetch through each access key")
+	plan := fs.String("plan", "monthly", "Plan to use for the payment step")
+	skipPayment := fs.Bool("skip-payment", false, "Do not initiate a payment")
+	adminToken := fs.String("admin-token", os.Getenv("ADMIN_TOKEN"), "Admin token to delete the smoke user with")
+	timeout := fs.Duration("timeout", 15*time.Second, "Timeout for each step")
+	fs.Parse(args)
+
+	r := &smokeRunner{
+		baseURL:    strings.TrimRight(*baseURL, "/"),
+		adminToken: *adminToken,
+		target:     *target,
+		timeout:    *timeout,
+		httpClient: &http.Client{
+			Timeout: *timeout,
+			// The backend may be probed from a machine with a VPN proxy configured.
+			Transport: &http.Transport{Proxy: nil},
+		},
+	}
+
+	email := fmt.Sprintf("smoke-%s@smoke.invalid", uuid.New().String()[:8])
+	password := uuid.New().String()
+
+	userID, token, ok := r.register(email, password)
+	if ok {
+		servers := r.fetchServers(token)
+		for _, srv := range servers {
+			// The smoke user is on the free plan.
+			if !srv.Locked {
+				r.checkAccessKey(srv)
+			}
+		}
+		if *skipPayment {
+			r.add("payment", true, "skipped")
+		} else if provider := r.paymentProvider(); provider != "sandbox" {
+			// Real payments are left alone, even unpaid ones.
+			r.add("payment", true, fmt.Sprintf("skipped, the payment provider is %s, not sandbox", provider))
+		} else {
+			r.initPayment(token, *plan)
+		}
+	}
+	if userID != "" {
+		r.deleteUser(userID)
+	}
+
+	return r.report(os.Stdout)
+}
+
+func (r *smokeRunner) add(step string, ok bool, detail string) {
+	r.results = append(r.results, smokeResult{Step: step, OK: ok, Detail: detail})
+}
+
+// register creates the smoke user and logs in. It returns the user's ID once
+// the user exists, even if logging in fails, so it can be deleted.
+func (r *smokeRunner) register(email, password string) (userID, token string, ok bool) {
+	creds, _ := json.Marshal(map[string]string{"email": email, "password": password})
+
+	resp, err := r.httpClient.Post(r.baseURL+"/register", "application/json", bytes.NewReader(creds))
+	if err != nil {
+		r.add("register", false, err.Error())
+		return "", "", false
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		r.add("register", false, fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(body))))
+		return "", "", false
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &created); err != nil || created.ID == "" {
+		r.add("register", false, "no id in response")
+		return "", "", false
+	}
+	r.add("register", true, email)
+
+	resp, err = r.httpClient.Post(r.baseURL+"/login", "application/json", bytes.NewReader(creds))
+	if err != nil {
+		r.add("login", false, err.Error())
+		return created.ID, "", false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		r.add("login", false, resp.Status)
+		return created.ID, "", false
+	}
+	var auth AuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil || auth.Token == "" {
+		r.add("login", false, "no token in response")
+		return created.ID, "", false
+	}
+	r.add("login", true, "")
+	return created.ID, auth.Token, true
+}
+
+// deleteUser deletes the smoke user and its keys, so runs don't pile up
+// users taking seats on the servers.
+func (r *smokeRunner) deleteUser(userID string) {
+	req, _ := http.NewRequest("DELETE", r.baseURL+"/admin/users/"+url.PathEscape(userID), nil)
+	if r.adminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+r.adminToken)
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		r.add("cleanup", false, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		r.add("cleanup", false, fmt.Sprintf("deleting user %s: %s: %s", userID, resp.Status, strings.TrimSpace(string(body))))
+		return
+	}
+	r.add("cleanup", true, "deleted user "+userID)
+}
+
+// paymentProvider returns the payment provider the backend reports in its
+// readiness probe, or "unknown".
+func (r *smokeRunner) paymentProvider() string {
+	resp, err := r.httpClient.Get(r.baseURL + "/readyz")
+	if err != nil {
+		return "unknown"
+	}
+	defer resp.Body.Close()
+	var ready struct {
+		PaymentProvider string `json:"payment_provider"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ready); err != nil || ready.PaymentProvider == "" {
+		return "unknown"
+	}
+	return ready.PaymentProvider
+}
+
+type smokeServer struct {
+	ID      string `json:"id"`
+	Country string `json:"country"`
+	City    string `json:"city"`
+	Config  string `json:"config"`
+	Type    string `json:"type"`
+	Locked  bool   `json:"locked"`
+}
+
+func (r *smokeRunner) fetchServers(token string) []smokeServer {
+	req, _ := http.NewRequest("GET", r.baseURL+"/servers", nil)
+	req.Header.Set("Authorization", token)
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		r.add("servers", false, err.Error())
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		r.add("servers", false, resp.Status)
+		return nil
+	}
+
+	var servers []smokeServer
+	if err := json.NewDecoder(resp.Body).Decode(&servers); err != nil {
+		r.add("servers", false, "invalid response: "+err.Error())
+		return nil
+	}
+	if len(servers) == 0 {
+		r.add("servers", false, "no servers returned")
+		return nil
+	}
+	r.add("servers", true, fmt.Sprintf("%d servers", len(servers)))
+	return servers
+}
+
+// checkAccessKey verifies that the access key of a server works. Shadowsocks keys
+// are exercised end-to-end by fetching the target through the SDK dialer. Other
+// protocols can't be spoken by the SDK, so only reachability of the endpoint is checked.
+func (r *smokeRunner) checkAccessKey(srv smokeServer) {
+	step := fmt.Sprintf("key %s (%s, %s %s)", srv.ID, srv.Type, srv.Country, srv.City)
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	var err error
+	var detail string
+	switch {
+	case srv.Config == "":
+		err = fmt.Errorf("empty access config")
+	case ServerType(srv.Type) == ServerTypeMock:
+		detail = "mock key, not dialed"
+	case strings.HasPrefix(srv.Config, "ss://"):
+		err = r.checkShadowsocks(ctx, srv.Config)
+		detail = "fetched " + r.target
+	default:
+		var endpoint string
+		endpoint, err = accessConfigEndpoint(srv.Config)
+		if err == nil {
+			var conn transport.StreamConn
+			conn, err = (&transport.TCPDialer{}).DialStream(ctx, endpoint)
+			if err == nil {
+				conn.Close()
+				detail = endpoint + " reachable"
+			}
+		}
+	}
+
+	if err != nil {
+		r.add(step, false, err.Error())
+		return
+	}
+	r.add(step, true, detail)
+}
+
+func (r *smokeRunner) checkShadowsocks(ctx context.Context, config string) error {
+	endpoint, cipherName, secret, err := parseShadowsocksURL(config)
+	if err != nil {
+		return err
+	}
+	key, err := shadowsocks.NewEncryptionKey(cipherName, secret)
+	if err != nil {
+		return err
+	}
+	dialer, err := shadowsocks.NewStreamDialer(&transport.TCPEndpoint{Address: endpoint}, key)
+	if err != nil {
+		return err
+	}
+
+	conn, err := dialer.DialStream(ctx, r.target)
+	if err != nil {
+		return fmt.Errorf("dial failed: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	host, _, _ := net.SplitHostPort(r.target)
+	req, _ := http.NewRequest("HEAD", "http://"+r.target+"/", nil)
+	req.Host = host
+	req.Header.Set("Connection", "close")
+	if err := req.Write(conn); err != nil {
+		return fmt.Errorf("write failed: %w", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return fmt.Errorf("no response through tunnel: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (r *smokeRunner) initPayment(token, plan string) {
+	data, _ := json.Marshal(map[string]string{"plan": plan})
+	req, _ := http.NewRequest("POST", r.baseURL+"/payment/init", bytes.NewReader(data))
+	req.Header.Set("Authorization", token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		r.add("payment", false, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		r.add("payment", false, fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(body))))
+		return
+	}
+
+	var payResp struct {
+		ID              string `json:"id"`
+		ConfirmationURL string `json:"confirmation_url"`
+	}
+	if err := json.Unmarshal(body, &payResp); err != nil || payResp.ConfirmationURL == "" {
+		r.add("payment", false, "missing confirmation_url")
+		return
+	}
+	r.add("payment", true, "payment "+payResp.ID)
+}
+
+// report prints the results and returns 0 if every step passed, 1 otherwise.
+func (r *smokeRunner) report(w io.Writer) int {
+	failed := 0
+	for _, res := range r.results {
+		status := "PASS"
+		if !res.OK {
+			status = "FAIL"
+			failed++
+		}
+		if res.Detail != "" {
+			fmt.Fprintf(w, "[%s] %s: %s\n", status, res.Step, res.Detail)
+		} else {
+			fmt.Fprintf(w, "[%s] %s\n", status, res.Step)
+		}
+	}
+	fmt.Fprintf(w, "%d/%d steps passed\n", len(r.results)-failed, len(r.results))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// parseShadowsocksURL extracts the endpoint, cipher and secret from a SIP002
+// ss:// URI, as returned by the Outline Server.
+func parseShadowsocksURL(config string) (endpoint, cipherName, secret string, err error) {
+	u, err := url.Parse(config)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid ss:// URI: %w", err)
+	}
+	if u.User == nil || u.Port() == "" {
+		return "", "", "", fmt.Errorf("invalid ss:// URI: missing credentials or port")
+	}
+
+	userInfo := u.User.Username()
+	if password, hasPassword := u.User.Password(); hasPassword {
+		userInfo += ":" + password
+	} else {
+		encoded := strings.TrimRight(userInfo, "=")
+		decoded, decErr := base64.RawURLEncoding.DecodeString(encoded)
+		if decErr != nil {
+			decoded, decErr = base64.RawStdEncoding.DecodeString(encoded)
+		}
+		if decErr != nil {
+			return "", "", "", fmt.Errorf("failed to decode ss:// user info: %w", decErr)
+		}
+		userInfo = string(decoded)
+	}
+
+	cipherName, secret, found := strings.Cut(userInfo, ":")
+	if !found {
+		return "", "", "", fmt.Errorf("invalid ss:// user info")
+	}
+	return u.Host, cipherName, secret, nil
+}
+
+// accessConfigEndpoint returns the host:port a non-Shadowsocks access config points to.
+func accessConfigEndpoint(config string) (string, error) {
+	u, err := url.Parse(config)
+	if err != nil {
+		return "", fmt.Errorf("invalid access config: %w", err)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return "", fmt.Errorf("access config has no host:port")
+	}
+	return u.Host, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// runSubscriptionExpiry downgrades users whose plan has expired to the free
+// plan, checking every interval until the process exits. Users with
+// auto-renewal on are downgraded GracePeriodDays later, while their renewal
+// is retried. Paused plans are resumed once their pause ran out.
+func (s *Server) runSubscriptionExpiry(interval time.Duration) {
+	for {
+		s.resumeDuePauses(time.Now())
+		s.expireSubscriptions(time.Now())
+		time.Sleep(interval)
+	}
+}
+
+func (s *Server) expireSubscriptions(now time.Time) {
+	graceCutoff := now.UTC()
+	if s.Cfg.AutoRenewDays > 0 {
+		graceCutoff = graceCutoff.AddDate(0, 0, -s.Cfg.GracePeriodDays)
+	}
+	const due = `plan != 'free' AND paused_at IS NULL AND expiry_date IS NOT NULL AND expiry_date <=
+		CASE WHEN auto_renew = 1 AND payment_method_id != '' THEN ? ELSE ? END`
+	rows, err := s.DB.Query(`SELECT id, email, plan, expiry_date FROM users WHERE `+due, graceCutoff, now.UTC())
+	if err != nil {
+		log.Printf("[Subscription] Failed to query expired users: %v", err)
+		return
+	}
+	type expired struct {
+		id, email, plan string
+		expiry          time.Time
+	}
+	var users []expired
+	for rows.Next() {
+		var u expired
+		if err := rows.Scan(&u.id, &u.email, &u.plan, &u.expiry); err == nil {
+			users = append(users, u)
+		}
+	}
+	rows.Close()
+
+	for _, u := range users {
+		// The plan may have been renewed since the query.
+		res, err := s.DB.Exec(`UPDATE users SET plan = 'free', expiry_date = NULL, renewal_attempted_at = NULL, renewal_failures = 0, next_plan = ''
+			WHERE id = ? AND plan = ? AND `+due, u.id, u.plan, graceCutoff, now.UTC())
+		if err != nil {
+			log.Printf("[Subscription] Failed to expire plan of user %s: %v", u.id, err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		log.Printf("[Subscription] Plan %s of user %s expired", u.plan, u.id)
+		s.applyPlanLimits(u.id)
+		s.emitEvent(EventSubscriptionExpired, map[string]interface{}{
+			"user_id":    u.id,
+			"email":      u.email,
+			"plan":       u.plan,
+			"expired_at": u.expiry,
+		})
+	}
+}
+
+// subscriptionURL returns the user's subscription link: a secret URL that
+// VPN clients such as v2rayNG, Hiddify or Streisand import to get the access
+// configs of all servers, for users without the desktop app. The token is
+// created on first use.
+func (s *Server) subscriptionURL(userID string) (string, error) {
+	var token string
+	if err := s.DB.QueryRow("SELECT sub_token FROM users WHERE id = ?", userID).Scan(&token); err != nil {
+		return "", err
+	}
+	if token == "" {
+		buf := make([]byte, 24)
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		token = hex.EncodeToString(buf)
+		// Another request may have set one meanwhile; the stored token wins.
+		if _, err := s.DB.Exec("UPDATE users SET sub_token = ? WHERE id = ? AND sub_token = ''", token, userID); err != nil {
+			return "", err
+		}
+		if err := s.DB.QueryRow("SELECT sub_token FROM users WHERE id = ?", userID).Scan(&token); err != nil {
+			return "", err
+		}
+	}
+	base := strings.TrimSuffix(s.Cfg.PublicURL, "/")
+	if base == "" {
+		base = "http://localhost" + s.Cfg.Port
+	}
+	return base + "/sub/" + token, nil
+}
+
+// handleSubscription serves a subscription link in the common base64 format:
+// one access config per line. Premium servers are only included for users on
+// a paid plan, since third-party clients can't gate them.
+func (s *Server) handleSubscription(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	var userID, plan string
+	err := s.DB.QueryRow("SELECT id, plan FROM users WHERE sub_token = ? AND sub_token != ''", token).Scan(&userID, &plan)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Not found", 404)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+
+	servers, err := s.userServers(userID, plan, nil)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	var configs []string
+	for _, srv := range servers {
+		if srv["locked"] == true {
+			continue
+		}
+		configs = append(configs, srv["config"].(string))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Profile-Title", "Dr. Frake VPN")
+	w.Header().Set("Profile-Update-Interval", "12")
+	w.Write([]byte(base64.StdEncoding.EncodeToString([]byte(strings.Join(configs, "\n")))))
+}
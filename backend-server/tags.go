@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Server tags describe what a server is good for, e.g. "streaming", "p2p",
+// "low-latency" or "obfuscated", so clients can group and filter the server
+// list by them. They are free-form within tagRe; the servers table keeps them
+// comma-separated.
+
+// maxServerTags is how many tags a server can have.
+const maxServerTags = 10
+
+var tagRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// normalizeTags lowercases and trims tags, drops empty and duplicate ones and
+// sorts them. It never returns nil, so tags encode as a JSON array.
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	out := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// validateTags checks normalized tags.
+func validateTags(tags []string) error {
+	if len(tags) > maxServerTags {
+		return fmt.Errorf("a server can have at most %d tags", maxServerTags)
+	}
+	for _, tag := range tags {
+		if !tagRe.MatchString(tag) {
+			return fmt.Errorf("tag %q must be 1-32 lowercase letters, digits and dashes", tag)
+		}
+	}
+	return nil
+}
+
+// parseTags splits the tags column.
+func parseTags(column string) []string {
+	if column == "" {
+		return []string{}
+	}
+	return strings.Split(column, ",")
+}
+
+// joinTags is the tags column of normalized tags.
+func joinTags(tags []string) string {
+	return strings.Join(tags, ",")
+}
+
+// handleAdminSetTags replaces the tags of a server.
+func (s *Server) handleAdminSetTags(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	tags := normalizeTags(req.Tags)
+	if err := validateTags(tags); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	res, err := s.DB.Exec("UPDATE servers SET tags = ? WHERE id = ?", joinTags(tags), id)
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Server not found", 404)
+		return
+	}
+	log.Printf("[Admin] Server %s tags set to %q", id, tags)
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "tags": tags})
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The Telegram bot is an optional sales channel: users link or create an
+// account with /start, buy a plan with /buy and receive their subscription
+// link once the payment succeeds. It is enabled by TELEGRAM_BOT_TOKEN and
+// uses long polling, so the backend needs no public webhook for it.
+
+// telegramLinkCodeTTL is how long a code from POST /telegram/link can be used.
+const telegramLinkCodeTTL = 15 * time.Minute
+
+// telegramPollTimeout is the long polling timeout of getUpdates.
+const telegramPollTimeout = 30 * time.Second
+
+// TelegramBot is a minimal client of the Telegram Bot API.
+type TelegramBot struct {
+	apiURL     string // Base URL including the bot token
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	username string // Set by the first successful getMe
+}
+
+func NewTelegramBot(apiURL, token string) *TelegramBot {
+	return &TelegramBot{
+		apiURL:     strings.TrimSuffix(apiURL, "/") + "/bot" + token,
+		httpClient: &http.Client{Timeout: telegramPollTimeout + 10*time.Second},
+	}
+}
+
+type tgUser struct {
+	ID        int64  `json:"id"`
+	FirstName string `json:"first_name"`
+	Username  string `json:"username"`
+}
+
+type tgMessage struct {
+	MessageID int64   `json:"message_id"`
+	From      *tgUser `json:"from"`
+	Chat      struct {
+		ID   int64  `json:"id"`
+		Type string `json:"type"`
+	} `json:"chat"`
+	Text string `json:"text"`
+}
+
+type tgCallbackQuery struct {
+	ID      string     `json:"id"`
+	From    tgUser     `json:"from"`
+	Message *tgMessage `json:"message"`
+	Data    string     `json:"data"`
+}
+
+type tgUpdate struct {
+	UpdateID      int64            `json:"update_id"`
+	Message       *tgMessage       `json:"message"`
+	CallbackQuery *tgCallbackQuery `json:"callback_query"`
+}
+
+// tgButton is an inline keyboard button; exactly one of URL and
+// CallbackData is set.
+type tgButton struct {
+	Text         string `json:"text"`
+	URL          string `json:"url,omitempty"`
+	CallbackData string `json:"callback_data,omitempty"`
+}
+
+// Username returns the bot's username, or "" until it has connected.
+func (b *TelegramBot) Username() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.username
+}
+
+// call invokes a Bot API method and decodes its result into result, if not nil.
+func (b *TelegramBot) call(method string, params interface{}, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	resp, err := b.httpClient.Post(b.apiURL+"/"+method, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// The error contains the URL, and with it the bot token.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("telegram %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("telegram %s: %d: %w", method, resp.StatusCode, err)
+	}
+	if !envelope.OK {
+		return fmt.Errorf("telegram %s: %s", method, envelope.Description)
+	}
+	if result != nil {
+		return json.Unmarshal(envelope.Result, result)
+	}
+	return nil
+}
+
+// send posts a message to a chat, with optional inline keyboard rows.
+func (b *TelegramBot) send(chatID int64, text string, buttons ...[]tgButton) error {
+	params := map[string]interface{}{
+		"chat_id":                  chatID,
+		"text":                     text,
+		"disable_web_page_preview": true,
+	}
+	if len(buttons) > 0 {
+		params["reply_markup"] = map[string]interface{}{"inline_keyboard": buttons}
+	}
+	return b.call("sendMessage", params, nil)
+}
+
+// runTelegramBot polls the Bot API for updates until the process exits.
+func (s *Server) runTelegramBot() {
+	bot := s.Telegram
+	var offset int64
+	for {
+		if bot.Username() == "" {
+			var me tgUser
+			if err := bot.call("getMe", struct{}{}, &me); err != nil {
+				log.Printf("[Telegram] Failed to connect: %v", err)
+				time.Sleep(time.Minute)
+				continue
+			}
+			bot.mu.Lock()
+			bot.username = me.Username
+			bot.mu.Unlock()
+			log.Printf("[Telegram] Bot @%s started", me.Username)
+		}
+
+		var updates []tgUpdate
+		err := bot.call("getUpdates", map[string]interface{}{
+			"offset":          offset,
+			"timeout":         int(telegramPollTimeout.Seconds()),
+			"allowed_updates": []string{"message", "callback_query"},
+		}, &updates)
+		if err != nil {
+			log.Printf("[Telegram] Failed to get updates: %v", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			switch {
+			case u.Message != nil && u.Message.Chat.Type == "private":
+				s.handleTelegramMessage(u.Message)
+			case u.CallbackQuery != nil && u.CallbackQuery.Message != nil:
+				bot.call("answerCallbackQuery", map[string]string{"callback_query_id": u.CallbackQuery.ID}, nil)
+				s.handleTelegramCommand(u.CallbackQuery.Message.Chat.ID, &u.CallbackQuery.From, u.CallbackQuery.Data)
+			}
+		}
+	}
+}
+
+func (s *Server) handleTelegramMessage(msg *tgMessage) {
+	if msg.From == nil || !strings.HasPrefix(msg.Text, "/") {
+		s.telegramHelp(msg.Chat.ID)
+		return
+	}
+	s.handleTelegramCommand(msg.Chat.ID, msg.From, msg.Text)
+}
+
+// handleTelegramCommand runs a command such as "/buy monthly". Commands from
+// inline buttons arrive the same way, as callback data.
+func (s *Server) handleTelegramCommand(chatID int64, from *tgUser, text string) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return
+	}
+	// Commands picked from the menu can be addressed as /buy@botname.
+	cmd, _, _ := strings.Cut(fields[0], "@")
+	arg := ""
+	if len(fields) > 1 {
+		arg = fields[1]
+	}
+
+	if s.Flags.Enabled(FlagMaintenance, strconv.FormatInt(chatID, 10)) {
+		msg := "The service is under maintenance, please try again later."
+		if flag, _ := s.Flags.Get(FlagMaintenance); flag.Message != "" {
+			msg = flag.Message
+		}
+		s.Telegram.send(chatID, msg)
+		return
+	}
+
+	switch cmd {
+	case "/start":
+		s.telegramStart(chatID, from, arg)
+	case "/buy":
+		s.telegramBuy(chatID, arg)
+	case "/status":
+		s.telegramStatus(chatID)
+	case "/redeem":
+		s.telegramRedeem(chatID, strings.Join(fields[1:], ""))
+	default:
+		s.telegramHelp(chatID)
+	}
+}
+
+func (s *Server) telegramHelp(chatID int64) {
+	s.Telegram.send(chatID, "Dr. Frake VPN\n\n"+
+		"/buy – buy a subscription\n"+
+		"/status – your plan and subscription link\n"+
+		"/redeem CODE – activate a gift code\n"+
+		"/start – link your account")
+}
+
+// telegramStart links the chat to an account. With a code from the app it
+// links the existing account; without one it creates an account for users
+// who only use the bot.
+func (s *Server) telegramStart(chatID int64, from *tgUser, code string) {
+	if code != "" {
+		var userID string
+		err := s.DB.QueryRow("SELECT user_id FROM telegram_link_codes WHERE code_hash = ? AND expires_at > ?",
+			hashToken(code), time.Now().UTC()).Scan(&userID)
+		if err != nil {
+			s.Telegram.send(chatID, "This link has expired. Open the app and link Telegram again.")
+			return
+		}
+		s.DB.Exec("DELETE FROM telegram_link_codes WHERE code_hash = ?", hashToken(code))
+		if err := s.linkTelegramChat(chatID, userID); err != nil {
+			log.Printf("[Telegram] Failed to link chat %d to user %s: %v", chatID, userID, err)
+			s.Telegram.send(chatID, "Something went wrong, please try again later.")
+			return
+		}
+		log.Printf("[Telegram] Linked chat %d to user %s", chatID, userID)
+		s.Telegram.send(chatID, "Your account is linked. Use /buy to get Premium or /status to see your plan.")
+		return
+	}
+
+	if userID, err := s.telegramUser(chatID); err == nil && userID != "" {
+		s.telegramStatus(chatID)
+		return
+	}
+
+	if !s.Flags.Enabled(FlagRegistration, strconv.FormatInt(chatID, 10)) {
+		s.Telegram.send(chatID, "Registration is temporarily closed.")
+		return
+	}
+	// The account has no usable password; it can only be used through the bot.
+	userID := uuid.New().String()
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	email := fmt.Sprintf("tg%d@telegram.invalid", from.ID)
+	_, err := s.DB.Exec("INSERT INTO users (id, email, password, plan, quota_reset_at, legacy_token_revoked) VALUES (?, ?, ?, ?, ?, 1)",
+		userID, email, hex.EncodeToString(buf), "free", addMonths(time.Now(), 1).UTC())
+	if err == nil {
+		err = s.linkTelegramChat(chatID, userID)
+	}
+	if err != nil {
+		log.Printf("[Telegram] Failed to create account for chat %d: %v", chatID, err)
+		s.Telegram.send(chatID, "Something went wrong, please try again later.")
+		return
+	}
+	log.Printf("[Telegram] Created user %s for chat %d", userID, chatID)
+	s.emitEvent(EventUserRegistered, map[string]string{"user_id": userID, "email": email})
+	s.Telegram.send(chatID, "Welcome to Dr. Frake VPN! Your account is ready.\n\n"+
+		"Already using the app? Link Telegram from the app instead to keep one account.")
+	s.telegramStatus(chatID)
+}
+
+// linkTelegramChat links a chat to a user, replacing earlier links of both.
+func (s *Server) linkTelegramChat(chatID int64, userID string) error {
+	_, err := s.DB.Exec(`DELETE FROM telegram_links WHERE chat_id = ? OR user_id = ?`, chatID, userID)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec("INSERT INTO telegram_links (chat_id, user_id, created_at) VALUES (?, ?, ?)",
+		chatID, userID, time.Now().UTC())
+	return err
+}
+
+// telegramUser returns the user linked to a chat, or "" if there is none.
+func (s *Server) telegramUser(chatID int64) (string, error) {
+	var userID string
+	err := s.DB.QueryRow("SELECT user_id FROM telegram_links WHERE chat_id = ?", chatID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return userID, err
+}
+
+func (s *Server) telegramBuy(chatID int64, plan string) {
+	userID, err := s.telegramUser(chatID)
+	if err != nil || userID == "" {
+		s.Telegram.send(chatID, "Send /start first to set up your account.")
+		return
+	}
+
+	if _, ok := paidPlans[plan]; !ok {
+		names := make([]string, 0, len(paidPlans))
+		for name := range paidPlans {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		// A chat has no client address to locate, so the price is the one of
+		// the user's last payment.
+		region := s.userRegion(userID)
+		var buttons [][]tgButton
+		for _, name := range names {
+			amount, _ := s.planPrice(name, region)
+			buttons = append(buttons, []tgButton{{
+				Text:         fmt.Sprintf("%s – %s ₽", paidPlans[name].Description, amount),
+				CallbackData: "/buy " + name,
+			}})
+		}
+		s.Telegram.send(chatID, "Choose a plan:", buttons...)
+		return
+	}
+
+	if !s.Flags.Enabled(FlagPayments, userID) {
+		s.Telegram.send(chatID, "Payments are temporarily unavailable, please try again later.")
+		return
+	}
+	if owner, err := s.familyOwner(userID); err == nil && owner != "" {
+		s.Telegram.send(chatID, "Your plan is shared by your family. Leave it in the app to buy your own.")
+		return
+	}
+	if start, downgrade, err := s.downgradeStart(userID, plan, time.Now().UTC()); err == nil && downgrade {
+		if err := s.scheduleDowngrade(userID, plan); err != nil {
+			log.Printf("[Telegram] Failed to schedule plan switch for user %s: %v", userID, err)
+			s.Telegram.send(chatID, "Couldn't switch the plan, please try again later.")
+			return
+		}
+		s.Telegram.send(chatID, fmt.Sprintf("Your plan switches to %s on %s, when the current one ends.",
+			paidPlans[plan].Description, start.Format("2006-01-02")))
+		return
+	}
+	payment, err := s.startPayment(userID, plan, s.userRegion(userID))
+	if err != nil {
+		log.Printf("[Telegram] Failed to start payment for user %s: %v", userID, err)
+		s.Telegram.send(chatID, "Couldn't start the payment, please try again later.")
+		return
+	}
+	s.Telegram.send(chatID,
+		fmt.Sprintf("%s: %s ₽. Pay with the button below; your subscription link arrives here once the payment goes through.",
+			paidPlans[plan].Description, payment.Amount),
+		[]tgButton{{Text: "Pay", URL: payment.ConfirmationURL}},
+		[]tgButton{{Text: "I've paid", CallbackData: "/status"}})
+}
+
+// telegramStatus shows the plan and subscription link. Pending payments are
+// checked with the gateway first, so "I've paid" works before the gateway's
+// notification arrives.
+func (s *Server) telegramStatus(chatID int64) {
+	userID, err := s.telegramUser(chatID)
+	if err != nil || userID == "" {
+		s.Telegram.send(chatID, "Send /start first to set up your account.")
+		return
+	}
+	pending, err := s.pendingPayment(userID, "")
+	if err != nil {
+		log.Printf("[Telegram] Failed to check pending payments of user %s: %v", userID, err)
+	}
+
+	var plan string
+	var expiry sql.NullTime
+	if err := s.DB.QueryRow("SELECT plan, expiry_date FROM users WHERE id = ?", userID).Scan(&plan, &expiry); err != nil {
+		s.Telegram.send(chatID, "Something went wrong, please try again later.")
+		return
+	}
+	text := "Plan: " + plan
+	if expiry.Valid {
+		text += ", until " + expiry.Time.Format("2006-01-02")
+	}
+	if pending != nil {
+		text += "\nYour payment is still awaiting confirmation."
+	}
+	if subURL, err := s.subscriptionURL(userID); err == nil {
+		text += "\n\nSubscription link (import it in v2rayNG, Hiddify, Streisand or a similar app):\n" + subURL
+	}
+	if plan == "free" {
+		s.Telegram.send(chatID, text, []tgButton{{Text: "Get Premium", CallbackData: "/buy"}})
+		return
+	}
+	s.Telegram.send(chatID, text)
+}
+
+func (s *Server) telegramRedeem(chatID int64, code string) {
+	userID, err := s.telegramUser(chatID)
+	if err != nil || userID == "" {
+		s.Telegram.send(chatID, "Send /start first to set up your account.")
+		return
+	}
+	if code == "" || len(code) > 64 {
+		s.Telegram.send(chatID, "Send the code along with the command, e.g. /redeem DRF-XXXX-XXXX-XXXX")
+		return
+	}
+	plan, expiry, err := s.redeemCode(userID, code)
+	switch {
+	case errors.Is(err, errCodeInvalid):
+		s.Telegram.send(chatID, "This code is invalid or has expired.")
+	case errors.Is(err, errCodeRedeemed):
+		s.Telegram.send(chatID, "This code has already been used.")
+	case errors.Is(err, errPlanPermanent):
+		s.Telegram.send(chatID, "Your plan doesn't expire, keep the code for someone else.")
+	case errors.Is(err, errPlanMismatch):
+		s.Telegram.send(chatID, "This code is for another plan, redeem it once your current plan ends.")
+	case err != nil:
+		log.Printf("[Telegram] Failed to redeem code for user %s: %v", userID, err)
+		s.Telegram.send(chatID, "Something went wrong, please try again later.")
+	default:
+		s.Telegram.send(chatID, fmt.Sprintf("Code activated: %s until %s.", plan, expiry.Format("2006-01-02")),
+			[]tgButton{{Text: "Get subscription link", CallbackData: "/status"}})
+	}
+}
+
+// notifyTelegramPayment sends the subscription link to the chat linked to a
+// user whose payment succeeded.
+func (s *Server) notifyTelegramPayment(userID, plan string) {
+	var chatID int64
+	if err := s.DB.QueryRow("SELECT chat_id FROM telegram_links WHERE user_id = ?", userID).Scan(&chatID); err != nil {
+		return
+	}
+	subURL, err := s.subscriptionURL(userID)
+	if err != nil {
+		log.Printf("[Telegram] Failed to create subscription link for user %s: %v", userID, err)
+		return
+	}
+	err = s.Telegram.send(chatID, fmt.Sprintf("Payment received, thank you! Your %s plan is active.\n\n"+
+		"Subscription link (import it in v2rayNG, Hiddify, Streisand or a similar app):\n%s", plan, subURL))
+	if err != nil {
+		log.Printf("[Telegram] Failed to notify user %s: %v", userID, err)
+	}
+}
+
+// handleTelegramLink creates a one-time code that links the user's account to
+// the Telegram bot, returned with the t.me deep link that sends it.
+func (s *Server) handleTelegramLink(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authUser(r)
+	if !ok {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	if s.Telegram == nil || s.Telegram.Username() == "" {
+		http.Error(w, "Telegram bot is not available", 503)
+		return
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	code := hex.EncodeToString(buf)
+	expiresAt := time.Now().Add(telegramLinkCodeTTL).UTC()
+	s.DB.Exec("DELETE FROM telegram_link_codes WHERE user_id = ? OR expires_at <= ?", userID, time.Now().UTC())
+	if _, err := s.DB.Exec("INSERT INTO telegram_link_codes (code_hash, user_id, expires_at) VALUES (?, ?, ?)",
+		hashToken(code), userID, expiresAt); err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":        "https://t.me/" + s.Telegram.Username() + "?start=" + code,
+		"expires_at": expiresAt,
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ServerTraffic is the last collected transfer of a server, for /metrics.
+type ServerTraffic struct {
+	ServerID string
+	Country  string
+	Type     string
+	Keys     int
+	Users    int
+	MaxUsers int
+	// Up reports whether the last collection from the provider succeeded.
+	Up bool
+	// Bytes is the sum over all keys as reported by the server; it drops when
+	// Outline's 30-day window moves on or 3X-UI counters are reset.
+	Bytes int64
+	// Observed only grows: it adds up the increases of each key's counter
+	// since the backend started.
+	Observed int64
+
+	last map[string]int64 // Key ID -> bytes at the last collection
+}
+
+// TrafficStats holds the transfer of all servers in rotation.
+type TrafficStats struct {
+	mu      sync.Mutex
+	servers map[string]*ServerTraffic
+}
+
+func NewTrafficStats() *TrafficStats {
+	return &TrafficStats{servers: make(map[string]*ServerTraffic)}
+}
+
+// Snapshot returns a copy of the stats, sorted by server ID.
+func (t *TrafficStats) Snapshot() []ServerTraffic {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := make([]ServerTraffic, 0, len(t.servers))
+	for _, st := range t.servers {
+		stats = append(stats, *st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ServerID < stats[j].ServerID })
+	return stats
+}
+
+// record stores a collection of a server. A key whose counter went down was
+// reset, or had old traffic leave Outline's window; that collection adds
+// nothing to Observed.
+func (t *TrafficStats) record(st ServerTraffic, transfer map[string]int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prev, ok := t.servers[st.ServerID]
+	if !ok {
+		prev = &ServerTraffic{}
+	}
+	// The first successful collection of a server sets the baseline.
+	baseline := prev.last == nil
+	st.Bytes, st.Observed, st.last = prev.Bytes, prev.Observed, prev.last
+	if transfer != nil {
+		st.Bytes = 0
+		for keyID, bytes := range transfer {
+			st.Bytes += bytes
+			if baseline {
+				continue
+			}
+			if last, seen := prev.last[keyID]; !seen {
+				st.Observed += bytes
+			} else if bytes > last {
+				st.Observed += bytes - last
+			}
+		}
+		st.last = transfer
+	}
+	t.servers[st.ServerID] = &st
+}
+
+// retain drops servers that left rotation.
+func (t *TrafficStats) retain(ids map[string]bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id := range t.servers {
+		if !ids[id] {
+			delete(t.servers, id)
+		}
+	}
+}
+
+// runTrafficCollector polls the transfer of all servers in rotation, so
+// /metrics answers scrapes without calling the VPN servers, and enforces the
+// data caps of the users with it.
+func (s *Server) runTrafficCollector(interval time.Duration) {
+	for {
+		s.collectTraffic()
+		time.Sleep(interval)
+	}
+}
+
+func (s *Server) collectTraffic() {
+	rows, err := s.DB.Query(`SELECT s.id, s.type, s.api_url, s.cert_sha256, s.server_host,
+		s.xray_inbound_id, s.xray_panel_url, s.xray_username, s.xray_password, s.xray_settings,
+		s.country, s.max_users, (SELECT COUNT(*) FROM access_keys k WHERE k.server_id = s.id),
+		(SELECT COUNT(DISTINCT k.user_id) FROM access_keys k WHERE k.server_id = s.id)
+		FROM servers s WHERE s.archived = 0`)
+	if err != nil {
+		log.Printf("[Traffic] Failed to list servers: %v", err)
+		return
+	}
+	type serverRow struct {
+		rec serverRecord
+		st  ServerTraffic
+	}
+	var servers []serverRow
+	for rows.Next() {
+		var row serverRow
+		rec := &row.rec
+		if err := rows.Scan(&rec.ID, &rec.Type, &rec.APIURL, &rec.CertSHA256, &rec.ServerHost,
+			&rec.XrayInboundID, &rec.XrayPanelURL, &rec.XrayUsername, &rec.XrayPassword, &rec.XraySettings,
+			&row.st.Country, &row.st.MaxUsers, &row.st.Keys, &row.st.Users); err != nil {
+			log.Printf("[Traffic] Error scanning server row: %v", err)
+			continue
+		}
+		row.st.ServerID, row.st.Type = rec.ID, rec.Type
+		servers = append(servers, row)
+	}
+	rows.Close()
+
+	ids := make(map[string]bool, len(servers))
+	recs := make(map[string]serverRecord, len(servers))
+	transfers := make(map[string]map[string]int64, len(servers))
+	for _, row := range servers {
+		ids[row.rec.ID] = true
+		recs[row.rec.ID] = row.rec
+		transfer, err := s.provider(row.rec).GetTransfer()
+		if err != nil {
+			log.Printf("[Traffic] Failed to get transfer of server %s: %v", row.rec.ID, err)
+		} else {
+			transfers[row.rec.ID] = transfer
+		}
+		row.st.Up = err == nil
+		s.Traffic.record(row.st, transfer)
+	}
+	s.Traffic.retain(ids)
+	s.enforceDataCaps(recs, transfers)
+}
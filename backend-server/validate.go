@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"drfrake-core"
+)
+
+// Request validation. Handlers decode with decodeJSON and check fields with
+// the validators below before touching the database or a VPN server, and
+// answer 400 with the validator's message.
+
+// maxBodyBytes caps JSON request bodies; none of the API's requests come close.
+const maxBodyBytes = 64 << 10
+
+const (
+	minPasswordLen = 8
+	maxPasswordLen = 128
+	maxEmailLen    = 254
+	// maxTextLen caps free-form fields such as notes, reasons and device names.
+	maxTextLen = 500
+)
+
+// decodeJSON decodes the request body into v. On failure it writes the error
+// response and returns false: 413 for oversized bodies, 400 otherwise.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request body too large", 413)
+		} else {
+			http.Error(w, "Bad request", 400)
+		}
+		return false
+	}
+	return true
+}
+
+// validateEmail accepts a bare address such as "user@example.com", without a
+// display name or surrounding spaces.
+func validateEmail(email string) error {
+	if email == "" {
+		return errors.New("Email is required")
+	}
+	if len(email) > maxEmailLen {
+		return errors.New("Email is too long")
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || !strings.Contains(email[strings.LastIndex(email, "@"):], ".") {
+		return errors.New("Invalid email")
+	}
+	return nil
+}
+
+func validatePassword(password string) error {
+	if utf8.RuneCountInString(password) < minPasswordLen {
+		return fmt.Errorf("Password must be at least %d characters", minPasswordLen)
+	}
+	if len(password) > maxPasswordLen {
+		return fmt.Errorf("Password must be at most %d bytes", maxPasswordLen)
+	}
+	return nil
+}
+
+// validatePlan accepts the configured plans only.
+func (s *Server) validatePlan(name string) error {
+	if _, ok := s.Plans[name]; !ok {
+		return fmt.Errorf("Unknown plan %q", name)
+	}
+	return nil
+}
+
+// validateText caps the length of a free-form field; empty is allowed.
+func validateText(field, value string, max int) error {
+	if utf8.RuneCountInString(value) > max {
+		return fmt.Errorf("%s must be at most %d characters", field, max)
+	}
+	if strings.ContainsAny(value, "\x00\r\n") {
+		return fmt.Errorf("%s must be a single line", field)
+	}
+	return nil
+}
+
+// validateCountryCode requires an ISO 3166-1 alpha-2 code in upper case,
+// as GeoIP reports them, e.g. "KZ".
+func validateCountryCode(field, code string) error {
+	if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+		return fmt.Errorf("%s must be a two-letter country code such as KZ", field)
+	}
+	return nil
+}
+
+// validateHTTPURL requires an absolute http(s) URL with a host.
+func validateHTTPURL(field, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s must be an http(s) URL", field)
+	}
+	if u.User != nil {
+		return fmt.Errorf("%s must not contain credentials", field)
+	}
+	return nil
+}
+
+// validateHost requires a hostname or IP address, without scheme or port.
+func validateHost(field, host string) error {
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	if host == "" || len(host) > 253 || strings.Trim(host, ".") != host {
+		return fmt.Errorf("%s must be a hostname or IP address", field)
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return fmt.Errorf("%s must be a hostname or IP address", field)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return fmt.Errorf("%s must be a hostname or IP address", field)
+			}
+		}
+	}
+	return nil
+}
+
+// validateFingerprint accepts a hex SHA-256 fingerprint, with or without
+// colon separators, as the Outline installer prints it. Empty is allowed and
+// disables pinning.
+func validateFingerprint(field, fp string) error {
+	if fp == "" {
+		return nil
+	}
+	b, err := hex.DecodeString(strings.ReplaceAll(fp, ":", ""))
+	if err != nil || len(b) != 32 {
+		return fmt.Errorf("%s must be a hex SHA-256 fingerprint", field)
+	}
+	return nil
+}
+
+// validateAccessConfig checks an access config, as a provider created it,
+// before it is stored and handed to clients, so they never get one they
+// can't connect with.
+func validateAccessConfig(config string) error {
+	info, err := core.ValidateConfig(config)
+	if err != nil {
+		return err
+	}
+	if info.Smart {
+		return errors.New("the smart mode isn't an access config")
+	}
+	return nil
+}
+
+// firstError returns the first non-nil error, so a handler can run a list of
+// checks and report the first failure.
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
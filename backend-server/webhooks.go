@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhook events.
+const (
+	EventUserRegistered      = "user.registered"
+	EventUserEmailChanged    = "user.email_changed"
+	EventPaymentSucceeded    = "payment.succeeded"
+	EventSubscriptionExpired = "subscription.expired"
+	EventSubscriptionRenewed = "subscription.renewed"
+	EventRenewalFailed       = "subscription.renewal_failed"
+	EventServerUnhealthy     = "server.unhealthy"
+	EventAbuseFlagged        = "abuse.flagged"
+	EventPing                = "ping" // Sent by the test endpoint only
+)
+
+var webhookEvents = []string{EventUserRegistered, EventUserEmailChanged, EventPaymentSucceeded, EventSubscriptionExpired, EventServerUnhealthy, EventAbuseFlagged}
+
+const (
+	webhookMaxAttempts = 8
+	webhookTimeout     = 10 * time.Second
+)
+
+// Webhook is an operator-registered endpoint receiving events.
+type Webhook struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"` // Empty means all events
+	// Secret is only returned when the webhook is created.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookPayload is the body POSTed to webhooks. Receivers verify it with the
+// X-DrFrake-Signature header: "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)),
+// where timestamp is the X-DrFrake-Timestamp header.
+type WebhookPayload struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// emitEvent queues an event for every webhook subscribed to it. Delivery
+// happens in the background, see runWebhookDeliveries.
+func (s *Server) emitEvent(event string, data interface{}) {
+	rows, err := s.DB.Query("SELECT id, events FROM webhooks")
+	if err != nil {
+		log.Printf("[Webhook] Failed to list webhooks: %v", err)
+		return
+	}
+	var targets []string
+	for rows.Next() {
+		var id, events string
+		if err := rows.Scan(&id, &events); err != nil {
+			continue
+		}
+		if events == "" || slices.Contains(strings.Split(events, ","), event) {
+			targets = append(targets, id)
+		}
+	}
+	rows.Close()
+	if len(targets) == 0 {
+		return
+	}
+
+	payload, _ := json.Marshal(WebhookPayload{
+		ID:        uuid.New().String(),
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	for _, webhookID := range targets {
+		s.queueDelivery(webhookID, event, payload)
+	}
+}
+
+func (s *Server) queueDelivery(webhookID, event string, payload []byte) {
+	_, err := s.DB.Exec(`INSERT INTO webhook_deliveries (id, webhook_id, event, payload, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?)`, uuid.New().String(), webhookID, event, string(payload), time.Now().UTC())
+	if err != nil {
+		log.Printf("[Webhook] Failed to queue %s for webhook %s: %v", event, webhookID, err)
+	}
+}
+
+// runWebhookDeliveries sends due deliveries every interval. Failed deliveries
+// are retried with exponential backoff, up to webhookMaxAttempts times.
+func (s *Server) runWebhookDeliveries(interval time.Duration) {
+	client := &http.Client{Timeout: webhookTimeout}
+	for {
+		s.deliverDueWebhooks(client, time.Now())
+		time.Sleep(interval)
+	}
+}
+
+func (s *Server) deliverDueWebhooks(client *http.Client, now time.Time) {
+	rows, err := s.DB.Query(`SELECT d.id, d.event, d.payload, d.attempts, w.url, w.secret
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = 'pending' AND d.next_attempt_at <= ?
+		ORDER BY d.next_attempt_at LIMIT 100`, now.UTC())
+	if err != nil {
+		log.Printf("[Webhook] Failed to query deliveries: %v", err)
+		return
+	}
+	type delivery struct {
+		id, event, payload string
+		attempts           int
+		url, secret        string
+	}
+	var due []delivery
+	for rows.Next() {
+		var d delivery
+		if err := rows.Scan(&d.id, &d.event, &d.payload, &d.attempts, &d.url, &d.secret); err == nil {
+			due = append(due, d)
+		}
+	}
+	rows.Close()
+
+	for _, d := range due {
+		err := sendWebhook(client, d.url, d.secret, d.id, d.event, []byte(d.payload))
+		attempts := d.attempts + 1
+		switch {
+		case err == nil:
+			s.DB.Exec("UPDATE webhook_deliveries SET status = 'delivered', attempts = ?, last_error = '' WHERE id = ?", attempts, d.id)
+		case attempts >= webhookMaxAttempts:
+			log.Printf("[Webhook] Giving up on %s delivery %s to %s: %v", d.event, d.id, d.url, err)
+			s.DB.Exec("UPDATE webhook_deliveries SET status = 'failed', attempts = ?, last_error = ? WHERE id = ?", attempts, err.Error(), d.id)
+		default:
+			// 30s, 1m, 2m, ... capped at an hour.
+			backoff := min(30*time.Second<<(attempts-1), time.Hour)
+			s.DB.Exec("UPDATE webhook_deliveries SET attempts = ?, last_error = ?, next_attempt_at = ? WHERE id = ?",
+				attempts, err.Error(), now.Add(backoff).UTC(), d.id)
+		}
+	}
+}
+
+// sendWebhook POSTs a signed payload. Any 2xx response counts as delivered.
+func sendWebhook(client *http.Client, target, secret, deliveryID, event string, payload []byte) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+
+	req, err := http.NewRequest("POST", target, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "DrFrake-Webhooks/1.0")
+	req.Header.Set("X-DrFrake-Event", event)
+	req.Header.Set("X-DrFrake-Delivery", deliveryID)
+	req.Header.Set("X-DrFrake-Timestamp", timestamp)
+	req.Header.Set("X-DrFrake-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *Server) handleAdminListWebhooks(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.DB.Query("SELECT id, url, events, created_at FROM webhooks ORDER BY created_at")
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		var wh Webhook
+		var events string
+		if err := rows.Scan(&wh.ID, &wh.URL, &events, &wh.CreatedAt); err != nil {
+			log.Printf("Error scanning webhook row: %v", err)
+			continue
+		}
+		wh.Events = []string{}
+		if events != "" {
+			wh.Events = strings.Split(events, ",")
+		}
+		webhooks = append(webhooks, wh)
+	}
+	json.NewEncoder(w).Encode(webhooks)
+}
+
+// handleAdminAddWebhook registers a webhook. A secret is generated unless one
+// is given; it is only shown in this response.
+func (s *Server) handleAdminAddWebhook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL    string   `json:"url"`
+		Secret string   `json:"secret"`
+		Events []string `json:"events"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := firstError(validateHTTPURL("url", req.URL), validateText("secret", req.Secret, 256)); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	for _, e := range req.Events {
+		if !slices.Contains(webhookEvents, e) {
+			http.Error(w, "Unknown event: "+e, 400)
+			return
+		}
+	}
+	if req.Secret == "" {
+		buf := make([]byte, 32)
+		rand.Read(buf)
+		req.Secret = hex.EncodeToString(buf)
+	}
+
+	wh := Webhook{
+		ID:        uuid.New().String(),
+		URL:       req.URL,
+		Events:    req.Events,
+		Secret:    req.Secret,
+		CreatedAt: time.Now().UTC(),
+	}
+	if wh.Events == nil {
+		wh.Events = []string{}
+	}
+	_, err := s.DB.Exec("INSERT INTO webhooks (id, url, secret, events, created_at) VALUES (?, ?, ?, ?, ?)",
+		wh.ID, wh.URL, wh.Secret, strings.Join(wh.Events, ","), wh.CreatedAt)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), 500)
+		return
+	}
+	log.Printf("[Admin] Added webhook %s -> %s", wh.ID, wh.URL)
+	json.NewEncoder(w).Encode(wh)
+}
+
+func (s *Server) handleAdminDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	res, err := s.DB.Exec("DELETE FROM webhooks WHERE id = ?", id)
+	if err != nil {
+		http.Error(w, "Database error: "+err.Error(), 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Webhook not found", 404)
+		return
+	}
+	s.DB.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id)
+	log.Printf("[Admin] Deleted webhook %s", id)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": id})
+}
+
+// handleAdminTestWebhook sends a ping event right away and reports the outcome.
+func (s *Server) handleAdminTestWebhook(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var target, secret string
+	err := s.DB.QueryRow("SELECT url, secret FROM webhooks WHERE id = ?", id).Scan(&target, &secret)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Webhook not found", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+
+	deliveryID := uuid.New().String()
+	payload, _ := json.Marshal(WebhookPayload{
+		ID:        deliveryID,
+		Event:     EventPing,
+		CreatedAt: time.Now().UTC(),
+		Data:      map[string]string{"webhook_id": id},
+	})
+	if err := sendWebhook(&http.Client{Timeout: webhookTimeout}, target, secret, deliveryID, EventPing, payload); err != nil {
+		http.Error(w, "Delivery failed: "+err.Error(), 502)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": id})
+}
+
+// WebhookDelivery is a queued, delivered or failed event delivery.
+type WebhookDelivery struct {
+	ID            string    `json:"id"`
+	Event         string    `json:"event"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error,omitempty"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+func (s *Server) handleAdminListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.DB.Query(`SELECT id, event, status, attempts, last_error, next_attempt_at, created_at
+		FROM webhook_deliveries WHERE webhook_id = ? ORDER BY created_at DESC LIMIT 100`, r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Database error", 500)
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.Event, &d.Status, &d.Attempts, &d.LastError, &d.NextAttemptAt, &d.CreatedAt); err != nil {
+			log.Printf("Error scanning delivery row: %v", err)
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+	json.NewEncoder(w).Encode(deliveries)
+}
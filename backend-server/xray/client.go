@@ -2,29 +2,60 @@ package xray
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Client communicates with 3X-UI panel API.
+// It is safe for concurrent use; an expired panel session is refreshed transparently.
 type Client struct {
-	BaseURL    string
-	Username   string
-	Password   string
+	BaseURL  string
+	Username string
+	Password string
+	// MaxRetries is the number of extra attempts for requests that fail at the transport level.
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, doubled on every attempt.
+	RetryBackoff time.Duration
+
 	httpClient *http.Client
-	loggedIn   bool
+
+	loginMu  sync.Mutex // serializes logins
+	mu       sync.Mutex // guards loggedIn and sessionGen
+	loggedIn bool
+	// sessionGen is bumped on every successful login, so concurrent callers
+	// that saw the same expired session only trigger one re-login.
+	sessionGen int
 }
 
+// Inbound protocols supported by the provider.
+const (
+	ProtocolVLESS  = "vless"
+	ProtocolVMess  = "vmess"
+	ProtocolTrojan = "trojan"
+)
+
 type InboundClient struct {
-	ID    string `json:"id"`
-	Email string `json:"email"`
-	Flow  string `json:"flow"`
+	ID         string `json:"id,omitempty"`
+	Password   string `json:"password,omitempty"` // Trojan clients are identified by password
+	Email      string `json:"email"`
+	Flow       string `json:"flow,omitempty"`
+	Enable     bool   `json:"enable"`
+	LimitIP    int    `json:"limitIp"`
+	TotalGB    int64  `json:"totalGB"`    // Traffic quota in bytes, 0 = unlimited
+	ExpiryTime int64  `json:"expiryTime"` // Unix milliseconds, 0 = never
+	SubID      string `json:"subId,omitempty"`
 }
 
 type InboundInfo struct {
@@ -35,7 +66,7 @@ type InboundInfo struct {
 	Remark         string          `json:"remark"`
 	Enable         bool            `json:"enable"`
 	ExpiryTime     int64           `json:"expiryTime"`
-	ClientStats    []interface{}   `json:"clientStats"`
+	ClientStats    []ClientTraffic `json:"clientStats"`
 	Listen         string          `json:"listen"`
 	Port           int             `json:"port"`
 	Protocol       string          `json:"protocol"`
@@ -45,10 +76,75 @@ type InboundInfo struct {
 	Sniffing       json.RawMessage `json:"sniffing"`
 }
 
+// Key returns the identifier of the client used by the panel API: the
+// password for Trojan clients, the UUID otherwise.
+func (c InboundClient) Key() string {
+	if c.Password != "" {
+		return c.Password
+	}
+	return c.ID
+}
+
+// StreamSettings is the subset of an inbound's streamSettings the backend needs.
+type StreamSettings struct {
+	Network         string           `json:"network"`
+	Security        string           `json:"security"`
+	RealitySettings *RealitySettings `json:"realitySettings,omitempty"`
+	TLSSettings     *TLSSettings     `json:"tlsSettings,omitempty"`
+}
+
+// RealitySettings holds the server-side Reality configuration of an inbound.
+// The client-facing parameters are under Settings.
+type RealitySettings struct {
+	Dest        string   `json:"dest"`
+	ServerNames []string `json:"serverNames"`
+	ShortIDs    []string `json:"shortIds"`
+	Settings    struct {
+		PublicKey   string `json:"publicKey"`
+		Fingerprint string `json:"fingerprint"`
+		ServerName  string `json:"serverName"`
+		SpiderX     string `json:"spiderX"`
+	} `json:"settings"`
+}
+
+// TLSSettings holds the TLS configuration of an inbound.
+type TLSSettings struct {
+	ServerName string `json:"serverName"`
+	Settings   struct {
+		Fingerprint string `json:"fingerprint"`
+	} `json:"settings"`
+}
+
+// ParseStreamSettings decodes the inbound's streamSettings.
+func (i *InboundInfo) ParseStreamSettings() (*StreamSettings, error) {
+	var ss StreamSettings
+	if err := decodeEmbeddedJSON(i.StreamSettings, &ss); err != nil {
+		return nil, fmt.Errorf("failed to parse stream settings: %w", err)
+	}
+	return &ss, nil
+}
+
+// decodeEmbeddedJSON decodes a settings field, which the panel returns as a
+// JSON-encoded string, but some versions return as a plain object.
+func decodeEmbeddedJSON(raw json.RawMessage, v interface{}) error {
+	if len(raw) > 0 && raw[0] == '"' {
+		var inner string
+		if err := json.Unmarshal(raw, &inner); err != nil {
+			return err
+		}
+		raw = json.RawMessage(inner)
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, v)
+}
+
 type VLESSConfig struct {
 	UUID        string
 	Host        string
 	Port        int
+	Network     string // Transport, "tcp" if empty
 	Security    string
 	Flow        string
 	SNI         string
@@ -58,23 +154,70 @@ type VLESSConfig struct {
 	SpiderX     string
 }
 
+// DefaultTimeout is the per-request timeout used by NewClient.
+const DefaultTimeout = 15 * time.Second
+
 // NewClient creates a 3X-UI API client.
 func NewClient(baseURL, username, password string) *Client {
+	return NewClientWithTimeout(baseURL, username, password, DefaultTimeout)
+}
+
+// NewClientWithTimeout creates a 3X-UI API client with a custom per-request timeout.
+func NewClientWithTimeout(baseURL, username, password string, timeout time.Duration) *Client {
 	jar, _ := cookiejar.New(nil)
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
 	return &Client{
-		BaseURL:  strings.TrimRight(baseURL, "/"),
-		Username: username,
-		Password: password,
+		BaseURL:      strings.TrimRight(baseURL, "/"),
+		Username:     username,
+		Password:     password,
+		MaxRetries:   2,
+		RetryBackoff: 500 * time.Millisecond,
 		httpClient: &http.Client{
 			Jar:       jar,
 			Transport: tr,
+			Timeout:   timeout,
+			// Don't follow redirects: the panel redirects to the login page
+			// when the session cookie has expired, which must be detected.
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
 		},
 	}
 }
 
+// ErrCertificateMismatch is returned when the panel presents a certificate
+// that doesn't match the pinned fingerprint.
+var ErrCertificateMismatch = errors.New("3x-ui panel certificate does not match pinned sha256 fingerprint")
+
+// PinCertificate makes the client only accept a panel whose certificate
+// hashes to certSHA256, a hex SHA-256 fingerprint, such as the self-signed
+// one provisioning sets the panel up with. Call it before any request. A
+// malformed fingerprint is returned as an error and rejects every connection
+// rather than disabling pinning.
+func (c *Client) PinCertificate(certSHA256 string) error {
+	fingerprint, parseErr := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(certSHA256), ":", ""))
+	if parseErr == nil && len(fingerprint) != sha256.Size {
+		parseErr = fmt.Errorf("invalid certificate fingerprint length %d", len(fingerprint))
+	}
+	tr := c.httpClient.Transport.(*http.Transport)
+	tr.TLSClientConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if parseErr != nil {
+			return parseErr
+		}
+		if len(rawCerts) == 0 {
+			return ErrCertificateMismatch
+		}
+		sum := sha256.Sum256(rawCerts[0])
+		if !bytes.Equal(sum[:], fingerprint) {
+			return ErrCertificateMismatch
+		}
+		return nil
+	}
+	return parseErr
+}
+
 // Login authenticates with the 3X-UI panel.
 func (c *Client) Login() error {
 	payload := map[string]string{
@@ -83,7 +226,7 @@ func (c *Client) Login() error {
 	}
 	data, _ := json.Marshal(payload)
 
-	resp, err := c.httpClient.Post(c.BaseURL+"/login", "application/json", bytes.NewBuffer(data))
+	resp, err := c.send("POST", "/login", data)
 	if err != nil {
 		return fmt.Errorf("login request failed: %w", err)
 	}
@@ -100,25 +243,16 @@ func (c *Client) Login() error {
 		return fmt.Errorf("login failed: %s", result.Msg)
 	}
 
+	c.mu.Lock()
 	c.loggedIn = true
-	return nil
-}
-
-// ensureLoggedIn performs login if not already authenticated.
-func (c *Client) ensureLoggedIn() error {
-	if !c.loggedIn {
-		return c.Login()
-	}
+	c.sessionGen++
+	c.mu.Unlock()
 	return nil
 }
 
 // GetInbound returns info about a specific inbound by ID.
 func (c *Client) GetInbound(inboundID int) (*InboundInfo, error) {
-	if err := c.ensureLoggedIn(); err != nil {
-		return nil, err
-	}
-
-	resp, err := c.httpClient.Get(fmt.Sprintf("%s/panel/api/inbounds/get/%d", c.BaseURL, inboundID))
+	resp, err := c.do("GET", fmt.Sprintf("/panel/api/inbounds/get/%d", inboundID), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -137,16 +271,62 @@ func (c *Client) GetInbound(inboundID int) (*InboundInfo, error) {
 	return &result.Obj, nil
 }
 
+// ListInbounds returns all inbounds configured on the panel.
+func (c *Client) ListInbounds() ([]InboundInfo, error) {
+	resp, err := c.do("GET", "/panel/api/inbounds/list", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool          `json:"success"`
+		Msg     string        `json:"msg"`
+		Obj     []InboundInfo `json:"obj"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if !result.Success {
+		return nil, fmt.Errorf("failed to list inbounds: %s", result.Msg)
+	}
+	return result.Obj, nil
+}
+
+// FindInbound returns the first enabled inbound using the given protocol.
+func (c *Client) FindInbound(protocol string) (*InboundInfo, error) {
+	inbounds, err := c.ListInbounds()
+	if err != nil {
+		return nil, err
+	}
+	for i := range inbounds {
+		if inbounds[i].Enable && inbounds[i].Protocol == protocol {
+			return &inbounds[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no enabled %s inbound found", protocol)
+}
+
 // AddClient adds a new VLESS client to an inbound.
 func (c *Client) AddClient(inboundID int, clientUUID, email string) error {
-	if err := c.ensureLoggedIn(); err != nil {
-		return err
-	}
+	return c.AddProtocolClient(inboundID, ProtocolVLESS, clientUUID, email)
+}
 
+// AddProtocolClient adds a new client to an inbound of the given protocol.
+// clientID is the UUID for VLESS/VMess clients and the password for Trojan clients.
+func (c *Client) AddProtocolClient(inboundID int, protocol, clientID, email string) error {
 	client := InboundClient{
-		ID:    clientUUID,
-		Email: email,
-		Flow:  "xtls-rprx-vision",
+		Email:  email,
+		Enable: true,
+	}
+	switch protocol {
+	case ProtocolTrojan:
+		client.Password = clientID
+	case ProtocolVMess:
+		client.ID = clientID
+	default:
+		client.ID = clientID
+		client.Flow = "xtls-rprx-vision"
 	}
 	clientsJSON, _ := json.Marshal([]InboundClient{client})
 
@@ -156,11 +336,7 @@ func (c *Client) AddClient(inboundID int, clientUUID, email string) error {
 	}
 	data, _ := json.Marshal(payload)
 
-	resp, err := c.httpClient.Post(
-		fmt.Sprintf("%s/panel/api/inbounds/addClient", c.BaseURL),
-		"application/json",
-		bytes.NewBuffer(data),
-	)
+	resp, err := c.do("POST", "/panel/api/inbounds/addClient", data)
 	if err != nil {
 		return fmt.Errorf("add client request failed: %w", err)
 	}
@@ -169,22 +345,69 @@ func (c *Client) AddClient(inboundID int, clientUUID, email string) error {
 	return c.checkResponse(resp)
 }
 
-// RemoveClient removes a client from an inbound by UUID.
-func (c *Client) RemoveClient(inboundID int, clientUUID string) error {
-	if err := c.ensureLoggedIn(); err != nil {
+// UpdateClient replaces the settings of an existing client, identified by its UUID.
+// The full client must be passed: fields left at their zero value are reset on the panel.
+func (c *Client) UpdateClient(inboundID int, client InboundClient) error {
+	clientsJSON, _ := json.Marshal([]InboundClient{client})
+
+	payload := map[string]interface{}{
+		"id":       inboundID,
+		"settings": fmt.Sprintf(`{"clients":%s}`, string(clientsJSON)),
+	}
+	data, _ := json.Marshal(payload)
+
+	resp, err := c.do("POST", "/panel/api/inbounds/updateClient/"+client.Key(), data)
+	if err != nil {
+		return fmt.Errorf("update client request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return c.checkResponse(resp)
+}
+
+// SetClientEnabled enables or disables a client without deleting it, so the
+// UUID (and therefore the user's access URI) survives a plan expiry.
+func (c *Client) SetClientEnabled(inboundID int, clientUUID string, enabled bool) error {
+	client, err := c.FindClient(inboundID, clientUUID)
+	if err != nil {
 		return err
 	}
+	client.Enable = enabled
+	return c.UpdateClient(inboundID, *client)
+}
 
-	req, err := http.NewRequest(
-		"POST",
-		fmt.Sprintf("%s/panel/api/inbounds/%d/delClient/%s", c.BaseURL, inboundID, clientUUID),
-		nil,
-	)
+// SetClientLimits sets the traffic quota (bytes, 0 = unlimited) and expiry
+// time (0 = never) of a client, keeping its other settings.
+func (c *Client) SetClientLimits(inboundID int, clientUUID string, totalBytes int64, expiry time.Time) error {
+	client, err := c.FindClient(inboundID, clientUUID)
 	if err != nil {
 		return err
 	}
+	client.TotalGB = totalBytes
+	client.ExpiryTime = 0
+	if !expiry.IsZero() {
+		client.ExpiryTime = expiry.UnixMilli()
+	}
+	return c.UpdateClient(inboundID, *client)
+}
 
-	resp, err := c.httpClient.Do(req)
+// FindClient returns the current settings of a client by UUID (password for Trojan).
+func (c *Client) FindClient(inboundID int, clientUUID string) (*InboundClient, error) {
+	clients, err := c.GetClients(inboundID)
+	if err != nil {
+		return nil, err
+	}
+	for _, cl := range clients {
+		if cl.Key() == clientUUID {
+			return &cl, nil
+		}
+	}
+	return nil, fmt.Errorf("client %s not found in inbound %d", clientUUID, inboundID)
+}
+
+// RemoveClient removes a client from an inbound by UUID (password for Trojan).
+func (c *Client) RemoveClient(inboundID int, clientUUID string) error {
+	resp, err := c.do("POST", fmt.Sprintf("/panel/api/inbounds/%d/delClient/%s", inboundID, clientUUID), nil)
 	if err != nil {
 		return fmt.Errorf("remove client request failed: %w", err)
 	}
@@ -203,7 +426,7 @@ func (c *Client) GetClients(inboundID int) ([]InboundClient, error) {
 	var settings struct {
 		Clients []InboundClient `json:"clients"`
 	}
-	if err := json.Unmarshal(inbound.Settings, &settings); err != nil {
+	if err := decodeEmbeddedJSON(inbound.Settings, &settings); err != nil {
 		return nil, fmt.Errorf("failed to parse inbound settings: %w", err)
 	}
 	return settings.Clients, nil
@@ -212,9 +435,11 @@ func (c *Client) GetClients(inboundID int) ([]InboundClient, error) {
 // BuildVLESSURI constructs a vless:// URI from configuration.
 func BuildVLESSURI(cfg VLESSConfig) string {
 	params := url.Values{}
-	params.Set("type", "tcp")
+	params.Set("type", networkOrDefault(cfg.Network))
 	params.Set("security", cfg.Security)
-	params.Set("flow", cfg.Flow)
+	if cfg.Flow != "" {
+		params.Set("flow", cfg.Flow)
+	}
 
 	if cfg.Security == "reality" {
 		params.Set("sni", cfg.SNI)
@@ -0,0 +1,69 @@
+package xray
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// GetOnlineClients returns the emails of clients with active connections, across all inbounds.
+func (c *Client) GetOnlineClients() ([]string, error) {
+	resp, err := c.do("POST", "/panel/api/inbounds/onlines", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool     `json:"success"`
+		Msg     string   `json:"msg"`
+		Obj     []string `json:"obj"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if !result.Success {
+		return nil, fmt.Errorf("failed to get online clients: %s", result.Msg)
+	}
+	return result.Obj, nil
+}
+
+// GetClientIPs returns the source IPs the panel recorded for a client. The
+// panel only records IPs when its IP limit feature (Xray access log) is
+// enabled; otherwise the list is empty.
+func (c *Client) GetClientIPs(email string) ([]string, error) {
+	resp, err := c.do("POST", "/panel/api/inbounds/clientIps/"+url.PathEscape(email), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool            `json:"success"`
+		Msg     string          `json:"msg"`
+		Obj     json.RawMessage `json:"obj"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if !result.Success {
+		return nil, fmt.Errorf("failed to get IPs of client %s: %s", email, result.Msg)
+	}
+
+	// The IPs come as a JSON array, either directly or encoded in a string,
+	// and "No IP Record" if there are none. Newer panels append the time an IP
+	// was last seen: "1.2.3.4 (2024-01-02 15:04:05)".
+	var entries []string
+	if err := decodeEmbeddedJSON(result.Obj, &entries); err != nil {
+		return nil, nil
+	}
+	ips := make([]string, 0, len(entries))
+	for _, e := range entries {
+		ip, _, _ := strings.Cut(strings.TrimSpace(e), " ")
+		if ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, nil
+}
@@ -0,0 +1,120 @@
+package xray
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// errSessionExpired is returned by send when the panel rejects the session cookie.
+var errSessionExpired = errors.New("3x-ui session expired")
+
+// do performs an authenticated API request. It logs in on first use, re-logs in
+// once if the panel reports the session as expired, and retries transport
+// failures with exponential backoff.
+func (c *Client) do(method, path string, body []byte) (*http.Response, error) {
+	gen, err := c.session(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.sendWithRetry(method, path, body)
+	if errors.Is(err, errSessionExpired) {
+		if _, err := c.session(gen); err != nil {
+			return nil, err
+		}
+		resp, err = c.sendWithRetry(method, path, body)
+	}
+	if errors.Is(err, errSessionExpired) {
+		return nil, fmt.Errorf("%w: re-login did not help", err)
+	}
+	return resp, err
+}
+
+// session makes sure there is a valid session and returns its generation.
+// If staleGen matches the current generation, that session is known to be
+// expired and a new login is performed. Holding the lock during login makes
+// concurrent callers wait for a single refresh instead of racing.
+func (c *Client) session(staleGen int) (int, error) {
+	c.mu.Lock()
+	if c.loggedIn && c.sessionGen != staleGen {
+		gen := c.sessionGen
+		c.mu.Unlock()
+		return gen, nil
+	}
+	c.loggedIn = false
+	c.mu.Unlock()
+
+	c.loginMu.Lock()
+	defer c.loginMu.Unlock()
+
+	c.mu.Lock()
+	if c.loggedIn {
+		// Another goroutine refreshed the session while we waited.
+		gen := c.sessionGen
+		c.mu.Unlock()
+		return gen, nil
+	}
+	c.mu.Unlock()
+
+	if err := c.Login(); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sessionGen, nil
+}
+
+// sendWithRetry calls send, retrying transport errors up to MaxRetries times.
+func (c *Client) sendWithRetry(method, path string, body []byte) (*http.Response, error) {
+	backoff := c.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(method, path, body)
+		if err == nil || errors.Is(err, errSessionExpired) || attempt >= c.MaxRetries {
+			return resp, err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// send performs a single request and classifies responses that indicate an
+// expired session: 401/403, or a redirect to the login page.
+func (c *Client) send(method, path string, body []byte) (*http.Response, error) {
+	var req *http.Request
+	var err error
+	if body != nil {
+		req, err = http.NewRequest(method, c.BaseURL+path, bytes.NewReader(body))
+	} else {
+		req, err = http.NewRequest(method, c.BaseURL+path, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s failed: %w", method, path, err)
+	}
+
+	if path != "/login" && isSessionExpired(resp) {
+		resp.Body.Close()
+		return nil, errSessionExpired
+	}
+	return resp, nil
+}
+
+func isSessionExpired(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return true
+	}
+	// API endpoints never redirect on their own: a redirect means the panel is
+	// sending us to its login page.
+	return resp.StatusCode >= 300 && resp.StatusCode < 400
+}
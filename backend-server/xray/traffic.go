@@ -0,0 +1,79 @@
+package xray
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// ClientTraffic is the traffic accounting of a client, as kept by the panel.
+type ClientTraffic struct {
+	ID         int    `json:"id"`
+	InboundID  int    `json:"inboundId"`
+	Enable     bool   `json:"enable"`
+	Email      string `json:"email"`
+	Up         int64  `json:"up"`
+	Down       int64  `json:"down"`
+	ExpiryTime int64  `json:"expiryTime"`
+	Total      int64  `json:"total"` // Quota in bytes, 0 = unlimited
+}
+
+// Used returns the bytes transferred in both directions.
+func (t ClientTraffic) Used() int64 {
+	return t.Up + t.Down
+}
+
+// GetClientTraffic returns the traffic counters of a client by email.
+func (c *Client) GetClientTraffic(email string) (*ClientTraffic, error) {
+	resp, err := c.do("GET", "/panel/api/inbounds/getClientTraffics/"+url.PathEscape(email), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool           `json:"success"`
+		Msg     string         `json:"msg"`
+		Obj     *ClientTraffic `json:"obj"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if !result.Success || result.Obj == nil {
+		return nil, fmt.Errorf("failed to get traffic of client %s: %s", email, result.Msg)
+	}
+	return result.Obj, nil
+}
+
+// ResetClientTraffic zeroes the traffic counters of a client, which re-enables
+// it if it was disabled for exceeding its quota.
+func (c *Client) ResetClientTraffic(inboundID int, email string) error {
+	resp, err := c.do("POST", fmt.Sprintf("/panel/api/inbounds/%d/resetClientTraffic/%s", inboundID, url.PathEscape(email)), nil)
+	if err != nil {
+		return fmt.Errorf("reset client traffic request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return c.checkResponse(resp)
+}
+
+// SetClientQuota sets the traffic quota of a client in bytes (0 = unlimited),
+// keeping its other settings.
+func (c *Client) SetClientQuota(inboundID int, clientUUID string, totalBytes int64) error {
+	client, err := c.FindClient(inboundID, clientUUID)
+	if err != nil {
+		return err
+	}
+	client.TotalGB = totalBytes
+	return c.UpdateClient(inboundID, *client)
+}
+
+// GetInboundTraffic returns the traffic counters of all clients of an inbound
+// in one request.
+func (c *Client) GetInboundTraffic(inboundID int) ([]ClientTraffic, error) {
+	inbound, err := c.GetInbound(inboundID)
+	if err != nil {
+		return nil, err
+	}
+	return inbound.ClientStats, nil
+}
@@ -0,0 +1,83 @@
+package xray
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// BuildURI constructs the share URI for a client of the given inbound protocol.
+// For Trojan, cfg.UUID holds the client password.
+func BuildURI(protocol string, cfg VLESSConfig) string {
+	switch protocol {
+	case ProtocolVMess:
+		return BuildVMessURI(cfg)
+	case ProtocolTrojan:
+		return BuildTrojanURI(cfg)
+	default:
+		return BuildVLESSURI(cfg)
+	}
+}
+
+// BuildVMessURI constructs a vmess:// URI in the v2rayN format
+// (base64-encoded JSON).
+func BuildVMessURI(cfg VLESSConfig) string {
+	tlsMode := ""
+	if cfg.Security == "tls" {
+		tlsMode = "tls"
+	}
+	link := map[string]string{
+		"v":    "2",
+		"ps":   "DrFrakeVPN",
+		"add":  cfg.Host,
+		"port": strconv.Itoa(cfg.Port),
+		"id":   cfg.UUID,
+		"aid":  "0",
+		"scy":  "auto",
+		"net":  networkOrDefault(cfg.Network),
+		"type": "none",
+		"tls":  tlsMode,
+		"sni":  cfg.SNI,
+		"fp":   cfg.Fingerprint,
+	}
+	data, _ := json.Marshal(link)
+	return "vmess://" + base64.StdEncoding.EncodeToString(data)
+}
+
+// BuildTrojanURI constructs a trojan:// URI. cfg.UUID is the client password.
+func BuildTrojanURI(cfg VLESSConfig) string {
+	params := url.Values{}
+	params.Set("type", networkOrDefault(cfg.Network))
+	security := cfg.Security
+	if security == "" {
+		security = "tls"
+	}
+	params.Set("security", security)
+	if cfg.SNI != "" {
+		params.Set("sni", cfg.SNI)
+	}
+	if cfg.Fingerprint != "" {
+		params.Set("fp", cfg.Fingerprint)
+	}
+	if security == "reality" {
+		params.Set("pbk", cfg.PublicKey)
+		if cfg.ShortID != "" {
+			params.Set("sid", cfg.ShortID)
+		}
+		if cfg.SpiderX != "" {
+			params.Set("spx", cfg.SpiderX)
+		}
+	}
+
+	return fmt.Sprintf("trojan://%s@%s:%d?%s#DrFrakeVPN",
+		url.PathEscape(cfg.UUID), cfg.Host, cfg.Port, params.Encode())
+}
+
+func networkOrDefault(network string) string {
+	if network == "" {
+		return "tcp"
+	}
+	return network
+}
@@ -14,13 +14,19 @@ import (
 type XrayProvider struct {
 	client     *xray.Client
 	inboundID  int
+	protocol   string // Protocol of the inbound, detected from the panel
 	serverHost string // Public IP/hostname of the VPN server
 	settings   XrayServerSettings
+	resolved   bool
 }
 
 // XrayServerSettings holds server-specific VLESS+Reality parameters.
 type XrayServerSettings struct {
-	Port        int    `json:"port"`
+	// Protocol selects the inbound when no inbound ID is configured:
+	// "vless" (default), "vmess" or "trojan".
+	Protocol    string `json:"protocol"`
+	Network     string `json:"network"` // Detected from the inbound if empty
+	Port        int    `json:"port"`    // Detected from the inbound if 0
 	Flow        string `json:"flow"`
 	Security    string `json:"security"`    // "reality"
 	SNI         string `json:"sni"`         // e.g. "google.com"
@@ -30,8 +36,9 @@ type XrayServerSettings struct {
 	SpiderX     string `json:"spider_x"`
 }
 
-// NewXrayProvider creates a provider backed by a 3X-UI panel.
-func NewXrayProvider(panelURL, username, password string, inboundID int, serverHost string, settingsJSON string) *XrayProvider {
+// NewXrayProvider creates a provider backed by a 3X-UI panel. A certSHA256
+// pins the panel's certificate, see xray.Client.PinCertificate.
+func NewXrayProvider(panelURL, username, password, certSHA256 string, inboundID int, serverHost string, settingsJSON string) *XrayProvider {
 	var settings XrayServerSettings
 	if err := json.Unmarshal([]byte(settingsJSON), &settings); err != nil {
 		log.Printf("Warning: failed to parse xray settings: %v", err)
@@ -44,45 +51,170 @@ func NewXrayProvider(panelURL, username, password string, inboundID int, serverH
 		}
 	}
 
+	client := xray.NewClient(panelURL, username, password)
+	if certSHA256 != "" {
+		if err := client.PinCertificate(certSHA256); err != nil {
+			log.Printf("Warning: xray panel %s: %v", panelURL, err)
+		}
+	}
 	return &XrayProvider{
-		client:     xray.NewClient(panelURL, username, password),
+		client:     client,
 		inboundID:  inboundID,
 		serverHost: serverHost,
 		settings:   settings,
 	}
 }
 
+// resolveInbound selects the inbound to use and detects its protocol, network
+// and port. If no inbound ID is configured, the first enabled inbound with the
+// configured protocol is used.
+func (p *XrayProvider) resolveInbound() error {
+	if p.resolved {
+		return nil
+	}
+
+	var inbound *xray.InboundInfo
+	var err error
+	if p.inboundID == 0 {
+		protocol := p.settings.Protocol
+		if protocol == "" {
+			protocol = xray.ProtocolVLESS
+		}
+		inbound, err = p.client.FindInbound(protocol)
+	} else {
+		inbound, err = p.client.GetInbound(p.inboundID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to resolve xray inbound: %w", err)
+	}
+
+	p.inboundID = inbound.Id
+	p.protocol = inbound.Protocol
+	if p.settings.Port == 0 {
+		p.settings.Port = inbound.Port
+	}
+	if stream, err := inbound.ParseStreamSettings(); err == nil {
+		if p.settings.Network == "" {
+			p.settings.Network = stream.Network
+		}
+		if p.settings.Security == "" {
+			p.settings.Security = stream.Security
+		}
+	} else {
+		log.Printf("Warning: inbound %d: %v", inbound.Id, err)
+	}
+	p.resolved = true
+	return nil
+}
+
+// DiscoverSettings reads the inbound's stream settings from the panel and
+// returns the provider settings with the Reality/TLS client parameters
+// (public key, short ID, SNI, fingerprint), port and network filled in.
+// Settings that can't be discovered are kept as configured.
+func (p *XrayProvider) DiscoverSettings() (XrayServerSettings, error) {
+	if err := p.resolveInbound(); err != nil {
+		return p.settings, err
+	}
+	inbound, err := p.client.GetInbound(p.inboundID)
+	if err != nil {
+		return p.settings, err
+	}
+	stream, err := inbound.ParseStreamSettings()
+	if err != nil {
+		return p.settings, err
+	}
+
+	settings := p.settings
+	settings.Protocol = inbound.Protocol
+	settings.Port = inbound.Port
+	settings.Network = stream.Network
+	settings.Security = stream.Security
+	switch {
+	case stream.Security == "reality" && stream.RealitySettings != nil:
+		rs := stream.RealitySettings
+		if rs.Settings.PublicKey == "" {
+			return p.settings, fmt.Errorf("inbound %d has no reality public key", inbound.Id)
+		}
+		settings.PublicKey = rs.Settings.PublicKey
+		settings.SpiderX = rs.Settings.SpiderX
+		if rs.Settings.Fingerprint != "" {
+			settings.Fingerprint = rs.Settings.Fingerprint
+		}
+		settings.SNI = rs.Settings.ServerName
+		if settings.SNI == "" && len(rs.ServerNames) > 0 {
+			settings.SNI = rs.ServerNames[0]
+		}
+		settings.ShortID = ""
+		for _, sid := range rs.ShortIDs {
+			if sid != "" {
+				settings.ShortID = sid
+				break
+			}
+		}
+	case stream.Security == "tls" && stream.TLSSettings != nil:
+		settings.SNI = stream.TLSSettings.ServerName
+		if stream.TLSSettings.Settings.Fingerprint != "" {
+			settings.Fingerprint = stream.TLSSettings.Settings.Fingerprint
+		}
+		settings.PublicKey = ""
+		settings.ShortID = ""
+	}
+	if settings.Fingerprint == "" {
+		settings.Fingerprint = "chrome"
+	}
+	if inbound.Protocol != xray.ProtocolVLESS {
+		settings.Flow = ""
+	}
+
+	p.settings = settings
+	return settings, nil
+}
+
+// InboundID returns the inbound the provider is bound to, which may have been
+// selected by protocol.
+func (p *XrayProvider) InboundID() int {
+	return p.inboundID
+}
+
 func (p *XrayProvider) CreateKey(userID string) (string, string, error) {
+	if err := p.resolveInbound(); err != nil {
+		return "", "", err
+	}
 	email := fmt.Sprintf("user-%s", userID)
 
 	// Check if user already exists to prevent duplicates
 	clients, err := p.client.GetClients(p.inboundID)
 	if err == nil {
-		log.Printf("DEBUG: Found %d clients in inbound %d", len(clients), p.inboundID)
 		for _, c := range clients {
-			log.Printf("DEBUG: Client in Xray: ID=%s, Email=%s", c.ID, c.Email)
 			if c.Email == email {
 				log.Printf("User %s already exists in Xray, reusing key", userID)
-				return c.ID, p.buildVLESSURI(c.ID), nil
+				return c.Key(), p.buildURI(c.Key()), nil
 			}
 		}
 	} else {
 		log.Printf("Warning: failed to list clients: %v", err)
 	}
 
-	clientUUID := uuid.New().String()
-	if err := p.client.AddClient(p.inboundID, clientUUID, email); err != nil {
+	// Trojan uses the UUID as password, which is as good a secret as any.
+	clientID := uuid.New().String()
+	if err := p.client.AddProtocolClient(p.inboundID, p.protocol, clientID, email); err != nil {
 		return "", "", fmt.Errorf("failed to create xray client: %w", err)
 	}
 
-	return clientUUID, p.buildVLESSURI(clientUUID), nil
+	return clientID, p.buildURI(clientID), nil
 }
 
 func (p *XrayProvider) DeleteKey(keyID string) error {
+	if err := p.resolveInbound(); err != nil {
+		return err
+	}
 	return p.client.RemoveClient(p.inboundID, keyID)
 }
 
 func (p *XrayProvider) GetKeys() ([]VPNKey, error) {
+	if err := p.resolveInbound(); err != nil {
+		return nil, err
+	}
 	clients, err := p.client.GetClients(p.inboundID)
 	if err != nil {
 		return nil, err
@@ -91,9 +223,9 @@ func (p *XrayProvider) GetKeys() ([]VPNKey, error) {
 	var keys []VPNKey
 	for _, c := range clients {
 		keys = append(keys, VPNKey{
-			ID:        c.ID,
+			ID:        c.Key(),
 			Name:      c.Email,
-			AccessURL: p.buildVLESSURI(c.ID),
+			AccessURL: p.buildURI(c.Key()),
 		})
 	}
 	return keys, nil
@@ -105,11 +237,47 @@ func (p *XrayProvider) SetName(keyID string, name string) error {
 	return nil
 }
 
-func (p *XrayProvider) buildVLESSURI(uuid string) string {
-	return xray.BuildVLESSURI(xray.VLESSConfig{
-		UUID:        uuid,
+func (p *XrayProvider) SetDataLimit(keyID string, bytes int64) error {
+	if err := p.resolveInbound(); err != nil {
+		return err
+	}
+	return p.client.SetClientQuota(p.inboundID, keyID, bytes)
+}
+
+func (p *XrayProvider) GetUsage(keyID string) (int64, error) {
+	if err := p.resolveInbound(); err != nil {
+		return 0, err
+	}
+	// Traffic is accounted by email, not by UUID.
+	client, err := p.client.FindClient(p.inboundID, keyID)
+	if err != nil {
+		return 0, err
+	}
+	traffic, err := p.client.GetClientTraffic(client.Email)
+	if err != nil {
+		return 0, err
+	}
+	return traffic.Used(), nil
+}
+
+func (p *XrayProvider) ResetUsage(keyID string) error {
+	if err := p.resolveInbound(); err != nil {
+		return err
+	}
+	client, err := p.client.FindClient(p.inboundID, keyID)
+	if err != nil {
+		return err
+	}
+	return p.client.ResetClientTraffic(p.inboundID, client.Email)
+}
+
+// buildURI builds the share URI matching the inbound protocol.
+func (p *XrayProvider) buildURI(clientID string) string {
+	return xray.BuildURI(p.protocol, xray.VLESSConfig{
+		UUID:        clientID,
 		Host:        p.serverHost,
 		Port:        p.settings.Port,
+		Network:     p.settings.Network,
 		Flow:        p.settings.Flow,
 		Security:    p.settings.Security,
 		SNI:         p.settings.SNI,
@@ -119,3 +287,69 @@ func (p *XrayProvider) buildVLESSURI(uuid string) string {
 		SpiderX:     p.settings.SpiderX,
 	})
 }
+
+// GetConnections counts the source IPs of the inbound's online clients. Online
+// clients without recorded IPs, as when the panel's IP limit feature is off,
+// count as one.
+func (p *XrayProvider) GetConnections() (map[string]int, error) {
+	if err := p.resolveInbound(); err != nil {
+		return nil, err
+	}
+	online, err := p.client.GetOnlineClients()
+	if err != nil {
+		return nil, err
+	}
+	if len(online) == 0 {
+		return map[string]int{}, nil
+	}
+	clients, err := p.client.GetClients(p.inboundID)
+	if err != nil {
+		return nil, err
+	}
+	keyByEmail := make(map[string]string, len(clients))
+	for _, c := range clients {
+		keyByEmail[c.Email] = c.Key()
+	}
+
+	counts := make(map[string]int)
+	for _, email := range online {
+		keyID, ok := keyByEmail[email]
+		if !ok {
+			continue // Client of another inbound
+		}
+		ips, err := p.client.GetClientIPs(email)
+		if err != nil {
+			return nil, err
+		}
+		counts[keyID] = max(len(ips), 1)
+	}
+	return counts, nil
+}
+
+// GetTransfer returns the traffic counters of the inbound's clients, which
+// restart when a client's quota period is reset.
+func (p *XrayProvider) GetTransfer() (map[string]int64, error) {
+	if err := p.resolveInbound(); err != nil {
+		return nil, err
+	}
+	stats, err := p.client.GetInboundTraffic(p.inboundID)
+	if err != nil {
+		return nil, err
+	}
+	clients, err := p.client.GetClients(p.inboundID)
+	if err != nil {
+		return nil, err
+	}
+	keyByEmail := make(map[string]string, len(clients))
+	for _, c := range clients {
+		keyByEmail[c.Email] = c.Key()
+	}
+
+	transfer := make(map[string]int64, len(stats))
+	for _, t := range stats {
+		if keyID, ok := keyByEmail[t.Email]; ok {
+			transfer[keyID] = t.Used()
+		}
+	}
+	return transfer, nil
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -25,14 +27,33 @@ type Confirmation struct {
 type PaymentMetadata struct {
 	UserID string `json:"user_id"`
 	Tier   string `json:"tier"`
+	// Credit is the amount, in RUB, of a prorated upgrade from FromPlan
+	// taken off the price, see prorate. The plan paid for starts right away.
+	Credit   string `json:"credit,omitempty"`
+	FromPlan string `json:"from_plan,omitempty"`
+	// Region is the country whose price was charged, "" for the default
+	// price, see planPrice.
+	Region string `json:"region,omitempty"`
 }
 
 type PaymentRequest struct {
 	Amount       Amount          `json:"amount"`
 	Capture      bool            `json:"capture"`
-	Confirmation Confirmation    `json:"confirmation"`
+	Confirmation *Confirmation   `json:"confirmation,omitempty"`
 	Description  string          `json:"description"`
 	Metadata     PaymentMetadata `json:"metadata"`
+	// SavePaymentMethod asks the gateway to keep the card for recurring charges.
+	SavePaymentMethod bool `json:"save_payment_method,omitempty"`
+	// PaymentMethodID charges a saved method without the user's confirmation.
+	PaymentMethodID string `json:"payment_method_id,omitempty"`
+}
+
+// PaymentMethod is the method a payment was made with.
+type PaymentMethod struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Saved bool   `json:"saved"`
+	Title string `json:"title,omitempty"` // E.g. "Bank card *4444"
 }
 
 type PaymentResponse struct {
@@ -43,12 +64,41 @@ type PaymentResponse struct {
 	Confirmation Confirmation    `json:"confirmation"`
 	Description  string          `json:"description"`
 	Metadata     PaymentMetadata `json:"metadata"`
+	CreatedAt    time.Time       `json:"created_at"`
+	// PaymentMethod is set once the user paid. Saved methods can be charged
+	// again with ChargeSavedMethod.
+	PaymentMethod *PaymentMethod `json:"payment_method,omitempty"`
+	// CancellationDetails explains why a payment was canceled.
+	CancellationDetails *struct {
+		Party  string `json:"party"`
+		Reason string `json:"reason"`
+	} `json:"cancellation_details,omitempty"`
+}
+
+type RefundRequest struct {
+	PaymentID   string `json:"payment_id"`
+	Amount      Amount `json:"amount"`
+	Description string `json:"description,omitempty"`
+}
+
+type RefundResponse struct {
+	ID        string `json:"id"`
+	PaymentID string `json:"payment_id"`
+	Status    string `json:"status"`
+	Amount    Amount `json:"amount"`
 }
 
 type YooKassaClient struct {
 	ShopID    string
 	SecretKey string
 	BaseURL   string
+	// SavePaymentMethods asks to save the card of every payment, for
+	// auto-renewal. The shop must be enabled for recurring payments.
+	SavePaymentMethods bool
+	// Notifications is how YooKassa's notifications authenticate. YooKassa
+	// doesn't sign them, so this is basic auth in the notification URL or a
+	// signature added by a proxy.
+	Notifications NotificationAuth
 }
 
 func NewYooKassaClient(shopID, secretKey string) *YooKassaClient {
@@ -59,22 +109,20 @@ func NewYooKassaClient(shopID, secretKey string) *YooKassaClient {
 	}
 }
 
-func (c *YooKassaClient) CreatePayment(amount string, description string, userID string, tier string, returnURL string) (*PaymentResponse, error) {
+func (c *YooKassaClient) CreatePayment(amount string, description string, metadata PaymentMetadata, returnURL string) (*PaymentResponse, error) {
 	reqBody := PaymentRequest{
 		Amount: Amount{
 			Value:    amount,
 			Currency: "RUB",
 		},
 		Capture: true,
-		Confirmation: Confirmation{
+		Confirmation: &Confirmation{
 			Type:      "redirect",
 			ReturnURL: returnURL,
 		},
-		Description: description,
-		Metadata: PaymentMetadata{
-			UserID: userID,
-			Tier:   tier,
-		},
+		Description:       description,
+		Metadata:          metadata,
+		SavePaymentMethod: c.SavePaymentMethods,
 	}
 
 	jsonBody, err := json.Marshal(reqBody)
@@ -94,6 +142,32 @@ func (c *YooKassaClient) CreatePayment(amount string, description string, userID
 	return c.do(req)
 }
 
+// ChargeSavedMethod charges a saved payment method without the user's
+// confirmation. The payment usually completes right away but may stay pending
+// for a while; check it with GetPayment.
+func (c *YooKassaClient) ChargeSavedMethod(amount string, description string, userID string, tier string, methodID string) (*PaymentResponse, error) {
+	reqBody := PaymentRequest{
+		Amount:          Amount{Value: amount, Currency: "RUB"},
+		Capture:         true,
+		Description:     description,
+		Metadata:        PaymentMetadata{UserID: userID, Tier: tier},
+		PaymentMethodID: methodID,
+	}
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", c.BaseURL+"/payments", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, err
+	}
+
+	c.setHeaders(req, uuid.New().String())
+
+	return c.do(req)
+}
+
 func (c *YooKassaClient) GetPayment(paymentID string) (*PaymentResponse, error) {
 	req, err := http.NewRequest("GET", c.BaseURL+"/payments/"+paymentID, nil)
 	if err != nil {
@@ -105,6 +179,65 @@ func (c *YooKassaClient) GetPayment(paymentID string) (*PaymentResponse, error)
 	return c.do(req)
 }
 
+// ListPayments returns all payments created since the given time, following
+// the API's pagination cursor.
+func (c *YooKassaClient) ListPayments(since time.Time) ([]PaymentResponse, error) {
+	var payments []PaymentResponse
+	cursor := ""
+	for {
+		q := url.Values{}
+		q.Set("created_at.gte", since.UTC().Format(time.RFC3339))
+		q.Set("limit", "100")
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+		req, err := http.NewRequest("GET", c.BaseURL+"/payments?"+q.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		c.setHeaders(req, "")
+
+		var page struct {
+			Items      []PaymentResponse `json:"items"`
+			NextCursor string            `json:"next_cursor"`
+		}
+		if err := c.doInto(req, &page); err != nil {
+			return nil, err
+		}
+		payments = append(payments, page.Items...)
+		if page.NextCursor == "" {
+			return payments, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// CreateRefund refunds amount (e.g. "299.00") of a succeeded payment.
+func (c *YooKassaClient) CreateRefund(paymentID string, amount string, description string) (*RefundResponse, error) {
+	reqBody := RefundRequest{
+		PaymentID:   paymentID,
+		Amount:      Amount{Value: amount, Currency: "RUB"},
+		Description: description,
+	}
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", c.BaseURL+"/refunds", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, err
+	}
+
+	c.setHeaders(req, uuid.New().String())
+
+	var refund RefundResponse
+	if err := c.doInto(req, &refund); err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
 func (c *YooKassaClient) setHeaders(req *http.Request, idempotenceKey string) {
 	auth := base64.StdEncoding.EncodeToString([]byte(c.ShopID + ":" + c.SecretKey))
 	req.Header.Set("Authorization", "Basic "+auth)
@@ -115,6 +248,14 @@ func (c *YooKassaClient) setHeaders(req *http.Request, idempotenceKey string) {
 }
 
 func (c *YooKassaClient) do(req *http.Request) (*PaymentResponse, error) {
+	var paymentResp PaymentResponse
+	if err := c.doInto(req, &paymentResp); err != nil {
+		return nil, err
+	}
+	return &paymentResp, nil
+}
+
+func (c *YooKassaClient) doInto(req *http.Request, v interface{}) error {
 	// Use a client that bypasses system proxy to avoid
 	// "http: server gave HTTP response to HTTPS client" errors
 	// when the VPN app has set a local HTTP proxy
@@ -125,20 +266,23 @@ func (c *YooKassaClient) do(req *http.Request) (*PaymentResponse, error) {
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("yookassa api error: %s - %s", resp.Status, string(body))
+		return fmt.Errorf("yookassa api error: %s - %s", resp.Status, string(body))
 	}
 
-	var paymentResp PaymentResponse
-	if err := json.Unmarshal(body, &paymentResp); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
-	return &paymentResp, nil
+	return nil
+}
+
+func (c *YooKassaClient) VerifyNotification(r *http.Request, body []byte) error {
+	return c.Notifications.Verify(r, body)
 }
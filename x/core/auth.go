@@ -2,78 +2,687 @@ package core
 
 import (
 	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const (
+	// defaultRetries is how many times NewAuthClient's clients retry a
+	// request.
+	defaultRetries = 2
+	// retryDelay is the wait before the first retry, doubled for each next
+	// one.
+	retryDelay = 500 * time.Millisecond
+	// maxRetryDelay caps the wait before a retry, also when the backend asks
+	// for a longer one with Retry-After.
+	maxRetryDelay = 5 * time.Second
+)
+
+// AuthClient is a client of the backend API. Its methods take the session
+// token of the last Login.
 type AuthClient struct {
 	BaseURL string
 	Token   string
+	User    User // Of the last Login
+	// HTTPClient sends the requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+	// Retries is how many times requests that can safely be sent again are
+	// retried after network errors and 429, 502, 503 and 504 responses.
+	Retries int
+	// DeviceID identifies this device to the backend, which gives it keys of
+	// its own, see NewDeviceID. It must stay the same across restarts. Empty
+	// uses the keys of the account, shared by all devices without one.
+	DeviceID string
+	// DeviceName names the device when the backend first sees it.
+	DeviceName string
+}
+
+// NewDeviceID returns a random ID for AuthClient.DeviceID.
+func NewDeviceID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
 }
 
 func NewAuthClient(baseURL string) *AuthClient {
-	return &AuthClient{BaseURL: baseURL}
+	return &AuthClient{BaseURL: baseURL, Retries: defaultRetries}
+}
+
+// APIError is an error response of the backend. It comes as the JSON
+// envelope {"error": code, "message": message}, or as plain text from older
+// handlers, which leaves Code empty.
+type APIError struct {
+	Status  int
+	Code    string // E.g. "maintenance"
+	Message string
+	// RetryAfter is how long the backend asks to wait before trying again,
+	// 0 if it didn't.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return fmt.Sprintf("HTTP %d", e.Status)
+}
+
+// IsUnauthorized reports whether err is the backend rejecting the session,
+// which takes logging in again.
+func IsUnauthorized(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized
+}
+
+type User struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	Plan  string `json:"plan"`
 }
 
 type AuthResponse struct {
 	Token string `json:"token"`
-	User  struct {
-		ID    string `json:"id"`
-		Email string `json:"email"`
-		Plan  string `json:"plan"`
-	} `json:"user"`
+	User  User   `json:"user"`
+}
+
+// Account is the profile of the logged in user.
+type Account struct {
+	ID         string     `json:"id"`
+	Email      string     `json:"email"`
+	Plan       string     `json:"plan"`
+	ExpiryDate *time.Time `json:"expiry_date,omitempty"`
+	// PendingEmail is the address of an email change until it is confirmed.
+	PendingEmail string `json:"pending_email,omitempty"`
+	// PausedUntil is set while the user's plan is paused.
+	PausedUntil *time.Time `json:"paused_until,omitempty"`
+}
+
+// ServerInfo is a server the backend lists for the user. Locked servers need
+// a plan the user doesn't have and come without a config.
+type ServerInfo struct {
+	ID        string   `json:"id"`
+	Country   string   `json:"country"`
+	City      string   `json:"city"`
+	Flag      string   `json:"flag"`
+	Config    string   `json:"config"`              // Access key, e.g. ss://
+	Fallbacks []string `json:"fallbacks,omitempty"` // Other configs of the server
+	IsPremium bool     `json:"isPremium"`
+	Locked    bool     `json:"locked"`
+	Type      string   `json:"type"` // "outline" or "xray"
+	// Tags say what the server is good for, e.g. "streaming" or "p2p".
+	Tags []string `json:"tags,omitempty"`
+	// Device is the device the key belongs to, nil for the account's keys.
+	Device *Device `json:"device,omitempty"`
+}
+
+// Device is a device of the user with keys of its own. LastSeenAt, Keys and
+// Current only come with Devices.
+type Device struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at,omitzero"`
+	Keys       int       `json:"keys,omitempty"` // Servers the device has a key on
+	Current    bool      `json:"current,omitempty"`
+}
+
+// Family is the family plan the user owns or shares. Owner is set for
+// members; Invites only for owners.
+type Family struct {
+	Seats   int            `json:"seats"` // The owner's included
+	Owner   string         `json:"owner,omitempty"`
+	Members []FamilyMember `json:"members"`
+	Invites []FamilyInvite `json:"invites"`
+}
+
+// FamilyMember is an account sharing a family plan. ID identifies the
+// membership, not the account.
+type FamilyMember struct {
+	ID       string    `json:"id"`
+	Email    string    `json:"email"`
+	JoinedAt time.Time `json:"joined_at"`
+	Current  bool      `json:"current"` // The user
+}
+
+// FamilyInvite is an invitation to a family plan not accepted yet.
+type FamilyInvite struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Payment is a payment the user confirms in the browser at ConfirmationURL.
+type Payment struct {
+	ID              string `json:"id"`
+	Status          string `json:"status"`
+	ConfirmationURL string `json:"confirmation_url"`
+	// Plan, Amount and CreatedAt come with a PendingPayment. Amount is what
+	// is charged, after the credit of an upgrade from a shorter plan.
+	Plan      string    `json:"plan,omitempty"`
+	Amount    string    `json:"amount,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+	// Region is the country whose price is charged, empty for the default
+	// price.
+	Region string `json:"region,omitempty"`
+	// EffectiveAt is when a switch to a shorter plan, with status
+	// PaymentScheduled, takes effect.
+	EffectiveAt *time.Time `json:"effective_at,omitempty"`
+}
+
+// Statuses of a Payment, as the payment provider reports them.
+const (
+	PaymentPending   = "pending"
+	PaymentSucceeded = "succeeded"
+	PaymentCanceled  = "canceled"
+	// PaymentScheduled is the status of a switch to a shorter plan, which
+	// takes effect, and is charged, when the current plan ends.
+	PaymentScheduled = "scheduled"
+)
+
+// PaymentCheck is the status of a payment.
+type PaymentCheck struct {
+	Status string `json:"status"`
+	Plan   string `json:"plan"`
+}
+
+// PaymentRecord is a past payment of the user.
+type PaymentRecord struct {
+	ID        string    `json:"id"`
+	Plan      string    `json:"plan"`
+	Amount    float64   `json:"amount"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	// Description, Region and InvoiceURL come with the PaymentHistory.
+	// InvoiceURL is set for completed payments, see Invoice.
+	Description string `json:"description,omitempty"`
+	Region      string `json:"region,omitempty"`
+	InvoiceURL  string `json:"invoice_url,omitempty"`
+}
+
+// Invoice formats.
+const (
+	InvoiceHTML = "html"
+	InvoicePDF  = "pdf"
+)
+
+// Subscription is the state of the user's plan and its renewals.
+type Subscription struct {
+	Plan            string     `json:"plan"`
+	Status          string     `json:"status"`
+	ExpiryDate      *time.Time `json:"expiry_date,omitempty"`
+	GraceUntil      *time.Time `json:"grace_until,omitempty"`
+	AutoRenew       bool       `json:"auto_renew"`
+	PaymentMethod   string     `json:"payment_method,omitempty"` // E.g. "Bank card *4242"
+	Price           string     `json:"price,omitempty"`          // Of a renewal, in RUB
+	RenewalFailures int        `json:"renewal_failures,omitempty"`
+	NextPlan        string     `json:"next_plan,omitempty"`    // Scheduled at ExpiryDate
+	PausedUntil     *time.Time `json:"paused_until,omitempty"` // Set while Status is "paused"
+	PauseDaysLeft   int        `json:"pause_days_left"`
+	FamilyOwner     string     `json:"family_owner,omitempty"` // Who pays for the family plan the user shares
+}
+
+// Usage is how much of their data cap the user has used, in total and on
+// each server.
+type Usage struct {
+	Plan           string     `json:"plan"`
+	LimitBytes     int64      `json:"limit_bytes"` // Per user and period, 0 for unlimited
+	UsedBytes      int64      `json:"used_bytes"`
+	RemainingBytes *int64     `json:"remaining_bytes,omitempty"` // Nil for unlimited plans
+	ResetAt        *time.Time `json:"reset_at,omitempty"`
+	Servers        []KeyUsage `json:"servers"`
 }
 
-func (c *AuthClient) Login(email, password string) error {
+// KeyUsage is the usage of the user's key on one server.
+type KeyUsage struct {
+	ServerID       string `json:"server_id"`
+	Country        string `json:"country"`
+	City           string `json:"city"`
+	UsedBytes      int64  `json:"used_bytes"`
+	RemainingBytes *int64 `json:"remaining_bytes,omitempty"` // Shared by all keys, nil for unlimited plans
+	Error          string `json:"error,omitempty"`
+}
+
+// Register creates an account and returns its ID. It doesn't log in.
+func (c *AuthClient) Register(ctx context.Context, email, password string) (string, error) {
+	var resp struct {
+		ID string `json:"id"`
+	}
 	payload := map[string]string{"email": email, "password": password}
-	data, _ := json.Marshal(payload)
+	if err := c.do(ctx, "POST", "/register", payload, &resp); err != nil {
+		return "", fmt.Errorf("registration failed: %w", err)
+	}
+	return resp.ID, nil
+}
 
-	resp, err := http.Post(c.BaseURL+"/login", "application/json", bytes.NewBuffer(data))
+// SignUp is Register for bindings, which can't pass a context.
+func (c *AuthClient) SignUp(email, password string) error {
+	_, err := c.Register(context.Background(), email, password)
+	return err
+}
+
+// Login logs in, keeping the token and the user.
+func (c *AuthClient) Login(ctx context.Context, email, password string) (*AuthResponse, error) {
+	var authResp AuthResponse
+	payload := map[string]string{"email": email, "password": password}
+	if err := c.do(ctx, "POST", "/login", payload, &authResp); err != nil {
+		return nil, fmt.Errorf("login failed: %w", err)
+	}
+	c.Token = authResp.Token
+	c.User = authResp.User
+	return &authResp, nil
+}
+
+// SignIn is Login for bindings, which can't pass a context.
+func (c *AuthClient) SignIn(email, password string) error {
+	_, err := c.Login(context.Background(), email, password)
+	return err
+}
+
+// Logout ends the session on the backend and forgets the token.
+func (c *AuthClient) Logout(ctx context.Context) error {
+	err := c.do(ctx, "POST", "/logout", nil, nil)
+	c.Token = ""
+	c.User = User{}
 	if err != nil {
-		return err
+		return fmt.Errorf("logout failed: %w", err)
+	}
+	return nil
+}
+
+// SignOut is Logout for bindings, which can't pass a context.
+func (c *AuthClient) SignOut() error {
+	return c.Logout(context.Background())
+}
+
+// Me fetches the profile of the logged in user, with the plan as it is now.
+func (c *AuthClient) Me(ctx context.Context) (*Account, error) {
+	var account Account
+	if err := c.do(ctx, "GET", "/account", nil, &account); err != nil {
+		return nil, fmt.Errorf("failed to fetch account: %w", err)
 	}
-	defer resp.Body.Close()
+	return &account, nil
+}
+
+// GetAccount is Me for bindings, which can't pass a context.
+func (c *AuthClient) GetAccount() (*Account, error) {
+	return c.Me(context.Background())
+}
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("login failed: %s", resp.Status)
+// ChangeEmail has the backend send a confirmation link to email. The email
+// only changes once the link is opened.
+func (c *AuthClient) ChangeEmail(ctx context.Context, email, password string) error {
+	payload := map[string]string{"email": email, "password": password}
+	if err := c.do(ctx, "PUT", "/account/email", payload, nil); err != nil {
+		return fmt.Errorf("email change failed: %w", err)
 	}
+	return nil
+}
 
-	var authResp AuthResponse
-	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
-		return err
+// Servers fetches the servers the backend lists for the user, with the
+// access key of those the plan covers.
+func (c *AuthClient) Servers(ctx context.Context) ([]ServerInfo, error) {
+	var servers []ServerInfo
+	if err := c.do(ctx, "GET", "/servers", nil, &servers); err != nil {
+		return nil, fmt.Errorf("failed to fetch servers: %w", err)
 	}
+	return servers, nil
+}
 
-	c.Token = authResp.Token
+// GetServers is Servers for bindings, which can't pass a context or bind
+// slices: it returns the access keys of the servers the user can connect to,
+// one per line, as VPNClient.Start takes them to fail over between them.
+func (c *AuthClient) GetServers() (string, error) {
+	servers, err := c.Servers(context.Background())
+	if err != nil {
+		return "", err
+	}
+	var configs []string
+	for _, s := range servers {
+		if !s.Locked {
+			configs = append(configs, s.Config)
+		}
+	}
+	return strings.Join(configs, "\n"), nil
+}
+
+// ServersJSON is Servers for bindings, with the list as JSON.
+func (c *AuthClient) ServersJSON() (string, error) {
+	servers, err := c.Servers(context.Background())
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(servers)
+	return string(data), err
+}
+
+// Devices fetches the user's devices with keys of their own, most recently
+// used first.
+func (c *AuthClient) Devices(ctx context.Context) ([]Device, error) {
+	var devices []Device
+	if err := c.do(ctx, "GET", "/devices", nil, &devices); err != nil {
+		return nil, fmt.Errorf("failed to fetch devices: %w", err)
+	}
+	return devices, nil
+}
+
+// RenameDevice changes the name of the user's device with id.
+func (c *AuthClient) RenameDevice(ctx context.Context, id, name string) error {
+	if err := c.do(ctx, "PUT", "/devices/"+url.PathEscape(id), map[string]string{"name": name}, nil); err != nil {
+		return fmt.Errorf("device rename failed: %w", err)
+	}
+	return nil
+}
+
+// RevokeDevice deletes the keys of the user's device with id and logs it out.
+// Revoking this device takes logging in again.
+func (c *AuthClient) RevokeDevice(ctx context.Context, id string) error {
+	if err := c.do(ctx, "DELETE", "/devices/"+url.PathEscape(id), nil, nil); err != nil {
+		return fmt.Errorf("device revoke failed: %w", err)
+	}
 	return nil
 }
 
-func (c *AuthClient) GetServers() ([]string, error) {
-	req, _ := http.NewRequest("GET", c.BaseURL+"/servers", nil)
-	req.Header.Set("Authorization", c.Token)
+// InitPayment starts a payment for plan, "monthly", "yearly" or "family".
+// The backend hands out the pending payment again if there is one for plan.
+// Switching to a shorter plan than the current one isn't paid now: the
+// payment has status PaymentScheduled.
+func (c *AuthClient) InitPayment(ctx context.Context, plan string) (*Payment, error) {
+	var payment Payment
+	if err := c.do(ctx, "POST", "/payment/init", map[string]string{"plan": plan}, &payment); err != nil {
+		return nil, fmt.Errorf("payment init failed: %w", err)
+	}
+	return &payment, nil
+}
+
+// StartPayment is InitPayment for bindings, which can't pass a context.
+func (c *AuthClient) StartPayment(plan string) (*Payment, error) {
+	return c.InitPayment(context.Background(), plan)
+}
+
+// CheckPayment returns the status of the payment with id. Once it
+// succeeded, the backend has upgraded the user.
+func (c *AuthClient) CheckPayment(ctx context.Context, id string) (*PaymentCheck, error) {
+	var check PaymentCheck
+	if err := c.do(ctx, "GET", "/payment/check?id="+url.QueryEscape(id), nil, &check); err != nil {
+		return nil, fmt.Errorf("payment check failed: %w", err)
+	}
+	return &check, nil
+}
+
+// PaymentStatus is CheckPayment for bindings, which can't pass a context.
+func (c *AuthClient) PaymentStatus(id string) (*PaymentCheck, error) {
+	return c.CheckPayment(context.Background(), id)
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// PendingPayment returns the unpaid payment of the user for plan, or for any
+// plan if plan is empty, so it can be resumed. It is nil if there is none.
+func (c *AuthClient) PendingPayment(ctx context.Context, plan string) (*Payment, error) {
+	var payment Payment
+	err := c.do(ctx, "GET", "/payment/pending?plan="+url.QueryEscape(plan), nil, &payment)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+		return nil, nil
+	}
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to fetch pending payment: %w", err)
 	}
-	defer resp.Body.Close()
+	return &payment, nil
+}
+
+// Payments fetches the last payments of the user, newest first.
+func (c *AuthClient) Payments(ctx context.Context) ([]PaymentRecord, error) {
+	var payments []PaymentRecord
+	if err := c.do(ctx, "GET", "/account/payments", nil, &payments); err != nil {
+		return nil, fmt.Errorf("failed to fetch payments: %w", err)
+	}
+	return payments, nil
+}
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("failed to fetch servers: %s", resp.Status)
+// PaymentHistory fetches all payments of the user, newest first.
+func (c *AuthClient) PaymentHistory(ctx context.Context) ([]PaymentRecord, error) {
+	var payments []PaymentRecord
+	if err := c.do(ctx, "GET", "/payments", nil, &payments); err != nil {
+		return nil, fmt.Errorf("failed to fetch payment history: %w", err)
 	}
+	return payments, nil
+}
 
-	// Assuming servers returns a list of objects with "config" field
-	var serverList []struct {
-		Config string `json:"config"`
+// Invoice downloads the invoice of a completed payment as an HTML document or
+// a PDF, format InvoiceHTML or InvoicePDF.
+func (c *AuthClient) Invoice(ctx context.Context, paymentID, format string) ([]byte, error) {
+	var doc []byte
+	path := "/payments/" + url.PathEscape(paymentID) + "/invoice?format=" + url.QueryEscape(format)
+	if err := c.do(ctx, "GET", path, nil, &doc); err != nil {
+		return nil, fmt.Errorf("failed to download invoice: %w", err)
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&serverList); err != nil {
-		return nil, err
+	return doc, nil
+}
+
+// Subscription fetches the state of the user's plan.
+func (c *AuthClient) Subscription(ctx context.Context) (*Subscription, error) {
+	var sub Subscription
+	if err := c.do(ctx, "GET", "/account/subscription", nil, &sub); err != nil {
+		return nil, fmt.Errorf("failed to fetch subscription: %w", err)
 	}
+	return &sub, nil
+}
 
-	var configs []string
-	for _, s := range serverList {
-		configs = append(configs, s.Config)
+// SetAutoRenew turns renewals on or off. The backend only turns them on once
+// a payment saved a payment method.
+func (c *AuthClient) SetAutoRenew(ctx context.Context, enabled bool) (*Subscription, error) {
+	var sub Subscription
+	if err := c.do(ctx, "PUT", "/account/subscription/auto-renew", map[string]bool{"enabled": enabled}, &sub); err != nil {
+		return nil, fmt.Errorf("auto-renew change failed: %w", err)
+	}
+	return &sub, nil
+}
+
+// CancelNextPlan cancels a scheduled switch to another plan.
+func (c *AuthClient) CancelNextPlan(ctx context.Context) (*Subscription, error) {
+	var sub Subscription
+	if err := c.do(ctx, "DELETE", "/account/subscription/next-plan", nil, &sub); err != nil {
+		return nil, fmt.Errorf("plan switch cancel failed: %w", err)
+	}
+	return &sub, nil
+}
+
+// PauseSubscription pauses the user's paid plan for days days. Their keys
+// stop working until it resumes, and the expiry moves out by as much.
+func (c *AuthClient) PauseSubscription(ctx context.Context, days int) (*Subscription, error) {
+	var sub Subscription
+	if err := c.do(ctx, "POST", "/account/subscription/pause", map[string]int{"days": days}, &sub); err != nil {
+		return nil, fmt.Errorf("subscription pause failed: %w", err)
+	}
+	return &sub, nil
+}
+
+// ResumeSubscription ends a pause early. Days of it not started are given back.
+func (c *AuthClient) ResumeSubscription(ctx context.Context) (*Subscription, error) {
+	var sub Subscription
+	if err := c.do(ctx, "POST", "/account/subscription/resume", nil, &sub); err != nil {
+		return nil, fmt.Errorf("subscription resume failed: %w", err)
+	}
+	return &sub, nil
+}
+
+// Family fetches the family plan the user owns or shares.
+func (c *AuthClient) Family(ctx context.Context) (*Family, error) {
+	var f Family
+	if err := c.do(ctx, "GET", "/family", nil, &f); err != nil {
+		return nil, fmt.Errorf("failed to fetch family: %w", err)
+	}
+	return &f, nil
+}
+
+// InviteToFamily emails email an invitation code to the user's family plan.
+func (c *AuthClient) InviteToFamily(ctx context.Context, email string) (*FamilyInvite, error) {
+	var inv FamilyInvite
+	if err := c.do(ctx, "POST", "/family/invite", map[string]string{"email": email}, &inv); err != nil {
+		return nil, fmt.Errorf("family invitation failed: %w", err)
+	}
+	return &inv, nil
+}
+
+// AcceptFamilyInvite joins the family plan of an invitation code sent to the
+// user's email.
+func (c *AuthClient) AcceptFamilyInvite(ctx context.Context, token string) (*Family, error) {
+	var f Family
+	if err := c.do(ctx, "POST", "/family/accept", map[string]string{"token": token}, &f); err != nil {
+		return nil, fmt.Errorf("joining family failed: %w", err)
+	}
+	return &f, nil
+}
+
+// CancelFamilyInvite withdraws an invitation, freeing its seat.
+func (c *AuthClient) CancelFamilyInvite(ctx context.Context, id string) (*Family, error) {
+	var f Family
+	if err := c.do(ctx, "DELETE", "/family/invites/"+url.PathEscape(id), nil, &f); err != nil {
+		return nil, fmt.Errorf("invitation cancel failed: %w", err)
+	}
+	return &f, nil
+}
+
+// RemoveFamilyMember removes a member, by its member ID, from the user's
+// family plan. With the user's own user or member ID, it leaves the family
+// they are in.
+func (c *AuthClient) RemoveFamilyMember(ctx context.Context, id string) (*Family, error) {
+	var f Family
+	if err := c.do(ctx, "DELETE", "/family/members/"+url.PathEscape(id), nil, &f); err != nil {
+		return nil, fmt.Errorf("family member removal failed: %w", err)
+	}
+	return &f, nil
+}
+
+// Usage fetches how much data the user used on each server.
+func (c *AuthClient) Usage(ctx context.Context) (*Usage, error) {
+	var usage Usage
+	if err := c.do(ctx, "GET", "/usage", nil, &usage); err != nil {
+		return nil, fmt.Errorf("failed to fetch usage: %w", err)
+	}
+	return &usage, nil
+}
+
+// UsageJSON is Usage for bindings, with the usage as JSON.
+func (c *AuthClient) UsageJSON() (string, error) {
+	usage, err := c.Usage(context.Background())
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(usage)
+	return string(data), err
+}
+
+// do sends a request with the session token and decodes the JSON response
+// into v, unless v is nil; a *[]byte v gets the response body as it is.
+// Requests that can safely be sent again, all but POSTs, are retried.
+func (c *AuthClient) do(ctx context.Context, method, path string, body any, v any) error {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	retries := 0
+	if method != "POST" {
+		retries = c.Retries
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", c.Token)
+		if c.DeviceID != "" {
+			req.Header.Set("X-Device-ID", c.DeviceID)
+			req.Header.Set("X-Device-Name", c.DeviceName)
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		delay := retryDelay << attempt
+		resp, err := client.Do(req)
+		if err == nil {
+			if resp.StatusCode/100 == 2 {
+				defer resp.Body.Close()
+				if v == nil {
+					return nil
+				}
+				if raw, ok := v.(*[]byte); ok {
+					*raw, err = io.ReadAll(resp.Body)
+					return err
+				}
+				return json.NewDecoder(resp.Body).Decode(v)
+			}
+			apiErr := responseError(resp)
+			resp.Body.Close()
+			err = apiErr
+			if !retryableStatus(apiErr.Status) {
+				return err
+			}
+			if apiErr.RetryAfter > 0 {
+				delay = apiErr.RetryAfter
+			}
+		}
+		if attempt >= retries || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(min(delay, maxRetryDelay)):
+		}
+	}
+}
+
+// retryableStatus reports whether a response with status may go away if the
+// request is sent again.
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// responseError reads the error of a failed response: the JSON envelope, or
+// else the plain text message, or else the status.
+func responseError(resp *http.Response) *APIError {
+	apiErr := &APIError{Status: resp.StatusCode}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/json" {
+		var envelope struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &envelope) == nil && (envelope.Error != "" || envelope.Message != "") {
+			apiErr.Code = envelope.Error
+			apiErr.Message = cmp.Or(envelope.Message, envelope.Error)
+			return apiErr
+		}
+	}
+	apiErr.Message = strings.TrimSpace(string(body))
+	if apiErr.Message == "" {
+		apiErr.Message = resp.Status
 	}
-	return configs, nil
+	return apiErr
 }
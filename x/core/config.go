@@ -0,0 +1,432 @@
+package core
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.getoutline.org/sdk/x/configurl"
+)
+
+// ConfigInfo describes a config checked by ValidateConfig.
+type ConfigInfo struct {
+	// Normalized is the config in a canonical form, without its name, so
+	// configs that connect the same way are equal. It connects like the
+	// original.
+	Normalized string `json:"normalized"`
+	// Name is the name share links carry after #, if any.
+	Name string `json:"name,omitempty"`
+	// Protocol is that of the server: "ss", "socks5", "vless", "vmess" or
+	// "trojan". It is empty for configs that connect directly, such as
+	// "tlsfrag:1" or "smart".
+	Protocol string `json:"protocol,omitempty"`
+	// Host and Port are those of the server. In a chain it is the last proxy,
+	// through which traffic leaves.
+	Host string `json:"host,omitempty"`
+	Port int    `json:"port,omitempty"`
+	// Chain is the scheme of every part of a configurl config, in order and
+	// separated by "|", such as "tlsfrag|ss".
+	Chain string `json:"chain,omitempty"`
+	// Network is the transport of xray-core configs, such as "tcp", "ws" or
+	// "grpc".
+	Network string `json:"network,omitempty"`
+	// Security is "tls" or "reality" if the traffic is wrapped in TLS, and
+	// "none" otherwise.
+	Security string `json:"security"`
+	// SNI is the server name sent in the TLS handshake, if set.
+	SNI string `json:"sni,omitempty"`
+	// Cipher is the Shadowsocks cipher, or the VMess security.
+	Cipher string `json:"cipher,omitempty"`
+	// Xray is whether the config needs xray-core, which this package doesn't
+	// dial: VLESS, VMess, Trojan, and Shadowsocks with the 2022 ciphers.
+	Xray bool `json:"xray"`
+	// Smart is whether the config is of the smart mode. The other fields are
+	// those of its fallback.
+	Smart bool `json:"smart"`
+}
+
+// ConfigError is why ValidateConfig rejected a config. It names the part and
+// field at fault but never their values, which can be secrets.
+type ConfigError struct {
+	Scheme string // Of the part at fault, empty for the config as a whole
+	Field  string // Such as "port" or "cipher", empty if not one field
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	msg := "invalid config"
+	if e.Scheme != "" {
+		msg = "invalid " + e.Scheme + " config"
+	}
+	if e.Field != "" {
+		msg += ": " + e.Field
+	}
+	return msg + ": " + e.Reason
+}
+
+// Shadowsocks ciphers, by those configurl dials and those only xray-core
+// has, with the size of their keys.
+var (
+	ssCiphers = map[string]bool{
+		"chacha20-ietf-poly1305": true,
+		"aes-128-gcm":            true,
+		"aes-192-gcm":            true,
+		"aes-256-gcm":            true,
+	}
+	ss2022Ciphers = map[string]int{
+		"2022-blake3-aes-128-gcm":       16,
+		"2022-blake3-aes-256-gcm":       32,
+		"2022-blake3-chacha20-poly1305": 32,
+	}
+)
+
+// Values xray-core takes in share links.
+var (
+	xrayNetworks   = []string{"", "tcp", "raw", "ws", "grpc", "http", "h2", "httpupgrade", "xhttp", "splithttp", "kcp", "quic"}
+	xraySecurities = []string{"", "none", "tls", "reality"}
+	vlessFlows     = []string{"", "xtls-rprx-vision", "xtls-rprx-vision-udp443"}
+)
+
+// ValidateConfig checks config before it is connected with, or stored, and
+// describes it. It takes the ss://, socks5:// and chained configs of
+// configurl, the vless://, vmess:// and trojan:// share links of xray-core,
+// and the smart mode. Failover lists are checked one config at a time. The
+// error is a *ConfigError.
+func ValidateConfig(config string) (*ConfigInfo, error) {
+	config = strings.TrimSpace(config)
+	if config == "" {
+		return nil, &ConfigError{Reason: "empty"}
+	}
+	if strings.ContainsAny(config, "\r\n") {
+		return nil, &ConfigError{Reason: "several configs, check them one at a time"}
+	}
+	if fallback, ok := smartFallback(config); ok {
+		if fallback == "" {
+			return &ConfigInfo{Normalized: smartPrefix, Security: "none", Smart: true}, nil
+		}
+		info, err := ValidateConfig(fallback)
+		if err != nil {
+			return nil, err
+		}
+		if info.Xray {
+			return nil, &ConfigError{Scheme: info.Protocol, Reason: "xray-core configs can't be the fallback of the smart mode"}
+		}
+		info.Normalized = smartPrefix + ":" + info.Normalized
+		info.Smart = true
+		return info, nil
+	}
+
+	scheme, _, _ := strings.Cut(config, ":")
+	switch strings.ToLower(scheme) {
+	case "vless", "trojan":
+		return validateXrayLink(config)
+	case "vmess":
+		return validateVMessLink(config)
+	}
+	return validateConfigURL(config)
+}
+
+// validateConfigURL checks a configurl config, or a Shadowsocks 2022 one.
+func validateConfigURL(config string) (*ConfigInfo, error) {
+	info := &ConfigInfo{Security: "none"}
+	parts := strings.Split(config, "|")
+	var normalized, chain []string
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, &ConfigError{Reason: "empty part in the chain"}
+		}
+		// Like configurl, "<scheme>" alone is "<scheme>:".
+		if !strings.Contains(part, ":") {
+			part += ":"
+		}
+		u, err := url.Parse(part)
+		if err != nil || u.Scheme == "" {
+			return nil, &ConfigError{Reason: "a part of the chain isn't a URL"}
+		}
+		scheme := strings.ToLower(u.Scheme)
+		u.Scheme = scheme
+		if u.Fragment != "" {
+			info.Name = u.Fragment
+			u.Fragment, u.RawFragment = "", ""
+		}
+		switch scheme {
+		case "vless", "vmess", "trojan":
+			return nil, &ConfigError{Scheme: scheme, Reason: "xray-core configs can't be chained"}
+		case "ss":
+			if err := normalizeShadowsocks(u, info); err != nil {
+				return nil, err
+			}
+		case "socks5":
+			if err := checkServerAddr(scheme, u.Host, info); err != nil {
+				return nil, err
+			}
+			info.Protocol = scheme
+			u.Host = strings.ToLower(u.Host)
+		case "tls":
+			info.Security = "tls"
+			if q, err := url.ParseQuery(u.Opaque); err == nil && q.Get("sni") != "" {
+				info.SNI = q.Get("sni")
+			}
+		}
+		if u.RawQuery != "" {
+			u.RawQuery = u.Query().Encode()
+		}
+		normalized = append(normalized, u.String())
+		chain = append(chain, scheme)
+	}
+	info.Normalized = strings.Join(normalized, "|")
+	info.Chain = strings.Join(chain, "|")
+	if info.Xray {
+		if len(parts) > 1 {
+			return nil, &ConfigError{Scheme: "ss", Field: "cipher", Reason: "2022 ciphers need xray-core, so they can't be chained"}
+		}
+		return info, nil
+	}
+	// Whatever else is wrong, configurl knows. Creating the dialer doesn't
+	// connect.
+	if _, err := configurl.NewDefaultProviders().NewStreamDialer(context.Background(), info.Normalized); err != nil {
+		reason := err.Error()
+		for _, part := range append(parts, normalized...) {
+			reason = strings.ReplaceAll(reason, strings.TrimSpace(part), "…")
+		}
+		return nil, &ConfigError{Reason: reason}
+	}
+	return info, nil
+}
+
+// normalizeShadowsocks checks the ss:// part u, in the SIP002 or the legacy
+// format, and rewrites it in SIP002, with the cipher and password in
+// unpadded base64url as configurl reads them first, or percent-encoded for
+// the 2022 ciphers as SIP022 wants.
+func normalizeShadowsocks(u *url.URL, info *ConfigInfo) error {
+	if u.User == nil && u.Host != "" {
+		// Legacy: ss://base64(method:password@host:port)
+		decoded, err := DecodeBase64(u.Host)
+		if err != nil {
+			return &ConfigError{Scheme: "ss", Reason: "no user info, and the host isn't base64 of the legacy format"}
+		}
+		legacy, err := url.Parse("ss://" + string(decoded))
+		if err != nil || legacy.User == nil {
+			return &ConfigError{Scheme: "ss", Reason: "invalid legacy format"}
+		}
+		u.User, u.Host = legacy.User, legacy.Host
+	}
+	if u.User == nil {
+		return &ConfigError{Scheme: "ss", Field: "user info", Reason: "missing"}
+	}
+	method, password, ok := u.User.Username(), "", false
+	if password, ok = u.User.Password(); !ok {
+		decoded, err := DecodeBase64(method)
+		if err != nil {
+			return &ConfigError{Scheme: "ss", Field: "user info", Reason: "not base64 of cipher:password"}
+		}
+		if method, password, ok = strings.Cut(string(decoded), ":"); !ok {
+			return &ConfigError{Scheme: "ss", Field: "user info", Reason: "no password"}
+		}
+	}
+	method = strings.ToLower(method)
+	if password == "" {
+		return &ConfigError{Scheme: "ss", Field: "password", Reason: "empty"}
+	}
+	if keySize, ok := ss2022Ciphers[method]; ok {
+		// The password is the server key, then the user's, in base64.
+		for _, key := range strings.Split(password, ":") {
+			if raw, err := base64.StdEncoding.DecodeString(key); err != nil || len(raw) != keySize {
+				return &ConfigError{Scheme: "ss", Field: "password", Reason: fmt.Sprintf("the %s keys must be %d bytes in base64", method, keySize)}
+			}
+		}
+		info.Xray = true
+		u.User = url.UserPassword(method, password)
+	} else if ssCiphers[method] {
+		u.User = url.User(base64.RawURLEncoding.EncodeToString([]byte(method + ":" + password)))
+	} else {
+		return &ConfigError{Scheme: "ss", Field: "cipher", Reason: fmt.Sprintf("unsupported cipher %q", method)}
+	}
+	if err := checkServerAddr("ss", u.Host, info); err != nil {
+		return err
+	}
+	u.Host = strings.ToLower(u.Host)
+	info.Protocol = "ss"
+	info.Cipher = method
+	return nil
+}
+
+// validateXrayLink checks a vless:// or trojan:// share link.
+func validateXrayLink(config string) (*ConfigInfo, error) {
+	u, err := url.Parse(config)
+	if err != nil {
+		return nil, &ConfigError{Reason: "not a URL"}
+	}
+	scheme := strings.ToLower(u.Scheme)
+	info := &ConfigInfo{Protocol: scheme, Xray: true, Name: u.Fragment}
+	if u.User == nil || u.User.Username() == "" {
+		field := "id"
+		if scheme == "trojan" {
+			field = "password"
+		}
+		return nil, &ConfigError{Scheme: scheme, Field: field, Reason: "missing"}
+	}
+	if err := checkServerAddr(scheme, u.Host, info); err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	info.Network = cmp.Or(q.Get("type"), "tcp")
+	info.Security = q.Get("security")
+	if scheme == "trojan" {
+		// Trojan is always in TLS.
+		info.Security = cmp.Or(info.Security, "tls")
+	}
+	info.SNI = q.Get("sni")
+	if err := checkXrayStream(scheme, info); err != nil {
+		return nil, err
+	}
+	if info.Security == "reality" {
+		if key, err := base64.RawURLEncoding.DecodeString(q.Get("pbk")); err != nil || len(key) != 32 {
+			return nil, &ConfigError{Scheme: scheme, Field: "pbk", Reason: "the REALITY public key must be 32 bytes in base64url"}
+		}
+		if info.SNI == "" {
+			return nil, &ConfigError{Scheme: scheme, Field: "sni", Reason: "REALITY needs the server name to mimic"}
+		}
+	}
+	if scheme == "vless" && !slices.Contains(vlessFlows, q.Get("flow")) {
+		return nil, &ConfigError{Scheme: scheme, Field: "flow", Reason: fmt.Sprintf("unsupported flow %q", q.Get("flow"))}
+	}
+	normal := url.URL{Scheme: scheme, User: url.User(u.User.Username()), Host: strings.ToLower(u.Host), RawQuery: q.Encode()}
+	info.Normalized = normal.String()
+	return info, nil
+}
+
+// vmessLink is the JSON of a vmess:// share link, in the v2rayN format.
+type vmessLink struct {
+	Add      string `json:"add"`
+	Port     any    `json:"port"` // A number or a string
+	ID       string `json:"id"`
+	Net      string `json:"net"`
+	TLS      string `json:"tls"`
+	SNI      string `json:"sni"`
+	Host     string `json:"host"`
+	Security string `json:"scy"`
+	Name     string `json:"ps"`
+}
+
+// validateVMessLink checks a vmess:// share link.
+func validateVMessLink(config string) (*ConfigInfo, error) {
+	_, encoded, _ := strings.Cut(config, "://")
+	data, err := DecodeBase64(encoded)
+	if err != nil {
+		return nil, &ConfigError{Scheme: "vmess", Reason: "not base64"}
+	}
+	var link vmessLink
+	if err := json.Unmarshal(data, &link); err != nil {
+		return nil, &ConfigError{Scheme: "vmess", Reason: "not JSON in the v2rayN format"}
+	}
+	// The other fields are kept as they are.
+	var fields map[string]any
+	json.Unmarshal(data, &fields)
+
+	info := &ConfigInfo{Protocol: "vmess", Xray: true, Name: link.Name}
+	if link.ID == "" {
+		return nil, &ConfigError{Scheme: "vmess", Field: "id", Reason: "missing"}
+	}
+	port := strings.TrimSpace(fmt.Sprint(link.Port))
+	if link.Port == nil {
+		port = ""
+	}
+	if err := checkServerAddr("vmess", net.JoinHostPort(link.Add, port), info); err != nil {
+		return nil, err
+	}
+	info.Network = cmp.Or(link.Net, "tcp")
+	info.Security = "none"
+	if link.TLS == "tls" {
+		info.Security = "tls"
+		info.SNI = cmp.Or(link.SNI, link.Host)
+	}
+	info.Cipher = cmp.Or(link.Security, "auto")
+	if err := checkXrayStream("vmess", info); err != nil {
+		return nil, err
+	}
+	if info.Security == "reality" {
+		return nil, &ConfigError{Scheme: "vmess", Field: "tls", Reason: "VMess doesn't do REALITY"}
+	}
+
+	delete(fields, "ps")
+	fields["add"] = strings.ToLower(link.Add)
+	fields["port"] = strconv.Itoa(info.Port)
+	// Maps are marshaled with sorted keys, so this is canonical.
+	normal, _ := json.Marshal(fields)
+	info.Normalized = "vmess://" + base64.StdEncoding.EncodeToString(normal)
+	return info, nil
+}
+
+// checkXrayStream checks the network and security of an xray-core config.
+func checkXrayStream(scheme string, info *ConfigInfo) error {
+	if !slices.Contains(xrayNetworks, info.Network) {
+		return &ConfigError{Scheme: scheme, Field: "type", Reason: fmt.Sprintf("unsupported transport %q", info.Network)}
+	}
+	if !slices.Contains(xraySecurities, info.Security) {
+		return &ConfigError{Scheme: scheme, Field: "security", Reason: fmt.Sprintf("unsupported security %q", info.Security)}
+	}
+	info.Security = cmp.Or(info.Security, "none")
+	return nil
+}
+
+// checkServerAddr checks the address (host:port) of a server and sets it in
+// info.
+func checkServerAddr(scheme, addr string, info *ConfigInfo) error {
+	host, portText, err := net.SplitHostPort(addr)
+	if err != nil || portText == "" {
+		return &ConfigError{Scheme: scheme, Field: "port", Reason: "missing"}
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 1 || port > 65535 {
+		return &ConfigError{Scheme: scheme, Field: "port", Reason: "must be from 1 to 65535"}
+	}
+	if !validHost(host) {
+		return &ConfigError{Scheme: scheme, Field: "host", Reason: "not an IP address or a domain name"}
+	}
+	info.Host = strings.ToLower(host)
+	info.Port = port
+	return nil
+}
+
+// validHost tells whether host is an IP address or a domain name.
+func validHost(host string) bool {
+	if _, err := netip.ParseAddr(host); err == nil {
+		return true
+	}
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// DecodeBase64 decodes standard or URL-safe base64, padded or not, as share
+// links use all of them.
+func DecodeBase64(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if data, err := enc.DecodeString(s); err == nil {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("not base64")
+}
@@ -0,0 +1,198 @@
+package core
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	ssUser := base64.RawURLEncoding.EncodeToString([]byte("chacha20-ietf-poly1305:secret"))
+	ssKey := base64.StdEncoding.EncodeToString(make([]byte, 16))
+	pbk := base64.RawURLEncoding.EncodeToString(make([]byte, 32))
+	vmess := func(json string) string { return "vmess://" + base64.StdEncoding.EncodeToString([]byte(json)) }
+
+	cases := []struct {
+		name   string
+		config string
+		want   ConfigInfo // Normalized is only checked if set
+	}{
+		{
+			name:   "ss",
+			config: "ss://" + ssUser + "@Example.com:8388#Frankfurt",
+			want: ConfigInfo{
+				Normalized: "ss://" + ssUser + "@example.com:8388", Name: "Frankfurt",
+				Protocol: "ss", Host: "example.com", Port: 8388, Chain: "ss", Security: "none", Cipher: "chacha20-ietf-poly1305",
+			},
+		},
+		{
+			name:   "ss with a plain password",
+			config: "ss://chacha20-ietf-poly1305:secret@1.2.3.4:8388",
+			want: ConfigInfo{
+				Normalized: "ss://" + ssUser + "@1.2.3.4:8388",
+				Protocol:   "ss", Host: "1.2.3.4", Port: 8388, Chain: "ss", Security: "none", Cipher: "chacha20-ietf-poly1305",
+			},
+		},
+		{
+			name:   "ss legacy",
+			config: "ss://" + base64.StdEncoding.EncodeToString([]byte("chacha20-ietf-poly1305:secret@1.2.3.4:8388")),
+			want: ConfigInfo{
+				Normalized: "ss://" + ssUser + "@1.2.3.4:8388",
+				Protocol:   "ss", Host: "1.2.3.4", Port: 8388, Chain: "ss", Security: "none", Cipher: "chacha20-ietf-poly1305",
+			},
+		},
+		{
+			name:   "ss 2022",
+			config: "ss://2022-blake3-aes-128-gcm:" + ssKey + "@1.2.3.4:8388",
+			want: ConfigInfo{
+				Protocol: "ss", Host: "1.2.3.4", Port: 8388, Chain: "ss", Security: "none", Cipher: "2022-blake3-aes-128-gcm", Xray: true,
+			},
+		},
+		{
+			name:   "chain",
+			config: "tls:sni=cdn.example.com|ss://" + ssUser + "@1.2.3.4:443",
+			want: ConfigInfo{
+				Protocol: "ss", Host: "1.2.3.4", Port: 443, Chain: "tls|ss", Security: "tls", SNI: "cdn.example.com", Cipher: "chacha20-ietf-poly1305",
+			},
+		},
+		{
+			name:   "socks5",
+			config: "socks5://Proxy.example.com:1080",
+			want:   ConfigInfo{Normalized: "socks5://proxy.example.com:1080", Protocol: "socks5", Host: "proxy.example.com", Port: 1080, Chain: "socks5", Security: "none"},
+		},
+		{name: "direct", config: "tlsfrag:1", want: ConfigInfo{Normalized: "tlsfrag:1", Chain: "tlsfrag", Security: "none"}},
+		{name: "smart", config: " smart ", want: ConfigInfo{Normalized: "smart", Security: "none", Smart: true}},
+		{
+			name:   "smart with a fallback",
+			config: "smart:ss://" + ssUser + "@1.2.3.4:8388",
+			want: ConfigInfo{
+				Normalized: "smart:ss://" + ssUser + "@1.2.3.4:8388",
+				Protocol:   "ss", Host: "1.2.3.4", Port: 8388, Chain: "ss", Security: "none", Cipher: "chacha20-ietf-poly1305", Smart: true,
+			},
+		},
+		{
+			name:   "vless",
+			config: "vless://uuid@Example.com:443?type=ws&security=tls&sni=cdn.example.com#Name",
+			want: ConfigInfo{
+				Normalized: "vless://uuid@example.com:443?security=tls&sni=cdn.example.com&type=ws", Name: "Name",
+				Protocol: "vless", Host: "example.com", Port: 443, Network: "ws", Security: "tls", SNI: "cdn.example.com", Xray: true,
+			},
+		},
+		{
+			name:   "vless REALITY",
+			config: "vless://uuid@1.2.3.4:443?security=reality&sni=www.example.com&pbk=" + pbk + "&flow=xtls-rprx-vision",
+			want: ConfigInfo{
+				Protocol: "vless", Host: "1.2.3.4", Port: 443, Network: "tcp", Security: "reality", SNI: "www.example.com", Xray: true,
+			},
+		},
+		{
+			name:   "trojan",
+			config: "trojan://secret@1.2.3.4:443",
+			want:   ConfigInfo{Normalized: "trojan://secret@1.2.3.4:443", Protocol: "trojan", Host: "1.2.3.4", Port: 443, Network: "tcp", Security: "tls", Xray: true},
+		},
+		{
+			name:   "vmess",
+			config: vmess(`{"add":"Example.com","port":443,"id":"uuid","net":"ws","tls":"tls","host":"cdn.example.com","ps":"Name"}`),
+			want: ConfigInfo{
+				Normalized: vmess(`{"add":"example.com","host":"cdn.example.com","id":"uuid","net":"ws","port":"443","tls":"tls"}`), Name: "Name",
+				Protocol: "vmess", Host: "example.com", Port: 443, Network: "ws", Security: "tls", SNI: "cdn.example.com", Cipher: "auto", Xray: true,
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, err := ValidateConfig(tc.config)
+			if err != nil {
+				t.Fatalf("ValidateConfig: %v", err)
+			}
+			got := *info
+			if tc.want.Normalized == "" {
+				got.Normalized = ""
+			}
+			if got != tc.want {
+				t.Errorf("ValidateConfig =\n%+v, want\n%+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateConfigErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		config string
+		scheme string
+		field  string
+	}{
+		{name: "empty", config: "  "},
+		{name: "several", config: "ss://a@1.2.3.4:1\nss://b@1.2.3.4:2"},
+		{name: "empty part", config: "tlsfrag:1||ss://a@1.2.3.4:1"},
+		{name: "ss without user info", config: "ss://1.2.3.4:8388", scheme: "ss"},
+		{name: "ss without password", config: "ss://" + base64.RawURLEncoding.EncodeToString([]byte("aes-128-gcm")) + "@1.2.3.4:8388", scheme: "ss", field: "user info"},
+		{name: "ss cipher", config: "ss://rc4-md5:secret@1.2.3.4:8388", scheme: "ss", field: "cipher"},
+		{name: "ss 2022 key", config: "ss://2022-blake3-aes-256-gcm:c2VjcmV0@1.2.3.4:8388", scheme: "ss", field: "password"},
+		{name: "ss 2022 chained", config: "tlsfrag:1|ss://2022-blake3-aes-128-gcm:" + base64.StdEncoding.EncodeToString(make([]byte, 16)) + "@1.2.3.4:8388", scheme: "ss", field: "cipher"},
+		{name: "ss port", config: "ss://aes-128-gcm:secret@1.2.3.4:65536", scheme: "ss", field: "port"},
+		{name: "ss no port", config: "ss://aes-128-gcm:secret@1.2.3.4", scheme: "ss", field: "port"},
+		{name: "ss host", config: "ss://aes-128-gcm:secret@-bad.example:8388", scheme: "ss", field: "host"},
+		{name: "xray chained", config: "tlsfrag:1|vless://uuid@1.2.3.4:443", scheme: "vless"},
+		{name: "xray fallback", config: "smart:trojan://secret@1.2.3.4:443", scheme: "trojan"},
+		{name: "vless without ID", config: "vless://1.2.3.4:443", scheme: "vless", field: "id"},
+		{name: "trojan without password", config: "trojan://1.2.3.4:443", scheme: "trojan", field: "password"},
+		{name: "vless transport", config: "vless://uuid@1.2.3.4:443?type=carrier-pigeon", scheme: "vless", field: "type"},
+		{name: "vless security", config: "vless://uuid@1.2.3.4:443?security=ssl", scheme: "vless", field: "security"},
+		{name: "vless flow", config: "vless://uuid@1.2.3.4:443?flow=fast", scheme: "vless", field: "flow"},
+		{name: "REALITY key", config: "vless://uuid@1.2.3.4:443?security=reality&sni=example.com&pbk=short", scheme: "vless", field: "pbk"},
+		{name: "REALITY without SNI", config: "vless://uuid@1.2.3.4:443?security=reality&pbk=" + base64.RawURLEncoding.EncodeToString(make([]byte, 32)), scheme: "vless", field: "sni"},
+		{name: "vmess not base64", config: "vmess://%%%", scheme: "vmess"},
+		{name: "vmess not JSON", config: "vmess://" + base64.StdEncoding.EncodeToString([]byte("secret")), scheme: "vmess"},
+		{name: "vmess without port", config: "vmess://" + base64.StdEncoding.EncodeToString([]byte(`{"add":"1.2.3.4","id":"uuid"}`)), scheme: "vmess", field: "port"},
+		{name: "unknown scheme", config: "carrier://secret@1.2.3.4:1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ValidateConfig(tc.config)
+			var cfgErr *ConfigError
+			if !errors.As(err, &cfgErr) {
+				t.Fatalf("ValidateConfig error = %v, want a *ConfigError", err)
+			}
+			if cfgErr.Scheme != tc.scheme || cfgErr.Field != tc.field {
+				t.Errorf("ValidateConfig error = %q, want scheme %q and field %q", err, tc.scheme, tc.field)
+			}
+			if strings.Contains(err.Error(), "secret") {
+				t.Errorf("ValidateConfig error %q has the secret", err)
+			}
+		})
+	}
+}
+
+func TestDecodeBase64(t *testing.T) {
+	cases := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "standard", input: "Pz8+Pg==", want: "??>>"},
+		{name: "unpadded", input: "Pz8+Pg", want: "??>>"},
+		{name: "URL-safe", input: "Pz8-Pg==", want: "??>>"},
+		{name: "URL-safe unpadded", input: "Pz8-Pg", want: "??>>"},
+		{name: "surrounding space", input: " Pz8+Pg==\n", want: "??>>"},
+		{name: "empty", input: "", want: ""},
+		{name: "not base64", input: "Pz8+Pg=?", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DecodeBase64(tc.input)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("DecodeBase64(%q) = %q, want an error", tc.input, got)
+				}
+				return
+			}
+			if err != nil || string(got) != tc.want {
+				t.Errorf("DecodeBase64(%q) = %q, %v, want %q", tc.input, got, err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"golang.getoutline.org/sdk/dns"
+	"golang.getoutline.org/sdk/transport"
+	"golang.getoutline.org/sdk/x/configurl"
+	"golang.getoutline.org/sdk/x/connectivity"
+)
+
+// Codes of connectivity problems, for user-facing messages. They are plain
+// strings so gomobile can bind them.
+const (
+	CodeOK            = "ok"
+	CodeInvalidConfig = "invalid_config"
+	CodeNoNetwork     = "no_network"
+	// CodeUnreachable is when the server doesn't answer: it is down, or its
+	// address is blocked.
+	CodeUnreachable = "server_unreachable"
+	// CodeBlocked is when connections are reset, as DPI does to the
+	// protocols it detects.
+	CodeBlocked = "blocked_by_dpi"
+	// CodeAuth is when the server takes the connection but doesn't relay it,
+	// as with a wrong or revoked key.
+	CodeAuth       = "auth_failed"
+	CodeUDPBlocked = "udp_blocked"
+	// CodeDNSPoisoned is when the system resolver answers with addresses
+	// public domains can't have.
+	CodeDNSPoisoned = "dns_poisoned"
+	// CodeDNSFailed is when the system resolver doesn't answer.
+	CodeDNSFailed = "dns_failed"
+)
+
+const (
+	// connectivityResolver is the resolver queried through the transport.
+	connectivityResolver = "8.8.8.8:53"
+	// connectivityDomain is the domain resolved by the checks.
+	connectivityDomain = "example.com"
+	// connectivityTimeout is how long each check may take.
+	connectivityTimeout = 5 * time.Second
+)
+
+// ConnectivityReport is the result of TestConnectivity.
+type ConnectivityReport struct {
+	// Code is of the problem that matters most, CodeOK if there is none.
+	Code string `json:"code"`
+	// Message describes Code to the user.
+	Message string `json:"message"`
+	// Server is the address connected to first, e.g. of the Shadowsocks
+	// server.
+	Server string `json:"server,omitempty"`
+	// Direct is a TCP connection to Server, outside the transport.
+	Direct *CheckResult `json:"direct,omitempty"`
+	// TCP and UDP resolve a domain through the transport. UDP is nil if the
+	// transport doesn't relay UDP.
+	TCP *CheckResult `json:"tcp,omitempty"`
+	UDP *CheckResult `json:"udp,omitempty"`
+	// DNS resolves a domain, and the one of Server if it has one, with the
+	// system resolver, looking for poisoned answers.
+	DNS *CheckResult `json:"dns,omitempty"`
+}
+
+// CheckResult is the result of one check of a ConnectivityReport.
+type CheckResult struct {
+	OK         bool   `json:"ok"`
+	Code       string `json:"code"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// connectivityMessages describe the codes to the user.
+var connectivityMessages = map[string]string{
+	CodeOK:            "Everything works.",
+	CodeInvalidConfig: "The access key is invalid.",
+	CodeNoNetwork:     "There is no network connection.",
+	CodeUnreachable:   "The server can't be reached. It may be down, or its address blocked by your network.",
+	CodeBlocked:       "Your network blocks the connection to the server. Try another server or protocol.",
+	CodeAuth:          "The server didn't accept the connection. The access key may be wrong or expired.",
+	CodeUDPBlocked:    "UDP doesn't get through: browsing works, but calls, games and QUIC may not.",
+	CodeDNSPoisoned:   "Your network's DNS gives false answers. Connect to resolve domains through the server.",
+	CodeDNSFailed:     "Your network's DNS doesn't answer. Connect to resolve domains through the server.",
+}
+
+// ConnectivityMessage returns the user-facing description of code.
+func ConnectivityMessage(code string) string {
+	return connectivityMessages[code]
+}
+
+// CheckConnectivity is TestConnectivity for bindings, which can't pass a
+// context.
+func CheckConnectivity(config string) *ConnectivityReport {
+	return TestConnectivity(context.Background(), config)
+}
+
+// TestConnectivity checks whether the server of config can be reached and
+// relays TCP and UDP, and whether the system's DNS is poisoned. The checks run
+// at once and take up to a few seconds.
+func TestConnectivity(ctx context.Context, config string) *ConnectivityReport {
+	report := &ConnectivityReport{}
+	providers := configurl.NewDefaultProviders()
+	dialer, err := newStreamDialer(ctx, providers, config)
+	if err != nil {
+		report.Code = CodeInvalidConfig
+		report.Message = ConnectivityMessage(CodeInvalidConfig) + " " + err.Error()
+		return report
+	}
+	// Transports without UDP fail here.
+	packetListener, _ := newPacketListener(ctx, providers, config)
+	report.Server = firstHopAddr(config)
+
+	var wg sync.WaitGroup
+	check := func(result **CheckResult, test func(ctx context.Context) (string, error)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, connectivityTimeout)
+			defer cancel()
+			start := time.Now()
+			code, err := test(ctx)
+			r := &CheckResult{OK: err == nil, Code: code, DurationMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				r.Error = err.Error()
+			} else {
+				r.Code = CodeOK
+			}
+			*result = r
+		}()
+	}
+
+	if report.Server != "" {
+		check(&report.Direct, func(ctx context.Context) (string, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", report.Server)
+			if err != nil {
+				return classifyDialError(err), err
+			}
+			conn.Close()
+			return "", nil
+		})
+	}
+	check(&report.TCP, func(ctx context.Context) (string, error) {
+		return testTransport(ctx, dns.NewTCPResolver(dialer, connectivityResolver))
+	})
+	if packetListener != nil {
+		check(&report.UDP, func(ctx context.Context) (string, error) {
+			code, err := testTransport(ctx, dns.NewUDPResolver(transport.PacketListenerDialer{Listener: packetListener}, connectivityResolver))
+			if err != nil && code != CodeNoNetwork {
+				code = CodeUDPBlocked
+			}
+			return code, err
+		})
+	}
+	domains := []string{connectivityDomain}
+	if host, _, _ := net.SplitHostPort(report.Server); host != "" && net.ParseIP(host) == nil {
+		domains = append(domains, host)
+	}
+	check(&report.DNS, func(ctx context.Context) (string, error) {
+		for _, domain := range domains {
+			addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", domain)
+			if err != nil {
+				return CodeDNSFailed, err
+			}
+			for _, addr := range addrs {
+				if isBogon(addr) {
+					return CodeDNSPoisoned, fmt.Errorf("%s resolves to %s", domain, addr)
+				}
+			}
+		}
+		return "", nil
+	})
+	wg.Wait()
+
+	report.Code = CodeOK
+	// The first failure explains the ones after it. Poisoned answers, as for
+	// the domain of the server, explain all.
+	for _, r := range []*CheckResult{poisoned(report.DNS), report.Direct, report.TCP, report.UDP, report.DNS} {
+		if r != nil && !r.OK {
+			report.Code = r.Code
+			break
+		}
+	}
+	report.Message = ConnectivityMessage(report.Code)
+	return report
+}
+
+// poisoned returns r if it found poisoned DNS answers, else nil.
+func poisoned(r *CheckResult) *CheckResult {
+	if r != nil && r.Code == CodeDNSPoisoned {
+		return r
+	}
+	return nil
+}
+
+// testTransport resolves connectivityDomain with resolver and classifies the
+// failure, if any.
+func testTransport(ctx context.Context, resolver dns.Resolver) (string, error) {
+	result, err := connectivity.TestConnectivityWithResolver(ctx, resolver, connectivityDomain)
+	if err != nil {
+		return CodeUnreachable, err
+	}
+	if result == nil {
+		return "", nil
+	}
+	switch result.PosixError {
+	case "ECONNRESET", "ECONNABORTED", "EPIPE":
+		return CodeBlocked, result
+	case "ENETUNREACH", "ENETDOWN":
+		return CodeNoNetwork, result
+	}
+	if result.Op == "connect" {
+		return CodeUnreachable, result
+	}
+	// The server took the connection, but nothing came back.
+	return CodeAuth, result
+}
+
+// classifyDialError returns the code of a failed connection made outside the
+// transport.
+func classifyDialError(err error) string {
+	switch errnoCode(err) {
+	case "ECONNRESET", "ECONNABORTED":
+		return CodeBlocked
+	case "ENETUNREACH", "ENETDOWN":
+		return CodeNoNetwork
+	}
+	return CodeUnreachable
+}
+
+// isBogon reports whether addr can't be the address of a public domain, as
+// the answers injected by DNS poisoning often are.
+func isBogon(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsUnspecified() || addr.IsLoopback() || addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() || addr.IsMulticast()
+}
+
+// firstHopAddr returns the address the client connects to for config: that
+// of the innermost part of the chain with a port. It is empty if config has
+// none, e.g. if it connects directly. In the smart mode it is the address of
+// the fallback.
+func firstHopAddr(config string) string {
+	if fallback, ok := smartFallback(config); ok {
+		config = fallback
+	}
+	cfg, err := configurl.ParseConfig(config)
+	if err != nil {
+		return ""
+	}
+	addr := ""
+	for ; cfg != nil; cfg = cfg.BaseConfig {
+		if cfg.URL.Port() != "" {
+			addr = cfg.URL.Host
+		}
+	}
+	return addr
+}
@@ -0,0 +1,313 @@
+package core
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.getoutline.org/sdk/dns"
+	"golang.getoutline.org/sdk/transport"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultDNSUpstream is the resolver of a DNSForwarder without one.
+const DefaultDNSUpstream = "https://1.1.1.1/dns-query"
+
+const (
+	dnsQueryTimeout = 10 * time.Second
+	// dnsIdleTimeout is how long a TCP client may wait between queries.
+	dnsIdleTimeout = 30 * time.Second
+	// minUDPSize is the largest UDP answer a client without EDNS(0) takes.
+	minUDPSize = 512
+)
+
+// dohResolvers are the public resolvers that also serve DoH on their IPs, at
+// /dns-query.
+var dohResolvers = map[netip.Addr]bool{
+	netip.MustParseAddr("1.1.1.1"):         true,
+	netip.MustParseAddr("1.0.0.1"):         true,
+	netip.MustParseAddr("8.8.8.8"):         true,
+	netip.MustParseAddr("8.8.4.4"):         true,
+	netip.MustParseAddr("9.9.9.9"):         true,
+	netip.MustParseAddr("149.112.112.112"): true,
+}
+
+// DNSForwarder answers DNS queries by resolving them with an upstream
+// resolver through a dialer, such as that of the tunnel, so they neither leak
+// past it nor depend on the server relaying UDP. It serves a local address
+// with Listen, or answers queries handed to it, as from a TUN device.
+type DNSForwarder struct {
+	resolver dns.Resolver
+	upstream string
+
+	// Hosts are answered with these addresses instead of being resolved, by
+	// lowercase name without the final dot. This is for the server of the
+	// tunnel, which can't be resolved through the tunnel before it is up.
+	Hosts map[string][]netip.Addr
+	// BlockAAAA answers AAAA queries with no addresses, so apps don't try
+	// IPv6 while it is blocked.
+	BlockAAAA bool
+
+	mu     sync.Mutex
+	udp    net.PacketConn
+	tcp    net.Listener
+	conns  map[net.Conn]bool // Of the TCP clients
+	ctx    context.Context   // Of the queries being answered for clients
+	cancel context.CancelFunc
+}
+
+// NewDNSForwarder creates a forwarder that resolves with upstream through
+// dialer. Upstream is the URL of a DNS-over-HTTPS resolver, or the address of
+// a resolver, queried over TCP unless it is a public one known to serve DoH,
+// like 1.1.1.1, 8.8.8.8 and 9.9.9.9. Empty means DefaultDNSUpstream.
+func NewDNSForwarder(dialer transport.StreamDialer, upstream string) (*DNSForwarder, error) {
+	upstream = cmp.Or(strings.TrimSpace(upstream), DefaultDNSUpstream)
+	if ip, err := netip.ParseAddr(upstream); err == nil && dohResolvers[ip.Unmap()] {
+		upstream = "https://" + ip.Unmap().String() + "/dns-query"
+	}
+	f := &DNSForwarder{upstream: upstream}
+	if strings.HasPrefix(upstream, "https://") {
+		u, err := url.Parse(upstream)
+		if err != nil || u.Hostname() == "" {
+			return nil, fmt.Errorf("invalid DNS-over-HTTPS URL %q", upstream)
+		}
+		f.resolver = dns.NewHTTPSResolver(dialer, net.JoinHostPort(u.Hostname(), cmp.Or(u.Port(), "443")), upstream)
+		return f, nil
+	}
+	if strings.Contains(upstream, "://") {
+		return nil, fmt.Errorf("invalid DNS resolver %q: use an address or an https:// URL", upstream)
+	}
+	f.resolver = dns.NewTCPResolver(dialer, upstream)
+	return f, nil
+}
+
+// Upstream returns the resolver queried, as a DoH URL for resolvers that
+// serve it.
+func (f *DNSForwarder) Upstream() string {
+	return f.upstream
+}
+
+// Answer answers a DNS query message, as sent over TCP without the length
+// prefix. When the upstream resolver fails, the answer is SERVFAIL; the error
+// is only for messages that aren't a query with one question.
+func (f *DNSForwarder) Answer(ctx context.Context, query []byte) ([]byte, error) {
+	var req dnsmessage.Message
+	if err := req.Unpack(query); err != nil {
+		return nil, fmt.Errorf("invalid DNS message: %w", err)
+	}
+	if req.Header.Response || len(req.Questions) != 1 {
+		return nil, errors.New("not a DNS query with one question")
+	}
+	return f.answer(ctx, &req, 0)
+}
+
+// AnswerPacket is Answer for queries that came over UDP. Answers longer than
+// the client takes are truncated, so it asks again over TCP.
+func (f *DNSForwarder) AnswerPacket(ctx context.Context, query []byte) ([]byte, error) {
+	var req dnsmessage.Message
+	if err := req.Unpack(query); err != nil {
+		return nil, fmt.Errorf("invalid DNS message: %w", err)
+	}
+	if req.Header.Response || len(req.Questions) != 1 {
+		return nil, errors.New("not a DNS query with one question")
+	}
+	maxSize := minUDPSize
+	for _, r := range req.Additionals {
+		// The class of an OPT record is the UDP size the client takes.
+		if r.Header.Type == dnsmessage.TypeOPT {
+			maxSize = max(maxSize, int(r.Header.Class))
+		}
+	}
+	return f.answer(ctx, &req, maxSize)
+}
+
+// answer answers req with a message of at most maxSize bytes, 0 for no
+// limit.
+func (f *DNSForwarder) answer(ctx context.Context, req *dnsmessage.Message, maxSize int) ([]byte, error) {
+	q := req.Questions[0]
+	var resp *dnsmessage.Message
+	if addrs, ok := f.Hosts[strings.ToLower(strings.TrimSuffix(q.Name.String(), "."))]; ok {
+		resp = hostsAnswer(q, addrs)
+	} else if q.Type == dnsmessage.TypeAAAA && f.BlockAAAA {
+		resp = hostsAnswer(q, nil)
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
+		var err error
+		resp, err = f.resolver.Query(ctx, q)
+		cancel()
+		if err != nil {
+			log.Printf("DNS query for %s failed: %v\n", q.Name, err)
+			resp = &dnsmessage.Message{
+				Header:    dnsmessage.Header{Response: true, RCode: dnsmessage.RCodeServerFailure},
+				Questions: req.Questions,
+			}
+		}
+	}
+	resp.Header.ID = req.Header.ID
+	resp.Header.RecursionDesired = req.Header.RecursionDesired
+	// The EDNS(0) options of the upstream are for the connection to it.
+	resp.Additionals = deleteOPT(resp.Additionals)
+	buf, err := resp.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack DNS answer: %w", err)
+	}
+	if maxSize > 0 && len(buf) > maxSize {
+		resp.Header.Truncated = true
+		resp.Answers, resp.Authorities, resp.Additionals = nil, nil, nil
+		return resp.Pack()
+	}
+	return buf, nil
+}
+
+func deleteOPT(rs []dnsmessage.Resource) []dnsmessage.Resource {
+	var kept []dnsmessage.Resource
+	for _, r := range rs {
+		if r.Header.Type != dnsmessage.TypeOPT {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// hostsAnswer answers q with the addresses of its type.
+func hostsAnswer(q dnsmessage.Question, addrs []netip.Addr) *dnsmessage.Message {
+	msg := &dnsmessage.Message{
+		Header:    dnsmessage.Header{Response: true, Authoritative: true, RecursionAvailable: true},
+		Questions: []dnsmessage.Question{q},
+	}
+	for _, ip := range addrs {
+		hdr := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}
+		switch {
+		case q.Type == dnsmessage.TypeA && ip.Unmap().Is4():
+			msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.AResource{A: ip.Unmap().As4()}})
+		case q.Type == dnsmessage.TypeAAAA && ip.Is6() && !ip.Is4In6():
+			msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.AAAAResource{AAAA: ip.As16()}})
+		}
+	}
+	return msg
+}
+
+// Listen serves DNS on addr (host:port), over UDP and TCP on the same port,
+// until Close. It returns the address listened on, with the port picked if
+// addr's is 0.
+func (f *DNSForwarder) Listen(addr string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.udp != nil {
+		return "", errors.New("already listening")
+	}
+	udp, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return "", fmt.Errorf("failed to listen for DNS: %w", err)
+	}
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		return "", fmt.Errorf("failed to listen for DNS: %w", err)
+	}
+	f.udp, f.tcp = udp, tcp
+	f.conns = make(map[net.Conn]bool)
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	go f.serveUDP(f.ctx, udp)
+	go f.serveTCP(f.ctx, tcp)
+	return udp.LocalAddr().String(), nil
+}
+
+// Close stops serving, and the queries being answered.
+func (f *DNSForwarder) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.udp == nil {
+		return nil
+	}
+	f.cancel()
+	f.udp.Close()
+	f.tcp.Close()
+	for conn := range f.conns {
+		conn.Close()
+	}
+	f.udp, f.tcp, f.conns = nil, nil, nil
+	return nil
+}
+
+func (f *DNSForwarder) serveUDP(ctx context.Context, conn net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("DNS forwarder stopped: %v\n", err)
+			}
+			return
+		}
+		query := append([]byte(nil), buf[:n]...)
+		go func() {
+			resp, err := f.AnswerPacket(ctx, query)
+			if err != nil {
+				// Dropped like a lost packet.
+				return
+			}
+			conn.WriteTo(resp, addr)
+		}()
+	}
+}
+
+func (f *DNSForwarder) serveTCP(ctx context.Context, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("DNS forwarder stopped: %v\n", err)
+			}
+			return
+		}
+		f.mu.Lock()
+		if f.conns == nil {
+			f.mu.Unlock()
+			conn.Close()
+			return
+		}
+		f.conns[conn] = true
+		f.mu.Unlock()
+		go func() {
+			f.serveConn(ctx, conn)
+			f.mu.Lock()
+			delete(f.conns, conn)
+			f.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// serveConn answers the queries of a TCP client in turn, each prefixed with
+// its length, until it closes or stays idle.
+func (f *DNSForwarder) serveConn(ctx context.Context, conn net.Conn) {
+	for {
+		conn.SetReadDeadline(time.Now().Add(dnsIdleTimeout))
+		var size [2]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		query := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+		resp, err := f.Answer(ctx, query)
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp...)); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+
+package core
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errnoCode returns the POSIX name of the socket error in err, as the SDK's
+// connectivity package names them, or "" if there is none.
+func errnoCode(err error) string {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return ""
+	}
+	switch errno {
+	case syscall.ECONNRESET:
+		return "ECONNRESET"
+	case syscall.ECONNABORTED:
+		return "ECONNABORTED"
+	case syscall.ECONNREFUSED:
+		return "ECONNREFUSED"
+	case syscall.EHOSTUNREACH:
+		return "EHOSTUNREACH"
+	case syscall.ENETUNREACH:
+		return "ENETUNREACH"
+	case syscall.ENETDOWN:
+		return "ENETDOWN"
+	case syscall.ETIMEDOUT:
+		return "ETIMEDOUT"
+	}
+	return ""
+}
@@ -0,0 +1,35 @@
+//go:build windows
+
+package core
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// errnoCode returns the POSIX name of the socket error in err, as the SDK's
+// connectivity package names them, or "" if there is none.
+func errnoCode(err error) string {
+	var errno windows.Errno
+	if !errors.As(err, &errno) {
+		return ""
+	}
+	switch errno {
+	case windows.WSAECONNRESET:
+		return "ECONNRESET"
+	case windows.WSAECONNABORTED:
+		return "ECONNABORTED"
+	case windows.WSAECONNREFUSED:
+		return "ECONNREFUSED"
+	case windows.WSAEHOSTUNREACH:
+		return "EHOSTUNREACH"
+	case windows.WSAENETUNREACH:
+		return "ENETUNREACH"
+	case windows.WSAENETDOWN:
+		return "ENETDOWN"
+	case windows.WSAETIMEDOUT:
+		return "ETIMEDOUT"
+	}
+	return ""
+}
@@ -0,0 +1,259 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"golang.getoutline.org/sdk/dns"
+	"golang.getoutline.org/sdk/transport"
+	"golang.getoutline.org/sdk/x/configurl"
+	"golang.getoutline.org/sdk/x/connectivity"
+)
+
+const (
+	// failoverCheckInterval is how often the endpoints are health-checked.
+	failoverCheckInterval = 30 * time.Second
+	// failoverMaxLatency is the slowest a health check may be for the
+	// endpoint to count as healthy.
+	failoverMaxLatency = 2 * time.Second
+	// failoverWindow is how many of the last dials of an endpoint its error
+	// rate is of.
+	failoverWindow = 20
+	// failoverMinDials is how many dials it takes to judge the error rate.
+	failoverMinDials = 5
+	// failoverMaxErrorRate is the highest error rate of a usable endpoint.
+	failoverMaxErrorRate = 0.5
+	// failoverMaxFailures is how many dials in a row may fail.
+	failoverMaxFailures = 3
+)
+
+// FailoverDialer is a StreamDialer over an ordered list of configs. It dials
+// with the first endpoint that is healthy and isn't failing, and moves on to
+// the next when the active one degrades: when too many of its dials fail, or
+// its health checks fail or get slow. It goes back to an earlier endpoint
+// once that passes a health check again.
+type FailoverDialer struct {
+	endpoints []*endpoint
+	mu        sync.Mutex
+	active    int
+	onSwitch  func(index int, config string)
+}
+
+var _ transport.StreamDialer = (*FailoverDialer)(nil)
+
+// endpoint is one config of a FailoverDialer. Its fields are guarded by the
+// mutex of the dialer.
+type endpoint struct {
+	config   string
+	dialer   transport.StreamDialer
+	healthy  bool          // As of the last health check
+	latency  time.Duration // Of the last health check
+	failures []bool        // Whether each of the last dials failed, oldest first
+}
+
+// degraded reports whether e shouldn't be dialed with.
+func (e *endpoint) degraded() bool {
+	if !e.healthy {
+		return true
+	}
+	failed, inARow := 0, 0
+	for _, f := range e.failures {
+		if f {
+			failed++
+			inARow++
+		} else {
+			inARow = 0
+		}
+	}
+	if inARow >= failoverMaxFailures {
+		return true
+	}
+	return len(e.failures) >= failoverMinDials && float64(failed)/float64(len(e.failures)) > failoverMaxErrorRate
+}
+
+// NewFailoverDialer creates the dialers of configs, in order of preference.
+// Health checks only run with Run.
+func NewFailoverDialer(ctx context.Context, configs []string) (*FailoverDialer, error) {
+	if len(configs) == 0 {
+		return nil, errors.New("no configs to fail over between")
+	}
+	providers := configurl.NewDefaultProviders()
+	d := &FailoverDialer{}
+	for i, config := range configs {
+		dialer, err := newStreamDialer(ctx, providers, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dialer %d: %w", i, err)
+		}
+		d.endpoints = append(d.endpoints, &endpoint{config: config, dialer: dialer, healthy: true})
+	}
+	return d, nil
+}
+
+// SetOnSwitch sets the function called, without locks held, when the active
+// endpoint changes.
+func (d *FailoverDialer) SetOnSwitch(f func(index int, config string)) {
+	d.mu.Lock()
+	d.onSwitch = f
+	d.mu.Unlock()
+}
+
+// Active returns the index and config of the endpoint dialed with.
+func (d *FailoverDialer) Active() (int, string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.active, d.endpoints[d.active].config
+}
+
+// Configs returns the configs, in order of preference.
+func (d *FailoverDialer) Configs() []string {
+	configs := make([]string, len(d.endpoints))
+	for i, e := range d.endpoints {
+		configs[i] = e.config
+	}
+	return configs
+}
+
+// DialStream dials addr with the active endpoint. If that fails and makes
+// the dialer switch, it tries once more with the new one.
+func (d *FailoverDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	i, dialer := d.activeDialer()
+	conn, err := dialer.DialStream(ctx, addr)
+	d.record(i, err != nil && ctx.Err() == nil)
+	if err == nil || ctx.Err() != nil {
+		return conn, err
+	}
+	j, next := d.activeDialer()
+	if j == i {
+		return nil, err
+	}
+	conn, err = next.DialStream(ctx, addr)
+	d.record(j, err != nil && ctx.Err() == nil)
+	return conn, err
+}
+
+func (d *FailoverDialer) activeDialer() (int, transport.StreamDialer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.active, d.endpoints[d.active].dialer
+}
+
+// record adds the result of a dial with endpoint i, and switches if it
+// degraded it.
+func (d *FailoverDialer) record(i int, failed bool) {
+	d.mu.Lock()
+	e := d.endpoints[i]
+	e.failures = append(e.failures, failed)
+	if len(e.failures) > failoverWindow {
+		e.failures = e.failures[len(e.failures)-failoverWindow:]
+	}
+	d.selectLocked()
+}
+
+// selectLocked makes the first endpoint that isn't degraded the active one.
+// If all are, the active one stays. d.mu must be held; selectLocked unlocks
+// it.
+func (d *FailoverDialer) selectLocked() {
+	next := d.active
+	for i, e := range d.endpoints {
+		if !e.degraded() {
+			next = i
+			break
+		}
+	}
+	if next == d.active {
+		d.mu.Unlock()
+		return
+	}
+	log.Printf("Failover: endpoint %d -> %d\n", d.active, next)
+	d.active = next
+	onSwitch, config := d.onSwitch, d.endpoints[next].config
+	d.mu.Unlock()
+	if onSwitch != nil {
+		onSwitch(next, config)
+	}
+}
+
+// Run health-checks the endpoints right away and then every
+// failoverCheckInterval, until ctx is done.
+func (d *FailoverDialer) Run(ctx context.Context) {
+	ticker := time.NewTicker(failoverCheckInterval)
+	defer ticker.Stop()
+	for {
+		d.checkEndpoints(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkEndpoints health-checks all endpoints at once, then picks the one to
+// use.
+func (d *FailoverDialer) checkEndpoints(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, e := range d.endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			latency, err := checkEndpoint(ctx, e.dialer)
+			if ctx.Err() != nil {
+				return
+			}
+			healthy := err == nil && latency <= failoverMaxLatency
+			if !healthy {
+				log.Printf("Failover: endpoint unhealthy (latency %v): %v\n", latency, err)
+			}
+			d.mu.Lock()
+			// A passed check gives the endpoint a fresh start.
+			if healthy && !e.healthy {
+				e.failures = nil
+			}
+			e.healthy, e.latency = healthy, latency
+			d.mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+	d.mu.Lock()
+	d.selectLocked()
+}
+
+// checkEndpoint resolves a domain over TCP through dialer, which takes a
+// round trip through the server, and returns how long it took.
+func checkEndpoint(ctx context.Context, dialer transport.StreamDialer) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, connectivityTimeout)
+	defer cancel()
+	start := time.Now()
+	result, err := connectivity.TestConnectivityWithResolver(ctx, dns.NewTCPResolver(dialer, connectivityResolver), connectivityDomain)
+	latency := time.Since(start)
+	if err != nil {
+		return latency, err
+	}
+	if result != nil {
+		return latency, result
+	}
+	return latency, nil
+}
+
+// failoverPacketListener sends UDP through the active endpoint of a
+// FailoverDialer. Endpoints without UDP have a nil listener.
+type failoverPacketListener struct {
+	dialer    *FailoverDialer
+	listeners []transport.PacketListener
+}
+
+func (l *failoverPacketListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	i, _ := l.dialer.Active()
+	if l.listeners[i] == nil {
+		return nil, fmt.Errorf("endpoint %d doesn't support UDP", i)
+	}
+	return l.listeners[i].ListenPacket(ctx)
+}
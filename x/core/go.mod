@@ -2,17 +2,23 @@ module drfrake-core
 
 go 1.25.0
 
-require golang.getoutline.org/sdk/x v0.1.0
+require (
+	github.com/things-go/go-socks5 v0.0.5
+	golang.getoutline.org/sdk v0.0.21
+	golang.getoutline.org/sdk/x v0.1.0
+	golang.org/x/net v0.50.0
+	golang.org/x/sys v0.41.0
+	golang.zx2c4.com/wintun v0.0.0-20230126152724-0fa3db229ce2
+	golang.zx2c4.com/wireguard/windows v0.5.3
+)
 
 require (
+	github.com/goccy/go-yaml v1.17.1 // indirect
 	github.com/gorilla/websocket v1.5.3 // indirect
 	github.com/shadowsocks/go-shadowsocks2 v0.1.5 // indirect
-	golang.getoutline.org/sdk v0.0.21 // indirect
 	golang.org/x/crypto v0.48.0 // indirect
 	golang.org/x/mobile v0.0.0-20260211191516-dcd2a3258864 // indirect
 	golang.org/x/mod v0.33.0 // indirect
-	golang.org/x/net v0.50.0 // indirect
 	golang.org/x/sync v0.19.0 // indirect
-	golang.org/x/sys v0.41.0 // indirect
 	golang.org/x/tools v0.42.0 // indirect
 )
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+)
+
+// Keystore keeps secrets, such as session tokens and access keys, where only
+// the current user of the system can read them.
+type Keystore interface {
+	// Get returns the secret of key, or ErrSecretNotFound if there is none.
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte) error
+	// Delete removes the secret of key. It is no error if there is none.
+	Delete(key string) error
+}
+
+// ErrSecretNotFound is the error of Keystore.Get for a key without a secret.
+var ErrSecretNotFound = errors.New("secret not found")
+
+// validKey matches the keys of a Keystore. They name files in the fallback.
+var validKey = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+func checkKey(key string) error {
+	if !validKey.MatchString(key) || key == ".." {
+		return fmt.Errorf("invalid secret key %q", key)
+	}
+	return nil
+}
+
+// NewKeystore returns the keystore of the system: DPAPI-encrypted files in dir
+// on Windows, the Keychain on macOS and the secret service on Linux. Secrets
+// are told apart by service, dir and key, so apps and their profiles each
+// have their own. Where the system has no keystore, secrets are files in dir
+// only the user can read, and on macOS and Linux those files are moved into
+// the system's keystore when read.
+func NewKeystore(service, dir string) Keystore {
+	return newSystemKeystore(service, dir)
+}
+
+// NewFileKeystore returns a keystore of files in dir that only the current
+// user can read. The secrets aren't encrypted.
+func NewFileKeystore(dir string) Keystore {
+	return &fileKeystore{dir: dir}
+}
+
+// fileKeystore keeps each secret in a file named after its key.
+type fileKeystore struct {
+	dir string
+}
+
+func (s *fileKeystore) Get(key string) ([]byte, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(s.dir, key)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrSecretNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Earlier versions wrote files anyone could read.
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0077 != 0 {
+		os.Chmod(path, 0600)
+	}
+	return data, nil
+}
+
+func (s *fileKeystore) Set(key string, value []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.dir, key), value)
+}
+
+func (s *fileKeystore) Delete(key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(s.dir, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// writeFileAtomic writes data to path readable only by the user, replacing
+// the file at once so a crash can't leave half a secret behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// migratingKeystore is a keystore of the system that takes over the secrets
+// files holds, as written before it was available.
+type migratingKeystore struct {
+	Keystore
+	files Keystore
+}
+
+func (s *migratingKeystore) Get(key string) ([]byte, error) {
+	value, err := s.Keystore.Get(key)
+	if !errors.Is(err, ErrSecretNotFound) {
+		return value, err
+	}
+	value, err = s.files.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	// If the system's keystore fails, the file stays for the next time.
+	if s.Keystore.Set(key, value) == nil {
+		s.files.Delete(key)
+	}
+	return value, nil
+}
+
+func (s *migratingKeystore) Delete(key string) error {
+	s.files.Delete(key)
+	return s.Keystore.Delete(key)
+}
+
+// exitCode returns the exit status of the command that failed with err, or -1
+// if it didn't exit.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
@@ -0,0 +1,83 @@
+//go:build darwin
+
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// keychainItemNotFound is the exit status of security when there is no such
+// item.
+const keychainItemNotFound = 44
+
+func newSystemKeystore(service, dir string) Keystore {
+	files := &fileKeystore{dir: dir}
+	// There is no security tool on iOS.
+	if _, err := exec.LookPath("security"); err != nil {
+		return files
+	}
+	return &migratingKeystore{Keystore: &keychainKeystore{service: service, dir: dir}, files: files}
+}
+
+// keychainKeystore keeps secrets in the login Keychain as generic passwords
+// of service, with the path the key would have in dir as the account. Values
+// are base64, as security prints them as text.
+type keychainKeystore struct {
+	service string
+	dir     string
+}
+
+func (s *keychainKeystore) account(key string) string {
+	return filepath.Join(s.dir, key)
+}
+
+func (s *keychainKeystore) Get(key string) ([]byte, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	out, err := exec.Command("security", "find-generic-password", "-s", s.service, "-a", s.account(key), "-w").Output()
+	if exitCode(err) == keychainItemNotFound {
+		return nil, ErrSecretNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from the Keychain: %w", err)
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+}
+
+func (s *keychainKeystore) Set(key string, value []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	// The command goes in on stdin, which unlike arguments other processes
+	// can't see.
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %q -a %q -w %q\n",
+		s.service, s.account(key), base64.StdEncoding.EncodeToString(value)))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	// In interactive mode, security exits with 0 even if the command fails.
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to write to the Keychain: %w", err)
+	}
+	if stderr.Len() > 0 {
+		return fmt.Errorf("failed to write to the Keychain: %s", strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (s *keychainKeystore) Delete(key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	err := exec.Command("security", "delete-generic-password", "-s", s.service, "-a", s.account(key)).Run()
+	if err != nil && exitCode(err) != keychainItemNotFound {
+		return fmt.Errorf("failed to delete from the Keychain: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+//go:build linux
+
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+func newSystemKeystore(service, dir string) Keystore {
+	files := &fileKeystore{dir: dir}
+	// The secret service is on the session bus, which Android and headless
+	// systems don't have.
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+		return files
+	}
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return files
+	}
+	return &migratingKeystore{Keystore: &secretServiceKeystore{service: service, dir: dir}, files: files}
+}
+
+// secretServiceKeystore keeps secrets with the secret service, such as GNOME
+// Keyring or KWallet, through secret-tool. They have the attributes service,
+// dir and account, the key. Values are base64, as secret-tool prints them as
+// text.
+type secretServiceKeystore struct {
+	service string
+	dir     string
+}
+
+// secretTool runs secret-tool with args and stdin, and returns what it
+// printed. found is false if it failed without a message, as lookup does
+// when there is no such secret.
+func (s *secretServiceKeystore) secretTool(stdin string, args ...string) (out []byte, found bool, err error) {
+	cmd := exec.Command("secret-tool", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err = cmd.Output()
+	if err != nil {
+		if exitCode(err) == 1 && stderr.Len() == 0 {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("secret-tool %s failed: %w %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, true, nil
+}
+
+func (s *secretServiceKeystore) Get(key string) ([]byte, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	out, found, err := s.secretTool("", "lookup", "service", s.service, "dir", s.dir, "account", key)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrSecretNotFound
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+}
+
+func (s *secretServiceKeystore) Set(key string, value []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	// The value goes in on stdin, which unlike arguments other processes
+	// can't see.
+	_, _, err := s.secretTool(base64.StdEncoding.EncodeToString(value),
+		"store", "--label="+s.service+" "+key, "service", s.service, "dir", s.dir, "account", key)
+	return err
+}
+
+func (s *secretServiceKeystore) Delete(key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	_, _, err := s.secretTool("", "clear", "service", s.service, "dir", s.dir, "account", key)
+	return err
+}
@@ -0,0 +1,7 @@
+//go:build !windows && !darwin && !linux
+
+package core
+
+func newSystemKeystore(service, dir string) Keystore {
+	return &fileKeystore{dir: dir}
+}
@@ -0,0 +1,87 @@
+//go:build windows
+
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// dpapiFileHeader starts the files of a dpapiKeystore, telling them apart
+// from the plaintext files of earlier versions.
+const dpapiFileHeader = "DRFRAKE-DPAPI1\n"
+
+func newSystemKeystore(service, dir string) Keystore {
+	return &dpapiKeystore{files: fileKeystore{dir: dir}, entropy: []byte(service)}
+}
+
+// dpapiKeystore keeps each secret in a file, encrypted with DPAPI so only the
+// current Windows user can decrypt it.
+type dpapiKeystore struct {
+	files fileKeystore
+	// entropy must be given again to decrypt, which ties the files to the
+	// service.
+	entropy []byte
+}
+
+func (s *dpapiKeystore) Get(key string) ([]byte, error) {
+	data, err := s.files.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if encrypted, ok := bytes.CutPrefix(data, []byte(dpapiFileHeader)); ok {
+		return unprotectData(encrypted, s.entropy)
+	}
+	// A plaintext file of an earlier version is encrypted in place.
+	if err := s.Set(key, data); err != nil {
+		log.Printf("Keystore: failed to encrypt %s: %v\n", key, err)
+	}
+	return data, nil
+}
+
+func (s *dpapiKeystore) Set(key string, value []byte) error {
+	encrypted, err := protectData(value, s.entropy)
+	if err != nil {
+		return err
+	}
+	return s.files.Set(key, append([]byte(dpapiFileHeader), encrypted...))
+}
+
+func (s *dpapiKeystore) Delete(key string) error {
+	return s.files.Delete(key)
+}
+
+// protectData encrypts data with DPAPI for the current Windows user.
+func protectData(data, entropy []byte) ([]byte, error) {
+	var out windows.DataBlob
+	if err := windows.CryptProtectData(newDataBlob(data), nil, newDataBlob(entropy), 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	return takeDataBlob(&out), nil
+}
+
+// unprotectData decrypts what protectData encrypted.
+func unprotectData(data, entropy []byte) ([]byte, error) {
+	var out windows.DataBlob
+	if err := windows.CryptUnprotectData(newDataBlob(data), nil, newDataBlob(entropy), 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return takeDataBlob(&out), nil
+}
+
+func newDataBlob(data []byte) *windows.DataBlob {
+	if len(data) == 0 {
+		return &windows.DataBlob{}
+	}
+	return &windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+}
+
+// takeDataBlob copies what the system allocated in blob and frees it.
+func takeDataBlob(blob *windows.DataBlob) []byte {
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(blob.Data)))
+	return append([]byte(nil), unsafe.Slice(blob.Data, blob.Size)...)
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.getoutline.org/sdk/x/configurl"
+)
+
+const (
+	// latencyTimeout is how long a server has to accept a connection.
+	latencyTimeout = 3 * time.Second
+	// latencyProbes is how many connections measure a server; the fastest
+	// counts.
+	latencyProbes = 3
+)
+
+// MeasureServerLatency returns how long the server of config takes to accept
+// a TCP connection, the fastest of latencyProbes tries. It takes the configs
+// of ValidateConfig.
+func MeasureServerLatency(config string) (time.Duration, error) {
+	addr := serverAddrOf(config)
+	if addr == "" {
+		return 0, errors.New("invalid server config")
+	}
+	var best time.Duration
+	var lastErr error
+	for range latencyProbes {
+		ctx, cancel := context.WithTimeout(context.Background(), latencyTimeout)
+		start := time.Now()
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		elapsed := time.Since(start)
+		cancel()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		conn.Close()
+		if best == 0 || elapsed < best {
+			best = elapsed
+		}
+	}
+	if best == 0 {
+		return 0, fmt.Errorf("failed to connect: %w", lastErr)
+	}
+	return best, nil
+}
+
+// serverAddrOf returns the address the client connects to for config, or ""
+// if it has none: that of the server of xray-core configs, and of the first
+// hop, the innermost part of the chain, of configurl ones.
+func serverAddrOf(config string) string {
+	if fallback, ok := smartFallback(config); ok {
+		return serverAddrOf(fallback)
+	}
+	if info, err := ValidateConfig(config); err == nil && info.Xray {
+		return net.JoinHostPort(info.Host, strconv.Itoa(info.Port))
+	}
+	cfg, err := configurl.ParseConfig(config)
+	if err != nil {
+		return ""
+	}
+	addr := ""
+	for ; cfg != nil; cfg = cfg.BaseConfig {
+		if cfg.URL.Port() != "" {
+			addr = cfg.URL.Host
+		}
+	}
+	return addr
+}
@@ -0,0 +1,28 @@
+package core
+
+import "testing"
+
+func TestServerAddrOf(t *testing.T) {
+	cases := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{name: "ss", config: "ss://aes-128-gcm:secret@1.2.3.4:8388", want: "1.2.3.4:8388"},
+		{name: "first hop of a chain", config: "ss://aes-128-gcm:secret@1.2.3.4:8388|socks5://5.6.7.8:1080", want: "1.2.3.4:8388"},
+		{name: "transport before the server", config: "tlsfrag:1|ss://aes-128-gcm:secret@1.2.3.4:8388", want: "1.2.3.4:8388"},
+		{name: "vless", config: "vless://uuid@Example.com:443?security=tls", want: "example.com:443"},
+		{name: "IPv6", config: "trojan://secret@[2001:db8::1]:443", want: "[2001:db8::1]:443"},
+		{name: "smart fallback", config: "smart:ss://aes-128-gcm:secret@1.2.3.4:8388", want: "1.2.3.4:8388"},
+		{name: "smart", config: "smart", want: ""},
+		{name: "direct", config: "tlsfrag:1", want: ""},
+		{name: "invalid", config: "::", want: ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := serverAddrOf(tc.config); got != tc.want {
+				t.Errorf("serverAddrOf(%q) = %q, want %q", tc.config, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.getoutline.org/sdk/transport"
+	"golang.getoutline.org/sdk/x/configurl"
+	"golang.getoutline.org/sdk/x/smart"
+)
+
+// smartPrefix starts the configs of the smart mode, which searches for a way
+// around blocking instead of using one transport: "smart" looks for a
+// strategy without a proxy, and "smart:" followed by a config also tries
+// that config, e.g. the access key of a server, if none works.
+const smartPrefix = "smart"
+
+// smartStrategies are what the smart mode tries: resolvers, from the system's
+// to DNS over HTTPS and TLS, and ways to get TLS past SNI-based blocking.
+const smartStrategies = `dns:
+  - system: {}
+  - https: { name: 8.8.8.8 }
+  - https: { name: 1.1.1.1 }
+  - https: { name: 9.9.9.9 }
+  - tls: { name: 8.8.8.8 }
+  - tls: { name: 1.1.1.1 }
+tls:
+  - ""
+  - split:1
+  - split:2,20*5
+  - tlsfrag:1
+  - split:1|tlsfrag:1
+  - override:host=cloudflare.net|tlsfrag:1
+`
+
+// smartTestDomains are the domains a strategy must reach, with TLS on port
+// 443, to be picked.
+var smartTestDomains = []string{"www.google.com", "www.youtube.com"}
+
+// smartTestTimeout is how long a strategy has to pass a test.
+const smartTestTimeout = 5 * time.Second
+
+// smartCache keeps the winning strategy, so connecting again tries it first.
+var smartCache = &memoryCache{}
+
+// IsSmartConfig reports whether config is of the smart mode.
+func IsSmartConfig(config string) bool {
+	_, ok := smartFallback(config)
+	return ok
+}
+
+// smartFallback returns the fallback of a config of the smart mode, and
+// whether config is one.
+func smartFallback(config string) (string, bool) {
+	if config == smartPrefix {
+		return "", true
+	}
+	if fallback, ok := strings.CutPrefix(config, smartPrefix+":"); ok {
+		return fallback, true
+	}
+	return "", false
+}
+
+// newStreamDialer creates the dialer of config, a configurl config or one of
+// the smart mode.
+func newStreamDialer(ctx context.Context, providers *configurl.ProviderContainer, config string) (transport.StreamDialer, error) {
+	fallback, ok := smartFallback(config)
+	if !ok {
+		return providers.NewStreamDialer(ctx, config)
+	}
+	strategies := smartStrategies
+	if fallback != "" {
+		// Checked first, for a clearer error than the finder's.
+		if _, err := providers.NewStreamDialer(ctx, fallback); err != nil {
+			return nil, fmt.Errorf("invalid fallback: %w", err)
+		}
+		// A JSON string is a YAML one.
+		quoted, _ := json.Marshal(fallback)
+		strategies += "fallback:\n  - " + string(quoted) + "\n"
+	}
+	finder := &smart.StrategyFinder{
+		TestTimeout:  smartTestTimeout,
+		LogWriter:    log.Writer(),
+		StreamDialer: &transport.TCPDialer{},
+		PacketDialer: &transport.UDPDialer{},
+		Cache:        smartCache,
+	}
+	dialer, err := finder.NewDialer(ctx, smartTestDomains, []byte(strategies))
+	if err != nil {
+		return nil, fmt.Errorf("no strategy of the smart mode works: %w", err)
+	}
+	return dialer, nil
+}
+
+// newPacketListener creates the packet listener of config. In the smart
+// mode, UDP goes through the fallback, or directly without one, as the
+// strategies are for TCP.
+func newPacketListener(ctx context.Context, providers *configurl.ProviderContainer, config string) (transport.PacketListener, error) {
+	if fallback, ok := smartFallback(config); ok {
+		config = fallback
+	}
+	return providers.NewPacketListener(ctx, config)
+}
+
+// memoryCache is a smart.StrategyResultCache in memory.
+type memoryCache struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func (c *memoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.values[key]
+	return value, ok
+}
+
+func (c *memoryCache) Put(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if value == nil {
+		delete(c.values, key)
+		return
+	}
+	if c.values == nil {
+		c.values = map[string][]byte{}
+	}
+	c.values[key] = value
+}
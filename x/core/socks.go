@@ -0,0 +1,127 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync/atomic"
+
+	"github.com/things-go/go-socks5"
+	"github.com/things-go/go-socks5/statute"
+	"golang.getoutline.org/sdk/transport"
+)
+
+// NewSOCKSServer returns a SOCKS5 server that connects through dialer and
+// relays the datagrams of UDP ASSOCIATE through listener. Domains are
+// resolved on the far side of dialer.
+func NewSOCKSServer(dialer transport.StreamDialer, listener transport.PacketListener) *socks5.Server {
+	return socks5.NewServer(
+		socks5.WithRule(socks5.NewPermitConnAndAss()),
+		socks5.WithResolver(remoteResolver{}),
+		socks5.WithDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialStream(ctx, addr)
+		}),
+		socks5.WithAssociateHandle(func(ctx context.Context, writer io.Writer, request *socks5.Request) error {
+			return handleAssociate(ctx, writer, request, listener)
+		}),
+		// It logs clients going away as errors.
+		socks5.WithLogger(socks5.NewLogger(log.New(io.Discard, "", 0))),
+	)
+}
+
+// remoteResolver leaves domains unresolved, so they are resolved on the
+// server instead of leaking to the local DNS.
+type remoteResolver struct{}
+
+func (remoteResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	return ctx, nil, nil
+}
+
+// handleAssociate relays the datagrams of a UDP association through
+// listener, for as long as the client keeps the TCP connection of the request
+// open.
+func handleAssociate(ctx context.Context, writer io.Writer, request *socks5.Request, listener transport.PacketListener) error {
+	// The client sends the datagrams to the address it sent the request to.
+	host, _, err := net.SplitHostPort(request.LocalAddr.String())
+	if err != nil {
+		host = "127.0.0.1"
+	}
+	relay, err := net.ListenPacket("udp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		socks5.SendReply(writer, statute.RepServerFailure, nil)
+		return fmt.Errorf("failed to listen for UDP: %w", err)
+	}
+	defer relay.Close()
+	remote, err := listener.ListenPacket(ctx)
+	if err != nil {
+		socks5.SendReply(writer, statute.RepServerFailure, nil)
+		return fmt.Errorf("failed to create packet connection: %w", err)
+	}
+	defer remote.Close()
+	if err := socks5.SendReply(writer, statute.RepSuccess, relay.LocalAddr()); err != nil {
+		return fmt.Errorf("failed to send reply: %w", err)
+	}
+
+	go func() {
+		io.Copy(io.Discard, request.Reader)
+		relay.Close()
+		remote.Close()
+	}()
+
+	// The client's address is the source of its first datagram.
+	var client atomic.Pointer[net.Addr]
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, from, err := remote.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			to := client.Load()
+			if to == nil {
+				continue
+			}
+			datagram, err := statute.NewDatagram(from.String(), buf[:n])
+			if err != nil {
+				continue
+			}
+			relay.WriteTo(datagram.Bytes(), *to)
+		}
+	}()
+
+	clientIP := addrIP(request.RemoteAddr)
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := relay.ReadFrom(buf)
+		if err != nil {
+			return nil
+		}
+		// Only the client that asked for the association may use it.
+		if !addrIP(from).Equal(clientIP) {
+			continue
+		}
+		datagram, err := statute.ParseDatagram(buf[:n])
+		// Fragments are rare and may be dropped.
+		if err != nil || datagram.Frag != 0 {
+			continue
+		}
+		client.Store(&from)
+		to, err := transport.MakeNetAddr("udp", datagram.DstAddr.String())
+		if err != nil {
+			continue
+		}
+		remote.WriteTo(datagram.Data, to)
+	}
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	}
+	return nil
+}
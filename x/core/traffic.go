@@ -0,0 +1,281 @@
+package core
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.getoutline.org/sdk/network"
+	"golang.getoutline.org/sdk/transport"
+)
+
+// TrafficStats is a snapshot of a TrafficCounter. The counts are int64 so
+// gomobile can bind them.
+type TrafficStats struct {
+	BytesUp   int64 `json:"bytesUp"`
+	BytesDown int64 `json:"bytesDown"`
+	// UpRate and DownRate are in bytes per second, over the time since the
+	// snapshot before this one of Report.
+	UpRate   float64 `json:"upRate"`
+	DownRate float64 `json:"downRate"`
+	// Connections counts the connections and UDP sessions opened, and
+	// ActiveConnections those still open.
+	Connections       int64 `json:"connections"`
+	ActiveConnections int64 `json:"activeConnections"`
+	// FailedDials counts the connections and UDP sessions that couldn't be
+	// opened.
+	FailedDials int64 `json:"failedDials"`
+	// DurationMs is the time since the counter was created or reset.
+	DurationMs int64 `json:"durationMs"`
+}
+
+// TrafficCounter counts the traffic of the dialers and proxies wrapped with
+// it, such as CountingStreamDialer, so proxy and TUN modes report the same
+// statistics. Up is what is sent to the network, down what comes back.
+type TrafficCounter struct {
+	up, down, conns, active, failed atomic.Int64
+
+	mu       sync.Mutex
+	since    time.Time
+	lastAt   time.Time // Of the last snapshot of Report
+	lastUp   int64
+	lastDown int64
+	upRate   float64
+	downRate float64
+}
+
+func NewTrafficCounter() *TrafficCounter {
+	c := &TrafficCounter{}
+	c.Reset()
+	return c
+}
+
+// Reset sets the counts to zero, as for a new connection. Connections still
+// open count as active until they close.
+func (c *TrafficCounter) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.up.Store(0)
+	c.down.Store(0)
+	c.conns.Store(0)
+	c.failed.Store(0)
+	c.since = time.Now()
+	c.lastAt = c.since
+	c.lastUp, c.lastDown, c.upRate, c.downRate = 0, 0, 0, 0
+}
+
+// Stats returns the counts as of now, with the rates of the last snapshot of
+// Report.
+func (c *TrafficCounter) Stats() *TrafficStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.statsLocked(time.Now())
+}
+
+func (c *TrafficCounter) statsLocked(now time.Time) *TrafficStats {
+	return &TrafficStats{
+		BytesUp:           c.up.Load(),
+		BytesDown:         c.down.Load(),
+		UpRate:            c.upRate,
+		DownRate:          c.downRate,
+		Connections:       c.conns.Load(),
+		ActiveConnections: c.active.Load(),
+		FailedDials:       c.failed.Load(),
+		DurationMs:        now.Sub(c.since).Milliseconds(),
+	}
+}
+
+// Report calls f with a snapshot every interval, until ctx is done.
+func (c *TrafficCounter) Report(ctx context.Context, interval time.Duration, f func(*TrafficStats)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			f(c.sample(now))
+		}
+	}
+}
+
+// sample takes a snapshot and updates the rates with it.
+func (c *TrafficCounter) sample(now time.Time) *TrafficStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	up, down := c.up.Load(), c.down.Load()
+	if elapsed := now.Sub(c.lastAt).Seconds(); elapsed > 0 {
+		c.upRate = float64(up-c.lastUp) / elapsed
+		c.downRate = float64(down-c.lastDown) / elapsed
+	}
+	c.lastUp, c.lastDown, c.lastAt = up, down, now
+	return c.statsLocked(now)
+}
+
+// CountUp returns a writer to w that counts what is written as sent, as for
+// packets from a TUN device to the network stack.
+func (c *TrafficCounter) CountUp(w io.Writer) io.Writer {
+	return countingWriter{w, &c.up}
+}
+
+// CountDown returns a writer to w that counts what is written as received.
+func (c *TrafficCounter) CountDown(w io.Writer) io.Writer {
+	return countingWriter{w, &c.down}
+}
+
+// opened counts a connection or UDP session, and returns the function to call
+// once it closes.
+func (c *TrafficCounter) opened() func() {
+	c.conns.Add(1)
+	c.active.Add(1)
+	var once sync.Once
+	return func() { once.Do(func() { c.active.Add(-1) }) }
+}
+
+// countingWriter adds the bytes written through it to n.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n.Add(int64(n))
+	return n, err
+}
+
+// CountingStreamDialer counts the connections Dialer dials and their bytes.
+type CountingStreamDialer struct {
+	Dialer  transport.StreamDialer
+	Counter *TrafficCounter
+}
+
+var _ transport.StreamDialer = (*CountingStreamDialer)(nil)
+
+func (d *CountingStreamDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	conn, err := d.Dialer.DialStream(ctx, addr)
+	if err != nil {
+		// Canceled dials didn't fail.
+		if ctx.Err() == nil {
+			d.Counter.failed.Add(1)
+		}
+		return nil, err
+	}
+	return &countingConn{StreamConn: conn, counter: d.Counter, closed: d.Counter.opened()}, nil
+}
+
+type countingConn struct {
+	transport.StreamConn
+	counter *TrafficCounter
+	closed  func()
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.StreamConn.Read(p)
+	c.counter.down.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.StreamConn.Write(p)
+	c.counter.up.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Close() error {
+	c.closed()
+	return c.StreamConn.Close()
+}
+
+// CountingPacketListener counts the sockets Listener opens, as UDP sessions,
+// and the bytes of their packets.
+type CountingPacketListener struct {
+	Listener transport.PacketListener
+	Counter  *TrafficCounter
+}
+
+var _ transport.PacketListener = (*CountingPacketListener)(nil)
+
+func (l *CountingPacketListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	conn, err := l.Listener.ListenPacket(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			l.Counter.failed.Add(1)
+		}
+		return nil, err
+	}
+	return &countingPacketConn{PacketConn: conn, counter: l.Counter, closed: l.Counter.opened()}, nil
+}
+
+type countingPacketConn struct {
+	net.PacketConn
+	counter *TrafficCounter
+	closed  func()
+}
+
+func (c *countingPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	c.counter.down.Add(int64(n))
+	return n, addr, err
+}
+
+func (c *countingPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(p, addr)
+	c.counter.up.Add(int64(n))
+	return n, err
+}
+
+func (c *countingPacketConn) Close() error {
+	c.closed()
+	return c.PacketConn.Close()
+}
+
+// CountingPacketProxy counts the UDP sessions of Proxy and the bytes of their
+// packets, for the network stack of a TUN device.
+type CountingPacketProxy struct {
+	Proxy   network.PacketProxy
+	Counter *TrafficCounter
+}
+
+var _ network.PacketProxy = (*CountingPacketProxy)(nil)
+
+func (p *CountingPacketProxy) NewSession(receiver network.PacketResponseReceiver) (network.PacketRequestSender, error) {
+	sender, err := p.Proxy.NewSession(&countingReceiver{receiver, p.Counter})
+	if err != nil {
+		p.Counter.failed.Add(1)
+		return nil, err
+	}
+	return &countingSender{PacketRequestSender: sender, counter: p.Counter, closed: p.Counter.opened()}, nil
+}
+
+type countingSender struct {
+	network.PacketRequestSender
+	counter *TrafficCounter
+	closed  func()
+}
+
+func (s *countingSender) WriteTo(p []byte, destination netip.AddrPort) (int, error) {
+	n, err := s.PacketRequestSender.WriteTo(p, destination)
+	s.counter.up.Add(int64(n))
+	return n, err
+}
+
+func (s *countingSender) Close() error {
+	s.closed()
+	return s.PacketRequestSender.Close()
+}
+
+type countingReceiver struct {
+	network.PacketResponseReceiver
+	counter *TrafficCounter
+}
+
+func (r *countingReceiver) WriteFrom(p []byte, source net.Addr) (int, error) {
+	n, err := r.PacketResponseReceiver.WriteFrom(p, source)
+	r.counter.down.Add(int64(n))
+	return n, err
+}
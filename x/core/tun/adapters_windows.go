@@ -0,0 +1,155 @@
+package tun
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// netClassGUID is GUID_DEVCLASS_NET, the device class of network adapters.
+var netClassGUID = windows.GUID{
+	Data1: 0x4d36e972,
+	Data2: 0xe325,
+	Data3: 0x11ce,
+	Data4: [8]byte{0xbf, 0xc1, 0x08, 0x00, 0x2b, 0xe1, 0x03, 0x18},
+}
+
+// networkListKey holds the network profiles Windows keeps for the networks
+// it has seen, and the signatures that match adapters to them.
+const networkListKey = `SOFTWARE\Microsoft\Windows NT\CurrentVersion\NetworkList`
+
+// driverName is the hardware ID of Wintun adapters.
+const driverName = "Wintun"
+
+// RemoveOrphanedAdapters removes the Wintun adapters named name, which earlier
+// runs that crashed left behind, also when unplugged, and the network profiles
+// Windows added for duplicates of them. No adapter of that name may be open.
+func RemoveOrphanedAdapters(name string) error {
+	devices, err := windows.SetupDiGetClassDevsEx(&netClassGUID, "", 0, 0, 0, "")
+	if err != nil {
+		return fmt.Errorf("failed to list network adapters: %w", err)
+	}
+	defer devices.Close()
+
+	var errs []error
+	for i := 0; ; i++ {
+		device, err := devices.EnumDeviceInfo(i)
+		if errors.Is(err, windows.ERROR_NO_MORE_ITEMS) {
+			break
+		}
+		if err != nil {
+			continue
+		}
+		if !isAdapterNamed(devices, device, name) {
+			continue
+		}
+		log.Printf("Wintun: removing an adapter %s left by a previous run\n", name)
+		params := windows.RemoveDeviceParams{
+			ClassInstallHeader: *windows.MakeClassInstallHeader(windows.DIF_REMOVE),
+			Scope:              windows.DI_REMOVEDEVICE_GLOBAL,
+		}
+		if err := devices.SetClassInstallParams(device, &params.ClassInstallHeader, uint32(unsafe.Sizeof(params))); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove adapter: %w", err))
+			continue
+		}
+		if err := devices.CallClassInstaller(windows.DIF_REMOVE, device); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove adapter: %w", err))
+		}
+	}
+	errs = append(errs, removeDuplicateNetworkProfiles(name))
+	return errors.Join(errs...)
+}
+
+// isAdapterNamed reports whether device is a Wintun adapter named name, or
+// with a number after it.
+func isAdapterNamed(devices windows.DevInfo, device *windows.DevInfoData, name string) bool {
+	ids, _ := devices.DeviceRegistryProperty(device, windows.SPDRP_HARDWAREID)
+	if ids, ok := ids.([]string); !ok || !slices.ContainsFunc(ids, func(id string) bool { return strings.EqualFold(id, driverName) }) {
+		return false
+	}
+	for _, property := range []windows.SPDRP{windows.SPDRP_FRIENDLYNAME, windows.SPDRP_DEVICEDESC} {
+		if s, ok := devicePropertyString(devices, device, property); ok && strings.HasPrefix(s, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func devicePropertyString(devices windows.DevInfo, device *windows.DevInfoData, property windows.SPDRP) (string, bool) {
+	value, err := devices.DeviceRegistryProperty(device, property)
+	if err != nil {
+		return "", false
+	}
+	s, ok := value.(string)
+	return s, ok
+}
+
+// removeDuplicateNetworkProfiles removes the network profiles named like
+// "<adapter> 2", which Windows added for adapters of earlier runs. The one
+// named after the adapter stays, with what the user set on it.
+func removeDuplicateNetworkProfiles(adapter string) error {
+	profiles, err := registry.OpenKey(registry.LOCAL_MACHINE, networkListKey+`\Profiles`, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return fmt.Errorf("failed to open network profiles: %w", err)
+	}
+	defer profiles.Close()
+	guids, err := profiles.ReadSubKeyNames(-1)
+	if err != nil {
+		return fmt.Errorf("failed to list network profiles: %w", err)
+	}
+	var removed []string
+	for _, guid := range guids {
+		k, err := registry.OpenKey(profiles, guid, registry.QUERY_VALUE)
+		if err != nil {
+			continue
+		}
+		name, _, err := k.GetStringValue("ProfileName")
+		k.Close()
+		if err != nil || !isDuplicateAdapterName(name, adapter) {
+			continue
+		}
+		if err := registry.DeleteKey(profiles, guid); err != nil {
+			return fmt.Errorf("failed to remove network profile %q: %w", name, err)
+		}
+		removed = append(removed, guid)
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	log.Printf("Wintun: removed %d duplicate network profiles\n", len(removed))
+
+	// The signatures of the removed profiles would point nowhere.
+	for _, kind := range []string{"Managed", "Unmanaged"} {
+		signatures, err := registry.OpenKey(registry.LOCAL_MACHINE, networkListKey+`\Signatures\`+kind, registry.ENUMERATE_SUB_KEYS)
+		if err != nil {
+			continue
+		}
+		names, _ := signatures.ReadSubKeyNames(-1)
+		for _, name := range names {
+			k, err := registry.OpenKey(signatures, name, registry.QUERY_VALUE)
+			if err != nil {
+				continue
+			}
+			guid, _, err := k.GetStringValue("ProfileGuid")
+			k.Close()
+			if err == nil && slices.ContainsFunc(removed, func(r string) bool { return strings.EqualFold(r, guid) }) {
+				registry.DeleteKey(signatures, name)
+			}
+		}
+		signatures.Close()
+	}
+	return nil
+}
+
+// isDuplicateAdapterName reports whether name is the adapter's name with a
+// number, as Windows names duplicates.
+func isDuplicateAdapterName(name, adapter string) bool {
+	n, ok := strings.CutPrefix(name, adapter+" ")
+	return ok && n != "" && strings.Trim(n, "0123456789") == ""
+}
@@ -0,0 +1,279 @@
+package tun
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// FullTunnel covers all addresses with two halves, which take precedence
+// over the default route without replacing it.
+var FullTunnel = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/1"),
+	netip.MustParsePrefix("128.0.0.0/1"),
+	netip.MustParsePrefix("::/1"),
+	netip.MustParsePrefix("8000::/1"),
+}
+
+// LANRanges are the local network: private, link-local and multicast
+// addresses, which FullTunnel would otherwise swallow.
+var LANRanges = []netip.Prefix{
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+// Routes are the routes of a VPN connection, each list sorted without
+// duplicates.
+type Routes struct {
+	// Tunneled are routed through the Device.
+	Tunneled []netip.Prefix
+	// Bypass are routed through the default gateway, around the tunnel.
+	Bypass []netip.Prefix
+}
+
+// Equal reports whether both have the same routes.
+func (r Routes) Equal(other Routes) bool {
+	return slices.Equal(r.Tunneled, other.Tunneled) && slices.Equal(r.Bypass, other.Bypass)
+}
+
+// RoutePlan describes the routes of a connection, which Routes computes.
+type RoutePlan struct {
+	// Servers are routed around the tunnel, so it doesn't loop into itself.
+	Servers []netip.Addr
+	// Include, if not empty, limits the tunnel to these destinations.
+	// Otherwise everything goes through it, with FullTunnel.
+	Include []netip.Prefix
+	// Exclude are routed around the tunnel.
+	Exclude []netip.Prefix
+	// TunnelLAN sends the local network through the full tunnel too. By
+	// default LANRanges bypass it, so printers, NAS and casting devices keep
+	// working while connected.
+	TunnelLAN bool
+}
+
+// Routes returns the routes of the plan.
+func (p RoutePlan) Routes() Routes {
+	routes := Routes{
+		Tunneled: slices.Clone(p.Include),
+		Bypass:   slices.Clone(p.Exclude),
+	}
+	if len(p.Include) == 0 {
+		routes.Tunneled = slices.Clone(FullTunnel)
+		if !p.TunnelLAN {
+			routes.Bypass = append(routes.Bypass, LANRanges...)
+		}
+	}
+	for _, ip := range p.Servers {
+		ip = ip.Unmap()
+		routes.Bypass = append(routes.Bypass, netip.PrefixFrom(ip, ip.BitLen()))
+	}
+	return routes.normalize()
+}
+
+// normalize masks, sorts and dedupes the routes.
+func (r Routes) normalize() Routes {
+	return Routes{Tunneled: sortPrefixes(r.Tunneled), Bypass: sortPrefixes(r.Bypass)}
+}
+
+// sortPrefixes returns the prefixes masked and sorted without duplicates.
+func sortPrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	var sorted []netip.Prefix
+	for _, p := range prefixes {
+		if p.IsValid() {
+			sorted = append(sorted, p.Masked())
+		}
+	}
+	slices.SortFunc(sorted, func(a, b netip.Prefix) int {
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c
+		}
+		return a.Bits() - b.Bits()
+	})
+	return slices.Compact(sorted)
+}
+
+// RouteManager programs the Routes of a Device with the system's routing
+// table. Apply changes only what differs from the routes applied before, and
+// undoes its changes when one fails, so the routes are either the old or the
+// new ones. The routes around the tunnel, which outlive the device, are
+// written to a journal before they are added, so RecoverRoutes can remove
+// them after a crash; the ones through it go away with the device.
+type RouteManager struct {
+	dev     Device
+	journal string
+
+	mu      sync.Mutex
+	applied Routes
+}
+
+// NewRouteManager creates a manager for the routes of dev, with its journal
+// at the path journal.
+func NewRouteManager(dev Device, journal string) *RouteManager {
+	return &RouteManager{dev: dev, journal: journal}
+}
+
+// Routes returns the routes applied.
+func (m *RouteManager) Routes() Routes {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.applied
+}
+
+// Apply programs routes in place of the ones applied before. New routes are
+// added before old ones are removed, so traffic is never left without one.
+// IPv6 routes around the tunnel are skipped when there is no IPv6 gateway.
+// On failure the changes made are undone and the old routes stay.
+func (m *RouteManager) Apply(routes Routes) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	routes = routes.normalize()
+	removedTunneled := missingPrefixes(m.applied.Tunneled, routes.Tunneled)
+	removedBypass := missingPrefixes(m.applied.Bypass, routes.Bypass)
+	addedTunneled := missingPrefixes(routes.Tunneled, m.applied.Tunneled)
+	addedBypass := missingPrefixes(routes.Bypass, m.applied.Bypass)
+	if len(removedTunneled)+len(removedBypass)+len(addedTunneled)+len(addedBypass) == 0 {
+		return nil
+	}
+	log.Printf("Routes: applying %d through the tunnel, %d around it\n", len(routes.Tunneled), len(routes.Bypass))
+
+	// Both the old and the new routes around the tunnel may be in the table
+	// until this returns.
+	if err := m.writeJournal(sortPrefixes(append(slices.Clone(m.applied.Bypass), addedBypass...))); err != nil {
+		return err
+	}
+	var gw gateways
+	if len(addedBypass)+len(removedBypass) > 0 {
+		var err error
+		if gw, err = defaultGateways(m.dev); err != nil {
+			return m.rollback(nil, fmt.Errorf("failed to apply routes: %w", err))
+		}
+	}
+
+	var undo []func() error
+	for _, p := range addedBypass {
+		if err := gw.addRoute(p); err != nil {
+			return m.rollback(undo, fmt.Errorf("failed to route %s around the tunnel: %w", p, err))
+		}
+		undo = append(undo, func() error { return removeGatewayRoutes([]netip.Prefix{p}) })
+	}
+	for _, p := range addedTunneled {
+		if err := addDeviceRoute(m.dev, p); err != nil {
+			return m.rollback(undo, fmt.Errorf("failed to route %s through the tunnel: %w", p, err))
+		}
+		undo = append(undo, func() error { return deleteDeviceRoute(m.dev, p) })
+	}
+	for _, p := range removedTunneled {
+		if err := deleteDeviceRoute(m.dev, p); err != nil {
+			return m.rollback(undo, fmt.Errorf("failed to remove the route of %s through the tunnel: %w", p, err))
+		}
+		undo = append(undo, func() error { return addDeviceRoute(m.dev, p) })
+	}
+	for _, p := range removedBypass {
+		if err := removeGatewayRoutes([]netip.Prefix{p}); err != nil {
+			return m.rollback(undo, fmt.Errorf("failed to remove the route of %s around the tunnel: %w", p, err))
+		}
+		undo = append(undo, func() error { return gw.addRoute(p) })
+	}
+	m.applied = routes
+	if err := m.writeJournal(routes.Bypass); err != nil {
+		log.Printf("Routes: %v\n", err)
+	}
+	return nil
+}
+
+// rollback undoes the changes of a failed Apply, the last first, and returns
+// err.
+func (m *RouteManager) rollback(undo []func() error, err error) error {
+	for i := len(undo) - 1; i >= 0; i-- {
+		if err := undo[i](); err != nil {
+			log.Printf("Routes: failed to roll back: %v\n", err)
+		}
+	}
+	if err := m.writeJournal(m.applied.Bypass); err != nil {
+		log.Printf("Routes: %v\n", err)
+	}
+	return err
+}
+
+// Close removes the routes around the tunnel and the journal. The routes
+// through it go away with the device, which they keep from leaking until it
+// is closed.
+func (m *RouteManager) Close() error {
+	m.mu.Lock()
+	tunneled := m.applied.Tunneled
+	m.mu.Unlock()
+	return m.Apply(Routes{Tunneled: tunneled})
+}
+
+// routeJournal is what the journal of a RouteManager lists.
+type routeJournal struct {
+	Bypass []netip.Prefix `json:"bypass,omitempty"`
+}
+
+// writeJournal lists bypass in the journal, which is removed once empty.
+func (m *RouteManager) writeJournal(bypass []netip.Prefix) error {
+	if len(bypass) == 0 {
+		if err := os.Remove(m.journal); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove route journal: %w", err)
+		}
+		return nil
+	}
+	data, _ := json.Marshal(routeJournal{Bypass: bypass})
+	if err := os.MkdirAll(filepath.Dir(m.journal), 0700); err != nil {
+		return fmt.Errorf("failed to save route journal: %w", err)
+	}
+	if err := os.WriteFile(m.journal, data, 0600); err != nil {
+		return fmt.Errorf("failed to save route journal: %w", err)
+	}
+	return nil
+}
+
+// RecoverRoutes removes the routes around the tunnel listed in the journal at
+// path, which a RouteManager that never closed left behind, and the journal.
+// It must not be called while a RouteManager uses the journal.
+func RecoverRoutes(journal string) error {
+	data, err := os.ReadFile(journal)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read route journal: %w", err)
+	}
+	var state routeJournal
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Printf("Routes: ignoring a broken journal: %v\n", err)
+	}
+	if len(state.Bypass) > 0 {
+		log.Printf("Routes: removing %d routes left by a previous session\n", len(state.Bypass))
+		if err := removeGatewayRoutes(state.Bypass); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(journal); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove route journal: %w", err)
+	}
+	return nil
+}
+
+// missingPrefixes returns the prefixes of a that are not in b.
+func missingPrefixes(a, b []netip.Prefix) []netip.Prefix {
+	var missing []netip.Prefix
+	for _, p := range a {
+		if !slices.Contains(b, p) {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,136 @@
+package tun
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strings"
+)
+
+// darwinRoute is a default route as netstat -rn prints it.
+type darwinRoute struct {
+	gateway string // Empty for routes to the interface
+	netif   string
+}
+
+// route runs route(8) with args.
+func route(args ...string) error {
+	out, err := exec.Command("route", append([]string{"-q", "-n"}, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("route %s failed: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// isNoRoute reports whether route failed for a route that doesn't exist, or
+// only added one that did.
+func isNoRoute(err error) bool {
+	return strings.Contains(err.Error(), "not in table") || strings.Contains(err.Error(), "File exists")
+}
+
+// familyFlag returns the route(8) flag of p's family.
+func familyFlag(p netip.Prefix) string {
+	if p.Addr().Is6() {
+		return "-inet6"
+	}
+	return "-inet"
+}
+
+func addDeviceRoute(dev Device, p netip.Prefix) error {
+	err := route("add", familyFlag(p), "-net", p.String(), "-interface", dev.Name())
+	if err != nil && !isNoRoute(err) {
+		return err
+	}
+	return nil
+}
+
+func deleteDeviceRoute(dev Device, p netip.Prefix) error {
+	err := route("delete", familyFlag(p), "-net", p.String(), "-interface", dev.Name())
+	if err != nil && !isNoRoute(err) {
+		return err
+	}
+	return nil
+}
+
+// gateways are the default routes of the physical network, which routes
+// around the tunnel go through.
+type gateways struct {
+	v4, v6 *darwinRoute // nil without one
+}
+
+// defaultGateways returns the first default routes that don't go through dev.
+// They are read from the table, as route get default would resolve through
+// FullTunnel.
+func defaultGateways(dev Device) (gateways, error) {
+	var g gateways
+	for _, family := range []string{"inet", "inet6"} {
+		out, err := exec.Command("netstat", "-rn", "-f", family).Output()
+		if err != nil {
+			return g, fmt.Errorf("failed to list routes: %w", err)
+		}
+		var best *darwinRoute
+		scanner := bufio.NewScanner(bytes.NewReader(out))
+		for scanner.Scan() {
+			// Destination, Gateway, Flags, Netif and Expire.
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 4 || fields[0] != "default" || fields[3] == dev.Name() {
+				continue
+			}
+			// Routes scoped to their interface only apply to sockets bound
+			// to it.
+			if strings.Contains(fields[2], "I") {
+				continue
+			}
+			r := &darwinRoute{gateway: fields[1], netif: fields[3]}
+			if strings.HasPrefix(r.gateway, "link#") {
+				r.gateway = ""
+			}
+			best = r
+			break
+		}
+		if family == "inet" {
+			g.v4 = best
+		} else {
+			g.v6 = best
+		}
+	}
+	return g, nil
+}
+
+// addRoute routes p through the default gateway of its family. IPv6 prefixes
+// are skipped without an IPv6 gateway.
+func (g gateways) addRoute(p netip.Prefix) error {
+	def := g.v4
+	if p.Addr().Is6() {
+		if g.v6 == nil {
+			return nil
+		}
+		def = g.v6
+	}
+	if def == nil {
+		return errors.New("no default gateway found")
+	}
+	var err error
+	if def.gateway == "" {
+		err = route("add", familyFlag(p), "-net", p.String(), "-interface", def.netif)
+	} else {
+		err = route("add", familyFlag(p), "-net", p.String(), def.gateway)
+	}
+	if err != nil && !isNoRoute(err) {
+		return err
+	}
+	return nil
+}
+
+// removeGatewayRoutes removes the routes to prefixes around the tunnel.
+func removeGatewayRoutes(prefixes []netip.Prefix) error {
+	for _, p := range prefixes {
+		if err := route("delete", familyFlag(p), "-net", p.String()); err != nil && !isNoRoute(err) {
+			return fmt.Errorf("failed to remove route to %s: %w", p, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package tun
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strings"
+)
+
+// linuxRoute is a route as ip -j prints it.
+type linuxRoute struct {
+	Dst     string `json:"dst"`
+	Gateway string `json:"gateway"`
+	Dev     string `json:"dev"`
+	Metric  int    `json:"metric"`
+}
+
+// listRoutes returns the routes ip -j prints with args, which show them.
+func listRoutes(args ...string) ([]linuxRoute, error) {
+	out, err := exec.Command("ip", append([]string{"-j"}, args...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes: %w", err)
+	}
+	var routes []linuxRoute
+	if err := json.Unmarshal(out, &routes); err != nil {
+		return nil, fmt.Errorf("failed to list routes: %w", err)
+	}
+	return routes, nil
+}
+
+func addDeviceRoute(dev Device, p netip.Prefix) error {
+	return ip("route", "replace", p.String(), "dev", dev.Name())
+}
+
+func deleteDeviceRoute(dev Device, p netip.Prefix) error {
+	err := ip("route", "del", p.String(), "dev", dev.Name())
+	if err != nil && !isNoRoute(err) {
+		return err
+	}
+	return nil
+}
+
+// isNoRoute reports whether ip failed for a route that doesn't exist.
+func isNoRoute(err error) bool {
+	return strings.Contains(err.Error(), "No such process")
+}
+
+// gateways are the default routes of the physical network, which routes
+// around the tunnel go through.
+type gateways struct {
+	v4, v6 *linuxRoute // nil without one
+}
+
+// defaultGateways returns the default routes, of the lowest metric, that
+// don't go through dev.
+func defaultGateways(dev Device) (gateways, error) {
+	var g gateways
+	for _, family := range []string{"-4", "-6"} {
+		routes, err := listRoutes(family, "route", "show", "default")
+		if err != nil {
+			return g, err
+		}
+		var best *linuxRoute
+		for i := range routes {
+			r := &routes[i]
+			if r.Dev == dev.Name() {
+				continue
+			}
+			if best == nil || r.Metric < best.Metric {
+				best = r
+			}
+		}
+		if family == "-4" {
+			g.v4 = best
+		} else {
+			g.v6 = best
+		}
+	}
+	return g, nil
+}
+
+// addRoute routes p through the default gateway of its family. IPv6 prefixes
+// are skipped without an IPv6 gateway.
+func (g gateways) addRoute(p netip.Prefix) error {
+	def := g.v4
+	if p.Addr().Is6() {
+		if g.v6 == nil {
+			return nil
+		}
+		def = g.v6
+	}
+	if def == nil {
+		return errors.New("no default gateway found")
+	}
+	args := []string{"route", "replace", p.String()}
+	if def.Gateway != "" {
+		args = append(args, "via", def.Gateway)
+	}
+	return ip(append(args, "dev", def.Dev)...)
+}
+
+// removeGatewayRoutes removes the routes to prefixes through a gateway.
+// On-link routes, like the ones through the TUN and the system's own, stay.
+func removeGatewayRoutes(prefixes []netip.Prefix) error {
+	for _, p := range prefixes {
+		routes, err := listRoutes("route", "show", "to", "exact", p.String())
+		if err != nil {
+			return err
+		}
+		for _, r := range routes {
+			if r.Gateway == "" {
+				continue
+			}
+			err := ip("route", "del", p.String(), "via", r.Gateway, "dev", r.Dev)
+			if err != nil && !isNoRoute(err) {
+				return fmt.Errorf("failed to remove route to %s: %w", p, err)
+			}
+		}
+	}
+	return nil
+}
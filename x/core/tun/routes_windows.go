@@ -0,0 +1,143 @@
+package tun
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"golang.org/x/sys/windows"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
+)
+
+// deviceLUID returns the LUID of the adapter of dev, which routes through it
+// are added on.
+func deviceLUID(dev Device) (winipcfg.LUID, error) {
+	w, ok := dev.(*Wintun)
+	if !ok {
+		return 0, fmt.Errorf("%s is not a Wintun adapter", dev.Name())
+	}
+	return w.LUID(), nil
+}
+
+func addDeviceRoute(dev Device, p netip.Prefix) error {
+	luid, err := deviceLUID(dev)
+	if err != nil {
+		return err
+	}
+	err = luid.AddRoute(p, unspecifiedAddr(p), 1)
+	if err != nil && !errors.Is(err, windows.ERROR_OBJECT_ALREADY_EXISTS) {
+		return err
+	}
+	return nil
+}
+
+func deleteDeviceRoute(dev Device, p netip.Prefix) error {
+	luid, err := deviceLUID(dev)
+	if err != nil {
+		return err
+	}
+	err = luid.DeleteRoute(p, unspecifiedAddr(p))
+	if err != nil && !errors.Is(err, windows.ERROR_NOT_FOUND) {
+		return err
+	}
+	return nil
+}
+
+// gateways are the default routes of the physical network, which routes
+// around the tunnel go through.
+type gateways struct {
+	v4, v6 *winipcfg.MibIPforwardRow2 // nil without one
+}
+
+// defaultGateways returns the default routes, of the lowest metric, that
+// don't go through dev.
+func defaultGateways(dev Device) (gateways, error) {
+	var g gateways
+	tun, err := deviceLUID(dev)
+	if err != nil {
+		return g, err
+	}
+	for _, family := range []winipcfg.AddressFamily{windows.AF_INET, windows.AF_INET6} {
+		rows, err := winipcfg.GetIPForwardTable2(family)
+		if err != nil {
+			return g, fmt.Errorf("failed to list routes: %w", err)
+		}
+		var best *winipcfg.MibIPforwardRow2
+		var bestMetric uint32
+		for i := range rows {
+			r := &rows[i]
+			if r.DestinationPrefix.PrefixLength != 0 || r.InterfaceLUID == tun {
+				continue
+			}
+			// Windows ranks routes by their metric plus their interface's.
+			metric := r.Metric
+			if ipif, err := r.InterfaceLUID.IPInterface(family); err == nil {
+				metric += ipif.Metric
+			}
+			if best == nil || metric < bestMetric {
+				best, bestMetric = r, metric
+			}
+		}
+		if family == windows.AF_INET {
+			g.v4 = best
+		} else {
+			g.v6 = best
+		}
+	}
+	return g, nil
+}
+
+// addRoute routes p through the default gateway of its family. IPv6 prefixes
+// are skipped without an IPv6 gateway.
+func (g gateways) addRoute(p netip.Prefix) error {
+	def := g.v4
+	if p.Addr().Is6() {
+		if g.v6 == nil {
+			return nil
+		}
+		def = g.v6
+	}
+	if def == nil {
+		return errors.New("no default gateway found")
+	}
+	err := def.InterfaceLUID.AddRoute(p, def.NextHop.Addr(), 1)
+	if err != nil && !errors.Is(err, windows.ERROR_OBJECT_ALREADY_EXISTS) {
+		return err
+	}
+	return nil
+}
+
+// removeGatewayRoutes removes the routes to prefixes through a gateway. On-link
+// routes, like the ones through the TUN and the system's own, e.g. of
+// multicast, stay.
+func removeGatewayRoutes(prefixes []netip.Prefix) error {
+	if len(prefixes) == 0 {
+		return nil
+	}
+	for _, family := range []winipcfg.AddressFamily{windows.AF_INET, windows.AF_INET6} {
+		rows, err := winipcfg.GetIPForwardTable2(family)
+		if err != nil {
+			return fmt.Errorf("failed to list routes: %w", err)
+		}
+		for i := range rows {
+			r := &rows[i]
+			if !slices.Contains(prefixes, r.DestinationPrefix.Prefix()) || r.NextHop.Addr().IsUnspecified() {
+				continue
+			}
+			if err := r.Delete(); err != nil && !errors.Is(err, windows.ERROR_NOT_FOUND) {
+				return fmt.Errorf("failed to remove route to %s: %w", r.DestinationPrefix.Prefix(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// unspecifiedAddr returns the unspecified address of p's family, the next
+// hop of on-link routes.
+func unspecifiedAddr(p netip.Prefix) netip.Addr {
+	if p.Addr().Is6() {
+		return netip.IPv6Unspecified()
+	}
+	return netip.IPv4Unspecified()
+}
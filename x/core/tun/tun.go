@@ -0,0 +1,62 @@
+// Package tun creates TUN devices, the virtual network interfaces of VPN apps,
+// whose IP packets the app reads and writes instead of the system sending
+// them: Wintun adapters on Windows, /dev/net/tun on Linux and utun on macOS.
+// Creating and configuring them needs administrator rights.
+package tun
+
+import (
+	"cmp"
+	"io"
+	"net/netip"
+)
+
+const (
+	// DefaultMTU is the MTU of a Device without one.
+	DefaultMTU = 1500
+	// DefaultRingSize is the size in bytes of the ring buffers between a
+	// Wintun adapter and the app without one.
+	DefaultRingSize = 4 << 20
+)
+
+// Device is a TUN device. Each Read returns one IP packet, which is cut
+// short if p is smaller than it, and each Write sends one. Read and Write
+// may be called concurrently; Close stops a blocked Read.
+type Device interface {
+	io.ReadWriteCloser
+	// Name returns the name of the network interface.
+	Name() string
+	// MTU returns the largest packet the device takes.
+	MTU() int
+	// Configure sets the addresses of the device, each with the prefix of
+	// its subnet, and brings it up. IPv4 addresses, if any, replace the ones
+	// it had; IPv6 ones are added.
+	Configure(addrs ...netip.Prefix) error
+}
+
+// Options configure a new Device.
+type Options struct {
+	// Name is the name of the interface. On macOS the system names it,
+	// utunN; on Linux empty lets it pick tunN. On Windows it is also the
+	// name of the adapters that New removes when earlier runs left them
+	// behind, and empty means "DrFrakeVPN".
+	Name string
+	// MTU is the largest packet the device takes, DefaultMTU if 0.
+	MTU int
+	// GUID is the GUID of the Windows adapter, like
+	// "{ff9f47d1-9b0f-4d0a-a59c-5584f653cfc2}". With the same one on every
+	// run, Windows sees the same adapter and keeps one network profile for
+	// it. Empty picks a new one.
+	GUID string
+	// RingSize is the size in bytes of the ring buffers between a Wintun
+	// adapter and the app, a power of 2 from 128 KiB to 64 MiB, or
+	// DefaultRingSize if 0. Larger ones absorb bursts of traffic.
+	RingSize uint32
+}
+
+// New creates a TUN device with opts. The caller closes it, which removes
+// it along with its addresses and routes.
+func New(opts Options) (Device, error) {
+	opts.MTU = cmp.Or(opts.MTU, DefaultMTU)
+	opts.RingSize = cmp.Or(opts.RingSize, DefaultRingSize)
+	return newDevice(opts)
+}
@@ -0,0 +1,159 @@
+package tun
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	utunControlName = "com.apple.net.utun_control"
+	// utunOptIfname is UTUN_OPT_IFNAME, the option with the device's name.
+	utunOptIfname = 2
+	// utunHeaderSize is the size of the protocol family before each packet.
+	utunHeaderSize = 4
+)
+
+// darwinDevice is a Device on a utun device, configured with ifconfig(8).
+type darwinDevice struct {
+	file *os.File
+	name string
+	mtu  int
+
+	readMu   sync.Mutex
+	readBuf  []byte
+	writeMu  sync.Mutex
+	writeBuf []byte
+}
+
+func newDevice(opts Options) (Device, error) {
+	fd, err := unix.Socket(unix.AF_SYSTEM, unix.SOCK_DGRAM, unix.AF_SYS_CONTROL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TUN device: %w", err)
+	}
+	info := &unix.CtlInfo{}
+	copy(info.Name[:], utunControlName)
+	if err := unix.IoctlCtlInfo(fd, info); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to create TUN device: %w", err)
+	}
+	// Unit 0 lets the system pick the first free utunN.
+	if err := unix.Connect(fd, &unix.SockaddrCtl{ID: info.Id, Unit: 0}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to create TUN device: %w", err)
+	}
+	name, err := unix.GetsockoptString(fd, unix.AF_SYS_CONTROL, utunOptIfname)
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to create TUN device: %w", err)
+	}
+	// Non-blocking, so the file reads through the poller and Close stops a
+	// blocked Read.
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to create TUN device: %w", err)
+	}
+	d := &darwinDevice{
+		file:     os.NewFile(uintptr(fd), name),
+		name:     name,
+		mtu:      opts.MTU,
+		readBuf:  make([]byte, utunHeaderSize+opts.MTU),
+		writeBuf: make([]byte, utunHeaderSize+opts.MTU),
+	}
+	if err := ifconfig(name, "mtu", strconv.Itoa(d.mtu)); err != nil {
+		d.file.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *darwinDevice) Name() string {
+	return d.name
+}
+
+func (d *darwinDevice) MTU() int {
+	return d.mtu
+}
+
+func (d *darwinDevice) Read(p []byte) (int, error) {
+	d.readMu.Lock()
+	defer d.readMu.Unlock()
+	n, err := d.file.Read(d.readBuf)
+	if err != nil {
+		return 0, err
+	}
+	if n < utunHeaderSize {
+		return 0, nil
+	}
+	return copy(p, d.readBuf[utunHeaderSize:n]), nil
+}
+
+func (d *darwinDevice) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	family := uint32(unix.AF_INET)
+	if p[0]>>4 == 6 {
+		family = unix.AF_INET6
+	}
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	d.writeBuf = append(binary.BigEndian.AppendUint32(d.writeBuf[:0], family), p...)
+	if _, err := d.file.Write(d.writeBuf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close removes the device, with the addresses and routes on it.
+func (d *darwinDevice) Close() error {
+	return d.file.Close()
+}
+
+// Configure sets the addresses of the device. utun devices are
+// point-to-point, so the IPv4 subnets are routed to it explicitly.
+func (d *darwinDevice) Configure(addrs ...netip.Prefix) error {
+	for _, p := range addrs {
+		if !p.IsValid() {
+			return fmt.Errorf("invalid TUN address %s", p)
+		}
+		if p.Addr().Is6() {
+			if err := ifconfig(d.name, "inet6", p.Addr().String(), "prefixlen", strconv.Itoa(p.Bits()), "alias"); err != nil {
+				return err
+			}
+			continue
+		}
+		addr := p.Addr().String()
+		if err := ifconfig(d.name, "inet", addr, addr, "netmask", netmask(p.Bits()), "up"); err != nil {
+			return err
+		}
+		if p.Bits() < 32 {
+			if err := addDeviceRoute(d, p.Masked()); err != nil {
+				return err
+			}
+		}
+	}
+	return ifconfig(d.name, "up")
+}
+
+// netmask returns the dotted IPv4 netmask of a prefix of bits.
+func netmask(bits int) string {
+	mask := netip.AddrFrom4([4]byte(binary.BigEndian.AppendUint32(nil, ^uint32(0)<<(32-bits))))
+	return mask.String()
+}
+
+// ifconfig runs ifconfig(8) on the interface name with args.
+func ifconfig(name string, args ...string) error {
+	out, err := exec.Command("ifconfig", append([]string{name}, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ifconfig %s %s failed: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
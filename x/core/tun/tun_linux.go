@@ -0,0 +1,99 @@
+package tun
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// linuxDevice is a Device on /dev/net/tun, configured with ip(8).
+type linuxDevice struct {
+	file *os.File
+	name string
+	mtu  int
+}
+
+func newDevice(opts Options) (Device, error) {
+	fd, err := unix.Open("/dev/net/tun", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open /dev/net/tun: %w", err)
+	}
+	ifr, err := unix.NewIfreq(opts.Name)
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("invalid TUN name %q: %w", opts.Name, err)
+	}
+	// Packets without the 4-byte header of their protocol.
+	ifr.SetUint16(unix.IFF_TUN | unix.IFF_NO_PI)
+	if err := unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to create TUN device: %w", err)
+	}
+	// Non-blocking, so the file reads through the poller and Close stops a
+	// blocked Read.
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to create TUN device: %w", err)
+	}
+	d := &linuxDevice{file: os.NewFile(uintptr(fd), "/dev/net/tun"), name: ifr.Name(), mtu: opts.MTU}
+	if err := ip("link", "set", "dev", d.name, "mtu", strconv.Itoa(d.mtu)); err != nil {
+		d.file.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *linuxDevice) Name() string {
+	return d.name
+}
+
+func (d *linuxDevice) MTU() int {
+	return d.mtu
+}
+
+func (d *linuxDevice) Read(p []byte) (int, error) {
+	return d.file.Read(p)
+}
+
+func (d *linuxDevice) Write(p []byte) (int, error) {
+	return d.file.Write(p)
+}
+
+// Close removes the device, as it isn't persistent.
+func (d *linuxDevice) Close() error {
+	return d.file.Close()
+}
+
+func (d *linuxDevice) Configure(addrs ...netip.Prefix) error {
+	for _, p := range addrs {
+		if !p.IsValid() {
+			return fmt.Errorf("invalid TUN address %s", p)
+		}
+	}
+	if slices.ContainsFunc(addrs, func(p netip.Prefix) bool { return p.Addr().Is4() }) {
+		if err := ip("-4", "addr", "flush", "dev", d.name); err != nil {
+			return err
+		}
+	}
+	for _, p := range addrs {
+		if err := ip("addr", "replace", p.String(), "dev", d.name); err != nil {
+			return err
+		}
+	}
+	return ip("link", "set", "dev", d.name, "up")
+}
+
+// ip runs ip(8) with args.
+func ip(args ...string) error {
+	out, err := exec.Command("ip", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ip %s failed: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+//go:build !windows && !darwin && !linux
+
+package tun
+
+import (
+	"errors"
+	"net/netip"
+	"runtime"
+)
+
+func newDevice(opts Options) (Device, error) {
+	return nil, errors.New("TUN devices are not supported on " + runtime.GOOS)
+}
+
+func addDeviceRoute(dev Device, p netip.Prefix) error {
+	return errors.ErrUnsupported
+}
+
+func deleteDeviceRoute(dev Device, p netip.Prefix) error {
+	return errors.ErrUnsupported
+}
+
+type gateways struct{}
+
+func defaultGateways(dev Device) (gateways, error) {
+	return gateways{}, errors.ErrUnsupported
+}
+
+func (g gateways) addRoute(p netip.Prefix) error {
+	return errors.ErrUnsupported
+}
+
+func removeGatewayRoutes(prefixes []netip.Prefix) error {
+	return errors.ErrUnsupported
+}
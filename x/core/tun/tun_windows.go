@@ -0,0 +1,223 @@
+package tun
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.zx2c4.com/wintun"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
+)
+
+// defaultAdapterName is the name of Windows adapters without one.
+const defaultAdapterName = "DrFrakeVPN"
+
+// configureTimeout is how long Configure waits for a new adapter to be known
+// to the IP stack.
+const configureTimeout = 10 * time.Second
+
+// Wintun is a Device on a Wintun adapter. Besides the Device methods, it
+// has the LUID that the adapter's routes and DNS are set by.
+type Wintun struct {
+	adapter *wintun.Adapter
+	name    string
+	mtu     int
+	luid    winipcfg.LUID
+
+	// closing is signaled by Close, to wake the Reads waiting for packets,
+	// which reading counts.
+	closing windows.Handle
+	reading sync.WaitGroup
+	// mu is held for reading by Read and Write while they use the session,
+	// and for writing by Close to end it.
+	mu      sync.RWMutex
+	session wintun.Session
+	closed  bool
+}
+
+func newDevice(opts Options) (Device, error) {
+	return NewWintun(opts)
+}
+
+// NewWintun is New on Windows. It first removes the adapters named like this
+// one that earlier runs left behind, which would otherwise make Wintun name
+// it "Name 1" and could hold its GUID.
+func NewWintun(opts Options) (*Wintun, error) {
+	opts.Name = cmp.Or(opts.Name, defaultAdapterName)
+	opts.MTU = cmp.Or(opts.MTU, DefaultMTU)
+	opts.RingSize = cmp.Or(opts.RingSize, DefaultRingSize)
+	var guid *windows.GUID
+	if opts.GUID != "" {
+		g, err := windows.GUIDFromString(opts.GUID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid adapter GUID %q: %w", opts.GUID, err)
+		}
+		guid = &g
+	}
+	if err := RemoveOrphanedAdapters(opts.Name); err != nil {
+		log.Printf("Wintun: %v\n", err)
+	}
+
+	adapter, err := wintun.CreateAdapter(opts.Name, opts.Name, guid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Wintun adapter: %w", err)
+	}
+	session, err := adapter.StartSession(opts.RingSize)
+	if err != nil {
+		adapter.Close()
+		return nil, fmt.Errorf("failed to start Wintun session: %w", err)
+	}
+	closing, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		session.End()
+		adapter.Close()
+		return nil, fmt.Errorf("failed to start Wintun session: %w", err)
+	}
+	return &Wintun{
+		adapter: adapter,
+		name:    opts.Name,
+		mtu:     opts.MTU,
+		luid:    winipcfg.LUID(adapter.LUID()),
+		closing: closing,
+		session: session,
+	}, nil
+}
+
+// LUID returns the LUID of the adapter.
+func (d *Wintun) LUID() winipcfg.LUID {
+	return d.luid
+}
+
+func (d *Wintun) Name() string {
+	return d.name
+}
+
+func (d *Wintun) MTU() int {
+	return d.mtu
+}
+
+func (d *Wintun) Read(p []byte) (int, error) {
+	d.mu.RLock()
+	if d.closed {
+		d.mu.RUnlock()
+		return 0, os.ErrClosed
+	}
+	d.reading.Add(1)
+	d.mu.RUnlock()
+	defer d.reading.Done()
+	for {
+		d.mu.RLock()
+		if d.closed {
+			d.mu.RUnlock()
+			return 0, os.ErrClosed
+		}
+		pkt, err := d.session.ReceivePacket()
+		if err == nil {
+			n := copy(p, pkt)
+			d.session.ReleaseReceivePacket(pkt)
+			d.mu.RUnlock()
+			return n, nil
+		}
+		readWait := d.session.ReadWaitEvent()
+		d.mu.RUnlock()
+		switch {
+		case errors.Is(err, windows.ERROR_NO_MORE_ITEMS):
+			// The ring is empty until the event is signaled.
+			if _, err := windows.WaitForMultipleObjects([]windows.Handle{readWait, d.closing}, false, windows.INFINITE); err != nil {
+				return 0, err
+			}
+		case errors.Is(err, windows.ERROR_HANDLE_EOF):
+			return 0, os.ErrClosed
+		default:
+			return 0, err
+		}
+	}
+}
+
+func (d *Wintun) Write(p []byte) (int, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return 0, os.ErrClosed
+	}
+	pkt, err := d.session.AllocateSendPacket(len(p))
+	if errors.Is(err, windows.ERROR_BUFFER_OVERFLOW) {
+		// The ring is full: dropped like a lost packet.
+		return len(p), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	copy(pkt, p)
+	d.session.SendPacket(pkt)
+	return len(p), nil
+}
+
+// Close removes the adapter, with its addresses and the routes through it.
+func (d *Wintun) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	windows.SetEvent(d.closing)
+	d.session.End()
+	err := d.adapter.Close()
+	d.mu.Unlock()
+	d.reading.Wait()
+	windows.CloseHandle(d.closing)
+	return err
+}
+
+// Configure sets the addresses of the adapter and its MTU. The adapter may
+// take a moment to be known to the IP stack after it's created, which it
+// waits for.
+func (d *Wintun) Configure(addrs ...netip.Prefix) error {
+	var v4, v6 []netip.Prefix
+	for _, p := range addrs {
+		if !p.IsValid() {
+			return fmt.Errorf("invalid TUN address %s", p)
+		}
+		if p.Addr().Is4() {
+			v4 = append(v4, p)
+		} else {
+			v6 = append(v6, p)
+		}
+	}
+	deadline := time.Now().Add(configureTimeout)
+	for len(v4) > 0 {
+		err := d.luid.SetIPAddressesForFamily(windows.AF_INET, v4)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, windows.ERROR_NOT_FOUND) || time.Now().After(deadline) {
+			return fmt.Errorf("failed to configure IP: %w", err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	for _, p := range v6 {
+		err := d.luid.AddIPAddress(p)
+		if err != nil && !errors.Is(err, windows.ERROR_OBJECT_ALREADY_EXISTS) {
+			return fmt.Errorf("failed to configure IPv6: %w", err)
+		}
+	}
+	for _, family := range []winipcfg.AddressFamily{windows.AF_INET, windows.AF_INET6} {
+		ipif, err := d.luid.IPInterface(family)
+		if err != nil {
+			// No IPv6 on this system.
+			continue
+		}
+		ipif.NLMTU = uint32(d.mtu)
+		if err := ipif.Set(); err != nil {
+			return fmt.Errorf("failed to set the MTU: %w", err)
+		}
+	}
+	return nil
+}
@@ -2,71 +2,507 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/things-go/go-socks5"
+	"golang.getoutline.org/sdk/transport"
 	"golang.getoutline.org/sdk/x/configurl"
 	"golang.getoutline.org/sdk/x/httpproxy"
 )
 
+// States of a VPNClient. They are plain ints so gomobile can bind them.
+const (
+	StateDisconnected = iota
+	StateConnecting
+	StateConnected
+	// StateReconnecting is when the local proxy failed and is being started
+	// again on the same address.
+	StateReconnecting
+)
+
+// StateName returns the name of state, for logs.
+func StateName(state int) string {
+	switch state {
+	case StateDisconnected:
+		return "Disconnected"
+	case StateConnecting:
+		return "Connecting"
+	case StateConnected:
+		return "Connected"
+	case StateReconnecting:
+		return "Reconnecting"
+	}
+	return fmt.Sprintf("State(%d)", state)
+}
+
+// StateListener gets the state changes of a VPNClient. Detail is the address
+// of the HTTP proxy when connected, and the error that caused the change, if any,
+// otherwise. The changes are delivered one at a time, in order, from a
+// goroutine of the client, so the listener may call the client.
+type StateListener interface {
+	OnStateChange(state int, detail string)
+}
+
+// EndpointListener is a StateListener that is also told which config of a
+// failover connection is in use: once connected, and on every switch. It is
+// told in order with the state changes.
+type EndpointListener interface {
+	OnEndpointChange(index int, config string)
+}
+
+// TrafficListener is a StateListener that also gets the traffic of the
+// connection every trafficInterval while connected, in order with the state
+// changes.
+type TrafficListener interface {
+	OnTrafficStats(stats *TrafficStats)
+}
+
+// trafficInterval is how often a TrafficListener gets the traffic.
+const trafficInterval = time.Second
+
+// The longest wait between attempts to start the local proxy again.
+const maxReconnectDelay = 30 * time.Second
+
+// Addresses are the addresses (host:port) of the local proxies.
+type Addresses struct {
+	HTTP  string // Of the HTTP CONNECT proxy
+	SOCKS string // Of the SOCKS5 proxy, empty without it
+	DNS   string // Of the DNS forwarder, empty without it
+}
+
 // VPNClient manages the connection
 type VPNClient struct {
+	mu           sync.Mutex
+	state        int
+	socks        bool   // Whether to start the SOCKS5 proxy too
+	dnsAddr      string // Where to serve DNS, empty for nowhere
+	dnsUpstream  string
+	dns          *DNSForwarder // Nil without it
 	proxyServer  *http.Server
-	isConnected  bool
+	socksServer  *socks5.Server
+	listeners    map[string]net.Listener // Of the running proxies, by name
+	addrs        Addresses
 	activeConfig string
+	traffic      *TrafficCounter    // Of the last connection
+	cancel       context.CancelFunc // Of the Connect in progress, or of the connection
+	listener     StateListener
+	changes      []func(StateListener) // Not delivered yet
+	delivering   bool                  // Whether a goroutine delivers changes
 }
 
 func NewVPNClient() *VPNClient {
 	return &VPNClient{}
 }
 
-// Connect starts the local proxy and returns the bound address (host:port).
-// On mobile, the UI layer (Flutter/Kotlin/Swift) must route traffic to this address.
-func (c *VPNClient) Connect(config string) (string, error) {
-	if c.isConnected {
-		return "", fmt.Errorf("already connected")
+// SetListener sets the listener of the state changes, nil for none.
+func (c *VPNClient) SetListener(l StateListener) {
+	c.mu.Lock()
+	c.listener = l
+	c.mu.Unlock()
+}
+
+// SetSOCKS sets whether Connect starts a SOCKS5 proxy, with UDP ASSOCIATE,
+// next to the HTTP one, for apps that send UDP.
+func (c *VPNClient) SetSOCKS(enabled bool) {
+	c.mu.Lock()
+	c.socks = enabled
+	c.mu.Unlock()
+}
+
+// SetDNS sets where Connect serves DNS (host:port, over UDP and TCP),
+// answered by upstream through the connection; see NewDNSForwarder. Apps
+// that don't use the proxies for DNS can be pointed there, so their queries
+// don't leak. An empty addr serves none.
+func (c *VPNClient) SetDNS(addr, upstream string) {
+	c.mu.Lock()
+	c.dnsAddr = addr
+	c.dnsUpstream = upstream
+	c.mu.Unlock()
+}
+
+// Connect starts the local proxies and returns their addresses. Config is a
+// configurl config, or "smart" or "smart:" and a fallback config to search
+// for a strategy that gets around blocking, which can take a while. Several
+// configs, one per line, are failed over between, in order; see
+// FailoverDialer.
+// On mobile, the UI layer (Flutter/Kotlin/Swift) must route traffic to these addresses.
+//
+// Canceling ctx, or calling Disconnect, stops a Connect in progress, which
+// then returns the error of ctx. Once connected, ctx no longer matters: the
+// connection lasts until Disconnect.
+func (c *VPNClient) Connect(ctx context.Context, config string) (*Addresses, error) {
+	c.mu.Lock()
+	if c.state != StateDisconnected {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("already connected")
 	}
+	ctx, cancel := context.WithCancel(ctx)
+	c.cancel = cancel
+	socks := c.socks
+	dns := dnsConfig{addr: c.dnsAddr, upstream: c.dnsUpstream}
+	c.setState(StateConnecting, "")
+	c.mu.Unlock()
 
-	dialer, err := configurl.NewDefaultProviders().NewStreamDialer(context.Background(), config)
+	proxies, err := c.start(ctx, config, socks, dns)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil && ctx.Err() != nil {
+		proxies.close()
+		err = ctx.Err()
+	}
+	cancel()
 	if err != nil {
-		return "", fmt.Errorf("failed to create dialer: %w", err)
+		c.cancel = nil
+		c.setState(StateDisconnected, err.Error())
+		return nil, err
 	}
 
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		return "", fmt.Errorf("failed to listen: %w", err)
+	// The connection gets its own context, which Disconnect cancels.
+	runCtx, runCancel := context.WithCancel(context.Background())
+	c.cancel = runCancel
+	c.activeConfig = config
+	c.traffic = proxies.traffic
+	c.proxyServer = &http.Server{Handler: proxies.handler}
+	c.socksServer = proxies.socksServer
+	c.listeners = map[string]net.Listener{"HTTP": proxies.http}
+	c.addrs = Addresses{HTTP: proxies.http.Addr().String()}
+	go c.serve(runCtx, "HTTP", proxies.http, c.proxyServer.Serve)
+	if proxies.socks != nil {
+		c.listeners["SOCKS5"] = proxies.socks
+		c.addrs.SOCKS = proxies.socks.Addr().String()
+		go c.serve(runCtx, "SOCKS5", proxies.socks, c.socksServer.Serve)
+	}
+	c.dns = proxies.dns
+	c.addrs.DNS = proxies.dnsAddr
+	c.setState(StateConnected, c.addrs.HTTP)
+	if fd := proxies.failover; fd != nil {
+		fd.SetOnSwitch(func(index int, config string) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			// Dials in flight may outlive the connection.
+			if runCtx.Err() != nil {
+				return
+			}
+			c.activeConfig = config
+			c.notifyEndpoint(index, config)
+		})
+		index, config := fd.Active()
+		c.activeConfig = config
+		c.notifyEndpoint(index, config)
+		go fd.Run(runCtx)
 	}
+	go proxies.traffic.Report(runCtx, trafficInterval, func(stats *TrafficStats) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if runCtx.Err() == nil {
+			c.notifyTraffic(stats)
+		}
+	})
+
+	// Return the addresses so mobile native layer can use them (VpnService/tun2socks)
+	addrs := c.addrs
+	return &addrs, nil
+}
+
+// Start is Connect for bindings, which can't pass a context. Disconnect
+// stops it.
+func (c *VPNClient) Start(config string) (*Addresses, error) {
+	return c.Connect(context.Background(), config)
+}
 
-	proxyAddr := listener.Addr().String()
+// startedProxies are the listeners of the local proxies, before they serve.
+type startedProxies struct {
+	http        net.Listener
+	handler     http.Handler
+	socks       net.Listener // Nil without the SOCKS5 proxy
+	socksServer *socks5.Server
+	failover    *FailoverDialer // Nil with a single config
+	traffic     *TrafficCounter
+	dns         *DNSForwarder // Nil without it
+	dnsAddr     string
+}
 
-	c.proxyServer = &http.Server{
-		Handler: httpproxy.NewProxyHandler(dialer),
+func (p *startedProxies) close() {
+	p.http.Close()
+	if p.socks != nil {
+		p.socks.Close()
+	}
+	if p.dns != nil {
+		p.dns.Close()
 	}
+}
+
+// dnsConfig is where and how a VPNClient serves DNS, see SetDNS.
+type dnsConfig struct {
+	addr     string
+	upstream string
+}
 
-	go func() {
-		if err := c.proxyServer.Serve(listener); err != nil && err != http.ErrServerClosed {
-			log.Printf("Proxy server error: %v\n", err)
+// start creates the dialers of config and the listeners of the local
+// proxies.
+func (c *VPNClient) start(ctx context.Context, config string, socks bool, dns dnsConfig) (*startedProxies, error) {
+	p := &startedProxies{}
+	providers := configurl.NewDefaultProviders()
+	var dialer transport.StreamDialer
+	var packetListener transport.PacketListener
+	var err error
+	if configs := splitConfigs(config); len(configs) > 1 {
+		p.failover, err = NewFailoverDialer(ctx, configs)
+		if err != nil {
+			return nil, err
 		}
-	}()
+		dialer = p.failover
+		if socks {
+			// Endpoints without UDP only fail once UDP is sent with them.
+			fpl := &failoverPacketListener{dialer: p.failover}
+			for _, config := range configs {
+				pl, _ := newPacketListener(ctx, providers, config)
+				fpl.listeners = append(fpl.listeners, pl)
+			}
+			packetListener = fpl
+		}
+	} else {
+		dialer, err = newStreamDialer(ctx, providers, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dialer: %w", err)
+		}
+		if socks {
+			packetListener, err = newPacketListener(ctx, providers, config)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create packet listener: %w", err)
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p.traffic = NewTrafficCounter()
+	dialer = &CountingStreamDialer{Dialer: dialer, Counter: p.traffic}
+	if packetListener != nil {
+		packetListener = &CountingPacketListener{Listener: packetListener, Counter: p.traffic}
+	}
 
-	c.isConnected = true
-	c.activeConfig = config
+	var lc net.ListenConfig
+	p.handler = httpproxy.NewProxyHandler(dialer)
+	p.http, err = lc.Listen(ctx, "tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	if socks {
+		p.socks, err = lc.Listen(ctx, "tcp", "127.0.0.1:0")
+		if err != nil {
+			p.http.Close()
+			return nil, fmt.Errorf("failed to listen: %w", err)
+		}
+		p.socksServer = NewSOCKSServer(dialer, packetListener)
+	}
+	if dns.addr != "" {
+		p.dns, err = NewDNSForwarder(dialer, dns.upstream)
+		if err == nil {
+			p.dnsAddr, err = p.dns.Listen(dns.addr)
+		}
+		if err != nil {
+			p.dns = nil
+			p.close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// serve runs the proxy called name on listener until ctx is canceled. If it
+// fails before, it is started again on the same address.
+func (c *VPNClient) serve(ctx context.Context, name string, listener net.Listener, serve func(net.Listener) error) {
+	addr := listener.Addr().String()
+	for {
+		err := serve(listener)
+		if ctx.Err() != nil || errors.Is(err, http.ErrServerClosed) {
+			return
+		}
+		log.Printf("%s proxy error: %v\n", name, err)
+		c.mu.Lock()
+		c.setState(StateReconnecting, err.Error())
+		c.mu.Unlock()
+
+		listener = c.relisten(ctx, addr)
+		if listener == nil {
+			return
+		}
+		c.mu.Lock()
+		if ctx.Err() != nil {
+			c.mu.Unlock()
+			listener.Close()
+			return
+		}
+		c.listeners[name] = listener
+		c.setState(StateConnected, c.addrs.HTTP)
+		c.mu.Unlock()
+	}
+}
 
-	// Return the address so mobile native layer can use it (VpnService/tun2socks)
-	return proxyAddr, nil
+// relisten listens on addr again, waiting longer after each failure, until
+// it succeeds or ctx is canceled, when it returns nil.
+func (c *VPNClient) relisten(ctx context.Context, addr string) net.Listener {
+	delay := time.Second
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		listener, err := net.Listen("tcp", addr)
+		if err == nil {
+			return listener
+		}
+		log.Printf("Failed to restart proxy on %s: %v\n", addr, err)
+		delay = min(2*delay, maxReconnectDelay)
+	}
 }
 
+// Disconnect stops the local proxy, or the Connect in progress.
 func (c *VPNClient) Disconnect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	// A Connect in progress reports the change once it returns.
+	if c.state == StateConnecting || c.state == StateDisconnected {
+		return nil
+	}
+	c.cancel = nil
 	if c.proxyServer != nil {
 		c.proxyServer.Close()
 		c.proxyServer = nil
 	}
-	c.isConnected = false
+	for _, l := range c.listeners {
+		l.Close()
+	}
+	c.listeners = nil
+	c.socksServer = nil
+	if c.dns != nil {
+		c.dns.Close()
+		c.dns = nil
+	}
+	c.addrs = Addresses{}
+	c.activeConfig = ""
+	c.setState(StateDisconnected, "")
 	return nil
 }
 
+// ActiveConfig returns the config connected with, of the endpoint in use
+// with several, empty if not connected.
+func (c *VPNClient) ActiveConfig() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.activeConfig
+}
+
+// TrafficStats returns the traffic of the connection, or of the last one
+// while disconnected. It is nil if there was none.
+func (c *VPNClient) TrafficStats() *TrafficStats {
+	c.mu.Lock()
+	traffic := c.traffic
+	c.mu.Unlock()
+	if traffic == nil {
+		return nil
+	}
+	return traffic.Stats()
+}
+
+// splitConfigs returns the configs of config, one per line.
+func splitConfigs(config string) []string {
+	var configs []string
+	for _, line := range strings.Split(config, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			configs = append(configs, line)
+		}
+	}
+	return configs
+}
+
+// State returns the state of the connection, one of the State constants.
+func (c *VPNClient) State() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
 func (c *VPNClient) IsConnected() bool {
-	return c.isConnected
+	return c.State() == StateConnected
+}
+
+// ProxyAddresses returns the addresses of the local proxies, empty if not
+// connected.
+func (c *VPNClient) ProxyAddresses() *Addresses {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	addrs := c.addrs
+	return &addrs
+}
+
+// setState changes the state and has the listener told. c.mu must be held.
+func (c *VPNClient) setState(state int, detail string) {
+	if state == c.state {
+		return
+	}
+	log.Printf("VPN %s -> %s %s\n", StateName(c.state), StateName(state), detail)
+	c.state = state
+	c.notify(func(l StateListener) { l.OnStateChange(state, detail) })
+}
+
+// notifyEndpoint has an EndpointListener told of the endpoint in use. c.mu
+// must be held.
+func (c *VPNClient) notifyEndpoint(index int, config string) {
+	c.notify(func(l StateListener) {
+		if el, ok := l.(EndpointListener); ok {
+			el.OnEndpointChange(index, config)
+		}
+	})
+}
+
+// notifyTraffic has a TrafficListener told of the traffic. c.mu must be
+// held.
+func (c *VPNClient) notifyTraffic(stats *TrafficStats) {
+	c.notify(func(l StateListener) {
+		if tl, ok := l.(TrafficListener); ok {
+			tl.OnTrafficStats(stats)
+		}
+	})
+}
+
+// notify queues change for the listener. c.mu must be held.
+func (c *VPNClient) notify(change func(StateListener)) {
+	c.changes = append(c.changes, change)
+	if !c.delivering {
+		c.delivering = true
+		go c.deliverChanges()
+	}
+}
+
+// deliverChanges tells the listener of the changes, until there are no more.
+func (c *VPNClient) deliverChanges() {
+	for {
+		c.mu.Lock()
+		if len(c.changes) == 0 {
+			c.delivering = false
+			c.mu.Unlock()
+			return
+		}
+		change := c.changes[0]
+		c.changes = c.changes[1:]
+		listener := c.listener
+		c.mu.Unlock()
+		if listener != nil {
+			change(listener)
+		}
+	}
 }
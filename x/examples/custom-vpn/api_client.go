@@ -1,16 +1,26 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
 	"time"
+
+	"drfrake-core"
 )
 
+const defaultBackendURL = "http://31.135.65.188:8080"
+
 type Server struct {
 	ID        string
 	Country   string
 	Flag      string // Emoji or icon name
 	Config    string // ss:// key
-	Latency   int    // in ms
+	Latency   int    // in ms, 0 if unknown
 	IsPremium bool
+	Locked    bool // Needs a plan the user doesn't have, so there is no key
 }
 
 type UserPlan string
@@ -24,28 +34,91 @@ type UserInfo struct {
 	ID           string
 	Email        string
 	Plan         UserPlan
-	ExpiryDate   time.Time
+	PlanName     string    // As the backend calls it, e.g. "monthly"
+	ExpiryDate   time.Time // Zero if the plan doesn't expire
 	ActiveServer *Server
 }
 
-// FetchServerList simulates an API call to get available VPN servers
-func FetchServerList() []Server {
-	return []Server{
-		{"us-1", "USA", "🇺🇸", "ss://YWVzLTEyOC1nY206dGVzdA@127.0.0.1:0", 45, false},
-		{"de-1", "Germany", "🇩🇪", "ss://YWVzLTEyOC1nY206dGVzdA@127.0.0.1:0", 60, false},
-		{"jp-1", "Japan", "🇯🇵", "ss://YWVzLTEyOC1nY206dGVzdA@127.0.0.1:0", 150, true},
-		{"uk-1", "UK", "🇬🇧", "ss://YWVzLTEyOC1nY206dGVzdA@127.0.0.1:0", 55, true},
-		{"nl-1", "Netherlands", "🇳🇱", "ss://YWVzLTEyOC1nY206dGVzdA@127.0.0.1:0", 50, true},
-		{"sg-1", "Singapore", "🇸🇬", "ss://YWVzLTEyOC1nY206dGVzdA@127.0.0.1:0", 120, true},
+// apiClient talks to the backend. It is logged in once Token is set.
+var apiClient *core.AuthClient
+
+func isLoggedIn() bool {
+	return apiClient != nil && apiClient.Token != ""
+}
+
+// restoreSession logs in with the token kept from the last run, if there is
+// one. refreshAccount finds out whether it is still valid.
+func restoreSession() bool {
+	token, err := keystore.Get(sessionKey)
+	if err != nil {
+		if !errors.Is(err, core.ErrSecretNotFound) {
+			slog.Warn("Failed to read the session", "error", err)
+		}
+		return false
+	}
+	apiClient.Token = string(token)
+	return true
+}
+
+// FetchServerList gets the servers available to the user, with their access
+// keys, from the backend.
+func FetchServerList() ([]Server, error) {
+	infos, err := apiClient.Servers(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	servers := make([]Server, 0, len(infos))
+	for _, s := range infos {
+		country := s.Country
+		if s.City != "" {
+			country = fmt.Sprintf("%s, %s", s.Country, s.City)
+		}
+		servers = append(servers, Server{
+			ID:        s.ID,
+			Country:   country,
+			Flag:      s.Flag,
+			Config:    s.Config,
+			IsPremium: s.IsPremium,
+			Locked:    s.Locked,
+		})
 	}
+	return servers, nil
 }
 
-// GetUserInfo simulates an API call to get the current user's profile and subscription
-func GetUserInfo() UserInfo {
-	return UserInfo{
-		ID:         "user_123",
-		Email:      "client@drfrake.com",
-		Plan:       PlanFree, // Change to PlanPremium to test premium features
-		ExpiryDate: time.Now().AddDate(0, 1, 0),
+// GetUserInfo gets the current user's profile and subscription from the
+// backend.
+func GetUserInfo() (UserInfo, error) {
+	account, err := apiClient.Me(context.Background())
+	if err != nil {
+		return UserInfo{}, err
+	}
+	info := UserInfo{
+		ID:       account.ID,
+		Email:    account.Email,
+		Plan:     PlanFree,
+		PlanName: account.Plan,
+	}
+	// Like on the backend, all plans but the free one are premium.
+	if account.Plan != "free" {
+		info.Plan = PlanPremium
+	}
+	if account.ExpiryDate != nil {
+		info.ExpiryDate = *account.ExpiryDate
+	}
+	return info, nil
+}
+
+// planText describes the user's plan for the sidebar.
+func planText(u UserInfo) string {
+	if u.Plan != PlanPremium {
+		return string(u.Plan)
+	}
+	text := string(u.Plan)
+	if u.PlanName != "" {
+		text += " (" + strings.ToLower(u.PlanName) + ")"
+	}
+	if !u.ExpiryDate.IsZero() {
+		text += "\nuntil " + u.ExpiryDate.Local().Format("Jan 2, 2006")
 	}
+	return text
 }
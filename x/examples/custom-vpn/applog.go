@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.getoutline.org/sdk/transport"
+)
+
+// maxLogEntries is how many entries the log view keeps.
+const maxLogEntries = 500
+
+type logEntry struct {
+	Time    time.Time
+	Level   slog.Level
+	Message string
+	Attrs   string // As key=value pairs
+}
+
+func (e logEntry) String() string {
+	s := fmt.Sprintf("%s %-5s %s", e.Time.Format("15:04:05"), e.Level, e.Message)
+	if e.Attrs != "" {
+		s += " " + e.Attrs
+	}
+	return s
+}
+
+// logBuffer keeps the last log entries for the log view.
+type logBuffer struct {
+	mu       sync.Mutex
+	entries  []logEntry
+	onChange func() // Called after an entry is added, if set
+}
+
+// appLog has the entries logged with slog, and with log, as main makes it
+// the default handler.
+var appLog = &logBuffer{}
+
+func (b *logBuffer) add(e logEntry) {
+	b.mu.Lock()
+	b.entries = append(b.entries, e)
+	if len(b.entries) > maxLogEntries {
+		b.entries = b.entries[len(b.entries)-maxLogEntries:]
+	}
+	onChange := b.onChange
+	b.mu.Unlock()
+	if onChange != nil {
+		onChange()
+	}
+}
+
+// Entries returns a copy of the entries, oldest first.
+func (b *logBuffer) Entries() []logEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]logEntry(nil), b.entries...)
+}
+
+func (b *logBuffer) Clear() {
+	b.mu.Lock()
+	b.entries = nil
+	b.mu.Unlock()
+}
+
+// SetOnChange sets the function called after each entry that is added.
+func (b *logBuffer) SetOnChange(f func()) {
+	b.mu.Lock()
+	b.onChange = f
+	b.mu.Unlock()
+}
+
+// logHandler is a slog.Handler adding the records to a logBuffer, and
+// passing them on to next.
+type logHandler struct {
+	buffer *logBuffer
+	next   slog.Handler
+	attrs  string // Of WithAttrs
+	group  string // Of WithGroup, as a key prefix
+}
+
+func newLogHandler(buffer *logBuffer, next slog.Handler) *logHandler {
+	return &logHandler{buffer: buffer, next: next}
+}
+
+func (h *logHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo || h.next.Enabled(ctx, level)
+}
+
+func (h *logHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelInfo {
+		var attrs []string
+		if h.attrs != "" {
+			attrs = append(attrs, h.attrs)
+		}
+		r.Attrs(func(a slog.Attr) bool {
+			attrs = append(attrs, formatAttr(h.group, a))
+			return true
+		})
+		h.buffer.add(logEntry{Time: r.Time, Level: r.Level, Message: r.Message, Attrs: strings.Join(attrs, " ")})
+	}
+	if h.next.Enabled(ctx, r.Level) {
+		return h.next.Handle(ctx, r)
+	}
+	return nil
+}
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.next = h.next.WithAttrs(attrs)
+	for _, a := range attrs {
+		h2.attrs = strings.TrimSpace(h2.attrs + " " + formatAttr(h.group, a))
+	}
+	return &h2
+}
+
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.next = h.next.WithGroup(name)
+	h2.group = h.group + name + "."
+	return &h2
+}
+
+func formatAttr(group string, a slog.Attr) string {
+	value := a.Value.Resolve().String()
+	if strings.ContainsAny(value, " \"=") {
+		value = fmt.Sprintf("%q", value)
+	}
+	return group + a.Key + "=" + value
+}
+
+// loggingStreamDialer logs the connections made with its StreamDialer.
+type loggingStreamDialer struct {
+	transport.StreamDialer
+}
+
+func (d loggingStreamDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	start := time.Now()
+	conn, err := d.StreamDialer.DialStream(ctx, addr)
+	if err != nil {
+		slog.Warn("Dial failed", "address", addr, "error", err)
+		return nil, err
+	}
+	slog.Info("Dialed", "address", addr, "took", time.Since(start).Round(time.Millisecond))
+	return conn, nil
+}
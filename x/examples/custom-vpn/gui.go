@@ -1,12 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"image/color"
-
+	"log/slog"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"drfrake-core"
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/layout"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
@@ -14,13 +23,32 @@ import (
 
 // App State
 var (
-	currentUser  UserInfo
-	allServers   []Server
-	activeServer *Server
-	isConnected  bool
-	statusLabel  *widget.Label
-	connectBtn   *widget.Button
-	contentArea  *fyne.Container
+	currentUser   UserInfo
+	allServers    []Server
+	activeServer  *Server
+	isConnected   bool
+	connecting    bool
+	statusLabel   *widget.Label
+	serverLabel   *widget.Label
+	connectBtn    *widget.Button
+	contentArea   *fyne.Container
+	mainWindow    fyne.Window
+	userLabel     *widget.Label
+	planLabel     *widget.Label
+	accountBtn    *widget.Button
+	serverList    *widget.List // Of the Locations view, if shown
+	serverOrder   []int        // Indexes of allServers in the order serverList shows them
+	socksCheck    *widget.Check
+	pacCheck      *widget.Check
+	proxyLabel    *widget.Label // Addresses of the running proxies
+	background    *canvas.Rectangle
+	logList       *widget.List    // Of the Log view, if shown
+	statusPane    *fyne.Container // Of the Log view, if shown
+	connectedTo   string          // Name of the server or transport
+	connectedAt   time.Time
+	autoConnected bool             // Whether AutoConnect was done this run
+	paymentBox    *fyne.Container  // Of the Pricing view, if shown
+	planButtons   []*widget.Button // Of the Pricing view, if shown
 )
 
 type drFrakeTheme struct {
@@ -44,14 +72,48 @@ func (t *drFrakeTheme) Color(name fyne.ThemeColorName, variant fyne.ThemeVariant
 	}
 }
 
+// lightTheme is Fyne's theme in its light variant, whatever the system uses.
+type lightTheme struct {
+	fyne.Theme
+}
+
+func (t *lightTheme) Color(name fyne.ThemeColorName, _ fyne.ThemeVariant) color.Color {
+	return t.Theme.Color(name, theme.VariantLight)
+}
+
+// themeNames are the names the settings show for the themes.
+var themeNames = map[string]string{
+	ThemeDrFrake: "Dr. Frake",
+	ThemeLight:   "Light",
+	ThemeSystem:  "System",
+}
+
+func applyTheme(name string) {
+	var t fyne.Theme
+	switch name {
+	case ThemeLight:
+		t = &lightTheme{Theme: theme.DefaultTheme()}
+	case ThemeSystem:
+		t = theme.DefaultTheme()
+	default:
+		t = &drFrakeTheme{Theme: theme.DefaultTheme()}
+	}
+	fyne.CurrentApp().Settings().SetTheme(t)
+	// The backdrop only goes with the Dr. Frake theme.
+	if _, ok := t.(*drFrakeTheme); ok {
+		background.Show()
+	} else {
+		background.Hide()
+	}
+}
+
 func setupGUI(myApp fyne.App) fyne.Window {
-	myApp.Settings().SetTheme(&drFrakeTheme{Theme: theme.DefaultTheme()})
+	background = canvas.NewRectangle(color.RGBA{R: 5, G: 10, B: 20, A: 255})
+	applyTheme(settings.Theme)
 	win := myApp.NewWindow("Dr. Frake VPN - Business Edition")
 	win.Resize(fyne.NewSize(800, 600))
-
-	// Initial Data Load
-	currentUser = GetUserInfo()
-	allServers = FetchServerList()
+	mainWindow = win
+	setupTray(myApp, win)
 
 	// Sidebar
 	sidebar := createSidebar()
@@ -64,10 +126,24 @@ func setupGUI(myApp fyne.App) fyne.Window {
 	mainLayout.Offset = 0.2
 
 	win.SetContent(container.NewMax(
-		canvas.NewRectangle(color.RGBA{R: 5, G: 10, B: 20, A: 255}),
+		background,
 		mainLayout,
 	))
 
+	// The servers and their keys come with the account.
+	if restoreSession() {
+		refreshAccount()
+	} else {
+		showLoginDialog()
+	}
+	startLatencyProber()
+
+	// A transport config needs no server to connect with.
+	if settings.AutoConnect && settings.transportConfig() != "" {
+		autoConnected = true
+		connect()
+	}
+
 	return win
 }
 
@@ -76,14 +152,20 @@ func createSidebar() fyne.CanvasObject {
 
 	homeBtn := widget.NewButtonWithIcon("Home", theme.HomeIcon(), showHomeView)
 	locBtn := widget.NewButtonWithIcon("Locations", theme.NavigateNextIcon(), showLocationsView)
-	priceBtn := widget.NewButtonWithIcon("Pricing", theme.SettingsIcon(), showPricingView)
+	priceBtn := widget.NewButtonWithIcon("Pricing", theme.UploadIcon(), showPricingView)
+	settingsBtn := widget.NewButtonWithIcon("Settings", theme.SettingsIcon(), showSettingsView)
+	logBtn := widget.NewButtonWithIcon("Log", theme.DocumentIcon(), showLogView)
 
 	homeBtn.Alignment = widget.ButtonAlignLeading
 	locBtn.Alignment = widget.ButtonAlignLeading
 	priceBtn.Alignment = widget.ButtonAlignLeading
+	settingsBtn.Alignment = widget.ButtonAlignLeading
+	logBtn.Alignment = widget.ButtonAlignLeading
 
-	avatar := widget.NewLabelWithStyle(currentUser.Email, fyne.TextAlignCenter, fyne.TextStyle{Italic: true})
-	planBadge := widget.NewLabelWithStyle(string(currentUser.Plan), fyne.TextAlignCenter, fyne.TextStyle{Bold: true})
+	userLabel = widget.NewLabelWithStyle("", fyne.TextAlignCenter, fyne.TextStyle{Italic: true})
+	planLabel = widget.NewLabelWithStyle("", fyne.TextAlignCenter, fyne.TextStyle{Bold: true})
+	accountBtn = widget.NewButtonWithIcon("", theme.AccountIcon(), nil)
+	updateUserUI()
 
 	return container.NewVBox(
 		layout.NewSpacer(),
@@ -92,14 +174,157 @@ func createSidebar() fyne.CanvasObject {
 		homeBtn,
 		locBtn,
 		priceBtn,
+		settingsBtn,
+		logBtn,
 		layout.NewSpacer(),
-		container.NewVBox(avatar, planBadge),
+		container.NewVBox(userLabel, planLabel, accountBtn),
 		layout.NewSpacer(),
 	)
 }
 
+// showLoginDialog asks for the account to log in with, or to register.
+func showLoginDialog() {
+	email := widget.NewEntry()
+	email.SetPlaceHolder("Email")
+	password := widget.NewPasswordEntry()
+	password.SetPlaceHolder("Password")
+	errorLabel := widget.NewLabel("")
+	errorLabel.Wrapping = fyne.TextWrapWord
+	errorLabel.Hide()
+
+	var d dialog.Dialog
+	var loginBtn, registerBtn *widget.Button
+	submit := func(register bool) {
+		if email.Text == "" || password.Text == "" {
+			errorLabel.SetText("Enter your email and password")
+			errorLabel.Show()
+			return
+		}
+		loginBtn.Disable()
+		registerBtn.Disable()
+		go func() {
+			var err error
+			if register {
+				_, err = apiClient.Register(context.Background(), email.Text, password.Text)
+			}
+			if err == nil {
+				_, err = apiClient.Login(context.Background(), email.Text, password.Text)
+			}
+			if err == nil {
+				if err := keystore.Set(sessionKey, []byte(apiClient.Token)); err != nil {
+					slog.Warn("Failed to keep the session", "error", err)
+				}
+			}
+			fyne.Do(func() {
+				loginBtn.Enable()
+				registerBtn.Enable()
+				if err != nil {
+					errorLabel.SetText(err.Error())
+					errorLabel.Show()
+					return
+				}
+				d.Hide()
+				refreshAccount()
+			})
+		}()
+	}
+	loginBtn = widget.NewButton("Log In", func() { submit(false) })
+	loginBtn.Importance = widget.HighImportance
+	registerBtn = widget.NewButton("Register", func() { submit(true) })
+	password.OnSubmitted = func(string) { submit(false) }
+
+	content := container.NewVBox(
+		email,
+		password,
+		errorLabel,
+		container.NewGridWithColumns(2, registerBtn, loginBtn),
+	)
+	d = dialog.NewCustomWithoutButtons("Log in to Dr. Frake", content, mainWindow)
+	d.Resize(fyne.NewSize(360, 260))
+	d.Show()
+}
+
+// refreshAccount loads the user's plan and servers from the backend.
+func refreshAccount() {
+	statusLabel.SetText("Loading your account...")
+	go func() {
+		user, err := GetUserInfo()
+		var servers []Server
+		if err == nil {
+			servers, err = FetchServerList()
+		}
+		fyne.Do(func() {
+			if core.IsUnauthorized(err) {
+				slog.Info("The session expired, log in again")
+				handleLogout()
+				return
+			}
+			if err != nil {
+				slog.Error("Failed to load account", "error", err)
+				statusLabel.SetText("Cloud Error: " + err.Error())
+				return
+			}
+			currentUser = user
+			allServers = servers
+			applyLatencies()
+			measureServers()
+			// The selected server may have a new key, or be locked now.
+			id := settings.LastServerID
+			if activeServer != nil {
+				id = activeServer.ID
+			}
+			activeServer = nil
+			for i := range allServers {
+				if allServers[i].ID == id && !allServers[i].Locked {
+					activeServer = &allServers[i]
+				}
+			}
+			updateUserUI()
+			updateHomeUI()
+			refreshServerList()
+			if settings.AutoConnect && !autoConnected && activeServer != nil && !isConnected {
+				autoConnected = true
+				connect()
+			}
+		})
+	}()
+}
+
+func handleLogout() {
+	stopPayment()
+	if isConnected {
+		stopVPN()
+		isConnected = false
+	}
+	apiClient.Token = ""
+	if err := keystore.Delete(sessionKey); err != nil {
+		slog.Warn("Failed to forget the session", "error", err)
+	}
+	currentUser = UserInfo{}
+	allServers = nil
+	activeServer = nil
+	updateUserUI()
+	showHomeView()
+	showLoginDialog()
+}
+
+func updateUserUI() {
+	if !isLoggedIn() {
+		userLabel.SetText("Not logged in")
+		planLabel.SetText("")
+		accountBtn.SetText("Log In")
+		accountBtn.OnTapped = showLoginDialog
+		return
+	}
+	userLabel.SetText(currentUser.Email)
+	planLabel.SetText(planText(currentUser))
+	accountBtn.SetText("Log Out")
+	accountBtn.OnTapped = handleLogout
+}
+
 func showHomeView() {
-	title := canvas.NewText("SECURE CONNECTION", color.White)
+	clearViewState()
+	title := canvas.NewText("SECURE CONNECTION", theme.Color(theme.ColorNameForeground))
 	title.TextSize = 24
 	title.TextStyle = fyne.TextStyle{Bold: true}
 	title.Alignment = fyne.TextAlignCenter
@@ -107,16 +332,29 @@ func showHomeView() {
 	statusLabel = widget.NewLabel("Status: Disconnected")
 	statusLabel.Alignment = fyne.TextAlignCenter
 
-	serverLabel := widget.NewLabel("Selected: None")
+	serverLabel = widget.NewLabel("")
 	serverLabel.Alignment = fyne.TextAlignCenter
-	if activeServer != nil {
-		serverLabel.SetText(fmt.Sprintf("Selected: %s %s", activeServer.Flag, activeServer.Country))
-	}
 
 	connectBtn = widget.NewButton("CONNECT", nil)
 	connectBtn.Importance = widget.HighImportance
 	connectBtn.OnTapped = handleConnectToggle
 
+	socksCheck = widget.NewCheck("Also start a SOCKS5 proxy (with UDP)", nil)
+	socksCheck.SetChecked(settings.SOCKS)
+	socksCheck.OnChanged = func(on bool) {
+		settings.SOCKS = on
+		storeSettings()
+	}
+	pacCheck = widget.NewCheck("Split tunnel: set the system proxy with a PAC file", nil)
+	pacCheck.SetChecked(settings.PAC)
+	pacCheck.OnChanged = func(on bool) {
+		settings.PAC = on
+		storeSettings()
+	}
+	proxyLabel = widget.NewLabel("")
+	proxyLabel.Alignment = fyne.TextAlignCenter
+	proxyLabel.TextStyle = fyne.TextStyle{Monospace: true}
+
 	updateHomeUI()
 
 	view := container.NewCenter(
@@ -127,6 +365,9 @@ func showHomeView() {
 			statusLabel,
 			layout.NewSpacer(),
 			container.NewPadded(connectBtn),
+			proxyLabel,
+			socksCheck,
+			pacCheck,
 		),
 	)
 	contentArea.Objects = []fyne.CanvasObject{view}
@@ -134,8 +375,9 @@ func showHomeView() {
 }
 
 func showLocationsView() {
+	clearViewState()
 	list := widget.NewList(
-		func() int { return len(allServers) },
+		func() int { return len(serverOrder) },
 		func() fyne.CanvasObject {
 			return container.NewHBox(
 				widget.NewLabel("Flags"),
@@ -146,28 +388,49 @@ func showLocationsView() {
 			)
 		},
 		func(id widget.ListItemID, item fyne.CanvasObject) {
-			s := allServers[id]
+			i := serverOrder[id]
+			s := allServers[i]
 			hbox := item.(*fyne.Container)
 			hbox.Objects[0].(*widget.Label).SetText(s.Flag)
 			hbox.Objects[1].(*widget.Label).SetText(s.Country)
-			hbox.Objects[3].(*widget.Label).SetText(fmt.Sprintf("%d ms", s.Latency))
+			latency := "—"
+			switch {
+			case s.Latency > 0:
+				latency = fmt.Sprintf("%d ms", s.Latency)
+			case s.Latency == latencyUnreachable:
+				latency = "no answer"
+			}
+			hbox.Objects[3].(*widget.Label).SetText(latency)
 
 			btn := hbox.Objects[4].(*widget.Button)
-			if s.IsPremium && currentUser.Plan != PlanPremium {
+			if s.Locked {
 				btn.SetText("PREMIUM")
 				btn.OnTapped = showPricingView
 			} else {
 				btn.SetText("SELECT")
 				btn.OnTapped = func() {
-					activeServer = &allServers[id]
+					activeServer = &allServers[i]
 					showHomeView()
 				}
 			}
 		},
 	)
 
+	serverList = list
+	refreshServerList()
+
+	sortCheck := widget.NewCheck("Fastest first", nil)
+	sortCheck.SetChecked(settings.SortByLatency)
+	sortCheck.OnChanged = func(on bool) {
+		settings.SortByLatency = on
+		storeSettings()
+		refreshServerList()
+	}
+	pingBtn := widget.NewButtonWithIcon("Ping", theme.ViewRefreshIcon(), measureServers)
+
 	view := container.NewBorder(
-		widget.NewLabelWithStyle("GLOBAL SERVER LOCATIONS", fyne.TextAlignCenter, fyne.TextStyle{Bold: true}),
+		container.NewBorder(nil, nil, nil, container.NewHBox(sortCheck, pingBtn),
+			widget.NewLabelWithStyle("GLOBAL SERVER LOCATIONS", fyne.TextAlignCenter, fyne.TextStyle{Bold: true})),
 		nil, nil, nil,
 		list,
 	)
@@ -176,85 +439,446 @@ func showLocationsView() {
 }
 
 func showPricingView() {
+	clearViewState()
 	title := widget.NewLabelWithStyle("CHOOSE YOUR PLAN", fyne.TextAlignCenter, fyne.TextStyle{Bold: true})
 
-	freeCard := container.NewVBox(
+	freeBtn := widget.NewButton("Current", nil)
+	freeBtn.Disable()
+	if currentUser.Plan == PlanPremium {
+		freeBtn.Hide()
+	}
+	cards := container.NewHBox(container.NewPadded(container.NewVBox(
 		widget.NewLabelWithStyle("FREE", fyne.TextAlignCenter, fyne.TextStyle{Bold: true}),
 		widget.NewLabel("Basic Speed"),
 		widget.NewLabel("2 Locations"),
-		widget.NewButton("Current", nil),
-	)
-
-	premiumCard := container.NewBorder(
-		nil,
-		widget.NewButton("UPGRADE NOW", func() {
-			currentUser.Plan = PlanPremium
-			showHomeView()
-		}),
-		nil, nil,
-		container.NewVBox(
-			widget.NewLabelWithStyle("PREMIUM", fyne.TextAlignCenter, fyne.TextStyle{Bold: true}),
-			widget.NewLabel("Ultra High Speed"),
-			widget.NewLabel("Global 10+ Locations"),
-			widget.NewLabel("Dedicated Support"),
-		),
-	)
+		freeBtn,
+	)))
+	for _, plan := range paidPlans {
+		text := "UPGRADE NOW"
+		if currentUser.PlanName == plan.Name {
+			text = "EXTEND"
+		}
+		btn := widget.NewButton(text, func() {
+			if !isLoggedIn() {
+				showLoginDialog()
+				return
+			}
+			startPayment(plan)
+		})
+		planButtons = append(planButtons, btn)
+		cards.Add(container.NewPadded(container.NewBorder(
+			nil, btn, nil, nil,
+			container.NewVBox(
+				widget.NewLabelWithStyle(plan.Title, fyne.TextAlignCenter, fyne.TextStyle{Bold: true}),
+				widget.NewLabel(plan.Price),
+				widget.NewLabel("Ultra High Speed"),
+				widget.NewLabel("Global 10+ Locations"),
+				widget.NewLabel("Dedicated Support"),
+			),
+		)))
+	}
+	paymentBox = container.NewVBox()
+	updatePaymentBox()
 
 	view := container.NewCenter(
 		container.NewVBox(
 			title,
 			layout.NewSpacer(),
-			container.NewHBox(
-				container.NewPadded(freeCard),
-				container.NewPadded(premiumCard),
-			),
+			cards,
+			paymentBox,
 		),
 	)
 	contentArea.Objects = []fyne.CanvasObject{view}
 	contentArea.Refresh()
 }
 
-func handleConnectToggle() {
-	if activeServer == nil {
-		statusLabel.SetText("Please select a location first")
+// updatePaymentBox shows the state of the payment in the Pricing view, if
+// shown. Plans can't be bought while a payment is in progress.
+func updatePaymentBox() {
+	if paymentBox == nil {
 		return
 	}
+	for _, btn := range planButtons {
+		if payingFor != "" {
+			btn.Disable()
+		} else {
+			btn.Enable()
+		}
+	}
+	status := widget.NewLabel(paymentStatus)
+	status.Alignment = fyne.TextAlignCenter
+	status.Wrapping = fyne.TextWrapWord
+	paymentBox.Objects = []fyne.CanvasObject{status}
+	if paymentURL != nil {
+		paymentBox.Add(container.NewCenter(widget.NewHyperlink("Open the payment page again", paymentURL)))
+	}
+	if payingFor != "" {
+		paymentBox.Add(container.NewCenter(widget.NewButton("Stop Waiting", stopPayment)))
+	}
+	paymentBox.Refresh()
+}
 
+func handleConnectToggle() {
+	if connecting {
+		return
+	}
 	if !isConnected {
-		statusLabel.SetText("Connecting to " + activeServer.Country + "...")
+		connect()
+	} else {
+		statusLabel.SetText("Disconnecting...")
 		connectBtn.Disable()
-		go func() {
-			err := startVPN(activeServer.Config)
+		stopVPN()
+		isConnected = false
+		updateHomeUI()
+	}
+}
+
+// connect connects with the transport config of the settings, if set, or else
+// to the selected server.
+func connect() {
+	config, name := settings.transportConfig(), "custom transport"
+	if config == "" {
+		if activeServer == nil {
+			statusLabel.SetText("Please select a location first")
+			return
+		}
+		config, name = activeServer.Config, activeServer.Country
+		settings.LastServerID = activeServer.ID
+		storeSettings()
+	}
+
+	statusLabel.SetText("Connecting to " + name + "...")
+	connectBtn.Disable()
+	connecting = true
+	updateTrayMenu()
+	go func() {
+		err := startVPN(config)
+		fyne.Do(func() {
+			connecting = false
 			if err != nil {
+				slog.Error("Failed to connect", "to", name, "error", err)
 				isConnected = false
 				statusLabel.SetText("Cloud Error: " + err.Error())
 				connectBtn.Enable()
+				updateTrayMenu()
 				return
 			}
 			isConnected = true
+			connectedTo, connectedAt = name, time.Now()
 			updateHomeUI()
-		}()
-	} else {
-		statusLabel.SetText("Disconnecting...")
-		connectBtn.Disable()
-		stopVPN()
-		isConnected = false
-		updateHomeUI()
-	}
+		})
+	}()
 }
 
 func updateHomeUI() {
+	updateStatusPane()
+	updateTrayMenu()
+	switch {
+	case settings.transportConfig() != "":
+		serverLabel.SetText("Selected: Custom transport (see Settings)")
+	case activeServer != nil:
+		serverLabel.SetText(fmt.Sprintf("Selected: %s %s", activeServer.Flag, activeServer.Country))
+	default:
+		serverLabel.SetText("Selected: None")
+	}
 	if isConnected {
 		statusLabel.SetText("CONNECTED")
 		connectBtn.SetText("DISCONNECT")
 		connectBtn.Importance = widget.WarningImportance
+		socksCheck.Disable()
+		pacCheck.Disable()
+		addrs := "HTTP proxy:   " + currentProxyAddr
+		if currentSOCKSAddr != "" {
+			addrs += "\nSOCKS5 proxy: " + currentSOCKSAddr
+		}
+		if currentPACURL != "" {
+			addrs += "\nPAC file:     " + currentPACURL
+		}
+		proxyLabel.SetText(addrs)
+		proxyLabel.Show()
 	} else {
 		statusLabel.SetText("DISCONNECTED")
 		connectBtn.SetText("CONNECT")
 		connectBtn.Importance = widget.HighImportance
+		socksCheck.Enable()
+		pacCheck.Enable()
+		proxyLabel.Hide()
 	}
 	connectBtn.Enable()
 	if connectBtn.OnTapped == nil {
 		connectBtn.OnTapped = handleConnectToggle
 	}
 }
+
+func showSettingsView() {
+	clearViewState()
+
+	backendEntry := widget.NewEntry()
+	backendEntry.SetPlaceHolder(defaultBackendURL)
+	backendEntry.SetText(settings.BackendURL)
+
+	transportEntry := widget.NewEntry()
+	transportEntry.SetPlaceHolder("ss://... (instead of the server's access key)")
+	transportEntry.SetText(settings.TransportConfig)
+
+	portEntry := widget.NewEntry()
+	portEntry.SetPlaceHolder("Any free port")
+	if settings.ProxyPort != 0 {
+		portEntry.SetText(strconv.Itoa(settings.ProxyPort))
+	}
+
+	themes := []string{themeNames[ThemeDrFrake], themeNames[ThemeLight], themeNames[ThemeSystem]}
+	themeSelect := widget.NewSelect(themes, nil)
+	themeSelect.SetSelected(themeNames[ThemeDrFrake])
+	if name, ok := themeNames[settings.Theme]; ok {
+		themeSelect.SetSelected(name)
+	}
+
+	autoConnectCheck := widget.NewCheck("Connect when the app starts", nil)
+	autoConnectCheck.SetChecked(settings.AutoConnect)
+
+	bypassEntry := widget.NewMultiLineEntry()
+	bypassEntry.SetPlaceHolder("example.com\n*.corp.example")
+	bypassEntry.SetText(strings.Join(settings.BypassDomains, "\n"))
+	bypassEntry.SetMinRowsVisible(4)
+
+	messageLabel := widget.NewLabel("")
+	messageLabel.Wrapping = fyne.TextWrapWord
+
+	form := widget.NewForm(
+		widget.NewFormItem("Backend URL", backendEntry),
+		widget.NewFormItem("Transport config", transportEntry),
+		widget.NewFormItem("HTTP proxy port", portEntry),
+		widget.NewFormItem("Theme", themeSelect),
+		widget.NewFormItem("", autoConnectCheck),
+		widget.NewFormItem("Bypass domains", bypassEntry),
+	)
+	form.Items[len(form.Items)-1].HintText = "Connected to directly in PAC mode, one per line"
+	form.SubmitText = "Save"
+	form.OnSubmit = func() {
+		next := *settings
+		next.BackendURL = strings.TrimSpace(backendEntry.Text)
+		next.TransportConfig = strings.TrimSpace(transportEntry.Text)
+		next.AutoConnect = autoConnectCheck.Checked
+		for id, name := range themeNames {
+			if name == themeSelect.Selected {
+				next.Theme = id
+			}
+		}
+		next.ProxyPort = 0
+		if text := strings.TrimSpace(portEntry.Text); text != "" {
+			port, err := strconv.Atoi(text)
+			if err != nil || port < 1 || port > 65535 {
+				messageLabel.SetText("The proxy port must be a number from 1 to 65535")
+				return
+			}
+			next.ProxyPort = port
+		}
+		if next.BackendURL != "" {
+			u, err := url.Parse(next.BackendURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				messageLabel.SetText("The backend URL must be an http:// or https:// URL")
+				return
+			}
+			next.BackendURL = strings.TrimSuffix(next.BackendURL, "/")
+		}
+		bypass, err := parseBypassList(bypassEntry.Text)
+		if err != nil {
+			messageLabel.SetText("Invalid bypass domains: " + err.Error())
+			return
+		}
+		next.BypassDomains = bypass
+		if next.TransportConfig != "" {
+			info, err := core.ValidateConfig(next.TransportConfig)
+			if err != nil {
+				messageLabel.SetText(err.Error())
+				return
+			}
+			if info.Xray || info.Smart {
+				messageLabel.SetText("The transport config must be a configurl config, such as ss://, socks5:// or a chain of them")
+				return
+			}
+		}
+
+		backendChanged := next.backendURL() != settings.backendURL()
+		*settings = next
+		if err := saveSettings(settings); err != nil {
+			messageLabel.SetText("Failed to save the settings: " + err.Error())
+			return
+		}
+		applyTheme(settings.Theme)
+		// The PAC file changes right away, for browsers that read it again.
+		if currentPAC != nil {
+			currentPAC.SetPAC(pacFile(currentProxyAddr, currentSOCKSAddr, settings.BypassDomains))
+		}
+
+		messageLabel.SetText("Saved.")
+		if isConnected {
+			messageLabel.SetText("Saved. Proxy changes apply from the next connection.")
+		}
+		// The account is on the old backend.
+		if backendChanged {
+			apiClient = core.NewAuthClient(settings.backendURL())
+			handleLogout()
+		}
+	}
+
+	var notes []string
+	if flagBackendURL != "" {
+		notes = append(notes, "The -api flag overrides the backend URL for this run.")
+	}
+	if flagTransportConfig != "" {
+		notes = append(notes, "The -transport flag overrides the transport config for this run.")
+	}
+	notesLabel := widget.NewLabel(strings.Join(notes, "\n"))
+	notesLabel.Wrapping = fyne.TextWrapWord
+
+	view := container.NewBorder(
+		widget.NewLabelWithStyle("SETTINGS", fyne.TextAlignCenter, fyne.TextStyle{Bold: true}),
+		nil, nil, nil,
+		container.NewVScroll(container.NewPadded(container.NewVBox(form, notesLabel, messageLabel))),
+	)
+	contentArea.Objects = []fyne.CanvasObject{view}
+	contentArea.Refresh()
+}
+
+// storeSettings saves the settings after a change that is made as it is
+// clicked.
+func storeSettings() {
+	if err := saveSettings(settings); err != nil {
+		slog.Error("Failed to save settings", "error", err)
+	}
+}
+
+// refreshServerList shows the servers in the Locations view, if it is shown,
+// in the order of the settings.
+func refreshServerList() {
+	if serverList == nil {
+		return
+	}
+	serverOrder = make([]int, len(allServers))
+	for i := range serverOrder {
+		serverOrder[i] = i
+	}
+	if settings.SortByLatency {
+		slices.SortStableFunc(serverOrder, func(x, y int) int { return compareLatency(allServers[x], allServers[y]) })
+	}
+	serverList.Refresh()
+}
+
+// clearViewState forgets the widgets of the view that is left.
+func clearViewState() {
+	serverList = nil
+	logList = nil
+	statusPane = nil
+	paymentBox = nil
+	planButtons = nil
+	appLog.SetOnChange(nil)
+}
+
+func showLogView() {
+	clearViewState()
+
+	entries := appLog.Entries()
+	list := widget.NewList(
+		func() int { return len(entries) },
+		func() fyne.CanvasObject {
+			label := widget.NewLabel("")
+			label.TextStyle = fyne.TextStyle{Monospace: true}
+			label.Truncation = fyne.TextTruncateEllipsis
+			return label
+		},
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			label := item.(*widget.Label)
+			e := entries[id]
+			switch {
+			case e.Level >= slog.LevelError:
+				label.Importance = widget.DangerImportance
+			case e.Level >= slog.LevelWarn:
+				label.Importance = widget.WarningImportance
+			default:
+				label.Importance = widget.MediumImportance
+			}
+			label.SetText(e.String())
+		},
+	)
+	logList = list
+	appLog.SetOnChange(func() {
+		fyne.Do(func() {
+			if logList != list {
+				return
+			}
+			entries = appLog.Entries()
+			list.Refresh()
+			list.ScrollToBottom()
+		})
+	})
+	list.ScrollToBottom()
+
+	copyBtn := widget.NewButtonWithIcon("Copy Log", theme.ContentCopyIcon(), func() {
+		lines := make([]string, len(entries))
+		for i, e := range entries {
+			lines[i] = e.String()
+		}
+		fyne.CurrentApp().Clipboard().SetContent(strings.Join(lines, "\n"))
+	})
+	clearBtn := widget.NewButtonWithIcon("Clear", theme.DeleteIcon(), func() {
+		appLog.Clear()
+		entries = nil
+		list.Refresh()
+	})
+
+	statusPane = container.New(layout.NewFormLayout())
+	updateStatusPane()
+
+	view := container.NewBorder(
+		container.NewVBox(
+			widget.NewLabelWithStyle("CONNECTION", fyne.TextAlignCenter, fyne.TextStyle{Bold: true}),
+			statusPane,
+			widget.NewSeparator(),
+		),
+		container.NewHBox(layout.NewSpacer(), clearBtn, copyBtn),
+		nil, nil,
+		list,
+	)
+	contentArea.Objects = []fyne.CanvasObject{view}
+	contentArea.Refresh()
+}
+
+// updateStatusPane shows the state of the connection in the status pane, if
+// it is shown.
+func updateStatusPane() {
+	if statusPane == nil {
+		return
+	}
+	row := func(name string, value fyne.CanvasObject) []fyne.CanvasObject {
+		return []fyne.CanvasObject{widget.NewLabelWithStyle(name, fyne.TextAlignLeading, fyne.TextStyle{Bold: true}), value}
+	}
+	var objects []fyne.CanvasObject
+	if !isConnected {
+		objects = row("Status", widget.NewLabel("Disconnected"))
+	} else {
+		objects = append(objects, row("Status", widget.NewLabel("Connected since "+connectedAt.Format("15:04:05")))...)
+		objects = append(objects, row("Server", widget.NewLabel(connectedTo))...)
+		objects = append(objects, row("HTTP proxy", copyableLabel(currentProxyAddr))...)
+		if currentSOCKSAddr != "" {
+			objects = append(objects, row("SOCKS5 proxy", copyableLabel(currentSOCKSAddr))...)
+		}
+		if currentPACURL != "" {
+			objects = append(objects, row("PAC file", copyableLabel(currentPACURL))...)
+		}
+	}
+	statusPane.Objects = objects
+	statusPane.Refresh()
+}
+
+// copyableLabel shows text with a button copying it to the clipboard.
+func copyableLabel(text string) fyne.CanvasObject {
+	label := widget.NewLabel(text)
+	label.TextStyle = fyne.TextStyle{Monospace: true}
+	copyBtn := widget.NewButtonWithIcon("", theme.ContentCopyIcon(), func() {
+		fyne.CurrentApp().Clipboard().SetContent(text)
+	})
+	copyBtn.Importance = widget.LowImportance
+	return container.NewBorder(nil, nil, nil, copyBtn, label)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"cmp"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"drfrake-core"
+	"fyne.io/fyne/v2"
+)
+
+const (
+	// latencyWorkers is how many servers are measured at once.
+	latencyWorkers = 8
+	// latencyInterval is how often the servers are measured again.
+	latencyInterval = 2 * time.Minute
+)
+
+// latencyUnreachable is the Latency of servers that didn't answer.
+const latencyUnreachable = -1
+
+var (
+	latencyMu sync.Mutex
+	latencies = map[string]int{} // Of servers in ms, by ID, as last measured
+	measuring bool               // Whether measureLatencies is running
+)
+
+// startLatencyProber measures the servers every latencyInterval.
+func startLatencyProber() {
+	go func() {
+		for range time.Tick(latencyInterval) {
+			fyne.Do(measureServers)
+		}
+	}()
+}
+
+// measureServers measures the servers of the list in the background and shows
+// the latencies as they come in. It does nothing if a measurement runs.
+func measureServers() {
+	latencyMu.Lock()
+	if measuring {
+		latencyMu.Unlock()
+		return
+	}
+	measuring = true
+	latencyMu.Unlock()
+
+	servers := slices.Clone(allServers)
+	go func() {
+		measureLatencies(servers, func() { fyne.Do(applyLatencies) })
+		latencyMu.Lock()
+		measuring = false
+		latencyMu.Unlock()
+	}()
+}
+
+// measureLatencies measures servers, a few at a time, calling measured after
+// each. Locked servers have no config and are skipped.
+func measureLatencies(servers []Server, measured func()) {
+	jobs := make(chan Server)
+	var wg sync.WaitGroup
+	for range min(latencyWorkers, len(servers)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range jobs {
+				ms := latencyUnreachable
+				if latency, err := core.MeasureServerLatency(s.Config); err != nil {
+					slog.Warn("Server didn't answer", "server", s.ID, "error", err)
+				} else {
+					ms = max(1, int(latency.Milliseconds()))
+				}
+				latencyMu.Lock()
+				latencies[s.ID] = ms
+				latencyMu.Unlock()
+				measured()
+			}
+		}()
+	}
+	for _, s := range servers {
+		if !s.Locked && s.Config != "" {
+			jobs <- s
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// applyLatencies sets Latency of the servers that were measured.
+func applyLatencies() {
+	latencyMu.Lock()
+	for i := range allServers {
+		allServers[i].Latency = latencies[allServers[i].ID]
+	}
+	latencyMu.Unlock()
+	refreshServerList()
+}
+
+// compareLatency orders servers by latency, fastest first, then the ones not
+// measured yet, then the unreachable ones.
+func compareLatency(x, y Server) int {
+	rank := func(s Server) int {
+		switch {
+		case s.Latency > 0:
+			return 0
+		case s.Latency == 0:
+			return 1
+		default:
+			return 2
+		}
+	}
+	return cmp.Or(cmp.Compare(rank(x), rank(y)), cmp.Compare(x.Latency, y.Latency))
+}
@@ -2,12 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"os"
+	"strconv"
 
+	"drfrake-core"
 	"fyne.io/fyne/v2/app"
 	"golang.getoutline.org/sdk/x/configurl"
 	"golang.getoutline.org/sdk/x/httpproxy"
@@ -16,15 +20,23 @@ import (
 var (
 	proxyServer      *http.Server
 	currentProxyAddr string
+	socksListener    net.Listener
+	currentSOCKSAddr string      // Empty without the SOCKS5 proxy
+	currentPACURL    string      // Empty without the PAC file
+	currentPAC       *pacHandler // Nil without the PAC file
 )
 
+const pacPath = "/proxy.pac"
+
 func startVPN(config string) error {
-	dialer, err := configurl.NewDefaultProviders().NewStreamDialer(context.Background(), config)
+	providers := configurl.NewDefaultProviders()
+	dialer, err := providers.NewStreamDialer(context.Background(), config)
 	if err != nil {
 		return fmt.Errorf("failed to create dialer: %w", err)
 	}
+	dialer = loggingStreamDialer{dialer}
 
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(settings.ProxyPort)))
 	if err != nil {
 		return fmt.Errorf("failed to listen: %w", err)
 	}
@@ -32,18 +44,51 @@ func startVPN(config string) error {
 	currentProxyAddr = listener.Addr().String()
 	host, port, _ := net.SplitHostPort(currentProxyAddr)
 
+	if settings.SOCKS {
+		packetListener, err := providers.NewPacketListener(context.Background(), config)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to create packet listener: %w", err)
+		}
+		socksListener, err = net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to listen: %w", err)
+		}
+		currentSOCKSAddr = socksListener.Addr().String()
+		slog.Info("SOCKS5 proxy started", "address", currentSOCKSAddr)
+		socksServer := core.NewSOCKSServer(dialer, packetListener)
+		go func(l net.Listener) {
+			if err := socksServer.Serve(l); err != nil && !errors.Is(err, net.ErrClosed) {
+				slog.Error("SOCKS5 server failed", "error", err)
+			}
+		}(socksListener)
+	}
+
+	var handler http.Handler = httpproxy.NewProxyHandler(dialer)
+	if settings.PAC {
+		currentPACURL = "http://" + currentProxyAddr + pacPath
+		currentPAC = &pacHandler{proxy: handler, pac: pacFile(currentProxyAddr, currentSOCKSAddr, settings.BypassDomains)}
+		handler = currentPAC
+	}
 	proxyServer = &http.Server{
-		Handler: httpproxy.NewProxyHandler(dialer),
+		Handler: handler,
 	}
 
+	slog.Info("HTTP proxy started", "address", currentProxyAddr, "pac", currentPACURL)
 	go func() {
 		if err := proxyServer.Serve(listener); err != nil && err != http.ErrServerClosed {
-			log.Printf("Proxy server error: %v\n", err)
+			slog.Error("Proxy server failed", "error", err)
 		}
 	}()
 
-	if err := setSystemProxy(host, port); err != nil {
-		proxyServer.Close()
+	if settings.PAC {
+		err = setSystemProxyPAC(currentPACURL)
+	} else {
+		err = setSystemProxy(host, port)
+	}
+	if err != nil {
+		stopVPN()
 		return fmt.Errorf("failed to set system proxy: %w", err)
 	}
 
@@ -55,17 +100,38 @@ func stopVPN() error {
 		proxyServer.Close()
 		proxyServer = nil
 	}
+	if socksListener != nil {
+		socksListener.Close()
+		socksListener = nil
+	}
+	if currentProxyAddr != "" {
+		slog.Info("Proxies stopped")
+	}
+	currentProxyAddr, currentSOCKSAddr, currentPACURL = "", "", ""
+	currentPAC = nil
 	return unsetSystemProxy()
 }
 
 func main() {
-	transportConfig := flag.String("transport", "", "Transport config (ss://...)")
+	flag.StringVar(&flagTransportConfig, "transport", "", "Transport config (ss://...), instead of the one in the settings")
+	flag.StringVar(&flagBackendURL, "api", "", "Backend API URL, instead of the one in the settings")
 	flag.Parse()
 
+	// The log view shows what is logged, also with log.
+	slog.SetDefault(slog.New(newLogHandler(appLog, slog.NewTextHandler(os.Stderr, nil))))
+
+	var err error
+	settings, err = loadSettings()
+	if err != nil {
+		slog.Warn("Failed to load settings, using defaults", "error", err)
+	}
+
+	apiClient = core.NewAuthClient(settings.backendURL())
+
 	myApp := app.New()
 	win := setupGUI(myApp)
 
-	log.Printf("Starting Dr. Frake VPN with config: %s\n", *transportConfig)
+	slog.Info("Starting Dr. Frake VPN", "backend", settings.backendURL())
 
 	win.ShowAndRun()
 
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// pacLocal is the part of the PAC file that keeps local hosts off the
+// proxies. It checks the host by name only, as isInNet would resolve it.
+const pacLocal = `  if (isPlainHostName(host) || host == "localhost" || dnsDomainIs(host, ".local") ||
+      shExpMatch(host, "127.*") || shExpMatch(host, "10.*") || shExpMatch(host, "192.168.*")) {
+    return "DIRECT";
+  }
+`
+
+// pacBypass is the part of the PAC file that sends the domains of the
+// bypass list, and their subdomains, or those matching a *. pattern, direct.
+const pacBypass = `  for (var i = 0; i < bypass.length; i++) {
+    var d = bypass[i];
+    if (d.charAt(0) == "*" ? shExpMatch(host, d) : host == d || dnsDomainIs(host, "." + d)) {
+      return "DIRECT";
+    }
+  }
+`
+
+// pacFile returns a proxy auto-config file that sends everything but local
+// hosts and the domains of bypass to the proxies: to the SOCKS5 one, if there
+// is one, else to the HTTP one.
+func pacFile(httpAddr, socksAddr string, bypass []string) string {
+	proxies := "PROXY " + httpAddr
+	if socksAddr != "" {
+		proxies = fmt.Sprintf("SOCKS5 %s; %s", socksAddr, proxies)
+	}
+	if bypass == nil {
+		bypass = []string{}
+	}
+	list, _ := json.Marshal(bypass)
+	var b strings.Builder
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+	b.WriteString("  host = host.toLowerCase();\n")
+	b.WriteString(pacLocal)
+	fmt.Fprintf(&b, "  var bypass = %s;\n", list)
+	b.WriteString(pacBypass)
+	fmt.Fprintf(&b, "  return %q;\n}\n", proxies)
+	return b.String()
+}
+
+var bypassDomainRE = regexp.MustCompile(`^(\*\.)?[a-z0-9-]+(\.[a-z0-9-]+)*$`)
+
+// parseBypassList parses the domains of text, separated by lines, commas or
+// spaces, for the bypass list. A domain also stands for its subdomains;
+// *.example.com only for the subdomains. Lines starting with # are comments.
+func parseBypassList(text string) ([]string, error) {
+	var domains []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, d := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\r' }) {
+			d = strings.TrimPrefix(strings.ToLower(d), ".")
+			if !bypassDomainRE.MatchString(d) {
+				return nil, fmt.Errorf("%q is not a domain", d)
+			}
+			domains = append(domains, d)
+		}
+	}
+	return domains, nil
+}
+
+// pacHandler serves the PAC file next to the HTTP proxy, which gets all other
+// requests.
+type pacHandler struct {
+	proxy http.Handler
+	mu    sync.Mutex
+	pac   string
+}
+
+// SetPAC changes the PAC file served. Browsers read it again when they
+// restart or the network changes.
+func (h *pacHandler) SetPAC(pac string) {
+	h.mu.Lock()
+	h.pac = pac
+	h.mu.Unlock()
+}
+
+func (h *pacHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Requests to the proxy itself, rather than through it, have no host in
+	// the URL.
+	if r.Method == http.MethodGet && r.URL.Host == "" && r.URL.Path == pacPath {
+		h.mu.Lock()
+		pac := h.pac
+		h.mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+		w.Header().Set("Cache-Control", "no-cache")
+		io.WriteString(w, pac)
+		return
+	}
+	h.proxy.ServeHTTP(w, r)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"drfrake-core"
+	"fyne.io/fyne/v2"
+)
+
+const (
+	// paymentPollInterval is how often the status of a payment is checked
+	// while the user pays in the browser.
+	paymentPollInterval = 3 * time.Second
+	// paymentTimeout is how long the user has to pay.
+	paymentTimeout = 15 * time.Minute
+)
+
+// paidPlan is a plan the user can buy.
+type paidPlan struct {
+	Name  string // As the backend calls it
+	Title string
+	Price string
+}
+
+// paidPlans are the plans the backend takes payments for.
+var paidPlans = []paidPlan{
+	{Name: "monthly", Title: "PREMIUM MONTHLY", Price: "299 ₽ per month"},
+	{Name: "yearly", Title: "PREMIUM YEARLY", Price: "2990 ₽ per year"},
+}
+
+var errPaymentCanceled = errors.New("payment canceled")
+
+// The payment in progress, set on the UI thread.
+var (
+	payingFor     string             // Plan, empty if there is no payment in progress
+	paymentURL    *url.URL           // Where the user confirms the payment, once known
+	paymentStatus string             // Of the payment in progress, or how the last one ended
+	cancelPayment context.CancelFunc // Stops waiting for the payment
+)
+
+// startPayment starts paying for plan: it opens the page confirming the
+// payment in the browser and waits for the backend to confirm it. Only then
+// is the account refreshed, which unlocks the premium servers.
+func startPayment(plan paidPlan) {
+	if payingFor != "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), paymentTimeout)
+	payingFor, paymentURL, cancelPayment = plan.Name, nil, cancel
+	setPaymentStatus("Starting the payment...")
+	go func() {
+		err := payForPlan(ctx, plan.Name)
+		fyne.Do(func() {
+			cancel()
+			payingFor, paymentURL, cancelPayment = "", nil, nil
+			switch {
+			case err == nil:
+				slog.Info("Payment confirmed", "plan", plan.Name)
+				setPaymentStatus("Payment confirmed. Welcome to Premium!")
+				refreshAccount()
+			case errors.Is(err, context.Canceled):
+				slog.Info("Stopped waiting for the payment", "plan", plan.Name)
+				setPaymentStatus("")
+			case errors.Is(err, context.DeadlineExceeded):
+				slog.Warn("Payment timed out", "plan", plan.Name)
+				setPaymentStatus("The payment wasn't confirmed in time. If you paid, your plan is updated shortly.")
+			case errors.Is(err, errPaymentCanceled):
+				slog.Warn("Payment canceled", "plan", plan.Name)
+				setPaymentStatus("The payment was canceled.")
+			default:
+				slog.Error("Payment failed", "plan", plan.Name, "error", err)
+				setPaymentStatus("Payment failed: " + err.Error())
+			}
+		})
+	}()
+}
+
+// stopPayment stops waiting for the payment in progress, if any. A payment
+// the user completes later is still applied by the backend.
+func stopPayment() {
+	if cancelPayment != nil {
+		cancelPayment()
+	}
+}
+
+// payForPlan creates a payment for plan, opens its confirmation page and
+// waits until the backend reports it succeeded.
+func payForPlan(ctx context.Context, plan string) error {
+	payment, err := apiClient.InitPayment(ctx, plan)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(payment.ConfirmationURL)
+	if err != nil || payment.ConfirmationURL == "" {
+		return errors.New("the backend sent no confirmation URL")
+	}
+	slog.Info("Payment started", "plan", plan, "id", payment.ID)
+	fyne.Do(func() {
+		paymentURL = u
+		setPaymentStatus("Complete the payment in your browser. Waiting for confirmation...")
+		if err := fyne.CurrentApp().OpenURL(u); err != nil {
+			slog.Warn("Failed to open the browser", "error", err)
+		}
+	})
+	return waitForPayment(ctx, payment.ID)
+}
+
+// waitForPayment polls the status of the payment with id until it succeeded
+// or was canceled, or ctx is done.
+func waitForPayment(ctx context.Context, id string) error {
+	ticker := time.NewTicker(paymentPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		check, err := apiClient.CheckPayment(ctx, id)
+		if err != nil {
+			// The backend may be unreachable for a moment; keep trying.
+			slog.Warn("Failed to check payment", "id", id, "error", err)
+			continue
+		}
+		switch check.Status {
+		case core.PaymentSucceeded:
+			return nil
+		case core.PaymentCanceled:
+			return errPaymentCanceled
+		}
+	}
+}
+
+// setPaymentStatus shows status in the Pricing view, if shown.
+func setPaymentStatus(status string) {
+	paymentStatus = status
+	updatePaymentBox()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"drfrake-core"
+)
+
+// Themes of the Theme setting.
+const (
+	ThemeDrFrake = "drfrake" // The default
+	ThemeLight   = "light"
+	ThemeSystem  = "system" // Fyne's, light or dark as the system is
+)
+
+// Settings are the preferences kept between runs.
+type Settings struct {
+	BackendURL string `json:"backendUrl,omitempty"` // Empty for defaultBackendURL
+	// TransportConfig is connected with instead of the access key of a
+	// server, if set. It is kept in the keystore; settings.json only has it
+	// from earlier versions.
+	TransportConfig string `json:"transportConfig,omitempty"`
+	ProxyPort       int    `json:"proxyPort,omitempty"` // Of the HTTP proxy, 0 for any free port
+	Theme           string `json:"theme,omitempty"`
+	// AutoConnect connects to LastServerID once logged in, or right away
+	// with TransportConfig.
+	AutoConnect  bool   `json:"autoConnect,omitempty"`
+	LastServerID string `json:"lastServerId,omitempty"`
+	SOCKS        bool   `json:"socks,omitempty"` // Also start a SOCKS5 proxy
+	PAC          bool   `json:"pac,omitempty"`   // Set the system proxy with a PAC file
+	// BypassDomains are connected to directly rather than through the
+	// proxies, in PAC mode.
+	BypassDomains []string `json:"bypassDomains,omitempty"`
+	// SortByLatency lists the fastest servers first in Locations.
+	SortByLatency bool `json:"sortByLatency,omitempty"`
+}
+
+// settings are the current settings.
+var settings = &Settings{}
+
+// The flags of this run, which take precedence over the settings without
+// being saved.
+var flagBackendURL, flagTransportConfig string
+
+func getConfigDir() string {
+	configDir, _ := os.UserConfigDir()
+	return filepath.Join(configDir, "DrFrakeVPNBusiness")
+}
+
+func getSettingsPath() string {
+	return filepath.Join(getConfigDir(), "settings.json")
+}
+
+// Keys of the secrets in the keystore.
+const (
+	transportConfigKey = "transport-config"
+	sessionKey         = "session" // The token of the backend
+)
+
+// keystore keeps the secrets: the transport config, which may be an access
+// key, and the session.
+var keystore = core.NewKeystore("DrFrakeVPNBusiness", getConfigDir())
+
+// loadSettings reads settings.json. Without one, the defaults are used.
+func loadSettings() (*Settings, error) {
+	s := &Settings{}
+	data, err := os.ReadFile(getSettingsPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return &Settings{}, err
+	}
+	if s.TransportConfig != "" {
+		// Move it out of the settings of an earlier version.
+		if err := saveSettings(s); err != nil {
+			slog.Warn("Failed to move the transport config to the keystore", "error", err)
+		}
+		return s, nil
+	}
+	config, err := keystore.Get(transportConfigKey)
+	if err != nil && !errors.Is(err, core.ErrSecretNotFound) {
+		return s, err
+	}
+	s.TransportConfig = string(config)
+	return s, nil
+}
+
+func saveSettings(s *Settings) error {
+	if s.TransportConfig != "" {
+		if err := keystore.Set(transportConfigKey, []byte(s.TransportConfig)); err != nil {
+			return err
+		}
+	} else if err := keystore.Delete(transportConfigKey); err != nil {
+		return err
+	}
+	plain := *s
+	plain.TransportConfig = ""
+	data, err := json.MarshalIndent(plain, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(getConfigDir(), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(getSettingsPath(), data, 0600)
+}
+
+// backendURL is the URL of the backend API to use.
+func (s *Settings) backendURL() string {
+	if flagBackendURL != "" {
+		return flagBackendURL
+	}
+	if s.BackendURL != "" {
+		return s.BackendURL
+	}
+	return defaultBackendURL
+}
+
+// transportConfig is the config to connect with instead of the access key of
+// a server, if any.
+func (s *Settings) transportConfig() string {
+	if flagTransportConfig != "" {
+		return flagTransportConfig
+	}
+	return s.TransportConfig
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/driver/desktop"
+)
+
+var (
+	trayMenu        *fyne.Menu // Nil without a system tray
+	trayStatusItem  *fyne.MenuItem
+	trayConnectItem *fyne.MenuItem
+)
+
+// setupTray adds the menu to the system tray, on desktops, and makes closing
+// win hide it to the tray instead, leaving the proxy running.
+func setupTray(myApp fyne.App, win fyne.Window) {
+	desk, ok := myApp.(desktop.App)
+	if !ok {
+		return
+	}
+	trayStatusItem = fyne.NewMenuItem("", nil)
+	trayStatusItem.Disabled = true
+	trayConnectItem = fyne.NewMenuItem("", handleConnectToggle)
+	showItem := fyne.NewMenuItem("Show Dr. Frake VPN", func() {
+		win.Show()
+		win.RequestFocus()
+	})
+	// Quitting stops the proxy, see main.
+	quitItem := fyne.NewMenuItem("Quit", myApp.Quit)
+	quitItem.IsQuit = true
+	trayMenu = fyne.NewMenu("Dr. Frake VPN",
+		trayStatusItem,
+		fyne.NewMenuItemSeparator(),
+		trayConnectItem,
+		showItem,
+		fyne.NewMenuItemSeparator(),
+		quitItem,
+	)
+	updateTrayMenu()
+	desk.SetSystemTrayMenu(trayMenu)
+	desk.SetSystemTrayWindow(win)
+	win.SetCloseIntercept(win.Hide)
+}
+
+// updateTrayMenu shows the state of the connection in the tray menu.
+func updateTrayMenu() {
+	if trayMenu == nil {
+		return
+	}
+	trayConnectItem.Disabled = false
+	switch {
+	case connecting:
+		trayStatusItem.Label = "Connecting..."
+		trayConnectItem.Label = "Connect"
+		trayConnectItem.Disabled = true
+	case isConnected:
+		trayStatusItem.Label = "Connected to " + connectedTo
+		trayConnectItem.Label = "Disconnect"
+	case settings.transportConfig() != "":
+		trayStatusItem.Label = "Disconnected"
+		trayConnectItem.Label = "Connect with the custom transport"
+	case activeServer != nil:
+		trayStatusItem.Label = "Disconnected"
+		trayConnectItem.Label = "Connect to " + activeServer.Country
+	default:
+		trayStatusItem.Label = "Disconnected"
+		trayConnectItem.Label = "Connect"
+		trayConnectItem.Disabled = true // No server to connect to
+	}
+	trayMenu.Refresh()
+}
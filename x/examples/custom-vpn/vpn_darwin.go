@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+
+	"golang.getoutline.org/sdk/x/sysproxy"
+)
+
+// setSystemProxy sets the web proxy of the active network service with
+// networksetup.
+func setSystemProxy(address string, port string) error {
+	slog.Info("Setting system proxy", "address", net.JoinHostPort(address, port))
+	return sysproxy.SetWebProxy(address, port)
+}
+
+func setSystemProxyPAC(url string) error {
+	slog.Info("Setting system proxy auto-config URL", "url", url)
+	return sysproxy.SetAutoConfigURL(url)
+}
+
+func unsetSystemProxy() error {
+	slog.Info("Unsetting system proxy")
+	return errors.Join(sysproxy.DisableWebProxy(), sysproxy.DisableAutoConfigURL())
+}
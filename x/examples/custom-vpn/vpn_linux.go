@@ -0,0 +1,203 @@
+//go:build !android
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.getoutline.org/sdk/x/sysproxy"
+)
+
+// linuxProxy is a place Linux desktops take the system proxy from.
+type linuxProxy struct {
+	name      string
+	available func() bool
+	set       func(host, port string) error
+	setPAC    func(url string) error // nil if it takes no PAC file
+	unset     func() error
+}
+
+// linuxProxies are the desktop settings the proxy is set in, all that are
+// there: GNOME's are also read by Cinnamon, MATE, Budgie and by Chrome,
+// KDE's by KDE apps. Without either, envProxy is used.
+var linuxProxies = []linuxProxy{
+	{"GNOME", func() bool { return hasCommand("gsettings") }, sysproxy.SetWebProxy, sysproxy.SetAutoConfigURL, sysproxy.DisableWebProxy},
+	{"KDE", isKDE, setKDEProxy, setKDEProxyPAC, unsetKDEProxy},
+}
+
+// envProxy sets the proxy variables for the apps of the user's session.
+var envProxy = linuxProxy{"environment", func() bool { return true }, setEnvProxy, nil, unsetEnvProxy}
+
+// activeProxies are the ones setSystemProxy set, for unsetSystemProxy.
+var activeProxies []linuxProxy
+
+func setSystemProxy(address string, port string) error {
+	slog.Info("Setting system proxy", "address", net.JoinHostPort(address, port))
+	for _, p := range linuxProxies {
+		if !p.available() {
+			continue
+		}
+		if err := p.set(address, port); err != nil {
+			slog.Warn("Failed to set the proxy", "desktop", p.name, "error", err)
+			continue
+		}
+		activeProxies = append(activeProxies, p)
+	}
+	if len(activeProxies) > 0 {
+		return nil
+	}
+	if err := envProxy.set(address, port); err != nil {
+		return err
+	}
+	activeProxies = append(activeProxies, envProxy)
+	return nil
+}
+
+func setSystemProxyPAC(pacURL string) error {
+	slog.Info("Setting system proxy auto-config URL", "url", pacURL)
+	for _, p := range linuxProxies {
+		if !p.available() || p.setPAC == nil {
+			continue
+		}
+		if err := p.setPAC(pacURL); err != nil {
+			slog.Warn("Failed to set the proxy", "desktop", p.name, "error", err)
+			continue
+		}
+		activeProxies = append(activeProxies, p)
+	}
+	if len(activeProxies) > 0 {
+		return nil
+	}
+	// The environment takes no PAC file, but the HTTP proxy serving it does
+	// for all that it sends to a proxy.
+	u, err := url.Parse(pacURL)
+	if err != nil {
+		return err
+	}
+	if err := envProxy.set(u.Hostname(), u.Port()); err != nil {
+		return err
+	}
+	activeProxies = append(activeProxies, envProxy)
+	return nil
+}
+
+func unsetSystemProxy() error {
+	slog.Info("Unsetting system proxy")
+	var errs []error
+	for _, p := range activeProxies {
+		if err := p.unset(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to unset the %s proxy: %w", p.name, err))
+		}
+	}
+	activeProxies = nil
+	return errors.Join(errs...)
+}
+
+func hasCommand(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// kwriteconfig returns the kwriteconfig of the installed KDE version.
+func kwriteconfig() string {
+	for _, name := range []string{"kwriteconfig6", "kwriteconfig5"} {
+		if hasCommand(name) {
+			return name
+		}
+	}
+	return ""
+}
+
+func isKDE() bool {
+	return strings.Contains(strings.ToUpper(os.Getenv("XDG_CURRENT_DESKTOP")), "KDE") && kwriteconfig() != ""
+}
+
+// setKDEProxy sets a manual proxy in kioslaverc.
+func setKDEProxy(host, port string) error {
+	proxy := fmt.Sprintf("http://%s %s", host, port)
+	for _, setting := range [][2]string{{"httpProxy", proxy}, {"httpsProxy", proxy}, {"ProxyType", "1"}} {
+		if err := kdeSetProxySetting(setting[0], setting[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setKDEProxyPAC sets a proxy auto-config URL in kioslaverc.
+func setKDEProxyPAC(pacURL string) error {
+	if err := kdeSetProxySetting("Proxy Config Script", pacURL); err != nil {
+		return err
+	}
+	return kdeSetProxySetting("ProxyType", "2")
+}
+
+func unsetKDEProxy() error {
+	return kdeSetProxySetting("ProxyType", "0")
+}
+
+func kdeSetProxySetting(key, value string) error {
+	if err := exec.Command(kwriteconfig(), "--file", "kioslaverc", "--group", "Proxy Settings", "--key", key, value).Run(); err != nil {
+		return fmt.Errorf("kwriteconfig command failed: %w", err)
+	}
+	// Running KDE apps only read the file again when told to.
+	exec.Command("dbus-send", "--type=signal", "/KIO/Scheduler", "org.kde.KIO.Scheduler.reparseSlaveConfiguration", "string:").Run()
+	return nil
+}
+
+// proxyEnvFile is the file setting the proxy variables of the sessions
+// systemd starts from now on.
+func proxyEnvFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "environment.d", "90-drfrake-proxy.conf"), nil
+}
+
+// setEnvProxy sets http_proxy and the like for the apps the user starts from
+// now on: those systemd starts right away, others after logging in again.
+func setEnvProxy(host, port string) error {
+	proxy := "http://" + net.JoinHostPort(host, port)
+	vars := []string{
+		"http_proxy=" + proxy,
+		"https_proxy=" + proxy,
+		"HTTP_PROXY=" + proxy,
+		"HTTPS_PROXY=" + proxy,
+		"no_proxy=localhost,127.0.0.0/8,::1",
+		"NO_PROXY=localhost,127.0.0.0/8,::1",
+	}
+	path, err := proxyEnvFile()
+	if err != nil {
+		return fmt.Errorf("failed to find the config dir: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(vars, "\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	exec.Command("systemctl", append([]string{"--user", "set-environment"}, vars...)...).Run()
+	slog.Info("No desktop proxy settings found, set the proxy in the environment", "file", path)
+	return nil
+}
+
+func unsetEnvProxy() error {
+	exec.Command("systemctl", "--user", "unset-environment",
+		"http_proxy", "https_proxy", "HTTP_PROXY", "HTTPS_PROXY", "no_proxy", "NO_PROXY").Run()
+	path, err := proxyEnvFile()
+	if err != nil {
+		return fmt.Errorf("failed to find the config dir: %w", err)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
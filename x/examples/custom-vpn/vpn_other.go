@@ -1,4 +1,4 @@
-//go:build !windows
+//go:build !windows && !darwin && (!linux || android)
 
 package main
 
@@ -8,6 +8,10 @@ func setSystemProxy(address string, port string) error {
 	return errors.New("system proxy not supported on this platform yet")
 }
 
+func setSystemProxyPAC(url string) error {
+	return errors.New("system proxy not supported on this platform yet")
+}
+
 func unsetSystemProxy() error {
 	return nil
 }
@@ -3,17 +3,24 @@
 package main
 
 import (
-	"log"
+	"errors"
+	"log/slog"
+	"net"
 
 	"golang.getoutline.org/sdk/x/sysproxy"
 )
 
 func setSystemProxy(address string, port string) error {
-	log.Printf("Setting system proxy to %s:%s\n", address, port)
+	slog.Info("Setting system proxy", "address", net.JoinHostPort(address, port))
 	return sysproxy.SetWebProxy(address, port)
 }
 
+func setSystemProxyPAC(url string) error {
+	slog.Info("Setting system proxy auto-config URL", "url", url)
+	return sysproxy.SetAutoConfigURL(url)
+}
+
 func unsetSystemProxy() error {
-	log.Println("Unsetting system proxy")
-	return sysproxy.DisableWebProxy()
+	slog.Info("Unsetting system proxy")
+	return errors.Join(sysproxy.DisableWebProxy(), sysproxy.DisableAutoConfigURL())
 }
@@ -3,15 +3,27 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"drfrake-core"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
 	"golang.getoutline.org/sdk/network"
 	"golang.getoutline.org/sdk/network/lwip2transport"
+	"golang.getoutline.org/sdk/transport"
 	"golang.getoutline.org/sdk/x/configurl"
 )
 
@@ -23,27 +35,85 @@ type Session struct {
 
 // App struct
 type App struct {
-	ctx          context.Context
-	tunDevice    *WindowsTUN
-	lwipDevice   network.IPDevice
-	isConnected  bool
-	activeConfig string
-	subDB        *SubscriptionDB
-	currentUser  *User
-	config       *Config
-	apiClient    *APIClient
-	authToken    string
-	xrayManager  *XrayManager
+	ctx           context.Context
+	tunDevice     TUNDevice
+	lwipDevice    network.IPDevice
+	isConnected   bool
+	activeConfig  string
+	profileID     string // Of the logged in account, see Profile
+	subCache      *SubscriptionCache
+	serverCache   *ServerCache
+	serverSub     *ServerSubscription
+	stopServerSub chan struct{}
+	updater       *Updater
+	stopUpdater   chan struct{}
+	stopNetworks  chan struct{}
+	recheckRules  chan struct{} // Applies the network rules again
+	currentUser   *User
+	config        *Config
+	configMu      sync.Mutex // Guards saving config and its server lists
+	apiClient     *core.AuthClient
+	authToken     string
+	xrayManager   *XrayManager
+	xrayLog       *xrayLog
+	appLog        *rotatingFile // app.log, which the log package writes to
+	events        *eventLog
+	privileged    Privileged // The helper service, or the app itself
+	killSwitch    KillSwitcher
+	systemProxy   *SystemProxy
+	proxyServer   *http.Server       // Local proxy while connected in proxy mode
+	dnsForwarder  *core.DNSForwarder // Answers DNS through the tunnel while connected
+	netMonitor    *NetworkMonitor
+	serverAddrs   []netip.Addr // Of the server while connected
+
+	// connMu serializes bringing the tunnel up and down, by the user and by
+	// the supervisor.
+	connMu         sync.Mutex
+	stopSupervisor chan struct{}
+	tunnelDialer   transport.StreamDialer   // Of the tunnel while connected
+	tunnelListener transport.PacketListener // Of the tunnel while connected
+	hostAddrs      map[string][]netip.Addr  // Last resolved addresses of servers
+	pausedUntil    time.Time                // Zero unless paused, see Pause
+	pauseTimer     *time.Timer
+	paused         atomic.Bool // Whether traffic bypasses the tunnel
+
+	statusMu sync.Mutex
+	status   ConnectionStatus
+	servers  ServerListStatus // Of the last GetServers
+	udp      UDPSupport       // Of the tunnel, see checkUDP
+	udpCheck uint64           // Counts checkUDP calls
+
+	latencyMu sync.Mutex
+	latencies map[string]int // Of servers in ms, by ID, as last measured
+
+	traffic    *core.TrafficCounter
+	statsSince atomic.Pointer[time.Time] // Nil while disconnected
+
+	// routesMu guards the split tunnel routes, which are refreshed in the
+	// background while connected.
+	routesMu    sync.Mutex
+	splitRoutes SplitRoutes
+	stopRefresh chan struct{}
 }
 
 // NewApp creates a new App application struct
 func NewApp() *App {
-	return &App{}
+	return &App{
+		hostAddrs: make(map[string][]netip.Addr),
+		status:    ConnectionStatus{State: StateDisconnected},
+		xrayLog:   newXrayLog(),
+		appLog:    &rotatingFile{path: filepath.Join(GetConfigDir(), "app.log"), maxSize: logFileMaxSize, backups: logFileBackups},
+		events:    newEventLog(),
+		traffic:   core.NewTrafficCounter(),
+	}
 }
 
 // startup is called when the app starts.
 func (a *App) startup(ctx context.Context) {
 	a.ctx = ctx
+	os.MkdirAll(GetConfigDir(), 0755)
+	// Kept for support bundles, see ExportSupportBundle.
+	log.SetOutput(io.MultiWriter(os.Stderr, a.appLog))
 
 	// Load Config
 	var err error
@@ -51,6 +121,7 @@ func (a *App) startup(ctx context.Context) {
 	if err != nil {
 		log.Printf("Failed to load config: %v (using defaults)", err)
 	}
+	a.xrayLog.SetFileLogging(a.config.Settings.XrayLogFile)
 
 	// Initialize API Client for backend communication
 	backendURL := "http://31.135.65.188:8080"
@@ -58,93 +129,140 @@ func (a *App) startup(ctx context.Context) {
 		backendURL = a.config.BackendURL
 	}
 	log.Printf("Using Backend URL: %s", backendURL)
-	a.apiClient = NewAPIClient(backendURL)
+	a.apiClient = core.NewAuthClient(backendURL)
+	a.apiClient.DeviceID, a.apiClient.DeviceName = a.deviceID(), deviceName()
 	log.Printf("API Client initialized: %s", backendURL)
 
-	// Initialize SQLite database (still used for local subscription/payment data)
-	dataDir, err := os.UserConfigDir()
-	if err != nil {
-		dataDir = "."
+	// The subscription itself lives on the backend; this only keeps the last
+	// known state for when it can't be reached.
+	a.useProfile(loadProfiles().Active)
+	a.serverSub = NewServerSubscription(filepath.Join(GetConfigDir(), "server_subscription.json"))
+	a.stopServerSub = make(chan struct{})
+	go a.serverSub.Run(a.stopServerSub)
+
+	a.updater = NewUpdater(a.config.UpdateManifestURL)
+	a.stopUpdater = make(chan struct{})
+	go a.updater.Run(a.stopUpdater, func(status UpdateStatus) {
+		runtime.EventsEmit(a.ctx, "update:available", status)
+	})
+
+	a.privileged = connectPrivileged()
+	if _, ok := a.privileged.(*HelperClient); ok {
+		log.Println("[Helper] Using the helper service.")
+	}
+	// Undo what a run that crashed while connected left behind: the kill
+	// switch, routes, adapter, DNS leak rules and system proxy.
+	a.killSwitch = a.privileged.KillSwitch()
+	a.killSwitch.Recover()
+	if err := a.privileged.RecoverTUN(); err != nil {
+		log.Printf("[Recovery] %v", err)
 	}
-	dbDir := filepath.Join(dataDir, "DrFrakeVPN")
-	os.MkdirAll(dbDir, 0755)
-	dbPath := filepath.Join(dbDir, "drfrake.db")
+	a.systemProxy = NewSystemProxy()
+	a.systemProxy.Recover()
 
-	a.subDB, err = NewSubscriptionDB(dbPath)
-	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+	// Without it, a network change only shows up as failing health checks.
+	if a.netMonitor, err = NewNetworkMonitor(); err != nil {
+		log.Printf("[Network] %v", err)
 	}
-	log.Printf("Database initialized at %s\n", dbPath)
 
 	// Restore session
-	a.loadSession()
+	if a.profileID != "" {
+		if !a.restoreSession(a.getSessionPath()) {
+			a.removeProfile()
+		}
+	} else {
+		a.migrateLegacySession()
+	}
+
+	// With network rules, they decide whether to connect.
+	if a.config.Settings.AutoConnect && !a.config.Settings.NetworkRules.Enabled {
+		go a.autoConnect()
+	}
+	var changes <-chan struct{}
+	if a.netMonitor != nil {
+		changes = a.netMonitor.Subscribe()
+	}
+	a.stopNetworks = make(chan struct{})
+	a.recheckRules = make(chan struct{}, 1)
+	go a.watchNetworks(a.stopNetworks, changes, a.recheckRules)
 }
 
 func (a *App) getSessionPath() string {
-	configDir, _ := os.UserConfigDir()
-	return filepath.Join(configDir, "DrFrakeVPN", "session.json")
+	return filepath.Join(profileDir(a.profileID), "session.json")
 }
 
 func (a *App) saveSession(token, email, plan string) {
 	data, _ := json.Marshal(Session{Token: token, Email: email, Plan: plan})
-	os.WriteFile(a.getSessionPath(), data, 0600)
+	if err := writeSecret(a.getSessionPath(), data); err != nil {
+		log.Printf("[Auth] Failed to save session: %v", err)
+	}
 }
 
-func (a *App) loadSession() {
-	data, err := os.ReadFile(a.getSessionPath())
+// restoreSession logs in with the session saved at path and reports whether
+// it is still valid.
+func (a *App) restoreSession(path string) bool {
+	data, err := readSecret(path)
 	if err != nil {
-		return
+		return false
 	}
 	var s Session
 	if err := json.Unmarshal(data, &s); err != nil {
-		return
+		return false
 	}
 
 	// Validate token by calling the backend API
-	apiUser, err := a.apiClient.ValidateToken(s.Token)
+	a.apiClient.Token = s.Token
+	apiUser, err := a.apiClient.Me(a.ctx)
 	if err != nil {
+		a.apiClient.Token = ""
 		log.Printf("Session expired or invalid: %v", err)
-		a.deleteSession()
-		return
+		deleteSecret(path)
+		return false
 	}
 
+	// The email may have been changed on another device.
+	email := s.Email
+	if apiUser.Email != "" {
+		email = apiUser.Email
+	}
 	a.authToken = s.Token
 	a.currentUser = &User{
 		ID:    apiUser.ID,
-		Email: s.Email,
+		Email: email,
 	}
-	log.Printf("[Auth] Session restored for: %s", s.Email)
-}
-
-func (a *App) deleteSession() {
-	os.Remove(a.getSessionPath())
+	log.Printf("[Auth] Session restored for: %s", email)
+	return true
 }
 
 // shutdown is called when the app quits
 func (a *App) shutdown(ctx context.Context) {
-	if a.isConnected {
-		a.Disconnect()
-	}
-	if a.subDB != nil {
-		a.subDB.Close()
+	a.Disconnect()
+	close(a.stopServerSub)
+	close(a.stopUpdater)
+	close(a.stopNetworks)
+	if a.netMonitor != nil {
+		a.netMonitor.Close()
 	}
+	log.SetOutput(os.Stderr)
+	a.appLog.Close()
 }
 
 // --- Auth Methods ---
 
 func (a *App) Register(email string, password string) (*User, error) {
 	log.Printf("[App] Registering user %s using Backend URL: %s", email, a.apiClient.BaseURL)
-	authResp, err := a.apiClient.Register(email, password)
+	if _, err := a.apiClient.Register(a.ctx, email, password); err != nil {
+		return nil, err
+	}
+	authResp, err := a.apiClient.Login(a.ctx, email, password)
 	if err != nil {
 		return nil, err
 	}
 
-	// Also register locally for subscription tracking
-	a.subDB.Register(email, password)
-
 	user := &User{ID: authResp.User.ID, Email: authResp.User.Email}
 	a.currentUser = user
 	a.authToken = authResp.Token
+	a.saveProfile(user)
 	a.saveSession(authResp.Token, email, authResp.User.Plan)
 	log.Printf("[Auth] User registered via API: %s", email)
 	return user, nil
@@ -152,7 +270,7 @@ func (a *App) Register(email string, password string) (*User, error) {
 
 func (a *App) Login(email string, password string) (*User, error) {
 	log.Printf("[App] Logging in user %s using Backend URL: %s", email, a.apiClient.BaseURL)
-	authResp, err := a.apiClient.Login(email, password)
+	authResp, err := a.apiClient.Login(a.ctx, email, password)
 	if err != nil {
 		return nil, err
 	}
@@ -160,29 +278,71 @@ func (a *App) Login(email string, password string) (*User, error) {
 	user := &User{ID: authResp.User.ID, Email: authResp.User.Email}
 	a.currentUser = user
 	a.authToken = authResp.Token
+	a.saveProfile(user)
 	a.saveSession(authResp.Token, email, authResp.User.Plan)
 	log.Printf("[Auth] User logged in via API: %s", email)
 	return user, nil
 }
 
+// Logout logs out and forgets the account, see AddProfile to keep it.
 func (a *App) Logout() {
-	if a.isConnected {
-		a.Disconnect()
+	a.Disconnect()
+	if a.authToken != "" {
+		if err := a.apiClient.Logout(a.ctx); err != nil {
+			log.Printf("[Auth] Failed to end the session on the backend: %v", err)
+		}
 	}
 	a.currentUser = nil
-	a.deleteSession()
+	a.authToken = ""
+	a.removeProfile()
 }
 
 func (a *App) GetCurrentUser() *User {
 	return a.currentUser
 }
 
+// GetAccount returns the account as known to the backend, including an email
+// change awaiting confirmation.
+func (a *App) GetAccount() (*core.Account, error) {
+	if a.currentUser == nil {
+		return nil, fmt.Errorf("not logged in")
+	}
+	acc, err := a.apiClient.Me(a.ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Pick up a confirmed email change.
+	if acc.Email != a.currentUser.Email {
+		a.currentUser.Email = acc.Email
+		a.saveProfile(a.currentUser)
+		a.saveSession(a.authToken, acc.Email, acc.Plan)
+	}
+	return acc, nil
+}
+
+// ChangeEmail starts an email change; the user has to confirm it from the
+// link sent to the new address.
+func (a *App) ChangeEmail(newEmail string, password string) error {
+	if a.currentUser == nil {
+		return fmt.Errorf("not logged in")
+	}
+	if err := a.apiClient.ChangeEmail(a.ctx, newEmail, password); err != nil {
+		return err
+	}
+	log.Printf("[Auth] Email change to %s requested, awaiting confirmation", newEmail)
+	return nil
+}
+
 // --- Server Methods ---
 
 func (a *App) GetServers() []Server {
+	return a.markLatencies(a.markFavorites(append(a.loadServers(), a.subscriptionServers()...)))
+}
+
+func (a *App) loadServers() []Server {
 	// Try backend API first
 	if a.apiClient != nil && a.authToken != "" {
-		apiServers, err := a.apiClient.GetServers()
+		apiServers, err := a.apiClient.Servers(a.ctx)
 		if err == nil {
 			var servers []Server
 			for _, s := range apiServers {
@@ -192,17 +352,35 @@ func (a *App) GetServers() []Server {
 					City:      s.City,
 					Flag:      s.Flag,
 					Config:    s.Config,
+					Fallbacks: s.Fallbacks,
 					IsPremium: s.IsPremium,
+					Locked:    s.Locked,
+					Tags:      s.Tags,
 					Latency:   50,
+					Device:    s.Device,
 				})
 			}
 			log.Printf("[Servers] Loaded %d servers from API", len(servers))
+			if a.currentUser != nil {
+				a.serverCache.SetServers(a.currentUser.ID, servers)
+			}
+			a.setServerListStatus(ServerListStatus{UpdatedAt: time.Now()})
 			return servers
 		}
 		log.Printf("[Servers] API failed, falling back to local: %v", err)
+
+		// The servers the backend last returned have the user's own keys.
+		if a.currentUser != nil {
+			if servers, fetchedAt := a.serverCache.Servers(a.currentUser.ID); servers != nil {
+				log.Printf("[Servers] Using %d cached servers from %s", len(servers), fetchedAt.Format(time.RFC3339))
+				a.setServerListStatus(ServerListStatus{Cached: true, UpdatedAt: fetchedAt})
+				return servers
+			}
+		}
 	}
 
 	// Fallback to local servers.json
+	a.setServerListStatus(ServerListStatus{Builtin: true})
 	configs, err := LoadServers()
 	if err != nil {
 		return []Server{}
@@ -215,6 +393,7 @@ func (a *App) GetServers() []Server {
 			Country:   c.Country,
 			Flag:      c.Flag,
 			Config:    c.Config,
+			Fallbacks: c.Fallbacks,
 			IsPremium: c.IsPremium,
 			Latency:   50 + len(c.City),
 		})
@@ -224,135 +403,356 @@ func (a *App) GetServers() []Server {
 
 // --- VPN Methods ---
 
+// Connect connects to a server and keeps the connection up until Disconnect,
+// reconnecting when it fails.
 func (a *App) Connect(config string, serverID string) error {
+	a.connMu.Lock()
+	defer a.connMu.Unlock()
+
 	if a.currentUser == nil {
 		return fmt.Errorf("please login first")
 	}
 
-	if a.isConnected {
+	if a.stopSupervisor != nil {
 		return fmt.Errorf("already connected")
 	}
 
-	// Check if server is premium and user has access
-	servers := a.GetServers()
-	for _, s := range servers {
-		if s.ID == serverID && s.IsPremium {
-			sub, err := a.subDB.GetSubscription(a.currentUser.ID)
-			if err != nil {
-				return fmt.Errorf("failed to check subscription: %w", err)
-			}
-			if sub.Plan == PlanFreeType || sub.Status == StatusExpired {
-				return fmt.Errorf("premium subscription required for this server")
-			}
+	// Whether the plan covers the server is up to the backend, which doesn't
+	// give out configs of premium servers to free users.
+	server := Server{ID: serverID, Config: config}
+	for _, s := range a.GetServers() {
+		if s.ID != serverID {
+			continue
+		}
+		if s.Locked {
+			return fmt.Errorf("premium subscription required for this server")
 		}
+		server.Fallbacks = s.Fallbacks
 	}
 
-	log.Printf("[VPN] Connecting with config: %s", config)
+	a.setStatus(ConnectionStatus{State: StateConnecting, ServerID: serverID})
+	failed, via, err := a.connectServer(server)
+	if err != nil {
+		a.disableKillSwitch()
+		a.restoreSystemProxy()
+		a.setStatus(ConnectionStatus{State: StateDisconnected, Error: err.Error()})
+		return err
+	}
+	a.stopSupervisor = make(chan struct{})
+	go a.supervise(a.stopSupervisor, server, failed)
+	a.startStats(a.stopSupervisor)
+	a.setStatus(ConnectionStatus{State: StateConnected, ServerID: serverID, Transport: via})
 
-	// Detect protocol and prepare config for Outline SDK
-	var serverHost string
-	var dialerConfig string
+	a.rememberServer(serverID)
+	return nil
+}
+
+// autoConnect connects to the last server on launch.
+func (a *App) autoConnect() {
+	if err := a.connectLastServer(); err != nil {
+		log.Printf("[VPN] Auto-connect failed: %v", err)
+	}
+}
+
+// connectLastServer connects to the server last connected to, for connecting
+// without the user picking one.
+func (a *App) connectLastServer() error {
+	a.configMu.Lock()
+	last := a.config.LastServerID
+	a.configMu.Unlock()
+	if a.currentUser == nil {
+		return errors.New("not logged in")
+	}
+	if last == "" {
+		return errors.New("no server connected to before")
+	}
+	for _, s := range a.GetServers() {
+		if s.ID == last {
+			log.Printf("[VPN] Connecting to the last server %s...", s.ID)
+			return a.Connect(s.Config, s.ID)
+		}
+	}
+	return fmt.Errorf("last server %s is gone", last)
+}
 
-	if strings.HasPrefix(config, "vless://") {
-		// VLESS: start xray-core subprocess, use SOCKS5 bridge
-		log.Printf("[VPN] Detected VLESS protocol, starting xray-core...")
+// connectServer tries the configs of server in order until one connects and
+// returns the failure channel of the tunnel and the transport it uses.
+// connMu must be held.
+func (a *App) connectServer(server Server) (<-chan error, string, error) {
+	var errs []error
+	for _, config := range server.configs() {
+		name := transportName(config)
+		fields := map[string]string{"server": server.ID, "transport": name}
+		// Configs that can't work are skipped before anything is set up.
+		if _, err := core.ValidateConfig(config); err != nil {
+			log.Printf("[VPN] Skipping a config of %s: %v", server.ID, err)
+			a.logEvent(EventConnectFailed, "Connecting to "+server.ID+" failed", err, fields)
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		a.logEvent(EventConnectAttempt, "Connecting to "+server.ID, nil, fields)
+		failed, err := a.connect(config)
+		if err == nil {
+			log.Printf("[VPN] Connected to %s over %s.", server.ID, name)
+			a.logEvent(EventConnected, "Connected to "+server.ID, nil, fields)
+			return failed, name, nil
+		}
+		a.teardown()
+		log.Printf("[VPN] Connecting to %s over %s failed: %v", server.ID, name, err)
+		a.logEvent(EventConnectFailed, "Connecting to "+server.ID+" failed", err, fields)
+		errs = append(errs, fmt.Errorf("%s: %w", name, err))
+	}
+	if len(errs) == 1 {
+		return nil, "", errors.Unwrap(errs[0])
+	}
+	return nil, "", errors.Join(errs...)
+}
 
-		// Parse VLESS URI to get server host for routing
-		vlessParams, err := ParseVLESSURI(config)
+// transportName describes the protocol of a config for the UI, such as
+// "vless+reality" or "ss".
+func transportName(config string) string {
+	if IsXrayURI(config) {
+		server, err := ParseXrayURI(config)
 		if err != nil {
-			return fmt.Errorf("failed to parse VLESS config: %w", err)
+			return "xray"
+		}
+		name := server.Protocol
+		if network, _ := server.Stream["network"].(string); network != "" && network != "tcp" {
+			name += "+" + network
+		}
+		if security, _ := server.Stream["security"].(string); security != "" && security != "none" {
+			name += "+" + security
 		}
-		serverHost = vlessParams.Host
+		return name
+	}
+	scheme, _, _ := strings.Cut(config, "://")
+	return scheme
+}
+
+// connect brings the tunnel up. The returned channel receives an error if
+// the tunnel fails later on. connMu must be held.
+func (a *App) connect(config string) (<-chan error, error) {
+	failed := make(chan error, 1)
+	fail := func(err error) {
+		select {
+		case failed <- err:
+		default:
+		}
+	}
+
+	// Not the config itself, which has the server's keys.
+	log.Printf("[VPN] Connecting to %s over %s...", serverHostOf(config), transportName(config))
+
+	// 1. Detect protocol and create dialers
+	var serverHost string
+	var sd transport.StreamDialer
+	var pl transport.PacketListener
+
+	if IsXrayURI(config) {
+		// VLESS, VMess, Trojan or Shadowsocks 2022: start xray-core and dial
+		// through it
+		server, err := ParseXrayURI(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse server config: %w", err)
+		}
+		log.Printf("[VPN] Detected %s protocol, starting xray-core...", server.Protocol)
+		// Server host for routing
+		serverHost = server.Host
 
 		// Start xray-core
 		if a.xrayManager == nil {
 			a.xrayManager = NewXrayManager()
 		}
+		a.xrayManager.Mirror = a.config.XrayMirror
+		a.xrayManager.Log = a.xrayLog
+		a.xrayManager.OnDownloadProgress = func(done, total int64) {
+			if a.ctx != nil {
+				runtime.EventsEmit(a.ctx, "xray:download", map[string]int64{"done": done, "total": total})
+			}
+		}
 		if err := a.xrayManager.Start(config); err != nil {
-			return fmt.Errorf("failed to start xray-core: %w", err)
+			return nil, fmt.Errorf("failed to start xray-core: %w", err)
 		}
 
-		// Use SOCKS5 proxy as the dialer config
-		dialerConfig = a.xrayManager.GetSOCKS5Config()
-		log.Printf("[VPN] Using SOCKS5 bridge: %s", dialerConfig)
+		go func(done <-chan struct{}) {
+			<-done
+			fail(errors.New("xray-core exited"))
+		}(a.xrayManager.Done())
+
+		if sd, pl, err = a.xrayManager.Dialers(); err != nil {
+			a.stopXray() // Clean up on failure
+			return nil, fmt.Errorf("failed to create xray-core dialers: %w", err)
+		}
 	} else {
 		// Shadowsocks or other protocol supported by Outline SDK
-		dialerConfig = config
 		if cfg, err := configurl.ParseConfig(config); err == nil {
 			serverHost = cfg.URL.Hostname()
 		}
+		providers := configurl.NewDefaultProviders()
+		var err error
+		if sd, err = providers.NewStreamDialer(context.Background(), config); err != nil {
+			return nil, fmt.Errorf("failed to create stream dialer: %w", err)
+		}
+		if pl, err = providers.NewPacketListener(context.Background(), config); err != nil {
+			return nil, fmt.Errorf("failed to create packet listener: %w", err)
+		}
 	}
-	// 1. Create Dialers
-	providers := configurl.NewDefaultProviders()
-	sd, err := providers.NewStreamDialer(context.Background(), dialerConfig)
-	if err != nil {
-		a.stopXray() // Clean up on failure
-		return fmt.Errorf("failed to create stream dialer: %w", err)
+	if a.config.Settings.Mode == ModeProxy {
+		if err := a.connectProxy(config, sd, pl, fail); err != nil {
+			return nil, err
+		}
+		return failed, nil
 	}
-	pl, err := providers.NewPacketListener(context.Background(), dialerConfig)
+
+	pp, err := network.NewPacketProxyFromPacketListener(pl)
 	if err != nil {
 		a.stopXray()
-		return fmt.Errorf("failed to create packet listener: %w", err)
+		return nil, fmt.Errorf("failed to create packet proxy: %w", err)
 	}
-	pp, err := network.NewPacketProxyFromPacketListener(pl)
+
+	// The server is resolved while DNS still works without the tunnel, and
+	// later queries for it are answered from these addresses.
+	var serverAddrs []netip.Addr
+	pinned := make(map[string][]netip.Addr)
+	if serverHost != "" {
+		if serverAddrs, err = a.resolveServer(serverHost); err != nil {
+			a.stopXray()
+			return nil, fmt.Errorf("failed to resolve server: %w", err)
+		}
+		if _, err := netip.ParseAddr(serverHost); err != nil {
+			pinned[strings.ToLower(serverHost)] = serverAddrs
+		}
+	}
+	tunnelIPv6 := a.config.Settings.IPv6 == IPv6Tunnel
+	// Counted like in proxy mode, before DNS is answered locally.
+	var tunnelSD transport.StreamDialer = &core.CountingStreamDialer{Dialer: sd, Counter: a.traffic}
+	var tunnelPP network.PacketProxy = &core.CountingPacketProxy{Proxy: pp, Counter: a.traffic}
+	if !tunnelIPv6 {
+		tunnelSD, tunnelPP = blockIPv6(tunnelSD, tunnelPP)
+	}
+	tunnelSD, tunnelPP, a.dnsForwarder, err = newTunnelDNS(tunnelSD, tunnelPP, a.config.Settings.UpstreamDNS(), pinned, !tunnelIPv6)
 	if err != nil {
 		a.stopXray()
-		return fmt.Errorf("failed to create packet proxy: %w", err)
+		return nil, err
 	}
 
+	// Resolved before the tunnel is up, through the regular network.
+	routes := a.config.Settings.SplitTunnel.Resolve()
+
 	// 2. Create & Configure TUN
-	tun, err := NewWindowsTUN()
+	tun, err := a.privileged.NewTUN(a.config.Settings.TUNRingBufferSize())
 	if err != nil {
 		a.stopXray()
-		return fmt.Errorf("failed to create TUN device: %w", err)
+		return nil, fmt.Errorf("failed to create TUN device: %w", err)
 	}
-	// Use a fixed IP for now. Ideally should be configurable or determined by server.
-	// But Outline usually doesn't push IP. We use a private IP.
-	tunIP := "10.0.85.2"
 	if err := tun.Configure(tunIP); err != nil {
 		tun.Close()
-		return fmt.Errorf("failed to configure TUN: %w", err)
+		return nil, fmt.Errorf("failed to configure TUN: %w", err)
+	}
+	// Also when IPv6 is blocked, so it is routed into the tunnel instead of
+	// leaking past it.
+	if err := tun.ConfigureIPv6(tunIP6); err != nil {
+		tun.Close()
+		return nil, fmt.Errorf("failed to configure TUN: %w", err)
 	}
-	a.tunDevice = tun
 
 	// 2.5 Setup Routing
-	if err := tun.SetupRoutes(serverHost, tunIP); err != nil {
+	if err := tun.SetupRoutes(serverHost, tunIP, routes); err != nil {
 		log.Printf("[VPN] Routing setup failed: %v", err)
 		tun.Close()
 		a.stopXray()
-		return fmt.Errorf("failed to setup routes: %w", err)
+		return nil, fmt.Errorf("failed to setup routes: %w", err)
+	}
+	if err := tun.SetupDNS(tunnelDNS); err != nil {
+		log.Printf("[VPN] DNS setup failed: %v", err)
+		tun.Close()
+		a.stopXray()
+		return nil, fmt.Errorf("failed to setup DNS: %w", err)
 	}
 
 	// 3. Configure LWIP Stack
-	dev, err := lwip2transport.ConfigureDevice(sd, pp)
+	dev, err := lwip2transport.ConfigureDevice(tunnelSD, tunnelPP)
 	if err != nil {
 		tun.Close()
-		return fmt.Errorf("failed to configure LWIP: %w", err)
+		return nil, fmt.Errorf("failed to configure LWIP: %w", err)
 	}
+	a.tunDevice = tun
 	a.lwipDevice = dev
 
 	// 4. Start Packet Forwarding
+	// Copying only stops when the devices are closed, or broke.
 	go func() {
 		_, err := io.Copy(a.tunDevice, a.lwipDevice)
 		if err != nil {
 			log.Printf("[VPN] Copy LWIP->TUN error: %v", err)
 		}
+		fail(fmt.Errorf("forwarding from the network stack stopped: %v", err))
 	}()
 	go func() {
 		_, err := io.Copy(a.lwipDevice, a.tunDevice)
 		if err != nil {
 			log.Printf("[VPN] Copy TUN->LWIP error: %v", err)
 		}
+		fail(fmt.Errorf("forwarding from the TUN stopped: %v", err))
 	}()
 
 	log.Println("[VPN] TUN Device started. Routing traffic...")
 
+	a.serverAddrs = serverAddrs
+	a.splitRoutes = routes
+	if a.config.Settings.KillSwitch {
+		if err := a.enableKillSwitch(); err != nil {
+			a.teardown()
+			return nil, err
+		}
+	}
+
+	// A server can accept connections and still not carry traffic, as when
+	// its protocol is blocked, so that is checked before relying on it.
+	if err := probeTunnel(sd, a.config.Settings.UpstreamDNS(), connectProbeTimeout); err != nil {
+		a.teardown()
+		return nil, fmt.Errorf("connectivity check failed: %w", err)
+	}
+	a.stopRefresh = make(chan struct{})
+	go a.refreshSplitRoutes(a.stopRefresh)
+	a.checkUDP(pl, a.config.Settings.UpstreamDNS())
+
+	a.tunnelDialer = sd
+	a.tunnelListener = pl
 	a.isConnected = true
 	a.activeConfig = config
-	return nil
+	return failed, nil
 }
 
 func (a *App) Disconnect() error {
+	a.connMu.Lock()
+	defer a.connMu.Unlock()
+
+	if a.stopSupervisor != nil {
+		close(a.stopSupervisor)
+		a.stopSupervisor = nil
+		a.logEvent(EventDisconnected, "Disconnected", nil, nil)
+	}
+	a.clearPause()
+	// The tunnel is torn down first, so nothing leaks in between.
+	a.teardown()
+	a.disableKillSwitch()
+	a.restoreSystemProxy()
+	a.setStatus(ConnectionStatus{State: StateDisconnected})
+	return nil
+}
+
+// teardown brings the tunnel down. The kill switch and system proxy are left
+// as is, so nothing leaks while reconnecting. connMu must be held.
+func (a *App) teardown() {
+	a.routesMu.Lock()
+	if a.stopRefresh != nil {
+		close(a.stopRefresh)
+		a.stopRefresh = nil
+	}
+	a.splitRoutes = SplitRoutes{}
+	a.routesMu.Unlock()
+
 	if a.tunDevice != nil {
 		a.tunDevice.Close()
 		a.tunDevice = nil
@@ -361,9 +761,19 @@ func (a *App) Disconnect() error {
 		a.lwipDevice.Close()
 		a.lwipDevice = nil
 	}
+	if a.proxyServer != nil {
+		a.proxyServer.Close()
+		a.proxyServer = nil
+	}
+	if a.dnsForwarder != nil {
+		a.dnsForwarder.Close()
+		a.dnsForwarder = nil
+	}
 	a.stopXray()
 	a.isConnected = false
-	return nil
+	a.serverAddrs = nil
+	a.tunnelDialer = nil
+	a.tunnelListener = nil
 }
 
 // stopXray stops the xray-core subprocess if running.
@@ -379,23 +789,252 @@ func (a *App) IsConnected() bool {
 	return a.isConnected
 }
 
+// --- Kill Switch ---
+
+// enableKillSwitch blocks all tunneled traffic that doesn't go through the
+// tunnel, except the connection to the current server and split tunnel
+// exclusions.
+func (a *App) enableKillSwitch() error {
+	tunAddrs := []netip.Addr{netip.MustParseAddr(tunIP), netip.MustParseAddr(tunIP6)}
+	allowed := slices.Clone(a.splitRoutes.Bypass)
+	for _, ip := range a.serverAddrs {
+		ip = ip.Unmap()
+		allowed = append(allowed, netip.PrefixFrom(ip, ip.BitLen()))
+	}
+	if err := a.killSwitch.Enable(tunAddrs, a.splitRoutes.Tunneled, allowed, a.config.Settings.KillSwitchAllowLAN); err != nil {
+		err = fmt.Errorf("failed to enable kill switch: %w", err)
+		a.logEvent(EventKillSwitch, "Kill switch", err, nil)
+		return err
+	}
+	a.logEvent(EventKillSwitch, "Kill switch on", nil, map[string]string{"allowed": strconv.Itoa(len(allowed))})
+	return nil
+}
+
+func (a *App) disableKillSwitch() {
+	if !a.killSwitch.IsActive() {
+		return
+	}
+	if err := a.killSwitch.Disable(); err != nil {
+		log.Printf("[KillSwitch] %v", err)
+		a.logEvent(EventKillSwitch, "Kill switch", err, nil)
+		return
+	}
+	a.logEvent(EventKillSwitch, "Kill switch off", nil, nil)
+}
+
+// resolveServer resolves a server's host, falling back to the addresses it
+// last had. With the kill switch on, DNS is blocked between two connections.
+// connMu must be held.
+func (a *App) resolveServer(host string) ([]netip.Addr, error) {
+	addrs, err := resolveHost(host)
+	if err != nil {
+		if cached, ok := a.hostAddrs[host]; ok {
+			log.Printf("[VPN] %v, using the last known addresses", err)
+			return cached, nil
+		}
+		return nil, err
+	}
+	a.hostAddrs[host] = addrs
+	return addrs, nil
+}
+
+// resolveHost returns the addresses of a server given by IP or name.
+func resolveHost(host string) ([]netip.Addr, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{ip}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	return ips, nil
+}
+
+// --- Split Tunneling ---
+
+// refreshSplitRoutes resolves the domains of the split tunnel rules again
+// every splitTunnelRefresh until stop is closed.
+func (a *App) refreshSplitRoutes(stop chan struct{}) {
+	ticker := time.NewTicker(splitTunnelRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		a.routesMu.Lock()
+		rules := a.config.Settings.SplitTunnel
+		a.routesMu.Unlock()
+		routes := rules.Resolve()
+
+		a.routesMu.Lock()
+		select {
+		case <-stop:
+			// Disconnected while resolving.
+		default:
+			if err := a.applySplitRoutes(routes); err != nil {
+				log.Printf("[SplitTunnel] %v", err)
+			}
+		}
+		a.routesMu.Unlock()
+	}
+}
+
+// applySplitRoutes reprograms the routes of the connection and the kill
+// switch if they changed. routesMu must be held.
+func (a *App) applySplitRoutes(routes SplitRoutes) error {
+	if routes.Equal(a.splitRoutes) {
+		return nil
+	}
+	if a.paused.Load() {
+		// Applied on resuming.
+		a.splitRoutes = routes
+		return nil
+	}
+	if err := a.tunDevice.SetSplitRoutes(routes); err != nil {
+		a.logEvent(EventRoutes, "Updating routes", err, nil)
+		return err
+	}
+	a.splitRoutes = routes
+	a.logEvent(EventRoutes, "Routes updated", nil, map[string]string{
+		"tunneled": strconv.Itoa(len(routes.Tunneled)),
+		"bypass":   strconv.Itoa(len(routes.Bypass)),
+	})
+	if a.killSwitch.IsActive() {
+		return a.enableKillSwitch()
+	}
+	return nil
+}
+
+// --- Settings ---
+
+func (a *App) GetSettings() *Settings {
+	return &a.config.Settings
+}
+
+// SetLaunchAtLogin turns Settings.LaunchAtLogin on or off.
+func (a *App) SetLaunchAtLogin(enabled bool) error {
+	s := a.config.Settings
+	s.LaunchAtLogin = enabled
+	return a.SaveSettings(s)
+}
+
+// SetAutoConnect turns Settings.AutoConnect on or off.
+func (a *App) SetAutoConnect(enabled bool) error {
+	s := a.config.Settings
+	s.AutoConnect = enabled
+	return a.SaveSettings(s)
+}
+
+// SaveSettings stores the settings in config.json. Kill switch and split
+// tunnel changes apply to the current connection right away.
+func (a *App) SaveSettings(s Settings) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if s.LaunchAtLogin != a.config.Settings.LaunchAtLogin {
+		if err := setLaunchAtLogin(s.LaunchAtLogin); err != nil {
+			return err
+		}
+	}
+	a.routesMu.Lock()
+	old := a.config.Settings
+	a.config.Settings = s
+	a.routesMu.Unlock()
+	a.configMu.Lock()
+	err := SaveConfig(a.config)
+	a.configMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to save settings: %w", err)
+	}
+	a.xrayLog.SetFileLogging(s.XrayLogFile)
+	if !s.NetworkRules.Equal(old.NetworkRules) {
+		select {
+		case a.recheckRules <- struct{}{}:
+		default:
+		}
+	}
+
+	a.connMu.Lock()
+	defer a.connMu.Unlock()
+	// Also while reconnecting, when the kill switch is all that is left.
+	if !s.KillSwitch {
+		a.disableKillSwitch()
+	}
+	if !s.SystemProxy {
+		a.restoreSystemProxy()
+	}
+	// Split tunneling and the kill switch only apply to the TUN.
+	if !a.isConnected || a.tunDevice == nil {
+		return nil
+	}
+
+	routes := s.SplitTunnel.Resolve()
+	a.routesMu.Lock()
+	defer a.routesMu.Unlock()
+	if err := a.applySplitRoutes(routes); err != nil {
+		return err
+	}
+	if s.KillSwitch && !a.paused.Load() && (!old.KillSwitch || old.KillSwitchAllowLAN != s.KillSwitchAllowLAN) {
+		return a.enableKillSwitch()
+	}
+	return nil
+}
+
 // --- Subscription Methods (exposed to React) ---
 
+// subscriptionFromAPI converts the backend's subscription for the frontend.
+func subscriptionFromAPI(b *core.Subscription) *Subscription {
+	price, _ := strconv.ParseFloat(b.Price, 64)
+	return &Subscription{
+		Plan:            PlanType(b.Plan),
+		Status:          SubscriptionStatus(b.Status),
+		ExpiryDate:      b.ExpiryDate,
+		GraceUntil:      b.GraceUntil,
+		AutoRenew:       b.AutoRenew,
+		PaymentMethod:   b.PaymentMethod,
+		Price:           price,
+		RenewalFailures: b.RenewalFailures,
+		NextPlan:        PlanType(b.NextPlan),
+		PausedUntil:     b.PausedUntil,
+		PauseDaysLeft:   b.PauseDaysLeft,
+		FamilyOwner:     b.FamilyOwner,
+		UpdatedAt:       time.Now(),
+	}
+}
+
+// GetSubscription returns the subscription from the backend, or the last
+// known one if the backend can't be reached.
 func (a *App) GetSubscription() (*Subscription, error) {
 	if a.currentUser == nil {
 		return nil, fmt.Errorf("not logged in")
 	}
-	return a.subDB.GetSubscription(a.currentUser.ID)
+	billing, err := a.apiClient.Subscription(a.ctx)
+	if err != nil {
+		cached := a.subCache.Subscription(a.currentUser.ID)
+		if cached == nil {
+			return nil, err
+		}
+		log.Printf("[Subscription] Backend unavailable, using cached subscription: %v", err)
+		cached.Offline = true
+		return cached, nil
+	}
+	sub := subscriptionFromAPI(billing)
+	a.subCache.SetSubscription(a.currentUser.ID, sub)
+	return sub, nil
 }
 
-func (a *App) InitPayment(plan string) (*APIPaymentResponse, error) {
+func (a *App) InitPayment(plan string) (*core.Payment, error) {
 	if a.currentUser == nil {
 		return nil, fmt.Errorf("not logged in")
 	}
 	if a.apiClient == nil || a.authToken == "" {
 		return nil, fmt.Errorf("not connected to server")
 	}
-	return a.apiClient.InitPayment(plan)
+	return a.apiClient.InitPayment(a.ctx, plan)
 }
 
 func (a *App) CheckPayment(paymentID string) (string, error) {
@@ -403,48 +1042,151 @@ func (a *App) CheckPayment(paymentID string) (string, error) {
 		return "", fmt.Errorf("not logged in")
 	}
 
-	status, plan, err := a.apiClient.CheckPayment(paymentID)
+	check, err := a.apiClient.CheckPayment(a.ctx, paymentID)
 	if err != nil {
 		return "", err
 	}
 
-	// If payment succeeded, upgrade local subscription DB too
-	if status == "succeeded" && plan != "" {
-		a.subDB.UpgradePlan(a.currentUser.ID, PlanType(plan))
-		log.Printf("[Payment] Upgraded user %s to plan: %s", a.currentUser.Email, plan)
+	// The backend applied the payment; refresh the cached subscription.
+	if check.Status == core.PaymentSucceeded {
+		if _, err := a.GetSubscription(); err != nil {
+			log.Printf("[Payment] Failed to refresh subscription: %v", err)
+		}
+		log.Printf("[Payment] User %s paid for plan: %s", a.currentUser.Email, check.Plan)
 	}
 
-	return status, nil
+	return check.Status, nil
 }
 
 func (a *App) CancelAutoRenew() error {
+	return a.setAutoRenew(false)
+}
+
+func (a *App) EnableAutoRenew() error {
+	return a.setAutoRenew(true)
+}
+
+func (a *App) setAutoRenew(enabled bool) error {
 	if a.currentUser == nil {
 		return fmt.Errorf("not logged in")
 	}
-	return a.subDB.CancelAutoRenew(a.currentUser.ID)
+	billing, err := a.apiClient.SetAutoRenew(a.ctx, enabled)
+	if err != nil {
+		return err
+	}
+	a.subCache.SetSubscription(a.currentUser.ID, subscriptionFromAPI(billing))
+	return nil
 }
 
-func (a *App) EnableAutoRenew() error {
+// CancelNextPlan cancels a scheduled switch to a shorter plan.
+func (a *App) CancelNextPlan() error {
+	if a.currentUser == nil {
+		return fmt.Errorf("not logged in")
+	}
+	billing, err := a.apiClient.CancelNextPlan(a.ctx)
+	if err != nil {
+		return err
+	}
+	a.subCache.SetSubscription(a.currentUser.ID, subscriptionFromAPI(billing))
+	return nil
+}
+
+// PauseSubscription pauses the user's plan for days days. The backend
+// disables their keys meanwhile and pushes the expiry out by as much.
+func (a *App) PauseSubscription(days int) error {
+	if a.currentUser == nil {
+		return fmt.Errorf("not logged in")
+	}
+	billing, err := a.apiClient.PauseSubscription(a.ctx, days)
+	if err != nil {
+		return err
+	}
+	a.subCache.SetSubscription(a.currentUser.ID, subscriptionFromAPI(billing))
+	return nil
+}
+
+// ResumeSubscription ends a pause of the user's plan early.
+func (a *App) ResumeSubscription() error {
 	if a.currentUser == nil {
 		return fmt.Errorf("not logged in")
 	}
-	return a.subDB.EnableAutoRenew(a.currentUser.ID)
+	billing, err := a.apiClient.ResumeSubscription(a.ctx)
+	if err != nil {
+		return err
+	}
+	a.subCache.SetSubscription(a.currentUser.ID, subscriptionFromAPI(billing))
+	return nil
 }
 
+// GetPaymentHistory returns the user's payments from the backend, or the last
+// known ones if it can't be reached.
 func (a *App) GetPaymentHistory() ([]PaymentRecord, error) {
 	if a.currentUser == nil {
 		return nil, fmt.Errorf("not logged in")
 	}
-	return a.subDB.GetPaymentHistory(a.currentUser.ID)
+	payments, err := a.apiClient.PaymentHistory(a.ctx)
+	if err != nil {
+		log.Printf("[Subscription] Backend unavailable, using cached payments: %v", err)
+		return a.subCache.Payments(a.currentUser.ID), nil
+	}
+	var records []PaymentRecord
+	for _, p := range payments {
+		records = append(records, PaymentRecord{
+			ID:          p.ID,
+			Amount:      p.Amount,
+			Plan:        PlanType(p.Plan),
+			Status:      p.Status,
+			CreatedAt:   p.CreatedAt,
+			Description: p.Description,
+			HasInvoice:  p.InvoiceURL != "",
+		})
+	}
+	a.subCache.SetPayments(a.currentUser.ID, records)
+	return records, nil
+}
+
+// SaveInvoice asks where to save the invoice of a completed payment, as a
+// PDF or an HTML document (format "pdf" or "html"), downloads it there and
+// returns the path, or "" if the user canceled.
+func (a *App) SaveInvoice(paymentID string, format string) (string, error) {
+	if a.currentUser == nil {
+		return "", fmt.Errorf("not logged in")
+	}
+	filter := runtime.FileFilter{DisplayName: "PDF documents", Pattern: "*.pdf"}
+	if format == core.InvoiceHTML {
+		filter = runtime.FileFilter{DisplayName: "HTML documents", Pattern: "*.html"}
+	}
+	path, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		Title:           "Save Invoice",
+		DefaultFilename: fmt.Sprintf("drfrake-invoice-%s.%s", paymentID, format),
+		Filters:         []runtime.FileFilter{filter},
+	})
+	if err != nil || path == "" {
+		return "", err
+	}
+	doc, err := a.apiClient.Invoice(a.ctx, paymentID, format)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, doc, 0o644); err != nil {
+		return "", fmt.Errorf("failed to save invoice: %w", err)
+	}
+	return path, nil
 }
 
 func (a *App) SavePaymentMethod(last4 string, brand string, expiry string) error {
 	return nil // Deprecated, handled by YooKassa
 }
 
+// GetPaymentMethod returns the method renewals are charged to, nil if no
+// payment saved one yet.
 func (a *App) GetPaymentMethod() (*PaymentMethod, error) {
-	if a.currentUser == nil {
-		return nil, fmt.Errorf("not logged in")
+	sub, err := a.GetSubscription()
+	if err != nil {
+		return nil, err
+	}
+	if sub.PaymentMethod == "" {
+		return nil, nil
 	}
-	return a.subDB.GetPaymentMethod(a.currentUser.ID)
+	return &PaymentMethod{Title: sub.PaymentMethod}, nil
 }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// setLaunchAtLogin adds the app to, or removes it from, what starts at login,
+// with an XDG autostart entry.
+func setLaunchAtLogin(enabled bool) error {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(configDir, "autostart", "drfrake-vpn.desktop")
+	if !enabled {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to change launch at login: %w", err)
+		}
+		return nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to change launch at login: %w", err)
+	}
+	entry := strings.Join([]string{
+		"[Desktop Entry]",
+		"Type=Application",
+		"Name=Dr. Frake VPN Premium",
+		`Exec="` + exe + `"`,
+		"X-GNOME-Autostart-enabled=true",
+		"",
+	}, "\n")
+	if err := os.WriteFile(path, []byte(entry), 0644); err != nil {
+		return fmt.Errorf("failed to change launch at login: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// runKey lists what starts when the user logs in. Changing it needs no admin
+// rights.
+const runKey = `Software\Microsoft\Windows\CurrentVersion\Run`
+
+// autostartName is the app's value in runKey.
+const autostartName = "DrFrakeVPN"
+
+// setLaunchAtLogin adds the app to, or removes it from, what starts at login.
+func setLaunchAtLogin(enabled bool) error {
+	key, err := registry.OpenKey(registry.CURRENT_USER, runKey, registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("failed to change launch at login: %w", err)
+	}
+	defer key.Close()
+	if !enabled {
+		if err := key.DeleteValue(autostartName); err != nil && !errors.Is(err, registry.ErrNotExist) {
+			return fmt.Errorf("failed to change launch at login: %w", err)
+		}
+		return nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err := key.SetStringValue(autostartName, `"`+exe+`"`); err != nil {
+		return fmt.Errorf("failed to change launch at login: %w", err)
+	}
+	return nil
+}
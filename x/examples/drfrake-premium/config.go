@@ -1,33 +1,75 @@
 package main
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
+
+	"drfrake-core"
 )
 
+// Config is stored in config.json in the config directory.
 type Config struct {
-	BackendURL string `json:"backend_url"`
+	BackendURL string `json:"backend_url,omitempty"`
+	// XrayMirror serves xray-core releases laid out like the official ones,
+	// for where GitHub is blocked.
+	XrayMirror string `json:"xray_mirror,omitempty"`
+	// UpdateManifestURL replaces where the release manifest is checked, for
+	// where GitHub is blocked. Manifests are signed, so any copy will do.
+	UpdateManifestURL string `json:"update_manifest_url,omitempty"`
+	// LastServerID is the server last connected to, for Settings.AutoConnect.
+	LastServerID string `json:"last_server_id,omitempty"`
+	// Favorites are the IDs of the user's favorite servers.
+	Favorites []string `json:"favorites,omitempty"`
+	// Recents are the IDs of the servers last connected to, newest first.
+	Recents []string `json:"recents,omitempty"`
+	// DeviceID identifies this installation to the backend, which gives it
+	// keys of its own, see GetDevices.
+	DeviceID string   `json:"device_id,omitempty"`
+	Settings Settings `json:"settings"`
 }
 
 type ServerConfig struct {
-	ID        string `json:"id"`
-	Country   string `json:"country"`
-	Flag      string `json:"flag"`
-	City      string `json:"city"`
-	Config    string `json:"config"` // SS URI
-	IsPremium bool   `json:"isPremium"`
-	IsDefault bool   `json:"isDefault"`
+	ID      string `json:"id"`
+	Country string `json:"country"`
+	Flag    string `json:"flag"`
+	City    string `json:"city"`
+	Config  string `json:"config"` // SS URI
+	// Fallbacks are tried in order when Config doesn't connect.
+	Fallbacks []string `json:"fallbacks,omitempty"`
+	IsPremium bool     `json:"isPremium"`
+	IsDefault bool     `json:"isDefault"`
 }
 
 // Server is the struct exposed to the frontend
 type Server struct {
-	ID        string `json:"id"`
-	Country   string `json:"country"`
-	City      string `json:"city"`
-	Flag      string `json:"flag"`
-	Config    string `json:"config"`
-	IsPremium bool   `json:"isPremium"`
-	Latency   int    `json:"latency"`
+	ID      string `json:"id"`
+	Country string `json:"country"`
+	City    string `json:"city"`
+	Flag    string `json:"flag"`
+	Config  string `json:"config"`
+	// Fallbacks are tried in order when Config doesn't connect, such as
+	// Shadowsocks behind VLESS+Reality.
+	Fallbacks []string `json:"fallbacks,omitempty"`
+	IsPremium bool     `json:"isPremium"`
+	// Locked is set by the backend for premium servers the user's plan
+	// doesn't cover. The subscription the app keeps only hints at it.
+	Locked bool `json:"locked"`
+	// Tags say what the server is good for, e.g. "streaming" or "p2p",
+	// see GroupServersByTag.
+	Tags       []string `json:"tags,omitempty"`
+	Latency    int      `json:"latency"`
+	IsFavorite bool     `json:"isFavorite"`
+	// Source is where the server comes from: empty for the backend, or
+	// "subscription" for the user's subscription link.
+	Source string `json:"source,omitempty"`
+	// Device is the device the backend issued the key to.
+	Device *core.Device `json:"device,omitempty"`
+}
+
+// configs returns the configs of the server in the order they are tried.
+func (s Server) configs() []string {
+	return append([]string{s.Config}, s.Fallbacks...)
 }
 
 func GetConfigDir() string {
@@ -35,16 +77,34 @@ func GetConfigDir() string {
 	return filepath.Join(configDir, "DrFrakeVPN")
 }
 
+func getConfigPath() string {
+	return filepath.Join(GetConfigDir(), "config.json")
+}
+
+// LoadConfig reads config.json. Without one, the defaults are used and the
+// backend URL is left to the caller.
 func LoadConfig() (*Config, error) {
-	// Hardcoded config, no file I/O
-	return &Config{
-		BackendURL: "http://31.135.65.188:8080",
-	}, nil
+	cfg := &Config{}
+	data, err := os.ReadFile(getConfigPath())
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return &Config{}, err
+	}
+	return cfg, nil
 }
 
 func SaveConfig(cfg *Config) error {
-	// No-op
-	return nil
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(GetConfigDir(), 0755)
+	return os.WriteFile(getConfigPath(), data, 0600)
 }
 
 func LoadServers() ([]ServerConfig, error) {
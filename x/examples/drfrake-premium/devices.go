@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"drfrake-core"
+)
+
+// deviceID returns the ID of this installation for the backend, generating
+// and saving it on first use.
+func (a *App) deviceID() string {
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+	if a.config.DeviceID == "" {
+		a.config.DeviceID = core.NewDeviceID()
+		if err := SaveConfig(a.config); err != nil {
+			log.Printf("[Devices] Failed to save device ID: %v", err)
+		}
+	}
+	return a.config.DeviceID
+}
+
+// deviceName is the name the backend lists a new device under, which users
+// can change with RenameDevice.
+func deviceName() string {
+	name, _ := os.Hostname()
+	return name
+}
+
+// GetDevices returns the devices of the account that hold keys, this one
+// marked as current.
+func (a *App) GetDevices() ([]core.Device, error) {
+	if a.currentUser == nil {
+		return nil, fmt.Errorf("not logged in")
+	}
+	return a.apiClient.Devices(a.ctx)
+}
+
+func (a *App) RenameDevice(id string, name string) error {
+	if a.currentUser == nil {
+		return fmt.Errorf("not logged in")
+	}
+	return a.apiClient.RenameDevice(a.ctx, id, name)
+}
+
+// RevokeDevice deletes the keys of a device of the account and logs it out.
+// Revoking this device logs out here too.
+func (a *App) RevokeDevice(id string) error {
+	if a.currentUser == nil {
+		return fmt.Errorf("not logged in")
+	}
+	if err := a.apiClient.RevokeDevice(a.ctx, id); err != nil {
+		return err
+	}
+	log.Printf("[Devices] Revoked device %s", id)
+	if id == a.apiClient.DeviceID {
+		// The backend ended the session already.
+		a.Disconnect()
+		a.currentUser = nil
+		a.authToken = ""
+		a.apiClient.Token = ""
+		a.removeProfile()
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.getoutline.org/sdk/dns"
+	"golang.getoutline.org/sdk/transport"
+	"golang.getoutline.org/sdk/x/connectivity"
+)
+
+const (
+	diagnosticTimeout = 10 * time.Second
+	// publicIPURL returns the address a request comes from as plain text.
+	publicIPURL = "https://api.ipify.org"
+	// resolverIPDomain resolves to the address of the resolver that asked
+	// for it, which shows whose DNS answered.
+	resolverIPDomain = "whoami.akamai.net"
+)
+
+// Network paths of diagnostic checks.
+const (
+	// PathSystem is how apps reach the network: through the tunnel while
+	// connected in VPN mode and the VPN works, directly otherwise.
+	PathSystem = "system"
+	// PathTunnel goes straight to the VPN server, bypassing the TUN.
+	PathTunnel = "tunnel"
+)
+
+// DiagnosticsReport is the result of RunDiagnostics. It is meant to be
+// attached to support requests, so it has no account details.
+type DiagnosticsReport struct {
+	Time       time.Time         `json:"time"`
+	State      ConnectionState   `json:"state"`
+	ServerID   string            `json:"serverId,omitempty"`
+	KillSwitch bool              `json:"killSwitch"`
+	IPv6       IPv6Mode          `json:"ipv6,omitempty"`
+	DNS        string            `json:"dns"`
+	Checks     []DiagnosticCheck `json:"checks"`
+	// SystemIP and TunnelIP are the public addresses seen through each path.
+	// While connected they should be the same.
+	SystemIP string `json:"systemIp,omitempty"`
+	TunnelIP string `json:"tunnelIp,omitempty"`
+	// ResolverIP is the address of the resolver that answers the system's
+	// queries, as seen by an authoritative server.
+	ResolverIP string `json:"resolverIp,omitempty"`
+	// LeakingResolvers are resolvers of the physical adapters that answered
+	// while connected, bypassing the tunnel.
+	LeakingResolvers []string `json:"leakingResolvers,omitempty"`
+	// Problems sums up what failed, for the UI.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// DiagnosticCheck is one test of a protocol over a network path.
+type DiagnosticCheck struct {
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	Protocol   string `json:"protocol"` // "tcp", "udp" or "dns"
+	OK         bool   `json:"ok"`
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+// RunDiagnostics tests connectivity directly and through the tunnel and
+// looks for leaks. Checks that don't apply, like tunnel checks while
+// disconnected, are left out.
+func (a *App) RunDiagnostics() *DiagnosticsReport {
+	status := a.GetConnectionStatus()
+	a.connMu.Lock()
+	sd, pl := a.tunnelDialer, a.tunnelListener
+	settings := a.config.Settings
+	a.connMu.Unlock()
+	connected := sd != nil
+	// In proxy mode only apps using the proxy go through the tunnel, so the
+	// rest of the system is expected to show up directly.
+	tunneled := connected && settings.Mode != ModeProxy
+
+	report := &DiagnosticsReport{
+		Time:       time.Now().UTC(),
+		State:      status.State,
+		ServerID:   status.ServerID,
+		KillSwitch: settings.KillSwitch,
+		IPv6:       settings.IPv6,
+		DNS:        settings.UpstreamDNS(),
+	}
+	upstream := net.JoinHostPort(settings.UpstreamDNS(), "53")
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	run := func(fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+	addCheck := func(name, path, protocol string, test func(ctx context.Context) error) {
+		run(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), diagnosticTimeout)
+			defer cancel()
+			start := time.Now()
+			err := test(ctx)
+			check := DiagnosticCheck{Name: name, Path: path, Protocol: protocol, OK: err == nil, DurationMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				check.Error = err.Error()
+			}
+			mu.Lock()
+			report.Checks = append(report.Checks, check)
+			mu.Unlock()
+		})
+	}
+
+	addCheck("DNS over TCP", PathSystem, "tcp", func(ctx context.Context) error {
+		return testResolver(ctx, dns.NewTCPResolver(&transport.TCPDialer{}, upstream))
+	})
+	addCheck("DNS over UDP", PathSystem, "udp", func(ctx context.Context) error {
+		return testResolver(ctx, dns.NewUDPResolver(&transport.UDPDialer{}, upstream))
+	})
+	addCheck("System resolver", PathSystem, "dns", func(ctx context.Context) error {
+		_, err := net.DefaultResolver.LookupNetIP(ctx, "ip", healthCheckDomain)
+		return err
+	})
+	run(func() {
+		ip, err := fetchPublicIP(http.DefaultClient)
+		mu.Lock()
+		report.SystemIP = ip
+		if err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("Couldn't get the public IP: %v", err))
+		}
+		mu.Unlock()
+	})
+	run(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), diagnosticTimeout)
+		defer cancel()
+		if ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip4", resolverIPDomain); err == nil && len(ips) > 0 {
+			mu.Lock()
+			report.ResolverIP = ips[0].String()
+			mu.Unlock()
+		}
+	})
+
+	if connected {
+		addCheck("DNS over TCP", PathTunnel, "tcp", func(ctx context.Context) error {
+			return testResolver(ctx, dns.NewTCPResolver(sd, upstream))
+		})
+		addCheck("DNS over UDP", PathTunnel, "udp", func(ctx context.Context) error {
+			return testResolver(ctx, dns.NewUDPResolver(transport.PacketListenerDialer{Listener: pl}, upstream))
+		})
+		run(func() {
+			client := &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return sd.DialStream(ctx, addr)
+				},
+			}}
+			ip, err := fetchPublicIP(client)
+			mu.Lock()
+			report.TunnelIP = ip
+			if err != nil {
+				report.Problems = append(report.Problems, fmt.Sprintf("Couldn't get the public IP through the tunnel: %v", err))
+			}
+			mu.Unlock()
+		})
+		if tunneled {
+			run(func() {
+				leaking := probeLeakingResolvers()
+				mu.Lock()
+				report.LeakingResolvers = leaking
+				mu.Unlock()
+			})
+		}
+	}
+	wg.Wait()
+
+	slices.SortFunc(report.Checks, func(x, y DiagnosticCheck) int {
+		return strings.Compare(x.Path+x.Protocol, y.Path+y.Protocol)
+	})
+	for _, check := range report.Checks {
+		if !check.OK {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s (%s) failed: %s", check.Name, check.Path, check.Error))
+		}
+	}
+	if tunneled && report.SystemIP != "" && report.TunnelIP != "" && report.SystemIP != report.TunnelIP {
+		report.Problems = append(report.Problems, fmt.Sprintf("Traffic leaves from %s instead of the VPN's %s", report.SystemIP, report.TunnelIP))
+	}
+	if len(report.LeakingResolvers) > 0 {
+		report.Problems = append(report.Problems, fmt.Sprintf("DNS leaks to %s", strings.Join(report.LeakingResolvers, ", ")))
+	}
+	return report
+}
+
+// testResolver resolves healthCheckDomain with resolver.
+func testResolver(ctx context.Context, resolver dns.Resolver) error {
+	result, err := connectivity.TestConnectivityWithResolver(ctx, resolver, healthCheckDomain)
+	if err != nil {
+		return err
+	}
+	if result != nil {
+		return result
+	}
+	return nil
+}
+
+func fetchPublicIP(client *http.Client) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, publicIPURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+	if err != nil {
+		return "", err
+	}
+	ip, err := netip.ParseAddr(strings.TrimSpace(string(body)))
+	if err != nil {
+		return "", fmt.Errorf("unexpected response %q", body)
+	}
+	return ip.String(), nil
+}
+
+// probeLeakingResolvers queries the resolvers of the physical adapters
+// directly and returns those that answer. While connected, DNS leak
+// protection should block all of them.
+func probeLeakingResolvers() []string {
+	var leaking []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, resolver := range physicalResolvers() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			addr := net.JoinHostPort(resolver.String(), "53")
+			if testResolver(ctx, dns.NewUDPResolver(&transport.UDPDialer{}, addr)) == nil {
+				mu.Lock()
+				leaking = append(leaking, resolver.String())
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	slices.Sort(leaking)
+	return leaking
+}
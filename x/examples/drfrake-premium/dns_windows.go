@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"strings"
+
+	"golang.org/x/sys/windows"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
+)
+
+// dnsLeakGroup is the Windows Firewall rule group that keeps DNS queries
+// inside the tunnel.
+const dnsLeakGroup = "DrFrake VPN DNS Leak Protection"
+
+// SetupDNS points the adapter at resolver and blocks DNS to every other
+// server, so no query leaks to the resolvers of the physical adapters.
+//
+// Only the TUN adapter's settings change, and they go away with it. The
+// firewall rules are removed by Close.
+func (t *WindowsTUN) SetupDNS(resolver string) error {
+	resolverAddr, err := netip.ParseAddr(resolver)
+	if err != nil {
+		return fmt.Errorf("invalid DNS resolver: %w", err)
+	}
+	all4 := []addrRange{prefixRange(netip.MustParsePrefix("0.0.0.0/0"))}
+	remote := []string{"::/0"}
+	for _, r := range subtractRanges(all4, []addrRange{{resolverAddr, resolverAddr}}) {
+		remote = append(remote, r.String())
+	}
+
+	log.Printf("[DNS] Using %s for DNS, blocking other resolvers...", resolver)
+	// Windows sends queries to the resolvers of all adapters, the one with
+	// the lowest metric first.
+	family := winipcfg.AddressFamily(windows.AF_INET)
+	if resolverAddr.Is6() {
+		family = windows.AF_INET6
+	}
+	if err := t.luid.SetDNS(family, []netip.Addr{resolverAddr}, nil); err != nil {
+		return fmt.Errorf("failed to set up DNS: %w", err)
+	}
+	ipif, err := t.luid.IPInterface(windows.AF_INET)
+	if err != nil {
+		return fmt.Errorf("failed to set up DNS: %w", err)
+	}
+	ipif.UseAutomaticMetric = false
+	ipif.Metric = 1
+	if err := ipif.Set(); err != nil {
+		return fmt.Errorf("failed to set the interface metric: %w", err)
+	}
+
+	// The others are blocked, with the rules applying whether or not the
+	// kill switch is on.
+	psCmd := fmt.Sprintf(`
+		$ErrorActionPreference = "Stop";
+		$group = "%s";
+		Get-NetFirewallRule -Group $group -ErrorAction SilentlyContinue | Remove-NetFirewallRule;
+		foreach ($proto in "UDP", "TCP") {
+			New-NetFirewallRule -Group $group -DisplayName "$group ($proto)" -Direction Outbound -Action Block -Protocol $proto -RemotePort 53 -RemoteAddress @(%s) | Out-Null
+		}
+		Clear-DnsClientCache
+	`, dnsLeakGroup, psList(remote))
+
+	if out, err := runPowerShell(psCmd); err != nil {
+		RemoveDNSLeakRules()
+		return fmt.Errorf("failed to set up DNS: %v, output: %s", err, out)
+	}
+	return nil
+}
+
+// RemoveDNSLeakRules lets DNS queries reach any server again. It is also
+// called on startup, in case a run crashed while connected.
+func RemoveDNSLeakRules() error {
+	out, err := runPowerShell(fmt.Sprintf(`
+		$ErrorActionPreference = "Stop";
+		Get-NetFirewallRule -Group "%s" -ErrorAction SilentlyContinue | Remove-NetFirewallRule;
+		# Answers cached from the tunnel may not be valid outside it.
+		Clear-DnsClientCache
+	`, dnsLeakGroup))
+	if err != nil {
+		return fmt.Errorf("failed to remove DNS leak rules: %v, output: %s", err, strings.TrimSpace(out))
+	}
+	return nil
+}
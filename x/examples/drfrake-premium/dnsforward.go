@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+
+	"drfrake-core"
+	"golang.getoutline.org/sdk/network"
+	"golang.getoutline.org/sdk/transport"
+)
+
+const (
+	// tunnelDNS is the resolver the TUN adapter is configured with. It is on
+	// the TUN's subnet, so it is always routed into the tunnel, and it doesn't
+	// exist: queries to it are answered by the app.
+	tunnelDNS = "10.0.85.53"
+
+	// defaultUpstreamDNS is queried through the tunnel when the settings
+	// don't name a resolver.
+	defaultUpstreamDNS = "1.1.1.1"
+)
+
+// newTunnelDNS returns the dialers of the tunnel with queries to tunnelDNS
+// answered by a forwarder that resolves with upstream through sd, over
+// DNS-over-HTTPS where upstream serves it and TCP otherwise. UDP isn't used
+// for them, as not every server relays it. TCP queries go to the forwarder
+// listening on loopback, which the caller closes with the tunnel.
+//
+// Queries for the pinned hosts are answered with their addresses instead.
+// This is for the VPN server: the tunnel can't resolve the host it needs to
+// reach before it can resolve anything. With noAAAA, IPv6 addresses are never
+// returned, so apps don't try IPv6 while it is blocked.
+func newTunnelDNS(sd transport.StreamDialer, pp network.PacketProxy, upstream string, pinned map[string][]netip.Addr, noAAAA bool) (transport.StreamDialer, network.PacketProxy, *core.DNSForwarder, error) {
+	forwarder, err := core.NewDNSForwarder(sd, upstream)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	forwarder.Hosts = pinned
+	forwarder.BlockAAAA = noAAAA
+	localAddr, err := forwarder.Listen("127.0.0.1:0")
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to start the DNS forwarder: %w", err)
+	}
+	log.Printf("[DNS] Resolving with %s", forwarder.Upstream())
+
+	resolverAddr := netip.AddrPortFrom(netip.MustParseAddr(tunnelDNS), 53)
+	local := &transport.TCPDialer{}
+	tunnelSD := transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		if addr == resolverAddr.String() {
+			return local.DialStream(ctx, localAddr)
+		}
+		return sd.DialStream(ctx, addr)
+	})
+	return tunnelSD, &dnsPacketProxy{forwarder: forwarder, addr: resolverAddr, next: pp}, forwarder, nil
+}
+
+// dnsPacketProxy answers UDP queries to addr with forwarder and passes all
+// other packets on to next.
+type dnsPacketProxy struct {
+	forwarder *core.DNSForwarder
+	addr      netip.AddrPort
+	next      network.PacketProxy
+}
+
+func (p *dnsPacketProxy) NewSession(respWriter network.PacketResponseReceiver) (network.PacketRequestSender, error) {
+	next, err := p.next.NewSession(respWriter)
+	if err != nil {
+		return nil, err
+	}
+	return &dnsRequestSender{proxy: p, next: next, respWriter: respWriter}, nil
+}
+
+type dnsRequestSender struct {
+	proxy      *dnsPacketProxy
+	next       network.PacketRequestSender
+	respWriter network.PacketResponseReceiver
+}
+
+func (s *dnsRequestSender) WriteTo(p []byte, destination netip.AddrPort) (int, error) {
+	if destination != s.proxy.addr {
+		return s.next.WriteTo(p, destination)
+	}
+	query := append([]byte(nil), p...)
+	go func() {
+		resp, err := s.proxy.forwarder.AnswerPacket(context.Background(), query)
+		if err != nil {
+			// Not a query we can forward, dropped like a lost packet.
+			return
+		}
+		s.respWriter.WriteFrom(resp, net.UDPAddrFromAddrPort(s.proxy.addr))
+	}()
+	return len(p), nil
+}
+
+func (s *dnsRequestSender) Close() error {
+	return s.next.Close()
+}
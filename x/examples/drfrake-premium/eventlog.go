@@ -0,0 +1,221 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	wailsruntime "github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+const (
+	// eventLogCapacity is how many events GetEvents returns at most.
+	eventLogCapacity = 200
+	// Log files are rotated at logFileMaxSize, keeping logFileBackups.
+	logFileMaxSize = 1 << 20
+	logFileBackups = 3
+)
+
+// Kinds of events.
+const (
+	EventConnectAttempt = "connect_attempt"
+	EventConnected      = "connected"
+	EventConnectFailed  = "connect_failed"
+	EventConnectionLost = "connection_lost"
+	EventDisconnected   = "disconnected"
+	EventRoutes         = "routes"
+	EventKillSwitch     = "kill_switch"
+	EventNetwork        = "network"
+	EventPaused         = "paused"
+	EventResumed        = "resumed"
+)
+
+// Event is an entry of the event log, which records what the connection
+// went through for support.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Message string    `json:"message"`
+	// Code classifies the error of failure events, see errorCode.
+	Code   string            `json:"code,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// eventLog keeps the last events and appends them as JSON lines to
+// events.log in the config directory.
+type eventLog struct {
+	mu     sync.Mutex
+	events []Event
+	file   *rotatingFile
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{file: &rotatingFile{path: filepath.Join(GetConfigDir(), "events.log"), maxSize: logFileMaxSize, backups: logFileBackups}}
+}
+
+func (l *eventLog) add(event Event) {
+	event.Time = time.Now().UTC()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+	if len(l.events) > eventLogCapacity {
+		l.events = l.events[len(l.events)-eventLogCapacity:]
+	}
+	data, _ := json.Marshal(event)
+	l.file.Write(append(data, '\n'))
+}
+
+// Events returns the last events, oldest first.
+func (l *eventLog) Events() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Event(nil), l.events...)
+}
+
+// logEvent records an event. For failures, err gives the message and code.
+func (a *App) logEvent(kind, message string, err error, fields map[string]string) {
+	event := Event{Kind: kind, Message: message, Fields: fields}
+	if err != nil {
+		event.Message = fmt.Sprintf("%s: %v", message, err)
+		event.Code = errorCode(err)
+	}
+	a.events.add(event)
+}
+
+// errorCodes maps what errors say to their codes. Errors are matched by
+// message as most are wrapped strings.
+var errorCodes = []struct{ text, code string }{
+	{"failed to parse server config", "config"},
+	{"failed to resolve server", "resolve"},
+	{"failed to create TUN device", "tun_create"},
+	{"failed to configure TUN", "tun_configure"},
+	{"failed to setup routes", "routes"},
+	{"failed to setup DNS", "dns"},
+	{"connectivity check failed", "probe"},
+	{"health checks failed", "health_check"},
+	{"failed to enable kill switch", "kill_switch"},
+	{"failed to start the local proxy", "proxy"},
+	{"forwarding from", "forwarding"},
+}
+
+// errorCode classifies err for the event log, so failures can be counted
+// without reading their messages.
+func errorCode(err error) string {
+	var startErr *XrayStartError
+	switch {
+	case errors.As(err, &startErr):
+		return "xray_start"
+	case errors.Is(err, errNetworkChanged):
+		return "network_changed"
+	case errors.Is(err, errNotElevated):
+		return "not_elevated"
+	}
+	for _, c := range errorCodes {
+		if strings.Contains(err.Error(), c.text) {
+			return c.code
+		}
+	}
+	return "unknown"
+}
+
+// GetEvents returns the last events of the event log, oldest first.
+func (a *App) GetEvents() []Event {
+	return a.events.Events()
+}
+
+// ExportSupportBundle asks where to save a zip file with the logs, the
+// settings and a diagnostics report, for support requests, and saves it
+// there. It returns the path, or "" if the user canceled.
+func (a *App) ExportSupportBundle() (string, error) {
+	path, err := wailsruntime.SaveFileDialog(a.ctx, wailsruntime.SaveDialogOptions{
+		Title:           "Save Support Bundle",
+		DefaultFilename: fmt.Sprintf("drfrake-support-%s.zip", time.Now().Format("20060102-150405")),
+		Filters:         []wailsruntime.FileFilter{{DisplayName: "Zip files", Pattern: "*.zip"}},
+	})
+	if err != nil || path == "" {
+		return "", err
+	}
+	if err := a.writeSupportBundle(path); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to save support bundle: %w", err)
+	}
+	log.Printf("[Support] Saved support bundle to %s", path)
+	return path, nil
+}
+
+func (a *App) writeSupportBundle(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+
+	addJSON := func(name string, v any) error {
+		w, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	if err := addJSON("system.json", map[string]any{
+		"time":   time.Now().UTC(),
+		"os":     runtime.GOOS,
+		"arch":   runtime.GOARCH,
+		"go":     runtime.Version(),
+		"helper": a.GetHelperStatus(),
+	}); err != nil {
+		return err
+	}
+	// Without the server lists and keys, only what changes how the app
+	// connects.
+	if err := addJSON("settings.json", map[string]any{
+		"backendUrl": a.config.BackendURL,
+		"xrayMirror": a.config.XrayMirror,
+		"settings":   a.config.Settings,
+	}); err != nil {
+		return err
+	}
+	if err := addJSON("diagnostics.json", a.RunDiagnostics()); err != nil {
+		return err
+	}
+
+	var logs []string
+	for _, file := range []*rotatingFile{a.events.file, a.appLog, a.xrayLog.file} {
+		logs = append(logs, file.files()...)
+	}
+	for _, name := range logs {
+		if err := addFile(zw, name); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func addFile(zw *zip.Writer, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	w, err := zw.Create("logs/" + filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"drfrake-core"
+)
+
+// GetFamily returns the family plan the account owns or shares.
+func (a *App) GetFamily() (*core.Family, error) {
+	if a.currentUser == nil {
+		return nil, fmt.Errorf("not logged in")
+	}
+	return a.apiClient.Family(a.ctx)
+}
+
+// InviteToFamily emails an invitation code to the account's family plan.
+func (a *App) InviteToFamily(email string) error {
+	if a.currentUser == nil {
+		return fmt.Errorf("not logged in")
+	}
+	if _, err := a.apiClient.InviteToFamily(a.ctx, email); err != nil {
+		return err
+	}
+	log.Printf("[Family] Invited %s", email)
+	return nil
+}
+
+// AcceptFamilyInvite joins the family plan of an invitation code. The plan
+// changes with it, so the subscription is fetched again.
+func (a *App) AcceptFamilyInvite(token string) error {
+	if a.currentUser == nil {
+		return fmt.Errorf("not logged in")
+	}
+	if _, err := a.apiClient.AcceptFamilyInvite(a.ctx, token); err != nil {
+		return err
+	}
+	log.Printf("[Family] Joined a family plan")
+	_, err := a.GetSubscription()
+	return err
+}
+
+// CancelFamilyInvite withdraws an invitation not accepted yet.
+func (a *App) CancelFamilyInvite(id string) error {
+	if a.currentUser == nil {
+		return fmt.Errorf("not logged in")
+	}
+	_, err := a.apiClient.CancelFamilyInvite(a.ctx, id)
+	return err
+}
+
+// RemoveFamilyMember removes a member from the account's family plan, or
+// leaves the family the account is in when id is its own.
+func (a *App) RemoveFamilyMember(id string) error {
+	if a.currentUser == nil {
+		return fmt.Errorf("not logged in")
+	}
+	if _, err := a.apiClient.RemoveFamilyMember(a.ctx, id); err != nil {
+		return err
+	}
+	log.Printf("[Family] Removed member %s", id)
+	if id == a.currentUser.ID {
+		_, err := a.GetSubscription()
+		return err
+	}
+	return nil
+}
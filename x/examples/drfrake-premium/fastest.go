@@ -0,0 +1,160 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"drfrake-core"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+const (
+	// latencyWorkers is how many servers are measured at once.
+	latencyWorkers = 8
+	// latencyTie is how much slower than the fastest server a favorite or
+	// recent server may be to be chosen instead.
+	latencyTie = 15 * time.Millisecond
+)
+
+// FastestProgress is sent as "fastest:progress" events by ConnectFastest.
+type FastestProgress struct {
+	// Phase is "measuring" or "connecting".
+	Phase  string `json:"phase"`
+	Tested int    `json:"tested"`
+	Total  int    `json:"total"`
+	// ServerID is of the server connecting to.
+	ServerID string `json:"serverId,omitempty"`
+}
+
+// serverLatency is a server with how long it takes to connect to it.
+type serverLatency struct {
+	server  Server
+	latency time.Duration
+}
+
+// ConnectFastest measures the servers the plan covers and connects to the one
+// answering fastest. Of servers about as fast, see latencyTie, a favorite or
+// a recent one is preferred.
+func (a *App) ConnectFastest() (*Server, error) {
+	a.connMu.Lock()
+	connected := a.stopSupervisor != nil
+	a.connMu.Unlock()
+	if connected {
+		return nil, errors.New("already connected")
+	}
+
+	var servers []Server
+	for _, s := range a.GetServers() {
+		if s.Config != "" && !s.Locked {
+			servers = append(servers, s)
+		}
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("no servers to connect to")
+	}
+
+	log.Printf("[Fastest] Measuring %d servers...", len(servers))
+	measured := a.measureLatencies(servers)
+	if len(measured) == 0 {
+		return nil, errors.New("no server answered")
+	}
+	best := a.pickFastest(measured)
+	log.Printf("[Fastest] %s answered in %d ms, connecting.", best.server.ID, best.latency.Milliseconds())
+	a.fastestProgress(FastestProgress{Phase: "connecting", Tested: len(servers), Total: len(servers), ServerID: best.server.ID})
+	if err := a.Connect(best.server.Config, best.server.ID); err != nil {
+		return nil, err
+	}
+	return &best.server, nil
+}
+
+// measureLatencies measures servers, a few at a time, and remembers the
+// latencies for GetServers. Servers that don't answer are left out.
+func (a *App) measureLatencies(servers []Server) []serverLatency {
+	var mu sync.Mutex
+	var measured []serverLatency
+	tested := 0
+	jobs := make(chan Server)
+	var wg sync.WaitGroup
+	for range min(latencyWorkers, len(servers)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range jobs {
+				latency, err := core.MeasureServerLatency(s.Config)
+				mu.Lock()
+				tested++
+				if err == nil {
+					measured = append(measured, serverLatency{s, latency})
+				}
+				progress := FastestProgress{Phase: "measuring", Tested: tested, Total: len(servers)}
+				mu.Unlock()
+				if err != nil {
+					log.Printf("[Fastest] %s: %v", s.ID, err)
+				}
+				a.fastestProgress(progress)
+			}
+		}()
+	}
+	for _, s := range servers {
+		jobs <- s
+	}
+	close(jobs)
+	wg.Wait()
+
+	a.latencyMu.Lock()
+	if a.latencies == nil {
+		a.latencies = make(map[string]int)
+	}
+	for _, m := range measured {
+		a.latencies[m.server.ID] = int(m.latency.Milliseconds())
+	}
+	a.latencyMu.Unlock()
+	return measured
+}
+
+// pickFastest returns the fastest of measured, or a favorite or recent server
+// within latencyTie of it.
+func (a *App) pickFastest(measured []serverLatency) serverLatency {
+	a.configMu.Lock()
+	recents := slices.Clone(a.config.Recents)
+	a.configMu.Unlock()
+	// Favorites first, then recents, newest first.
+	preference := func(s Server) int {
+		if s.IsFavorite {
+			return 0
+		}
+		if i := slices.Index(recents, s.ID); i >= 0 {
+			return 1 + i
+		}
+		return 1 + len(recents)
+	}
+
+	fastest := slices.MinFunc(measured, func(x, y serverLatency) int { return cmp.Compare(x.latency, y.latency) })
+	return slices.MinFunc(measured, func(x, y serverLatency) int {
+		xTied, yTied := x.latency <= fastest.latency+latencyTie, y.latency <= fastest.latency+latencyTie
+		return cmp.Or(compareBool(!xTied, !yTied), cmp.Compare(preference(x.server), preference(y.server)),
+			cmp.Compare(x.latency, y.latency))
+	})
+}
+
+// markLatencies sets Latency of the servers that were measured.
+func (a *App) markLatencies(servers []Server) []Server {
+	a.latencyMu.Lock()
+	defer a.latencyMu.Unlock()
+	for i := range servers {
+		if ms, ok := a.latencies[servers[i].ID]; ok {
+			servers[i].Latency = ms
+		}
+	}
+	return servers
+}
+
+func (a *App) fastestProgress(p FastestProgress) {
+	if a.ctx != nil {
+		runtime.EventsEmit(a.ctx, "fastest:progress", p)
+	}
+}
@@ -4,27 +4,121 @@ import './App.css';
 import { Auth } from './Auth';
 import {
     Register, Login, Logout, GetCurrentUser,
-    GetServers, Connect, Disconnect, IsConnected,
+    GetServers, SearchServers, GetRecentServers, FavoriteServer, UnfavoriteServer,
+    GetServerTags, GroupServersByTag,
+    Connect, Disconnect, IsConnected,
     GetSubscription, InitPayment, CheckPayment,
-    CancelAutoRenew, EnableAutoRenew,
-    GetPaymentHistory, GetPaymentMethod
+    CancelAutoRenew, EnableAutoRenew, CancelNextPlan, PauseSubscription, ResumeSubscription,
+    GetPaymentHistory, GetPaymentMethod, SaveInvoice,
+    GetAccount, ChangeEmail,
+    GetDevices, RenameDevice, RevokeDevice,
+    GetFamily, InviteToFamily, AcceptFamilyInvite, CancelFamilyInvite, RemoveFamilyMember,
+    GetSettings, SaveSettings,
+    GetConnectionStatus, RunDiagnostics, GetStats, GetXrayLogs,
+    GetHelperStatus, InstallHelper, UninstallHelper, SetLaunchAtLogin, SetAutoConnect,
+    GetServerSubscription, SetServerSubscription, RefreshServerSubscription, GetServerListStatus,
+    GetProfiles, SwitchProfile, AddProfile, RunSpeedTest,
+    GetEvents, ExportSupportBundle, GetUpdateStatus, CheckForUpdate, InstallUpdate,
+    GetCurrentNetwork, Pause, Resume, ConnectFastest
 } from '../wailsjs/go/main/App';
-import { BrowserOpenURL } from '../wailsjs/runtime/runtime';
+import { BrowserOpenURL, EventsOn, ClipboardSetText } from '../wailsjs/runtime/runtime';
 
-type ViewType = 'home' | 'servers' | 'pricing' | 'account';
+type ViewType = 'home' | 'servers' | 'pricing' | 'account' | 'settings';
+
+// Samples kept for the traffic graph, one per second.
+const GRAPH_SAMPLES = 60;
+
+const formatBytes = (n: number) => {
+    const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+    let i = 0;
+    while (n >= 1024 && i < units.length - 1) {
+        n /= 1024;
+        i++;
+    }
+    return `${n.toFixed(i === 0 ? 0 : 1)} ${units[i]}`;
+};
+
+const formatDuration = (sec: number) => {
+    const h = Math.floor(sec / 3600);
+    const m = Math.floor((sec % 3600) / 60);
+    const s = sec % 60;
+    return [h, m, s].map(v => String(v).padStart(2, '0')).join(':');
+};
+
+function TrafficGraph({ samples }: { samples: { up: number, down: number }[] }) {
+    const width = 300, height = 60;
+    const max = Math.max(1, ...samples.map(s => Math.max(s.up, s.down)));
+    const line = (key: 'up' | 'down') => samples.map((s, i) =>
+        `${(i / (GRAPH_SAMPLES - 1)) * width},${height - (s[key] / max) * height}`).join(' ');
+    return (
+        <svg className="traffic-graph" width={width} height={height} viewBox={`0 0 ${width} ${height}`}>
+            <polyline points={line('down')} className="down" />
+            <polyline points={line('up')} className="up" />
+        </svg>
+    );
+}
 
 function App() {
     const [view, setView] = useState<ViewType>('home');
     const [servers, setServers] = useState<any[]>([]);
+    const [serverSearch, setServerSearch] = useState('');
+    const [serverSort, setServerSort] = useState('');
+    const [serverTags, setServerTags] = useState<string[]>([]);
+    const [tagFilter, setTagFilter] = useState<string[]>([]);
+    const [groupByTag, setGroupByTag] = useState(false);
+    const [shownServers, setShownServers] = useState<any[]>([]);
+    const [serverGroups, setServerGroups] = useState<any[]>([]);
+    const [recentServers, setRecentServers] = useState<any[]>([]);
+    const [serverList, setServerList] = useState<any>(null);
     const [user, setUser] = useState<any>(null); // This is the API mock user info, separating from Auth user
     const [authUser, setAuthUser] = useState<any>(null); // The actual logged in user
     const [connected, setConnected] = useState(false);
     const [selectedServer, setSelectedServer] = useState<any>(null);
+    const [activeServerId, setActiveServerId] = useState<string | null>(null);
     const [status, setStatus] = useState('Disconnected');
+    const [udp, setUdp] = useState('');
     const [subscription, setSubscription] = useState<any>(null);
     const [payments, setPayments] = useState<any[]>([]);
     const [paymentMethod, setPaymentMethod] = useState<any>(null);
     const [loading, setLoading] = useState(false);
+    const [account, setAccount] = useState<any>(null);
+    const [profiles, setProfiles] = useState<any[]>([]);
+    const [newEmail, setNewEmail] = useState('');
+    const [emailPassword, setEmailPassword] = useState('');
+    const [emailMessage, setEmailMessage] = useState('');
+    const [devices, setDevices] = useState<any[]>([]);
+    const [family, setFamily] = useState<any>(null);
+    const [inviteEmail, setInviteEmail] = useState('');
+    const [inviteCode, setInviteCode] = useState('');
+    const [deviceNames, setDeviceNames] = useState<Record<string, string>>({});
+    const [settings, setSettings] = useState<any>(null);
+    const [splitInclude, setSplitInclude] = useState('');
+    const [splitExclude, setSplitExclude] = useState('');
+    const [dnsServer, setDnsServer] = useState('');
+    const [proxyPort, setProxyPort] = useState('');
+    const [proxyDnsPort, setProxyDnsPort] = useState('');
+    const [helper, setHelper] = useState<any>(null);
+    const [serverSub, setServerSub] = useState<any>(null);
+    const [serverSubURL, setServerSubURL] = useState('');
+    const [serverSubBusy, setServerSubBusy] = useState(false);
+    const [helperBusy, setHelperBusy] = useState(false);
+    const [diagnostics, setDiagnostics] = useState<any>(null);
+    const [diagnosing, setDiagnosing] = useState(false);
+    const [speedTest, setSpeedTest] = useState<any>(null);
+    const [speedTestPhase, setSpeedTestPhase] = useState('');
+    const [speedTestURL, setSpeedTestURL] = useState('');
+    const [xrayLogs, setXrayLogs] = useState<any[] | null>(null);
+    const [events, setEvents] = useState<any[] | null>(null);
+    const [exporting, setExporting] = useState(false);
+    const [update, setUpdate] = useState<any>(null);
+    const [updateDismissed, setUpdateDismissed] = useState(false);
+    const [updateProgress, setUpdateProgress] = useState('');
+    const [checkingUpdate, setCheckingUpdate] = useState(false);
+    const [network, setNetwork] = useState<any>(null);
+    const [pausedUntil, setPausedUntil] = useState<Date | null>(null);
+    const [fastest, setFastest] = useState<string | null>(null);
+    const [stats, setStats] = useState<any>(null);
+    const [traffic, setTraffic] = useState<{ up: number, down: number }[]>([]);
 
     useEffect(() => {
         GetCurrentUser().then(u => {
@@ -33,8 +127,73 @@ function App() {
                 loadData();
             }
         });
+        GetConnectionStatus().then(applyConnectionStatus);
+        GetStats().then(setStats);
+        // The backend reports reconnects and failures on its own.
+        const offState = EventsOn('vpn:state', applyConnectionStatus);
+        const offStats = EventsOn('vpn:stats', (s: any) => {
+            setStats(s);
+            setTraffic(t => [...t, { up: s.upRate, down: s.downRate }].slice(-GRAPH_SAMPLES));
+        });
+        // xray-core is downloaded the first time a VLESS server is used.
+        const offDownload = EventsOn('xray:download', (p: any) => {
+            setStatus(p.total > 0
+                ? `Downloading Xray... ${Math.floor(p.done * 100 / p.total)}%`
+                : `Downloading Xray... ${formatBytes(p.done)}`);
+        });
+        GetUpdateStatus().then(setUpdate);
+        const offUpdate = EventsOn('update:available', setUpdate);
+        const offNetwork = EventsOn('network:changed', setNetwork);
+        const offFastest = EventsOn('fastest:progress', (p: any) => {
+            setFastest(p.phase === 'measuring' ? `Testing servers... ${p.tested}/${p.total}` : 'Connecting...');
+        });
+        const offUpdateDownload = EventsOn('update:download', (p: any) => {
+            setUpdateProgress(p.total > 0
+                ? `Downloading... ${Math.floor(p.done * 100 / p.total)}%`
+                : `Downloading... ${formatBytes(p.done)}`);
+        });
+        return () => {
+            offState();
+            offStats();
+            offDownload();
+            offUpdate();
+            offNetwork();
+            offFastest();
+            offUpdateDownload();
+        };
     }, []);
 
+    const applyConnectionStatus = (s: any) => {
+        if (!s) return;
+        switch (s.state) {
+            case 'connected':
+                setConnected(true);
+                // Shows when a fallback transport was used.
+                setStatus(s.transport ? `Connected via ${s.transport}` : 'Connected');
+                break;
+            case 'paused':
+                setConnected(true);
+                setStatus(`Paused until ${new Date(s.pausedUntil).toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' })}`);
+                break;
+            case 'reconnecting':
+                setConnected(true);
+                setStatus(`Reconnecting (attempt ${s.attempt})...`);
+                break;
+            case 'connecting':
+                setStatus('Connecting...');
+                break;
+            default:
+                setConnected(false);
+                setStatus(s.error ? 'Error' : 'Disconnected');
+                setStats(null);
+                setTraffic([]);
+        }
+        // Reconnecting may move to another server.
+        setActiveServerId(s.serverId || null);
+        setPausedUntil(s.state === 'paused' ? new Date(s.pausedUntil) : null);
+        setUdp(s.udp || '');
+    };
+
     const loadData = async () => {
         try {
             const [srv, conn, sub, pm] = await Promise.all([
@@ -44,6 +203,7 @@ function App() {
                 GetPaymentMethod(),
             ]);
             setServers(srv || []);
+            setServerList(await GetServerListStatus());
             setConnected(conn);
             setSubscription(sub);
             setPaymentMethod(pm);
@@ -62,6 +222,26 @@ function App() {
         setView('home');
     };
 
+    const handleSwitchProfile = async (id: string) => {
+        try {
+            const u = await SwitchProfile(id);
+            setSelectedServer(null);
+            setAuthUser(u);
+            setView('home');
+            loadData();
+        } catch (e: any) {
+            alert(String(e));
+            setProfiles(await GetProfiles() || []);
+        }
+    };
+
+    const handleAddProfile = async () => {
+        await AddProfile();
+        setSelectedServer(null);
+        setAuthUser(null);
+        setView('home');
+    };
+
     const toggleConnect = async () => {
         if (!selectedServer) {
             alert("Select a server first!");
@@ -71,26 +251,53 @@ function App() {
         if (connected) {
             setStatus('Disconnecting...');
             await Disconnect();
-            setConnected(false);
-            setStatus('Disconnected');
         } else {
-            setStatus('Connecting...');
             try {
                 await Connect(selectedServer.config, selectedServer.id);
-                setConnected(true);
-                setStatus('Connected');
             } catch (err: any) {
                 alert("Connection failed: " + err);
-                setStatus('Error');
             }
         }
     };
 
+    const handleConnectFastest = async () => {
+        setFastest('Testing servers...');
+        try {
+            setSelectedServer(await ConnectFastest());
+        } catch (err: any) {
+            alert("Connection failed: " + err);
+        } finally {
+            setFastest(null);
+        }
+    };
+
+    const handlePause = async (minutes: number) => {
+        try {
+            await Pause(minutes * 60);
+        } catch (e: any) {
+            alert(String(e));
+        }
+    };
+
+    const handleResume = async () => {
+        try {
+            await Resume();
+        } catch (e: any) {
+            alert(String(e));
+        }
+    };
+
     const handlePayment = async (plan: string) => {
         setLoading(true);
         try {
             // 1. Init Payment
             const resp = await InitPayment(plan);
+            if (resp?.status === 'scheduled') {
+                alert(`Your plan switches to ${plan} on ${new Date(resp.effective_at).toLocaleDateString()}, when the current one ends.`);
+                setSubscription(await GetSubscription());
+                setLoading(false);
+                return;
+            }
             if (!resp || !resp.confirmation_url) {
                 alert("Failed to initialize payment");
                 setLoading(false);
@@ -144,15 +351,161 @@ function App() {
     };
 
     const handleToggleAutoRenew = async () => {
-        if (subscription?.autoRenew) {
-            await CancelAutoRenew();
-        } else {
-            await EnableAutoRenew();
+        try {
+            if (subscription?.autoRenew) {
+                await CancelAutoRenew();
+            } else {
+                await EnableAutoRenew();
+            }
+        } catch (e: any) {
+            alert(String(e));
         }
         const sub = await GetSubscription();
         setSubscription(sub);
     };
 
+    const handleCancelNextPlan = async () => {
+        try {
+            await CancelNextPlan();
+        } catch (e: any) {
+            alert(String(e));
+        }
+        setSubscription(await GetSubscription());
+    };
+
+    const handlePauseSubscription = async (days: number) => {
+        if (!confirm(`Pause your subscription for ${days} days? Servers won't connect until it resumes, and your billing date moves out by as much.`)) return;
+        try {
+            await PauseSubscription(days);
+        } catch (e: any) {
+            alert(String(e));
+        }
+        setSubscription(await GetSubscription());
+    };
+
+    const handleResumeSubscription = async () => {
+        try {
+            await ResumeSubscription();
+        } catch (e: any) {
+            alert(String(e));
+        }
+        setSubscription(await GetSubscription());
+    };
+
+    const loadAccount = async () => {
+        try {
+            const acc = await GetAccount();
+            setAccount(acc);
+            if (acc && acc.email !== authUser?.email) {
+                setAuthUser({ ...authUser, email: acc.email });
+            }
+        } catch (e) {
+            console.error("Failed to load account:", e);
+        }
+    };
+
+    const handleChangeEmail = async () => {
+        if (!newEmail || !emailPassword) {
+            setEmailMessage('Enter the new email and your current password.');
+            return;
+        }
+        try {
+            await ChangeEmail(newEmail, emailPassword);
+            setEmailMessage(`We sent a confirmation link to ${newEmail}. Your email changes once you open it.`);
+            setNewEmail('');
+            setEmailPassword('');
+            await loadAccount();
+        } catch (e: any) {
+            setEmailMessage(String(e));
+        }
+    };
+
+    const loadDevices = async () => {
+        try {
+            setDevices(await GetDevices() || []);
+        } catch (e) {
+            console.error("Failed to load devices:", e);
+        }
+    };
+
+    const handleRenameDevice = async (id: string) => {
+        try {
+            await RenameDevice(id, deviceNames[id] || '');
+            setDeviceNames({ ...deviceNames, [id]: undefined });
+            await loadDevices();
+        } catch (e: any) {
+            alert(String(e));
+        }
+    };
+
+    const handleRevokeDevice = async (d: any) => {
+        if (!confirm(`Revoke ${d.name || 'this device'}? Its keys stop working and it is logged out.`)) {
+            return;
+        }
+        try {
+            await RevokeDevice(d.id);
+        } catch (e: any) {
+            alert(String(e));
+            return;
+        }
+        await loadDevices();
+    };
+
+    const loadFamily = async () => {
+        try {
+            setFamily(await GetFamily());
+        } catch (e) {
+            console.error("Failed to load family:", e);
+        }
+    };
+
+    const handleInviteToFamily = async () => {
+        try {
+            await InviteToFamily(inviteEmail.trim());
+            setInviteEmail('');
+        } catch (e: any) {
+            alert(String(e));
+        }
+        await loadFamily();
+    };
+
+    const handleJoinFamily = async () => {
+        try {
+            await AcceptFamilyInvite(inviteCode.trim());
+            setInviteCode('');
+        } catch (e: any) {
+            alert(String(e));
+            return;
+        }
+        await loadFamily();
+        setSubscription(await GetSubscription());
+    };
+
+    const handleRemoveFamilyMember = async (id: string, email: string) => {
+        const leaving = id === account?.id;
+        if (!confirm(leaving ? `Leave the family plan of ${email}? You are back on the free plan.` : `Remove ${email} from your family plan?`)) {
+            return;
+        }
+        try {
+            await RemoveFamilyMember(id);
+        } catch (e: any) {
+            alert(String(e));
+        }
+        await loadFamily();
+        if (leaving) {
+            setSubscription(await GetSubscription());
+        }
+    };
+
+    const handleCancelFamilyInvite = async (id: string) => {
+        try {
+            await CancelFamilyInvite(id);
+        } catch (e: any) {
+            alert(String(e));
+        }
+        await loadFamily();
+    };
+
     const handleSaveCard = async () => {
         await SavePaymentMethod("4242", "Visa", "12/28");
         const pm = await GetPaymentMethod();
@@ -160,6 +513,220 @@ function App() {
         alert("Payment method saved!");
     };
 
+    const updateSetting = async (key: string, value: any) => {
+        const next = { ...settings, [key]: value };
+        try {
+            await SaveSettings(next);
+        } catch (e: any) {
+            alert(String(e));
+        }
+        setSettings(await GetSettings());
+    };
+
+    useEffect(() => {
+        setSplitInclude((settings?.splitTunnel?.include || []).join('\n'));
+        setSplitExclude((settings?.splitTunnel?.exclude || []).join('\n'));
+        setDnsServer(settings?.dns || '');
+        setProxyPort(settings?.proxyPort ? String(settings.proxyPort) : '');
+        setProxyDnsPort(settings?.proxyDnsPort ? String(settings.proxyDnsPort) : '');
+        setSpeedTestURL(settings?.speedTestUrl || '');
+    }, [settings]);
+
+    const splitEntries = (text: string) => text.split(/[\s,]+/).filter(e => e !== '');
+
+    const handleSaveSplitTunnel = () => updateSetting('splitTunnel', {
+        ...settings?.splitTunnel,
+        include: splitEntries(splitInclude),
+        exclude: splitEntries(splitExclude),
+    });
+
+    const handleRunDiagnostics = async () => {
+        setDiagnosing(true);
+        try {
+            setDiagnostics(await RunDiagnostics());
+        } finally {
+            setDiagnosing(false);
+        }
+    };
+
+    const loadServerList = async (search = serverSearch, sort = serverSort, tags = tagFilter, grouped = groupByTag) => {
+        try {
+            const query = { search, sort, tags };
+            const [found, recent] = await Promise.all([
+                grouped ? GroupServersByTag(query) : SearchServers(query),
+                GetRecentServers(),
+            ]);
+            if (grouped) {
+                setServerGroups(found || []);
+            } else {
+                setShownServers(found || []);
+            }
+            setRecentServers(recent || []);
+            setServerList(await GetServerListStatus());
+        } catch (e: any) {
+            console.error("Failed to load servers:", e);
+        }
+    };
+
+    const toggleFavorite = async (s: any) => {
+        await (s.isFavorite ? UnfavoriteServer(s.id) : FavoriteServer(s.id));
+        setServers(await GetServers() || []);
+        loadServerList();
+    };
+
+    const handleRunSpeedTest = async () => {
+        setSpeedTest(null);
+        setSpeedTestPhase('latency');
+        const off = EventsOn('speedtest:phase', setSpeedTestPhase);
+        try {
+            setSpeedTest(await RunSpeedTest());
+        } catch (e: any) {
+            alert(String(e));
+        } finally {
+            off();
+            setSpeedTestPhase('');
+        }
+    };
+
+    const handleShowXrayLogs = async () => setXrayLogs(await GetXrayLogs() || []);
+
+    const handleCheckForUpdate = async () => {
+        setCheckingUpdate(true);
+        try {
+            setUpdate(await CheckForUpdate());
+            setUpdateDismissed(false);
+        } catch (e: any) {
+            setUpdate(await GetUpdateStatus());
+        } finally {
+            setCheckingUpdate(false);
+        }
+    };
+
+    const handleInstallUpdate = async () => {
+        setUpdateProgress('Downloading...');
+        try {
+            // The app quits once the installer starts.
+            await InstallUpdate();
+        } catch (e: any) {
+            alert(String(e));
+        }
+        setUpdateProgress('');
+    };
+
+    const networkRules = settings?.networkRules || { enabled: false, trusted: [] };
+
+    const setTrusted = (trusted: any[]) => updateSetting('networkRules', { ...networkRules, trusted });
+
+    const handleTrustNetwork = (n: any) => {
+        setTrusted([...(networkRules.trusted || []), { id: n.id, name: n.name }]);
+        setNetwork({ ...n, trusted: true });
+    };
+
+    const handleUntrustNetwork = (id: string) => {
+        setTrusted((networkRules.trusted || []).filter((t: any) => t.id !== id));
+        if (network?.id === id) {
+            setNetwork({ ...network, trusted: false });
+        }
+    };
+
+    const handleSaveInvoice = async (paymentID: string, format: string) => {
+        try {
+            const path = await SaveInvoice(paymentID, format);
+            if (path) {
+                alert(`Saved to ${path}`);
+            }
+        } catch (e: any) {
+            alert(String(e));
+        }
+    };
+
+    const handleShowEvents = async () => setEvents(await GetEvents() || []);
+
+    const handleExportSupportBundle = async () => {
+        setExporting(true);
+        try {
+            const path = await ExportSupportBundle();
+            if (path) {
+                alert(`Saved to ${path}`);
+            }
+        } catch (e: any) {
+            alert(String(e));
+        } finally {
+            setExporting(false);
+        }
+    };
+
+    const handleStartupSetting = async (set: (enabled: boolean) => Promise<void>, enabled: boolean) => {
+        try {
+            await set(enabled);
+        } catch (e: any) {
+            alert(String(e));
+        }
+        setSettings(await GetSettings());
+    };
+
+    const loadServerSub = async () => {
+        const sub = await GetServerSubscription();
+        setServerSub(sub);
+        setServerSubURL(sub?.url || '');
+    };
+
+    const handleServerSub = async (update: () => Promise<void>) => {
+        setServerSubBusy(true);
+        try {
+            await update();
+        } catch (e: any) {
+            alert(String(e));
+        } finally {
+            setServerSubBusy(false);
+        }
+        await loadServerSub();
+        setServers(await GetServers() || []);
+    };
+
+    const handleHelper = async (install: boolean) => {
+        setHelperBusy(true);
+        try {
+            await (install ? InstallHelper() : UninstallHelper());
+        } catch (e: any) {
+            alert(String(e));
+        } finally {
+            setHelperBusy(false);
+        }
+        setHelper(await GetHelperStatus());
+    };
+
+    const shownServer = servers.find(x => x.id === activeServerId) || selectedServer;
+
+    const renderServerCard = (s: any) => (
+        <div key={s.id} className={`server-card ${selectedServer?.id === s.id ? 'selected' : ''}`} onClick={() => {
+            // The backend locks what the plan doesn't cover; the cached
+            // plan only hints at it until the servers are refreshed.
+            if (s.locked || (s.isPremium && !isPremium)) {
+                setView('pricing');
+            } else {
+                setSelectedServer(s);
+                setView('home');
+            }
+        }}>
+            <span className={`favorite ${s.isFavorite ? 'active' : ''}`} title={s.isFavorite ? 'Remove from favorites' : 'Add to favorites'}
+                onClick={e => { e.stopPropagation(); toggleFavorite(s); }}>
+                {s.isFavorite ? '★' : '☆'}
+            </span>
+            <div style={{ fontSize: '2rem' }}>{s.flag}</div>
+            <div style={{ fontWeight: 'bold', margin: '0.5rem 0' }}>
+                {s.city ? `${s.city}, ${s.country}` : s.country}
+                {s.isPremium && <span className="badge">{s.locked ? '🔒 PREMIUM' : 'PREMIUM'}</span>}
+            </div>
+            <div style={{ fontSize: '0.8rem', color: s.latency < 80 ? '#00ff88' : '#ffaa00' }}>{s.latency} ms</div>
+            {s.tags?.length > 0 && (
+                <div className="server-tags">
+                    {s.tags.map(t => <span key={t} className="tag-chip">{t}</span>)}
+                </div>
+            )}
+        </div>
+    );
+
     const daysRemaining = () => {
         if (!subscription?.expiryDate) return null;
         const diff = new Date(subscription.expiryDate).getTime() - Date.now();
@@ -173,14 +740,29 @@ function App() {
             <aside className="sidebar">
                 <div className="logo-area">DR. FRAKE</div>
                 <nav className="nav-links">
-                    {(['home', 'servers', 'pricing', 'account'] as ViewType[]).map(v => (
+                    {(['home', 'servers', 'pricing', 'account', 'settings'] as ViewType[]).map(v => (
                         <div key={v} className={`nav-item ${view === v ? 'active' : ''}`} onClick={() => {
                             if (v === 'account') {
                                 GetPaymentHistory().then(p => setPayments(p || []));
+                                loadAccount();
+                                loadDevices();
+                                loadFamily();
+                                GetProfiles().then(p => setProfiles(p || []));
+                                setEmailMessage('');
+                            }
+                            if (v === 'servers') {
+                                loadServerList();
+                                GetServerTags().then(t => setServerTags(t || []));
+                            }
+                            if (v === 'settings') {
+                                GetSettings().then(setSettings);
+                                GetHelperStatus().then(setHelper);
+                                GetCurrentNetwork().then(setNetwork).catch(() => setNetwork(null));
+                                loadServerSub();
                             }
                             setView(v);
                         }}>
-                            {v === 'home' ? '🏠 Home' : v === 'servers' ? '🌍 Locations' : v === 'pricing' ? '💎 Pricing' : '👤 Account'}
+                            {v === 'home' ? '🏠 Home' : v === 'servers' ? '🌍 Locations' : v === 'pricing' ? '💎 Pricing' : v === 'account' ? '👤 Account' : '⚙️ Settings'}
                         </div>
                     ))}
                     <div className="nav-item" onClick={handleLogout} style={{ marginTop: '2rem', color: '#ff4d4d' }}>
@@ -205,16 +787,42 @@ function App() {
             </aside>
 
             <main className="main-content">
+                {update?.available && (update.critical || !updateDismissed) && (
+                    <div className={`update-banner ${update.critical ? 'critical' : ''}`}>
+                        <div>
+                            {update.critical
+                                ? `Version ${update.version} fixes a security issue. Please update now.`
+                                : `Version ${update.version} is available.`}
+                            {update.notes && <div className="update-notes">{update.notes}</div>}
+                        </div>
+                        <div className="update-actions">
+                            <button className="btn-outline" disabled={!!updateProgress} onClick={handleInstallUpdate}>
+                                {updateProgress || 'Update and Restart'}
+                            </button>
+                            {!update.critical && (
+                                <button className="btn-outline" disabled={!!updateProgress} onClick={() => setUpdateDismissed(true)}>Later</button>
+                            )}
+                        </div>
+                    </div>
+                )}
                 {view === 'home' && (
                     <div className="dashboard">
                         {subscription?.status === 'grace' && (
                             <div className="grace-banner">
-                                ⚠️ Your subscription has expired. You have 3 days to renew before losing premium access.
+                                ⚠️ Your subscription has expired and we couldn't charge {subscription?.paymentMethod || 'your card'}. Renew by {new Date(subscription.graceUntil).toLocaleDateString()} to keep premium access.
                                 <button onClick={() => setView('pricing')} style={{ marginLeft: '1rem', color: '#00d7ff', background: 'none', border: '1px solid #00d7ff', borderRadius: '6px', padding: '4px 12px', cursor: 'pointer' }}>
                                     Renew Now
                                 </button>
                             </div>
                         )}
+                        {subscription?.status === 'paused' && (
+                            <div className="grace-banner">
+                                ⏸️ Your subscription is paused until {new Date(subscription.pausedUntil).toLocaleDateString()}. Servers won't connect until you resume it.
+                                <button onClick={handleResumeSubscription} style={{ marginLeft: '1rem', color: '#00d7ff', background: 'none', border: '1px solid #00d7ff', borderRadius: '6px', padding: '4px 12px', cursor: 'pointer' }}>
+                                    Resume Now
+                                </button>
+                            </div>
+                        )}
                         <div className={`connect-hub ${connected ? 'connected' : ''}`} onClick={toggleConnect}>
                             <div className="outer-ring"></div>
                             <div className="inner-circle">
@@ -224,34 +832,118 @@ function App() {
                             </div>
                         </div>
                         <div style={{ marginTop: '3rem', textAlign: 'center' }}>
-                            <h3>{selectedServer ? `${selectedServer.flag} ${selectedServer.country}` : 'No Server Selected'}</h3>
+                            <h3>{shownServer ? `${shownServer.flag} ${shownServer.country}` : 'No Server Selected'}</h3>
                             <p style={{ color: '#666' }}>Secure shadowsocks tunnel</p>
+                            {connected && udp === 'available' && (
+                                <p style={{ color: '#4caf50', fontSize: '0.8rem' }}>UDP ✓ QUIC and calls work</p>
+                            )}
+                            {connected && udp === 'blocked' && (
+                                <p style={{ color: '#ff9800', fontSize: '0.8rem' }}
+                                    title="This server doesn't relay UDP. Browsers fall back to TCP, but video calls and games may not work.">
+                                    TCP-only: video calls and QUIC may not work
+                                </p>
+                            )}
                         </div>
+                        {!connected && (
+                            <div className="pause-controls">
+                                <button className="btn-outline" disabled={fastest !== null} onClick={handleConnectFastest}>
+                                    {fastest || '⚡ Fastest Server'}
+                                </button>
+                            </div>
+                        )}
+                        {connected && (
+                            <div className="pause-controls">
+                                {pausedUntil ? (
+                                    <button className="btn-outline" onClick={handleResume}>Resume Now</button>
+                                ) : (
+                                    <>
+                                        <span>Pause for</span>
+                                        {[5, 15, 60].map(m => (
+                                            <button key={m} className="btn-outline" onClick={() => handlePause(m)}>
+                                                {m < 60 ? `${m} min` : `${m / 60} h`}
+                                            </button>
+                                        ))}
+                                    </>
+                                )}
+                            </div>
+                        )}
+                        {connected && stats && (
+                            <div className="traffic-stats">
+                                <div className="traffic-row">
+                                    <span className="up">↑ {formatBytes(stats.upRate)}/s</span>
+                                    <span>{formatDuration(stats.durationSec)}</span>
+                                    <span className="down">↓ {formatBytes(stats.downRate)}/s</span>
+                                </div>
+                                <TrafficGraph samples={traffic} />
+                                <div className="traffic-row" style={{ opacity: 0.6 }}>
+                                    <span>Sent {formatBytes(stats.bytesUp)}</span>
+                                    <span>{stats.activeConnections} open</span>
+                                    <span>Received {formatBytes(stats.bytesDown)}</span>
+                                </div>
+                            </div>
+                        )}
                     </div>
                 )}
 
                 {view === 'servers' && (
                     <div>
                         <h2 style={{ marginBottom: '2rem' }}>🌍 Global Servers</h2>
-                        <div className="server-grid">
-                            {servers.map(s => (
-                                <div key={s.id} className={`server-card ${selectedServer?.id === s.id ? 'selected' : ''}`} onClick={() => {
-                                    if (s.isPremium && !isPremium) {
-                                        setView('pricing');
-                                    } else {
-                                        setSelectedServer(s);
-                                        setView('home');
-                                    }
-                                }}>
-                                    <div style={{ fontSize: '2rem' }}>{s.flag}</div>
-                                    <div style={{ fontWeight: 'bold', margin: '0.5rem 0' }}>
-                                        {s.city}, {s.country}
-                                        {s.isPremium && <span className="badge">PREMIUM</span>}
-                                    </div>
-                                    <div style={{ fontSize: '0.8rem', color: s.latency < 80 ? '#00ff88' : '#ffaa00' }}>{s.latency} ms</div>
-                                </div>
-                            ))}
+                        {(serverList?.cached || serverList?.builtin) && (
+                            <div className="grace-banner">
+                                {serverList.cached
+                                    ? `Can't reach our servers. Showing your server list from ${new Date(serverList.updatedAt).toLocaleString()}, it may be out of date.`
+                                    : "Can't reach our servers. Showing the built-in server list."}
+                            </div>
+                        )}
+                        <div className="server-filters">
+                            <input type="text" placeholder="Search country or city" value={serverSearch}
+                                onChange={e => { setServerSearch(e.target.value); loadServerList(e.target.value, serverSort); }} />
+                            <select value={serverSort} onChange={e => { setServerSort(e.target.value); loadServerList(serverSearch, e.target.value); }}>
+                                <option value="">Default order</option>
+                                <option value="country">Country</option>
+                                <option value="latency">Latency</option>
+                                <option value="premium">Premium first</option>
+                            </select>
+                            {serverTags.length > 0 && (
+                                <label className="group-toggle">
+                                    <input type="checkbox" checked={groupByTag}
+                                        onChange={e => { setGroupByTag(e.target.checked); loadServerList(serverSearch, serverSort, tagFilter, e.target.checked); }} />
+                                    Group by tag
+                                </label>
+                            )}
                         </div>
+                        {serverTags.length > 0 && (
+                            <div className="tag-filters">
+                                {serverTags.map(t => (
+                                    <button key={t} className={`tag-chip ${tagFilter.includes(t) ? 'active' : ''}`} onClick={() => {
+                                        const tags = tagFilter.includes(t) ? tagFilter.filter(x => x !== t) : [...tagFilter, t];
+                                        setTagFilter(tags);
+                                        loadServerList(serverSearch, serverSort, tags, groupByTag);
+                                    }}>{t}</button>
+                                ))}
+                            </div>
+                        )}
+                        {recentServers.length > 0 && serverSearch === '' && tagFilter.length === 0 && (
+                            <>
+                                <h3 className="server-section">Recent</h3>
+                                <div className="server-grid">
+                                    {recentServers.map(s => renderServerCard(s))}
+                                </div>
+                                <h3 className="server-section">All Servers</h3>
+                            </>
+                        )}
+                        {groupByTag ? serverGroups.map(g => (
+                            <div key={g.tag}>
+                                <h3 className="server-section">{g.tag || 'Other'}</h3>
+                                <div className="server-grid">
+                                    {g.servers.map(s => renderServerCard(s))}
+                                </div>
+                            </div>
+                        )) : (
+                            <div className="server-grid">
+                                {shownServers.map(s => renderServerCard(s))}
+                            </div>
+                        )}
                     </div>
                 )}
 
@@ -305,6 +997,23 @@ function App() {
                                     {subscription?.plan === 'yearly' ? 'Active' : loading ? 'Processing...' : 'Subscribe'}
                                 </button>
                             </div>
+                            <div className="pricing-card">
+                                <h3>Family</h3>
+                                <div className="price">$14.99<span>/mo</span></div>
+                                <div style={{ color: '#00ff88', fontSize: '0.8rem', marginBottom: '1rem' }}>Up to 5 accounts</div>
+                                <ul className="features">
+                                    <li>✅ Everything in Monthly</li>
+                                    <li>✅ Invite 4 people by email</li>
+                                    <li>✅ Own login and keys for each</li>
+                                </ul>
+                                <button
+                                    className="btn-primary"
+                                    disabled={loading || subscription?.plan === 'family' || !!subscription?.familyOwner}
+                                    onClick={() => handleUpgrade('family')}
+                                >
+                                    {subscription?.plan === 'family' ? 'Active' : loading ? 'Processing...' : 'Subscribe'}
+                                </button>
+                            </div>
                         </div>
                     </div>
                 )}
@@ -313,6 +1022,108 @@ function App() {
                     <div>
                         <h2 style={{ marginBottom: '2rem' }}>👤 Account</h2>
 
+                        <div className="account-card" style={{ marginBottom: '1.5rem' }}>
+                            <h3>Accounts</h3>
+                            {profiles.map(p => (
+                                <div className="account-row" key={p.id}>
+                                    <span>{p.email}</span>
+                                    {p.active
+                                        ? <span style={{ color: '#00ff88' }}>Current</span>
+                                        : <button className="btn-outline" onClick={() => handleSwitchProfile(p.id)}>Switch</button>}
+                                </div>
+                            ))}
+                            <button className="btn-outline" style={{ marginTop: '1rem' }} onClick={handleAddProfile}>Add Account</button>
+                        </div>
+
+                        <div className="account-card" style={{ marginBottom: '1.5rem' }}>
+                            <h3>Email</h3>
+                            <div className="account-row">
+                                <span>Current</span>
+                                <span>{account?.email || authUser?.email}</span>
+                            </div>
+                            {account?.pending_email && (
+                                <div className="account-row">
+                                    <span>Awaiting confirmation</span>
+                                    <span style={{ color: '#ffaa00' }}>{account.pending_email}</span>
+                                </div>
+                            )}
+                            <div className="email-form">
+                                <input type="email" placeholder="New email" value={newEmail} onChange={e => setNewEmail(e.target.value)} />
+                                <input type="password" placeholder="Current password" value={emailPassword} onChange={e => setEmailPassword(e.target.value)} />
+                                <button className="btn-outline" onClick={handleChangeEmail}>Change Email</button>
+                            </div>
+                            {emailMessage && <div style={{ fontSize: '0.8rem', color: '#888', marginTop: '0.5rem' }}>{emailMessage}</div>}
+                        </div>
+
+                        {devices.length > 0 && (
+                            <div className="account-card" style={{ marginBottom: '1.5rem' }}>
+                                <h3>Devices</h3>
+                                {devices.map(d => (
+                                    <div className="account-row" key={d.id}>
+                                        <span>
+                                            <input type="text" value={deviceNames[d.id] ?? d.name} placeholder="Unnamed device"
+                                                onChange={e => setDeviceNames({ ...deviceNames, [d.id]: e.target.value })}
+                                                onBlur={() => deviceNames[d.id] !== undefined && deviceNames[d.id] !== d.name && handleRenameDevice(d.id)} />
+                                            <div style={{ fontSize: '0.75rem', color: '#888' }}>
+                                                Added {new Date(d.created_at).toLocaleDateString()}, {d.keys} keys, last seen {new Date(d.last_seen_at).toLocaleString()}
+                                            </div>
+                                        </span>
+                                        {d.current
+                                            ? <span style={{ color: '#00ff88' }}>This device</span>
+                                            : <button className="btn-outline" onClick={() => handleRevokeDevice(d)}>Revoke</button>}
+                                    </div>
+                                ))}
+                            </div>
+                        )}
+
+                        {family && (
+                            <div className="account-card" style={{ marginBottom: '1.5rem' }}>
+                                <h3>Family</h3>
+                                {family.owner && (
+                                    <div className="account-row">
+                                        <span>Shared by {family.owner}</span>
+                                        <button className="btn-outline" onClick={() => handleRemoveFamilyMember(account?.id, family.owner)}>Leave</button>
+                                    </div>
+                                )}
+                                {(family.members || []).map((m: any) => (
+                                    <div className="account-row" key={m.id}>
+                                        <span>
+                                            {m.email}
+                                            <div style={{ fontSize: '0.75rem', color: '#888' }}>Joined {new Date(m.joined_at).toLocaleDateString()}</div>
+                                        </span>
+                                        {m.current
+                                            ? <span style={{ color: '#00ff88' }}>You</span>
+                                            : !family.owner && <button className="btn-outline" onClick={() => handleRemoveFamilyMember(m.id, m.email)}>Remove</button>}
+                                    </div>
+                                ))}
+                                {(family.invites || []).map((inv: any) => (
+                                    <div className="account-row" key={inv.id}>
+                                        <span>
+                                            {inv.email}
+                                            <div style={{ fontSize: '0.75rem', color: '#888' }}>Invited, expires {new Date(inv.expires_at).toLocaleDateString()}</div>
+                                        </span>
+                                        <button className="btn-outline" onClick={() => handleCancelFamilyInvite(inv.id)}>Cancel</button>
+                                    </div>
+                                ))}
+                                {!family.owner && family.seats > 1 && (
+                                    <div className="email-form">
+                                        <span style={{ fontSize: '0.8rem', color: '#888' }}>
+                                            {family.seats - 1 - family.members.length - family.invites.length} of {family.seats - 1} seats free
+                                        </span>
+                                        <input type="email" placeholder="Email to invite" value={inviteEmail} onChange={e => setInviteEmail(e.target.value)} />
+                                        <button className="btn-outline" onClick={handleInviteToFamily}
+                                            disabled={family.members.length + family.invites.length >= family.seats - 1}>Invite</button>
+                                    </div>
+                                )}
+                                {!family.owner && family.seats <= 1 && (
+                                    <div className="email-form">
+                                        <input type="text" placeholder="Invitation code" value={inviteCode} onChange={e => setInviteCode(e.target.value)} />
+                                        <button className="btn-outline" onClick={handleJoinFamily}>Join Family</button>
+                                    </div>
+                                )}
+                            </div>
+                        )}
+
                         <div className="account-card">
                             <h3>Subscription</h3>
                             <div className="account-row">
@@ -327,7 +1138,18 @@ function App() {
                                     {subscription?.status?.toUpperCase()}
                                 </span>
                             </div>
-                            {isPremium && (
+                            {subscription?.offline && (
+                                <div className="account-row" style={{ fontSize: '0.75rem', color: '#888' }}>
+                                    Offline, showing the state as of {new Date(subscription.updatedAt).toLocaleString()}
+                                </div>
+                            )}
+                            {isPremium && subscription?.familyOwner && (
+                                <div className="account-row">
+                                    <span>Paid by</span>
+                                    <span>{subscription.familyOwner}</span>
+                                </div>
+                            )}
+                            {isPremium && !subscription?.familyOwner && (
                                 <>
                                     <div className="account-row">
                                         <span>Next billing date</span>
@@ -335,8 +1157,38 @@ function App() {
                                     </div>
                                     <div className="account-row">
                                         <span>Price</span>
-                                        <span>${subscription?.price?.toFixed(2)}</span>
+                                        <span>{subscription?.price?.toFixed(2)} ₽</span>
                                     </div>
+                                    {subscription?.nextPlan && (
+                                        <div className="account-row">
+                                            <span>Switches to {subscription.nextPlan} on the next billing date</span>
+                                            <button className="btn-outline" onClick={handleCancelNextPlan}>Keep {subscription.plan}</button>
+                                        </div>
+                                    )}
+                                    {subscription?.status === 'paused' ? (
+                                        <div className="account-row">
+                                            <span>Paused until {new Date(subscription.pausedUntil).toLocaleDateString()}</span>
+                                            <button className="btn-outline" onClick={handleResumeSubscription}>Resume</button>
+                                        </div>
+                                    ) : subscription?.status === 'active' && subscription.pauseDaysLeft > 0 && (
+                                        <div className="account-row">
+                                            <span>Pause ({subscription.pauseDaysLeft} days left this year)</span>
+                                            <span>
+                                                {[7, 14, 30].filter(d => d <= subscription.pauseDaysLeft).map(d => (
+                                                    <button key={d} className="btn-outline" onClick={() => handlePauseSubscription(d)}>{d} days</button>
+                                                ))}
+                                                {subscription.pauseDaysLeft < 7 && (
+                                                    <button className="btn-outline" onClick={() => handlePauseSubscription(subscription.pauseDaysLeft)}>{subscription.pauseDaysLeft} days</button>
+                                                )}
+                                            </span>
+                                        </div>
+                                    )}
+                                    {subscription?.paymentMethod && (
+                                        <div className="account-row">
+                                            <span>Payment method</span>
+                                            <span>{subscription.paymentMethod}</span>
+                                        </div>
+                                    )}
                                     <div className="account-row">
                                         <span>Auto-Renewal</span>
                                         <label className="toggle">
@@ -353,15 +1205,23 @@ function App() {
                                 <h3>Payment History</h3>
                                 <table className="payment-table">
                                     <thead>
-                                        <tr><th>Date</th><th>Plan</th><th>Amount</th><th>Status</th></tr>
+                                        <tr><th>Date</th><th>Plan</th><th>Amount</th><th>Status</th><th>Invoice</th></tr>
                                     </thead>
                                     <tbody>
                                         {payments.map(p => (
                                             <tr key={p.id}>
                                                 <td>{new Date(p.createdAt).toLocaleDateString()}</td>
-                                                <td>{p.plan}</td>
-                                                <td>${p.amount.toFixed(2)}</td>
+                                                <td title={p.description}>{p.plan}</td>
+                                                <td>{p.amount.toFixed(2)} ₽</td>
                                                 <td><span className={`status-badge ${p.status}`}>{p.status}</span></td>
+                                                <td>
+                                                    {p.hasInvoice && (
+                                                        <>
+                                                            <button className="btn-outline" onClick={() => handleSaveInvoice(p.id, 'pdf')}>PDF</button>
+                                                            <button className="btn-outline" onClick={() => handleSaveInvoice(p.id, 'html')}>HTML</button>
+                                                        </>
+                                                    )}
+                                                </td>
                                             </tr>
                                         ))}
                                     </tbody>
@@ -370,6 +1230,403 @@ function App() {
                         )}
                     </div>
                 )}
+
+                {view === 'settings' && (
+                    <div>
+                        <h2 style={{ marginBottom: '2rem' }}>⚙️ Settings</h2>
+
+                        <div className="account-card">
+                            <h3>Connection Mode</h3>
+                            <div className="account-row">
+                                <span>Mode</span>
+                                <select value={settings?.mode || 'vpn'} onChange={e => updateSetting('mode', e.target.value)}>
+                                    <option value="vpn">System VPN</option>
+                                    <option value="proxy">Proxy only</option>
+                                </select>
+                            </div>
+                            {settings?.mode === 'proxy' && (
+                                <>
+                                    <div className="email-form">
+                                        <input type="number" min={1} max={65535} placeholder="10809" value={proxyPort}
+                                            onChange={e => setProxyPort(e.target.value)} />
+                                        <button className="btn-outline" onClick={() => updateSetting('proxyPort', Number(proxyPort) || 0)}>Save Port</button>
+                                    </div>
+                                    <div className="email-form">
+                                        <input type="number" min={1} max={65535} placeholder="DNS port (off)" value={proxyDnsPort}
+                                            onChange={e => setProxyDnsPort(e.target.value)} />
+                                        <button className="btn-outline" onClick={() => updateSetting('proxyDnsPort', Number(proxyDnsPort) || 0)}>Save DNS Port</button>
+                                    </div>
+                                    <div className="account-row">
+                                        <span>Use as system proxy</span>
+                                        <label className="toggle">
+                                            <input type="checkbox" checked={!!settings?.systemProxy}
+                                                onChange={e => updateSetting('systemProxy', e.target.checked)} />
+                                            <span className="slider"></span>
+                                        </label>
+                                    </div>
+                                </>
+                            )}
+                            {settings?.mode !== 'proxy' && (
+                                <div className="account-row">
+                                    <span>Adapter buffer size</span>
+                                    <select value={settings?.tunRingSize || 4 << 20} onChange={e => updateSetting('tunRingSize', Number(e.target.value))}>
+                                        {[1, 2, 4, 8, 16, 32, 64].map(mb => (
+                                            <option key={mb} value={mb << 20}>{mb} MB{mb === 4 ? ' (default)' : ''}</option>
+                                        ))}
+                                    </select>
+                                </div>
+                            )}
+                            <p style={{ fontSize: '0.8rem', color: '#888', marginTop: '1rem' }}>
+                                System VPN sends all traffic through the tunnel and needs the helper service or administrator rights.
+                                Proxy only runs an HTTP proxy on 127.0.0.1:{settings?.proxyPort || 10809} instead, for computers
+                                where the VPN driver can't be installed; only apps that use the proxy are protected, and the
+                                kill switch and split tunneling settings don't apply. With a DNS port, DNS is also answered on
+                                127.0.0.1 through the tunnel, for apps that let you set a DNS server. A larger adapter buffer
+                                helps on fast connections. Changes apply on the next connection.
+                            </p>
+                        </div>
+
+                        <div className="account-card">
+                            <h3>Server Subscription</h3>
+                            <div className="email-form">
+                                <input type="text" placeholder="https://panel.example.com/sub/..." value={serverSubURL}
+                                    onChange={e => setServerSubURL(e.target.value)} />
+                                <button className="btn-outline" disabled={serverSubBusy}
+                                    onClick={() => handleServerSub(() => SetServerSubscription(serverSubURL))}>Save</button>
+                                {serverSub?.url && (
+                                    <button className="btn-outline" disabled={serverSubBusy}
+                                        onClick={() => handleServerSub(RefreshServerSubscription)}>
+                                        {serverSubBusy ? 'Updating...' : 'Update Now'}
+                                    </button>
+                                )}
+                            </div>
+                            {serverSub?.url && (
+                                <div className="account-row">
+                                    <span>{(serverSub.servers || []).length} servers</span>
+                                    <span style={{ color: serverSub.error ? '#ff4444' : '#888' }}>
+                                        {serverSub.error || (serverSub.updatedAt && new Date(serverSub.updatedAt).getFullYear() > 1
+                                            ? `Updated ${new Date(serverSub.updatedAt).toLocaleString()}` : 'Not updated yet')}
+                                    </span>
+                                </div>
+                            )}
+                            <p style={{ fontSize: '0.8rem', color: '#888', marginTop: '1rem' }}>
+                                Paste a subscription link from your panel to add its servers next to ours. It is updated
+                                every few hours; servers removed from it disappear here too. Save an empty link to remove it.
+                            </p>
+                        </div>
+
+                        <div className="account-card">
+                            <h3>Startup</h3>
+                            <div className="account-row">
+                                <span>Launch at login</span>
+                                <label className="toggle">
+                                    <input type="checkbox" checked={!!settings?.launchAtLogin}
+                                        onChange={e => handleStartupSetting(SetLaunchAtLogin, e.target.checked)} />
+                                    <span className="slider"></span>
+                                </label>
+                            </div>
+                            <div className="account-row">
+                                <span>Connect to the last server on launch</span>
+                                <label className="toggle">
+                                    <input type="checkbox" checked={!!settings?.autoConnect}
+                                        onChange={e => handleStartupSetting(SetAutoConnect, e.target.checked)} />
+                                    <span className="slider"></span>
+                                </label>
+                            </div>
+                        </div>
+
+                        <div className="account-card">
+                            <h3>Trusted Networks</h3>
+                            <div className="account-row">
+                                <span>Connect on untrusted networks and disconnect on trusted ones</span>
+                                <label className="toggle">
+                                    <input type="checkbox" checked={!!networkRules.enabled}
+                                        onChange={e => updateSetting('networkRules', { ...networkRules, enabled: e.target.checked })} />
+                                    <span className="slider"></span>
+                                </label>
+                            </div>
+                            <div className="account-row">
+                                <span>Current network: {network?.id ? network.name : 'none'}</span>
+                                {network?.id && (
+                                    network.trusted
+                                        ? <button className="btn-outline" onClick={() => handleUntrustNetwork(network.id)}>Untrust</button>
+                                        : <button className="btn-outline" onClick={() => handleTrustNetwork(network)}>Trust</button>
+                                )}
+                            </div>
+                            {(networkRules.trusted || []).filter((t: any) => t.id !== network?.id).map((t: any) => (
+                                <div className="account-row" key={t.id}>
+                                    <span>{t.name}</span>
+                                    <button className="btn-outline" onClick={() => handleUntrustNetwork(t.id)}>Remove</button>
+                                </div>
+                            ))}
+                            <p style={{ fontSize: '0.8rem', color: '#888', marginTop: '1rem' }}>
+                                Wi-Fi networks are told apart by name, wired ones by their router. The VPN connects to
+                                the last server when you join a network that isn't trusted. You can still connect or
+                                disconnect by hand until you change networks.
+                            </p>
+                        </div>
+
+                        <div className="account-card">
+                            <h3>Helper Service</h3>
+                            <div className="account-row">
+                                <span>{helper?.installed ? 'Installed' : helper?.elevated ? 'Not installed (running as administrator)' : 'Not installed'}</span>
+                                <button className="btn-outline" disabled={helperBusy} onClick={() => handleHelper(!helper?.installed)}>
+                                    {helperBusy ? 'Waiting...' : helper?.installed ? 'Uninstall' : 'Install'}
+                                </button>
+                            </div>
+                            <p style={{ fontSize: '0.8rem', color: '#888', marginTop: '1rem' }}>
+                                The helper service sets up the VPN adapter, routes and firewall rules, so the app doesn't
+                                need to run as administrator. Installing it asks for administrator rights once.
+                            </p>
+                        </div>
+
+                        <div className="account-card">
+                            <h3>Kill Switch</h3>
+                            <div className="account-row">
+                                <span>Block internet if the VPN drops</span>
+                                <label className="toggle">
+                                    <input type="checkbox" checked={settings?.killSwitch || false}
+                                        onChange={e => updateSetting('killSwitch', e.target.checked)} />
+                                    <span className="slider"></span>
+                                </label>
+                            </div>
+                            <div className="account-row">
+                                <span>Allow local network (printers, router)</span>
+                                <label className="toggle">
+                                    <input type="checkbox" checked={settings?.killSwitchAllowLan || false}
+                                        disabled={!settings?.killSwitch}
+                                        onChange={e => updateSetting('killSwitchAllowLan', e.target.checked)} />
+                                    <span className="slider"></span>
+                                </label>
+                            </div>
+                            <p style={{ fontSize: '0.8rem', color: '#888', marginTop: '1rem' }}>
+                                While connected, all traffic outside the tunnel is blocked by Windows Firewall,
+                                so nothing leaks if the connection dies. Disconnect to get normal access back.
+                            </p>
+                        </div>
+
+                        <div className="account-card">
+                            <h3>DNS</h3>
+                            <div className="email-form">
+                                <input type="text" placeholder="1.1.1.1" value={dnsServer} onChange={e => setDnsServer(e.target.value)} />
+                                <button className="btn-outline" onClick={() => updateSetting('dns', dnsServer.trim())}>Save</button>
+                            </div>
+                            <p style={{ fontSize: '0.8rem', color: '#888', marginTop: '1rem' }}>
+                                While connected, DNS queries go through the tunnel to this server and queries to any
+                                other server are blocked, so your provider can't see which sites you look up. Public resolvers
+                                like 1.1.1.1, 8.8.8.8 and 9.9.9.9 are queried over HTTPS. Changes apply on the next connection.
+                            </p>
+                        </div>
+
+                        <div className="account-card">
+                            <h3>IPv6</h3>
+                            <div className="account-row">
+                                <span>Send IPv6 through the VPN</span>
+                                <label className="toggle">
+                                    <input type="checkbox" checked={settings?.ipv6 === 'tunnel'}
+                                        onChange={e => updateSetting('ipv6', e.target.checked ? 'tunnel' : 'block')} />
+                                    <span className="slider"></span>
+                                </label>
+                            </div>
+                            <p style={{ fontSize: '0.8rem', color: '#888', marginTop: '1rem' }}>
+                                When off, IPv6 is blocked while connected so it can't leak past the tunnel, and apps use
+                                IPv4 instead. Turn it on only if your server has IPv6. Changes apply on the next connection.
+                            </p>
+                        </div>
+
+                        <div className="account-card">
+                            <h3>Split Tunneling</h3>
+                            <div className="split-form">
+                                <label>Only through VPN (empty for all traffic)</label>
+                                <textarea rows={4} placeholder={'example.com\n203.0.113.0/24'} value={splitInclude}
+                                    onChange={e => setSplitInclude(e.target.value)} />
+                                <label>Never through VPN</label>
+                                <textarea rows={4} placeholder={'bank.ru\n192.0.2.10'} value={splitExclude}
+                                    onChange={e => setSplitExclude(e.target.value)} />
+                                <button className="btn-outline" onClick={handleSaveSplitTunnel}>Save Rules</button>
+                            </div>
+                            <div className="account-row">
+                                <span>Keep the local network outside the VPN</span>
+                                <label className="toggle">
+                                    <input type="checkbox" checked={!settings?.splitTunnel?.tunnelLan}
+                                        onChange={e => updateSetting('splitTunnel', { ...settings?.splitTunnel, tunnelLan: !e.target.checked })} />
+                                    <span className="slider"></span>
+                                </label>
+                            </div>
+                            <p style={{ fontSize: '0.8rem', color: '#888', marginTop: '1rem' }}>
+                                One IP, CIDR or domain per line. Domains are resolved again every few minutes
+                                while connected. The local network (private, link-local and multicast addresses) stays
+                                reachable for printers, NAS and casting devices unless you turn it off. Changes apply to an
+                                active connection right away.
+                            </p>
+                        </div>
+
+                        <div className="account-card">
+                            <h3>Speed Test</h3>
+                            <div className="account-row">
+                                <span>Measure speed through the VPN</span>
+                                <button className="btn-outline" disabled={!!speedTestPhase || !connected} onClick={handleRunSpeedTest}>
+                                    {speedTestPhase ? `Testing ${speedTestPhase}...` : 'Run'}
+                                </button>
+                            </div>
+                            {speedTest && (
+                                <>
+                                    <div className="account-row"><span>Latency</span><span>{speedTest.latencyMs.toFixed(0)} ms (jitter {speedTest.jitterMs.toFixed(1)} ms)</span></div>
+                                    <div className="account-row"><span>Download</span><span>{speedTest.downloadMbps.toFixed(1)} Mbps</span></div>
+                                    <div className="account-row"><span>Upload</span><span>{speedTest.uploadMbps.toFixed(1)} Mbps</span></div>
+                                </>
+                            )}
+                            <div className="email-form">
+                                <input type="text" placeholder="https://speed.cloudflare.com" value={speedTestURL}
+                                    onChange={e => setSpeedTestURL(e.target.value)} />
+                                <button className="btn-outline" onClick={() => updateSetting('speedTestUrl', speedTestURL.trim())}>Save Endpoint</button>
+                            </div>
+                            <p style={{ fontSize: '0.8rem', color: '#888', marginTop: '1rem' }}>
+                                The test runs through the VPN only, so it shows the speed of the current server. The endpoint
+                                must answer like speed.cloudflare.com.
+                            </p>
+                        </div>
+
+                        <div className="account-card">
+                            <h3>Diagnostics</h3>
+                            <div className="account-row">
+                                <span>Test connectivity and look for leaks</span>
+                                <button className="btn-outline" disabled={diagnosing} onClick={handleRunDiagnostics}>
+                                    {diagnosing ? 'Running...' : 'Run'}
+                                </button>
+                            </div>
+                            {diagnostics && (
+                                <>
+                                    {(diagnostics.problems || []).length === 0 ? (
+                                        <div className="account-row"><span style={{ color: '#00ff88' }}>No problems found</span></div>
+                                    ) : diagnostics.problems.map((p: string) => (
+                                        <div className="account-row" key={p}><span style={{ color: '#ff4444' }}>{p}</span></div>
+                                    ))}
+                                    <div className="account-row">
+                                        <span>Public IP</span>
+                                        <span>{diagnostics.systemIp || '—'}{diagnostics.tunnelIp ? ` (VPN: ${diagnostics.tunnelIp})` : ''}</span>
+                                    </div>
+                                    <div className="account-row">
+                                        <span>DNS answered by</span>
+                                        <span>{diagnostics.resolverIp || '—'}</span>
+                                    </div>
+                                    <table className="payment-table">
+                                        <thead>
+                                            <tr><th>Check</th><th>Path</th><th>Time</th><th>Result</th></tr>
+                                        </thead>
+                                        <tbody>
+                                            {diagnostics.checks.map((c: any) => (
+                                                <tr key={c.path + c.protocol} title={c.error || ''}>
+                                                    <td>{c.name}</td>
+                                                    <td>{c.path}</td>
+                                                    <td>{c.durationMs} ms</td>
+                                                    <td><span className={`status-badge ${c.ok ? 'succeeded' : 'canceled'}`}>{c.ok ? 'ok' : 'failed'}</span></td>
+                                                </tr>
+                                            ))}
+                                        </tbody>
+                                    </table>
+                                    <button className="btn-outline" style={{ marginTop: '1rem' }}
+                                        onClick={() => ClipboardSetText(JSON.stringify(diagnostics, null, 2))}>
+                                        Copy Report
+                                    </button>
+                                </>
+                            )}
+                        </div>
+
+                        <div className="account-card">
+                            <h3>Updates</h3>
+                            <div className="account-row">
+                                <span>Version {update?.currentVersion}</span>
+                                <button className="btn-outline" disabled={checkingUpdate} onClick={handleCheckForUpdate}>
+                                    {checkingUpdate ? 'Checking...' : 'Check Now'}
+                                </button>
+                            </div>
+                            {update?.checkedAt && new Date(update.checkedAt).getTime() > 0 && (
+                                <div className="account-row">
+                                    <span>{update.error ? `Check failed: ${update.error}` : update.available ? `Version ${update.version} is available` : 'Up to date'}</span>
+                                    <span>{new Date(update.checkedAt).toLocaleString()}</span>
+                                </div>
+                            )}
+                            <p style={{ fontSize: '0.8rem', color: '#888', marginTop: '1rem' }}>
+                                Updates are checked daily. They are signed, and the installer is verified before it runs.
+                            </p>
+                        </div>
+
+                        <div className="account-card">
+                            <h3>Connection Events</h3>
+                            <div className="account-row">
+                                <span>Connection attempts, route changes and errors</span>
+                                <button className="btn-outline" onClick={handleShowEvents}>
+                                    {events ? 'Refresh' : 'Show'}
+                                </button>
+                            </div>
+                            {events && (
+                                events.length === 0 ? (
+                                    <div className="account-row"><span>Nothing logged yet</span></div>
+                                ) : (
+                                    <div className="xray-logs">
+                                        {events.map((e, i) => (
+                                            <div key={i} className={e.code ? 'log-error' : ''}>
+                                                {new Date(e.time).toLocaleTimeString()} {e.message}{e.code ? ` [${e.code}]` : ''}
+                                            </div>
+                                        ))}
+                                    </div>
+                                )
+                            )}
+                            <div className="account-row">
+                                <span>Logs, settings and a diagnostics report for support</span>
+                                <button className="btn-outline" disabled={exporting} onClick={handleExportSupportBundle}>
+                                    {exporting ? 'Exporting...' : 'Export Support Bundle'}
+                                </button>
+                            </div>
+                            <p style={{ fontSize: '0.8rem', color: '#888', marginTop: '1rem' }}>
+                                The bundle has no passwords, session or server keys. Exporting runs the diagnostics, which
+                                takes a few seconds.
+                            </p>
+                        </div>
+
+                        <div className="account-card">
+                            <h3>Xray Logs</h3>
+                            <div className="account-row">
+                                <span>Output of xray-core, used for VLESS servers</span>
+                                <button className="btn-outline" onClick={handleShowXrayLogs}>
+                                    {xrayLogs ? 'Refresh' : 'Show'}
+                                </button>
+                            </div>
+                            <div className="account-row">
+                                <span>Save to xray.log</span>
+                                <label className="toggle">
+                                    <input type="checkbox" checked={!!settings?.xrayLogFile}
+                                        onChange={e => updateSetting('xrayLogFile', e.target.checked)} />
+                                    <span className="slider"></span>
+                                </label>
+                            </div>
+                            {xrayLogs && (
+                                xrayLogs.length === 0 ? (
+                                    <div className="account-row"><span>Nothing logged yet</span></div>
+                                ) : (
+                                    <>
+                                        <div className="xray-logs">
+                                            {xrayLogs.map((l, i) => (
+                                                <div key={i} className={`log-${l.level}`}>
+                                                    {new Date(l.time).toLocaleTimeString()} [{l.level}] {l.message}
+                                                </div>
+                                            ))}
+                                        </div>
+                                        <button className="btn-outline" style={{ marginTop: '1rem' }}
+                                            onClick={() => ClipboardSetText(xrayLogs.map(l => `${l.time} [${l.level}] ${l.message}`).join('\n'))}>
+                                            Copy Logs
+                                        </button>
+                                    </>
+                                )
+                            )}
+                            <p style={{ fontSize: '0.8rem', color: '#888', marginTop: '1rem' }}>
+                                The last 500 lines are kept while the app runs. The log file is in the app's
+                                settings folder and is rotated at 1 MB.
+                            </p>
+                        </div>
+                    </div>
+                )}
             </main>
         </div>
     );
@@ -1,6 +1,6 @@
 // @ts-nocheck
-import { useState } from 'react';
-import { Login, Register } from "../wailsjs/go/main/App";
+import { useState, useEffect } from 'react';
+import { Login, Register, GetProfiles, SwitchProfile } from "../wailsjs/go/main/App";
 
 interface AuthProps {
     onLogin: (user: any) => void;
@@ -12,6 +12,23 @@ export function Auth({ onLogin }: AuthProps) {
     const [password, setPassword] = useState('');
     const [error, setError] = useState('');
     const [loading, setLoading] = useState(false);
+    const [profiles, setProfiles] = useState<any[]>([]);
+
+    useEffect(() => {
+        GetProfiles().then(p => setProfiles(p || []));
+    }, []);
+
+    const handleSwitch = async (id: string) => {
+        setError('');
+        setLoading(true);
+        try {
+            onLogin(await SwitchProfile(id));
+        } catch (err: any) {
+            setError(err || "Failed to switch account");
+            GetProfiles().then(p => setProfiles(p || []));
+        }
+        setLoading(false);
+    };
 
     const handleSubmit = async (e: React.FormEvent) => {
         e.preventDefault();
@@ -44,6 +61,17 @@ export function Auth({ onLogin }: AuthProps) {
             }}>
                 <h1 style={{ color: '#00d7ff', marginBottom: '2rem', letterSpacing: '2px' }}>DR. FRAKE</h1>
 
+                {profiles.length > 0 && (
+                    <div style={{ display: 'flex', flexDirection: 'column', gap: '0.5rem', marginBottom: '1.5rem' }}>
+                        <div style={{ fontSize: '0.85rem', color: '#aaa' }}>Saved accounts</div>
+                        {profiles.map(p => (
+                            <button key={p.id} className="btn-outline" disabled={loading} onClick={() => handleSwitch(p.id)}>
+                                {p.email}
+                            </button>
+                        ))}
+                    </div>
+                )}
+
                 <form onSubmit={handleSubmit} style={{ display: 'flex', flexDirection: 'column', gap: '1rem' }}>
                     <input
                         type="email"
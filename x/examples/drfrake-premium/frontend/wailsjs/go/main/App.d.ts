@@ -1,28 +1,87 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
+import {core} from '../models';
 import {main} from '../models';
 
+export function AcceptFamilyInvite(arg1:string):Promise<void>;
+
+export function AddProfile():Promise<void>;
+
 export function CancelAutoRenew():Promise<void>;
 
+export function CancelFamilyInvite(arg1:string):Promise<void>;
+
+export function CancelNextPlan():Promise<void>;
+
+export function ChangeEmail(arg1:string,arg2:string):Promise<void>;
+
+export function CheckForUpdate():Promise<main.UpdateStatus>;
+
 export function CheckPayment(arg1:string):Promise<string>;
 
 export function Connect(arg1:string,arg2:string):Promise<void>;
 
+export function ConnectFastest():Promise<main.Server>;
+
 export function Disconnect():Promise<void>;
 
 export function EnableAutoRenew():Promise<void>;
 
+export function ExportSupportBundle():Promise<string>;
+
+export function FavoriteServer(arg1:string):Promise<void>;
+
+export function GetAccount():Promise<core.Account>;
+
+export function GetConnectionStatus():Promise<main.ConnectionStatus>;
+
+export function GetCurrentNetwork():Promise<main.Network>;
+
 export function GetCurrentUser():Promise<main.User>;
 
+export function GetDevices():Promise<Array<core.Device>>;
+
+export function GetEvents():Promise<Array<main.Event>>;
+
+export function GetFamily():Promise<core.Family>;
+
+export function GetHelperStatus():Promise<main.HelperStatus>;
+
 export function GetPaymentHistory():Promise<Array<main.PaymentRecord>>;
 
 export function GetPaymentMethod():Promise<main.PaymentMethod>;
 
+export function GetProfiles():Promise<Array<main.Profile>>;
+
+export function GetRecentServers():Promise<Array<main.Server>>;
+
+export function GetServerListStatus():Promise<main.ServerListStatus>;
+
+export function GetServerSubscription():Promise<main.ServerSubscriptionStatus>;
+
+export function GetServerTags():Promise<Array<string>>;
+
 export function GetServers():Promise<Array<main.Server>>;
 
+export function GetSettings():Promise<main.Settings>;
+
+export function GetStats():Promise<main.ConnectionStats>;
+
 export function GetSubscription():Promise<main.Subscription>;
 
-export function InitPayment(arg1:string):Promise<main.APIPaymentResponse>;
+export function GetUpdateStatus():Promise<main.UpdateStatus>;
+
+export function GetXrayLogs():Promise<Array<main.XrayLogEntry>>;
+
+export function GroupServersByTag(arg1:main.ServerQuery):Promise<Array<main.ServerGroup>>;
+
+export function InitPayment(arg1:string):Promise<core.Payment>;
+
+export function InstallHelper():Promise<void>;
+
+export function InstallUpdate():Promise<void>;
+
+export function InviteToFamily(arg1:string):Promise<void>;
 
 export function IsConnected():Promise<boolean>;
 
@@ -30,6 +89,44 @@ export function Login(arg1:string,arg2:string):Promise<main.User>;
 
 export function Logout():Promise<void>;
 
+export function Pause(arg1:number):Promise<void>;
+
+export function PauseSubscription(arg1:number):Promise<void>;
+
+export function RefreshServerSubscription():Promise<void>;
+
 export function Register(arg1:string,arg2:string):Promise<main.User>;
 
+export function RemoveFamilyMember(arg1:string):Promise<void>;
+
+export function RenameDevice(arg1:string,arg2:string):Promise<void>;
+
+export function Resume():Promise<void>;
+
+export function ResumeSubscription():Promise<void>;
+
+export function RevokeDevice(arg1:string):Promise<void>;
+
+export function RunDiagnostics():Promise<main.DiagnosticsReport>;
+
+export function RunSpeedTest():Promise<main.SpeedTestResult>;
+
+export function SaveInvoice(arg1:string,arg2:string):Promise<string>;
+
 export function SavePaymentMethod(arg1:string,arg2:string,arg3:string):Promise<void>;
+
+export function SaveSettings(arg1:main.Settings):Promise<void>;
+
+export function SearchServers(arg1:main.ServerQuery):Promise<Array<main.Server>>;
+
+export function SetAutoConnect(arg1:boolean):Promise<void>;
+
+export function SetLaunchAtLogin(arg1:boolean):Promise<void>;
+
+export function SetServerSubscription(arg1:string):Promise<void>;
+
+export function SwitchProfile(arg1:string):Promise<main.User>;
+
+export function UnfavoriteServer(arg1:string):Promise<void>;
+
+export function UninstallHelper():Promise<void>;
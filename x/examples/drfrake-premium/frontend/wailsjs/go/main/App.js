@@ -2,10 +2,34 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function AcceptFamilyInvite(arg1) {
+  return window['go']['main']['App']['AcceptFamilyInvite'](arg1);
+}
+
+export function AddProfile() {
+  return window['go']['main']['App']['AddProfile']();
+}
+
 export function CancelAutoRenew() {
   return window['go']['main']['App']['CancelAutoRenew']();
 }
 
+export function CancelFamilyInvite(arg1) {
+  return window['go']['main']['App']['CancelFamilyInvite'](arg1);
+}
+
+export function CancelNextPlan() {
+  return window['go']['main']['App']['CancelNextPlan']();
+}
+
+export function ChangeEmail(arg1, arg2) {
+  return window['go']['main']['App']['ChangeEmail'](arg1, arg2);
+}
+
+export function CheckForUpdate() {
+  return window['go']['main']['App']['CheckForUpdate']();
+}
+
 export function CheckPayment(arg1) {
   return window['go']['main']['App']['CheckPayment'](arg1);
 }
@@ -14,6 +38,10 @@ export function Connect(arg1, arg2) {
   return window['go']['main']['App']['Connect'](arg1, arg2);
 }
 
+export function ConnectFastest() {
+  return window['go']['main']['App']['ConnectFastest']();
+}
+
 export function Disconnect() {
   return window['go']['main']['App']['Disconnect']();
 }
@@ -22,10 +50,46 @@ export function EnableAutoRenew() {
   return window['go']['main']['App']['EnableAutoRenew']();
 }
 
+export function ExportSupportBundle() {
+  return window['go']['main']['App']['ExportSupportBundle']();
+}
+
+export function FavoriteServer(arg1) {
+  return window['go']['main']['App']['FavoriteServer'](arg1);
+}
+
+export function GetAccount() {
+  return window['go']['main']['App']['GetAccount']();
+}
+
+export function GetConnectionStatus() {
+  return window['go']['main']['App']['GetConnectionStatus']();
+}
+
+export function GetCurrentNetwork() {
+  return window['go']['main']['App']['GetCurrentNetwork']();
+}
+
 export function GetCurrentUser() {
   return window['go']['main']['App']['GetCurrentUser']();
 }
 
+export function GetDevices() {
+  return window['go']['main']['App']['GetDevices']();
+}
+
+export function GetEvents() {
+  return window['go']['main']['App']['GetEvents']();
+}
+
+export function GetFamily() {
+  return window['go']['main']['App']['GetFamily']();
+}
+
+export function GetHelperStatus() {
+  return window['go']['main']['App']['GetHelperStatus']();
+}
+
 export function GetPaymentHistory() {
   return window['go']['main']['App']['GetPaymentHistory']();
 }
@@ -34,18 +98,70 @@ export function GetPaymentMethod() {
   return window['go']['main']['App']['GetPaymentMethod']();
 }
 
+export function GetProfiles() {
+  return window['go']['main']['App']['GetProfiles']();
+}
+
+export function GetRecentServers() {
+  return window['go']['main']['App']['GetRecentServers']();
+}
+
+export function GetServerListStatus() {
+  return window['go']['main']['App']['GetServerListStatus']();
+}
+
+export function GetServerSubscription() {
+  return window['go']['main']['App']['GetServerSubscription']();
+}
+
+export function GetServerTags() {
+  return window['go']['main']['App']['GetServerTags']();
+}
+
 export function GetServers() {
   return window['go']['main']['App']['GetServers']();
 }
 
+export function GetSettings() {
+  return window['go']['main']['App']['GetSettings']();
+}
+
+export function GetStats() {
+  return window['go']['main']['App']['GetStats']();
+}
+
 export function GetSubscription() {
   return window['go']['main']['App']['GetSubscription']();
 }
 
+export function GetUpdateStatus() {
+  return window['go']['main']['App']['GetUpdateStatus']();
+}
+
+export function GetXrayLogs() {
+  return window['go']['main']['App']['GetXrayLogs']();
+}
+
+export function GroupServersByTag(arg1) {
+  return window['go']['main']['App']['GroupServersByTag'](arg1);
+}
+
 export function InitPayment(arg1) {
   return window['go']['main']['App']['InitPayment'](arg1);
 }
 
+export function InstallHelper() {
+  return window['go']['main']['App']['InstallHelper']();
+}
+
+export function InstallUpdate() {
+  return window['go']['main']['App']['InstallUpdate']();
+}
+
+export function InviteToFamily(arg1) {
+  return window['go']['main']['App']['InviteToFamily'](arg1);
+}
+
 export function IsConnected() {
   return window['go']['main']['App']['IsConnected']();
 }
@@ -58,10 +174,86 @@ export function Logout() {
   return window['go']['main']['App']['Logout']();
 }
 
+export function Pause(arg1) {
+  return window['go']['main']['App']['Pause'](arg1);
+}
+
+export function PauseSubscription(arg1) {
+  return window['go']['main']['App']['PauseSubscription'](arg1);
+}
+
+export function RefreshServerSubscription() {
+  return window['go']['main']['App']['RefreshServerSubscription']();
+}
+
 export function Register(arg1, arg2) {
   return window['go']['main']['App']['Register'](arg1, arg2);
 }
 
+export function RemoveFamilyMember(arg1) {
+  return window['go']['main']['App']['RemoveFamilyMember'](arg1);
+}
+
+export function RenameDevice(arg1, arg2) {
+  return window['go']['main']['App']['RenameDevice'](arg1, arg2);
+}
+
+export function Resume() {
+  return window['go']['main']['App']['Resume']();
+}
+
+export function ResumeSubscription() {
+  return window['go']['main']['App']['ResumeSubscription']();
+}
+
+export function RevokeDevice(arg1) {
+  return window['go']['main']['App']['RevokeDevice'](arg1);
+}
+
+export function RunDiagnostics() {
+  return window['go']['main']['App']['RunDiagnostics']();
+}
+
+export function RunSpeedTest() {
+  return window['go']['main']['App']['RunSpeedTest']();
+}
+
+export function SaveInvoice(arg1, arg2) {
+  return window['go']['main']['App']['SaveInvoice'](arg1, arg2);
+}
+
 export function SavePaymentMethod(arg1, arg2, arg3) {
   return window['go']['main']['App']['SavePaymentMethod'](arg1, arg2, arg3);
 }
+
+export function SaveSettings(arg1) {
+  return window['go']['main']['App']['SaveSettings'](arg1);
+}
+
+export function SearchServers(arg1) {
+  return window['go']['main']['App']['SearchServers'](arg1);
+}
+
+export function SetAutoConnect(arg1) {
+  return window['go']['main']['App']['SetAutoConnect'](arg1);
+}
+
+export function SetLaunchAtLogin(arg1) {
+  return window['go']['main']['App']['SetLaunchAtLogin'](arg1);
+}
+
+export function SetServerSubscription(arg1) {
+  return window['go']['main']['App']['SetServerSubscription'](arg1);
+}
+
+export function SwitchProfile(arg1) {
+  return window['go']['main']['App']['SwitchProfile'](arg1);
+}
+
+export function UnfavoriteServer(arg1) {
+  return window['go']['main']['App']['UnfavoriteServer'](arg1);
+}
+
+export function UninstallHelper() {
+  return window['go']['main']['App']['UninstallHelper']();
+}
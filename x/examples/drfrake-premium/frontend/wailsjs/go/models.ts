@@ -1,58 +1,760 @@
+export namespace core {
+	
+	export class Account {
+	    id: string;
+	    email: string;
+	    plan: string;
+	    // Go type: time
+	    expiry_date?: any;
+	    pending_email?: string;
+	    // Go type: time
+	    paused_until?: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new Account(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.email = source["email"];
+	        this.plan = source["plan"];
+	        this.expiry_date = this.convertValues(source["expiry_date"], null);
+	        this.pending_email = source["pending_email"];
+	        this.paused_until = this.convertValues(source["paused_until"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Device {
+	    id: string;
+	    name: string;
+	    // Go type: time
+	    created_at: any;
+	    // Go type: time
+	    last_seen_at?: any;
+	    keys?: number;
+	    current?: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new Device(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.name = source["name"];
+	        this.created_at = this.convertValues(source["created_at"], null);
+	        this.last_seen_at = this.convertValues(source["last_seen_at"], null);
+	        this.keys = source["keys"];
+	        this.current = source["current"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Family {
+	    seats: number;
+	    owner?: string;
+	    members: FamilyMember[];
+	    invites: FamilyInvite[];
+	
+	    static createFrom(source: any = {}) {
+	        return new Family(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.seats = source["seats"];
+	        this.owner = source["owner"];
+	        this.members = this.convertValues(source["members"], FamilyMember);
+	        this.invites = this.convertValues(source["invites"], FamilyInvite);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class FamilyInvite {
+	    id: string;
+	    email: string;
+	    // Go type: time
+	    created_at: any;
+	    // Go type: time
+	    expires_at: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new FamilyInvite(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.email = source["email"];
+	        this.created_at = this.convertValues(source["created_at"], null);
+	        this.expires_at = this.convertValues(source["expires_at"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class FamilyMember {
+	    id: string;
+	    email: string;
+	    // Go type: time
+	    joined_at: any;
+	    current: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new FamilyMember(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.email = source["email"];
+	        this.joined_at = this.convertValues(source["joined_at"], null);
+	        this.current = source["current"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Payment {
+	    id: string;
+	    status: string;
+	    confirmation_url: string;
+	    plan?: string;
+	    amount?: string;
+	    // Go type: time
+	    created_at?: any;
+	    region?: string;
+	    // Go type: time
+	    effective_at?: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new Payment(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.status = source["status"];
+	        this.confirmation_url = source["confirmation_url"];
+	        this.plan = source["plan"];
+	        this.amount = source["amount"];
+	        this.created_at = this.convertValues(source["created_at"], null);
+	        this.region = source["region"];
+	        this.effective_at = this.convertValues(source["effective_at"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
 export namespace main {
 	
-	export class APIPaymentResponse {
-	    id: string;
-	    status: string;
-	    confirmation_url: string;
+	export class ConnectionStats {
+	    bytesUp: number;
+	    bytesDown: number;
+	    upRate: number;
+	    downRate: number;
+	    connections: number;
+	    activeConnections: number;
+	    // Go type: time
+	    connectedSince?: any;
+	    durationSec: number;
+	    serverId?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ConnectionStats(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.bytesUp = source["bytesUp"];
+	        this.bytesDown = source["bytesDown"];
+	        this.upRate = source["upRate"];
+	        this.downRate = source["downRate"];
+	        this.connections = source["connections"];
+	        this.activeConnections = source["activeConnections"];
+	        this.connectedSince = this.convertValues(source["connectedSince"], null);
+	        this.durationSec = source["durationSec"];
+	        this.serverId = source["serverId"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class ConnectionStatus {
+	    state: string;
+	    serverId?: string;
+	    attempt?: number;
+	    error?: string;
+	    transport?: string;
+	    // Go type: time
+	    pausedUntil: any;
+	    udp?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ConnectionStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.state = source["state"];
+	        this.serverId = source["serverId"];
+	        this.attempt = source["attempt"];
+	        this.error = source["error"];
+	        this.transport = source["transport"];
+	        this.pausedUntil = this.convertValues(source["pausedUntil"], null);
+	        this.udp = source["udp"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class DiagnosticCheck {
+	    name: string;
+	    path: string;
+	    protocol: string;
+	    ok: boolean;
+	    durationMs: number;
+	    error?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new DiagnosticCheck(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.path = source["path"];
+	        this.protocol = source["protocol"];
+	        this.ok = source["ok"];
+	        this.durationMs = source["durationMs"];
+	        this.error = source["error"];
+	    }
+	}
+	export class DiagnosticsReport {
+	    // Go type: time
+	    time: any;
+	    state: string;
+	    serverId?: string;
+	    killSwitch: boolean;
+	    ipv6?: string;
+	    dns: string;
+	    checks: DiagnosticCheck[];
+	    systemIp?: string;
+	    tunnelIp?: string;
+	    resolverIp?: string;
+	    leakingResolvers?: string[];
+	    problems?: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new DiagnosticsReport(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.time = this.convertValues(source["time"], null);
+	        this.state = source["state"];
+	        this.serverId = source["serverId"];
+	        this.killSwitch = source["killSwitch"];
+	        this.ipv6 = source["ipv6"];
+	        this.dns = source["dns"];
+	        this.checks = this.convertValues(source["checks"], DiagnosticCheck);
+	        this.systemIp = source["systemIp"];
+	        this.tunnelIp = source["tunnelIp"];
+	        this.resolverIp = source["resolverIp"];
+	        this.leakingResolvers = source["leakingResolvers"];
+	        this.problems = source["problems"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Event {
+	    // Go type: time
+	    time: any;
+	    kind: string;
+	    message: string;
+	    code?: string;
+	    fields?: {[key: string]: string};
+	
+	    static createFrom(source: any = {}) {
+	        return new Event(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.time = this.convertValues(source["time"], null);
+	        this.kind = source["kind"];
+	        this.message = source["message"];
+	        this.code = source["code"];
+	        this.fields = source["fields"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class FastestProgress {
+	    phase: string;
+	    tested: number;
+	    total: number;
+	    serverId?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new FastestProgress(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.phase = source["phase"];
+	        this.tested = source["tested"];
+	        this.total = source["total"];
+	        this.serverId = source["serverId"];
+	    }
+	}
+	export class HelperStatus {
+	    installed: boolean;
+	    elevated: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new HelperStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.installed = source["installed"];
+	        this.elevated = source["elevated"];
+	    }
+	}
+	export class Network {
+	    id: string;
+	    name: string;
+	    trusted: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new Network(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.name = source["name"];
+	        this.trusted = source["trusted"];
+	    }
+	}
+	export class NetworkRules {
+	    enabled: boolean;
+	    trusted?: TrustedNetwork[];
+	
+	    static createFrom(source: any = {}) {
+	        return new NetworkRules(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.trusted = this.convertValues(source["trusted"], TrustedNetwork);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class PaymentMethod {
+	    title: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new PaymentMethod(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.title = source["title"];
+	    }
+	}
+	export class PaymentRecord {
+	    id: string;
+	    amount: number;
+	    plan: string;
+	    status: string;
+	    // Go type: time
+	    createdAt: any;
+	    description?: string;
+	    hasInvoice?: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new PaymentRecord(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.amount = source["amount"];
+	        this.plan = source["plan"];
+	        this.status = source["status"];
+	        this.createdAt = this.convertValues(source["createdAt"], null);
+	        this.description = source["description"];
+	        this.hasInvoice = source["hasInvoice"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Profile {
+	    id: string;
+	    email: string;
+	    active: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new Profile(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.email = source["email"];
+	        this.active = source["active"];
+	    }
+	}
+	export class Server {
+	    id: string;
+	    country: string;
+	    city: string;
+	    flag: string;
+	    config: string;
+	    fallbacks?: string[];
+	    isPremium: boolean;
+	    locked: boolean;
+	    tags?: string[];
+	    latency: number;
+	    isFavorite: boolean;
+	    source?: string;
+	    device?: core.Device;
+	
+	    static createFrom(source: any = {}) {
+	        return new Server(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.country = source["country"];
+	        this.city = source["city"];
+	        this.flag = source["flag"];
+	        this.config = source["config"];
+	        this.fallbacks = source["fallbacks"];
+	        this.isPremium = source["isPremium"];
+	        this.locked = source["locked"];
+	        this.tags = source["tags"];
+	        this.latency = source["latency"];
+	        this.isFavorite = source["isFavorite"];
+	        this.source = source["source"];
+	        this.device = this.convertValues(source["device"], core.Device);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class ServerGroup {
+	    tag: string;
+	    servers: Server[];
 	
 	    static createFrom(source: any = {}) {
-	        return new APIPaymentResponse(source);
+	        return new ServerGroup(source);
 	    }
 	
 	    constructor(source: any = {}) {
 	        if ('string' === typeof source) source = JSON.parse(source);
-	        this.id = source["id"];
-	        this.status = source["status"];
-	        this.confirmation_url = source["confirmation_url"];
+	        this.tag = source["tag"];
+	        this.servers = this.convertValues(source["servers"], Server);
 	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
 	}
-	export class PaymentMethod {
-	    cardLast4: string;
-	    cardBrand: string;
-	    cardExpiry: string;
+	export class ServerListStatus {
+	    cached: boolean;
+	    builtin: boolean;
+	    // Go type: time
+	    updatedAt: any;
 	
 	    static createFrom(source: any = {}) {
-	        return new PaymentMethod(source);
+	        return new ServerListStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.cached = source["cached"];
+	        this.builtin = source["builtin"];
+	        this.updatedAt = this.convertValues(source["updatedAt"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class ServerQuery {
+	    search: string;
+	    sort: string;
+	    tags: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new ServerQuery(source);
 	    }
 	
 	    constructor(source: any = {}) {
 	        if ('string' === typeof source) source = JSON.parse(source);
-	        this.cardLast4 = source["cardLast4"];
-	        this.cardBrand = source["cardBrand"];
-	        this.cardExpiry = source["cardExpiry"];
+	        this.search = source["search"];
+	        this.sort = source["sort"];
+	        this.tags = source["tags"];
 	    }
 	}
-	export class PaymentRecord {
-	    id: number;
-	    userId: string;
-	    amount: number;
-	    plan: string;
-	    status: string;
+	export class ServerSubscriptionStatus {
+	    url: string;
+	    servers: SubscriptionServer[];
 	    // Go type: time
-	    createdAt: any;
+	    updatedAt: any;
+	    error?: string;
 	
 	    static createFrom(source: any = {}) {
-	        return new PaymentRecord(source);
+	        return new ServerSubscriptionStatus(source);
 	    }
 	
 	    constructor(source: any = {}) {
 	        if ('string' === typeof source) source = JSON.parse(source);
-	        this.id = source["id"];
-	        this.userId = source["userId"];
-	        this.amount = source["amount"];
-	        this.plan = source["plan"];
-	        this.status = source["status"];
-	        this.createdAt = this.convertValues(source["createdAt"], null);
+	        this.url = source["url"];
+	        this.servers = this.convertValues(source["servers"], SubscriptionServer);
+	        this.updatedAt = this.convertValues(source["updatedAt"], null);
+	        this.error = source["error"];
 	    }
 	
 		convertValues(a: any, classs: any, asMap: boolean = false): any {
@@ -73,43 +775,142 @@ export namespace main {
 		    return a;
 		}
 	}
-	export class Server {
-	    id: string;
-	    country: string;
-	    city: string;
-	    flag: string;
-	    config: string;
-	    isPremium: boolean;
-	    latency: number;
+	export class Settings {
+	    killSwitch: boolean;
+	    killSwitchAllowLan: boolean;
+	    splitTunnel: SplitTunnel;
+	    dns?: string;
+	    ipv6?: string;
+	    xrayLogFile?: boolean;
+	    mode?: string;
+	    proxyPort?: number;
+	    proxyDnsPort?: number;
+	    systemProxy?: boolean;
+	    launchAtLogin?: boolean;
+	    autoConnect?: boolean;
+	    speedTestUrl?: string;
+	    networkRules: NetworkRules;
+	    tunRingSize?: number;
 	
 	    static createFrom(source: any = {}) {
-	        return new Server(source);
+	        return new Settings(source);
 	    }
 	
 	    constructor(source: any = {}) {
 	        if ('string' === typeof source) source = JSON.parse(source);
-	        this.id = source["id"];
-	        this.country = source["country"];
-	        this.city = source["city"];
-	        this.flag = source["flag"];
-	        this.config = source["config"];
-	        this.isPremium = source["isPremium"];
-	        this.latency = source["latency"];
+	        this.killSwitch = source["killSwitch"];
+	        this.killSwitchAllowLan = source["killSwitchAllowLan"];
+	        this.splitTunnel = this.convertValues(source["splitTunnel"], SplitTunnel);
+	        this.dns = source["dns"];
+	        this.ipv6 = source["ipv6"];
+	        this.xrayLogFile = source["xrayLogFile"];
+	        this.mode = source["mode"];
+	        this.proxyPort = source["proxyPort"];
+	        this.proxyDnsPort = source["proxyDnsPort"];
+	        this.systemProxy = source["systemProxy"];
+	        this.launchAtLogin = source["launchAtLogin"];
+	        this.autoConnect = source["autoConnect"];
+	        this.speedTestUrl = source["speedTestUrl"];
+	        this.networkRules = this.convertValues(source["networkRules"], NetworkRules);
+	        this.tunRingSize = source["tunRingSize"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class SpeedTestResult {
+	    // Go type: time
+	    time: any;
+	    serverId: string;
+	    endpoint: string;
+	    latencyMs: number;
+	    jitterMs: number;
+	    downloadMbps: number;
+	    uploadMbps: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new SpeedTestResult(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.time = this.convertValues(source["time"], null);
+	        this.serverId = source["serverId"];
+	        this.endpoint = source["endpoint"];
+	        this.latencyMs = source["latencyMs"];
+	        this.jitterMs = source["jitterMs"];
+	        this.downloadMbps = source["downloadMbps"];
+	        this.uploadMbps = source["uploadMbps"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class SplitTunnel {
+	    include: string[];
+	    exclude: string[];
+	    tunnelLan?: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new SplitTunnel(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.include = source["include"];
+	        this.exclude = source["exclude"];
+	        this.tunnelLan = source["tunnelLan"];
 	    }
 	}
 	export class Subscription {
-	    id: number;
-	    userId: string;
 	    plan: string;
 	    status: string;
 	    // Go type: time
-	    startDate: any;
+	    expiryDate?: any;
 	    // Go type: time
-	    expiryDate: any;
+	    graceUntil?: any;
 	    autoRenew: boolean;
-	    // Go type: time
-	    lastPayment: any;
+	    paymentMethod?: string;
 	    price: number;
+	    renewalFailures?: number;
+	    nextPlan?: string;
+	    // Go type: time
+	    pausedUntil?: any;
+	    pauseDaysLeft: number;
+	    familyOwner?: string;
+	    offline?: boolean;
+	    // Go type: time
+	    updatedAt: any;
 	
 	    static createFrom(source: any = {}) {
 	        return new Subscription(source);
@@ -117,15 +918,122 @@ export namespace main {
 	
 	    constructor(source: any = {}) {
 	        if ('string' === typeof source) source = JSON.parse(source);
-	        this.id = source["id"];
-	        this.userId = source["userId"];
 	        this.plan = source["plan"];
 	        this.status = source["status"];
-	        this.startDate = this.convertValues(source["startDate"], null);
 	        this.expiryDate = this.convertValues(source["expiryDate"], null);
+	        this.graceUntil = this.convertValues(source["graceUntil"], null);
 	        this.autoRenew = source["autoRenew"];
-	        this.lastPayment = this.convertValues(source["lastPayment"], null);
+	        this.paymentMethod = source["paymentMethod"];
 	        this.price = source["price"];
+	        this.renewalFailures = source["renewalFailures"];
+	        this.nextPlan = source["nextPlan"];
+	        this.pausedUntil = this.convertValues(source["pausedUntil"], null);
+	        this.pauseDaysLeft = source["pauseDaysLeft"];
+	        this.familyOwner = source["familyOwner"];
+	        this.offline = source["offline"];
+	        this.updatedAt = this.convertValues(source["updatedAt"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class SubscriptionServer {
+	    id: string;
+	    name: string;
+	    config: string;
+	    // Go type: time
+	    addedAt: any;
+	    // Go type: time
+	    updatedAt: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new SubscriptionServer(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.name = source["name"];
+	        this.config = source["config"];
+	        this.addedAt = this.convertValues(source["addedAt"], null);
+	        this.updatedAt = this.convertValues(source["updatedAt"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class TrustedNetwork {
+	    id: string;
+	    name: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new TrustedNetwork(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.name = source["name"];
+	    }
+	}
+	export class UpdateStatus {
+	    currentVersion: string;
+	    available: boolean;
+	    version?: string;
+	    notes?: string;
+	    // Go type: time
+	    publishedAt: any;
+	    critical: boolean;
+	    size?: number;
+	    // Go type: time
+	    checkedAt: any;
+	    error?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new UpdateStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.currentVersion = source["currentVersion"];
+	        this.available = source["available"];
+	        this.version = source["version"];
+	        this.notes = source["notes"];
+	        this.publishedAt = this.convertValues(source["publishedAt"], null);
+	        this.critical = source["critical"];
+	        this.size = source["size"];
+	        this.checkedAt = this.convertValues(source["checkedAt"], null);
+	        this.error = source["error"];
 	    }
 	
 		convertValues(a: any, classs: any, asMap: boolean = false): any {
@@ -182,5 +1090,40 @@ export namespace main {
 		}
 	}
 
+	export class XrayLogEntry {
+	    // Go type: time
+	    time: any;
+	    level: string;
+	    message: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new XrayLogEntry(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.time = this.convertValues(source["time"], null);
+	        this.level = source["level"];
+	        this.message = source["message"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 }
 
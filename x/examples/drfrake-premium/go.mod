@@ -1,14 +1,15 @@
 module drfrake-premium
 
-go 1.24.1
+go 1.25.0
 
 require (
+	drfrake-core v0.0.0-00010101000000-000000000000
+	github.com/Microsoft/go-winio v0.6.2
 	github.com/wailsapp/wails/v2 v2.11.0
 	golang.getoutline.org/sdk v0.0.21
-	golang.getoutline.org/sdk/x v0.0.0-00010101000000-000000000000
-	golang.org/x/sys v0.37.0
-	golang.zx2c4.com/wintun v0.0.0-20230126152724-0fa3db229ce2
-	modernc.org/sqlite v1.45.0
+	golang.getoutline.org/sdk/x v0.1.0
+	golang.org/x/sys v0.41.0
+	golang.zx2c4.com/wireguard/windows v0.5.3
 )
 
 require (
@@ -16,6 +17,7 @@ require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/eycorsican/go-tun2socks v1.16.11 // indirect
 	github.com/go-ole/go-ole v1.3.0 // indirect
+	github.com/goccy/go-yaml v1.17.1 // indirect
 	github.com/godbus/dbus/v5 v5.1.1-0.20230522191255-76236955d466 // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/gorilla/websocket v1.5.3 // indirect
@@ -35,18 +37,17 @@ require (
 	github.com/rivo/uniseg v0.4.7 // indirect
 	github.com/samber/lo v1.49.1 // indirect
 	github.com/shadowsocks/go-shadowsocks2 v0.1.5 // indirect
+	github.com/things-go/go-socks5 v0.0.5 // indirect
 	github.com/tkrajina/go-reflector v0.5.8 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
 	github.com/wailsapp/go-webview2 v1.0.22 // indirect
 	github.com/wailsapp/mimetype v1.4.1 // indirect
-	golang.org/x/crypto v0.42.0 // indirect
+	golang.org/x/crypto v0.48.0 // indirect
 	golang.org/x/exp v0.0.0-20251023183803-a4bb9ffd2546 // indirect
-	golang.org/x/net v0.44.0 // indirect
-	golang.org/x/text v0.29.0 // indirect
-	modernc.org/libc v1.67.6 // indirect
-	modernc.org/mathutil v1.7.1 // indirect
-	modernc.org/memory v1.11.0 // indirect
+	golang.org/x/net v0.50.0 // indirect
+	golang.org/x/text v0.34.0 // indirect
+	golang.zx2c4.com/wintun v0.0.0-20230126152724-0fa3db229ce2 // indirect
 )
 
 // replace github.com/wailsapp/wails/v2 v2.11.0 => C:\Users\PC\go\pkg\mod
@@ -54,3 +55,5 @@ require (
 replace golang.getoutline.org/sdk => ../../../
 
 replace golang.getoutline.org/sdk/x => ../../
+
+replace drfrake-core => ../../core
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// The helper service runs the privileged operations for the app, so the app
+// doesn't have to run elevated. It is the app's executable run by the
+// service manager with helperServiceArg and the SID of the user who
+// installed it, installed once with admin rights. The app talks to it over a
+// named pipe, with a control connection for requests and a packet connection
+// that relays the TUN's packets. Only that user, the system and
+// administrators can open the pipe, as whoever can reroutes all the traffic
+// of the machine.
+const (
+	helperServiceName = "DrFrakeVPNHelper"
+	helperPipe        = `\\.\pipe\DrFrakeVPNHelper`
+
+	helperServiceArg   = "--helper-service"
+	helperInstallArg   = "--install-helper"
+	helperUninstallArg = "--uninstall-helper"
+)
+
+// Kinds of helper connections, sent first on each.
+const (
+	helperControl = "control"
+	helperPackets = "packets"
+)
+
+type helperRequest struct {
+	Op string `json:"op"`
+	// Arguments of the ops that take any.
+	IP         string         `json:"ip,omitempty"`
+	ServerHost string         `json:"serverHost,omitempty"`
+	Routes     SplitRoutes    `json:"routes"`
+	TUNAddrs   []netip.Addr   `json:"tunAddrs,omitempty"`
+	Allowed    []netip.Prefix `json:"allowed,omitempty"`
+	AllowLAN   bool           `json:"allowLan,omitempty"`
+	RingSize   uint32         `json:"ringSize,omitempty"`
+}
+
+type helperResponse struct {
+	Error  string `json:"error,omitempty"`
+	Active bool   `json:"active,omitempty"` // Of killswitch.isActive
+}
+
+// runHelperCommand handles the helper's command line arguments. It returns
+// false if args are not for the helper, and the app should start.
+func runHelperCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	var err error
+	switch args[0] {
+	case helperServiceArg, helperInstallArg:
+		if len(args) < 2 {
+			err = errors.New("missing the SID of the app's user, reinstall the helper from the app")
+		} else if args[0] == helperServiceArg {
+			err = svc.Run(helperServiceName, &helperService{userSID: args[1]})
+		} else {
+			err = installHelper(args[1])
+		}
+	case helperUninstallArg:
+		err = uninstallHelper()
+	default:
+		return false
+	}
+	if err != nil {
+		log.Printf("[Helper] %v", err)
+		os.Exit(1)
+	}
+	return true
+}
+
+// helperPipeSDDL lets the system, administrators and the user with userSID
+// connect.
+func helperPipeSDDL(userSID string) string {
+	return "D:P(A;;GA;;;SY)(A;;GA;;;BA)(A;;GRGW;;;" + userSID + ")"
+}
+
+// currentUserSID returns the SID of the user the app runs as.
+func currentUserSID() (string, error) {
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return "", err
+	}
+	return user.User.Sid.String(), nil
+}
+
+var errHelperLocation = errors.New("the helper can only be installed from the app's folder in Program Files, install the app with its installer first")
+
+// helperExecutable returns the app's executable for the helper service, which
+// runs as LocalSystem. It must be in Program Files, where only administrators
+// can replace it.
+func helperExecutable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return "", err
+	}
+	for _, id := range []*windows.KNOWNFOLDERID{windows.FOLDERID_ProgramFiles, windows.FOLDERID_ProgramFilesX86} {
+		dir, err := windows.KnownFolderPath(id, 0)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(dir, exe); err == nil && filepath.IsLocal(rel) {
+			return exe, nil
+		}
+	}
+	return "", errHelperLocation
+}
+
+func installHelper(userSID string) error {
+	if _, err := windows.StringToSid(userSID); err != nil {
+		return fmt.Errorf("invalid user SID %q: %w", userSID, err)
+	}
+	exe, err := helperExecutable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(helperServiceName); err == nil {
+		// Installed already, maybe from another location. Reinstall.
+		s.Control(svc.Stop)
+		s.Delete()
+		s.Close()
+		time.Sleep(time.Second)
+	}
+	s, err := m.CreateService(helperServiceName, exe, mgr.Config{
+		DisplayName: "DrFrake VPN Helper",
+		Description: "Sets up the DrFrake VPN adapter, routes and firewall rules for the app.",
+		StartType:   mgr.StartAutomatic,
+	}, helperServiceArg, userSID)
+	if err != nil {
+		return fmt.Errorf("failed to install the helper service: %w", err)
+	}
+	defer s.Close()
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("failed to start the helper service: %w", err)
+	}
+	return nil
+}
+
+func uninstallHelper() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(helperServiceName)
+	if err != nil {
+		return nil
+	}
+	defer s.Close()
+	s.Control(svc.Stop)
+	return s.Delete()
+}
+
+// helperService is the helper run by the service manager.
+type helperService struct {
+	// userSID is the user who installed the helper, the only one besides
+	// administrators allowed to use it.
+	userSID string
+}
+
+func (h *helperService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	listener, err := winio.ListenPipe(helperPipe, &winio.PipeConfig{SecurityDescriptor: helperPipeSDDL(h.userSID)})
+	if err != nil {
+		log.Printf("[Helper] Failed to listen on %s: %v", helperPipe, err)
+		return true, 1
+	}
+	server := newHelperServer()
+	go server.serve(listener)
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for req := range requests {
+		switch req.Cmd {
+		case svc.Interrogate:
+			status <- req.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+			listener.Close()
+			server.closeTUN()
+			return false, 0
+		}
+	}
+	return false, 0
+}
+
+// helperServer serves the app. There is one TUN at a time, owned by the
+// control connection that created it.
+type helperServer struct {
+	mu         sync.Mutex
+	tun        *WindowsTUN
+	killSwitch *KillSwitch
+}
+
+func newHelperServer() *helperServer {
+	s := &helperServer{killSwitch: NewKillSwitch()}
+	// Lift what a session that ended while connected left behind.
+	s.killSwitch.Recover()
+	if err := RecoverTUN(); err != nil {
+		log.Printf("[Recovery] %v", err)
+	}
+	return s
+}
+
+func (s *helperServer) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *helperServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	kind, err := r.ReadString('\n')
+	if err != nil {
+		return
+	}
+	switch kind[:len(kind)-1] {
+	case helperControl:
+		s.handleControl(r, conn)
+	case helperPackets:
+		s.relayPackets(r, conn)
+	}
+}
+
+// handleControl answers requests until the app disconnects, then closes the
+// TUN, as the app is gone. The kill switch is left on, like when the app
+// crashes without the helper.
+func (s *helperServer) handleControl(r io.Reader, w io.Writer) {
+	dec := json.NewDecoder(r)
+	enc := json.NewEncoder(w)
+	for {
+		var req helperRequest
+		if err := dec.Decode(&req); err != nil {
+			s.closeTUN()
+			return
+		}
+		var resp helperResponse
+		active, err := s.do(&req)
+		resp.Active = active
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		if err := enc.Encode(&resp); err != nil {
+			s.closeTUN()
+			return
+		}
+	}
+}
+
+func (s *helperServer) do(req *helperRequest) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch req.Op {
+	case "tun.create":
+		if s.tun != nil {
+			s.tun.Close()
+			s.tun = nil
+		}
+		tun, err := NewWindowsTUN(req.RingSize)
+		if err != nil {
+			return false, err
+		}
+		s.tun = tun
+		return false, nil
+	case "killswitch.enable":
+		return false, s.killSwitch.Enable(req.TUNAddrs, req.Routes.Tunneled, req.Allowed, req.AllowLAN)
+	case "killswitch.disable":
+		return false, s.killSwitch.Disable()
+	case "killswitch.isActive":
+		return s.killSwitch.IsActive(), nil
+	case "killswitch.recover":
+		s.killSwitch.Recover()
+		return false, nil
+	case "tun.recover":
+		// The TUN is the app's own while it is open.
+		if s.tun != nil {
+			return false, nil
+		}
+		return false, RecoverTUN()
+	}
+
+	if s.tun == nil {
+		return false, errors.New("no TUN")
+	}
+	switch req.Op {
+	case "tun.configure":
+		return false, s.tun.Configure(req.IP)
+	case "tun.configureIPv6":
+		return false, s.tun.ConfigureIPv6(req.IP)
+	case "tun.setupRoutes":
+		return false, s.tun.SetupRoutes(req.ServerHost, req.IP, req.Routes)
+	case "tun.setSplitRoutes":
+		return false, s.tun.SetSplitRoutes(req.Routes)
+	case "tun.setupDNS":
+		return false, s.tun.SetupDNS(req.IP)
+	case "tun.close":
+		err := s.tun.Close()
+		s.tun = nil
+		return false, err
+	}
+	return false, fmt.Errorf("unknown op %q", req.Op)
+}
+
+func (s *helperServer) closeTUN() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tun != nil {
+		s.tun.Close()
+		s.tun = nil
+	}
+}
+
+// relayPackets copies packets between the current TUN and the app until
+// either is closed.
+func (s *helperServer) relayPackets(r *bufio.Reader, conn net.Conn) {
+	s.mu.Lock()
+	tun := s.tun
+	s.mu.Unlock()
+	if tun == nil {
+		return
+	}
+	go func() {
+		buf := make([]byte, mtu)
+		for {
+			n, err := readPacketFrame(r, buf)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			if _, err := tun.Write(buf[:n]); err != nil {
+				log.Printf("[Helper] TUN write: %v", err)
+			}
+		}
+	}()
+	buf := make([]byte, mtu)
+	for {
+		n, err := tun.Read(buf)
+		if err != nil {
+			if errors.Is(err, windows.ERROR_NO_MORE_ITEMS) {
+				continue
+			}
+			return
+		}
+		if err := writePacketFrame(conn, buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// Packets are framed by a 2-byte big-endian length, as the pipe is a byte
+// stream.
+
+func writePacketFrame(w io.Writer, packet []byte) error {
+	frame := make([]byte, 2+len(packet))
+	binary.BigEndian.PutUint16(frame, uint16(len(packet)))
+	copy(frame[2:], packet)
+	_, err := w.Write(frame)
+	return err
+}
+
+func readPacketFrame(r *bufio.Reader, buf []byte) (int, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, err
+	}
+	n := int(binary.BigEndian.Uint16(header[:]))
+	if n > len(buf) {
+		return 0, fmt.Errorf("packet of %d bytes is too big", n)
+	}
+	return io.ReadFull(r, buf[:n])
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// helperDialTimeout is how long to wait for the helper's pipe.
+const helperDialTimeout = 2 * time.Second
+
+// HelperClient runs the privileged operations in the helper service.
+type HelperClient struct {
+	mu   sync.Mutex
+	conn net.Conn
+	dec  *json.Decoder
+}
+
+// DialHelper connects to the helper service. It fails if the service isn't
+// installed or running.
+func DialHelper() (*HelperClient, error) {
+	conn, err := dialHelper(helperControl)
+	if err != nil {
+		return nil, err
+	}
+	return &HelperClient{conn: conn, dec: json.NewDecoder(conn)}, nil
+}
+
+func dialHelper(kind string) (net.Conn, error) {
+	timeout := helperDialTimeout
+	conn, err := winio.DialPipe(helperPipe, &timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the helper service: %w", err)
+	}
+	if _, err := io.WriteString(conn, kind+"\n"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to the helper service: %w", err)
+	}
+	return conn, nil
+}
+
+// call sends req and waits for its response.
+func (c *HelperClient) call(req helperRequest) (helperResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var resp helperResponse
+	if err := json.NewEncoder(c.conn).Encode(&req); err != nil {
+		return resp, fmt.Errorf("helper service: %w", err)
+	}
+	if err := c.dec.Decode(&resp); err != nil {
+		return resp, fmt.Errorf("helper service: %w", err)
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+func (c *HelperClient) do(req helperRequest) error {
+	_, err := c.call(req)
+	return err
+}
+
+// Close disconnects from the helper, which closes the TUN.
+func (c *HelperClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *HelperClient) NewTUN(ringSize uint32) (TUNDevice, error) {
+	if err := c.do(helperRequest{Op: "tun.create", RingSize: ringSize}); err != nil {
+		return nil, err
+	}
+	conn, err := dialHelper(helperPackets)
+	if err != nil {
+		c.do(helperRequest{Op: "tun.close"})
+		return nil, err
+	}
+	return &helperTUN{client: c, conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+func (c *HelperClient) KillSwitch() KillSwitcher {
+	return helperKillSwitch{c}
+}
+
+func (c *HelperClient) RecoverTUN() error {
+	return c.do(helperRequest{Op: "tun.recover"})
+}
+
+// helperTUN is the helper's TUN, with its packets relayed over a pipe.
+type helperTUN struct {
+	client *HelperClient
+	conn   net.Conn
+	r      *bufio.Reader
+	// writeMu keeps frames whole, as packets are written concurrently.
+	writeMu sync.Mutex
+}
+
+func (t *helperTUN) Read(p []byte) (int, error) {
+	return readPacketFrame(t.r, p)
+}
+
+func (t *helperTUN) Write(p []byte) (int, error) {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	if err := writePacketFrame(t.conn, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (t *helperTUN) Close() error {
+	t.conn.Close()
+	return t.client.do(helperRequest{Op: "tun.close"})
+}
+
+func (t *helperTUN) Configure(localIP string) error {
+	return t.client.do(helperRequest{Op: "tun.configure", IP: localIP})
+}
+
+func (t *helperTUN) ConfigureIPv6(localIP6 string) error {
+	return t.client.do(helperRequest{Op: "tun.configureIPv6", IP: localIP6})
+}
+
+func (t *helperTUN) SetupRoutes(serverHost, localTUNIP string, routes SplitRoutes) error {
+	return t.client.do(helperRequest{Op: "tun.setupRoutes", ServerHost: serverHost, IP: localTUNIP, Routes: routes})
+}
+
+func (t *helperTUN) SetSplitRoutes(routes SplitRoutes) error {
+	return t.client.do(helperRequest{Op: "tun.setSplitRoutes", Routes: routes})
+}
+
+func (t *helperTUN) SetupDNS(resolver string) error {
+	return t.client.do(helperRequest{Op: "tun.setupDNS", IP: resolver})
+}
+
+// helperKillSwitch is the helper's kill switch.
+type helperKillSwitch struct {
+	client *HelperClient
+}
+
+func (k helperKillSwitch) Enable(tunAddrs []netip.Addr, tunneled, allowed []netip.Prefix, allowLAN bool) error {
+	return k.client.do(helperRequest{
+		Op:       "killswitch.enable",
+		TUNAddrs: tunAddrs,
+		Routes:   SplitRoutes{Tunneled: tunneled},
+		Allowed:  allowed,
+		AllowLAN: allowLAN,
+	})
+}
+
+func (k helperKillSwitch) Disable() error {
+	return k.client.do(helperRequest{Op: "killswitch.disable"})
+}
+
+func (k helperKillSwitch) IsActive() bool {
+	resp, err := k.client.call(helperRequest{Op: "killswitch.isActive"})
+	return err == nil && resp.Active
+}
+
+func (k helperKillSwitch) Recover() {
+	k.client.do(helperRequest{Op: "killswitch.recover"})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+
+	"golang.getoutline.org/sdk/network"
+	"golang.getoutline.org/sdk/transport"
+)
+
+// IPv6Mode is what happens to IPv6 traffic while connected. Either way it
+// is routed into the tunnel, so it never leaves outside it.
+type IPv6Mode string
+
+const (
+	// IPv6Block fails IPv6 connections right away, so apps fall back to IPv4.
+	// It is the default, as few servers have IPv6.
+	IPv6Block IPv6Mode = "block"
+	// IPv6Tunnel carries IPv6 through the tunnel like IPv4.
+	IPv6Tunnel IPv6Mode = "tunnel"
+)
+
+var errIPv6Blocked = errors.New("IPv6 is blocked while connected")
+
+// blockIPv6 returns dialers that refuse IPv6 destinations.
+func blockIPv6(sd transport.StreamDialer, pp network.PacketProxy) (transport.StreamDialer, network.PacketProxy) {
+	blockingSD := transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		if isIPv6Destination(addr) {
+			return nil, errIPv6Blocked
+		}
+		return sd.DialStream(ctx, addr)
+	})
+	return blockingSD, &ipv4PacketProxy{next: pp}
+}
+
+// isIPv6Destination reports whether addr is an IPv6 address and port.
+func isIPv6Destination(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.Is6() && !ip.Is4In6()
+}
+
+// ipv4PacketProxy drops packets to IPv6 destinations.
+type ipv4PacketProxy struct {
+	next network.PacketProxy
+}
+
+func (p *ipv4PacketProxy) NewSession(respWriter network.PacketResponseReceiver) (network.PacketRequestSender, error) {
+	next, err := p.next.NewSession(respWriter)
+	if err != nil {
+		return nil, err
+	}
+	return &ipv4RequestSender{next}, nil
+}
+
+type ipv4RequestSender struct {
+	network.PacketRequestSender
+}
+
+func (s *ipv4RequestSender) WriteTo(p []byte, destination netip.AddrPort) (int, error) {
+	if ip := destination.Addr(); ip.Is6() && !ip.Is4In6() {
+		return 0, errIPv6Blocked
+	}
+	return s.PacketRequestSender.WriteTo(p, destination)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// killSwitchGroup is the Windows Firewall rule group of the kill switch, so
+// its rules can be removed together.
+const killSwitchGroup = "DrFrake VPN Kill Switch"
+
+// KillSwitch blocks all traffic that doesn't go through the tunnel or to the
+// VPN server with Windows Firewall rules. Block rules take precedence over
+// the allow rules of other apps, so nothing gets past them.
+//
+// The firewall profile state it changes is saved to a file, so the rules of a
+// session that never disconnected, e.g. because the app crashed, are removed
+// on the next start.
+type KillSwitch struct {
+	statePath string
+}
+
+type killSwitchState struct {
+	// Profiles maps firewall profiles to whether they were enabled.
+	Profiles map[string]string `json:"profiles"`
+}
+
+func NewKillSwitch() *KillSwitch {
+	return &KillSwitch{statePath: filepath.Join(GetConfigDir(), "killswitch.json")}
+}
+
+// IsActive reports whether the rules are installed.
+func (k *KillSwitch) IsActive() bool {
+	_, err := os.Stat(k.statePath)
+	return err == nil
+}
+
+// Enable installs the rules. Traffic to tunneled destinations is only let out
+// from the TUN's addresses. Allowed destinations, such as the VPN server and
+// split tunnel exclusions, and loopback are always reachable, and so is the
+// local network if allowLAN is set.
+func (k *KillSwitch) Enable(tunAddrs []netip.Addr, tunneled, allowed []netip.Prefix, allowLAN bool) error {
+
+	// Keep the state saved by a session that is still active, it has the
+	// profiles as they were before the kill switch.
+	if !k.IsActive() {
+		out, err := runPowerShell(`Get-NetFirewallProfile | ForEach-Object { "$($_.Name)=$($_.Enabled)" }`)
+		if err != nil {
+			return fmt.Errorf("failed to read firewall profiles: %v, output: %s", err, out)
+		}
+		state := killSwitchState{Profiles: make(map[string]string)}
+		for _, line := range strings.Split(out, "\n") {
+			if name, enabled, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+				state.Profiles[name] = enabled
+			}
+		}
+		data, _ := json.Marshal(state)
+		if err := os.WriteFile(k.statePath, data, 0600); err != nil {
+			return fmt.Errorf("failed to save firewall state: %w", err)
+		}
+	}
+
+	exempt := []string{"127.0.0.0/8", "255.255.255.255/32", "::1/128"} // Loopback and DHCP
+	if allowLAN {
+		exempt = append(exempt, "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16", "224.0.0.0/4",
+			"fc00::/7", "fe80::/10", "ff00::/8")
+	}
+	var exemptRanges, blocked []addrRange
+	for _, p := range exempt {
+		exemptRanges = append(exemptRanges, prefixRange(netip.MustParsePrefix(p)))
+	}
+	for _, p := range allowed {
+		exemptRanges = append(exemptRanges, prefixRange(p))
+	}
+	for _, p := range tunneled {
+		blocked = append(blocked, prefixRange(p))
+	}
+	blocked = subtractRanges(blocked, exemptRanges)
+	var blocked4, blocked6 []string
+	for _, r := range blocked {
+		if r.from.Is4() {
+			blocked4 = append(blocked4, r.String())
+		} else {
+			blocked6 = append(blocked6, r.String())
+		}
+	}
+
+	var rules strings.Builder
+	for _, family := range []struct {
+		name    string
+		all     string
+		blocked []string
+	}{
+		{"IPv4", "0.0.0.0/0", blocked4},
+		{"IPv6", "::/0", blocked6},
+	} {
+		if len(family.blocked) == 0 {
+			continue
+		}
+		var tunRanges []addrRange
+		for _, ip := range tunAddrs {
+			tunRanges = append(tunRanges, addrRange{ip, ip})
+		}
+		var local []string
+		for _, r := range subtractRanges([]addrRange{prefixRange(netip.MustParsePrefix(family.all))}, tunRanges) {
+			local = append(local, r.String())
+		}
+		fmt.Fprintf(&rules, "New-NetFirewallRule -Group $group -DisplayName \"$group (%s)\" -Direction Outbound -Action Block -LocalAddress @(%s) -RemoteAddress @(%s) | Out-Null;\n",
+			family.name, psList(local), psList(family.blocked))
+	}
+	psCmd := fmt.Sprintf(`
+		$ErrorActionPreference = "Stop";
+		$group = "%s";
+		Get-NetFirewallRule -Group $group -ErrorAction SilentlyContinue | Remove-NetFirewallRule;
+
+		# Rules only apply while the firewall is on.
+		Set-NetFirewallProfile -All -Enabled True;
+
+		%s
+	`, killSwitchGroup, rules.String())
+
+	log.Printf("[KillSwitch] Blocking traffic outside the tunnel, allowing %v (LAN allowed: %v)...", allowed, allowLAN)
+	if out, err := runPowerShell(psCmd); err != nil {
+		k.Disable()
+		return fmt.Errorf("failed to install kill switch rules: %v, output: %s", err, out)
+	}
+	log.Println("[KillSwitch] Enabled.")
+	return nil
+}
+
+// Disable removes the rules and restores the firewall profiles.
+func (k *KillSwitch) Disable() error {
+	var state killSwitchState
+	if data, err := os.ReadFile(k.statePath); err == nil {
+		json.Unmarshal(data, &state)
+	}
+
+	var restore strings.Builder
+	for name, enabled := range state.Profiles {
+		fmt.Fprintf(&restore, "Set-NetFirewallProfile -Name %s -Enabled %s;\n", psQuote(name), psQuote(enabled))
+	}
+	psCmd := fmt.Sprintf(`
+		$ErrorActionPreference = "Stop";
+		Get-NetFirewallRule -Group "%s" -ErrorAction SilentlyContinue | Remove-NetFirewallRule;
+		%s
+	`, killSwitchGroup, restore.String())
+
+	if out, err := runPowerShell(psCmd); err != nil {
+		return fmt.Errorf("failed to remove kill switch rules: %v, output: %s", err, out)
+	}
+	os.Remove(k.statePath)
+	log.Println("[KillSwitch] Disabled.")
+	return nil
+}
+
+// Recover removes rules left behind by a session that never disconnected.
+func (k *KillSwitch) Recover() {
+	if !k.IsActive() {
+		return
+	}
+	log.Println("[KillSwitch] Found rules of a previous session, removing them...")
+	if err := k.Disable(); err != nil {
+		log.Printf("[KillSwitch] %v", err)
+	}
+}
+
+func runPowerShell(script string) (string, error) {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	cmd.SysProcAttr = &windows.SysProcAttr{HideWindow: true}
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func psList(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = psQuote(item)
+	}
+	return strings.Join(quoted, ",")
+}
+
+// addrRange is an inclusive range of addresses of one family.
+type addrRange struct {
+	from, to netip.Addr
+}
+
+func prefixRange(p netip.Prefix) addrRange {
+	p = p.Masked()
+	last := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(last)*8; i++ {
+		last[i/8] |= 1 << (7 - i%8)
+	}
+	to, _ := netip.AddrFromSlice(last)
+	return addrRange{p.Addr(), to}
+}
+
+func (r addrRange) String() string {
+	if r.from == r.to {
+		return r.from.String()
+	}
+	return r.from.String() + "-" + r.to.String()
+}
+
+// subtractRanges returns the parts of ranges not covered by excluded. Ranges
+// of different families don't overlap.
+func subtractRanges(ranges, excluded []addrRange) []addrRange {
+	sort.Slice(excluded, func(i, j int) bool { return excluded[i].from.Less(excluded[j].from) })
+	var out []addrRange
+	for _, r := range ranges {
+		next, covered := r.from, false
+		for _, ex := range excluded {
+			if ex.to.Less(next) || r.to.Less(ex.from) {
+				continue
+			}
+			if next.Less(ex.from) {
+				out = append(out, addrRange{next, ex.from.Prev()})
+			}
+			if !ex.to.Less(r.to) {
+				covered = true
+				break
+			}
+			next = ex.to.Next()
+		}
+		if !covered {
+			out = append(out, addrRange{next, r.to})
+		}
+	}
+	return out
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile appends to a log file in the config directory, moving it to
+// path.1 once it reaches maxSize. It is opened on the first write.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	backups int // How many rotated files, path.1 and on, are kept
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil && f.size >= f.maxSize {
+		f.file.Close()
+		f.file = nil
+		rotateLogFile(f.path, f.backups)
+	}
+	if f.file == nil {
+		file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return 0, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return 0, err
+		}
+		f.file, f.size = file, info.Size()
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	return n, err
+}
+
+// Close closes the file. The next write opens it again.
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// files returns the log file and its rotated files that exist, newest first.
+func (f *rotatingFile) files() []string {
+	var files []string
+	for i := 0; i <= f.backups; i++ {
+		path := f.path
+		if i > 0 {
+			path = fmt.Sprintf("%s.%d", f.path, i)
+		}
+		if _, err := os.Stat(path); err == nil {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// rotateLogFile moves path to path.1, path.1 to path.2 and so on, dropping
+// the one past backups.
+func rotateLogFile(path string, backups int) {
+	os.Remove(fmt.Sprintf("%s.%d", path, backups))
+	for i := backups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+	os.Rename(path, path+".1")
+}
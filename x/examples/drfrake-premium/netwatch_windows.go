@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// networkSettleDelay is how long notifications are collected before the
+	// network is looked at, as one change raises many of them.
+	networkSettleDelay = 2 * time.Second
+	// resumeCheckInterval is how often the clock is checked for a jump,
+	// which means the computer slept.
+	resumeCheckInterval = 10 * time.Second
+)
+
+// networkKicks receives a value when Windows reports an interface or address
+// change. Callbacks can't be freed, so there is one for the process.
+var (
+	networkKicks    = make(chan struct{}, 1)
+	networkCallback = windows.NewCallback(func(callerContext, row uintptr, notificationType uint32) uintptr {
+		select {
+		case networkKicks <- struct{}{}:
+		default:
+		}
+		return 0
+	})
+)
+
+// NetworkMonitor reports when the physical network changes, such as moving
+// from Wi-Fi to Ethernet or waking from sleep. Changes of the VPN's own
+// adapter are ignored.
+type NetworkMonitor struct {
+	changes chan struct{}
+	handles []windows.Handle
+
+	mu          sync.Mutex
+	subscribers []chan struct{}
+}
+
+func NewNetworkMonitor() (*NetworkMonitor, error) {
+	m := &NetworkMonitor{changes: make(chan struct{}, 1)}
+	var h windows.Handle
+	if err := windows.NotifyIpInterfaceChange(windows.AF_UNSPEC, networkCallback, nil, false, &h); err != nil {
+		return nil, fmt.Errorf("failed to watch interfaces: %w", err)
+	}
+	m.handles = append(m.handles, h)
+	if err := windows.NotifyUnicastIpAddressChange(windows.AF_UNSPEC, networkCallback, nil, false, &h); err != nil {
+		m.Close()
+		return nil, fmt.Errorf("failed to watch addresses: %w", err)
+	}
+	m.handles = append(m.handles, h)
+	go m.run()
+	return m, nil
+}
+
+// Changes returns a channel that receives a value after the network changed.
+func (m *NetworkMonitor) Changes() <-chan struct{} {
+	return m.changes
+}
+
+// Subscribe returns a channel of its own that receives a value after the
+// network changed, for watching changes next to Changes.
+func (m *NetworkMonitor) Subscribe() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	m.subscribers = append(m.subscribers, ch)
+	return ch
+}
+
+// Close stops the notifications.
+func (m *NetworkMonitor) Close() {
+	for _, h := range m.handles {
+		windows.CancelMibChangeNotify2(h)
+	}
+	m.handles = nil
+}
+
+func (m *NetworkMonitor) run() {
+	last := networkFingerprint()
+	ticker := time.NewTicker(resumeCheckInterval)
+	defer ticker.Stop()
+	lastTick := time.Now().Round(0) // Wall clock, which keeps running in sleep
+	for {
+		select {
+		case <-networkKicks:
+			time.Sleep(networkSettleDelay)
+			select {
+			case <-networkKicks:
+			default:
+			}
+			current := networkFingerprint()
+			if current == last {
+				continue
+			}
+			log.Printf("[Network] Network changed: %q -> %q", last, current)
+			last = current
+		case now := <-ticker.C:
+			now = now.Round(0)
+			slept := now.Sub(lastTick) > 3*resumeCheckInterval
+			lastTick = now
+			if !slept {
+				continue
+			}
+			log.Println("[Network] Resumed from sleep.")
+			last = networkFingerprint()
+		}
+		m.mu.Lock()
+		for _, ch := range append(m.subscribers, m.changes) {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+// networkFingerprint describes the gateways of the adapters that are up,
+// other than the VPN's.
+func networkFingerprint() string {
+	var gateways []string
+	err := forEachPhysicalAdapter(windows.GAA_FLAG_INCLUDE_GATEWAYS, func(name string, aa *windows.IpAdapterAddresses) {
+		for gw := aa.FirstGatewayAddress; gw != nil; gw = gw.Next {
+			gateways = append(gateways, fmt.Sprintf("%s/%s", name, gw.Address.IP()))
+		}
+	})
+	if err != nil {
+		log.Printf("[Network] %v", err)
+		return ""
+	}
+	slices.Sort(gateways)
+	return strings.Join(gateways, ",")
+}
+
+// physicalResolvers returns the DNS servers of the adapters that are up,
+// other than the VPN's. Link-local IPv6 ones, which need a zone, are skipped.
+func physicalResolvers() []netip.Addr {
+	var resolvers []netip.Addr
+	err := forEachPhysicalAdapter(0, func(name string, aa *windows.IpAdapterAddresses) {
+		for dnsAddr := aa.FirstDnsServerAddress; dnsAddr != nil; dnsAddr = dnsAddr.Next {
+			ip, ok := netip.AddrFromSlice(dnsAddr.Address.IP())
+			ip = ip.Unmap()
+			if ok && !ip.IsLinkLocalUnicast() && !slices.Contains(resolvers, ip) {
+				resolvers = append(resolvers, ip)
+			}
+		}
+	})
+	if err != nil {
+		log.Printf("[Network] %v", err)
+	}
+	return resolvers
+}
+
+// forEachPhysicalAdapter calls fn with the adapters that are up, other than
+// the VPN's, as listed by GetAdaptersAddresses with flags.
+func forEachPhysicalAdapter(flags uint32, fn func(name string, aa *windows.IpAdapterAddresses)) error {
+	size := uint32(15000)
+	var buf []byte
+	for {
+		buf = make([]byte, size)
+		err := windows.GetAdaptersAddresses(windows.AF_UNSPEC, flags, 0,
+			(*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])), &size)
+		if err == nil {
+			break
+		}
+		if err != windows.ERROR_BUFFER_OVERFLOW {
+			return fmt.Errorf("failed to list adapters: %w", err)
+		}
+	}
+	for aa := (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])); aa != nil; aa = aa.Next {
+		name := windows.UTF16PtrToString(aa.FriendlyName)
+		if aa.OperStatus == windows.IfOperStatusUp && name != adapterName {
+			fn(name, aa)
+		}
+	}
+	return nil
+}
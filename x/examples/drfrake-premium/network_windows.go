@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	wlanapi                = windows.NewLazySystemDLL("wlanapi.dll")
+	procWlanOpenHandle     = wlanapi.NewProc("WlanOpenHandle")
+	procWlanCloseHandle    = wlanapi.NewProc("WlanCloseHandle")
+	procWlanEnumInterfaces = wlanapi.NewProc("WlanEnumInterfaces")
+	procWlanQueryInterface = wlanapi.NewProc("WlanQueryInterface")
+	procWlanFreeMemory     = wlanapi.NewProc("WlanFreeMemory")
+)
+
+const (
+	wlanClientVersion            = 2
+	wlanInterfaceStateConnected  = 1
+	wlanIntfOpcodeCurrentConnect = 7
+)
+
+// wlanInterfaceInfo is WLAN_INTERFACE_INFO.
+type wlanInterfaceInfo struct {
+	InterfaceGUID windows.GUID
+	Description   [256]uint16
+	State         uint32
+}
+
+// wlanInterfaceList is WLAN_INTERFACE_INFO_LIST.
+type wlanInterfaceList struct {
+	Count      uint32
+	Index      uint32
+	Interfaces [1]wlanInterfaceInfo
+}
+
+// wlanConnectionAttributes is the start of WLAN_CONNECTION_ATTRIBUTES, up to
+// the SSID.
+type wlanConnectionAttributes struct {
+	State          uint32
+	ConnectionMode uint32
+	ProfileName    [256]uint16
+	SSIDLength     uint32
+	SSID           [32]byte
+}
+
+// currentNetwork returns the network the computer is on: the connected Wi-Fi
+// if there is one, which is what trust is about, or else the first network
+// with a gateway.
+func currentNetwork() (Network, error) {
+	ssid, err := connectedSSID()
+	if err != nil {
+		return Network{}, err
+	}
+	if ssid != "" {
+		return Network{ID: "wifi:" + ssid, Name: ssid}, nil
+	}
+	var n Network
+	err = forEachPhysicalAdapter(windows.GAA_FLAG_INCLUDE_GATEWAYS, func(name string, aa *windows.IpAdapterAddresses) {
+		if n.ID != "" || aa.FirstGatewayAddress == nil {
+			return
+		}
+		// The DNS suffix tells apart networks behind routers of the same
+		// address, like most home and office ones.
+		suffix := windows.UTF16PtrToString(aa.DnsSuffix)
+		n.ID = fmt.Sprintf("wired:%s/%s", aa.FirstGatewayAddress.Address.IP(), suffix)
+		n.Name = name
+		if suffix != "" {
+			n.Name += " (" + suffix + ")"
+		}
+	})
+	return n, err
+}
+
+// connectedSSID returns the SSID of the connected Wi-Fi, or "" without one.
+// Computers without Wi-Fi lack the WLAN service, which isn't an error.
+func connectedSSID() (string, error) {
+	if procWlanOpenHandle.Find() != nil {
+		return "", nil
+	}
+	var version uint32
+	var client windows.Handle
+	if r, _, _ := procWlanOpenHandle.Call(wlanClientVersion, 0, uintptr(unsafe.Pointer(&version)), uintptr(unsafe.Pointer(&client))); r != 0 {
+		if windows.Errno(r) == windows.ERROR_SERVICE_NOT_ACTIVE {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to open the WLAN service: %w", windows.Errno(r))
+	}
+	defer procWlanCloseHandle.Call(uintptr(client), 0)
+
+	var list *wlanInterfaceList
+	if r, _, _ := procWlanEnumInterfaces.Call(uintptr(client), 0, uintptr(unsafe.Pointer(&list))); r != 0 {
+		return "", fmt.Errorf("failed to list Wi-Fi interfaces: %w", windows.Errno(r))
+	}
+	defer procWlanFreeMemory.Call(uintptr(unsafe.Pointer(list)))
+	interfaces := unsafe.Slice(&list.Interfaces[0], list.Count)
+	for i := range interfaces {
+		if interfaces[i].State != wlanInterfaceStateConnected {
+			continue
+		}
+		var size uint32
+		var attrs *wlanConnectionAttributes
+		r, _, _ := procWlanQueryInterface.Call(uintptr(client), uintptr(unsafe.Pointer(&interfaces[i].InterfaceGUID)),
+			wlanIntfOpcodeCurrentConnect, 0, uintptr(unsafe.Pointer(&size)), uintptr(unsafe.Pointer(&attrs)), 0)
+		if r != 0 {
+			continue
+		}
+		ssid := string(attrs.SSID[:min(attrs.SSIDLength, uint32(len(attrs.SSID)))])
+		procWlanFreeMemory.Call(uintptr(unsafe.Pointer(attrs)))
+		if ssid != "" {
+			return ssid, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Network is a network the computer is on, as network rules know it.
+type Network struct {
+	// ID identifies the network: "wifi:" and the SSID for Wi-Fi, or
+	// "wired:" and the gateway for other networks. Empty when offline.
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Trusted is whether the network is in NetworkRules.Trusted.
+	Trusted bool `json:"trusted"`
+}
+
+// TrustedNetwork is a network the user trusts, such as their home Wi-Fi.
+type TrustedNetwork struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// NetworkRules connect the VPN when the computer joins a network that isn't
+// trusted, such as public Wi-Fi, and disconnect it on trusted ones.
+type NetworkRules struct {
+	Enabled bool             `json:"enabled"`
+	Trusted []TrustedNetwork `json:"trusted,omitempty"`
+}
+
+// NetworkAction is what network rules do on joining a network.
+type NetworkAction string
+
+const (
+	NetworkActionNone       NetworkAction = ""
+	NetworkActionConnect    NetworkAction = "connect"
+	NetworkActionDisconnect NetworkAction = "disconnect"
+)
+
+// Validate checks the rules before they are saved.
+func (r *NetworkRules) Validate() error {
+	var ids []string
+	for _, n := range r.Trusted {
+		if n.ID == "" {
+			return errors.New("trusted network without an ID")
+		}
+		if slices.Contains(ids, n.ID) {
+			return fmt.Errorf("network %q is trusted twice", n.Name)
+		}
+		ids = append(ids, n.ID)
+	}
+	return nil
+}
+
+// IsTrusted reports whether the network with id is trusted.
+func (r *NetworkRules) IsTrusted(id string) bool {
+	return slices.ContainsFunc(r.Trusted, func(n TrustedNetwork) bool { return n.ID == id })
+}
+
+// Equal reports whether both have the same rules.
+func (r *NetworkRules) Equal(other NetworkRules) bool {
+	return r.Enabled == other.Enabled && slices.Equal(r.Trusted, other.Trusted)
+}
+
+// Action returns what to do on joining n.
+func (r *NetworkRules) Action(n Network) NetworkAction {
+	switch {
+	case !r.Enabled || n.ID == "":
+		return NetworkActionNone
+	case r.IsTrusted(n.ID):
+		return NetworkActionDisconnect
+	default:
+		return NetworkActionConnect
+	}
+}
+
+// watchNetworks applies the network rules when the computer joins a network,
+// until stop is closed. A network is acted on when it is joined, so the user
+// can still connect or disconnect by hand on it; recheck makes the rules apply
+// to the current network again, as after they changed.
+func (a *App) watchNetworks(stop, changes, recheck <-chan struct{}) {
+	last := "-"
+	force := true
+	for {
+		n, err := currentNetwork()
+		if err != nil {
+			log.Printf("[Network] %v", err)
+		} else if n.ID != last || force {
+			last = n.ID
+			a.applyNetworkRules(n)
+		}
+		force = false
+		select {
+		case <-stop:
+			return
+		case <-changes:
+		case <-recheck:
+			force = true
+		}
+	}
+}
+
+// applyNetworkRules connects or disconnects as the rules say for n.
+func (a *App) applyNetworkRules(n Network) {
+	a.routesMu.Lock()
+	rules := a.config.Settings.NetworkRules
+	a.routesMu.Unlock()
+	n.Trusted = rules.IsTrusted(n.ID)
+	runtime.EventsEmit(a.ctx, "network:changed", n)
+
+	a.connMu.Lock()
+	connected := a.stopSupervisor != nil
+	a.connMu.Unlock()
+	fields := map[string]string{"network": n.Name}
+	switch rules.Action(n) {
+	case NetworkActionConnect:
+		if connected {
+			return
+		}
+		log.Printf("[Network] Joined untrusted network %q, connecting.", n.Name)
+		a.logEvent(EventNetwork, "Joined untrusted network, connecting", nil, fields)
+		if err := a.connectLastServer(); err != nil {
+			log.Printf("[Network] Connecting failed: %v", err)
+		}
+	case NetworkActionDisconnect:
+		if !connected {
+			return
+		}
+		log.Printf("[Network] Joined trusted network %q, disconnecting.", n.Name)
+		a.logEvent(EventNetwork, "Joined trusted network, disconnecting", nil, fields)
+		a.Disconnect()
+	}
+}
+
+// GetCurrentNetwork returns the network the computer is on, for trusting it.
+func (a *App) GetCurrentNetwork() (Network, error) {
+	n, err := currentNetwork()
+	if err != nil {
+		return Network{}, err
+	}
+	a.routesMu.Lock()
+	n.Trusted = a.config.Settings.NetworkRules.IsTrusted(n.ID)
+	a.routesMu.Unlock()
+	return n, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"golang.getoutline.org/sdk/transport"
+)
+
+// maxPause is the longest a connection can be paused.
+const maxPause = 24 * time.Hour
+
+// Pause lets traffic bypass the tunnel for seconds, then resumes it. The TUN,
+// xray-core and the supervisor keep running, so pausing and resuming take a
+// moment where reconnecting would take seconds.
+//
+// In VPN mode, the routes into the tunnel and the kill switch are removed;
+// DNS still goes through the tunnel. In proxy mode, the local proxy connects
+// directly.
+func (a *App) Pause(seconds int) error {
+	d := time.Duration(seconds) * time.Second
+	if d <= 0 || d > maxPause {
+		return fmt.Errorf("invalid pause of %d seconds", seconds)
+	}
+	a.connMu.Lock()
+	defer a.connMu.Unlock()
+	if a.stopSupervisor == nil {
+		return errors.New("not connected")
+	}
+	if a.isConnected {
+		if err := a.pauseTunnel(); err != nil {
+			return err
+		}
+	}
+	// While reconnecting, the pause applies once connected, see repause.
+	until := time.Now().Add(d)
+	a.pausedUntil = until
+	if a.pauseTimer != nil {
+		a.pauseTimer.Stop()
+	}
+	a.pauseTimer = time.AfterFunc(d, func() {
+		if err := a.resume(until); err != nil {
+			log.Printf("[Pause] %v", err)
+		}
+	})
+	log.Printf("[Pause] Paused until %s.", until.Format(time.TimeOnly))
+	a.logEvent(EventPaused, "Paused", nil, map[string]string{"seconds": strconv.Itoa(seconds)})
+
+	status := a.GetConnectionStatus()
+	if status.State == StateConnected {
+		status.State = StatePaused
+	}
+	status.PausedUntil = until
+	a.setStatus(status)
+	return nil
+}
+
+// Resume sends traffic through the tunnel again before the pause is over.
+func (a *App) Resume() error {
+	return a.resume(time.Time{})
+}
+
+// resume ends the pause until, or any pause if until is zero. A timer of an
+// earlier pause doesn't end a later one.
+func (a *App) resume(until time.Time) error {
+	a.connMu.Lock()
+	defer a.connMu.Unlock()
+	if a.pausedUntil.IsZero() || (!until.IsZero() && !until.Equal(a.pausedUntil)) {
+		return nil
+	}
+	a.clearPause()
+	a.logEvent(EventResumed, "Resumed", nil, nil)
+	status := a.GetConnectionStatus()
+	if status.State == StatePaused {
+		status.State = StateConnected
+	}
+	status.PausedUntil = time.Time{}
+	a.setStatus(status)
+	if !a.isConnected {
+		return nil
+	}
+	log.Println("[Pause] Resumed.")
+	return a.resumeTunnel()
+}
+
+// clearPause forgets the pause, as when disconnecting. connMu must be held.
+func (a *App) clearPause() {
+	if a.pauseTimer != nil {
+		a.pauseTimer.Stop()
+		a.pauseTimer = nil
+	}
+	a.pausedUntil = time.Time{}
+	a.paused.Store(false)
+}
+
+// repause pauses the tunnel again after the supervisor reconnected it during
+// a pause. connMu must be held.
+func (a *App) repause() {
+	if a.pausedUntil.IsZero() {
+		return
+	}
+	if err := a.pauseTunnel(); err != nil {
+		log.Printf("[Pause] %v", err)
+		return
+	}
+	status := a.GetConnectionStatus()
+	status.State = StatePaused
+	status.PausedUntil = a.pausedUntil
+	a.setStatus(status)
+}
+
+// pauseTunnel lets traffic bypass the tunnel. connMu must be held.
+func (a *App) pauseTunnel() error {
+	a.paused.Store(true)
+	if a.tunDevice == nil {
+		// Proxy mode, where pausableDialer takes over.
+		return nil
+	}
+	a.routesMu.Lock()
+	defer a.routesMu.Unlock()
+	// Otherwise everything outside the tunnel is blocked.
+	a.disableKillSwitch()
+	if err := a.tunDevice.SetSplitRoutes(SplitRoutes{}); err != nil {
+		a.paused.Store(false)
+		return fmt.Errorf("failed to pause: %w", err)
+	}
+	return nil
+}
+
+// resumeTunnel puts back the routes and the kill switch. connMu must be held.
+func (a *App) resumeTunnel() error {
+	if a.tunDevice == nil {
+		return nil
+	}
+	a.routesMu.Lock()
+	defer a.routesMu.Unlock()
+	if err := a.tunDevice.SetSplitRoutes(a.splitRoutes); err != nil {
+		return fmt.Errorf("failed to resume: %w", err)
+	}
+	if a.config.Settings.KillSwitch {
+		return a.enableKillSwitch()
+	}
+	return nil
+}
+
+// pausableDialer dials directly instead of through the tunnel while paused.
+type pausableDialer struct {
+	tunnel transport.StreamDialer
+	paused *atomic.Bool
+}
+
+func (d pausableDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	if d.paused.Load() {
+		return (&transport.TCPDialer{}).DialStream(ctx, addr)
+	}
+	return d.tunnel.DialStream(ctx, addr)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// TUNDevice is the VPN's network adapter. WindowsTUN is one when the app runs
+// elevated; otherwise the helper service runs it and relays its packets.
+type TUNDevice interface {
+	io.ReadWriteCloser
+	Configure(localIP string) error
+	ConfigureIPv6(localIP6 string) error
+	SetupRoutes(serverHost, localTUNIP string, routes SplitRoutes) error
+	SetSplitRoutes(routes SplitRoutes) error
+	SetupDNS(resolver string) error
+}
+
+// KillSwitcher is the kill switch, see KillSwitch.
+type KillSwitcher interface {
+	Enable(tunAddrs []netip.Addr, tunneled, allowed []netip.Prefix, allowLAN bool) error
+	Disable() error
+	IsActive() bool
+	Recover()
+}
+
+// Privileged runs what needs admin rights: creating the TUN, routes and
+// firewall rules.
+type Privileged interface {
+	// NewTUN creates the TUN with ring buffers of ringSize bytes, see
+	// Settings.TUNRingSize.
+	NewTUN(ringSize uint32) (TUNDevice, error)
+	KillSwitch() KillSwitcher
+	// RecoverTUN undoes what the TUN of a session that never disconnected
+	// left behind, see RecoverTUN.
+	RecoverTUN() error
+}
+
+var errNotElevated = errors.New("the VPN needs administrator rights: install the helper service in Settings, run the app as administrator, or use proxy mode")
+
+// localPrivileged runs the privileged operations in the app itself, which
+// must then run elevated.
+type localPrivileged struct {
+	killSwitch *KillSwitch
+}
+
+func newLocalPrivileged() *localPrivileged {
+	return &localPrivileged{killSwitch: NewKillSwitch()}
+}
+
+func (p *localPrivileged) NewTUN(ringSize uint32) (TUNDevice, error) {
+	if !windows.GetCurrentProcessToken().IsElevated() {
+		return nil, errNotElevated
+	}
+	return NewWindowsTUN(ringSize)
+}
+
+func (p *localPrivileged) KillSwitch() KillSwitcher {
+	return p.killSwitch
+}
+
+func (p *localPrivileged) RecoverTUN() error {
+	// Without admin rights there can't be a TUN.
+	if !windows.GetCurrentProcessToken().IsElevated() {
+		return nil
+	}
+	return RecoverTUN()
+}
+
+// connectPrivileged uses the helper service if it is installed and the app
+// itself otherwise.
+func connectPrivileged() Privileged {
+	if client, err := DialHelper(); err == nil {
+		return client
+	}
+	return newLocalPrivileged()
+}
+
+// HelperStatus tells how the app gets admin rights.
+type HelperStatus struct {
+	// Installed is whether the helper service is running and used.
+	Installed bool `json:"installed"`
+	// Elevated is whether the app runs as administrator, which it needs
+	// without the helper.
+	Elevated bool `json:"elevated"`
+}
+
+func (a *App) GetHelperStatus() HelperStatus {
+	a.connMu.Lock()
+	defer a.connMu.Unlock()
+	_, installed := a.privileged.(*HelperClient)
+	return HelperStatus{
+		Installed: installed,
+		Elevated:  windows.GetCurrentProcessToken().IsElevated(),
+	}
+}
+
+// helperInstallTimeout is how long to wait for the helper to start after
+// the user confirms the UAC prompt.
+const helperInstallTimeout = 30 * time.Second
+
+// InstallHelper installs the helper service for the current user, asking for
+// admin rights once, and switches to it.
+func (a *App) InstallHelper() error {
+	a.connMu.Lock()
+	defer a.connMu.Unlock()
+	if a.isConnected {
+		return errors.New("disconnect first")
+	}
+	// Check before the UAC prompt, the elevated install fails the same way.
+	if _, err := helperExecutable(); err != nil {
+		return err
+	}
+	sid, err := currentUserSID()
+	if err != nil {
+		return err
+	}
+	if err := runElevated(helperInstallArg + " " + sid); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(helperInstallTimeout)
+	for {
+		client, err := DialHelper()
+		if err == nil {
+			a.usePrivileged(client)
+			log.Println("[Helper] Helper service installed.")
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the helper service didn't start: %w", err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// UninstallHelper removes the helper service. The app then needs to run as
+// administrator for the VPN mode.
+func (a *App) UninstallHelper() error {
+	a.connMu.Lock()
+	defer a.connMu.Unlock()
+	if a.isConnected {
+		return errors.New("disconnect first")
+	}
+	if err := runElevated(helperUninstallArg); err != nil {
+		return err
+	}
+	a.usePrivileged(newLocalPrivileged())
+	log.Println("[Helper] Helper service uninstalled.")
+	return nil
+}
+
+// usePrivileged switches to p. connMu must be held.
+func (a *App) usePrivileged(p Privileged) {
+	if client, ok := a.privileged.(*HelperClient); ok {
+		client.Close()
+	}
+	a.privileged = p
+	a.killSwitch = p.KillSwitch()
+}
+
+// runElevated runs the app with arg as administrator, which shows the UAC
+// prompt. It doesn't wait for it to finish.
+func runElevated(arg string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	verb, _ := windows.UTF16PtrFromString("runas")
+	file, _ := windows.UTF16PtrFromString(exe)
+	args, _ := windows.UTF16PtrFromString(arg)
+	if err := windows.ShellExecute(0, verb, file, args, nil, windows.SW_HIDE); err != nil {
+		return fmt.Errorf("failed to get administrator rights: %w", err)
+	}
+	return nil
+}
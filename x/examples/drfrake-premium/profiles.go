@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Profile is an account saved in the app, so users with several, e.g.
+// personal and work, can switch between them without logging in again. Each
+// has its own directory with its session and caches.
+type Profile struct {
+	ID     string `json:"id"` // The account's user ID
+	Email  string `json:"email"`
+	Active bool   `json:"active"`
+}
+
+// profileIndex is stored in profiles.json.
+type profileIndex struct {
+	Active   string    `json:"active,omitempty"`
+	Profiles []Profile `json:"profiles"`
+}
+
+func profilesPath() string {
+	return filepath.Join(GetConfigDir(), "profiles.json")
+}
+
+// profileDir returns the directory of a profile. The ID comes from the
+// backend, so it is hashed rather than trusted as a path.
+func profileDir(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(GetConfigDir(), "profiles", hex.EncodeToString(sum[:8]))
+}
+
+func loadProfiles() profileIndex {
+	var index profileIndex
+	data, err := os.ReadFile(profilesPath())
+	if err != nil {
+		return index
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		log.Printf("[Profiles] Invalid profiles.json: %v", err)
+	}
+	return index
+}
+
+func (index profileIndex) save() error {
+	for i := range index.Profiles {
+		index.Profiles[i].Active = false
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(GetConfigDir(), 0755)
+	return os.WriteFile(profilesPath(), data, 0600)
+}
+
+// useProfile makes the profile id the one sessions and caches are stored in.
+// An empty id is no profile, while logged out.
+func (a *App) useProfile(id string) {
+	a.profileID = id
+	dir := GetConfigDir()
+	if id != "" {
+		dir = profileDir(id)
+	}
+	os.MkdirAll(dir, 0700)
+	a.subCache = NewSubscriptionCache(filepath.Join(dir, "subscription.json"))
+	a.serverCache = NewServerCache(filepath.Join(dir, "servers_cache.json"))
+}
+
+// saveProfile adds the logged in account to the profiles, or updates its
+// email, and makes it the active one.
+func (a *App) saveProfile(user *User) {
+	index := loadProfiles()
+	i := slices.IndexFunc(index.Profiles, func(p Profile) bool { return p.ID == user.ID })
+	if i < 0 {
+		index.Profiles = append(index.Profiles, Profile{ID: user.ID})
+		i = len(index.Profiles) - 1
+	}
+	index.Profiles[i].Email = user.Email
+	index.Active = user.ID
+	if err := index.save(); err != nil {
+		log.Printf("[Profiles] Failed to save profiles: %v", err)
+	}
+	if a.profileID != user.ID {
+		a.useProfile(user.ID)
+	}
+}
+
+// removeProfile forgets the active profile, with its session and caches.
+func (a *App) removeProfile() {
+	if a.profileID == "" {
+		return
+	}
+	index := loadProfiles()
+	index.Profiles = slices.DeleteFunc(index.Profiles, func(p Profile) bool { return p.ID == a.profileID })
+	index.Active = ""
+	if err := index.save(); err != nil {
+		log.Printf("[Profiles] Failed to save profiles: %v", err)
+	}
+	// Secrets may be in the system's keystore rather than in the directory.
+	deleteSecret(a.getSessionPath())
+	a.serverCache.Clear()
+	os.RemoveAll(profileDir(a.profileID))
+	a.useProfile("")
+}
+
+// migrateLegacySession moves the session and caches of versions before
+// profiles, stored right in the config directory, into a profile.
+func (a *App) migrateLegacySession() {
+	legacy := filepath.Join(GetConfigDir(), "session.json")
+	if _, err := os.Stat(legacy); err != nil {
+		return
+	}
+	defer deleteSecret(legacy)
+	if !a.restoreSession(legacy) {
+		return
+	}
+	log.Printf("[Profiles] Moving the session of %s into a profile...", a.currentUser.Email)
+	a.saveProfile(a.currentUser)
+	for _, name := range []string{"subscription.json", "servers_cache.json"} {
+		os.Rename(filepath.Join(GetConfigDir(), name), filepath.Join(profileDir(a.currentUser.ID), name))
+	}
+	a.saveSession(a.authToken, a.currentUser.Email, "")
+}
+
+// GetProfiles returns the saved accounts.
+func (a *App) GetProfiles() []Profile {
+	index := loadProfiles()
+	profiles := index.Profiles
+	for i := range profiles {
+		profiles[i].Active = a.currentUser != nil && profiles[i].ID == a.currentUser.ID
+	}
+	return profiles
+}
+
+// SwitchProfile disconnects and logs in to the saved account id.
+func (a *App) SwitchProfile(id string) (*User, error) {
+	index := loadProfiles()
+	if !slices.ContainsFunc(index.Profiles, func(p Profile) bool { return p.ID == id }) {
+		return nil, fmt.Errorf("no saved account %q", id)
+	}
+	a.Disconnect()
+	a.currentUser = nil
+	a.authToken = ""
+	a.useProfile(id)
+	if !a.restoreSession(a.getSessionPath()) {
+		// The session expired; the account has to log in again.
+		a.removeProfile()
+		return nil, errors.New("the session of this account expired, please log in again")
+	}
+	a.saveProfile(a.currentUser)
+	log.Printf("[Profiles] Switched to %s", a.currentUser.Email)
+	return a.currentUser, nil
+}
+
+// AddProfile logs out without forgetting the current account, so another
+// one can log in next to it.
+func (a *App) AddProfile() {
+	a.Disconnect()
+	a.currentUser = nil
+	a.authToken = ""
+	index := loadProfiles()
+	index.Active = ""
+	if err := index.save(); err != nil {
+		log.Printf("[Profiles] Failed to save profiles: %v", err)
+	}
+	a.useProfile("")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"drfrake-core"
+	"golang.getoutline.org/sdk/transport"
+	"golang.getoutline.org/sdk/x/httpproxy"
+)
+
+// ConnectionMode is how traffic is sent to the VPN.
+type ConnectionMode string
+
+const (
+	// ModeVPN routes all traffic into the tunnel through a Wintun adapter,
+	// which needs admin rights. It is the default.
+	ModeVPN ConnectionMode = "vpn"
+	// ModeProxy serves a local HTTP proxy instead, for machines where the
+	// driver can't be installed. Only apps that use the proxy go through it.
+	ModeProxy ConnectionMode = "proxy"
+)
+
+// defaultProxyPort is the port of the local proxy if none is set.
+const defaultProxyPort = 10809
+
+// connectProxy serves a local HTTP proxy through sd instead of setting up the
+// TUN and, if enabled, makes it the system proxy. connMu must be held.
+func (a *App) connectProxy(config string, sd transport.StreamDialer, pl transport.PacketListener, fail func(error)) error {
+	settings := a.config.Settings
+	// The proxy answers even when the server doesn't, so it is checked first.
+	if err := probeTunnel(sd, settings.UpstreamDNS(), connectProbeTimeout); err != nil {
+		return fmt.Errorf("connectivity check failed: %w", err)
+	}
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(settings.LocalProxyPort()))
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start the local proxy, change its port in the settings: %w", err)
+	}
+	dialer := pausableDialer{tunnel: &core.CountingStreamDialer{Dialer: sd, Counter: a.traffic}, paused: &a.paused}
+	server := &http.Server{Handler: httpproxy.NewProxyHandler(dialer)}
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			fail(fmt.Errorf("local proxy stopped: %w", err))
+		}
+	}()
+	a.proxyServer = server
+	log.Printf("[Proxy] HTTP proxy listening on %s", addr)
+
+	if settings.ProxyDNSPort != 0 {
+		forwarder, err := core.NewDNSForwarder(dialer, settings.UpstreamDNS())
+		if err != nil {
+			return err
+		}
+		dnsAddr := net.JoinHostPort("127.0.0.1", strconv.Itoa(settings.ProxyDNSPort))
+		if _, err := forwarder.Listen(dnsAddr); err != nil {
+			return fmt.Errorf("%w, change its port in the settings", err)
+		}
+		a.dnsForwarder = forwarder
+		log.Printf("[Proxy] DNS listening on %s, resolving with %s", dnsAddr, forwarder.Upstream())
+	}
+
+	if settings.SystemProxy {
+		if err := a.systemProxy.Set(addr); err != nil {
+			return fmt.Errorf("failed to set the system proxy: %w", err)
+		}
+	}
+
+	a.tunnelDialer = sd
+	a.tunnelListener = pl
+	a.isConnected = true
+	a.activeConfig = config
+	return nil
+}
+
+// restoreSystemProxy puts back the proxy settings from before connecting.
+func (a *App) restoreSystemProxy() {
+	if err := a.systemProxy.Restore(); err != nil {
+		log.Printf("[Proxy] %v", err)
+	}
+}
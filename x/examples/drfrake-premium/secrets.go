@@ -0,0 +1,29 @@
+package main
+
+import (
+	"path/filepath"
+
+	"drfrake-core"
+)
+
+// keystoreService names the app's secrets in the system's keystore. It is
+// also the DPAPI entropy of the files earlier versions encrypted, so those
+// read as they are.
+const keystoreService = "DrFrakeVPN"
+
+// readSecret reads the secret kept at path: the file, encrypted for the
+// Windows user, or on other systems its entry in the system's keystore. A
+// plaintext file of an earlier version is read and secured.
+func readSecret(path string) ([]byte, error) {
+	return core.NewKeystore(keystoreService, filepath.Dir(path)).Get(filepath.Base(path))
+}
+
+// writeSecret keeps data at path, for secrets such as the session token and
+// access keys.
+func writeSecret(path string, data []byte) error {
+	return core.NewKeystore(keystoreService, filepath.Dir(path)).Set(filepath.Base(path), data)
+}
+
+func deleteSecret(path string) error {
+	return core.NewKeystore(keystoreService, filepath.Dir(path)).Delete(filepath.Base(path))
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"drfrake-core"
+)
+
+// A server subscription is a link, as panels like 3X-UI and Marzban share
+// them, serving the user's servers as base64 of one URI per line. Its servers
+// are listed next to the backend's.
+const (
+	// serverSubscriptionRefresh is how often the link is fetched again.
+	serverSubscriptionRefresh = 6 * time.Hour
+	serverSubscriptionTimeout = 30 * time.Second
+	// maxServerSubscriptionSize limits what is read from the link.
+	maxServerSubscriptionSize = 1 << 20
+)
+
+// SubscriptionServer is a server from the subscription link.
+type SubscriptionServer struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Config string `json:"config"`
+	// AddedAt is when the server first showed up in the link.
+	AddedAt time.Time `json:"addedAt"`
+	// UpdatedAt is when the link last listed the server.
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// ServerSubscriptionStatus is shown on the settings page.
+type ServerSubscriptionStatus struct {
+	URL     string               `json:"url"`
+	Servers []SubscriptionServer `json:"servers"`
+	// UpdatedAt is when the link was last fetched. Zero if it never was.
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Error is why the last fetch failed, if it did. The servers of the
+	// fetch before are kept.
+	Error string `json:"error,omitempty"`
+}
+
+// ServerSubscription keeps the link and its servers in an encrypted file in
+// the config directory, so they are there without a network.
+type ServerSubscription struct {
+	path string
+
+	mu     sync.Mutex
+	status ServerSubscriptionStatus
+}
+
+func NewServerSubscription(path string) *ServerSubscription {
+	s := &ServerSubscription{path: path}
+	if data, err := readSecret(path); err == nil {
+		if err := json.Unmarshal(data, &s.status); err != nil {
+			log.Printf("[Subscription] Invalid %s: %v", path, err)
+		}
+	}
+	return s
+}
+
+// Status returns the link and its servers.
+func (s *ServerSubscription) Status() ServerSubscriptionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	status.Servers = append([]SubscriptionServer(nil), s.status.Servers...)
+	return status
+}
+
+// SetURL replaces the link and fetches it. An empty link removes the
+// subscription and its servers.
+func (s *ServerSubscription) SetURL(link string) error {
+	link = strings.TrimSpace(link)
+	if link != "" {
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid subscription link %q: use an http or https URL", link)
+		}
+	}
+	s.mu.Lock()
+	s.status = ServerSubscriptionStatus{URL: link}
+	err := s.save()
+	s.mu.Unlock()
+	if err != nil || link == "" {
+		return err
+	}
+	return s.Refresh()
+}
+
+// Refresh fetches the link and updates the servers: new ones are added,
+// listed ones get a new UpdatedAt, and the ones no longer listed are removed.
+func (s *ServerSubscription) Refresh() error {
+	s.mu.Lock()
+	link := s.status.URL
+	s.mu.Unlock()
+	if link == "" {
+		return nil
+	}
+
+	configs, err := fetchServerSubscription(link)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status.URL != link {
+		// Changed while fetching.
+		return nil
+	}
+	now := time.Now()
+	s.status.UpdatedAt = now
+	if err != nil {
+		s.status.Error = err.Error()
+		s.save()
+		return err
+	}
+	s.status.Error = ""
+
+	known := make(map[string]SubscriptionServer)
+	for _, server := range s.status.Servers {
+		known[server.ID] = server
+	}
+	var servers []SubscriptionServer
+	for _, config := range configs {
+		server := SubscriptionServer{
+			ID:        subscriptionServerID(config),
+			Name:      subscriptionServerName(config),
+			Config:    config,
+			AddedAt:   now,
+			UpdatedAt: now,
+		}
+		if slices.ContainsFunc(servers, func(s SubscriptionServer) bool { return s.ID == server.ID }) {
+			continue // Listed twice under different names
+		}
+		if old, ok := known[server.ID]; ok {
+			server.AddedAt = old.AddedAt
+		}
+		servers = append(servers, server)
+	}
+	log.Printf("[Subscription] Fetched %d servers (%d before).", len(servers), len(s.status.Servers))
+	s.status.Servers = servers
+	return s.save()
+}
+
+// Run refreshes the link periodically until stop is closed.
+func (s *ServerSubscription) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(serverSubscriptionRefresh)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		due := time.Since(s.status.UpdatedAt) >= serverSubscriptionRefresh
+		s.mu.Unlock()
+		if due {
+			if err := s.Refresh(); err != nil {
+				log.Printf("[Subscription] %v", err)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// save writes the status to the file. mu must be held.
+func (s *ServerSubscription) save() error {
+	data, err := json.MarshalIndent(s.status, "", "  ")
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(GetConfigDir(), 0755)
+	// The link and the servers' configs are secrets.
+	return writeSecret(s.path, data)
+}
+
+// fetchServerSubscription returns the server URIs served at link.
+func fetchServerSubscription(link string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), serverSubscriptionTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch subscription: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch subscription: %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxServerSubscriptionSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch subscription: %w", err)
+	}
+	return parseServerSubscription(body)
+}
+
+// parseServerSubscription parses base64 of one server URI per line. Plain
+// text is accepted too. Lines of unsupported schemes are skipped.
+func parseServerSubscription(body []byte) ([]string, error) {
+	text := string(body)
+	if decoded, err := core.DecodeBase64(strings.Join(strings.Fields(text), "")); err == nil {
+		text = string(decoded)
+	}
+	var configs []string
+	seen := make(map[string]bool) // By normalized config
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		info, err := core.ValidateConfig(line)
+		if err == nil && info.Smart {
+			err = errors.New("the smart mode isn't a server")
+		}
+		if err != nil {
+			log.Printf("[Subscription] Skipping entry %.16s...: %v", line, err)
+			continue
+		}
+		if seen[info.Normalized] {
+			continue
+		}
+		seen[info.Normalized] = true
+		configs = append(configs, line)
+	}
+	if len(configs) == 0 {
+		return nil, errors.New("the subscription has no supported servers")
+	}
+	return configs, nil
+}
+
+// subscriptionServerID identifies a server by its config without the name,
+// so renaming it in the panel keeps its ID, and so its favorite.
+func subscriptionServerID(config string) string {
+	config, _, _ = strings.Cut(config, "#")
+	sum := sha256.Sum256([]byte(config))
+	return "sub-" + hex.EncodeToString(sum[:6])
+}
+
+// subscriptionServerName returns the name in the config's fragment, or in
+// the "ps" field of VMess links, or the host.
+func subscriptionServerName(config string) string {
+	if strings.HasPrefix(config, "vmess://") {
+		if data, err := core.DecodeBase64(strings.TrimPrefix(config, "vmess://")); err == nil {
+			var link struct {
+				PS  string `json:"ps"`
+				Add string `json:"add"`
+			}
+			if json.Unmarshal(data, &link) == nil {
+				if link.PS != "" {
+					return link.PS
+				}
+				return link.Add
+			}
+		}
+		return "VMess"
+	}
+	if _, fragment, ok := strings.Cut(config, "#"); ok && fragment != "" {
+		if name, err := url.PathUnescape(fragment); err == nil {
+			return name
+		}
+		return fragment
+	}
+	if u, err := url.Parse(config); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return "Server"
+}
+
+// subscriptionServers returns the servers of the subscription link.
+func (a *App) subscriptionServers() []Server {
+	var servers []Server
+	for _, s := range a.serverSub.Status().Servers {
+		servers = append(servers, Server{
+			ID:      s.ID,
+			Country: s.Name,
+			Flag:    "🔗",
+			Config:  s.Config,
+			Source:  "subscription",
+		})
+	}
+	return servers
+}
+
+func (a *App) GetServerSubscription() ServerSubscriptionStatus {
+	return a.serverSub.Status()
+}
+
+// SetServerSubscription sets the subscription link and fetches its servers.
+// An empty link removes it.
+func (a *App) SetServerSubscription(link string) error {
+	return a.serverSub.SetURL(link)
+}
+
+// RefreshServerSubscription fetches the subscription link now.
+func (a *App) RefreshServerSubscription() error {
+	return a.serverSub.Refresh()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// ServerCache keeps the last server list the backend returned, so the user
+// can reconnect while it is unreachable. The list has the user's own keys, so
+// it is kept as a secret.
+type ServerCache struct {
+	path string
+	mu   sync.Mutex
+}
+
+type cachedServers struct {
+	UserID    string    `json:"userId"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	Servers   []Server  `json:"servers"`
+}
+
+func NewServerCache(path string) *ServerCache {
+	return &ServerCache{path: path}
+}
+
+// Servers returns the cached servers of userID and when they were fetched,
+// or nil if there are none.
+func (c *ServerCache) Servers(userID string) ([]Server, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, err := readSecret(c.path)
+	if err != nil {
+		return nil, time.Time{}
+	}
+	// The cache of earlier versions doesn't parse, and is fetched again.
+	var cached cachedServers
+	if err := json.Unmarshal(data, &cached); err != nil || cached.UserID != userID {
+		return nil, time.Time{}
+	}
+	return cached.Servers, cached.FetchedAt
+}
+
+// SetServers caches the servers of userID, fetched now.
+func (c *ServerCache) SetServers(userID string, servers []Server) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, _ := json.Marshal(cachedServers{UserID: userID, FetchedAt: time.Now(), Servers: servers})
+	if err := writeSecret(c.path, data); err != nil {
+		log.Printf("[Servers] Failed to cache servers: %v", err)
+	}
+}
+
+func (c *ServerCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deleteSecret(c.path)
+}
+
+// ServerListStatus tells where the server list came from, for the app to
+// show when it may be out of date.
+type ServerListStatus struct {
+	// Cached is set when the backend couldn't be reached and the servers
+	// are the ones it last returned.
+	Cached bool `json:"cached"`
+	// Builtin is set when there are no cached servers either, and the list
+	// is the built-in one.
+	Builtin bool `json:"builtin"`
+	// UpdatedAt is when the backend returned the servers. Zero for the
+	// built-in list.
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (a *App) setServerListStatus(status ServerListStatus) {
+	a.statusMu.Lock()
+	defer a.statusMu.Unlock()
+	a.servers = status
+}
+
+// GetServerListStatus tells where the servers GetServers last returned came
+// from.
+func (a *App) GetServerListStatus() ServerListStatus {
+	a.statusMu.Lock()
+	defer a.statusMu.Unlock()
+	return a.servers
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// maxRecentServers is how many servers the recents list keeps.
+const maxRecentServers = 5
+
+// Server sort orders of ServerQuery.
+const (
+	SortByCountry = "country"
+	SortByLatency = "latency"
+	// SortByPremium lists premium servers first.
+	SortByPremium = "premium"
+)
+
+// ServerQuery filters and sorts the server list for long lists.
+type ServerQuery struct {
+	// Search keeps the servers whose country, city or ID contains it,
+	// ignoring case.
+	Search string `json:"search"`
+	// Sort is one of the SortBy orders. Empty keeps the backend's order.
+	Sort string `json:"sort"`
+	// Tags keeps the servers that have all of them.
+	Tags []string `json:"tags"`
+}
+
+// ServerGroup is the servers with a tag, see GroupServersByTag.
+type ServerGroup struct {
+	// Tag is empty for the servers without tags.
+	Tag     string   `json:"tag"`
+	Servers []Server `json:"servers"`
+}
+
+// SearchServers returns the servers matching q, favorites first.
+func (a *App) SearchServers(q ServerQuery) ([]Server, error) {
+	var compare func(a, b Server) int
+	switch q.Sort {
+	case "":
+		compare = func(a, b Server) int { return 0 }
+	case SortByCountry:
+		compare = func(a, b Server) int {
+			return cmp.Or(strings.Compare(a.Country, b.Country), strings.Compare(a.City, b.City))
+		}
+	case SortByLatency:
+		compare = func(a, b Server) int { return cmp.Compare(a.Latency, b.Latency) }
+	case SortByPremium:
+		compare = func(a, b Server) int {
+			return cmp.Or(compareBool(b.IsPremium, a.IsPremium), strings.Compare(a.Country, b.Country))
+		}
+	default:
+		return nil, fmt.Errorf("invalid sort order %q", q.Sort)
+	}
+
+	search := strings.ToLower(strings.TrimSpace(q.Search))
+	var servers []Server
+	for _, s := range a.GetServers() {
+		if search == "" ||
+			strings.Contains(strings.ToLower(s.Country), search) ||
+			strings.Contains(strings.ToLower(s.City), search) ||
+			strings.Contains(strings.ToLower(s.ID), search) {
+			if hasTags(s, q.Tags) {
+				servers = append(servers, s)
+			}
+		}
+	}
+	slices.SortStableFunc(servers, func(a, b Server) int {
+		return cmp.Or(compareBool(b.IsFavorite, a.IsFavorite), compare(a, b))
+	})
+	return servers, nil
+}
+
+// hasTags reports whether s has all of tags.
+func hasTags(s Server, tags []string) bool {
+	for _, tag := range tags {
+		if !slices.Contains(s.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetServerTags returns the tags of all servers, sorted, to filter by.
+func (a *App) GetServerTags() []string {
+	var tags []string
+	for _, s := range a.GetServers() {
+		tags = append(tags, s.Tags...)
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// GroupServersByTag returns the servers matching q by tag, the tags sorted
+// and the servers without tags last. A server with several tags is in the
+// group of each.
+func (a *App) GroupServersByTag(q ServerQuery) ([]ServerGroup, error) {
+	servers, err := a.SearchServers(q)
+	if err != nil {
+		return nil, err
+	}
+	byTag := make(map[string][]Server)
+	for _, s := range servers {
+		if len(s.Tags) == 0 {
+			byTag[""] = append(byTag[""], s)
+		}
+		for _, tag := range s.Tags {
+			byTag[tag] = append(byTag[tag], s)
+		}
+	}
+	groups := []ServerGroup{}
+	for _, tag := range slices.Sorted(maps.Keys(byTag)) {
+		if tag != "" {
+			groups = append(groups, ServerGroup{Tag: tag, Servers: byTag[tag]})
+		}
+	}
+	if untagged := byTag[""]; untagged != nil {
+		groups = append(groups, ServerGroup{Servers: untagged})
+	}
+	return groups, nil
+}
+
+// compareBool orders false before true.
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+	return -1
+}
+
+func (a *App) FavoriteServer(id string) error {
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+	if slices.Contains(a.config.Favorites, id) {
+		return nil
+	}
+	a.config.Favorites = append(a.config.Favorites, id)
+	if err := SaveConfig(a.config); err != nil {
+		return fmt.Errorf("failed to save favorites: %w", err)
+	}
+	return nil
+}
+
+func (a *App) UnfavoriteServer(id string) error {
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+	i := slices.Index(a.config.Favorites, id)
+	if i < 0 {
+		return nil
+	}
+	a.config.Favorites = slices.Delete(a.config.Favorites, i, i+1)
+	if err := SaveConfig(a.config); err != nil {
+		return fmt.Errorf("failed to save favorites: %w", err)
+	}
+	return nil
+}
+
+// GetRecentServers returns the servers last connected to, newest first.
+// Servers that are no longer offered are left out.
+func (a *App) GetRecentServers() []Server {
+	servers := a.GetServers()
+	a.configMu.Lock()
+	recents := slices.Clone(a.config.Recents)
+	a.configMu.Unlock()
+	var recent []Server
+	for _, id := range recents {
+		if i := slices.IndexFunc(servers, func(s Server) bool { return s.ID == id }); i >= 0 {
+			recent = append(recent, servers[i])
+		}
+	}
+	return recent
+}
+
+// markFavorites sets IsFavorite of servers.
+func (a *App) markFavorites(servers []Server) []Server {
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+	for i := range servers {
+		servers[i].IsFavorite = slices.Contains(a.config.Favorites, servers[i].ID)
+	}
+	return servers
+}
+
+// rememberServer makes id the last server and moves it to the top of the
+// recents.
+func (a *App) rememberServer(id string) {
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+	recents := []string{id}
+	for _, r := range a.config.Recents {
+		if r != id && len(recents) < maxRecentServers {
+			recents = append(recents, r)
+		}
+	}
+	if a.config.LastServerID == id && slices.Equal(recents, a.config.Recents) {
+		return
+	}
+	a.config.LastServerID = id
+	a.config.Recents = recents
+	if err := SaveConfig(a.config); err != nil {
+		log.Printf("[VPN] Failed to save the last server: %v", err)
+	}
+}
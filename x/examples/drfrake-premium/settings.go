@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// Settings are the user's connection preferences, edited in the app and
+// stored in config.json.
+type Settings struct {
+	// KillSwitch blocks all traffic outside the tunnel while connected, so
+	// nothing leaks if the tunnel or xray-core dies.
+	KillSwitch bool `json:"killSwitch"`
+	// KillSwitchAllowLAN keeps the local network (printers, the router's
+	// DNS) reachable while the kill switch is on. It only matters with
+	// SplitTunnel.TunnelLAN, as the local network bypasses the tunnel and the
+	// kill switch otherwise.
+	KillSwitchAllowLAN bool `json:"killSwitchAllowLan"`
+	// SplitTunnel limits what goes through the VPN.
+	SplitTunnel SplitTunnel `json:"splitTunnel"`
+	// DNS is the resolver queried through the tunnel while connected, an IP.
+	// Empty means defaultUpstreamDNS.
+	DNS string `json:"dns,omitempty"`
+	// IPv6 is what happens to IPv6 traffic. Empty means IPv6Block.
+	IPv6 IPv6Mode `json:"ipv6,omitempty"`
+	// XrayLogFile also writes xray-core's output to xray.log in the config
+	// directory, for support.
+	XrayLogFile bool `json:"xrayLogFile,omitempty"`
+	// Mode is how traffic goes to the VPN. Empty means ModeVPN.
+	Mode ConnectionMode `json:"mode,omitempty"`
+	// ProxyPort is the port of the local proxy in ModeProxy. 0 means
+	// defaultProxyPort.
+	ProxyPort int `json:"proxyPort,omitempty"`
+	// ProxyDNSPort is the port on 127.0.0.1 where DNS is answered through the
+	// tunnel in ModeProxy, for apps that can be pointed at a resolver. 0
+	// serves none.
+	ProxyDNSPort int `json:"proxyDnsPort,omitempty"`
+	// SystemProxy makes the local proxy the system proxy in ModeProxy.
+	SystemProxy bool `json:"systemProxy,omitempty"`
+	// LaunchAtLogin starts the app when the user logs in.
+	LaunchAtLogin bool `json:"launchAtLogin,omitempty"`
+	// AutoConnect connects to the last server when the app starts. With
+	// NetworkRules enabled, they decide instead.
+	AutoConnect bool `json:"autoConnect,omitempty"`
+	// SpeedTestURL is the endpoint of the speed test. Empty means
+	// defaultSpeedTestURL.
+	SpeedTestURL string `json:"speedTestUrl,omitempty"`
+	// NetworkRules connect and disconnect the VPN by the network joined.
+	NetworkRules NetworkRules `json:"networkRules"`
+	// TUNRingSize is the size in bytes of the ring buffers between the TUN
+	// adapter and the app, a power of two from minTUNRingSize to
+	// maxTUNRingSize. Larger ones take bursts at high speeds. 0 means
+	// defaultTUNRingSize.
+	TUNRingSize uint32 `json:"tunRingSize,omitempty"`
+}
+
+// Ring buffer sizes of the TUN adapter, as Wintun allows them.
+const (
+	defaultTUNRingSize = 4 << 20
+	minTUNRingSize     = 128 << 10
+	maxTUNRingSize     = 64 << 20
+)
+
+// Validate checks the settings before they are saved.
+func (s *Settings) Validate() error {
+	if s.DNS != "" {
+		if _, err := netip.ParseAddr(s.DNS); err != nil {
+			return fmt.Errorf("invalid DNS server %q: use an IP address", s.DNS)
+		}
+	}
+	switch s.IPv6 {
+	case "", IPv6Block, IPv6Tunnel:
+	default:
+		return fmt.Errorf("invalid IPv6 mode %q", s.IPv6)
+	}
+	switch s.Mode {
+	case "", ModeVPN, ModeProxy:
+	default:
+		return fmt.Errorf("invalid connection mode %q", s.Mode)
+	}
+	if s.ProxyPort < 0 || s.ProxyPort > 65535 {
+		return fmt.Errorf("invalid proxy port %d", s.ProxyPort)
+	}
+	if s.ProxyDNSPort < 0 || s.ProxyDNSPort > 65535 {
+		return fmt.Errorf("invalid DNS port %d", s.ProxyDNSPort)
+	}
+	if s.SpeedTestURL != "" {
+		if err := validateSpeedTestURL(s.SpeedTestURL); err != nil {
+			return err
+		}
+	}
+	if s.TUNRingSize != 0 && (s.TUNRingSize < minTUNRingSize || s.TUNRingSize > maxTUNRingSize || s.TUNRingSize&(s.TUNRingSize-1) != 0) {
+		return fmt.Errorf("invalid ring buffer size %d: use a power of two from 128 KiB to 64 MiB", s.TUNRingSize)
+	}
+	if err := s.NetworkRules.Validate(); err != nil {
+		return err
+	}
+	return s.SplitTunnel.Validate()
+}
+
+// UpstreamDNS returns the resolver to query through the tunnel.
+func (s *Settings) UpstreamDNS() string {
+	if s.DNS == "" {
+		return defaultUpstreamDNS
+	}
+	return s.DNS
+}
+
+// LocalProxyPort returns the port of the local proxy in ModeProxy.
+func (s *Settings) LocalProxyPort() int {
+	if s.ProxyPort == 0 {
+		return defaultProxyPort
+	}
+	return s.ProxyPort
+}
+
+// TUNRingBufferSize returns the size of the TUN adapter's ring buffers.
+func (s *Settings) TUNRingBufferSize() uint32 {
+	if s.TUNRingSize == 0 {
+		return defaultTUNRingSize
+	}
+	return s.TUNRingSize
+}
+
+// SpeedTestEndpoint returns the endpoint of the speed test.
+func (s *Settings) SpeedTestEndpoint() string {
+	if s.SpeedTestURL == "" {
+		return defaultSpeedTestURL
+	}
+	return s.SpeedTestURL
+}
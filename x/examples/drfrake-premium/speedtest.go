@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"drfrake-core"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"golang.getoutline.org/sdk/transport"
+)
+
+// defaultSpeedTestURL is the speed test endpoint if none is set. Endpoints
+// answer GET /__down?bytes=N with N bytes and accept POST /__up, like
+// Cloudflare's.
+const defaultSpeedTestURL = "https://speed.cloudflare.com"
+
+const (
+	speedTestPings = 10
+	// A phase ends after speedTestDuration or when its bytes are through,
+	// whichever comes first.
+	speedTestDuration      = 10 * time.Second
+	speedTestDownloadBytes = 100 << 20
+	speedTestUploadBytes   = 25 << 20
+	speedTestTimeout       = 15 * time.Second
+)
+
+// SpeedTestResult is the result of RunSpeedTest.
+type SpeedTestResult struct {
+	Time     time.Time `json:"time"`
+	ServerID string    `json:"serverId"`
+	Endpoint string    `json:"endpoint"`
+	// LatencyMs is the median round trip of a request, and JitterMs the mean
+	// difference between consecutive ones.
+	LatencyMs    float64 `json:"latencyMs"`
+	JitterMs     float64 `json:"jitterMs"`
+	DownloadMbps float64 `json:"downloadMbps"`
+	UploadMbps   float64 `json:"uploadMbps"`
+}
+
+var speedTestMu sync.Mutex
+
+// RunSpeedTest measures latency, jitter and throughput through the tunnel.
+// Its phases are sent as "speedtest:phase" events.
+func (a *App) RunSpeedTest() (*SpeedTestResult, error) {
+	if !speedTestMu.TryLock() {
+		return nil, errors.New("a speed test is already running")
+	}
+	defer speedTestMu.Unlock()
+
+	a.connMu.Lock()
+	sd := a.tunnelDialer
+	endpoint := a.config.Settings.SpeedTestEndpoint()
+	a.connMu.Unlock()
+	if sd == nil {
+		return nil, errors.New("connect first, the speed test runs through the VPN")
+	}
+	result := &SpeedTestResult{
+		Time:     time.Now().UTC(),
+		ServerID: a.GetConnectionStatus().ServerID,
+		Endpoint: endpoint,
+	}
+	client := speedTestClient(sd)
+	defer client.CloseIdleConnections()
+
+	log.Printf("[SpeedTest] Testing through %s...", endpoint)
+	a.speedTestPhase("latency")
+	var err error
+	if result.LatencyMs, result.JitterMs, err = measureLatency(client, endpoint); err != nil {
+		return nil, fmt.Errorf("latency test failed: %w", err)
+	}
+	a.speedTestPhase("download")
+	if result.DownloadMbps, err = measureDownload(client, endpoint); err != nil {
+		return nil, fmt.Errorf("download test failed: %w", err)
+	}
+	a.speedTestPhase("upload")
+	if result.UploadMbps, err = measureUpload(client, endpoint); err != nil {
+		return nil, fmt.Errorf("upload test failed: %w", err)
+	}
+	a.speedTestPhase("done")
+	log.Printf("[SpeedTest] %.0f ms (jitter %.1f ms), %.1f Mbps down, %.1f Mbps up",
+		result.LatencyMs, result.JitterMs, result.DownloadMbps, result.UploadMbps)
+	return result, nil
+}
+
+func (a *App) speedTestPhase(phase string) {
+	if a.ctx != nil {
+		runtime.EventsEmit(a.ctx, "speedtest:phase", phase)
+	}
+}
+
+// speedTestClient makes requests through sd, so they can't bypass the
+// tunnel however routes are set up.
+func speedTestClient(sd transport.StreamDialer) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return sd.DialStream(ctx, addr)
+		},
+		ForceAttemptHTTP2: true,
+	}}
+}
+
+// measureLatency times small requests over a warm connection.
+func measureLatency(client *http.Client, endpoint string) (latency, jitter float64, err error) {
+	var rtts []float64
+	// The first request also connects, so it isn't counted.
+	for i := 0; i <= speedTestPings; i++ {
+		start := time.Now()
+		if err := speedTestRequest(client, http.MethodGet, endpoint+"/__down?bytes=0", nil, io.Discard); err != nil {
+			return 0, 0, err
+		}
+		if i > 0 {
+			rtts = append(rtts, float64(time.Since(start).Microseconds())/1000)
+		}
+	}
+	for i := 1; i < len(rtts); i++ {
+		jitter += math.Abs(rtts[i] - rtts[i-1])
+	}
+	jitter /= float64(len(rtts) - 1)
+	sorted := slices.Clone(rtts)
+	slices.Sort(sorted)
+	return sorted[len(sorted)/2], jitter, nil
+}
+
+func measureDownload(client *http.Client, endpoint string) (float64, error) {
+	counter := core.NewTrafficCounter()
+	start := time.Now()
+	err := speedTestRequest(client, http.MethodGet, fmt.Sprintf("%s/__down?bytes=%d", endpoint, speedTestDownloadBytes), nil, counter.CountDown(io.Discard))
+	return throughput(uint64(counter.Stats().BytesDown), time.Since(start), err)
+}
+
+func measureUpload(client *http.Client, endpoint string) (float64, error) {
+	counter := core.NewTrafficCounter()
+	start := time.Now()
+	body := io.TeeReader(bytes.NewReader(make([]byte, speedTestUploadBytes)), counter.CountUp(io.Discard))
+	err := speedTestRequest(client, http.MethodPost, endpoint+"/__up", body, io.Discard)
+	return throughput(uint64(counter.Stats().BytesUp), time.Since(start), err)
+}
+
+// throughput returns the rate in Mbit/s. Running out of time isn't an error,
+// what was transferred by then counts.
+func throughput(n uint64, elapsed time.Duration, err error) (float64, error) {
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, errors.New("nothing was transferred")
+	}
+	return float64(n) * 8 / elapsed.Seconds() / 1e6, nil
+}
+
+// speedTestRequest sends a request and copies the response body to w. It
+// stops after speedTestDuration.
+func speedTestRequest(client *http.Client, method, url string, body io.Reader, w io.Writer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), speedTestDuration)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// validateSpeedTestURL checks a speed test endpoint setting.
+func validateSpeedTestURL(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid speed test endpoint %q: use an http or https URL", endpoint)
+	}
+	if strings.HasSuffix(u.Path, "/") {
+		return fmt.Errorf("invalid speed test endpoint %q: leave out the trailing slash", endpoint)
+	}
+	return nil
+}
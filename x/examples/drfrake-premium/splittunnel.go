@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"drfrake-core/tun"
+)
+
+// splitTunnelRefresh is how often the domains of split tunnel rules are
+// resolved again while connected, as their addresses change.
+const splitTunnelRefresh = 5 * time.Minute
+
+// SplitTunnel decides which traffic goes through the VPN. Entries are IPs,
+// CIDRs or domain names.
+type SplitTunnel struct {
+	// Include, if not empty, limits the tunnel to these destinations.
+	Include []string `json:"include"`
+	// Exclude lists destinations that bypass the tunnel.
+	Exclude []string `json:"exclude"`
+	// TunnelLAN sends the local network through the full tunnel too. By
+	// default it bypasses the tunnel, so printers, NAS and casting devices
+	// keep working while connected.
+	TunnelLAN bool `json:"tunnelLan,omitempty"`
+}
+
+// Validate checks that every entry is an IP, a CIDR or a domain name.
+func (s SplitTunnel) Validate() error {
+	for _, entry := range append(slices.Clone(s.Include), s.Exclude...) {
+		if _, ok := parseRoutePrefix(entry); ok {
+			continue
+		}
+		if !isDomainName(entry) {
+			return fmt.Errorf("invalid split tunnel rule %q: use an IP, a CIDR or a domain name", entry)
+		}
+	}
+	return nil
+}
+
+// SplitRoutes are split tunnel rules with their domains resolved, without
+// the route to the server.
+type SplitRoutes = tun.Routes
+
+// Resolve resolves the rules. Domains that fail to resolve are skipped.
+func (s SplitTunnel) Resolve() SplitRoutes {
+	include := resolveRules(s.Include)
+	exclude := resolveRules(s.Exclude)
+	if len(s.Include) > 0 && len(include) == 0 {
+		// None of the destinations resolved: nothing goes through the
+		// tunnel, rather than everything.
+		return SplitRoutes{Bypass: exclude}
+	}
+	return tun.RoutePlan{Include: include, Exclude: exclude, TunnelLAN: s.TunnelLAN}.Routes()
+}
+
+// resolveRules turns entries into a sorted list of prefixes.
+func resolveRules(entries []string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		if p, ok := parseRoutePrefix(entry); ok {
+			prefixes = append(prefixes, p)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", entry)
+		cancel()
+		if err != nil {
+			log.Printf("[SplitTunnel] Failed to resolve %s: %v", entry, err)
+			continue
+		}
+		for _, ip := range ips {
+			ip = ip.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(ip, ip.BitLen()))
+		}
+	}
+	return sortPrefixes(prefixes)
+}
+
+// sortPrefixes sorts prefixes and removes duplicates.
+func sortPrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	slices.SortFunc(prefixes, func(a, b netip.Prefix) int {
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c
+		}
+		return a.Bits() - b.Bits()
+	})
+	return slices.Compact(prefixes)
+}
+
+// parseRoutePrefix parses an IP or a CIDR.
+func parseRoutePrefix(entry string) (netip.Prefix, bool) {
+	if p, err := netip.ParsePrefix(entry); err == nil {
+		return p.Masked(), true
+	}
+	if ip, err := netip.ParseAddr(entry); err == nil {
+		ip = ip.Unmap()
+		return netip.PrefixFrom(ip, ip.BitLen()), true
+	}
+	return netip.Prefix{}, false
+}
+
+func isDomainName(s string) bool {
+	if len(s) == 0 || len(s) > 253 || !strings.Contains(s, ".") {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
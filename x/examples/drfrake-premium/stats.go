@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"drfrake-core"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// statsInterval is how often "vpn:stats" events are sent while connected.
+const statsInterval = time.Second
+
+// ConnectionStats is the traffic of the current connection, including the
+// reconnects since the user connected, as the core counts it in both modes.
+// It is sent to the frontend with the "vpn:stats" event every statsInterval.
+type ConnectionStats struct {
+	BytesUp   uint64 `json:"bytesUp"`
+	BytesDown uint64 `json:"bytesDown"`
+	// UpRate and DownRate are in bytes per second over the last interval.
+	UpRate   float64 `json:"upRate"`
+	DownRate float64 `json:"downRate"`
+	// Connections counts the connections and UDP sessions opened, and
+	// ActiveConnections those still open.
+	Connections       int64      `json:"connections"`
+	ActiveConnections int64      `json:"activeConnections"`
+	ConnectedSince    *time.Time `json:"connectedSince,omitempty"`
+	DurationSec       int64      `json:"durationSec"`
+	ServerID          string     `json:"serverId,omitempty"`
+}
+
+// GetStats returns the traffic of the current connection.
+func (a *App) GetStats() ConnectionStats {
+	return a.connectionStats(a.traffic.Stats())
+}
+
+// connectionStats converts the traffic counted by the core for the frontend.
+func (a *App) connectionStats(t *core.TrafficStats) ConnectionStats {
+	stats := ConnectionStats{
+		BytesUp:           uint64(t.BytesUp),
+		BytesDown:         uint64(t.BytesDown),
+		Connections:       t.Connections,
+		ActiveConnections: t.ActiveConnections,
+		ServerID:          a.GetConnectionStatus().ServerID,
+	}
+	if since := a.statsSince.Load(); since != nil {
+		stats.UpRate, stats.DownRate = t.UpRate, t.DownRate
+		stats.ConnectedSince = since
+		stats.DurationSec = t.DurationMs / 1000
+	}
+	return stats
+}
+
+// startStats resets the counters and sends stats every statsInterval until
+// stop is closed.
+func (a *App) startStats(stop chan struct{}) {
+	a.traffic.Reset()
+	now := time.Now()
+	a.statsSince.Store(&now)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		a.statsSince.Store(nil)
+		cancel()
+	}()
+	go a.traffic.Report(ctx, statsInterval, func(t *core.TrafficStats) {
+		if a.ctx != nil {
+			runtime.EventsEmit(a.ctx, "vpn:stats", a.connectionStats(t))
+		}
+	})
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"golang.getoutline.org/sdk/dns"
+	"golang.getoutline.org/sdk/transport"
+	"golang.getoutline.org/sdk/x/configurl"
+)
+
+const (
+	// healthCheckInterval is how often the tunnel is checked while connected.
+	healthCheckInterval = 30 * time.Second
+	// healthCheckFailures is how many checks in a row must fail before the
+	// tunnel counts as broken.
+	healthCheckFailures = 3
+	// healthCheckDomain is resolved through the tunnel to check it.
+	healthCheckDomain = "example.com"
+	// connectProbeTimeout is how long a new tunnel has to pass its first
+	// check before the next config is tried.
+	connectProbeTimeout = 10 * time.Second
+	// udpProbeTimeout is how long a UDP query through a new tunnel may take
+	// before UDP counts as blocked.
+	udpProbeTimeout = 5 * time.Second
+
+	reconnectMinBackoff = time.Second
+	reconnectMaxBackoff = time.Minute
+	// attemptsPerServer is how many times a server is tried before moving on
+	// to the next best one.
+	attemptsPerServer = 2
+)
+
+// ConnectionState is the state of the VPN connection.
+type ConnectionState string
+
+const (
+	StateDisconnected ConnectionState = "disconnected"
+	StateConnecting   ConnectionState = "connecting"
+	StateConnected    ConnectionState = "connected"
+	StateReconnecting ConnectionState = "reconnecting"
+	// StatePaused is connected with traffic bypassing the tunnel, see Pause.
+	StatePaused ConnectionState = "paused"
+)
+
+// ConnectionStatus is sent to the frontend with the "vpn:state" event
+// whenever it changes.
+type ConnectionStatus struct {
+	State    ConnectionState `json:"state"`
+	ServerID string          `json:"serverId,omitempty"`
+	Attempt  int             `json:"attempt,omitempty"` // Of reconnecting
+	Error    string          `json:"error,omitempty"`   // Why the connection failed
+	// Transport is the protocol of the config that connected, as fallback
+	// configs may be used.
+	Transport string `json:"transport,omitempty"`
+	// PausedUntil is when a pause ends, while paused.
+	PausedUntil time.Time `json:"pausedUntil"`
+	// UDP is whether the server relays UDP, while connected.
+	UDP UDPSupport `json:"udp,omitempty"`
+}
+
+// UDPSupport is whether the server relays UDP. Without it, QUIC, video calls
+// and games don't work through the tunnel, while DNS still does, as queries
+// go over TCP, see newTunnelDNS.
+type UDPSupport string
+
+const (
+	UDPUnknown   UDPSupport = "" // Not checked yet
+	UDPAvailable UDPSupport = "available"
+	UDPBlocked   UDPSupport = "blocked" // TCP-only
+)
+
+// GetConnectionStatus returns the current connection status.
+func (a *App) GetConnectionStatus() ConnectionStatus {
+	a.statusMu.Lock()
+	defer a.statusMu.Unlock()
+	return a.status
+}
+
+func (a *App) setStatus(status ConnectionStatus) {
+	a.statusMu.Lock()
+	if status.State == StateConnected || status.State == StatePaused {
+		status.UDP = a.udp
+	}
+	a.status = status
+	a.statusMu.Unlock()
+	if a.ctx != nil {
+		runtime.EventsEmit(a.ctx, "vpn:state", status)
+	}
+}
+
+// errNetworkChanged fails the tunnel when the network it was set up on is
+// gone, as its routes and server connections are.
+var errNetworkChanged = errors.New("network changed")
+
+// supervise keeps the connection to server up until stop is closed. When the
+// tunnel fails or the network changes, it is torn down and connected again,
+// first to the same server and then to the next best ones, backing off
+// between attempts. A network change cuts the backoff short.
+func (a *App) supervise(stop chan struct{}, server Server, failed <-chan error) {
+	networkChanges := a.networkChanges()
+	// Changes from before connecting are already accounted for.
+	select {
+	case <-networkChanges:
+	default:
+	}
+
+	// The server list and their addresses are fetched while the tunnel
+	// works; with the kill switch on, they can't be later.
+	candidates := a.fallbackServers(server)
+	for _, s := range candidates {
+		for _, config := range s.configs() {
+			a.preResolve(config)
+		}
+	}
+
+	for {
+		err := a.watch(stop, failed, networkChanges)
+		if err == nil {
+			return
+		}
+		log.Printf("[Supervisor] Connection failed: %v", err)
+		a.logEvent(EventConnectionLost, "Connection to "+server.ID+" lost", err, nil)
+
+		for attempt := 1; ; attempt++ {
+			server = candidates[(attempt-1)/attemptsPerServer%len(candidates)]
+			a.connMu.Lock()
+			if isClosed(stop) {
+				a.connMu.Unlock()
+				return
+			}
+			a.teardown()
+			a.setStatus(ConnectionStatus{State: StateReconnecting, ServerID: server.ID, Attempt: attempt, Error: err.Error()})
+			a.connMu.Unlock()
+
+			backoff := min(reconnectMinBackoff<<min(attempt-1, 6), reconnectMaxBackoff)
+			select {
+			case <-stop:
+				return
+			case <-time.After(backoff):
+			case <-networkChanges:
+			}
+
+			a.connMu.Lock()
+			if isClosed(stop) {
+				a.connMu.Unlock()
+				return
+			}
+			log.Printf("[Supervisor] Reconnecting to %s, attempt %d...", server.ID, attempt)
+			var via string
+			failed, via, err = a.connectServer(server)
+			if err == nil {
+				a.setStatus(ConnectionStatus{State: StateConnected, ServerID: server.ID, Transport: via})
+				a.repause()
+			}
+			a.connMu.Unlock()
+			if err == nil {
+				log.Printf("[Supervisor] Reconnected to %s.", server.ID)
+				break
+			}
+			log.Printf("[Supervisor] Reconnect failed: %v", err)
+		}
+	}
+}
+
+// watch waits until the tunnel fails, checking it every healthCheckInterval.
+// It returns nil once stop is closed.
+func (a *App) watch(stop chan struct{}, failed <-chan error, networkChanges <-chan struct{}) error {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-stop:
+			return nil
+		case err := <-failed:
+			return err
+		case <-networkChanges:
+			return errNetworkChanged
+		case <-ticker.C:
+		}
+		err := a.checkTunnel()
+		if err == nil {
+			failures = 0
+			continue
+		}
+		failures++
+		log.Printf("[Supervisor] Health check %d/%d failed: %v", failures, healthCheckFailures, err)
+		if failures >= healthCheckFailures {
+			return fmt.Errorf("health checks failed: %w", err)
+		}
+	}
+}
+
+// networkChanges returns the network monitor's changes, or nil without one.
+func (a *App) networkChanges() <-chan struct{} {
+	if a.netMonitor == nil {
+		return nil
+	}
+	return a.netMonitor.Changes()
+}
+
+// checkTunnel resolves healthCheckDomain over TCP through the tunnel.
+func (a *App) checkTunnel() error {
+	a.connMu.Lock()
+	sd := a.tunnelDialer
+	upstream := a.config.Settings.UpstreamDNS()
+	a.connMu.Unlock()
+	if sd == nil {
+		return fmt.Errorf("not connected")
+	}
+	return probeTunnel(sd, upstream, 10*time.Second)
+}
+
+// probeTunnel resolves healthCheckDomain with upstream over TCP through sd.
+func probeTunnel(sd transport.StreamDialer, upstream string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return testResolver(ctx, dns.NewTCPResolver(sd, net.JoinHostPort(upstream, "53")))
+}
+
+// checkUDP finds out whether the tunnel of pl relays UDP, with a query to
+// upstream, and updates the connection status with it. The check runs in the
+// background, as servers that drop UDP only show it by not answering.
+func (a *App) checkUDP(pl transport.PacketListener, upstream string) {
+	a.statusMu.Lock()
+	a.udp = UDPUnknown
+	a.udpCheck++
+	check := a.udpCheck
+	a.statusMu.Unlock()
+
+	go func() {
+		udp := UDPAvailable
+		if err := probeUDP(pl, upstream, udpProbeTimeout); err != nil {
+			log.Printf("[VPN] UDP doesn't go through the server, only TCP works: %v", err)
+			udp = UDPBlocked
+		}
+
+		a.statusMu.Lock()
+		// A later connection has its own check.
+		if check != a.udpCheck {
+			a.statusMu.Unlock()
+			return
+		}
+		a.udp = udp
+		status := a.status
+		a.statusMu.Unlock()
+		if status.State == StateConnected || status.State == StatePaused {
+			a.setStatus(status)
+		}
+	}()
+}
+
+// probeUDP resolves healthCheckDomain with upstream over UDP through pl.
+func probeUDP(pl transport.PacketListener, upstream string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return testResolver(ctx, dns.NewUDPResolver(transport.PacketListenerDialer{Listener: pl}, net.JoinHostPort(upstream, "53")))
+}
+
+// fallbackServers returns server followed by the other servers the user has
+// access to, by latency.
+func (a *App) fallbackServers(server Server) []Server {
+	var others []Server
+	for _, s := range a.GetServers() {
+		if s.ID != server.ID && s.Config != "" && !s.Locked {
+			others = append(others, s)
+		}
+	}
+	slices.SortStableFunc(others, func(x, y Server) int { return x.Latency - y.Latency })
+	return append([]Server{server}, others...)
+}
+
+// preResolve caches the addresses of a server for reconnecting to it.
+func (a *App) preResolve(config string) {
+	host := serverHostOf(config)
+	if host == "" {
+		return
+	}
+	addrs, err := resolveHost(host)
+	if err != nil {
+		return
+	}
+	a.connMu.Lock()
+	a.hostAddrs[host] = addrs
+	a.connMu.Unlock()
+}
+
+// serverHostOf returns the host of a server config, or "" if it can't be
+// parsed.
+func serverHostOf(config string) string {
+	if IsXrayURI(config) {
+		if server, err := ParseXrayURI(config); err == nil {
+			return server.Host
+		}
+		return ""
+	}
+	if cfg, err := configurl.ParseConfig(config); err == nil {
+		return cfg.URL.Hostname()
+	}
+	return ""
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// internetSettingsKey holds the proxy settings of the current user, which
+// browsers and most apps follow. Changing them needs no admin rights.
+const internetSettingsKey = `Software\Microsoft\Windows\CurrentVersion\Internet Settings`
+
+// proxyBypass is what doesn't go through the system proxy: the local network.
+const proxyBypass = "<local>;localhost;127.*;10.*;172.16.*;192.168.*"
+
+var (
+	wininet                = windows.NewLazySystemDLL("wininet.dll")
+	procInternetSetOptionW = wininet.NewProc("InternetSetOptionW")
+)
+
+const (
+	internetOptionSettingsChanged = 39
+	internetOptionRefresh         = 37
+)
+
+// proxySettings are the values of internetSettingsKey that SystemProxy
+// changes.
+type proxySettings struct {
+	Enable   uint64 `json:"enable"`
+	Server   string `json:"server,omitempty"`
+	Override string `json:"override,omitempty"`
+}
+
+// SystemProxy points the system proxy at the local proxy while connected in
+// proxy mode. The previous settings are saved to a file first, so they are
+// put back even after a crash.
+type SystemProxy struct {
+	backupPath string
+}
+
+func NewSystemProxy() *SystemProxy {
+	return &SystemProxy{backupPath: filepath.Join(GetConfigDir(), "system_proxy.json")}
+}
+
+// Set makes addr the system proxy.
+func (p *SystemProxy) Set(addr string) error {
+	// A backup left from before a reconnect or crash has the user's
+	// settings; the current ones are ours.
+	if _, err := os.Stat(p.backupPath); errors.Is(err, os.ErrNotExist) {
+		current, err := readProxySettings()
+		if err != nil {
+			return err
+		}
+		data, _ := json.Marshal(current)
+		if err := os.WriteFile(p.backupPath, data, 0600); err != nil {
+			return fmt.Errorf("failed to back up proxy settings: %w", err)
+		}
+	}
+	if err := writeProxySettings(proxySettings{Enable: 1, Server: addr, Override: proxyBypass}); err != nil {
+		return err
+	}
+	log.Printf("[Proxy] System proxy set to %s.", addr)
+	return nil
+}
+
+// Restore puts back the settings from before Set, if it was called.
+func (p *SystemProxy) Restore() error {
+	data, err := os.ReadFile(p.backupPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved proxySettings
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("invalid proxy settings backup: %w", err)
+	}
+	if err := writeProxySettings(saved); err != nil {
+		return err
+	}
+	log.Println("[Proxy] System proxy restored.")
+	return os.Remove(p.backupPath)
+}
+
+// Recover restores the settings a session that never disconnected left.
+func (p *SystemProxy) Recover() {
+	if _, err := os.Stat(p.backupPath); err != nil {
+		return
+	}
+	log.Println("[Proxy] Found the system proxy of a previous session, restoring it...")
+	if err := p.Restore(); err != nil {
+		log.Printf("[Proxy] %v", err)
+	}
+}
+
+func readProxySettings() (proxySettings, error) {
+	key, err := registry.OpenKey(registry.CURRENT_USER, internetSettingsKey, registry.QUERY_VALUE)
+	if err != nil {
+		return proxySettings{}, fmt.Errorf("failed to read proxy settings: %w", err)
+	}
+	defer key.Close()
+	var s proxySettings
+	s.Enable, _, _ = key.GetIntegerValue("ProxyEnable")
+	s.Server, _, _ = key.GetStringValue("ProxyServer")
+	s.Override, _, _ = key.GetStringValue("ProxyOverride")
+	return s, nil
+}
+
+// writeProxySettings stores s and tells running apps to reload them.
+func writeProxySettings(s proxySettings) error {
+	key, err := registry.OpenKey(registry.CURRENT_USER, internetSettingsKey, registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("failed to change proxy settings: %w", err)
+	}
+	defer key.Close()
+	if err := key.SetDWordValue("ProxyEnable", uint32(s.Enable)); err != nil {
+		return fmt.Errorf("failed to change proxy settings: %w", err)
+	}
+	for name, value := range map[string]string{"ProxyServer": s.Server, "ProxyOverride": s.Override} {
+		if value == "" {
+			key.DeleteValue(name)
+			continue
+		}
+		if err := key.SetStringValue(name, value); err != nil {
+			return fmt.Errorf("failed to change proxy settings: %w", err)
+		}
+	}
+	procInternetSetOptionW.Call(0, internetOptionSettingsChanged, 0, 0)
+	procInternetSetOptionW.Call(0, internetOptionRefresh, 0, 0)
+	return nil
+}
@@ -0,0 +1,57 @@
+// Command signupdate signs the release manifest the app checks for updates.
+//
+//	signupdate -genkey
+//	DRFRAKE_UPDATE_KEY=<private key> signupdate manifest.json > drfrake-update.json
+//
+// -genkey prints a new key pair. Release builds get the public key with
+// -ldflags "-X main.updatePublicKey=<public key>"; the private key signs
+// releases and must be kept out of the repository, see the README.
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+func main() {
+	genKey := flag.Bool("genkey", false, "print a new key pair")
+	flag.Parse()
+
+	if *genKey {
+		pub, priv, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("public: ", base64.StdEncoding.EncodeToString(pub))
+		fmt.Println("private:", base64.StdEncoding.EncodeToString(priv))
+		return
+	}
+
+	if flag.NArg() != 1 {
+		log.Fatal("usage: signupdate manifest.json")
+	}
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("DRFRAKE_UPDATE_KEY"))
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		log.Fatal("DRFRAKE_UPDATE_KEY must be the base64 private key")
+	}
+	manifest, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !json.Valid(manifest) {
+		log.Fatalf("%s is not JSON", flag.Arg(0))
+	}
+	signed, err := json.MarshalIndent(map[string]string{
+		"manifest":  base64.StdEncoding.EncodeToString(manifest),
+		"signature": base64.StdEncoding.EncodeToString(ed25519.Sign(ed25519.PrivateKey(key), manifest)),
+	}, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(signed))
+}
@@ -1,197 +1,159 @@
 package main
 
 import (
+	"cmp"
 	"fmt"
 	"log"
-	"os/exec"
-	"strings"
-	"time"
+	"net/netip"
+	"slices"
+	"sync"
 
-	"golang.org/x/sys/windows"
-	"golang.zx2c4.com/wintun"
+	"drfrake-core/tun"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
 )
 
 const (
-	driverName  = "Wintun"
 	adapterName = "DrFrakeVPN"
-	mtu         = 1500
+	// adapterGUID is the GUID of the adapter, the same on every run, so
+	// Windows sees the same network adapter and keeps one network profile for
+	// it. With a new GUID each run, it adds "DrFrakeVPN 2", "DrFrakeVPN 3" and
+	// so on.
+	adapterGUID = "{FF9F47D1-9B0F-4D0A-A59C-5584F653CFC2}"
+	mtu         = tun.DefaultMTU
+
+	// tunIP is the address of the TUN adapter. Use a fixed IP for now. Ideally
+	// should be configurable or determined by server. But Outline usually
+	// doesn't push IP. We use a private IP.
+	tunIP = "10.0.85.2"
+	// tunIP6 is the IPv6 address of the TUN adapter, a unique local address
+	// so tunneled IPv6 traffic has a source inside the tunnel.
+	tunIP6 = "fd00:85::2"
 )
 
+// WindowsTUN is the Wintun adapter of the core, with the routes and DNS of
+// the VPN on it.
 type WindowsTUN struct {
-	adapter *wintun.Adapter
-	session wintun.Session
-}
+	dev  *tun.Wintun
+	luid winipcfg.LUID // Of the adapter, which it is configured by
 
-func NewWindowsTUN() (*WindowsTUN, error) {
-	log.Println("[Wintun] Initializing...")
+	// journal lists what must be undone if the app or helper crashes,
+	// besides the routes, which routes journals.
+	journal *tunJournal
+	routes  *tun.RouteManager
 
-	// Best Practice: cleanup stale adapter with the same name before creating a new one.
-	// This prevents "Element not found" errors when Wintun tries to resolve name collisions (e.g. "DrFrakeVPN 1").
-	if oldAdapter, err := wintun.OpenAdapter(adapterName); err == nil {
-		log.Println("[Wintun] Found existing adapter, closing/deleting it...")
-		oldAdapter.Close()
-	} else {
-		log.Println("[Wintun] No existing adapter found (clean slate).")
-	}
+	mu     sync.Mutex
+	server []netip.Addr // Routed around the tunnel, set by SetupRoutes
+}
 
-	// Create adapter. Using nil GUID for random/auto-generated.
-	log.Println("[Wintun] Creating new adapter...")
-	adapter, err := wintun.CreateAdapter(adapterName, "DrFrakeVPN", nil)
-	if err != nil {
-		log.Printf("[Wintun] CreateAdapter failed: %v", err)
-		return nil, fmt.Errorf("failed to create Wintun adapter: %w", err)
+// NewWindowsTUN creates the adapter, with ring buffers of ringSize bytes
+// between it and the app, or the default if 0.
+func NewWindowsTUN(ringSize uint32) (*WindowsTUN, error) {
+	journal := newTUNJournal()
+	if err := journal.setAdapter(true); err != nil {
+		return nil, err
 	}
-	log.Println("[Wintun] Adapter created successfully.")
 
-	// Capacity: 0x400000 (4MB) is a common default.
-	// Wintun uses ring buffer size.
-	log.Println("[Wintun] Starting session...")
-	session, err := adapter.StartSession(0x400000)
+	log.Printf("[Wintun] Creating the adapter with %d KiB ring buffers...", cmp.Or(ringSize, tun.DefaultRingSize)/1024)
+	dev, err := tun.NewWintun(tun.Options{Name: adapterName, MTU: mtu, GUID: adapterGUID, RingSize: ringSize})
 	if err != nil {
-		log.Printf("[Wintun] StartSession failed: %v", err)
-		adapter.Close()
-		return nil, fmt.Errorf("failed to start Wintun session: %w", err)
+		log.Printf("[Wintun] %v", err)
+		journal.setAdapter(false)
+		return nil, err
 	}
-	log.Println("[Wintun] Session started.")
+	log.Println("[Wintun] Adapter created successfully.")
 
 	return &WindowsTUN{
-		adapter: adapter,
-		session: session,
+		dev:     dev,
+		luid:    dev.LUID(),
+		journal: journal,
+		routes:  tun.NewRouteManager(dev, routeJournalPath()),
 	}, nil
 }
 
 func (t *WindowsTUN) Read(p []byte) (int, error) {
-	pkt, err := t.session.ReceivePacket()
-	if err != nil {
-		return 0, err
-	}
-	n := copy(p, pkt)
-	t.session.ReleaseReceivePacket(pkt)
-	return n, nil
+	return t.dev.Read(p)
 }
 
 func (t *WindowsTUN) Write(p []byte) (int, error) {
-	pkt, err := t.session.AllocateSendPacket(len(p))
-	if err != nil {
-		return 0, err
-	}
-	copy(pkt, p)
-	t.session.SendPacket(pkt)
-	return len(p), nil
+	return t.dev.Write(p)
 }
 
 func (t *WindowsTUN) Close() error {
-	t.session.End()
-	return t.adapter.Close()
+	// Routes through the TUN go away with it, the ones around it don't.
+	if err := t.routes.Close(); err != nil {
+		log.Printf("[Routing] %v", err)
+	}
+	if err := RemoveDNSLeakRules(); err != nil {
+		log.Printf("[DNS] %v", err)
+	}
+	err := t.dev.Close()
+	if err := t.journal.setAdapter(false); err != nil {
+		log.Printf("[Wintun] %v", err)
+	}
+	return err
 }
 
 func (t *WindowsTUN) MTU() int {
-	return mtu
+	return t.dev.MTU()
 }
 
+// Configure sets localIP, in a /24, as the adapter's IPv4 address.
 func (t *WindowsTUN) Configure(localIP string) error {
-	log.Printf("[Wintun] Configuring IP %s via netsh... (Looping 10s)", localIP)
-
-	var lastErr error
-	var lastOut string
-
-	// Loop for up to 10 seconds to allow interface to appear
-	for i := 0; i < 20; i++ {
-		// 1. Check if specific interface exists in netsh
-		checkCmd := exec.Command("netsh", "interface", "ip", "show", "address", fmt.Sprintf("name=\"%s\"", adapterName))
-		checkCmd.SysProcAttr = &windows.SysProcAttr{HideWindow: true}
-		checkOut, _ := checkCmd.CombinedOutput()
-		output := string(checkOut)
-
-		// If verify shows IP is already there -> Success
-		if strings.Contains(output, localIP) {
-			log.Printf("[Wintun] Success: IP %s already present on attempt %d.", localIP, i+1)
-			return nil
-		}
-
-		// 2. Try to set IP
-		cmd := exec.Command("netsh", "interface", "ip", "set", "address",
-			fmt.Sprintf("name=\"%s\"", adapterName),
-			"source=static",
-			fmt.Sprintf("addr=%s", localIP),
-			"mask=255.255.255.0",
-			"gateway=none")
-		cmd.SysProcAttr = &windows.SysProcAttr{HideWindow: true}
-		out, err := cmd.CombinedOutput()
-
-		if err == nil {
-			log.Printf("[Wintun] Success: IP configured on attempt %d.", i+1)
-			return nil
-		}
-
-		lastErr = err
-		lastOut = string(out)
-
-		// Check for "Already exists" error in various languages (Russian, English)
-		// "Этот объект уже существует" / "The object already exists"
-		if strings.Contains(lastOut, "существует") || strings.Contains(lastOut, "exists") {
-			log.Printf("[Wintun] 'Object exists' error detected. Assuming success and proceeding.")
-			return nil
-		}
-
-		// Log intermediate failure quietly
-		if i%5 == 0 {
-			log.Printf("[Wintun] Attempt %d/20 failed: %v | Out: %s", i+1, err, strings.TrimSpace(lastOut))
-		}
-
-		time.Sleep(500 * time.Millisecond)
+	addr, err := netip.ParseAddr(localIP)
+	if err != nil {
+		return fmt.Errorf("invalid TUN address: %w", err)
 	}
-
-	return fmt.Errorf("failed to configure IP after 10s. Last error: %v, Output: %s", lastErr, lastOut)
+	log.Printf("[Wintun] Configuring IP %s...", localIP)
+	return t.dev.Configure(netip.PrefixFrom(addr, 24))
 }
 
-func (t *WindowsTUN) SetupRoutes(serverIP string, localTUNIP string) error {
-	// PowerShell script to setup routing:
-	// 1. Find Default Gateway
-	// 2. Add route to VPN Server via Default Gateway (Loop prevention)
-	// 3. Add 0.0.0.0/1 and 128.0.0.0/1 via TUN (Redirect traffic)
-
-	psCmd := fmt.Sprintf(`
-		$ErrorActionPreference = "Stop";
-		$serverIP = "%s";
-		$tunIP = "%s";
-		
-		# 1. Find Default Gateway (metric based)
-		$defRoute = Get-NetRoute -DestinationPrefix "0.0.0.0/0" | Sort-Object -Property RouteMetric | Select-Object -First 1
-		if (!$defRoute) { Write-Error "No default gateway found"; exit 1 }
-		$gw = $defRoute.NextHop
-		$ifIndex = $defRoute.InterfaceIndex
-		
-		# 2. Prevent Loop: Route to VPN Server via old gateway
-		if ($serverIP -ne "") {
-			if (!(Get-NetRoute -DestinationPrefix "$serverIP/32" -ErrorAction SilentlyContinue)) {
-				New-NetRoute -DestinationPrefix "$serverIP/32" -NextHop $gw -InterfaceIndex $ifIndex -RouteMetric 1
-			}
-		}
-
-		# 3. Route Traffic via TUN
-		$tunIf = Get-NetIPAddress -IPAddress $tunIP
-		if (!$tunIf) { Write-Error "TUN Interface not found"; exit 1 }
-		$tunIdx = $tunIf.InterfaceIndex
-		
-		# Helper to add route if missing
-		function Add-Route($prefix, $idx) {
-			if (!(Get-NetRoute -DestinationPrefix $prefix -ErrorAction SilentlyContinue)) {
-				New-NetRoute -DestinationPrefix $prefix -InterfaceIndex $idx -RouteMetric 1
-			}
-		}
-		
-		Add-Route "0.0.0.0/1" $tunIdx
-		Add-Route "128.0.0.0/1" $tunIdx
-	`, serverIP, localTUNIP)
+// ConfigureIPv6 adds localIP6, in a /64, to the adapter. Configure must have
+// succeeded, so the adapter is known to the IP stack.
+func (t *WindowsTUN) ConfigureIPv6(localIP6 string) error {
+	addr, err := netip.ParseAddr(localIP6)
+	if err != nil {
+		return fmt.Errorf("invalid TUN address: %w", err)
+	}
+	log.Printf("[Wintun] Configuring IPv6 %s...", localIP6)
+	return t.dev.Configure(netip.PrefixFrom(addr, 64))
+}
 
+// SetupRoutes routes the server through the default gateway, so the tunnel
+// doesn't loop into itself, and then programs routes with SetSplitRoutes.
+func (t *WindowsTUN) SetupRoutes(serverIP string, localTUNIP string, routes SplitRoutes) error {
+	tunAddr, err := netip.ParseAddr(localTUNIP)
+	if err != nil {
+		return fmt.Errorf("invalid TUN address: %w", err)
+	}
 	log.Printf("[Routing] Configuring routes for Server: %s, TUN: %s...", serverIP, localTUNIP)
-	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", psCmd)
-	cmd.SysProcAttr = &windows.SysProcAttr{HideWindow: true}
-
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to setup routes: %v, output: %s", err, string(out))
+	if _, err := t.luid.IPAddress(tunAddr); err != nil {
+		return fmt.Errorf("failed to setup routes: TUN interface not found: %w", err)
+	}
+	t.mu.Lock()
+	t.server = nil
+	if addr, err := netip.ParseAddr(serverIP); err == nil {
+		t.server = []netip.Addr{addr.Unmap()}
+	}
+	t.mu.Unlock()
+	if err := t.SetSplitRoutes(routes); err != nil {
+		return err
 	}
 	log.Println("[Routing] Routes configured successfully.")
 	return nil
 }
+
+// SetSplitRoutes programs the routes of the split tunnel, with the route to
+// the server, changing only what changed since the last call. On failure the
+// routes of the last call stay.
+func (t *WindowsTUN) SetSplitRoutes(routes SplitRoutes) error {
+	bypass := slices.Clone(routes.Bypass)
+	t.mu.Lock()
+	for _, ip := range t.server {
+		bypass = append(bypass, netip.PrefixFrom(ip, ip.BitLen()))
+	}
+	t.mu.Unlock()
+	if err := t.routes.Apply(tun.Routes{Tunneled: routes.Tunneled, Bypass: bypass}); err != nil {
+		return fmt.Errorf("failed to update split tunnel routes: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"drfrake-core/tun"
+)
+
+// tunJournal lists what WindowsTUN changed that outlives it: its adapter.
+// The change is written to the journal before it is made and dropped once
+// undone, so RecoverTUN can undo what a crashed app or helper left behind.
+// The routes around the tunnel are in the journal of its tun.RouteManager.
+type tunJournal struct {
+	path string
+}
+
+type tunJournalState struct {
+	Adapter bool `json:"adapter,omitempty"`
+}
+
+// routeJournalPath is the path of the journal of the routes of WindowsTUN.
+func routeJournalPath() string {
+	return filepath.Join(GetConfigDir(), "route_journal.json")
+}
+
+func newTUNJournal() *tunJournal {
+	return &tunJournal{path: filepath.Join(GetConfigDir(), "tun_journal.json")}
+}
+
+func (j *tunJournal) load() tunJournalState {
+	var state tunJournalState
+	if data, err := os.ReadFile(j.path); err == nil {
+		json.Unmarshal(data, &state)
+	}
+	return state
+}
+
+// update changes the journal with fn. Once it lists nothing, it is removed.
+func (j *tunJournal) update(fn func(state *tunJournalState)) error {
+	state := j.load()
+	fn(&state)
+	if !state.Adapter {
+		if err := os.Remove(j.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove TUN journal: %w", err)
+		}
+		return nil
+	}
+	data, _ := json.Marshal(state)
+	if err := os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return fmt.Errorf("failed to save TUN journal: %w", err)
+	}
+	if err := os.WriteFile(j.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to save TUN journal: %w", err)
+	}
+	return nil
+}
+
+// setAdapter records whether the adapter exists.
+func (j *tunJournal) setAdapter(exists bool) error {
+	return j.update(func(state *tunJournalState) { state.Adapter = exists })
+}
+
+// RecoverTUN removes what a TUN of a session that never disconnected left
+// behind: the routes in its route journal, the adapter in the journal, and
+// the DNS leak rules. It must not be called while a TUN is open.
+func RecoverTUN() error {
+	j := newTUNJournal()
+	state := j.load()
+	errs := []error{tun.RecoverRoutes(routeJournalPath())}
+	if state.Adapter {
+		log.Println("[Recovery] Found the adapter of a previous session, removing it...")
+		if err := tun.RemoveOrphanedAdapters(adapterName); err != nil {
+			errs = append(errs, err)
+		} else {
+			errs = append(errs, j.setAdapter(false))
+		}
+	}
+	errs = append(errs, RemoveDNSLeakRules())
+	return errors.Join(errs...)
+}
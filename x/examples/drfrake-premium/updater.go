@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	wailsruntime "github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// appVersion is the version of the app, as in wails.json. Release builds set
+// it with -ldflags "-X main.appVersion=1.2.3".
+var appVersion = "1.0.0"
+
+const (
+	// defaultUpdateManifestURL is where the manifest of the latest release
+	// is published, see releaseManifest.
+	defaultUpdateManifestURL = "https://github.com/GGmuzem/outline-sdk/releases/latest/download/drfrake-update.json"
+
+	// updateCheckInterval is how often the manifest is checked.
+	updateCheckInterval   = 24 * time.Hour
+	updateCheckTimeout    = 30 * time.Second
+	updateDownloadTimeout = 10 * time.Minute
+	// maxUpdateManifestSize limits what is read from the manifest URL.
+	maxUpdateManifestSize = 64 << 10
+)
+
+// updatePublicKey is the Ed25519 key release manifests are signed with, in
+// base64. Release builds set it with
+// -ldflags "-X main.updatePublicKey=<public key>"; builds without one don't
+// update. Its private half signs releases and is never in the repository,
+// see the README.
+var updatePublicKey string
+
+// errNoUpdateKey is returned by update checks of builds without
+// updatePublicKey.
+var errNoUpdateKey = errors.New("updates are disabled: this build has no update key")
+
+// updateKey returns updatePublicKey decoded.
+func updateKey() (ed25519.PublicKey, error) {
+	if updatePublicKey == "" {
+		return nil, errNoUpdateKey
+	}
+	key, err := base64.StdEncoding.DecodeString(updatePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("updates are disabled: the build's update key is invalid")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// signedManifest is the file at the manifest URL: the JSON of a
+// releaseManifest in base64, and its Ed25519 signature in base64. The
+// signature covers the decoded JSON, so nothing in it can be changed on the
+// way, including the installers' checksums.
+type signedManifest struct {
+	Manifest  string `json:"manifest"`
+	Signature string `json:"signature"`
+}
+
+// releaseManifest describes the latest release.
+type releaseManifest struct {
+	Version     string    `json:"version"`
+	Notes       string    `json:"notes"`
+	PublishedAt time.Time `json:"publishedAt"`
+	// Critical is set for releases with security fixes, which the app
+	// doesn't let the user dismiss.
+	Critical bool `json:"critical"`
+	// Installers maps GOOS/GOARCH to the installer built for it.
+	Installers map[string]releaseInstaller `json:"installers"`
+}
+
+type releaseInstaller struct {
+	// URL may be relative to the manifest URL, so mirrors can serve a copy
+	// of the release as is.
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// UpdateStatus is shown in the update banner and on the settings page.
+type UpdateStatus struct {
+	CurrentVersion string `json:"currentVersion"`
+	// Available is whether Version is newer than CurrentVersion and has an
+	// installer for this platform.
+	Available   bool      `json:"available"`
+	Version     string    `json:"version,omitempty"`
+	Notes       string    `json:"notes,omitempty"`
+	PublishedAt time.Time `json:"publishedAt"`
+	Critical    bool      `json:"critical"`
+	Size        int64     `json:"size,omitempty"`
+	// CheckedAt is when the manifest was last checked. Zero if it never was.
+	CheckedAt time.Time `json:"checkedAt"`
+	// Error is why the last check failed, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// Updater checks the release manifest for new versions of the app and
+// downloads their installers.
+type Updater struct {
+	manifestURL string
+
+	mu        sync.Mutex
+	status    UpdateStatus
+	installer releaseInstaller // Of the available version
+}
+
+func NewUpdater(manifestURL string) *Updater {
+	if manifestURL == "" {
+		manifestURL = defaultUpdateManifestURL
+	}
+	return &Updater{
+		manifestURL: manifestURL,
+		status:      UpdateStatus{CurrentVersion: appVersion},
+	}
+}
+
+// Status returns the result of the last check.
+func (u *Updater) Status() UpdateStatus {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.status
+}
+
+// Check fetches the manifest and verifies its signature.
+func (u *Updater) Check() (UpdateStatus, error) {
+	manifest, err := fetchReleaseManifest(u.manifestURL)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.status = UpdateStatus{CurrentVersion: appVersion, CheckedAt: time.Now()}
+	u.installer = releaseInstaller{}
+	if err != nil {
+		u.status.Error = err.Error()
+		return u.status, err
+	}
+	newer, err := isNewerVersion(manifest.Version, appVersion)
+	if err != nil {
+		u.status.Error = err.Error()
+		return u.status, err
+	}
+	installer, ok := manifest.Installers[runtime.GOOS+"/"+runtime.GOARCH]
+	if !newer || !ok {
+		return u.status, nil
+	}
+	u.status.Available = true
+	u.status.Version = manifest.Version
+	u.status.Notes = manifest.Notes
+	u.status.PublishedAt = manifest.PublishedAt
+	u.status.Critical = manifest.Critical
+	u.status.Size = installer.Size
+	u.installer = installer
+	return u.status, nil
+}
+
+// Run checks for updates now and every updateCheckInterval until stop is
+// closed, calling onAvailable when one is found.
+func (u *Updater) Run(stop <-chan struct{}, onAvailable func(UpdateStatus)) {
+	ticker := time.NewTicker(updateCheckInterval)
+	defer ticker.Stop()
+	for {
+		status, err := u.Check()
+		if errors.Is(err, errNoUpdateKey) {
+			log.Printf("[Update] %v", err)
+			return
+		}
+		if err != nil {
+			log.Printf("[Update] %v", err)
+		} else if status.Available {
+			log.Printf("[Update] Version %s is available (critical: %v).", status.Version, status.Critical)
+			onAvailable(status)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Download downloads the installer of the available version into the config
+// directory, unless it is there already, and returns its path once its size
+// and SHA-256 match the manifest.
+func (u *Updater) Download(progress func(done, total int64)) (string, error) {
+	u.mu.Lock()
+	status, installer := u.status, u.installer
+	u.mu.Unlock()
+	if !status.Available {
+		return "", errors.New("no update available")
+	}
+	link, err := resolveInstallerURL(u.manifestURL, installer.URL)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(GetConfigDir(), "updates")
+	path := filepath.Join(dir, installerFileName(status.Version, link))
+	if verifyInstaller(path, installer) == nil {
+		return path, nil
+	}
+	removeOldUpdates()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	log.Printf("[Update] Downloading version %s from %s...", status.Version, link)
+	ctx, cancel := context.WithTimeout(context.Background(), updateDownloadTimeout)
+	defer cancel()
+	tmp, err := os.CreateTemp(dir, "download-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create download file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	err = download(ctx, link, tmp, progress)
+	tmp.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to download the update: %w", err)
+	}
+	if err := verifyInstaller(tmp.Name(), installer); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// installerFileName names the downloaded installer of version after the
+// platform, with the extension of the installer at link, e.g.
+// DrFrakeVPN-1.2.3-windows-amd64.exe.
+func installerFileName(version, link string) string {
+	ext := ""
+	if u, err := url.Parse(link); err == nil {
+		ext = path.Ext(u.Path)
+	}
+	// The extension picks the program that opens the installer, so it is
+	// kept to letters and digits.
+	if len(ext) < 2 || len(ext) > 10 || strings.ContainsFunc(ext[1:], func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9')
+	}) {
+		ext = ""
+	}
+	return fmt.Sprintf("DrFrakeVPN-%s-%s-%s%s", version, runtime.GOOS, runtime.GOARCH, ext)
+}
+
+// verifyInstaller checks the file at path against the manifest.
+func verifyInstaller(path string, installer releaseInstaller) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sum := sha256.New()
+	n, err := io.Copy(sum, f)
+	if err != nil {
+		return err
+	}
+	if installer.Size > 0 && n != installer.Size {
+		return fmt.Errorf("update download is corrupt or tampered with: size is %d, want %d", n, installer.Size)
+	}
+	if got := hex.EncodeToString(sum.Sum(nil)); !strings.EqualFold(got, installer.SHA256) {
+		return fmt.Errorf("update download is corrupt or tampered with: SHA-256 is %s, want %s", got, installer.SHA256)
+	}
+	return nil
+}
+
+// removeOldUpdates removes the installers of earlier downloads.
+func removeOldUpdates() {
+	os.RemoveAll(filepath.Join(GetConfigDir(), "updates"))
+}
+
+// fetchReleaseManifest returns the manifest at link once its signature is
+// verified with updatePublicKey.
+func fetchReleaseManifest(link string) (*releaseManifest, error) {
+	key, err := updateKey()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), updateCheckTimeout)
+	defer cancel()
+	var buf strings.Builder
+	if err := download(ctx, link, &limitedWriter{w: &buf, n: maxUpdateManifestSize}, nil); err != nil {
+		return nil, fmt.Errorf("failed to check for updates: %w", err)
+	}
+	var signed signedManifest
+	if err := json.Unmarshal([]byte(buf.String()), &signed); err != nil {
+		return nil, fmt.Errorf("invalid update manifest: %w", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(signed.Manifest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid update manifest: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signed.Signature)
+	if err != nil || !ed25519.Verify(key, data, sig) {
+		return nil, errors.New("update manifest signature is invalid")
+	}
+	var manifest releaseManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid update manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// limitedWriter fails writes past n bytes.
+type limitedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.n {
+		return 0, errors.New("response is too big")
+	}
+	l.n -= int64(len(p))
+	return l.w.Write(p)
+}
+
+// resolveInstallerURL resolves the URL of an installer relative to the
+// manifest URL. Only https is accepted, the checksums aside.
+func resolveInstallerURL(manifestURL, installerURL string) (string, error) {
+	base, err := url.Parse(manifestURL)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(installerURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid installer URL %q: %w", installerURL, err)
+	}
+	u := base.ResolveReference(ref)
+	if u.Scheme != "https" {
+		return "", fmt.Errorf("installer URL %q is not https", u)
+	}
+	return u.String(), nil
+}
+
+// isNewerVersion reports whether version is newer than current. Versions are
+// dotted numbers, optionally prefixed with "v", such as "v1.2.3".
+func isNewerVersion(version, current string) (bool, error) {
+	v, err := parseVersion(version)
+	if err != nil {
+		return false, err
+	}
+	c, err := parseVersion(current)
+	if err != nil {
+		return false, err
+	}
+	for i := 0; i < max(len(v), len(c)); i++ {
+		var a, b int
+		if i < len(v) {
+			a = v[i]
+		}
+		if i < len(c) {
+			b = c[i]
+		}
+		if a != b {
+			return a > b, nil
+		}
+	}
+	return false, nil
+}
+
+func parseVersion(version string) ([]int, error) {
+	var parts []int
+	for _, s := range strings.Split(strings.TrimPrefix(version, "v"), ".") {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version %q", version)
+		}
+		parts = append(parts, n)
+	}
+	return parts, nil
+}
+
+// GetUpdateStatus returns the result of the last update check.
+func (a *App) GetUpdateStatus() UpdateStatus {
+	return a.updater.Status()
+}
+
+// CheckForUpdate checks for a new version now.
+func (a *App) CheckForUpdate() (UpdateStatus, error) {
+	return a.updater.Check()
+}
+
+// InstallUpdate downloads and verifies the installer of the available
+// version, starts it and quits, which disconnects. The installer asks for
+// admin rights and replaces the app.
+func (a *App) InstallUpdate() error {
+	path, err := a.updater.Download(func(done, total int64) {
+		wailsruntime.EventsEmit(a.ctx, "update:download", map[string]int64{"done": done, "total": total})
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("[Update] Starting the installer %s", path)
+	if err := startInstaller(path); err != nil {
+		return err
+	}
+	wailsruntime.Quit(a.ctx)
+	return nil
+}
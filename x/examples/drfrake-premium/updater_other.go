@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// startInstaller fails, as releases only have Windows installers.
+func startInstaller(path string) error {
+	return errors.New("updates can only be installed on Windows")
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// startInstaller runs the installer at path without waiting for it. Windows
+// shows the UAC prompt its manifest asks for.
+func startInstaller(path string) error {
+	verb, _ := windows.UTF16PtrFromString("open")
+	file, _ := windows.UTF16PtrFromString(path)
+	if err := windows.ShellExecute(0, verb, file, nil, nil, windows.SW_SHOWNORMAL); err != nil {
+		return fmt.Errorf("failed to start the installer: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+//go:build xray_embed
+
+// Building with the xray_embed tag links xray-core into the app, so no
+// xray.exe is needed. It requires the module:
+//
+//	go get github.com/xtls/xray-core@v1.250306.0
+//	wails build -tags xray_embed
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+
+	xlog "github.com/xtls/xray-core/common/log"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/infra/conf/serial"
+	_ "github.com/xtls/xray-core/main/distro/all"
+	"golang.getoutline.org/sdk/transport"
+)
+
+// xrayBackend runs xray-core in-process, without inbounds. Connections are
+// handed to its outbound directly.
+type xrayBackend struct {
+	instance *core.Instance
+}
+
+// NewXrayManager creates a new manager for embedded xray-core.
+func NewXrayManager() *XrayManager {
+	return &XrayManager{}
+}
+
+// Start runs xray-core with a generated config for the given server URI.
+func (m *XrayManager) Start(uri string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.running {
+		return fmt.Errorf("xray-core is already running")
+	}
+
+	server, err := ParseXrayURI(uri)
+	if err != nil {
+		return err
+	}
+	config, err := serial.LoadJSONConfig(strings.NewReader(generateConfig(server, 0)))
+	if err != nil {
+		return fmt.Errorf("invalid xray config: %w", err)
+	}
+	instance, err := core.New(config)
+	if err != nil {
+		return fmt.Errorf("failed to create xray-core: %w", err)
+	}
+	if err := instance.Start(); err != nil {
+		instance.Close()
+		return fmt.Errorf("failed to start xray-core: %w", err)
+	}
+
+	// Replaces the handler of xray-core's log config, which writes to stdout.
+	if m.Log != nil {
+		xlog.RegisterHandler(xrayLogHandler{m.Log})
+	}
+
+	m.instance = instance
+	m.running = true
+	m.done = make(chan struct{})
+	log.Printf("[Xray] Started embedded xray-core %s", core.Version())
+	return nil
+}
+
+// Stop shuts xray-core down.
+func (m *XrayManager) Stop() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.running {
+		return nil
+	}
+
+	log.Printf("[Xray] Stopping xray-core...")
+	err := m.instance.Close()
+	m.instance = nil
+	m.running = false
+	close(m.done)
+	return err
+}
+
+// Dialers returns dialers that go through xray-core's outbound.
+func (m *XrayManager) Dialers() (transport.StreamDialer, transport.PacketListener, error) {
+	m.mu.Lock()
+	instance := m.instance
+	m.mu.Unlock()
+	if instance == nil {
+		return nil, nil, errors.New("xray-core is not running")
+	}
+
+	sd := transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		dest, err := xnet.ParseDestination("tcp:" + addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := core.Dial(ctx, instance, dest)
+		if err != nil {
+			return nil, err
+		}
+		return &xrayStreamConn{conn}, nil
+	})
+	return sd, xrayPacketListener{instance}, nil
+}
+
+// xrayStreamConn adapts xray-core connections, which can't be half-closed.
+// The connection is only closed as a whole, by Close.
+type xrayStreamConn struct {
+	net.Conn
+}
+
+func (c *xrayStreamConn) CloseRead() error  { return nil }
+func (c *xrayStreamConn) CloseWrite() error { return nil }
+
+// xrayPacketListener sends UDP through xray-core's outbound.
+type xrayPacketListener struct {
+	instance *core.Instance
+}
+
+func (l xrayPacketListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	return core.DialUDP(ctx, l.instance)
+}
+
+// xrayLogHandler writes xray-core's log messages to w, a line each.
+type xrayLogHandler struct {
+	w io.Writer
+}
+
+func (h xrayLogHandler) Handle(msg xlog.Message) {
+	fmt.Fprintln(h.w, msg.String())
+}
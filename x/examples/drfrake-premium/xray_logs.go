@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// xrayLogCapacity is how many lines of xray-core output are kept.
+	xrayLogCapacity = 500
+	// xrayLogFileMaxSize is when xray.log is rotated.
+	xrayLogFileMaxSize = 1 << 20
+	// xrayLogFileBackups is how many rotated files, xray.log.1 and on, are
+	// kept.
+	xrayLogFileBackups = 3
+)
+
+// XrayLogEntry is a line of xray-core output.
+type XrayLogEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"` // "debug", "info", "warning" or "error"
+	Message string    `json:"message"`
+}
+
+// xrayLog keeps the last xrayLogCapacity lines written to it and, if enabled,
+// appends them to xray.log in the config directory.
+type xrayLog struct {
+	mu      sync.Mutex
+	entries []XrayLogEntry // Ring buffer
+	next    int            // Where the next entry goes once full
+	partial []byte         // Last line, until its newline is written
+	toFile  bool
+	file    *rotatingFile
+}
+
+func newXrayLog() *xrayLog {
+	return &xrayLog{
+		entries: make([]XrayLogEntry, 0, xrayLogCapacity),
+		file:    &rotatingFile{path: filepath.Join(GetConfigDir(), "xray.log"), maxSize: xrayLogFileMaxSize, backups: xrayLogFileBackups},
+	}
+}
+
+// Write adds complete lines of p to the log.
+func (l *xrayLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(l.partial[:i]), "\r")
+		l.partial = l.partial[i+1:]
+		if line != "" {
+			l.add(line)
+		}
+	}
+	return len(p), nil
+}
+
+func (l *xrayLog) add(line string) {
+	entry := parseXrayLogLine(line)
+	if len(l.entries) < xrayLogCapacity {
+		l.entries = append(l.entries, entry)
+	} else {
+		l.entries[l.next] = entry
+		l.next = (l.next + 1) % xrayLogCapacity
+	}
+	if l.toFile {
+		l.writeFile(line)
+	}
+}
+
+// Entries returns the kept lines, oldest first.
+func (l *xrayLog) Entries() []XrayLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := make([]XrayLogEntry, 0, len(l.entries))
+	entries = append(entries, l.entries[l.next:]...)
+	return append(entries, l.entries[:l.next]...)
+}
+
+// SetFileLogging turns writing to xray.log on or off.
+func (l *xrayLog) SetFileLogging(enabled bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.toFile = enabled
+	if !enabled {
+		l.file.Close()
+	}
+}
+
+// writeFile appends line to xray.log. Errors turn file logging off, as
+// there is nowhere to report them.
+func (l *xrayLog) writeFile(line string) {
+	if _, err := l.file.Write([]byte(line + "\n")); err != nil {
+		l.toFile = false
+	}
+}
+
+// parseXrayLogLine reads the level out of an xray-core log line, which looks
+// like "2025/03/06 12:00:00.000000 [Warning] message". Lines without a
+// level, such as the version banner, are info.
+func parseXrayLogLine(line string) XrayLogEntry {
+	entry := XrayLogEntry{Time: time.Now(), Level: "info", Message: line}
+	start := strings.IndexByte(line, '[')
+	end := strings.IndexByte(line, ']')
+	if start < 0 || end < start {
+		return entry
+	}
+	switch level := strings.ToLower(line[start+1 : end]); level {
+	case "debug", "info", "warning", "error":
+		entry.Level = level
+		entry.Message = strings.TrimSpace(line[end+1:])
+	}
+	return entry
+}
+
+// GetXrayLogs returns the last lines xray-core logged, oldest first.
+func (a *App) GetXrayLogs() []XrayLogEntry {
+	return a.xrayLog.Entries()
+}
@@ -3,133 +3,72 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"net/url"
-	"os"
-	"os/exec"
-	"path/filepath"
-	"runtime"
 	"strings"
 	"sync"
-	"time"
 )
 
-// XrayManager manages the xray-core subprocess for VLESS connections.
+// XrayManager runs xray-core for the protocols the Outline SDK lacks (see
+// IsXrayURI). By default it runs as a
+// subprocess reached through a local SOCKS5 proxy; built with the xray_embed
+// tag, it runs in-process and is dialed directly.
 type XrayManager struct {
-	mu         sync.Mutex
-	process    *exec.Cmd
-	configPath string
-	socksPort  int
-	running    bool
+	mu      sync.Mutex
+	running bool
+	done    chan struct{} // Closed when xray-core stops
+
+	// Mirror is where xray-core is downloaded from when it isn't installed,
+	// the official releases if empty. Unused when xray-core is embedded.
+	Mirror string
+	// OnDownloadProgress, if set, is called while xray-core downloads.
+	OnDownloadProgress func(done, total int64)
+	// Log, if set, receives xray-core's log lines.
+	Log io.Writer
+
+	xrayBackend
 }
 
-// VLESSParams holds VLESS connection parameters parsed from a vless:// URI.
-type VLESSParams struct {
-	UUID        string
-	Host        string
-	Port        string
-	Security    string
+// XrayServer is a server reached through xray-core, parsed from its URI by
+// ParseXrayURI.
+type XrayServer struct {
+	Protocol string // xray-core outbound protocol
+	Host     string
+	Port     int
+	// Settings and Stream are the outbound's settings and streamSettings.
+	Settings map[string]interface{}
+	Stream   map[string]interface{}
+}
+
+// StreamParams are the transport and security settings shared by the
+// protocols xray-core runs, from URI query parameters.
+type StreamParams struct {
+	Network     string // "tcp", "ws" or "grpc"
+	Security    string // "none", "tls" or "reality"
 	SNI         string
 	Fingerprint string
 	PublicKey   string
 	ShortID     string
 	SpiderX     string
-	Flow        string
-	Network     string
+	Path        string // WebSocket path or gRPC service name
+	HostHeader  string // WebSocket Host header
 }
 
-// NewXrayManager creates a new manager for xray-core subprocess.
-func NewXrayManager() *XrayManager {
-	return &XrayManager{
-		socksPort: 10808,
-	}
-}
-
-// Start launches xray-core with a generated config for the given VLESS URI.
-func (m *XrayManager) Start(vlessURI string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	if m.running {
-		return fmt.Errorf("xray-core is already running")
-	}
-
-	// Parse VLESS URI
-	params, err := ParseVLESSURI(vlessURI)
-	if err != nil {
-		return fmt.Errorf("failed to parse VLESS URI: %w", err)
-	}
-
-	// Generate xray config
-	config := m.generateConfig(params)
-
-	// Write config to temp file
-	configDir, err := os.UserConfigDir()
-	if err != nil {
-		configDir = os.TempDir()
-	}
-	configDir = filepath.Join(configDir, "DrFrakeVPN")
-	os.MkdirAll(configDir, 0755)
-
-	m.configPath = filepath.Join(configDir, "xray_config.json")
-	if err := os.WriteFile(m.configPath, []byte(config), 0600); err != nil {
-		return fmt.Errorf("failed to write xray config: %w", err)
-	}
-
-	// Find xray binary
-	xrayBin := m.findXrayBinary()
-	if xrayBin == "" {
-		return fmt.Errorf("xray-core binary not found. Please place xray.exe in the application directory")
-	}
-
-	// Start xray-core
-	m.process = exec.Command(xrayBin, "run", "-c", m.configPath)
-	m.process.Stdout = os.Stdout
-	m.process.Stderr = os.Stderr
-
-	if err := m.process.Start(); err != nil {
-		return fmt.Errorf("failed to start xray-core: %w", err)
-	}
-
-	m.running = true
-	log.Printf("[Xray] Started xray-core (PID %d) with SOCKS5 on 127.0.0.1:%d", m.process.Process.Pid, m.socksPort)
-
-	// Wait a moment for xray to start listening
-	time.Sleep(500 * time.Millisecond)
-
-	return nil
+// VLESSParams holds VLESS connection parameters parsed from a vless:// URI.
+type VLESSParams struct {
+	UUID string
+	Host string
+	Port string
+	Flow string
+	StreamParams
 }
 
-// Stop terminates the xray-core subprocess.
-func (m *XrayManager) Stop() error {
+// Done returns a channel that is closed when the xray-core started last
+// stops, whether it was stopped or died.
+func (m *XrayManager) Done() <-chan struct{} {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-
-	if !m.running || m.process == nil {
-		return nil
-	}
-
-	log.Printf("[Xray] Stopping xray-core...")
-
-	if m.process.Process != nil {
-		m.process.Process.Kill()
-		m.process.Wait()
-	}
-
-	m.running = false
-	m.process = nil
-
-	// Clean up config file
-	if m.configPath != "" {
-		os.Remove(m.configPath)
-	}
-
-	return nil
-}
-
-// GetSOCKS5Config returns the local SOCKS5 address for Outline SDK to use.
-func (m *XrayManager) GetSOCKS5Config() string {
-	return fmt.Sprintf("socks5://127.0.0.1:%d", m.socksPort)
+	return m.done
 }
 
 // IsRunning returns whether xray-core is currently running.
@@ -139,43 +78,18 @@ func (m *XrayManager) IsRunning() bool {
 	return m.running
 }
 
-// findXrayBinary searches for xray-core executable in common locations.
-func (m *XrayManager) findXrayBinary() string {
-	binaryName := "xray"
-	if runtime.GOOS == "windows" {
-		binaryName = "xray.exe"
-	}
-
-	// Search locations
-	locations := []string{
-		// Same directory as the application
-		filepath.Join(".", binaryName),
-		// In xray subdirectory
-		filepath.Join(".", "xray", binaryName),
-		// User config directory
-		func() string {
-			dir, _ := os.UserConfigDir()
-			return filepath.Join(dir, "DrFrakeVPN", binaryName)
-		}(),
-	}
-
-	// Also check in PATH
-	if path, err := exec.LookPath(binaryName); err == nil {
-		return path
+// generateConfig creates an xray-core JSON config for a connection to server.
+// With socksPort 0 it has no inbound, for embedded use.
+func generateConfig(server *XrayServer, socksPort int) string {
+	outbound := map[string]interface{}{
+		"tag":      "proxy",
+		"protocol": server.Protocol,
+		"settings": server.Settings,
 	}
-
-	for _, loc := range locations {
-		if _, err := os.Stat(loc); err == nil {
-			absPath, _ := filepath.Abs(loc)
-			return absPath
-		}
+	if server.Stream != nil {
+		outbound["streamSettings"] = server.Stream
 	}
 
-	return ""
-}
-
-// generateConfig creates an xray-core JSON config for a VLESS+Reality connection.
-func (m *XrayManager) generateConfig(params *VLESSParams) string {
 	config := map[string]interface{}{
 		"log": map[string]interface{}{
 			"loglevel": "warning",
@@ -183,7 +97,7 @@ func (m *XrayManager) generateConfig(params *VLESSParams) string {
 		"inbounds": []map[string]interface{}{
 			{
 				"tag":      "socks-in",
-				"port":     m.socksPort,
+				"port":     socksPort,
 				"listen":   "127.0.0.1",
 				"protocol": "socks",
 				"settings": map[string]interface{}{
@@ -197,26 +111,7 @@ func (m *XrayManager) generateConfig(params *VLESSParams) string {
 			},
 		},
 		"outbounds": []map[string]interface{}{
-			{
-				"tag":      "vless-out",
-				"protocol": "vless",
-				"settings": map[string]interface{}{
-					"vnext": []map[string]interface{}{
-						{
-							"address": params.Host,
-							"port":    params.Port,
-							"users": []map[string]interface{}{
-								{
-									"id":         params.UUID,
-									"flow":       params.Flow,
-									"encryption": "none",
-								},
-							},
-						},
-					},
-				},
-				"streamSettings": m.buildStreamSettings(params),
-			},
+			outbound,
 			{
 				"tag":      "direct",
 				"protocol": "freedom",
@@ -224,12 +119,16 @@ func (m *XrayManager) generateConfig(params *VLESSParams) string {
 		},
 	}
 
+	if socksPort == 0 {
+		delete(config, "inbounds")
+	}
+
 	data, _ := json.MarshalIndent(config, "", "  ")
 	return string(data)
 }
 
 // buildStreamSettings creates the streamSettings for xray config.
-func (m *XrayManager) buildStreamSettings(params *VLESSParams) map[string]interface{} {
+func buildStreamSettings(params *StreamParams) map[string]interface{} {
 	network := params.Network
 	if network == "" {
 		network = "tcp"
@@ -255,6 +154,17 @@ func (m *XrayManager) buildStreamSettings(params *VLESSParams) map[string]interf
 		}
 	}
 
+	switch network {
+	case "ws":
+		ws := map[string]interface{}{"path": params.Path}
+		if params.HostHeader != "" {
+			ws["headers"] = map[string]string{"Host": params.HostHeader}
+		}
+		ss["wsSettings"] = ws
+	case "grpc":
+		ss["grpcSettings"] = map[string]interface{}{"serviceName": params.Path}
+	}
+
 	return ss
 }
 
@@ -277,14 +187,8 @@ func ParseVLESSURI(uri string) (*VLESSParams, error) {
 	}
 
 	q := u.Query()
-	params.Security = q.Get("security")
-	params.SNI = q.Get("sni")
-	params.Fingerprint = q.Get("fp")
-	params.PublicKey = q.Get("pbk")
-	params.ShortID = q.Get("sid")
-	params.SpiderX = q.Get("spx")
+	params.StreamParams = parseStreamParams(q)
 	params.Flow = q.Get("flow")
-	params.Network = q.Get("type")
 
 	if params.Flow == "" {
 		params.Flow = "xtls-rprx-vision"
@@ -298,3 +202,23 @@ func ParseVLESSURI(uri string) (*VLESSParams, error) {
 
 	return params, nil
 }
+
+// parseStreamParams reads the stream settings from the query of a share link,
+// in the format xray-core clients share.
+func parseStreamParams(q url.Values) StreamParams {
+	params := StreamParams{
+		Network:     q.Get("type"),
+		Security:    q.Get("security"),
+		SNI:         q.Get("sni"),
+		Fingerprint: q.Get("fp"),
+		PublicKey:   q.Get("pbk"),
+		ShortID:     q.Get("sid"),
+		SpiderX:     q.Get("spx"),
+		Path:        q.Get("path"),
+		HostHeader:  q.Get("host"),
+	}
+	if params.Network == "grpc" {
+		params.Path = q.Get("serviceName")
+	}
+	return params
+}
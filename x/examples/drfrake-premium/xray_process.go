@@ -0,0 +1,313 @@
+//go:build !xray_embed
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.getoutline.org/sdk/transport"
+	"golang.getoutline.org/sdk/x/configurl"
+)
+
+const (
+	// xrayStartTimeout is how long xray-core has to start accepting SOCKS5
+	// connections.
+	xrayStartTimeout = 5 * time.Second
+	// xrayStartAttempts is how many ports are tried when the one picked is
+	// taken before xray-core binds it.
+	xrayStartAttempts = 3
+	// xrayOutputTail is how much of xray-core's output XrayStartError keeps.
+	xrayOutputTail = 4096
+)
+
+// xrayBackend runs xray-core as a subprocess with a SOCKS5 inbound.
+type xrayBackend struct {
+	process   *exec.Cmd
+	socksPort int // Picked when starting
+}
+
+// XrayStartError is returned by Start when xray-core exits or doesn't accept
+// connections during startup.
+type XrayStartError struct {
+	Port int
+	Err  error
+	// Output is the tail of what xray-core wrote. It logs to stdout, so both
+	// stdout and stderr are kept.
+	Output string
+}
+
+func (e *XrayStartError) Error() string {
+	if e.Output == "" {
+		return fmt.Sprintf("xray-core failed to start on port %d: %v", e.Port, e.Err)
+	}
+	return fmt.Sprintf("xray-core failed to start on port %d: %v\n%s", e.Port, e.Err, e.Output)
+}
+
+func (e *XrayStartError) Unwrap() error {
+	return e.Err
+}
+
+// portInUse tells whether xray-core failed because its port was taken.
+func (e *XrayStartError) portInUse() bool {
+	out := strings.ToLower(e.Output)
+	return strings.Contains(out, "address already in use") ||
+		strings.Contains(out, "only one usage of each socket address")
+}
+
+// NewXrayManager creates a new manager for xray-core subprocess.
+func NewXrayManager() *XrayManager {
+	return &XrayManager{}
+}
+
+// Start launches xray-core with a generated config for the given server URI,
+// on a free port, and returns once it accepts SOCKS5 connections.
+func (m *XrayManager) Start(uri string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.running {
+		return fmt.Errorf("xray-core is already running")
+	}
+
+	// Parse server URI
+	server, err := ParseXrayURI(uri)
+	if err != nil {
+		return err
+	}
+
+	// Find xray binary, downloading it if there is none
+	xrayBin := m.findXrayBinary()
+	if xrayBin == "" {
+		if xrayBin, err = installXray(m.Mirror, m.OnDownloadProgress); err != nil {
+			return fmt.Errorf("xray-core binary not found and couldn't be downloaded: %w", err)
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := m.startProcess(xrayBin, server)
+		if err == nil {
+			return nil
+		}
+		var startErr *XrayStartError
+		if attempt >= xrayStartAttempts || !errors.As(err, &startErr) || !startErr.portInUse() {
+			return err
+		}
+		log.Printf("[Xray] Port %d was taken, retrying on another port...", startErr.Port)
+	}
+}
+
+// startProcess runs xrayBin with its SOCKS5 inbound on a free port and waits
+// until it accepts connections. m.mu must be held.
+func (m *XrayManager) startProcess(xrayBin string, server *XrayServer) error {
+	port, err := freeLocalPort()
+	if err != nil {
+		return fmt.Errorf("failed to find a free port: %w", err)
+	}
+
+	// Generate xray config
+	config := generateConfig(server, port)
+
+	// The config has the server's keys, so it is passed on stdin rather than
+	// written to disk. Earlier versions left it in the config directory.
+	os.Remove(filepath.Join(GetConfigDir(), "xray_config.json"))
+
+	// Start xray-core
+	output := &tailBuffer{max: xrayOutputTail}
+	cmd := exec.Command(xrayBin, "run", "-config", "stdin:")
+	cmd.Stdin = strings.NewReader(config)
+	stdout, stderr := []io.Writer{os.Stdout, output}, []io.Writer{os.Stderr, output}
+	if m.Log != nil {
+		stdout, stderr = append(stdout, m.Log), append(stderr, m.Log)
+	}
+	cmd.Stdout = io.MultiWriter(stdout...)
+	cmd.Stderr = io.MultiWriter(stderr...)
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start xray-core: %w", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		err := cmd.Wait()
+		log.Printf("[Xray] xray-core exited: %v", err)
+		close(done)
+	}()
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	if err := waitForSOCKS5(addr, done); err != nil {
+		cmd.Process.Kill()
+		<-done
+		return &XrayStartError{Port: port, Err: err, Output: output.String()}
+	}
+
+	m.process = cmd
+	m.socksPort = port
+	m.running = true
+	m.done = done
+	log.Printf("[Xray] Started xray-core (PID %d) with SOCKS5 on %s", cmd.Process.Pid, addr)
+	return nil
+}
+
+// freeLocalPort returns a TCP port on the loopback interface that nothing
+// listens on right now.
+func freeLocalPort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+// waitForSOCKS5 waits until a SOCKS5 server accepts connections on addr,
+// giving up after xrayStartTimeout or once exited is closed. A greeting is
+// exchanged so that something else holding the port doesn't count.
+func waitForSOCKS5(addr string, exited <-chan struct{}) error {
+	deadline := time.Now().Add(xrayStartTimeout)
+	for {
+		err := socks5Greet(addr)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("SOCKS5 inbound isn't accepting connections after %v: %w", xrayStartTimeout, err)
+		}
+		select {
+		case <-exited:
+			return errors.New("xray-core exited")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// socks5Greet offers a SOCKS5 server on addr no authentication and checks
+// that it accepts.
+func socks5Greet(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write([]byte{5, 1, 0}); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply != [2]byte{5, 0} {
+		return fmt.Errorf("not a SOCKS5 server: replied %x", reply)
+	}
+	return nil
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+	max int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.max; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
+
+// Stop terminates the xray-core subprocess.
+func (m *XrayManager) Stop() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.running || m.process == nil {
+		return nil
+	}
+
+	log.Printf("[Xray] Stopping xray-core...")
+
+	if m.process.Process != nil {
+		m.process.Process.Kill()
+		<-m.done
+	}
+
+	m.running = false
+	m.process = nil
+
+	return nil
+}
+
+// Dialers returns dialers that go through xray-core's SOCKS5 inbound.
+func (m *XrayManager) Dialers() (transport.StreamDialer, transport.PacketListener, error) {
+	m.mu.Lock()
+	socksConfig := fmt.Sprintf("socks5://127.0.0.1:%d", m.socksPort)
+	m.mu.Unlock()
+	log.Printf("[VPN] Using SOCKS5 bridge: %s", socksConfig)
+	providers := configurl.NewDefaultProviders()
+	sd, err := providers.NewStreamDialer(context.Background(), socksConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	pl, err := providers.NewPacketListener(context.Background(), socksConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sd, pl, nil
+}
+
+// findXrayBinary searches for xray-core executable in common locations. A
+// binary placed there by the user takes precedence over the managed one.
+func (m *XrayManager) findXrayBinary() string {
+	binaryName := xrayBinaryName()
+
+	// Search locations
+	locations := []string{
+		// Same directory as the application
+		filepath.Join(".", binaryName),
+		// In xray subdirectory
+		filepath.Join(".", "xray", binaryName),
+		// User config directory
+		func() string {
+			dir, _ := os.UserConfigDir()
+			return filepath.Join(dir, "DrFrakeVPN", binaryName)
+		}(),
+		// Downloaded by installXray
+		filepath.Join(xrayInstallDir(xrayVersion), binaryName),
+	}
+
+	// Also check in PATH
+	if path, err := exec.LookPath(binaryName); err == nil {
+		return path
+	}
+
+	for _, loc := range locations {
+		if _, err := os.Stat(loc); err == nil {
+			absPath, _ := filepath.Abs(loc)
+			return absPath
+		}
+	}
+
+	return ""
+}
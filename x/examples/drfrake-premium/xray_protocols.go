@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"drfrake-core"
+)
+
+// IsXrayURI tells whether a server config needs xray-core: VLESS, VMess,
+// Trojan, and Shadowsocks with the 2022 ciphers, which the Outline SDK
+// doesn't have. Other configs go to configurl.
+func IsXrayURI(config string) bool {
+	switch {
+	case strings.HasPrefix(config, "vless://"),
+		strings.HasPrefix(config, "vmess://"),
+		strings.HasPrefix(config, "trojan://"):
+		return true
+	case strings.HasPrefix(config, "ss://"):
+		method, _, err := parseShadowsocksUserInfo(config)
+		return err == nil && strings.HasPrefix(method, "2022-")
+	}
+	return false
+}
+
+// ParseXrayURI parses the URI of a server reached through xray-core.
+func ParseXrayURI(uri string) (*XrayServer, error) {
+	switch {
+	case strings.HasPrefix(uri, "vless://"):
+		params, err := ParseVLESSURI(uri)
+		if err != nil {
+			return nil, err
+		}
+		return params.server()
+	case strings.HasPrefix(uri, "vmess://"):
+		return parseVMessURI(uri)
+	case strings.HasPrefix(uri, "trojan://"):
+		return parseTrojanURI(uri)
+	case strings.HasPrefix(uri, "ss://"):
+		return parseShadowsocks2022URI(uri)
+	}
+	return nil, fmt.Errorf("unsupported server URI: %s", uri)
+}
+
+func (p *VLESSParams) server() (*XrayServer, error) {
+	port, err := parsePort(p.Port)
+	if err != nil {
+		return nil, err
+	}
+	return &XrayServer{
+		Protocol: "vless",
+		Host:     p.Host,
+		Port:     port,
+		Settings: map[string]interface{}{
+			"vnext": []map[string]interface{}{
+				{
+					"address": p.Host,
+					"port":    port,
+					"users": []map[string]interface{}{
+						{
+							"id":         p.UUID,
+							"flow":       p.Flow,
+							"encryption": "none",
+						},
+					},
+				},
+			},
+		},
+		Stream: buildStreamSettings(&p.StreamParams),
+	}, nil
+}
+
+// vmessLink is the JSON in a vmess:// URI, as v2rayN and 3X-UI share it.
+// Numbers may be strings.
+type vmessLink struct {
+	Add      string      `json:"add"`
+	Port     interface{} `json:"port"`
+	ID       string      `json:"id"`
+	AlterID  interface{} `json:"aid"`
+	Security string      `json:"scy"`
+	Net      string      `json:"net"`
+	Host     string      `json:"host"`
+	Path     string      `json:"path"`
+	TLS      string      `json:"tls"`
+	SNI      string      `json:"sni"`
+	FP       string      `json:"fp"`
+}
+
+// parseVMessURI parses vmess://BASE64(JSON).
+func parseVMessURI(uri string) (*XrayServer, error) {
+	data, err := core.DecodeBase64(strings.TrimPrefix(uri, "vmess://"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid VMess URI: %w", err)
+	}
+	var link vmessLink
+	if err := json.Unmarshal(data, &link); err != nil {
+		return nil, fmt.Errorf("invalid VMess URI: %w", err)
+	}
+	if link.Add == "" || link.ID == "" {
+		return nil, fmt.Errorf("invalid VMess URI: missing address or id")
+	}
+	port, err := parsePort(fmt.Sprint(link.Port))
+	if err != nil {
+		return nil, err
+	}
+	alterID := 0
+	if s := strings.TrimSpace(fmt.Sprint(link.AlterID)); link.AlterID != nil && s != "" {
+		if alterID, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("invalid VMess alterId %q", s)
+		}
+	}
+	security := link.Security
+	if security == "" {
+		security = "auto"
+	}
+
+	stream := StreamParams{
+		Network:     link.Net,
+		Security:    "none",
+		SNI:         link.SNI,
+		Fingerprint: link.FP,
+		Path:        link.Path,
+		HostHeader:  link.Host,
+	}
+	if link.TLS == "tls" {
+		stream.Security = "tls"
+		if stream.SNI == "" {
+			stream.SNI = link.Host
+		}
+	}
+	return &XrayServer{
+		Protocol: "vmess",
+		Host:     link.Add,
+		Port:     port,
+		Settings: map[string]interface{}{
+			"vnext": []map[string]interface{}{
+				{
+					"address": link.Add,
+					"port":    port,
+					"users": []map[string]interface{}{
+						{
+							"id":       link.ID,
+							"alterId":  alterID,
+							"security": security,
+						},
+					},
+				},
+			},
+		},
+		Stream: buildStreamSettings(&stream),
+	}, nil
+}
+
+// parseTrojanURI parses trojan://PASSWORD@HOST:PORT?params#name. TLS is the
+// default security.
+func parseTrojanURI(uri string) (*XrayServer, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse URI: %w", err)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("invalid Trojan URI: missing password")
+	}
+	port, err := parsePort(u.Port())
+	if err != nil {
+		return nil, err
+	}
+	stream := parseStreamParams(u.Query())
+	if stream.Security == "" {
+		stream.Security = "tls"
+	}
+	return &XrayServer{
+		Protocol: "trojan",
+		Host:     u.Hostname(),
+		Port:     port,
+		Settings: map[string]interface{}{
+			"servers": []map[string]interface{}{
+				{
+					"address":  u.Hostname(),
+					"port":     port,
+					"password": u.User.Username(),
+				},
+			},
+		},
+		Stream: buildStreamSettings(&stream),
+	}, nil
+}
+
+// parseShadowsocks2022URI parses an ss:// URI with a 2022 cipher.
+func parseShadowsocks2022URI(uri string) (*XrayServer, error) {
+	method, password, err := parseShadowsocksUserInfo(uri)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(method, "2022-") {
+		return nil, fmt.Errorf("cipher %s is handled by the Outline SDK, not xray-core", method)
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse URI: %w", err)
+	}
+	port, err := parsePort(u.Port())
+	if err != nil {
+		return nil, err
+	}
+	return &XrayServer{
+		Protocol: "shadowsocks",
+		Host:     u.Hostname(),
+		Port:     port,
+		Settings: map[string]interface{}{
+			"servers": []map[string]interface{}{
+				{
+					"address":  u.Hostname(),
+					"port":     port,
+					"method":   method,
+					"password": password,
+				},
+			},
+		},
+	}, nil
+}
+
+// parseShadowsocksUserInfo returns the cipher and password of an ss:// URI in
+// the SIP002 format. The user info is either base64 of "method:password" or,
+// as 2022 keys are usually shared, "method:password" percent-encoded.
+func parseShadowsocksUserInfo(uri string) (method, password string, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse URI: %w", err)
+	}
+	if u.User == nil {
+		return "", "", fmt.Errorf("invalid Shadowsocks URI: missing user info")
+	}
+	if password, ok := u.User.Password(); ok {
+		return u.User.Username(), password, nil
+	}
+	data, err := core.DecodeBase64(u.User.Username())
+	if err != nil {
+		return "", "", fmt.Errorf("invalid Shadowsocks user info: %w", err)
+	}
+	method, password, ok := strings.Cut(string(data), ":")
+	if !ok {
+		return "", "", fmt.Errorf("invalid Shadowsocks user info: missing password")
+	}
+	return method, password, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
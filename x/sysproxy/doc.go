@@ -22,12 +22,16 @@
 //	| HTTP       | Yes     | Yes    | Yes    |
 //	| HTTPS      | Yes     | Yes    | Yes    |
 //	| SOCKS      | Yes(v4) | Yes(v5)| Yes(v5)|
+//	| PAC URL    | Yes     | Yes    | Yes    |
 //	+------------+---------+--------+--------+
 //
 // [SetWebProxy] implementation in this package sets up both system HTTP and HTTPS proxy settings when they are distinguished by the platform.
 //
 // [SetSOCKSProxy] method configures SOCKS proxy settings on the system.
 //
+// [SetAutoConfigURL] points the system to a proxy auto-config (PAC) file instead, which lets the PAC file pick the
+// proxy, or none, for each URL.
+//
 // Support for FTP Proxy setting was not included due to lack of adoption and usage.
 //
 // Username and password authentication is not supported because the intended usage it to connect to a
@@ -101,6 +101,29 @@ func DisableSOCKSProxy() error {
 	return nil
 }
 
+// SetAutoConfigURL points the system to a proxy auto-config (PAC) file.
+// https://keith.github.io/xcode-man-pages/networksetup.8.html#setautoproxyurl
+func SetAutoConfigURL(url string) error {
+	// Get the active network interface
+	activeInterface, err := getActiveNetworkInterface()
+	if err != nil {
+		return err
+	}
+
+	// Setting the URL also turns it on
+	return exec.Command("networksetup", "-setautoproxyurl", activeInterface, url).Run()
+}
+
+func DisableAutoConfigURL() error {
+	// Get the active network interface
+	activeInterface, err := getActiveNetworkInterface()
+	if err != nil {
+		return err
+	}
+
+	return exec.Command("networksetup", "-setautoproxystate", activeInterface, "off").Run()
+}
+
 // getActiveNetworkInterface finds the active network interface using shell commands.
 // https://keith.github.io/xcode-man-pages/networksetup.8.html#listnetworkserviceorder
 func getActiveNetworkInterface() (string, error) {
@@ -287,3 +310,32 @@ func getSOCKSProxy() (host string, port string, enabled bool, err error) {
 
 	return socksSettings.host, socksSettings.port, socksSettings.enabled, nil
 }
+
+// getAutoConfigURL parses the output of networksetup -getautoproxyurl, e.g.:
+//
+//	URL: http://127.0.0.1:8080/proxy.pac
+//	Enabled: Yes
+func getAutoConfigURL() (url string, enabled bool, err error) {
+	activeInterface, err := getActiveNetworkInterface()
+	if err != nil {
+		return "", false, err
+	}
+
+	output, err := exec.Command("networksetup", "-getautoproxyurl", activeInterface).Output()
+	if err != nil {
+		return "", false, err
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		trimmedLine := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmedLine, "URL:"):
+			url = strings.TrimSpace(strings.TrimPrefix(trimmedLine, "URL:"))
+		case strings.HasPrefix(trimmedLine, "Enabled:"):
+			enabled = strings.Contains(trimmedLine, "Yes")
+		}
+	}
+	if url == "(null)" {
+		url = ""
+	}
+	return url, enabled, nil
+}
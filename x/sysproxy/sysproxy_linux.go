@@ -64,6 +64,18 @@ func DisableSOCKSProxy() error {
 	return gnomeSettingsSetString("org.gnome.system.proxy", "mode", "none")
 }
 
+// SetAutoConfigURL points the system to a proxy auto-config (PAC) file.
+func SetAutoConfigURL(url string) error {
+	if err := gnomeSettingsSetString("org.gnome.system.proxy", "autoconfig-url", url); err != nil {
+		return err
+	}
+	return gnomeSettingsSetString("org.gnome.system.proxy", "mode", "auto")
+}
+
+func DisableAutoConfigURL() error {
+	return gnomeSettingsSetString("org.gnome.system.proxy", "mode", "none")
+}
+
 func setManualMode() error {
 	return gnomeSettingsSetString("org.gnome.system.proxy", "mode", "manual")
 }
@@ -134,6 +146,20 @@ func getWebProxy() (host string, port string, enabled bool, err error) {
 	return httpHost, httpPort, mode != "none", nil
 }
 
+func getAutoConfigURL() (url string, enabled bool, err error) {
+	url, err = gnomeSettingsGetString("org.gnome.system.proxy", "autoconfig-url")
+	if err != nil {
+		return "", false, err
+	}
+
+	mode, err := gnomeSettingsGetString("org.gnome.system.proxy", "mode")
+	if err != nil {
+		return "", false, err
+	}
+
+	return url, mode == "auto", nil
+}
+
 func getSOCKSProxy() (host string, port string, enabled bool, err error) {
 
 	socksHost, err := gnomeSettingsGetString("org.gnome.system.proxy.socks", "host")
@@ -37,3 +37,13 @@ func SetSOCKSProxy(ip string, port string) error {
 func DisableSOCKSProxy() error {
 	return errors.New("unsupported platform")
 }
+
+// SetAutoConfigURL does nothing on unsupported platforms.
+func SetAutoConfigURL(url string) error {
+	return errors.New("unsupported platform")
+}
+
+// DisableAutoConfigURL does nothing on unsupported platforms.
+func DisableAutoConfigURL() error {
+	return errors.New("unsupported platform")
+}
@@ -100,6 +100,25 @@ func TestClearSocksProxy(t *testing.T) {
 	require.Equal(t, false, enabled)
 }
 
+func TestSetAutoConfigURL(t *testing.T) {
+	url := "http://127.0.0.1:" + strconv.Itoa(rand.Intn(65536)) + "/proxy.pac"
+
+	err := SetAutoConfigURL(url)
+	require.NoError(t, err)
+
+	u, e, err := getAutoConfigURL()
+	require.NoError(t, err)
+	require.Equal(t, url, u)
+	require.Equal(t, e, true)
+
+	err = DisableAutoConfigURL()
+	require.NoError(t, err)
+
+	_, e, err = getAutoConfigURL()
+	require.NoError(t, err)
+	require.Equal(t, false, e)
+}
+
 func generateRandomDomain() string {
 
 	// Define the characters allowed in the domain name
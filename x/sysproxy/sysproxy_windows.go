@@ -117,6 +117,38 @@ func disableProxy() error {
 	return notifyWinInetProxySettingsChanged()
 }
 
+// SetAutoConfigURL points the system to a proxy auto-config (PAC) file.
+func SetAutoConfigURL(url string) error {
+	key, err := registry.OpenKey(registry.CURRENT_USER, `Software\Microsoft\Windows\CurrentVersion\Internet Settings`, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+
+	if err = key.SetStringValue("AutoConfigURL", url); err != nil {
+		return err
+	}
+
+	// Refresh the settings
+	return notifyWinInetProxySettingsChanged()
+}
+
+func DisableAutoConfigURL() error {
+	key, err := registry.OpenKey(registry.CURRENT_USER, `Software\Microsoft\Windows\CurrentVersion\Internet Settings`, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+
+	// There is no switch for the auto-config URL, only the value.
+	if err = key.DeleteValue("AutoConfigURL"); err != nil && err != registry.ErrNotExist {
+		return err
+	}
+
+	// Refresh the settings
+	return notifyWinInetProxySettingsChanged()
+}
+
 // https://learn.microsoft.com/en-us/windows/win32/api/wininet/nf-wininet-internetsetoptionw
 // internetSetOption sets an Internet option.
 func internetSetOption(hInternet uintptr, dwOption int, lpBuffer uintptr, dwBufferLength uint32) error {
@@ -169,6 +201,23 @@ func getWebProxy() (host string, port string, enabled bool, err error) {
 	return host, port, proxyEnable == 1, nil
 }
 
+func getAutoConfigURL() (url string, enabled bool, err error) {
+	key, err := registry.OpenKey(registry.CURRENT_USER, `Software\Microsoft\Windows\CurrentVersion\Internet Settings`, registry.QUERY_VALUE)
+	if err != nil {
+		return "", false, err
+	}
+	defer key.Close()
+
+	url, _, err = key.GetStringValue("AutoConfigURL")
+	if err == registry.ErrNotExist {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return url, true, nil
+}
+
 func getSOCKSProxy() (host string, port string, enabled bool, err error) {
 	key, err := registry.OpenKey(registry.CURRENT_USER, `Software\Microsoft\Windows\CurrentVersion\Internet Settings`, registry.QUERY_VALUE)
 	if err != nil {